@@ -0,0 +1,150 @@
+package requeue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// BatchAcks acknowledges the messages producers send with a
+// protocol.CorrelationIDHeader in a protocol.BatchAck per reply subject,
+// rather than each on its own, cutting down the acks sent at high ingest
+// rates. A producer sends a batch of messages with the same reply subject,
+// each with its own correlation id, and waits for the ids to be acknowledged.
+// The acks of a reply subject are gathered for up to window, or until there
+// are max of them, before they're sent. Messages without the header are
+// still acknowledged on their own.
+func BatchAcks(window time.Duration, max int) Option {
+	return func(o *Options) error {
+		if window <= 0 {
+			return fmt.Errorf("batch ack window must be positive: %s", window)
+		}
+		if max <= 0 {
+			return fmt.Errorf("batch ack max must be positive: %d", max)
+		}
+		o.batchAckWindow = window
+		o.batchAckMax = max
+		return nil
+	}
+}
+
+// pendingAck is the ack of a message waiting to be sent in a batch.
+type pendingAck struct {
+	id        string
+	qk        queue.QueueKey
+	msg       *nats.Msg
+	persisted bool
+}
+
+// ackBatcher gathers the acks of each reply subject into batches.
+type ackBatcher struct {
+	window time.Duration
+	max    int
+	send   func(reply string, acks []pendingAck)
+
+	mu      sync.Mutex
+	batches map[string]*ackBatch
+	closed  bool
+}
+
+// ackBatch is the acks waiting to be sent to a reply subject.
+type ackBatch struct {
+	acks  []pendingAck
+	timer *time.Timer
+}
+
+func newAckBatcher(window time.Duration, max int, send func(reply string, acks []pendingAck)) *ackBatcher {
+	return &ackBatcher{
+		window:  window,
+		max:     max,
+		send:    send,
+		batches: make(map[string]*ackBatch),
+	}
+}
+
+// add adds the ack to the batch of its reply subject. The batch is sent once
+// it's full or the window is up. Once the batcher is closed the ack is sent on
+// its own right away.
+func (b *ackBatcher) add(a pendingAck) {
+	reply := a.msg.Reply
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		b.send(reply, []pendingAck{a})
+		return
+	}
+	batch, ok := b.batches[reply]
+	if !ok {
+		batch = &ackBatch{}
+		b.batches[reply] = batch
+		batch.timer = time.AfterFunc(b.window, func() { b.flush(reply, batch) })
+	}
+	batch.acks = append(batch.acks, a)
+	if len(batch.acks) < b.max {
+		b.mu.Unlock()
+		return
+	}
+	batch.timer.Stop()
+	delete(b.batches, reply)
+	b.mu.Unlock()
+	b.send(reply, batch.acks)
+}
+
+// flush sends the batch unless it was already sent for being full.
+func (b *ackBatcher) flush(reply string, batch *ackBatch) {
+	b.mu.Lock()
+	if b.batches[reply] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.batches, reply)
+	b.mu.Unlock()
+	b.send(reply, batch.acks)
+}
+
+// close sends the batches waiting to be sent.
+func (b *ackBatcher) close() {
+	b.mu.Lock()
+	b.closed = true
+	batches := b.batches
+	b.batches = make(map[string]*ackBatch)
+	b.mu.Unlock()
+	for reply, batch := range batches {
+		batch.timer.Stop()
+		b.send(reply, batch.acks)
+	}
+}
+
+// sendBatchAck acknowledges the messages in a BatchAck, recording an ack
+// failure for each of them if it can't be sent.
+func (c *Conn) sendBatchAck(reply string, acks []pendingAck) {
+	ba := protocol.BatchAck{IDs: make([]string, len(acks))}
+	for i, a := range acks {
+		ba.IDs[i] = a.id
+	}
+	msg, err := ba.Msg(reply)
+	if err == nil {
+		c.mu.RLock()
+		nc := c.nc
+		c.mu.RUnlock()
+		err = nc.PublishMsg(msg)
+	}
+	if err != nil {
+		for _, a := range acks {
+			c.ackFailed(a.qk, a.msg, a.persisted, err)
+		}
+	}
+}
+
+// correlationID returns the correlation id of the message if it should be
+// acknowledged in a batch.
+func (c *Conn) correlationID(msg *nats.Msg) string {
+	if c.ackBatcher == nil || msg.Header == nil {
+		return ""
+	}
+	return msg.Header.Get(protocol.CorrelationIDHeader)
+}
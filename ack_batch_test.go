@@ -0,0 +1,63 @@
+package requeue
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAckBatcher(t *testing.T) {
+	var mu sync.Mutex
+	sent := make(map[string][][]string)
+	b := newAckBatcher(time.Hour, 3, func(reply string, acks []pendingAck) {
+		ids := make([]string, len(acks))
+		for i, a := range acks {
+			ids[i] = a.id
+		}
+		mu.Lock()
+		sent[reply] = append(sent[reply], ids)
+		mu.Unlock()
+	})
+	ack := func(reply, id string) pendingAck {
+		return pendingAck{id: id, msg: &nats.Msg{Reply: reply}}
+	}
+
+	// A batch is sent once it's full.
+	b.add(ack("inbox.a", "1"))
+	b.add(ack("inbox.b", "1"))
+	b.add(ack("inbox.a", "2"))
+	b.add(ack("inbox.a", "3"))
+	b.add(ack("inbox.a", "4"))
+	mu.Lock()
+	assert.Equal(t, map[string][][]string{"inbox.a": {{"1", "2", "3"}}}, sent)
+	mu.Unlock()
+
+	// The rest are sent when it's closed, and afterwards acks are sent on
+	// their own.
+	b.close()
+	b.add(ack("inbox.a", "5"))
+	mu.Lock()
+	assert.Equal(t, map[string][][]string{
+		"inbox.a": {{"1", "2", "3"}, {"4"}, {"5"}},
+		"inbox.b": {{"1"}},
+	}, sent)
+	mu.Unlock()
+
+	// Or once the window is up.
+	done := make(chan []pendingAck, 1)
+	b = newAckBatcher(10*time.Millisecond, 100, func(reply string, acks []pendingAck) {
+		done <- acks
+	})
+	b.add(ack("inbox.a", "1"))
+	b.add(ack("inbox.a", "2"))
+	select {
+	case acks := <-done:
+		assert.Len(t, acks, 2)
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch was never sent")
+	}
+	b.close()
+}
@@ -0,0 +1,139 @@
+package requeue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultAckFailureRetention is how long ack failures are kept by default.
+const DefaultAckFailureRetention = 7 * 24 * time.Hour
+
+// AckFailureRetention sets how long a protocol.AckFailure is kept when a
+// message can't be acknowledged to its producer. The failures can be listed
+// with Conn.AckFailures to reconcile messages that were persisted but never
+// acked against the retries made by producers. Zero keeps them until they are
+// resolved with Conn.ResolveAckFailure or the queue is dropped.
+func AckFailureRetention(retention time.Duration) Option {
+	return func(o *Options) error {
+		if retention < 0 {
+			return fmt.Errorf("ack failure retention cannot be negative: %s", retention)
+		}
+		o.ackFailureRetention = retention
+		return nil
+	}
+}
+
+// AckFailureHandler sets a callback that will be triggered for every
+// AckFailure. The failures are also published on protocol.AckFailuresSubject.
+func AckFailureHandler(cb func(protocol.AckFailure)) Option {
+	return func(o *Options) error {
+		o.ackFailureCB = cb
+		return nil
+	}
+}
+
+// ackFailed records that the message stored under qk couldn't be acknowledged
+// to its producer.
+func (c *Conn) ackFailed(qk queue.QueueKey, msg *nats.Msg, persisted bool, ackErr error) {
+	fb := flatbuf.GetRootAsRequeueMessage(msg.Data, 0)
+	c.recordAckFailure(qk.Key, protocol.AckFailure{
+		InstanceID: c.instanceId,
+		Queue:      qk.Name,
+		Key:        qk.Key.String(),
+		Subject:    string(fb.OriginalSubject()),
+		Reply:      msg.Reply,
+		Persisted:  persisted,
+		Error:      ackErr.Error(),
+		Labels:     c.Opts.labels,
+		Time:       time.Now(),
+	})
+}
+
+// recordAckFailure stores the ack failure of the message stored under k and
+// reports it.
+func (c *Conn) recordAckFailure(k key.Key, e protocol.AckFailure) {
+	c.mu.RLock()
+	nc, db := c.nc, c.badgerDB
+	c.mu.RUnlock()
+	c.recordAckFailureIn(nc, db, k, e)
+}
+
+// recordAckFailureIn stores the ack failure of the message stored under k in
+// db and reports it with nc. It doesn't acquire the lock on the connection.
+func (c *Conn) recordAckFailureIn(nc *nats.Conn, db *badger.DB, k key.Key, e protocol.AckFailure) {
+	log.Error().
+		Str("error", e.Error).
+		Str("queue", e.Queue).
+		Str("key", e.Key).
+		Str("reply", e.Reply).
+		Msg("problem sending ACK for message")
+
+	data, err := e.MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("problem marshaling ack failure")
+		return
+	}
+	if err := queue.PutAckFailure(db, e.Queue, k, data, c.Opts.ackFailureRetention); err != nil {
+		log.Err(err).
+			Str("queue", e.Queue).
+			Str("key", e.Key).
+			Msg("problem storing ack failure")
+	}
+
+	if cb := c.Opts.ackFailureCB; cb != nil {
+		c.hook(func() { cb(e) })
+	}
+	c.publishEventOn(nc, protocol.AckFailuresSubject, e)
+}
+
+// AckFailures calls f, in message key order, with the ack failures recorded
+// for the queue. If f returns false the iteration stops.
+func (c *Conn) AckFailures(queueName string, f func(protocol.AckFailure) bool) error {
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if db == nil {
+		return fmt.Errorf("ack failures: store is not open")
+	}
+
+	var decodeErr error
+	err := queue.RangeAckFailures(db, queueName, func(qi queue.QueueItem) bool {
+		e := protocol.AckFailure{}
+		if decodeErr = e.UnmarshalBinary(qi.V); decodeErr != nil {
+			return false
+		}
+		return f(e)
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return fmt.Errorf("ack failures: %w", err)
+	}
+	return nil
+}
+
+// ResolveAckFailure removes the ack failure of the message with the key, as
+// given in its protocol.AckFailure, once it has been reconciled.
+func (c *Conn) ResolveAckFailure(queueName, messageKey string) error {
+	k, err := key.Parse(messageKey)
+	if err != nil {
+		return fmt.Errorf("resolve ack failure: %w", err)
+	}
+
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if db == nil {
+		return fmt.Errorf("resolve ack failure: store is not open")
+	}
+	return queue.DeleteAckFailure(db, queueName, k)
+}
@@ -0,0 +1,402 @@
+package requeue
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// adminShutdownTimeout is how long the requests being served by the admin API
+// have to finish when the connection is closed.
+const adminShutdownTimeout = 5 * time.Second
+
+// AdminAddr serves the admin API over HTTP on the address, e.g., ":8080". It
+// has no authentication so it should only be reachable by operators. The
+// endpoints are:
+//
+//	GET    /queues                        List the queues.
+//	GET    /queues/{queue}                Show the depth and oldest message of a queue.
+//	GET    /queues/{queue}/messages/{key} Peek at a message by its key.
+//	DELETE /queues/{queue}/messages       Purge the messages from a queue.
+//	POST   /replay                        Republish the ready messages now.
+//	GET    /rejections                    Report the messages rejected at ingest,
+//	                                      optionally ?subject=orders.>&since=<RFC 3339>.
+//	GET    /readyz                        Report whether the instance is keeping up,
+//	                                      with a 503 when it isn't.
+func AdminAddr(addr string) Option {
+	return func(o *Options) error {
+		o.adminAddr = addr
+		return nil
+	}
+}
+
+func (c *Conn) initAdmin() error {
+	if c.Opts.adminAddr == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", c.Opts.adminAddr)
+	if err != nil {
+		return fmt.Errorf("init admin: %w", err)
+	}
+	srv := &http.Server{Handler: c.adminHandler()}
+
+	c.mu.Lock()
+	c.adminListener = ln
+	c.mu.Unlock()
+
+	c.closers.admin.AddRunning(1)
+	go func() {
+		defer c.closers.admin.Done()
+		<-c.closers.admin.HasBeenClosed()
+
+		log.Debug().Msg("closing admin api...")
+		ctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Err(err).Msg("problem shutting down admin api")
+		}
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Err(err).Msg("admin api stopped")
+		}
+	}()
+
+	log.Info().Str("addr", ln.Addr().String()).Msg("serving admin api")
+	return nil
+}
+
+// AdminAddr returns the address the admin API is served on, or nil if it
+// isn't.
+func (c *Conn) AdminAddr() net.Addr {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.adminListener == nil {
+		return nil
+	}
+	return c.adminListener.Addr()
+}
+
+// adminHandler routes the requests to the admin API.
+func (c *Conn) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/queues", c.handleAdminQueues)
+	mux.HandleFunc("/queues/", c.handleAdminQueue)
+	mux.HandleFunc("/replay", c.handleAdminReplay)
+	mux.HandleFunc("/rejections", c.handleAdminRejections)
+	mux.HandleFunc("/readyz", c.handleAdminReadyz)
+	return mux
+}
+
+func (c *Conn) handleAdminQueues(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		adminMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	summaries, err := c.QueueSummaries()
+	if err != nil {
+		adminError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	adminJSON(w, http.StatusOK, summaries)
+}
+
+// handleAdminQueue handles the requests for a queue, whose name can't contain
+// a slash, and its messages.
+func (c *Conn) handleAdminQueue(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/queues/"), "/")
+	name := parts[0]
+	if name == "" {
+		adminError(w, http.StatusNotFound, fmt.Errorf("queue name cannot be blank"))
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		if r.Method != http.MethodGet {
+			adminMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		s, ok, err := c.QueueSummary(name)
+		if err != nil {
+			adminError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		if !ok {
+			adminError(w, http.StatusNotFound, fmt.Errorf("no such queue: %q", name))
+			return
+		}
+		adminJSON(w, http.StatusOK, s)
+
+	case len(parts) == 2 && parts[1] == "messages":
+		if r.Method != http.MethodDelete {
+			adminMethodNotAllowed(w, http.MethodDelete)
+			return
+		}
+		reply := protocol.PurgeReply{Queue: name}
+		n, err := c.PurgeQueue(name)
+		reply.Purged = int64(n)
+		reply.Time = time.Now()
+		if err != nil {
+			reply.Error = err.Error()
+			adminJSON(w, http.StatusInternalServerError, reply)
+			return
+		}
+		adminJSON(w, http.StatusOK, reply)
+
+	case len(parts) == 3 && parts[1] == "messages":
+		if r.Method != http.MethodGet {
+			adminMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if _, err := key.Parse(parts[2]); err != nil {
+			adminError(w, http.StatusBadRequest, err)
+			return
+		}
+		m, ok, err := c.PeekMessage(name, parts[2])
+		if err != nil {
+			adminError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !ok {
+			adminError(w, http.StatusNotFound, fmt.Errorf("no such message: %q", parts[2]))
+			return
+		}
+		adminJSON(w, http.StatusOK, m)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (c *Conn) handleAdminReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		adminMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if err := c.Replay(); err != nil {
+		adminError(w, http.StatusConflict, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (c *Conn) handleAdminRejections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		adminMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	req := protocol.RejectionsRequest{Subject: r.URL.Query().Get("subject")}
+	if since := r.URL.Query().Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			adminError(w, http.StatusBadRequest, fmt.Errorf("invalid since: %w", err))
+			return
+		}
+		req.Since = t
+	}
+	report, err := c.Rejections(req)
+	if err != nil {
+		report.Error = err.Error()
+		adminJSON(w, http.StatusBadRequest, report)
+		return
+	}
+	adminJSON(w, http.StatusOK, report)
+}
+
+func (c *Conn) handleAdminReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		adminMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	readiness := c.Readiness()
+	code := http.StatusOK
+	if !readiness.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	adminJSON(w, code, readiness)
+}
+
+func adminJSON(w http.ResponseWriter, code int, v interface{}) {
+	var data []byte
+	var err error
+	if m, ok := v.(encoding.BinaryMarshaler); ok {
+		data, err = m.MarshalBinary()
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		log.Err(err).Msg("unable to marshal admin api response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(data); err != nil {
+		log.Err(err).Msg("unable to write admin api response")
+	}
+}
+
+func adminError(w http.ResponseWriter, code int, err error) {
+	adminJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func adminMethodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	adminError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+}
+
+// QueueSummaries describes every queue, including the sub-queues of time
+// bucketed queues, in name order.
+func (c *Conn) QueueSummaries() ([]protocol.QueueSummary, error) {
+	qManager := c.Manager()
+	if qManager == nil {
+		return nil, fmt.Errorf("queue summaries: queue manager is not running")
+	}
+	queues := qManager.Queues()
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Name() < queues[j].Name()
+	})
+
+	summaries := make([]protocol.QueueSummary, 0, len(queues))
+	for _, q := range queues {
+		summaries = append(summaries, queueSummary(q))
+	}
+	return summaries, nil
+}
+
+// QueueSummary describes the queue. False is returned if there's no such
+// queue.
+func (c *Conn) QueueSummary(queueName string) (protocol.QueueSummary, bool, error) {
+	qManager := c.Manager()
+	if qManager == nil {
+		return protocol.QueueSummary{}, false, fmt.Errorf("queue summary: queue manager is not running")
+	}
+	q, ok := qManager.GetQueue(queueName)
+	if !ok {
+		return protocol.QueueSummary{}, false, nil
+	}
+	return queueSummary(q), true, nil
+}
+
+// queueSummary describes the queue. The queue is ordered by when its messages
+// are ready rather than when they were enqueued, e.g., a message delayed for
+// an hour sits behind one enqueued after it with no delay, so the oldest
+// message is the one found when the queue stats were last refreshed rather
+// than reading the whole queue on every request.
+func queueSummary(q *queue.Queue) protocol.QueueSummary {
+	stats := q.Stats.QueueStatsMessage()
+	s := protocol.QueueSummary{
+		Name:     q.Name(),
+		Depth:    stats.Enqueued,
+		InFlight: stats.InFlight,
+	}
+	if stats.OldestEnqueuedAt != 0 {
+		oldest := time.Unix(0, stats.OldestEnqueuedAt)
+		s.OldestEnqueuedAt = &oldest
+		s.OldestAgeSeconds = time.Since(oldest).Seconds()
+	}
+	return s
+}
+
+// PeekMessage returns the message with the key pending in the queue without
+// taking it out of the queue. False is returned if there's no such message,
+// e.g., because it has been republished or retried under a new key.
+func (c *Conn) PeekMessage(queueName, messageKey string) (protocol.PeekedMessage, bool, error) {
+	var m protocol.PeekedMessage
+
+	k, err := key.Parse(messageKey)
+	if err != nil {
+		return m, false, fmt.Errorf("peek message: %w", err)
+	}
+
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if db == nil {
+		return m, false, fmt.Errorf("peek message: store is not open")
+	}
+
+	qi, ok, err := queue.GetMessage(db, queueName, k)
+	if err != nil || !ok {
+		return m, false, err
+	}
+	fb := flatbuf.GetRootAsRequeueMessage(qi.V, 0)
+	m = protocol.PeekedMessage{
+		Queue:      queueName,
+		Key:        k.String(),
+		MessageID:  qi.MessageID(fb),
+		Subject:    string(fb.OriginalSubject()),
+		ReadyAt:    qi.ReadyAt(),
+		EnqueuedAt: qi.FirstEnqueuedAt(fb),
+		Attempts:   fb.Attempts(),
+		Retries:    fb.Retries(),
+		Message:    qi.V,
+	}
+	if expiresAt, ok := qi.Expiry(fb); ok {
+		m.ExpiresAt = &expiresAt
+	}
+	return m, true, nil
+}
+
+// PurgeQueue removes every message pending in the queue. Its quarantined
+// messages, dead letters and records are kept. The number of messages removed
+// is returned, even if it fails part way. Messages already in flight may still
+// be delivered, but are never retried. Its progress is reported by Operations,
+// and it can be stopped part way with CancelOperation.
+func (c *Conn) PurgeQueue(queueName string) (int, error) {
+	c.mu.RLock()
+	db := c.badgerDB
+	qManager := c.qManager
+	c.mu.RUnlock()
+	if db == nil || qManager == nil {
+		return 0, fmt.Errorf("purge queue: queue manager is not running")
+	}
+	q, ok := qManager.GetQueue(queueName)
+	if !ok {
+		return 0, fmt.Errorf("purge queue: no such queue: %q", queueName)
+	}
+
+	op := c.operations.start(c.Opts.ctx, protocol.OperationPurge, queueName)
+	n, err := queue.EraseMessagesProgress(op.ctx, db, queueName, func(*flatbuf.RequeueMessage) bool {
+		return true
+	}, false, op.progress)
+	op.finish(err)
+	// Recount now so the summary of the queue doesn't wait on the next
+	// refresh to drop the oldest message.
+	if rErr := q.Stats.Refresh(); rErr != nil {
+		log.Err(rErr).Str("queue", queueName).Msg("problem refreshing the queue stats after purging")
+		q.Stats.AddCount(-int64(n))
+	}
+	log.Info().Str("queue", queueName).Str("operation", op.id()).Msgf("purged %d messages", n)
+	if err != nil {
+		return n, fmt.Errorf("purge queue: %w", err)
+	}
+	return n, nil
+}
+
+// Replay wakes the republisher so the messages that are ready are republished
+// now rather than on its next scheduled scan.
+func (c *Conn) Replay() error {
+	c.mu.RLock()
+	rp := c.republisher
+	c.mu.RUnlock()
+	if rp == nil {
+		return fmt.Errorf("replay: not republishing")
+	}
+	rp.Notify(time.Now())
+	return nil
+}
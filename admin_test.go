@@ -0,0 +1,165 @@
+package requeue
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	assert.NoError(t, AdminAddr("127.0.0.1:0")(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	assert.NoError(t, c.initQueueManager())
+	assert.NoError(t, c.initAdmin())
+	assert.NotNil(t, c.AdminAddr())
+
+	now := time.Now()
+	keys := []key.Key{
+		key.New(now.Add(-2 * time.Minute)),
+		key.New(now.Add(-time.Minute)),
+	}
+	assert.NoError(t, c.badgerDB.Update(func(txn *badger.Txn) error {
+		for _, k := range keys {
+			m := protocol.DefaultRequeueMessage()
+			m.QueueName = "orders"
+			m.OriginalSubject = "orders.created"
+			m.OriginalPayload = []byte("order")
+			m.Retries = 5
+			if err := txn.Set(queue.NewQueueKeyForMessage("orders", k).Bytes(), m.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	for _, name := range []string{"orders", "emails"} {
+		q, err := c.qManager.CreateQueue(queue.NewQueueKeyForState(name, ""))
+		assert.NoError(t, err)
+		assert.NoError(t, q.Stats.Refresh())
+	}
+
+	srv := httptest.NewServer(c.adminHandler())
+	defer srv.Close()
+	do := func(method, path string, out interface{}) int {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		assert.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		if out != nil {
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(out))
+		}
+		return res.StatusCode
+	}
+
+	// The queues are listed in name order with the age of their oldest
+	// message.
+	var summaries []protocol.QueueSummary
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/queues", &summaries))
+	if assert.Len(t, summaries, 2) {
+		assert.Equal(t, "emails", summaries[0].Name)
+		assert.Nil(t, summaries[0].OldestEnqueuedAt)
+		assert.Equal(t, "orders", summaries[1].Name)
+		if assert.NotNil(t, summaries[1].OldestEnqueuedAt) {
+			assert.Equal(t, keys[0].Time().Unix(), summaries[1].OldestEnqueuedAt.Unix())
+		}
+		assert.True(t, summaries[1].OldestAgeSeconds >= 119)
+	}
+	var s protocol.QueueSummary
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/queues/orders", &s))
+	assert.Equal(t, "orders", s.Name)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/queues/missing", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodPost, "/queues", nil))
+
+	// A message is peeked at by its key and left in the queue.
+	var m protocol.PeekedMessage
+	path := "/queues/orders/messages/" + keys[1].String()
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, path, &m))
+	assert.Equal(t, keys[1].String(), m.Key)
+	assert.Equal(t, "orders.created", m.Subject)
+	assert.Equal(t, uint64(5), m.Retries)
+	assert.Nil(t, m.ExpiresAt)
+	var msg protocol.RequeueMessage
+	assert.NoError(t, msg.UnmarshalBinary(m.Message))
+	assert.Equal(t, "order", string(msg.OriginalPayload))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, path, nil))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/queues/orders/messages/nope", nil))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/queues/emails/messages/"+keys[1].String(), nil))
+
+	// Purging removes every message.
+	var purged protocol.PurgeReply
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/queues/orders/messages", &purged))
+	assert.Equal(t, int64(2), purged.Purged)
+	assert.Empty(t, purged.Error)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, path, nil))
+	var empty protocol.QueueSummary
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/queues/orders", &empty))
+	assert.Nil(t, empty.OldestEnqueuedAt)
+	assert.Equal(t, int64(0), empty.Depth)
+
+	// There's nothing to wake without a republisher.
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/replay", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodGet, "/replay", nil))
+
+	// The admin API is also served on the address it was given.
+	res, err := http.Get("http://" + c.AdminAddr().String() + "/queues")
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestQueueSummaryOldest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	assert.NoError(t, c.initQueueManager())
+	q, err := c.qManager.CreateQueue(queue.NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+
+	// The delayed message is behind the ready one in the queue, but it was
+	// enqueued first, e.g., it's being retried with a long backoff.
+	now := time.Now()
+	ready := protocol.DefaultRequeueMessage()
+	delayed := protocol.DefaultRequeueMessage()
+	delayed.EnqueuedAt = now.Add(-time.Hour).UnixNano()
+	assert.NoError(t, c.badgerDB.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(queue.NewQueueKeyForMessage("orders", key.New(now.Add(-time.Minute))).Bytes(), ready.Bytes()); err != nil {
+			return err
+		}
+		return txn.Set(queue.NewQueueKeyForMessage("orders", key.New(now.Add(time.Hour))).Bytes(), delayed.Bytes())
+	}))
+	// The oldest message is found when the queue stats are refreshed.
+	assert.NoError(t, q.Stats.Refresh())
+
+	s, ok, err := c.QueueSummary("orders")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	if assert.NotNil(t, s.OldestEnqueuedAt) {
+		assert.Equal(t, delayed.EnqueuedAt, s.OldestEnqueuedAt.UnixNano())
+	}
+	assert.True(t, s.OldestAgeSeconds >= 3599)
+}
@@ -0,0 +1,55 @@
+package requeue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/internal/republisher"
+)
+
+// BackoffStrategy decides how long a message waits before it's republished
+// again. Implement it for a custom DefaultBackoff.
+type BackoffStrategy = republisher.BackoffStrategy
+
+// The backoff strategies messages can ask for with their backoff_strategy.
+type (
+	// FixedBackoff waits the delay of the message before every attempt.
+	FixedBackoff = republisher.FixedBackoff
+
+	// ExponentialBackoff doubles the delay on every attempt, starting at the
+	// delay of the message.
+	ExponentialBackoff = republisher.ExponentialBackoff
+
+	// ExponentialWithJitterBackoff is an ExponentialBackoff that waits a
+	// random delay between half and all of it.
+	ExponentialWithJitterBackoff = republisher.ExponentialWithJitterBackoff
+
+	// LinearBackoff adds the delay of the message on every attempt.
+	LinearBackoff = republisher.LinearBackoff
+)
+
+// DefaultBackoff sets the backoff of the messages that don't ask for one with
+// their backoff_strategy. They wait the delay they asked for before every
+// attempt by default, i.e., a FixedBackoff.
+func DefaultBackoff(b BackoffStrategy) Option {
+	return func(o *Options) error {
+		if b == nil {
+			return fmt.Errorf("default backoff cannot be nil")
+		}
+		o.defaultBackoff = b
+		return nil
+	}
+}
+
+// MaxBackoff caps the delay of the messages that ask for a growing backoff,
+// which is republisher.DefaultMaxBackoff by default. It doesn't apply to the
+// DefaultBackoff, which caps itself.
+func MaxBackoff(max time.Duration) Option {
+	return func(o *Options) error {
+		if max <= 0 {
+			return fmt.Errorf("max backoff must be positive: %s", max)
+		}
+		o.maxBackoff = max
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+package requeue
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/internal/republisher"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// DefaultBridgeRetryDelay is the delay before a bridged message is first
+// retried. It backs off exponentially from there, up to the MaxBackoff.
+const DefaultBridgeRetryDelay = time.Second
+
+// Bridge makes requeue a durable store-and-forward link between NATS
+// deployments. Plain messages published on the subject, which may have
+// wildcards, on the ingest connection are persisted and forwarded on the
+// egress connection, which must be set with EgressNATSServers or
+// EgressNATSOptions, as soon as they arrive. They're retried until they're
+// acknowledged unless MaxRedeliveries says otherwise. Their subjects are
+// translated with the translations, as described by
+// republisher.SubjectTranslations, and left alone when none match. Only the
+// traceparent of the headers of a message is forwarded, and only when trace
+// headers are enabled.
+//
+// The subject must not match the subjects the messages are forwarded on if
+// the egress connection is to the same deployment, otherwise they're ingested
+// again.
+func Bridge(subject string, translations map[string]string) Option {
+	return func(o *Options) error {
+		if err := protocol.ValidateSubject(subject); err != nil {
+			return fmt.Errorf("bridge: %w", err)
+		}
+		if err := republisher.ValidateSubjectTranslations(translations); err != nil {
+			return fmt.Errorf("bridge: %w", err)
+		}
+		o.bridge = true
+		o.natsSubject = subject
+		o.subjectTranslations = translations
+		return nil
+	}
+}
+
+// bridgeEnvelope returns the plain message msg wrapped in a RequeueMessage so
+// it can be ingested like any other.
+func bridgeEnvelope(msg *nats.Msg) *nats.Msg {
+	m := protocol.DefaultRequeueMessage()
+	m.Retries = math.MaxUint64
+	m.Delay = uint64(DefaultBridgeRetryDelay)
+	m.BackoffStrategy = protocol.BackoffStrategy_Exponential
+	m.OriginalSubject = msg.Subject
+	m.OriginalPayload = msg.Data
+	return &nats.Msg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Header:  msg.Header,
+		Data:    m.Bytes(),
+		Sub:     msg.Sub,
+	}
+}
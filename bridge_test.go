@@ -0,0 +1,58 @@
+package requeue
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBridgeEnvelope(t *testing.T) {
+	msg := &nats.Msg{
+		Subject: "orders.created",
+		Reply:   "_INBOX.1",
+		Header:  http.Header{},
+		Data:    []byte("order"),
+	}
+	wrapped := bridgeEnvelope(msg)
+	assert.Equal(t, msg.Subject, wrapped.Subject)
+	assert.Equal(t, msg.Reply, wrapped.Reply)
+
+	var m protocol.RequeueMessage
+	assert.NoError(t, m.UnmarshalBinary(wrapped.Data))
+	assert.Equal(t, "orders.created", m.OriginalSubject)
+	assert.Equal(t, "order", string(m.OriginalPayload))
+	assert.Equal(t, protocol.DefaultQueueName, m.QueueName)
+	assert.Equal(t, uint64(math.MaxUint64), m.Retries)
+	assert.Equal(t, uint64(DefaultBridgeRetryDelay), m.Delay)
+
+	// It's forwarded right away rather than after its retry delay.
+	o := GetDefaultOptions()
+	assert.NoError(t, Bridge("orders.>", nil)(&o))
+	c := NewConn(o)
+	before := time.Now().Truncate(time.Second)
+	qk, err := c.newMessageQueueKey(wrapped, flatbuf.GetRootAsRequeueMessage(wrapped.Data, 0))
+	assert.NoError(t, err)
+	assert.False(t, qk.Time().Before(before))
+	assert.True(t, qk.Time().Before(time.Now().Add(DefaultBridgeRetryDelay/2)))
+}
+
+func TestBridgeOption(t *testing.T) {
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir("/tmp/requeue")(&o))
+	assert.NoError(t, Bridge("orders.>", map[string]string{"orders.>": "east.orders.>"})(&o))
+	assert.Equal(t, "orders.>", o.natsSubject)
+
+	// It needs somewhere to forward the messages to.
+	assert.Error(t, o.Validate())
+	assert.NoError(t, EgressNATSServers("nats://east:4222")(&o))
+	assert.NoError(t, o.Validate())
+
+	assert.Error(t, Bridge("orders..created", nil)(&o))
+	assert.Error(t, Bridge("orders.>", map[string]string{"orders.>": "east.orders.*"})(&o))
+}
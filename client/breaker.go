@@ -0,0 +1,70 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is given to the Fallback for messages that weren't sent
+// because the circuit breaker is open.
+var ErrCircuitOpen = errors.New("requeue circuit breaker is open")
+
+// breaker stops sending to requeue after it fails to acknowledge a number of
+// messages in a row so callers don't each wait out the ack timeout during an
+// outage. Once the cooldown has passed a single message is let through to
+// check if requeue has recovered.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	// True while the message checking if requeue has recovered is in flight.
+	probing bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+func (b *breaker) isOpen() bool {
+	return b.failures >= b.threshold
+}
+
+// allow returns true if a message may be sent.
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.isOpen() {
+		return true
+	}
+	if b.probing || now.Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// success records that requeue responded to a message.
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+// failure records that requeue didn't respond to a message.
+func (b *breaker) failure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.isOpen() {
+		b.openedAt = now
+	}
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(2, time.Second)
+	now := time.Now()
+
+	assert.True(t, b.allow(now))
+	b.failure(now)
+	assert.True(t, b.allow(now))
+	b.success()
+	b.failure(now)
+	assert.True(t, b.allow(now))
+	b.failure(now)
+
+	// Open after two failures in a row.
+	assert.False(t, b.allow(now))
+	assert.False(t, b.allow(now.Add(time.Second-1)))
+
+	// A single probe is let through after the cooldown.
+	now = now.Add(time.Second)
+	assert.True(t, b.allow(now))
+	assert.False(t, b.allow(now))
+	b.failure(now)
+	assert.False(t, b.allow(now))
+
+	now = now.Add(time.Second)
+	assert.True(t, b.allow(now))
+	b.success()
+	assert.True(t, b.allow(now))
+	assert.True(t, b.allow(now))
+}
@@ -0,0 +1,316 @@
+// Package client sends messages to requeue.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/internal/ticker"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultAckTimeout is how long to wait for requeue to acknowledge a
+	// message.
+	DefaultAckTimeout = 5 * time.Second
+
+	// DefaultSpoolReplayInterval is how often spooled messages are replayed.
+	DefaultSpoolReplayInterval = 5 * time.Second
+)
+
+var (
+	// ErrAckTimeout is returned when requeue doesn't acknowledge a message in
+	// time. It may still have been persisted.
+	ErrAckTimeout = errors.New("requeue did not acknowledge the message in time")
+
+	// ErrNoResponders is returned when nothing is subscribed to the subject a
+	// message was sent to requeue on, e.g., because requeue isn't running.
+	// The message was not persisted.
+	ErrNoResponders = errors.New("requeue is not subscribed to the subject")
+)
+
+// Option is a function on the options for a Producer.
+type Option func(*Options) error
+
+// Options can be used to create a customized Producer.
+type Options struct {
+	ackTimeout time.Duration
+
+	spoolPath           string
+	spoolReplayInterval time.Duration
+
+	subjectPrefix string
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	fallback         Fallback
+}
+
+func GetDefaultOptions() Options {
+	return Options{
+		ackTimeout:          DefaultAckTimeout,
+		spoolReplayInterval: DefaultSpoolReplayInterval,
+	}
+}
+
+// AckTimeout sets how long to wait for requeue to acknowledge a message.
+func AckTimeout(timeout time.Duration) Option {
+	return func(o *Options) error {
+		if timeout <= 0 {
+			return fmt.Errorf("ack timeout must be positive: %s", timeout)
+		}
+		o.ackTimeout = timeout
+		return nil
+	}
+}
+
+// Spool appends messages requeue doesn't acknowledge to the file at path
+// instead of failing to send them, unless there is another Fallback. They are
+// replayed, in order, once requeue is reachable again. This covers the case
+// where requeue itself is down. Messages requeue rejects are never spooled.
+func Spool(path string) Option {
+	return func(o *Options) error {
+		if path == "" {
+			return fmt.Errorf("spool path cannot be empty")
+		}
+		o.spoolPath = path
+		return nil
+	}
+}
+
+// SpoolReplayInterval sets how often spooled messages are replayed.
+func SpoolReplayInterval(interval time.Duration) Option {
+	return func(o *Options) error {
+		if interval <= 0 {
+			return fmt.Errorf("spool replay interval must be positive: %s", interval)
+		}
+		o.spoolReplayInterval = interval
+		return nil
+	}
+}
+
+// CircuitBreaker stops sending messages to requeue once it fails to
+// acknowledge threshold of them in a row, handing them straight to the
+// Fallback instead of waiting out the ack timeout for each. After the cooldown
+// one message is sent to check if requeue has recovered.
+func CircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(o *Options) error {
+		if threshold <= 0 {
+			return fmt.Errorf("circuit breaker threshold must be positive: %d", threshold)
+		}
+		if cooldown <= 0 {
+			return fmt.Errorf("circuit breaker cooldown must be positive: %s", cooldown)
+		}
+		o.breakerThreshold = threshold
+		o.breakerCooldown = cooldown
+		return nil
+	}
+}
+
+// FallbackHandler sets what to do with messages requeue doesn't acknowledge.
+// Without one the error is returned from Producer.Send, unless there is a
+// Spool.
+func FallbackHandler(f Fallback) Option {
+	return func(o *Options) error {
+		o.fallback = f
+		return nil
+	}
+}
+
+// NakError is returned when requeue rejects a message.
+type NakError struct {
+	Nak protocol.Nak
+}
+
+func (e *NakError) Error() string {
+	if e.Nak.Message == "" {
+		return fmt.Sprintf("requeue rejected the message: %s", e.Nak.Reason)
+	}
+	return fmt.Sprintf("requeue rejected the message: %s: %s", e.Nak.Reason, e.Nak.Message)
+}
+
+// Producer sends messages to requeue over a NATS connection it doesn't own.
+type Producer struct {
+	nc   *nats.Conn
+	opts Options
+
+	breaker  *breaker
+	fallback Fallback
+
+	spool *spool
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewProducer creates a Producer that sends messages over nc.
+func NewProducer(nc *nats.Conn, options ...Option) (*Producer, error) {
+	opts := GetDefaultOptions()
+	for _, opt := range options {
+		if opt != nil {
+			if err := opt(&opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	p := &Producer{
+		nc:       nc,
+		opts:     opts,
+		fallback: opts.fallback,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if opts.breakerThreshold > 0 {
+		p.breaker = newBreaker(opts.breakerThreshold, opts.breakerCooldown)
+	}
+
+	if opts.spoolPath == "" {
+		close(p.done)
+		return p, nil
+	}
+
+	s, err := openSpool(opts.spoolPath)
+	if err != nil {
+		return nil, err
+	}
+	p.spool = s
+	if p.fallback == nil {
+		p.fallback = SpoolFallback()
+	}
+	go p.replayLoop()
+	return p, nil
+}
+
+// Send sends the message to requeue on the subject and waits for it to be
+// acknowledged. If requeue doesn't respond, or the circuit breaker is open,
+// the message is handed to the Fallback and its result is returned. Without a
+// Fallback, ErrAckTimeout or ErrNoResponders is returned if requeue doesn't
+// respond. A *NakError is returned if requeue rejects the message.
+func (p *Producer) Send(subject string, msg protocol.RequeueMessage) error {
+	if prefix := p.opts.subjectPrefix; prefix != "" {
+		prefixed, stripped, err := prefixSubject(prefix, subject)
+		if err != nil {
+			return fmt.Errorf("send: %w", err)
+		}
+		subject = prefixed
+		if msg.OriginalSubject == "" {
+			msg.OriginalSubject = stripped
+		} else {
+			msg.OriginalSubject = strings.TrimPrefix(msg.OriginalSubject, prefix)
+		}
+	}
+	data := msg.Bytes()
+	if p.breaker != nil && !p.breaker.allow(time.Now()) {
+		return p.fallBack(subject, data, ErrCircuitOpen)
+	}
+	err := p.request(subject, data)
+	if _, ok := err.(*NakError); ok || err == nil {
+		return err
+	}
+	return p.fallBack(subject, data, err)
+}
+
+func (p *Producer) fallBack(subject string, data []byte, cause error) error {
+	if p.fallback == nil {
+		return cause
+	}
+	return p.fallback(p, subject, data, cause)
+}
+
+// request sends the message and waits for the acknowledgement, keeping track
+// of whether requeue is responding for the circuit breaker.
+func (p *Producer) request(subject string, data []byte) error {
+	reply, err := p.nc.Request(subject, data, p.opts.ackTimeout)
+	if p.breaker != nil {
+		if err != nil {
+			p.breaker.failure(time.Now())
+		} else {
+			p.breaker.success()
+		}
+	}
+	switch {
+	case err == nats.ErrTimeout:
+		return ErrAckTimeout
+	case err == nats.ErrNoResponders:
+		return ErrNoResponders
+	case err != nil:
+		return err
+	}
+	if nak, ok := protocol.NakFromNATS(reply); ok {
+		return &NakError{Nak: nak}
+	}
+	return nil
+}
+
+// Spooled returns the number of messages in the spool waiting to be replayed.
+func (p *Producer) Spooled() int {
+	if p.spool == nil {
+		return 0
+	}
+	return p.spool.len()
+}
+
+// Replay sends the spooled messages to requeue in the order they were
+// spooled. It stops at the first message requeue doesn't acknowledge, leaving
+// it and the rest in the spool. Messages requeue rejects are dropped from the
+// spool since replaying them would never succeed.
+func (p *Producer) Replay() error {
+	if p.spool == nil {
+		return nil
+	}
+	return p.spool.replay(func(subject string, data []byte) error {
+		err := p.request(subject, data)
+		if nErr, ok := err.(*NakError); ok {
+			log.Err(nErr).
+				Str("subject", subject).
+				Msg("dropping spooled message requeue rejected")
+			return nil
+		}
+		return err
+	})
+}
+
+func (p *Producer) replayLoop() {
+	defer close(p.done)
+	t := ticker.New(p.opts.spoolReplayInterval)
+	go func() {
+		<-p.quit
+		t.Stop()
+	}()
+	t.Loop(func() bool {
+		if p.spool.len() == 0 || !p.nc.IsConnected() {
+			return true
+		}
+		if p.breaker != nil && !p.breaker.allow(time.Now()) {
+			return true
+		}
+		if err := p.Replay(); err != nil {
+			log.Debug().Err(err).
+				Int("spooled", p.spool.len()).
+				Msg("requeue is still unreachable")
+		}
+		return true
+	})
+}
+
+// Close stops replaying the spool. Spooled messages stay on disk and are
+// replayed by the next Producer that uses the same spool. The NATS connection
+// is not closed.
+func (p *Producer) Close() error {
+	select {
+	case <-p.quit:
+		return nil
+	default:
+	}
+	close(p.quit)
+	<-p.done
+	if p.spool != nil {
+		return p.spool.close()
+	}
+	return nil
+}
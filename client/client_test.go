@@ -0,0 +1,35 @@
+package client
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProducerFallBack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "requeue-spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Spooling is the default fallback when there is a spool.
+	p, err := NewProducer(nil, Spool(filepath.Join(dir, "spool")), CircuitBreaker(1, time.Minute))
+	assert.NoError(t, err)
+	assert.NoError(t, p.fallBack("requeue.a", []byte("1"), ErrCircuitOpen))
+	assert.Equal(t, 1, p.Spooled())
+	assert.NoError(t, p.Close())
+
+	// Without one the cause is returned.
+	p, err = NewProducer(nil)
+	assert.NoError(t, err)
+	cause := errors.New("timeout")
+	assert.Equal(t, cause, p.fallBack("requeue.a", []byte("1"), cause))
+	assert.NoError(t, p.Close())
+
+	_, err = NewProducer(nil, CircuitBreaker(0, time.Minute))
+	assert.Error(t, err)
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Fallback handles a message requeue didn't acknowledge, or that wasn't sent
+// because the circuit breaker is open, in which case cause is ErrCircuitOpen.
+// Its error is returned from Producer.Send.
+type Fallback func(p *Producer, subject string, data []byte, cause error) error
+
+// DropFallback silently drops the message.
+func DropFallback() Fallback {
+	return func(p *Producer, subject string, data []byte, cause error) error {
+		return nil
+	}
+}
+
+// LogFallback logs the message and drops it.
+func LogFallback() Fallback {
+	return func(p *Producer, subject string, data []byte, cause error) error {
+		log.Warn().Err(cause).
+			Str("subject", subject).
+			Int("size", len(data)).
+			Msg("requeue did not acknowledge the message so it was dropped")
+		return nil
+	}
+}
+
+// SpoolFallback appends the message to the spool to be replayed once requeue
+// is reachable again. It's the default Fallback when there is a Spool.
+func SpoolFallback() Fallback {
+	return func(p *Producer, subject string, data []byte, cause error) error {
+		if p.spool == nil {
+			return fmt.Errorf("unable to spool message after %v: there is no spool", cause)
+		}
+		if err := p.spool.append(subject, data); err != nil {
+			return fmt.Errorf("unable to spool message after %v: %w", cause, err)
+		}
+		log.Warn().Err(cause).
+			Str("subject", subject).
+			Msg("requeue did not acknowledge the message so it was spooled")
+		return nil
+	}
+}
+
+// SubjectFallback publishes the message on the alternate subject, e.g., the
+// ingress subject of another requeue cluster. It doesn't wait for an
+// acknowledgement.
+func SubjectFallback(alternate string) Fallback {
+	return func(p *Producer, subject string, data []byte, cause error) error {
+		if err := p.nc.Publish(alternate, data); err != nil {
+			return fmt.Errorf("unable to publish message to %s after %v: %w", alternate, cause, err)
+		}
+		return nil
+	}
+}
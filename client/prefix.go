@@ -0,0 +1,74 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// DefaultSubjectPrefix is the prefix of the subjects matched by the subject
+// requeue subscribes to by default.
+const DefaultSubjectPrefix = protocol.SystemSubjectPrefix
+
+// SubjectPrefix has Producer.Send prefix the subject with the prefix requeue
+// ingests messages under, e.g., orders.created is sent on
+// requeue.orders.created with DefaultSubjectPrefix, so it matches the subject
+// requeue subscribes to. A subject that already has the prefix is sent as is.
+// The prefix is stripped from the subject the message is republished on, which
+// is the subject sent on unless the message sets its OriginalSubject.
+func SubjectPrefix(prefix string) Option {
+	return func(o *Options) error {
+		if err := validateSubjectPrefix(prefix); err != nil {
+			return err
+		}
+		o.subjectPrefix = prefix
+		return nil
+	}
+}
+
+// RequeueSubjectPrefix has Publish send the message to requeue on the subject
+// prefixed with the prefix requeue ingests messages under, e.g., orders.created
+// is sent on requeue.orders.created with DefaultSubjectPrefix, instead of on
+// the RequeueSubject. The message is republished on the subject with the
+// prefix stripped, if it has it.
+func RequeueSubjectPrefix(prefix string) MsgOption {
+	return func(o *MsgOptions) error {
+		if err := validateSubjectPrefix(prefix); err != nil {
+			return err
+		}
+		o.subjectPrefix = prefix
+		return nil
+	}
+}
+
+func validateSubjectPrefix(prefix string) error {
+	if !strings.HasSuffix(prefix, ".") {
+		return fmt.Errorf("subject prefix must end with a '.': %q", prefix)
+	}
+	if err := protocol.ValidateSubject(strings.TrimSuffix(prefix, ".")); err != nil {
+		return fmt.Errorf("invalid subject prefix: %w", err)
+	}
+	if strings.ContainsAny(prefix, "*>") {
+		return fmt.Errorf("subject prefix cannot contain wildcards: %q", prefix)
+	}
+	return nil
+}
+
+// prefixSubject returns the subject to send a message to requeue on, which is
+// the subject with the prefix, and the subject to republish it on, which is the
+// subject without it.
+func prefixSubject(prefix, subject string) (prefixed, stripped string, err error) {
+	if err := protocol.ValidateSubject(subject); err != nil {
+		return "", "", err
+	}
+	if strings.HasPrefix(subject, prefix) {
+		prefixed, stripped = subject, strings.TrimPrefix(subject, prefix)
+	} else {
+		prefixed, stripped = prefix+subject, subject
+	}
+	if protocol.IsReservedSubject(prefixed) {
+		return "", "", fmt.Errorf("subject is reserved for requeue: %q", prefixed)
+	}
+	return prefixed, stripped, nil
+}
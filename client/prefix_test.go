@@ -0,0 +1,52 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixSubject(t *testing.T) {
+	prefixed, stripped, err := prefixSubject(DefaultSubjectPrefix, "orders.created")
+	assert.NoError(t, err)
+	assert.Equal(t, "requeue.orders.created", prefixed)
+	assert.Equal(t, "orders.created", stripped)
+
+	// A subject that already has the prefix isn't prefixed again.
+	prefixed, stripped, err = prefixSubject(DefaultSubjectPrefix, "requeue.orders.created")
+	assert.NoError(t, err)
+	assert.Equal(t, "requeue.orders.created", prefixed)
+	assert.Equal(t, "orders.created", stripped)
+
+	for _, subject := range []string{"", "orders..created", "events.created", "requeue.control.x"} {
+		_, _, err := prefixSubject(DefaultSubjectPrefix, subject)
+		assert.Error(t, err, subject)
+	}
+
+	for _, prefix := range []string{"", "requeue", "requeue..", "requeue.*.", "requeue.>."} {
+		assert.Error(t, SubjectPrefix(prefix)(&Options{}), prefix)
+		assert.Error(t, RequeueSubjectPrefix(prefix)(&MsgOptions{}), prefix)
+	}
+}
+
+func TestRequeueSubjectPrefix(t *testing.T) {
+	o, err := newMsgOptions("orders.created", nil, []MsgOption{
+		RequeueSubjectPrefix("ingest."),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ingest.orders.created", o.requeueSubject)
+	assert.Equal(t, "orders.created", o.msg.OriginalSubject)
+
+	o, err = newMsgOptions("ingest.orders.created", nil, []MsgOption{
+		RequeueSubjectPrefix("ingest."),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ingest.orders.created", o.requeueSubject)
+	assert.Equal(t, "orders.created", o.msg.OriginalSubject)
+
+	// The subject is still republished on, so it can't have wildcards.
+	_, err = newMsgOptions("orders.*", nil, []MsgOption{
+		RequeueSubjectPrefix("ingest."),
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// DefaultRequeueSubject is the subject messages are sent to requeue on by
+// Publish. It's matched by the subject requeue subscribes to by default.
+const DefaultRequeueSubject = "requeue.publish"
+
+// MsgOption is a function on the options for a message sent with Publish.
+type MsgOption func(*MsgOptions) error
+
+// MsgOptions are the options for a message sent with Publish.
+type MsgOptions struct {
+	msg            protocol.RequeueMessage
+	requeueSubject string
+	subjectPrefix  string
+}
+
+func GetDefaultMsgOptions() MsgOptions {
+	return MsgOptions{
+		msg:            protocol.DefaultRequeueMessage(),
+		requeueSubject: DefaultRequeueSubject,
+	}
+}
+
+// Retries sets the number of times requeue republishes the message until it's
+// acknowledged.
+func Retries(n uint64) MsgOption {
+	return func(o *MsgOptions) error {
+		o.msg.Retries = n
+		return nil
+	}
+}
+
+// TTL sets how long after it's persisted the message expires, after which it's
+// never republished.
+func TTL(ttl time.Duration) MsgOption {
+	return func(o *MsgOptions) error {
+		if ttl < 0 {
+			return fmt.Errorf("ttl cannot be negative: %s", ttl)
+		}
+		o.msg.TTL = uint64(ttl)
+		return nil
+	}
+}
+
+// Delay sets how long requeue waits before republishing the message. It's
+// the base of the backoff between retries.
+func Delay(delay time.Duration) MsgOption {
+	return func(o *MsgOptions) error {
+		if delay < 0 {
+			return fmt.Errorf("delay cannot be negative: %s", delay)
+		}
+		o.msg.Delay = uint64(delay)
+		return nil
+	}
+}
+
+// Backoff sets how the delay grows between retries. Without one the default
+// backoff of the requeue instance is used.
+func Backoff(b protocol.BackoffStrategy) MsgOption {
+	return func(o *MsgOptions) error {
+		o.msg.BackoffStrategy = b
+		return nil
+	}
+}
+
+// Queue sets the queue the message is stored in. It's
+// protocol.DefaultQueueName by default.
+func Queue(name string) MsgOption {
+	return func(o *MsgOptions) error {
+		if name == "" {
+			return fmt.Errorf("queue name cannot be blank")
+		}
+		o.msg.QueueName = name
+		return nil
+	}
+}
+
+// DedupeKey sets the key messages replace each other by while they're pending
+// in a coalescing queue.
+func DedupeKey(k string) MsgOption {
+	return func(o *MsgOptions) error {
+		o.msg.DedupeKey = k
+		return nil
+	}
+}
+
+// Metadata sets the metadata k of the message to v, e.g., the id of the user
+// it belongs to so it can be erased.
+func Metadata(k, v string) MsgOption {
+	return func(o *MsgOptions) error {
+		if o.msg.Metadata == nil {
+			o.msg.Metadata = make(protocol.Metadata)
+		}
+		o.msg.Metadata[k] = v
+		return nil
+	}
+}
+
+// QueueGroup sets the downstream queue group the message is intended for.
+// When requeue sends queue group headers, the members of other groups
+// subscribed to the subject can use protocol.IntendedFor to ignore it, so a
+// retried message is handled by one member of the group just like the
+// original.
+func QueueGroup(group string) MsgOption {
+	return func(o *MsgOptions) error {
+		o.msg.QueueGroup = group
+		return nil
+	}
+}
+
+// RepublishAckTimeout sets how long requeue waits for the message to be
+// acknowledged when it's republished, overriding the timeout of its queue.
+func RepublishAckTimeout(timeout time.Duration) MsgOption {
+	return func(o *MsgOptions) error {
+		if timeout < 0 {
+			return fmt.Errorf("republish ack timeout cannot be negative: %s", timeout)
+		}
+		o.msg.AckTimeout = uint64(timeout)
+		return nil
+	}
+}
+
+// RequeueSubject sets the subject the message is sent to requeue on. It's
+// DefaultRequeueSubject by default, and it's ignored when there is a
+// RequeueSubjectPrefix.
+func RequeueSubject(subject string) MsgOption {
+	return func(o *MsgOptions) error {
+		if err := protocol.ValidateSubject(subject); err != nil {
+			return fmt.Errorf("invalid requeue subject: %w", err)
+		}
+		o.requeueSubject = subject
+		return nil
+	}
+}
+
+// Publish has requeue republish the payload on the subject, as set by the
+// options, with a Producer over nc with the default options. See
+// Producer.Publish.
+func Publish(nc *nats.Conn, subject string, payload []byte, opts ...MsgOption) error {
+	p, err := NewProducer(nc)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	return p.Publish(subject, payload, opts...)
+}
+
+// Publish has requeue republish the payload on the subject, as set by the
+// options, and sends it with Send, so it's acknowledged, handed to the
+// Fallback, and fails the same way.
+func (p *Producer) Publish(subject string, payload []byte, opts ...MsgOption) error {
+	o, err := newMsgOptions(subject, payload, opts)
+	if err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+	return p.Send(o.requeueSubject, o.msg)
+}
+
+// newMsgOptions applies the options to the message that republishes the
+// payload on the subject.
+func newMsgOptions(subject string, payload []byte, opts []MsgOption) (MsgOptions, error) {
+	o := GetDefaultMsgOptions()
+	for _, opt := range opts {
+		if opt != nil {
+			if err := opt(&o); err != nil {
+				return o, err
+			}
+		}
+	}
+	if o.subjectPrefix != "" {
+		prefixed, stripped, err := prefixSubject(o.subjectPrefix, subject)
+		if err != nil {
+			return o, err
+		}
+		o.requeueSubject, subject = prefixed, stripped
+	}
+	if err := protocol.ValidateSubject(subject); err != nil {
+		return o, err
+	}
+	if strings.ContainsAny(subject, "*>") {
+		return o, fmt.Errorf("cannot republish on a wildcard subject: %q", subject)
+	}
+	o.msg.OriginalSubject = subject
+	o.msg.OriginalPayload = payload
+	return o, nil
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMsgOptions(t *testing.T) {
+	o, err := newMsgOptions("orders.created", []byte("order"), []MsgOption{
+		Retries(5),
+		TTL(time.Hour),
+		Delay(time.Second),
+		Backoff(protocol.BackoffStrategy_Exponential),
+		Queue("orders"),
+		DedupeKey("order-1"),
+		Metadata("user_id", "42"),
+		RepublishAckTimeout(10 * time.Second),
+		QueueGroup("workers"),
+		RequeueSubject("requeue.orders"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "requeue.orders", o.requeueSubject)
+
+	// The message requeue receives is the flatbuf built from the options.
+	var msg protocol.RequeueMessage
+	assert.NoError(t, msg.UnmarshalBinary(o.msg.Bytes()))
+	assert.Equal(t, "orders.created", msg.OriginalSubject)
+	assert.Equal(t, "order", string(msg.OriginalPayload))
+	assert.Equal(t, uint64(5), msg.Retries)
+	assert.Equal(t, uint64(time.Hour), msg.TTL)
+	assert.Equal(t, uint64(time.Second), msg.Delay)
+	assert.Equal(t, protocol.BackoffStrategy_Exponential, msg.BackoffStrategy)
+	assert.Equal(t, "orders", msg.QueueName)
+	assert.Equal(t, "order-1", msg.DedupeKey)
+	assert.Equal(t, protocol.Metadata{"user_id": "42"}, msg.Metadata)
+	assert.Equal(t, uint64(10*time.Second), msg.AckTimeout)
+	assert.Equal(t, "workers", msg.QueueGroup)
+
+	// The defaults.
+	o, err = newMsgOptions("orders.created", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRequeueSubject, o.requeueSubject)
+	assert.Equal(t, protocol.DefaultQueueName, o.msg.QueueName)
+	assert.False(t, protocol.IsReservedSubject(DefaultRequeueSubject))
+
+	for _, opts := range [][]MsgOption{
+		{TTL(-1)},
+		{Delay(-1)},
+		{Queue("")},
+		{RequeueSubject("requeue..orders")},
+	} {
+		_, err := newMsgOptions("orders.created", nil, opts)
+		assert.Error(t, err)
+	}
+	for _, subject := range []string{"", "orders.*", "orders.>"} {
+		_, err := newMsgOptions(subject, nil, nil)
+		assert.Error(t, err, subject)
+	}
+}
+
+func TestPublishFailsLikeSend(t *testing.T) {
+	p, err := NewProducer(nil)
+	assert.NoError(t, err)
+	defer p.Close()
+
+	sendErr := p.Send(DefaultRequeueSubject, protocol.RequeueMessage{OriginalSubject: "orders.created"})
+	assert.True(t, errors.Is(sendErr, nats.ErrInvalidConnection))
+
+	err = p.Publish("orders.created", nil)
+	assert.Equal(t, sendErr, err)
+	err = Publish(nil, "orders.created", nil)
+	assert.Equal(t, sendErr, err)
+}
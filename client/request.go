@@ -0,0 +1,97 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Outcome is the outcome of a request or publish made with
+// RequestOrRequeue or PublishOrRequeue.
+type Outcome struct {
+	// Reply is the reply to the request. It's nil if the message was
+	// requeued, or published rather than requested.
+	Reply *nats.Msg
+
+	// Requeued is true if the message was handed to requeue to be
+	// republished because the request or publish failed.
+	Requeued bool
+
+	// Cause is why the request or publish failed, if it did.
+	Cause error
+}
+
+// RequeueError is returned when a message couldn't be handed to requeue after
+// its request or publish failed, so it wasn't delivered at all.
+type RequeueError struct {
+	// Cause is why the request or publish failed.
+	Cause error
+	// Err is why requeue didn't persist the message.
+	Err error
+}
+
+func (e *RequeueError) Error() string {
+	return fmt.Sprintf("unable to requeue the message after %v: %v", e.Cause, e.Err)
+}
+
+func (e *RequeueError) Unwrap() error {
+	return e.Err
+}
+
+// RequestOrRequeue makes a request on the subject. If it fails, e.g., because
+// nothing responded in time, the message is handed to requeue to be
+// republished as set by the options, as with Publish. The request is never
+// retried if requeue rejects the message or is unreachable too, in which case
+// a *RequeueError is returned.
+func RequestOrRequeue(nc *nats.Conn, subject string, data []byte, timeout time.Duration, opts ...MsgOption) (Outcome, error) {
+	p, err := NewProducer(nc)
+	if err != nil {
+		return Outcome{}, err
+	}
+	defer p.Close()
+	return p.RequestOrRequeue(subject, data, timeout, opts...)
+}
+
+// RequestOrRequeue is like the RequestOrRequeue function, but hands the
+// message to requeue with the Producer's Publish.
+func (p *Producer) RequestOrRequeue(subject string, data []byte, timeout time.Duration, opts ...MsgOption) (Outcome, error) {
+	reply, err := p.nc.Request(subject, data, timeout)
+	if err == nil {
+		return Outcome{Reply: reply}, nil
+	}
+	return p.requeue(subject, data, err, opts)
+}
+
+// PublishOrRequeue publishes the message on the subject. If that fails, e.g.,
+// because the connection is closed or the buffer of a reconnecting connection
+// is full, the message is handed to requeue to be republished as set by the
+// options, as with Publish. A *RequeueError is returned if requeue doesn't
+// persist it either.
+func PublishOrRequeue(nc *nats.Conn, subject string, data []byte, opts ...MsgOption) (Outcome, error) {
+	p, err := NewProducer(nc)
+	if err != nil {
+		return Outcome{}, err
+	}
+	defer p.Close()
+	return p.PublishOrRequeue(subject, data, opts...)
+}
+
+// PublishOrRequeue is like the PublishOrRequeue function, but hands the
+// message to requeue with the Producer's Publish.
+func (p *Producer) PublishOrRequeue(subject string, data []byte, opts ...MsgOption) (Outcome, error) {
+	err := p.nc.Publish(subject, data)
+	if err == nil {
+		return Outcome{}, nil
+	}
+	return p.requeue(subject, data, err, opts)
+}
+
+func (p *Producer) requeue(subject string, data []byte, cause error, opts []MsgOption) (Outcome, error) {
+	o := Outcome{Cause: cause}
+	if err := p.Publish(subject, data, opts...); err != nil {
+		return o, &RequeueError{Cause: cause, Err: err}
+	}
+	o.Requeued = true
+	return o, nil
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestOrRequeue(t *testing.T) {
+	// Without a connection neither the request nor requeue can be reached.
+	o, err := RequestOrRequeue(nil, "orders.created", []byte("order"), time.Second, Retries(3))
+	var rErr *RequeueError
+	if assert.True(t, errors.As(err, &rErr)) {
+		assert.Equal(t, nats.ErrInvalidConnection, rErr.Cause)
+	}
+	assert.True(t, errors.Is(err, nats.ErrInvalidConnection))
+	assert.False(t, o.Requeued)
+	assert.Nil(t, o.Reply)
+	assert.Equal(t, nats.ErrInvalidConnection, o.Cause)
+
+	o, err = PublishOrRequeue(nil, "orders.created", []byte("order"))
+	assert.True(t, errors.As(err, &rErr))
+	assert.False(t, o.Requeued)
+
+	// The options are still validated before requeueing.
+	_, err = PublishOrRequeue(nil, "orders.created", nil, TTL(-1))
+	assert.True(t, errors.As(err, &rErr))
+	assert.False(t, errors.Is(err, nats.ErrInvalidConnection))
+}
@@ -0,0 +1,261 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// lenSize is the size of the length prefixes of the fields of a record.
+const lenSize = 4
+
+// spool is an append only file of messages waiting to be sent. Each record is
+// the subject followed by the data, both prefixed by their big endian uint32
+// length.
+type spool struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+	n  int
+
+	// Held while replaying so only one replay runs at a time. Appending
+	// doesn't wait on it.
+	replayMu sync.Mutex
+}
+
+func openSpool(path string) (*spool, error) {
+	s := &spool{path: path}
+	records, torn, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	if torn {
+		// Drop the partial record so new ones aren't appended after it.
+		return s, s.truncate(records)
+	}
+	s.n = len(records)
+	return s, s.open()
+}
+
+func (s *spool) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("open spool: %w", err)
+	}
+	s.f = f
+	return nil
+}
+
+type spoolRecord struct {
+	subject string
+	data    []byte
+}
+
+func (r spoolRecord) bytes() []byte {
+	b := make([]byte, 0, 2*lenSize+len(r.subject)+len(r.data))
+	b = appendField(b, []byte(r.subject))
+	return appendField(b, r.data)
+}
+
+func appendField(b, field []byte) []byte {
+	var l [lenSize]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(field)))
+	return append(append(b, l[:]...), field...)
+}
+
+// append adds the message to the end of the spool. It's synced to disk before
+// returning.
+func (s *spool) append(subject string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return fmt.Errorf("spool is closed")
+	}
+	if _, err := s.f.Write(spoolRecord{subject: subject, data: data}.bytes()); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.n++
+	return nil
+}
+
+// len returns the number of messages in the spool.
+func (s *spool) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n
+}
+
+// read returns every record in the spool. A partially written record at the
+// end, from a crash while appending, is ignored and torn is true.
+func (s *spool) read() (records []spoolRecord, torn bool, err error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("read spool: %w", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, false, fmt.Errorf("read spool: %w", err)
+	}
+
+	records = make([]spoolRecord, 0)
+	left := fi.Size()
+	r := bufio.NewReader(f)
+	for {
+		subject, err := readField(r, &left)
+		if err == io.EOF {
+			return records, false, nil
+		}
+		var msg []byte
+		if err == nil {
+			msg, err = readField(r, &left)
+		}
+		if err != nil {
+			log.Warn().Err(err).
+				Str("path", s.path).
+				Msg("ignoring the partially written record at the end of the spool")
+			return records, true, nil
+		}
+		records = append(records, spoolRecord{subject: string(subject), data: msg})
+	}
+}
+
+// readField reads a length prefixed field, with left being the number of bytes
+// left to read. A length longer than what's left, e.g., from a torn or corrupt
+// prefix, is an error rather than allocated.
+func readField(r io.Reader, left *int64) ([]byte, error) {
+	var l [lenSize]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	*left -= lenSize
+	n := binary.BigEndian.Uint32(l[:])
+	if int64(n) > *left {
+		return nil, fmt.Errorf("field of %d bytes is longer than the %d left: %w", n, *left, io.ErrUnexpectedEOF)
+	}
+	field := make([]byte, n)
+	if _, err := io.ReadFull(r, field); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	*left -= int64(n)
+	return field, nil
+}
+
+// replay calls f with each message in the order they were spooled until it
+// returns an error. The messages f succeeded on are removed from the spool.
+// The lock isn't held while calling f, so messages can be appended in the
+// meantime. They are left in the spool for the next replay.
+func (s *spool) replay(f func(subject string, data []byte) error) error {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	s.mu.Lock()
+	if s.f == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("spool is closed")
+	}
+	records, _, err := s.read()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	sent := 0
+	var fErr error
+	for _, r := range records {
+		if fErr = f(r.subject, r.data); fErr != nil {
+			break
+		}
+		sent++
+	}
+	if sent == 0 {
+		return fErr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		// What was sent is sent again by the next Producer to use the spool.
+		return fmt.Errorf("spool is closed")
+	}
+	// Only replays remove records, so the ones sent are still the first,
+	// followed by any appended while sending.
+	records, _, err = s.read()
+	if err != nil {
+		return err
+	}
+	if err := s.truncate(records[sent:]); err != nil {
+		return err
+	}
+	return fErr
+}
+
+// truncate replaces the spool with the remaining records. The new spool is
+// written beside the old one and renamed over it so a crash never loses
+// messages, although it can cause some to be sent twice.
+func (s *spool) truncate(remaining []spoolRecord) error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("truncate spool: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for _, r := range remaining {
+		if _, err := w.Write(r.bytes()); err != nil {
+			f.Close()
+			return fmt.Errorf("truncate spool: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("truncate spool: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("truncate spool: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("truncate spool: %w", err)
+	}
+
+	if s.f != nil {
+		if err := s.f.Close(); err != nil {
+			return fmt.Errorf("truncate spool: %w", err)
+		}
+		s.f = nil
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("truncate spool: %w", err)
+	}
+	s.n = len(remaining)
+	return s.open()
+}
+
+// close waits for a replay to finish before closing the spool.
+func (s *spool) close() error {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "requeue-spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spool")
+
+	s, err := openSpool(path)
+	assert.NoError(t, err)
+	assert.NoError(t, s.append("requeue.a", []byte("1")))
+	assert.NoError(t, s.append("requeue.b", []byte("2")))
+	assert.NoError(t, s.append("requeue.c", []byte("3")))
+	assert.NoError(t, s.close())
+
+	// Reopening picks up what was spooled.
+	s, err = openSpool(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, s.len())
+
+	// A failure stops the replay and leaves the rest in the spool.
+	unreachable := errors.New("unreachable")
+	sent := make([]string, 0)
+	err = s.replay(func(subject string, data []byte) error {
+		if subject == "requeue.b" {
+			return unreachable
+		}
+		sent = append(sent, string(data))
+		return nil
+	})
+	assert.Equal(t, unreachable, err)
+	assert.Equal(t, []string{"1"}, sent)
+	assert.Equal(t, 2, s.len())
+
+	assert.NoError(t, s.append("requeue.d", []byte("4")))
+	assert.NoError(t, s.replay(func(subject string, data []byte) error {
+		sent = append(sent, string(data))
+		return nil
+	}))
+	assert.Equal(t, []string{"1", "2", "3", "4"}, sent)
+	assert.Equal(t, 0, s.len())
+	assert.NoError(t, s.close())
+}
+
+func TestSpoolPartialRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "requeue-spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spool")
+
+	b := spoolRecord{subject: "requeue.a", data: []byte("1")}.bytes()
+	partial := spoolRecord{subject: "requeue.b", data: []byte("2")}.bytes()
+	assert.NoError(t, ioutil.WriteFile(path, append(b, partial[:len(partial)-1]...), 0600))
+
+	s, err := openSpool(path)
+	assert.NoError(t, err)
+	defer s.close()
+	assert.Equal(t, 1, s.len())
+
+	// New records go after the last whole one.
+	assert.NoError(t, s.append("requeue.c", []byte("3")))
+	records, torn, err := s.read()
+	assert.NoError(t, err)
+	assert.False(t, torn)
+	assert.Equal(t, []spoolRecord{
+		{subject: "requeue.a", data: []byte("1")},
+		{subject: "requeue.c", data: []byte("3")},
+	}, records)
+}
+
+func TestSpoolAppendWhileReplaying(t *testing.T) {
+	dir, err := ioutil.TempDir("", "requeue-spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := openSpool(filepath.Join(dir, "spool"))
+	assert.NoError(t, err)
+	defer s.close()
+	assert.NoError(t, s.append("requeue.a", []byte("1")))
+	assert.NoError(t, s.append("requeue.b", []byte("2")))
+
+	// Appending and counting don't wait on the messages being sent.
+	sent := make([]string, 0)
+	assert.NoError(t, s.replay(func(subject string, data []byte) error {
+		if subject == "requeue.a" {
+			appended := make(chan error, 1)
+			go func() {
+				appended <- s.append("requeue.c", []byte("3"))
+			}()
+			select {
+			case err := <-appended:
+				assert.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("appending waited on the replay")
+			}
+			assert.Equal(t, 3, s.len())
+		}
+		sent = append(sent, string(data))
+		return nil
+	}))
+	assert.Equal(t, []string{"1", "2"}, sent)
+
+	// What was appended while replaying is left for the next replay.
+	records, torn, err := s.read()
+	assert.NoError(t, err)
+	assert.False(t, torn)
+	assert.Equal(t, []spoolRecord{{subject: "requeue.c", data: []byte("3")}}, records)
+	assert.Equal(t, 1, s.len())
+}
+
+func TestSpoolCorruptLength(t *testing.T) {
+	dir, err := ioutil.TempDir("", "requeue-spool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spool")
+
+	// The length of the second record claims far more than is in the file.
+	b := spoolRecord{subject: "requeue.a", data: []byte("1")}.bytes()
+	b = append(b, 0xff, 0xff, 0xff, 0xff, 'r')
+	assert.NoError(t, ioutil.WriteFile(path, b, 0600))
+
+	s, err := openSpool(path)
+	assert.NoError(t, err)
+	defer s.close()
+	assert.Equal(t, 1, s.len())
+}
@@ -0,0 +1,48 @@
+package requeue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// CoalesceQueue makes the queue a coalescing queue. A message with a dedupe
+// key replaces the pending message with the same dedupe key, so state refresh
+// style workloads only republish the latest snapshot instead of the whole
+// backlog. Messages without a dedupe key are queued as usual. With time
+// bucketing, messages only replace each other within the same sub-queue.
+//
+// Writes to a coalescing queue are not batched since each replace has to be
+// done atomically.
+func CoalesceQueue(queueName string) Option {
+	return func(o *Options) error {
+		if queueName == "" {
+			return fmt.Errorf("coalesce queue: queue name cannot be blank")
+		}
+		if o.coalescingQueues == nil {
+			o.coalescingQueues = make(map[string]bool)
+		}
+		o.coalescingQueues[queueName] = true
+		return nil
+	}
+}
+
+// coalesceMessage persists the message in place of any pending message with
+// the same dedupe key. False is returned if the message isn't to be coalesced
+// and has to be added as usual.
+func (c *Conn) coalesceMessage(q *queue.Queue, qk queue.QueueKey, data []byte, fb *flatbuf.RequeueMessage, msg *nats.Msg, received time.Time) bool {
+	dedupeKey := fb.DedupeKey()
+	if len(dedupeKey) == 0 || !c.Opts.coalescingQueues[protocol.GetQueueName(fb)] {
+		return false
+	}
+	_, err := q.ReplaceMessage(qk.Bytes(), data, c.storageTTL(fb), string(dedupeKey))
+	if err != nil {
+		c.badgerWriteMsgErr(msg, err)
+	}
+	c.processIngressMessageCallback(q, qk, msg, received)(err)
+	return true
+}
@@ -0,0 +1,67 @@
+package requeue
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// CompatibilityRevision makes the instance emit an older protocol.Revision of
+// the envelopes it stores and the stats it publishes. Use it while upgrading a
+// fleet so the instances that haven't been upgraded yet can read them. Once
+// every instance has been upgraded, send a protocol.CompatRequest for the
+// protocol.CurrentRevision on protocol.CompatSubject, or restart without this
+// option.
+func CompatibilityRevision(r protocol.Revision) Option {
+	return func(o *Options) error {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("compatibility revision: %w", err)
+		}
+		o.revision = r
+		return nil
+	}
+}
+
+// Revision returns the revision of the messages the instance emits.
+func (c *Conn) Revision() protocol.Revision {
+	return protocol.Revision(atomic.LoadInt32(&c.revision))
+}
+
+// SetRevision changes the revision of the messages the instance emits.
+func (c *Conn) SetRevision(r protocol.Revision) error {
+	if err := r.Validate(); err != nil {
+		return fmt.Errorf("set revision: %w", err)
+	}
+	if old := protocol.Revision(atomic.SwapInt32(&c.revision, int32(r))); old != r {
+		log.Info().
+			Int("from", int(old)).
+			Int("to", int(r)).
+			Msg("changed the revision of the messages emitted")
+	}
+	return nil
+}
+
+func (c *Conn) handleCompatRequest(msg *nats.Msg) {
+	req := protocol.CompatRequest{}
+	report := protocol.CompatReport{InstanceID: c.instanceId}
+	if err := req.UnmarshalBinary(msg.Data); err != nil {
+		report.Error = fmt.Sprintf("invalid compat request: %s", err)
+	} else if err := c.SetRevision(req.Revision); err != nil {
+		report.Error = err.Error()
+	}
+	report.Revision = c.Revision()
+	report.Time = time.Now()
+
+	data, err := report.MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("unable to marshal compat report")
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Err(err).Msg("unable to respond to compat request")
+	}
+}
@@ -0,0 +1,96 @@
+package requeue
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger/v2/options"
+	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+)
+
+// Compression is how the tables of the store are compressed.
+type Compression int
+
+const (
+	// CompressionNone leaves the tables uncompressed. This is the default.
+	CompressionNone Compression = iota
+
+	// CompressionSnappy is fast and compresses text payloads about 2x.
+	CompressionSnappy
+
+	// CompressionZSTD is slower to write but compresses large text payloads
+	// 5-10x. It's only available when built with cgo.
+	CompressionZSTD
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZSTD:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+}
+
+func (c Compression) badger() options.CompressionType {
+	switch c {
+	case CompressionSnappy:
+		return options.Snappy
+	case CompressionZSTD:
+		return options.ZSTD
+	default:
+		return options.None
+	}
+}
+
+// DefaultBlockCacheSize is a sensible block cache size to use along with
+// compression.
+const DefaultBlockCacheSize = 256 << 20
+
+// TableCompression sets how the tables of the store are compressed. Only
+// tables written from then on are compressed. Compression trades CPU for
+// less disk and page cache, and pays off for large text payloads. Use it with
+// a BlockCacheSize so reads don't decompress a block every time.
+func TableCompression(c Compression) Option {
+	return func(o *Options) error {
+		switch c {
+		case CompressionNone, CompressionSnappy:
+		case CompressionZSTD:
+			if !badgerInternal.ZSTDSupported() {
+				return fmt.Errorf("table compression: zstd requires building with cgo")
+			}
+		default:
+			return fmt.Errorf("table compression: unknown compression: %s", c)
+		}
+		o.compression = c
+		return nil
+	}
+}
+
+// ZSTDCompressionLevel sets the level of ZSTD compression, from 1, the
+// fastest and the default, to 22, the smallest.
+func ZSTDCompressionLevel(level int) Option {
+	return func(o *Options) error {
+		if level < 1 || level > 22 {
+			return fmt.Errorf("zstd compression level must be from 1 to 22: %d", level)
+		}
+		o.zstdLevel = level
+		return nil
+	}
+}
+
+// BlockCacheSize sets the size in bytes of the cache of decompressed table
+// blocks. It's disabled by default since without compression it only adds
+// overhead.
+func BlockCacheSize(size int64) Option {
+	return func(o *Options) error {
+		if size < 0 {
+			return fmt.Errorf("block cache size cannot be negative: %d", size)
+		}
+		o.blockCacheSize = size
+		return nil
+	}
+}
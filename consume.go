@@ -0,0 +1,77 @@
+package requeue
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultConsumerBatchSize is how many messages a consumer takes per read by
+// default. BenchmarkConsume shows most of the saving is had by 16 messages;
+// bigger batches save little more and buffer more messages that aren't acked.
+const DefaultConsumerBatchSize = 16
+
+// ConsumerBatchSize sets the max number of messages each consumer takes from
+// the subscription per read before processing them, rather than going back
+// for them one at a time. Only messages that are already waiting are taken so
+// it never holds a message back. The subscription buffers enough messages for
+// every consumer to fill a batch. One disables batching.
+func ConsumerBatchSize(n int) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return fmt.Errorf("consumer batch size must be positive: %d", n)
+		}
+		o.consumerBatchSize = n
+		return nil
+	}
+}
+
+// consumerBuffer returns the number of messages buffered between the
+// subscription and the consumers.
+func (o Options) consumerBuffer() int {
+	if o.consumerBatchSize <= 1 {
+		return 0
+	}
+	return o.consumerBatchSize * o.numConsumers
+}
+
+// consume calls f with every message received on ch, up to size of them per
+// read, until ctx is done or closed is closed.
+func consume(ctx context.Context, closed <-chan struct{}, ch <-chan *nats.Msg, size int, f func(*nats.Msg)) {
+	batch := make([]*nats.Msg, 0, size)
+	for {
+		select {
+		case msg := <-ch:
+			batch = append(batch[:0], msg)
+		case <-ctx.Done():
+			// Whatever is pending in the queues is flushed as they see the
+			// context is done too.
+			return
+		case <-closed:
+			// The consumer has been asked to close.
+			return
+		}
+
+		// Take whatever else is already waiting.
+	fill:
+		for len(batch) < size {
+			select {
+			case msg := <-ch:
+				batch = append(batch, msg)
+			default:
+				break fill
+			}
+		}
+
+		for i, msg := range batch {
+			// Check the context between every message. The messages left
+			// behind were never acked so they'll be sent again.
+			if ctx.Err() != nil {
+				return
+			}
+			f(msg)
+			batch[i] = nil
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package requeue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	closed := make(chan struct{})
+	ch := make(chan *nats.Msg, 8)
+	for i := 0; i < 8; i++ {
+		ch <- &nats.Msg{Subject: fmt.Sprint(i)}
+	}
+
+	var got []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consume(ctx, closed, ch, 3, func(msg *nats.Msg) {
+			got = append(got, msg.Subject)
+			if len(got) == 5 {
+				cancel()
+			}
+		})
+	}()
+	<-done
+
+	// It stops between messages of a batch once the context is done.
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, got)
+	assert.Len(t, ch, 2)
+}
+
+// BenchmarkConsume measures the cost per message of handing messages from the
+// subscription to the consumers for a range of batch sizes.
+func BenchmarkConsume(b *testing.B) {
+	const consumers = DefaultNumConcurrentBatchTransactions
+	for _, size := range []int{1, 4, 16, 64, 256} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			o := GetDefaultOptions()
+			o.consumerBatchSize = size
+			ch := make(chan *nats.Msg, o.consumerBuffer())
+			ctx, cancel := context.WithCancel(context.Background())
+			closed := make(chan struct{})
+			msg := &nats.Msg{}
+
+			var wg sync.WaitGroup
+			wg.Add(b.N)
+			for i := 0; i < consumers; i++ {
+				go consume(ctx, closed, ch, size, func(*nats.Msg) { wg.Done() })
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ch <- msg
+			}
+			wg.Wait()
+			b.StopTimer()
+			cancel()
+		})
+	}
+}
+
+func TestRecoverIngressMessage(t *testing.T) {
+	c := NewConn(GetDefaultOptions())
+
+	// The panic is recovered and the message rejected so the consumer
+	// carries on with the next one.
+	assert.NotPanics(t, func() {
+		defer c.recoverIngressMessage(&nats.Msg{Subject: "requeue.in", Reply: "inbox.1"})
+		panic("boom")
+	})
+	assert.Equal(t, int64(1), c.counters.Rejected()[string(protocol.NakReasonInternalError)])
+}
@@ -0,0 +1,183 @@
+package requeue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// initControl subscribes to the control subjects of the instance.
+func (c *Conn) initControl() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The subscriptions go away when nats is drained.
+	subs := map[string]nats.MsgHandler{
+		protocol.BacklogReportSubject(c.instanceId):  c.handleBacklogRequest,
+		protocol.InfoSubject:                         c.handleInfoRequest,
+		protocol.InstanceInfoSubject(c.instanceId):   c.handleInfoRequest,
+		protocol.CompatSubject:                       c.handleCompatRequest,
+		protocol.InstanceCompatSubject(c.instanceId): c.handleCompatRequest,
+		protocol.KeyRotationSubject(c.instanceId):    c.handleKeyRotationRequest,
+		protocol.EraseSubject(c.instanceId):          c.handleEraseRequest,
+		protocol.ExportSubject(c.instanceId):         c.handleExportRequest,
+		protocol.StateReportSubject(c.instanceId):    c.handleStateRequest,
+		protocol.RejectionsSubject(c.instanceId):     c.handleRejectionsRequest,
+		protocol.MembershipSubject(c.instanceId):     c.handleMembershipRequest,
+		protocol.OperationsSubject(c.instanceId):     c.handleOperationsRequest,
+		protocol.ReplaySubject(c.instanceId):         c.handleReplayRequest,
+	}
+	for subj, h := range subs {
+		if _, err := c.nc.Subscribe(subj, h); err != nil {
+			return fmt.Errorf("init control: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *Conn) handleBacklogRequest(msg *nats.Msg) {
+	req := protocol.BacklogRequest{}
+	var report protocol.BacklogReport
+	if err := req.UnmarshalBinary(msg.Data); err != nil {
+		report = protocol.BacklogReport{
+			InstanceID: c.instanceId,
+			Error:      fmt.Sprintf("invalid backlog request: %s", err),
+			Time:       time.Now(),
+		}
+	} else {
+		var err error
+		report, err = c.BacklogReport(c.Opts.ctx, req)
+		if err != nil {
+			report.Error = err.Error()
+		}
+	}
+
+	report.Version = Version
+	report.Features = c.Features()
+	data, err := report.MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("unable to marshal backlog report")
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Err(err).Msg("unable to respond to backlog request")
+	}
+}
+
+// BacklogReport scans the queue and reports the original subjects with the
+// largest backlog. The sub-queues of a time bucketed queue are included.
+func (c *Conn) BacklogReport(ctx context.Context, req protocol.BacklogRequest) (protocol.BacklogReport, error) {
+	report := protocol.BacklogReport{
+		InstanceID: c.instanceId,
+		Queue:      req.Queue,
+		Subjects:   make([]protocol.SubjectBacklog, 0),
+		Time:       time.Now(),
+	}
+	if req.Queue == "" {
+		return report, fmt.Errorf("backlog report: queue name cannot be blank")
+	}
+	switch req.OrderBy {
+	case "", protocol.BacklogOrderCount, protocol.BacklogOrderBytes:
+	default:
+		return report, fmt.Errorf("backlog report: unknown order: %q", req.OrderBy)
+	}
+	n := req.N
+	if n <= 0 {
+		n = protocol.DefaultBacklogTopN
+	}
+
+	c.mu.RLock()
+	db := c.badgerDB
+	qManager := c.qManager
+	c.mu.RUnlock()
+	if db == nil || qManager == nil {
+		return report, fmt.Errorf("backlog report: queue manager is not running")
+	}
+
+	bySubject := make(map[string]*protocol.SubjectBacklog)
+	for _, q := range qManager.Queues() {
+		base, _ := queue.SplitBucketName(q.Name())
+		if base != req.Queue {
+			continue
+		}
+		backlog, err := queue.BacklogBySubject(ctx, db, q.Name())
+		if err != nil {
+			return report, fmt.Errorf("backlog report: %s: %w", q.Name(), err)
+		}
+		for _, b := range backlog {
+			s, ok := bySubject[b.Subject]
+			if !ok {
+				s = &protocol.SubjectBacklog{Subject: b.Subject}
+				bySubject[b.Subject] = s
+			}
+			s.Count += b.Count
+			s.Bytes += b.Bytes
+		}
+	}
+
+	for _, s := range bySubject {
+		report.Subjects = append(report.Subjects, *s)
+		report.Count += s.Count
+		report.Bytes += s.Bytes
+	}
+	queue.SortBacklog(report.Subjects, req.OrderBy)
+	if len(report.Subjects) > n {
+		report.Subjects = report.Subjects[:n]
+	}
+	return report, nil
+}
+
+func (c *Conn) handleStateRequest(msg *nats.Msg) {
+	report, err := c.StateReport()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	data, err := report.MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("unable to marshal state report")
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Err(err).Msg("unable to respond to state request")
+	}
+}
+
+// StateReport reports how much of the state bucket each queue takes up. Only
+// the latest version of each state property is kept when the store is
+// compacted, so the versions of a queue should stay close to its properties.
+func (c *Conn) StateReport() (protocol.StateReport, error) {
+	report := protocol.StateReport{
+		InstanceID: c.instanceId,
+		Queues:     make([]protocol.QueueState, 0),
+		Time:       time.Now(),
+	}
+
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if db == nil {
+		return report, fmt.Errorf("state report: store is not open")
+	}
+
+	sizes, err := queue.StateSizes(db)
+	if err != nil {
+		return report, fmt.Errorf("state report: %w", err)
+	}
+	for _, s := range sizes {
+		report.Queues = append(report.Queues, protocol.QueueState{
+			Queue:      s.Queue,
+			Properties: s.Properties,
+			Versions:   s.Versions,
+			Bytes:      s.Bytes,
+		})
+		report.Properties += s.Properties
+		report.Versions += s.Versions
+		report.Bytes += s.Bytes
+	}
+	return report, nil
+}
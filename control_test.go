@@ -0,0 +1,43 @@
+package requeue
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	assert.NoError(t, c.initQueueManager())
+
+	q, err := c.qManager.CreateQueue(queue.NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, q.UpdateCheckpoint(queue.FirstMessage("orders").Bytes()))
+	}
+	_, err = c.qManager.CreateQueue(queue.NewQueueKeyForState("emails", ""))
+	assert.NoError(t, err)
+
+	report, err := c.StateReport()
+	assert.NoError(t, err)
+	assert.Empty(t, report.Error)
+	if assert.Len(t, report.Queues, 2) {
+		assert.Equal(t, "emails", report.Queues[0].Queue)
+		assert.Equal(t, "orders", report.Queues[1].Queue)
+		assert.Equal(t, int64(1), report.Queues[1].Properties)
+		assert.True(t, report.Queues[1].Versions >= 1)
+	}
+	assert.Equal(t, int64(2), report.Properties)
+	assert.True(t, report.Bytes > 0)
+}
@@ -0,0 +1,215 @@
+package requeue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// CrashDumps writes a crash dump of the in-memory state, i.e., the counters,
+// channel depths, queue stats, and the keys of the last n messages each
+// consumer ingested, to a file in the data directory when a consumer panics or
+// a panic reaches Conn.DumpOnPanic. Zero, the default, disables them.
+func CrashDumps(n int) Option {
+	return func(o *Options) error {
+		if n < 0 {
+			return fmt.Errorf("crash dump keys cannot be negative: %d", n)
+		}
+		o.crashDumpKeys = n
+		return nil
+	}
+}
+
+// DumpOnPanic writes a crash dump, if they're enabled, when the goroutine it's
+// deferred in panics and then panics again with the same value. A panic can
+// only be recovered in the goroutine it happened in so defer it at the top of
+// main and of any goroutine using the Conn.
+func (c *Conn) DumpOnPanic() {
+	if r := recover(); r != nil {
+		c.writeCrashDump(r, debug.Stack())
+		panic(r)
+	}
+}
+
+// crashDump is what's written to the crash file.
+type crashDump struct {
+	InstanceID string    `json:"instance_id"`
+	Time       time.Time `json:"time"`
+	Panic      string    `json:"panic"`
+	Stack      string    `json:"stack"`
+
+	ConsumersAlive int32          `json:"consumers_alive"`
+	Consumers      []consumerDump `json:"consumers"`
+
+	// The number of messages waiting for a consumer and the number of hooks
+	// waiting to run.
+	IngestChannel       channelDepth `json:"ingest_channel"`
+	SubscriptionPending int          `json:"subscription_pending"`
+	HookQueue           channelDepth `json:"hook_queue"`
+	DroppedHooks        uint64       `json:"dropped_hooks"`
+
+	Rejected       protocol.ReasonCounts        `json:"rejected,omitempty"`
+	TopIngested    protocol.SubjectCounts       `json:"top_ingested,omitempty"`
+	TopRepublished protocol.SubjectCounts       `json:"top_republished,omitempty"`
+	TopNoReply     protocol.SubjectCounts       `json:"top_no_reply,omitempty"`
+	Queues         []protocol.QueueStatsMessage `json:"queues,omitempty"`
+}
+
+type consumerDump struct {
+	ID int `json:"id"`
+	// The keys of the last messages the consumer ingested, oldest first.
+	RecentKeys []recentKey `json:"recent_keys"`
+}
+
+type recentKey struct {
+	Queue string `json:"queue"`
+	Key   string `json:"key"`
+}
+
+type channelDepth struct {
+	Len int `json:"len"`
+	Cap int `json:"cap"`
+}
+
+// crashTopSubjects is how many of the top subjects are included in a dump.
+const crashTopSubjects = 10
+
+// writeCrashDump writes the crash dump for the panic r if they're enabled.
+func (c *Conn) writeCrashDump(r interface{}, stack []byte) {
+	if c.Opts.crashDumpKeys == 0 {
+		return
+	}
+	d := crashDump{
+		InstanceID:     c.instanceId,
+		Time:           time.Now(),
+		Panic:          fmt.Sprint(r),
+		Stack:          string(stack),
+		ConsumersAlive: atomic.LoadInt32(&c.natsConsumersAlive),
+		Consumers:      c.consumers.dump(),
+		IngestChannel:  channelDepth{Len: len(c.natsMsgCh), Cap: cap(c.natsMsgCh)},
+		DroppedHooks:   c.hooks.Dropped(),
+		Rejected:       c.counters.Rejected(),
+		TopIngested:    c.counters.TopIngested(crashTopSubjects),
+		TopRepublished: c.counters.TopRepublished(crashTopSubjects),
+		TopNoReply:     c.counters.TopNoReply(crashTopSubjects),
+	}
+	d.HookQueue.Len, d.HookQueue.Cap = c.hooks.Pending()
+
+	c.mu.RLock()
+	sub := c.sub
+	qManager := c.qManager
+	dataDir := c.Opts.dataDir
+	c.mu.RUnlock()
+	if sub != nil {
+		d.SubscriptionPending, _, _ = sub.Pending()
+	}
+	if qManager != nil {
+		for _, q := range qManager.Queues() {
+			d.Queues = append(d.Queues, q.Stats.QueueStatsMessage())
+		}
+	}
+
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		log.Err(err).Msg("problem marshaling crash dump")
+		return
+	}
+	// There's nowhere to write it without a data dir.
+	if dataDir == "" {
+		log.Error().RawJSON("dump", data).Msg("crash dump")
+		return
+	}
+	path := filepath.Join(dataDir, fmt.Sprintf("crash-%s-%d.json", c.instanceId, d.Time.UnixNano()))
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		log.Err(err).Str("path", path).Msg("problem writing crash dump")
+		return
+	}
+	log.Error().Str("path", path).Msg("wrote crash dump")
+}
+
+// consumerRegistry keeps the keys of the last messages each running consumer
+// ingested for the crash dumps.
+type consumerRegistry struct {
+	mu        sync.Mutex
+	nextID    int
+	consumers map[int]*recentKeys
+}
+
+// add registers a consumer keeping its last n keys. The keys are nil when n is
+// zero, which is safe to use.
+func (r *consumerRegistry) add(n int) (int, *recentKeys) {
+	if n == 0 {
+		return 0, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.consumers == nil {
+		r.consumers = make(map[int]*recentKeys)
+	}
+	r.nextID++
+	keys := &recentKeys{keys: make([]queue.QueueKey, n)}
+	r.consumers[r.nextID] = keys
+	return r.nextID, keys
+}
+
+func (r *consumerRegistry) remove(id int) {
+	r.mu.Lock()
+	delete(r.consumers, id)
+	r.mu.Unlock()
+}
+
+func (r *consumerRegistry) dump() []consumerDump {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]consumerDump, 0, len(r.consumers))
+	for id, keys := range r.consumers {
+		out = append(out, consumerDump{ID: id, RecentKeys: keys.snapshot()})
+	}
+	return out
+}
+
+// recentKeys is a ring of the last keys of messages ingested by a consumer.
+type recentKeys struct {
+	mu   sync.Mutex
+	keys []queue.QueueKey
+	next int
+	full bool
+}
+
+func (r *recentKeys) add(qk queue.QueueKey) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.keys[r.next] = qk
+	r.next = (r.next + 1) % len(r.keys)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+// snapshot returns the keys, oldest first.
+func (r *recentKeys) snapshot() []recentKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ordered := r.keys[:r.next]
+	if r.full {
+		ordered = append(append([]queue.QueueKey(nil), r.keys[r.next:]...), ordered...)
+	}
+	out := make([]recentKey, len(ordered))
+	for i, qk := range ordered {
+		out[i] = recentKey{Queue: qk.Name, Key: key.Key(qk.Key).String()}
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package requeue
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecentKeys(t *testing.T) {
+	var r consumerRegistry
+	id, keys := r.add(0)
+	assert.Nil(t, keys)
+	keys.add(queue.NewQueueKeyForMessage("orders", key.New(time.Now())))
+	r.remove(id)
+
+	_, keys = r.add(2)
+	qks := make([]queue.QueueKey, 3)
+	for i := range qks {
+		qks[i] = queue.NewQueueKeyForMessage("orders", key.New(time.Now()))
+	}
+	keys.add(qks[0])
+	assert.Equal(t, []recentKey{{Queue: "orders", Key: key.Key(qks[0].Key).String()}}, keys.snapshot())
+
+	// Only the last ones are kept, oldest first.
+	keys.add(qks[1])
+	keys.add(qks[2])
+	assert.Equal(t, []recentKey{
+		{Queue: "orders", Key: key.Key(qks[1].Key).String()},
+		{Queue: "orders", Key: key.Key(qks[2].Key).String()},
+	}, keys.snapshot())
+
+	dump := r.dump()
+	assert.Len(t, dump, 1)
+	assert.Len(t, dump[0].RecentKeys, 2)
+}
+
+func TestDumpOnPanic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crash-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	assert.NoError(t, CrashDumps(4)(&o))
+	c := NewConn(o)
+	_, keys := c.consumers.add(o.crashDumpKeys)
+	keys.add(queue.NewQueueKeyForMessage("orders", key.New(time.Now())))
+
+	assert.PanicsWithValue(t, "boom", func() {
+		defer c.DumpOnPanic()
+		panic("boom")
+	})
+
+	files, err := filepath.Glob(filepath.Join(dir, "crash-*.json"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	data, err := ioutil.ReadFile(files[0])
+	assert.NoError(t, err)
+	var d crashDump
+	assert.NoError(t, json.Unmarshal(data, &d))
+	assert.Equal(t, "boom", d.Panic)
+	assert.Contains(t, d.Stack, "TestDumpOnPanic")
+	assert.Len(t, d.Consumers, 1)
+	assert.Equal(t, "orders", d.Consumers[0].RecentKeys[0].Queue)
+	assert.Equal(t, o.hookQueueSize, d.HookQueue.Cap)
+}
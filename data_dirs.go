@@ -0,0 +1,296 @@
+package requeue
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/internal/reaper"
+	"github.com/rs/zerolog/log"
+)
+
+// DataDirs sets the directories the store may be in, in order of preference.
+// The first is the DataDir and the rest are where the store fails over to, in
+// order, when Connect finds it can't write to the ones before them. The
+// messages left in a data dir that was failed over from are still
+// republished, by reaping the instances in it into the store like any other,
+// for as long as they can be read.
+//
+// Once connected, the store is probed on every health check. If it can no
+// longer be written to, the store fails over to the next data dir that can be
+// used and the one it was in is reaped into it like any other that was failed
+// over from. If there is none left, the instance is reported as degraded and
+// not ready, and it leaves the ingest queue group so new messages go to the
+// other instances, while the messages it has keep being republished. It joins
+// again if the store recovers.
+func DataDirs(dirs ...string) Option {
+	return func(o *Options) error {
+		if len(dirs) == 0 {
+			return fmt.Errorf("data dirs cannot be empty")
+		}
+		for _, dir := range dirs {
+			if strings.TrimSpace(dir) == "" {
+				return fmt.Errorf("data dirs cannot be blank: %q", dirs)
+			}
+		}
+		o.dataDir = dirs[0]
+		o.fallbackDataDirs = append([]string(nil), dirs[1:]...)
+		return nil
+	}
+}
+
+// allDataDirs returns the data dir followed by the ones to fail over to.
+func (o Options) allDataDirs() []string {
+	return append([]string{o.dataDir}, o.fallbackDataDirs...)
+}
+
+// storageState is whether the store could be written to when it was last
+// probed.
+type storageState struct {
+	mu  sync.Mutex
+	err error
+	// Whether the instance left the ingest queue group because of it.
+	left bool
+}
+
+// storageFailure returns why the store couldn't be written to when it was last
+// probed, or nil if it could.
+func (c *Conn) storageFailure() error {
+	c.storage.mu.Lock()
+	defer c.storage.mu.Unlock()
+	return c.storage.err
+}
+
+// storeDirs returns the data dir and the instance dir the store is in.
+func (c *Conn) storeDirs() (dataDir, instanceDir string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Opts.dataDir, c.instanceDir
+}
+
+// checkStorage probes the store. If it can't be written to, the store fails
+// over to the next data dir, if there is one. Otherwise the instance leaves
+// the ingest queue group, and joins it again once it can be written to,
+// unless it was asked to leave. It returns the reasons the instance is
+// degraded because of the storage, if any.
+func (c *Conn) checkStorage() []string {
+	c.mu.RLock()
+	db := c.badgerDB
+	spare := len(c.spareDataDirs) > 0
+	c.mu.RUnlock()
+	if db == nil {
+		return nil
+	}
+
+	err := c.probeStorage()
+	if err != nil && spare && !badgerInternal.InMemory(c.badgerOpenOptions()...) {
+		dataDir, _ := c.storeDirs()
+		log.Err(err).Str("dataDir", dataDir).Msg("storage can't be written to, failing over to the next data dir")
+		if fErr := c.failOver(); fErr != nil {
+			log.Err(fErr).Msg("problem failing over to the next data dir")
+		} else {
+			err = c.probeStorage()
+		}
+	}
+
+	c.mu.RLock()
+	dataDir := c.Opts.dataDir
+	failed := c.failedDataDirs
+	c.mu.RUnlock()
+	reasons := make([]string, 0)
+	for _, dir := range failed {
+		reasons = append(reasons, fmt.Sprintf("data dir %s can't be used, failed over to %s", dir, dataDir))
+	}
+
+	c.storage.mu.Lock()
+	defer c.storage.mu.Unlock()
+	prev := c.storage.err
+	c.storage.err = err
+
+	if err != nil {
+		reasons = append(reasons, fmt.Sprintf("storage can't be written to: %v", err))
+		if prev == nil {
+			log.Err(err).Str("dataDir", dataDir).Msg("storage can't be written to")
+		}
+		if !c.storage.left && c.QueueGroupMember() {
+			if lErr := c.LeaveQueueGroup(); lErr != nil {
+				log.Err(lErr).Msg("problem leaving the ingest queue group")
+			} else {
+				c.storage.left = true
+			}
+		}
+		return reasons
+	}
+
+	if prev != nil {
+		log.Info().Str("dataDir", dataDir).Msg("storage can be written to again")
+	}
+	if c.storage.left {
+		if jErr := c.JoinQueueGroup(); jErr != nil {
+			log.Err(jErr).Msg("problem joining the ingest queue group")
+		} else {
+			c.storage.left = false
+		}
+	}
+	return reasons
+}
+
+// probeStorage returns why the store can't be written to, or nil if it can.
+func (c *Conn) probeStorage() error {
+	c.mu.RLock()
+	db, instanceDir := c.badgerDB, c.instanceDir
+	c.mu.RUnlock()
+
+	err := badgerInternal.Probe(db, []byte(strconv.FormatInt(time.Now().UnixNano(), 10)))
+	if err == nil && !badgerInternal.InMemory(c.badgerOpenOptions()...) {
+		err = checkDirWritable(instanceDir)
+	}
+	return err
+}
+
+// retiredStore is what was in use before the store failed over, to be closed
+// once nothing can reach it.
+type retiredStore struct {
+	db       *badger.DB
+	qManager *queue.Manager
+	reapers  []*reaper.Reaper
+}
+
+// close stops reaping into the store, then writes out and closes its queues
+// before closing it.
+func (s retiredStore) close() {
+	for _, r := range s.reapers {
+		r.Close()
+	}
+	if s.qManager != nil {
+		s.qManager.Close()
+	}
+	if s.db != nil {
+		s.db.Close()
+	}
+}
+
+// failOver moves the store to the next data dir that can be used. Republishing
+// is stopped while it is moved, since it reads from the store, and the data
+// dir it was in is reaped into the new one so its messages are still
+// republished once it can be read.
+func (c *Conn) failOver() error {
+	republishing := c.IsRepublishing()
+	c.StopRepublishing()
+
+	c.mu.Lock()
+	from := c.Opts.dataDir
+	retired, err := c.moveStore()
+	to := c.Opts.dataDir
+	c.mu.Unlock()
+	if err == nil {
+		// Closing the queues waits on their writes, so it mustn't be done
+		// with the lock held.
+		retired.close()
+		log.Warn().Str("failed", from).Str("dataDir", to).Msg("failed over to another data dir")
+	}
+
+	if republishing {
+		if rErr := c.StartRepublishing(); rErr != nil {
+			log.Err(rErr).Msg("problem restarting republishing after failing over")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("fail over: %w", err)
+	}
+	return nil
+}
+
+// moveStore opens the store in the first of the spare data dirs that can be
+// used and has it written to in place of the current one, which is returned to
+// be closed. Nothing is changed if none can be used. Should be called with the
+// lock acquired.
+func (c *Conn) moveStore() (retiredStore, error) {
+	old := retiredStore{
+		db:       c.badgerDB,
+		qManager: c.qManager,
+		reapers:  c.failedDataDirReapers,
+	}
+	if c.reaper != nil {
+		old.reapers = append([]*reaper.Reaper{c.reaper}, old.reapers...)
+	}
+	dataDir, instanceDir := c.Opts.dataDir, c.instanceDir
+
+	err := fmt.Errorf("no data dir to fail over to")
+	for i, dir := range c.spareDataDirs {
+		var manager *queue.Manager
+		if manager, err = c.openSpareDataDir(dir); err != nil {
+			log.Err(err).Str("dataDir", dir).Msg("data dir can't be used, trying the next one")
+			continue
+		}
+		c.qManager = manager
+
+		failed := make([]string, 0, len(c.failedDataDirs)+i+1)
+		failed = append(failed, c.failedDataDirs...)
+		failed = append(failed, dataDir)
+		c.failedDataDirs = append(failed, c.spareDataDirs[:i]...)
+		c.spareDataDirs = c.spareDataDirs[i+1:]
+
+		if c.statsPublisher != nil {
+			c.statsPublisher.SetStore(c.qManager, c.badgerDB)
+		}
+		if c.vlogGC != nil {
+			c.vlogGC.SetStore(c.badgerDB, c.instanceDir)
+		}
+		// Reap into the new store, including what's left in the old one.
+		if c.reaper != nil {
+			if c.reaper, err = reaper.NewReaper(c.badgerDB, c.Opts.dataDir, c.instanceDir, c.Opts.reaperOpts...); err != nil {
+				log.Err(err).Msg("problem reaping the data dir failed over to")
+			}
+			c.failedDataDirReapers = nil
+			if err := c.initFailedDataDirReapers(); err != nil {
+				log.Err(err).Msg("problem reaping the data dirs failed over from")
+			}
+		}
+		return old, nil
+	}
+
+	c.badgerDB = old.db
+	c.Opts.dataDir, c.instanceDir = dataDir, instanceDir
+	return retiredStore{}, err
+}
+
+// openSpareDataDir opens the store in the data dir, along with its queues.
+// Should be called with the lock acquired.
+func (c *Conn) openSpareDataDir(dir string) (*queue.Manager, error) {
+	c.badgerDB = nil
+	if err := c.openBadger(dir, false); err != nil {
+		return nil, err
+	}
+	manager, err := c.newQueueManager()
+	if err != nil {
+		c.badgerDB.Close()
+		c.badgerDB = nil
+		return nil, err
+	}
+	return manager, nil
+}
+
+// initFailedDataDirReapers reaps the instances in the data dirs that were
+// failed over from into the store, so their messages are still republished.
+// Should be called with the lock acquired.
+func (c *Conn) initFailedDataDirReapers() error {
+	for _, dir := range c.failedDataDirs {
+		// There is nothing to reap if it couldn't even be created.
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			continue
+		}
+		r, err := reaper.NewReaper(c.badgerDB, dir, c.instanceDir, c.Opts.reaperOpts...)
+		if err != nil {
+			return err
+		}
+		c.failedDataDirReapers = append(c.failedDataDirReapers, r)
+	}
+	return nil
+}
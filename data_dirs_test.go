@@ -0,0 +1,121 @@
+package requeue
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/internal/reaper"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataDirsFailover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "data-dirs-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A file can't be used as a data dir.
+	primary := filepath.Join(dir, "primary")
+	assert.NoError(t, ioutil.WriteFile(primary, nil, 0666))
+	secondary := filepath.Join(dir, "secondary")
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDirs(primary, secondary)(&o))
+	c := NewConn(o)
+	assert.NoError(t, c.initBadger())
+	defer c.Close()
+
+	assert.Equal(t, secondary, c.Opts.dataDir)
+	assert.Equal(t, []string{primary}, c.failedDataDirs)
+	assert.Equal(t, filepath.Join(secondary, c.instanceId), c.instanceDir)
+	assert.NoError(t, c.preflight())
+
+	// The failover degrades the instance but it's still writable.
+	reasons := c.checkStorage()
+	assert.Len(t, reasons, 1)
+	assert.Contains(t, reasons[0], primary)
+	assert.NoError(t, c.storageFailure())
+	assert.True(t, c.QueueGroupMember())
+
+	// An instance that can't write to its store stops taking messages.
+	instanceDir := c.instanceDir
+	c.instanceDir = filepath.Join(dir, "missing")
+	reasons = c.checkStorage()
+	assert.Len(t, reasons, 2)
+	assert.Error(t, c.storageFailure())
+	assert.False(t, c.QueueGroupMember())
+	assert.Contains(t, c.checkReadiness().Reasons, reasons[1])
+	c.instanceDir = instanceDir
+
+	// None of the data dirs can be used.
+	o = GetDefaultOptions()
+	assert.NoError(t, DataDirs(primary, filepath.Join(primary, "nested"))(&o))
+	assert.Error(t, NewConn(o).initBadger())
+}
+
+func TestDataDirsFailoverWhileRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "data-dirs-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	primary := filepath.Join(dir, "primary")
+	secondary := filepath.Join(dir, "secondary")
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDirs(primary, secondary)(&o))
+	c := NewConn(o)
+	assert.NoError(t, c.initBadger())
+	defer c.Close()
+	assert.NoError(t, c.initQueueManager())
+	assert.Equal(t, primary, c.Opts.dataDir)
+	assert.Empty(t, c.checkStorage())
+
+	_, err = c.qManager.CreateQueue(queue.NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+	m := protocol.DefaultRequeueMessage()
+	m.OriginalSubject = "orders.created"
+	k := key.New(time.Now())
+	assert.NoError(t, c.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(queue.NewQueueKeyForMessage("orders", k).Bytes(), m.Bytes())
+	}))
+	manager := c.Manager()
+
+	// The store fails over to the next data dir rather than the instance
+	// leaving the ingest queue group.
+	c.instanceDir = filepath.Join(dir, "missing")
+	reasons := c.checkStorage()
+	assert.Len(t, reasons, 1)
+	assert.Contains(t, reasons[0], primary)
+	assert.NoError(t, c.storageFailure())
+	assert.True(t, c.QueueGroupMember())
+	assert.Equal(t, secondary, c.Opts.dataDir)
+	assert.Equal(t, []string{primary}, c.failedDataDirs)
+	assert.Empty(t, c.spareDataDirs)
+	assert.Equal(t, filepath.Join(secondary, c.instanceId), c.instanceDir)
+	assert.NotEqual(t, manager, c.Manager())
+	assert.NoError(t, c.preflight())
+
+	// The old store was closed, so the messages in it can be reaped into the
+	// new one.
+	reaped, err := reaper.Reap(c.badgerDB, primary, c.instanceId)
+	assert.NoError(t, err)
+	assert.True(t, reaped)
+	_, ok, err := queue.GetMessage(c.badgerDB, "orders", k)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// There's nowhere left to fail over to.
+	instanceDir := c.instanceDir
+	c.instanceDir = filepath.Join(dir, "missing")
+	reasons = c.checkStorage()
+	assert.Len(t, reasons, 2)
+	assert.Error(t, c.storageFailure())
+	assert.False(t, c.QueueGroupMember())
+	assert.Equal(t, secondary, c.Opts.dataDir)
+	c.instanceDir = instanceDir
+}
@@ -0,0 +1,152 @@
+package requeue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// DeadLetterQueue keeps the messages that are given up on, because they ran
+// out of retries, reached the max redeliveries of their queue or their payload
+// couldn't be decrypted, as protocol.DeadLetters for the retention rather than
+// throwing them away. They
+// can be listed with Conn.DeadLetters and put back in their queue with
+// Conn.RedriveDeadLetters. Zero keeps them until they are deleted, redriven or
+// the queue is dropped.
+func DeadLetterQueue(retention time.Duration) Option {
+	return func(o *Options) error {
+		if retention < 0 {
+			return fmt.Errorf("dead letter retention cannot be negative: %s", retention)
+		}
+		o.deadLetterQueue = true
+		o.deadLetterRetention = retention
+		return nil
+	}
+}
+
+// PublishDeadLetters publishes a protocol.DeadLetter on
+// protocol.DeadLetterSubject for every message that is given up on, whether or
+// not they're kept with DeadLetterQueue.
+func PublishDeadLetters() Option {
+	return func(o *Options) error {
+		o.deadLettersPublished = true
+		return nil
+	}
+}
+
+// DeadLetterHandler sets a callback that will be triggered for every message
+// that is given up on.
+func DeadLetterHandler(cb func(protocol.DeadLetter)) Option {
+	return func(o *Options) error {
+		o.deadLetterCB = cb
+		return nil
+	}
+}
+
+// messageDeadLettered is called by the republisher with every message it gives
+// up on.
+func (c *Conn) messageDeadLettered(nc *nats.Conn, dl protocol.DeadLetter) {
+	dl.InstanceID = c.instanceId
+	dl.Labels = c.Opts.labels
+	if cb := c.Opts.deadLetterCB; cb != nil {
+		c.hook(func() { cb(dl) })
+	}
+	if c.Opts.deadLettersPublished {
+		c.publishEventOn(nc, protocol.DeadLetterSubject(dl.Subject), dl)
+	}
+}
+
+// DeadLetters calls f, in message key order, with the dead letters kept for
+// the queue. If f returns false the iteration stops.
+func (c *Conn) DeadLetters(queueName string, f func(protocol.DeadLetter) bool) error {
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if db == nil {
+		return fmt.Errorf("dead letters: store is not open")
+	}
+
+	var decodeErr error
+	err := queue.RangeDeadLetters(db, queueName, func(qi queue.QueueItem) bool {
+		dl := protocol.DeadLetter{}
+		if decodeErr = dl.UnmarshalBinary(qi.V); decodeErr != nil {
+			return false
+		}
+		return f(dl)
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return fmt.Errorf("dead letters: %w", err)
+	}
+	return nil
+}
+
+// DeleteDeadLetter removes the dead letter with the key, as given in its
+// protocol.DeadLetter, e.g., once it has been inspected and won't be replayed.
+func (c *Conn) DeleteDeadLetter(queueName, messageKey string) error {
+	k, err := key.Parse(messageKey)
+	if err != nil {
+		return fmt.Errorf("delete dead letter: %w", err)
+	}
+
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if db == nil {
+		return fmt.Errorf("delete dead letter: store is not open")
+	}
+	return queue.DeleteDeadLetter(db, queueName, k)
+}
+
+// RedriveDeadLetters puts every dead letter of the queue back in it, ready to
+// be republished right away with the number of retries. Their attempts start
+// over, so the max redeliveries of the queue apply afresh, and any TTL counts
+// from when they're redriven. The number of messages redriven is returned,
+// even if it fails part way. Its progress is reported by Operations, and it can
+// be stopped part way with CancelOperation.
+func (c *Conn) RedriveDeadLetters(queueName string, retries uint64) (int, error) {
+	if retries == 0 {
+		return 0, fmt.Errorf("redrive dead letters: retries must be positive")
+	}
+
+	c.mu.RLock()
+	db := c.badgerDB
+	qManager := c.qManager
+	c.mu.RUnlock()
+	if db == nil || qManager == nil {
+		return 0, fmt.Errorf("redrive dead letters: queue manager is not running")
+	}
+	q, err := qManager.CreateQueue(queue.NewQueueKeyForState(queueName, ""))
+	if err != nil {
+		return 0, fmt.Errorf("redrive dead letters: %w", err)
+	}
+
+	op := c.operations.start(c.Opts.ctx, protocol.OperationRedrive, queueName)
+	n, err := queue.RedriveDeadLettersProgress(op.ctx, db, queueName, func(record []byte) ([]byte, time.Duration, error) {
+		var dl protocol.DeadLetter
+		if err := dl.UnmarshalBinary(record); err != nil {
+			return nil, 0, err
+		}
+		var msg protocol.RequeueMessage
+		if err := msg.UnmarshalBinary(dl.Message); err != nil {
+			return nil, 0, err
+		}
+		msg.Retries = retries
+		msg.Attempts = 0
+		v := msg.Bytes()
+		return v, c.storageTTL(flatbuf.GetRootAsRequeueMessage(v, 0)), nil
+	}, op.progress)
+	op.finish(err)
+	q.Stats.AddCount(int64(n))
+	if n > 0 {
+		c.wakeRepublisher(time.Now())
+	}
+	return n, err
+}
@@ -0,0 +1,116 @@
+package requeue
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dead-letters-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	assert.NoError(t, DeadLetterQueue(time.Hour)(&o))
+	handled := make(chan protocol.DeadLetter, 1)
+	assert.NoError(t, DeadLetterHandler(func(dl protocol.DeadLetter) {
+		handled <- dl
+	})(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	assert.NoError(t, c.initQueueManager())
+	assert.True(t, c.Features().Supports(protocol.FeatureDeadLetterQueue))
+
+	now := time.Now()
+	keys := make([]key.Key, 2)
+	assert.NoError(t, c.badgerDB.Update(func(txn *badger.Txn) error {
+		for i := range keys {
+			m := protocol.DefaultRequeueMessage()
+			m.OriginalSubject = "orders.created"
+			m.OriginalPayload = []byte{byte('a' + i)}
+			m.Retries = 1
+			m.Attempts = 5
+			keys[i] = key.New(now.Add(time.Duration(i) * time.Second))
+			data, err := protocol.DeadLetter{
+				Queue:   "orders",
+				Key:     keys[i].String(),
+				Subject: m.OriginalSubject,
+				Reason:  protocol.TerminalReasonRetriesExhausted,
+				Message: m.Bytes(),
+			}.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			if err := txn.SetEntry(queue.DeadLetterEntry("orders", keys[i], data, 0)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	// The instance fills in who it is before handing them out.
+	c.messageDeadLettered(c.nc, protocol.DeadLetter{Queue: "orders", Subject: "orders.created"})
+	select {
+	case dl := <-handled:
+		assert.Equal(t, c.instanceId, dl.InstanceID)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the dead letter handler")
+	}
+
+	var dls []protocol.DeadLetter
+	assert.NoError(t, c.DeadLetters("orders", func(dl protocol.DeadLetter) bool {
+		dls = append(dls, dl)
+		return true
+	}))
+	if assert.Len(t, dls, 2) {
+		assert.Equal(t, keys[0].String(), dls[0].Key)
+	}
+
+	assert.NoError(t, c.DeleteDeadLetter("orders", keys[0].String()))
+	assert.Error(t, c.DeleteDeadLetter("orders", "nope"))
+
+	_, err = c.RedriveDeadLetters("orders", 0)
+	assert.Error(t, err)
+	n, err := c.RedriveDeadLetters("orders", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	q, ok := c.qManager.GetQueue("orders")
+	if assert.True(t, ok) {
+		assert.Equal(t, int64(1), q.Stats.Count())
+	}
+	var redriven []*flatbuf.RequeueMessage
+	assert.NoError(t, c.badgerDB.View(func(txn *badger.Txn) error {
+		prefix := queue.NewQueueKeyForMessage("orders", nil).NamePrefixBytes()
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			redriven = append(redriven, flatbuf.GetRootAsRequeueMessage(v, 0))
+		}
+		return nil
+	}))
+	if assert.Len(t, redriven, 1) {
+		assert.Equal(t, "b", string(redriven[0].OriginalPayloadBytes()))
+		assert.Equal(t, uint64(3), redriven[0].Retries())
+		assert.Equal(t, uint64(0), redriven[0].Attempts())
+	}
+	assert.NoError(t, c.DeadLetters("orders", func(protocol.DeadLetter) bool {
+		t.Error("dead letter was not redriven")
+		return false
+	}))
+}
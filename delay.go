@@ -0,0 +1,49 @@
+package requeue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// QueueMinDelay holds every message ingested into the queue for at least d,
+// whatever delay the producer set, e.g., so everything in a digest queue
+// waits at least 10 minutes to be batched up. Retries are delayed by at least
+// d too.
+func QueueMinDelay(queueName string, d time.Duration) Option {
+	return func(o *Options) error {
+		if queueName == "" {
+			return fmt.Errorf("queue min delay: queue name cannot be blank")
+		}
+		if d < 0 {
+			return fmt.Errorf("queue min delay: %s: delay cannot be negative: %s", queueName, d)
+		}
+		if o.queueMinDelays == nil {
+			o.queueMinDelays = make(map[string]time.Duration)
+		}
+		o.queueMinDelays[queueName] = d
+		return nil
+	}
+}
+
+// applyMinDelay returns the message data with its delay raised to the min
+// delay of its queue. The delay is persisted with the message, rather than
+// only used for its key, so its age, TTL and retries all account for it.
+func (c *Conn) applyMinDelay(data []byte) []byte {
+	fb := flatbuf.GetRootAsRequeueMessage(data, 0)
+	min, ok := c.Opts.queueMinDelays[protocol.GetQueueName(fb)]
+	if !ok || fb.Delay() >= uint64(min) {
+		return data
+	}
+	// A producer that didn't set a delay has none in the buffer to mutate so
+	// the message is rebuilt with it.
+	if fb.MutateDelay(uint64(min)) {
+		return data
+	}
+	var m protocol.RequeueMessage
+	_ = m.UnmarshalBinary(data)
+	m.Delay = uint64(min)
+	return m.Bytes()
+}
@@ -0,0 +1,63 @@
+package requeue
+
+import (
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// stampReplyTo returns the message data with the reply subject of the producer
+// when acks are deferred until delivery, so the producer can be acknowledged
+// once the message is delivered even if the instance restarts in between.
+func (c *Conn) stampReplyTo(data []byte, msg *nats.Msg) []byte {
+	if c.Opts.ackMode != AckOnDelivery || msg.Reply == "" {
+		return data
+	}
+	return protocol.SetReplyTo(data, msg.Reply)
+}
+
+// ackDelivered acknowledges the message that was delivered to its producer on
+// the reply subject persisted with it. Acks that can't be sent are recorded as
+// an AckFailure.
+func (c *Conn) ackDelivered(r protocol.TerminalRecord) {
+	c.mu.RLock()
+	nc, db := c.nc, c.badgerDB
+	c.mu.RUnlock()
+	c.ackDeliveredOn(nc, db, r)
+}
+
+// ackDeliveredOn acknowledges the delivered message with nc, recording an ack
+// that can't be sent in db. It doesn't acquire the lock on the connection.
+func (c *Conn) ackDeliveredOn(nc *nats.Conn, db *badger.DB, r protocol.TerminalRecord) {
+	if r.ReplyTo == "" {
+		return
+	}
+
+	err := nats.ErrConnectionClosed
+	if nc != nil {
+		err = nc.Publish(r.ReplyTo, nil)
+	}
+	if err == nil {
+		return
+	}
+	k, parseErr := key.Parse(r.Key)
+	if parseErr != nil {
+		log.Err(err).Str("reply", r.ReplyTo).Msg("problem sending ACK for delivered message")
+		return
+	}
+	c.recordAckFailureIn(nc, db, k, protocol.AckFailure{
+		InstanceID: c.instanceId,
+		Queue:      r.Queue,
+		Key:        r.Key,
+		Subject:    r.Subject,
+		Reply:      r.ReplyTo,
+		Persisted:  true,
+		Error:      err.Error(),
+		Labels:     c.Opts.labels,
+		Time:       time.Now(),
+	})
+}
@@ -0,0 +1,58 @@
+package requeue
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliveryAck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "delivery-ack-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	c := NewConn(o)
+	defer c.Close()
+
+	m := protocol.DefaultRequeueMessage()
+	m.OriginalSubject = "orders.created"
+	msg := &nats.Msg{Subject: "requeue.orders", Reply: "_INBOX.abc", Data: m.Bytes()}
+
+	// The reply subject is only persisted when acks are deferred.
+	fb := flatbuf.GetRootAsRequeueMessage(c.stampReplyTo(msg.Data, msg), 0)
+	assert.Empty(t, fb.ReplyTo())
+	c.Opts.ackMode = AckOnDelivery
+	fb = flatbuf.GetRootAsRequeueMessage(c.stampReplyTo(msg.Data, msg), 0)
+	assert.Equal(t, "_INBOX.abc", string(fb.ReplyTo()))
+	assert.Equal(t, "orders.created", string(fb.OriginalSubject()))
+
+	// An ack that can't be sent is recorded.
+	assert.NoError(t, c.initBadger())
+	k := key.New(time.Now())
+	c.ackDelivered(protocol.TerminalRecord{
+		Queue:   "orders",
+		Key:     k.String(),
+		Subject: "orders.created",
+		State:   protocol.TerminalStateDelivered,
+		ReplyTo: "_INBOX.abc",
+	})
+	var failures []protocol.AckFailure
+	assert.NoError(t, c.AckFailures("orders", func(e protocol.AckFailure) bool {
+		failures = append(failures, e)
+		return true
+	}))
+	if assert.Len(t, failures, 1) {
+		assert.Equal(t, "_INBOX.abc", failures[0].Reply)
+		assert.Equal(t, k.String(), failures[0].Key)
+		assert.True(t, failures[0].Persisted)
+	}
+}
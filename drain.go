@@ -0,0 +1,119 @@
+package requeue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+// drainPollInterval is how often Drain checks if the ingest subscription has
+// finished draining.
+const drainPollInterval = 10 * time.Millisecond
+
+// inflightCounter counts the ingested messages that have yet to be committed
+// and acknowledged so Drain can wait for them.
+type inflightCounter struct {
+	mu sync.Mutex
+	n  int64
+	// Closed, and replaced, every time the count drops to zero.
+	idle chan struct{}
+}
+
+// add counts delta more messages in flight and wakes up the waiters once there
+// are none.
+func (f *inflightCounter) add(delta int64) {
+	f.mu.Lock()
+	f.n += delta
+	if f.n == 0 && f.idle != nil {
+		close(f.idle)
+		f.idle = nil
+	}
+	f.mu.Unlock()
+}
+
+// wait blocks until there are no messages in flight, or ctx is done.
+func (f *inflightCounter) wait(ctx context.Context) error {
+	f.mu.Lock()
+	if f.n == 0 {
+		f.mu.Unlock()
+		return nil
+	}
+	if f.idle == nil {
+		f.idle = make(chan struct{})
+	}
+	idle := f.idle
+	f.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drain closes the connection gracefully, e.g., from a Kubernetes preStop hook.
+// It stops taking new messages from NATS, waits for the messages already taken
+// to be committed and acknowledged, and only then closes everything like
+// Close. If ctx is done first the connection is closed anyway and the error
+// of ctx returned. The messages that weren't acknowledged by then are sent
+// again by their producers.
+func (c *Conn) Drain(ctx context.Context) error {
+	defer c.Close()
+
+	// Stop the watchdog so it doesn't replace the subscription being drained.
+	c.closers.watchdog.SignalAndWait()
+
+	c.mu.RLock()
+	nc := c.nc
+	sub := c.sub
+	qManager := c.qManager
+	c.mu.RUnlock()
+
+	if sub != nil {
+		log.Debug().Msg("draining the ingest subscription...")
+		if err := sub.Drain(); err != nil && err != nats.ErrBadSubscription {
+			return fmt.Errorf("drain: %w", err)
+		}
+		if err := waitForDrained(ctx, sub); err != nil {
+			return fmt.Errorf("drain: ingest subscription: %w", err)
+		}
+	}
+
+	// Commit what is waiting to be batched now rather than after the batch
+	// interval.
+	if qManager != nil {
+		qManager.Flush()
+	}
+	if err := c.inflight.wait(ctx); err != nil {
+		return fmt.Errorf("drain: pending acks: %w", err)
+	}
+
+	// Make sure the acks are sent before nats is closed.
+	if nc != nil && nc.IsConnected() {
+		if err := nc.FlushWithContext(ctx); err != nil {
+			return fmt.Errorf("drain: flush: %w", err)
+		}
+	}
+	log.Info().Msg("requeue: drained")
+	return nil
+}
+
+// waitForDrained blocks until the subscription has been drained, or ctx is
+// done.
+func waitForDrained(ctx context.Context, sub *nats.Subscription) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for sub.IsValid() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
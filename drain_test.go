@@ -0,0 +1,32 @@
+package requeue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInflightCounter(t *testing.T) {
+	var f inflightCounter
+	assert.NoError(t, f.wait(context.Background()))
+
+	f.add(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, f.wait(ctx))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f.wait(context.Background())
+	}()
+	f.add(-1)
+	select {
+	case <-done:
+		t.Fatal("wait returned with a message still in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+	f.add(-1)
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,124 @@
+package requeue
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultEgressNatsClientName is the default name of the egress NATS
+// connection.
+const DefaultEgressNatsClientName = DefaultNatsClientName + "-egress"
+
+// EgressNATSServers republishes messages on a separate NATS connection to the
+// servers (separated by comma), e.g., to bridge messages from one cluster to
+// another, or to keep the republished traffic off the flusher of the ingest
+// connection. Messages are still ingested, acknowledged, and the control
+// subjects served on the connection to NATSServers.
+func EgressNATSServers(servers string) Option {
+	return func(o *Options) error {
+		o.egressNatsServers = servers
+		return nil
+	}
+}
+
+// EgressNATSOptions are options given to NATS when establishing the egress
+// connection, after the NATSOptions so they override them, e.g., credentials
+// for another cluster. Setting them without EgressNATSServers opens an egress
+// connection to the NATSServers.
+func EgressNATSOptions(natsOptions []nats.Option) Option {
+	return func(o *Options) error {
+		o.egressNatsOptions = append(o.egressNatsOptions, natsOptions...)
+		return nil
+	}
+}
+
+// egressEnabled returns true if messages are republished on their own
+// connection.
+func (o Options) egressEnabled() bool {
+	return o.egressNatsServers != "" || len(o.egressNatsOptions) > 0
+}
+
+// egressConn returns the connection messages are republished on.
+func (c *Conn) egressConn() *nats.Conn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.egressNC != nil {
+		return c.egressNC
+	}
+	return c.nc
+}
+
+// connectEgress opens the egress connection. Should be called with the lock
+// acquired.
+func (c *Conn) connectEgress() error {
+	o := c.Opts
+	servers := o.egressNatsServers
+	if servers == "" {
+		servers = o.natsServers
+	}
+
+	opts := make([]nats.Option, 0, len(o.natsOptions)+len(o.egressNatsOptions)+5)
+	opts = append(opts, o.natsOptions...)
+	opts = append(opts, nats.Name(DefaultEgressNatsClientName))
+	opts = append(opts, o.egressNatsOptions...)
+	opts = append(opts,
+		nats.DisconnectErrHandler(c.egressDisconnectErrHandler),
+		nats.ReconnectHandler(c.egressReconnectHandler),
+		nats.ClosedHandler(c.egressClosedHandler),
+		nats.ErrorHandler(c.NATSErrorHandler),
+	)
+
+	nc, err := nats.Connect(servers, opts...)
+	if err != nil {
+		log.Err(err).Msgf("nats-replay: unable to connect to egress servers: %s", servers)
+		return err
+	}
+	c.egressNC = nc
+	log.Info().Str("servers", servers).Msg("connected egress nats")
+	return nil
+}
+
+func (c *Conn) egressDisconnectErrHandler(nc *nats.Conn, err error) {
+	log.Err(err).Msg("nats-replay: egress got disconnected!")
+}
+
+func (c *Conn) egressReconnectHandler(nc *nats.Conn) {
+	log.Info().Msgf("nats-replay: egress got reconnected to %s!", nc.ConnectedUrl())
+}
+
+// egressClosedHandler closes everything else once the egress connection is
+// closed, the same as the ingest connection, since no messages can be
+// republished without it.
+func (c *Conn) egressClosedHandler(nc *nats.Conn) {
+	err := nc.LastError()
+	log.Err(err).Msg("nats-replay: egress connection closed")
+	if c.Opts.natsConnErrCB != nil {
+		c.Opts.natsConnErrCB(c, err)
+	}
+
+	c.egressClosedOnce.Do(func() { close(c.egressClosed) })
+
+	c.Close()
+}
+
+// drainEgressNATS drains the egress connection and waits for it to close.
+// Must not be called with the lock acquired.
+func (c *Conn) drainEgressNATS(nc *nats.Conn) {
+	log.Debug().Msg("draining egress nats...")
+	if err := nc.Drain(); err != nil {
+		log.Err(err).Msg("error draining egress nats")
+	}
+
+	timer := time.NewTimer(c.Opts.natsDrainTimeout + natsDrainFlushTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.egressClosed:
+		log.Debug().Msg("drained egress nats")
+	case <-timer.C:
+		log.Warn().Msg("egress nats drain timed out. closing egress nats...")
+		nc.Close()
+	}
+	log.Debug().Msg("closed egress nats")
+}
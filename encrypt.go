@@ -0,0 +1,82 @@
+package requeue
+
+import (
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// PayloadEncrypter encrypts the original payload of every message with e
+// before it's persisted and decrypts it after it's retrieved to be
+// republished. This is independent of any at-rest encryption of the store.
+// See RepublishEncrypted to keep payloads encrypted over NATS as well.
+func PayloadEncrypter(e protocol.Encrypter) Option {
+	return func(o *Options) error {
+		if e == nil {
+			return fmt.Errorf("payload encrypter cannot be nil")
+		}
+		o.payloadEncrypter = e
+		return nil
+	}
+}
+
+// PayloadKeyring encrypts the original payload of every message with the key
+// of its queue in k, instead of one key for every queue, so tenants' data is
+// cryptographically separated. The id of the key is stored with the message
+// so keys can be rotated in the keyring without losing access to what's
+// already stored, and destroying a key shreds every message encrypted with it.
+// Messages without a key id, e.g., persisted before the keyring was set, are
+// still decrypted with the PayloadEncrypter if there is one.
+func PayloadKeyring(k protocol.Keyring) Option {
+	return func(o *Options) error {
+		if k == nil {
+			return fmt.Errorf("payload keyring cannot be nil")
+		}
+		o.payloadKeyring = k
+		return nil
+	}
+}
+
+// RepublishEncrypted republishes payloads without decrypting them so they're
+// also protected when republished over untrusted NATS links. Consumers must
+// decrypt them with the same protocol.Encrypter given to PayloadEncrypter, or
+// the key from the keyring named by the protocol.KeyIDHeader.
+func RepublishEncrypted() Option {
+	return func(o *Options) error {
+		o.republishEncrypted = true
+		return nil
+	}
+}
+
+// encryptPayload returns the message data to persist. If the payload can't be
+// encrypted the message is rejected and false is returned.
+func (c *Conn) encryptPayload(msg *nats.Msg, queueName string) ([]byte, bool) {
+	var data []byte
+	var err error
+	switch {
+	case c.Opts.payloadKeyring != nil:
+		data, err = protocol.EncryptPayloadWithKeyring(c.Opts.payloadKeyring, queueName, msg.Data)
+	case c.Opts.payloadEncrypter != nil:
+		data, err = protocol.EncryptPayload(c.Opts.payloadEncrypter, msg.Data)
+	default:
+		return msg.Data, true
+	}
+	if err != nil {
+		c.nak(msg, protocol.NakReasonEncryptionFailed, err.Error())
+		return nil, false
+	}
+	return data, true
+}
+
+// decryptPayload returns the original payload of the message in the clear.
+func (c *Conn) decryptPayload(fb *flatbuf.RequeueMessage) ([]byte, error) {
+	switch {
+	case c.Opts.payloadKeyring != nil && len(fb.KeyId()) > 0:
+		return protocol.DecryptPayloadWithKeyring(c.Opts.payloadKeyring, fb)
+	case c.Opts.payloadEncrypter != nil:
+		return protocol.DecryptPayload(c.Opts.payloadEncrypter, fb)
+	}
+	return fb.OriginalPayloadBytes(), nil
+}
@@ -0,0 +1,172 @@
+package requeue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// Erase removes every pending, quarantined and dead lettered message whose
+// metadata matches req.Match from the queues, e.g., to comply with a request
+// to erase a user's data. Unless it's a dry run, an audit record of the erasure is kept
+// and published on protocol.ErasuresSubject. The record is returned even if
+// the erasure fails part way so it's known what was erased. Messages already
+// in flight when they're erased may still be delivered, but are never retried.
+func (c *Conn) Erase(ctx context.Context, req protocol.EraseRequest) (protocol.ErasureRecord, error) {
+	now := time.Now()
+	record := protocol.ErasureRecord{
+		InstanceID: c.instanceId,
+		Match:      req.Match,
+		Reason:     req.Reason,
+		DryRun:     req.DryRun,
+		Queues:     make([]protocol.ErasedQueue, 0),
+		Labels:     c.Opts.labels,
+		Time:       now,
+	}
+	if len(req.Match) == 0 {
+		return record, fmt.Errorf("erase: match cannot be empty")
+	}
+
+	c.mu.RLock()
+	db := c.badgerDB
+	qManager := c.qManager
+	c.mu.RUnlock()
+	if db == nil || qManager == nil {
+		return record, fmt.Errorf("erase: queue manager is not running")
+	}
+
+	queues := qManager.Queues()
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Name() < queues[j].Name()
+	})
+	match := func(fb *flatbuf.RequeueMessage) bool {
+		return protocol.MatchMetadata(fb, req.Match)
+	}
+
+	var eraseErr error
+	for _, q := range queues {
+		base, _ := queue.SplitBucketName(q.Name())
+		if len(req.Queues) > 0 && !contains(req.Queues, base) && !contains(req.Queues, q.Name()) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			eraseErr = fmt.Errorf("erase: %w", err)
+			break
+		}
+		erased := protocol.ErasedQueue{Queue: q.Name()}
+		n, err := queue.EraseMessages(db, q.Name(), match, req.DryRun)
+		erased.Messages = int64(n)
+		if !req.DryRun {
+			q.Stats.AddCount(-int64(n))
+		}
+		if err == nil {
+			n, err = queue.EraseQuarantined(db, q.Name(), match, req.DryRun)
+			erased.Quarantined = int64(n)
+		}
+		if err == nil {
+			n, err = queue.EraseDeadLettered(db, q.Name(), match, req.DryRun)
+			erased.DeadLettered = int64(n)
+		}
+		if erased.Messages > 0 || erased.Quarantined > 0 || erased.DeadLettered > 0 {
+			record.Queues = append(record.Queues, erased)
+			record.Messages += erased.Messages
+			record.Quarantined += erased.Quarantined
+			record.DeadLettered += erased.DeadLettered
+		}
+		if err != nil {
+			eraseErr = fmt.Errorf("erase: %w", err)
+			break
+		}
+	}
+	if eraseErr != nil {
+		record.Error = eraseErr.Error()
+	}
+	if req.DryRun {
+		return record, eraseErr
+	}
+
+	k := key.New(now)
+	record.ID = k.String()
+	log.Info().
+		Str("id", record.ID).
+		Str("reason", record.Reason).
+		Int64("messages", record.Messages).
+		Int64("quarantined", record.Quarantined).
+		Int64("dead_lettered", record.DeadLettered).
+		Msg("erased messages")
+	data, err := record.MarshalBinary()
+	if err != nil {
+		return record, fmt.Errorf("erase: %w", err)
+	}
+	if err := queue.PutErasureRecord(db, k, data); err != nil {
+		log.Err(err).Str("id", record.ID).Msg("problem storing erasure record")
+		if eraseErr == nil {
+			eraseErr = fmt.Errorf("erase: %w", err)
+		}
+	}
+	c.publishEvent(protocol.ErasuresSubject, record)
+	return record, eraseErr
+}
+
+// ErasureRecords calls f with the audit records of the erasures made by the
+// instance in the order they were made. If f returns false the iteration
+// stops.
+func (c *Conn) ErasureRecords(f func(protocol.ErasureRecord) bool) error {
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if db == nil {
+		return fmt.Errorf("erasure records: store is not open")
+	}
+
+	var decodeErr error
+	err := queue.RangeErasureRecords(db, func(qi queue.QueueItem) bool {
+		r := protocol.ErasureRecord{}
+		if decodeErr = r.UnmarshalBinary(qi.V); decodeErr != nil {
+			return false
+		}
+		return f(r)
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return fmt.Errorf("erasure records: %w", err)
+	}
+	return nil
+}
+
+func (c *Conn) handleEraseRequest(msg *nats.Msg) {
+	req := protocol.EraseRequest{}
+	var record protocol.ErasureRecord
+	if err := req.UnmarshalBinary(msg.Data); err != nil {
+		record = protocol.ErasureRecord{
+			InstanceID: c.instanceId,
+			Error:      fmt.Sprintf("invalid erase request: %s", err),
+			Time:       time.Now(),
+		}
+	} else {
+		var err error
+		record, err = c.Erase(c.Opts.ctx, req)
+		if err != nil {
+			record.Error = err.Error()
+		}
+	}
+
+	data, err := record.MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("unable to marshal erasure record")
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Err(err).Msg("unable to respond to erase request")
+	}
+}
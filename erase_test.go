@@ -0,0 +1,93 @@
+package requeue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "erase-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	assert.NoError(t, InstanceID("erase")(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	assert.NoError(t, c.initQueueManager())
+
+	now := time.Now()
+	assert.NoError(t, c.badgerDB.Update(func(txn *badger.Txn) error {
+		for i, q := range []string{"orders", "orders", "emails", "emails"} {
+			m := protocol.DefaultRequeueMessage()
+			m.QueueName = q
+			m.Metadata = protocol.Metadata{"user_id": "123"}
+			if i%2 == 1 {
+				m.Metadata["user_id"] = "456"
+			}
+			k := key.New(now.Add(time.Duration(i) * time.Second))
+			if err := txn.Set(queue.NewQueueKeyForMessage(q, k).Bytes(), m.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	for _, q := range []string{"orders", "emails"} {
+		_, err := c.qManager.CreateQueue(queue.NewQueueKeyForState(q, ""))
+		assert.NoError(t, err)
+	}
+
+	_, err = c.Erase(context.Background(), protocol.EraseRequest{})
+	assert.Error(t, err)
+
+	match := protocol.Metadata{"user_id": "123"}
+	record, err := c.Erase(context.Background(), protocol.EraseRequest{Match: match, DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), record.Messages)
+	assert.Empty(t, record.ID)
+
+	record, err = c.Erase(context.Background(), protocol.EraseRequest{
+		Match:  match,
+		Queues: []string{"orders"},
+		Reason: "ticket-42",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), record.Messages)
+	assert.Equal(t, []protocol.ErasedQueue{{Queue: "orders", Messages: 1}}, record.Queues)
+
+	record, err = c.Erase(context.Background(), protocol.EraseRequest{Match: match})
+	assert.NoError(t, err)
+	assert.Equal(t, []protocol.ErasedQueue{{Queue: "emails", Messages: 1}}, record.Queues)
+
+	// Both erasures were audited, but not the dry run.
+	records := make([]protocol.ErasureRecord, 0)
+	assert.NoError(t, c.ErasureRecords(func(r protocol.ErasureRecord) bool {
+		records = append(records, r)
+		return true
+	}))
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "ticket-42", records[0].Reason)
+		assert.Equal(t, match, records[0].Match)
+		assert.NotEmpty(t, records[0].ID)
+		assert.Equal(t, int64(1), records[1].Messages)
+	}
+
+	// Only the messages of the other user are left.
+	record, err = c.Erase(context.Background(), protocol.EraseRequest{
+		Match:  protocol.Metadata{"user_id": "456"},
+		DryRun: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), record.Messages)
+}
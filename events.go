@@ -0,0 +1,76 @@
+package requeue
+
+import (
+	"encoding"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// ConnEventHandler sets a callback that will be triggered for every ConnEvent.
+// The events are also published on protocol.ConnEventsSubject.
+func ConnEventHandler(cb func(protocol.ConnEvent)) Option {
+	return func(o *Options) error {
+		o.connEventCB = cb
+		return nil
+	}
+}
+
+// publishEvent publishes an event on one of the system subjects.
+func (c *Conn) publishEvent(subject string, e encoding.BinaryMarshaler) {
+	c.mu.RLock()
+	nc := c.nc
+	c.mu.RUnlock()
+	c.publishEventOn(nc, subject, e)
+}
+
+// publishEventOn publishes an event on one of the system subjects with nc. It
+// doesn't acquire the lock on the connection.
+func (c *Conn) publishEventOn(nc *nats.Conn, subject string, e encoding.BinaryMarshaler) {
+	data, err := e.MarshalBinary()
+	if err != nil {
+		log.Err(err).Str("subject", subject).Msg("unable to marshal event")
+		return
+	}
+	if nc == nil || nc.IsClosed() {
+		return
+	}
+	if err := nc.Publish(subject, data); err != nil {
+		log.Err(err).Str("subject", subject).Msg("unable to publish event")
+	}
+}
+
+// publishConnEvent must not acquire the lock on the connection since it's
+// called from the nats handlers.
+func (c *Conn) publishConnEvent(nc *nats.Conn, state protocol.ConnState, err error) {
+	e := protocol.ConnEvent{
+		InstanceID: c.instanceId,
+		State:      state,
+		Labels:     c.Opts.labels,
+		Time:       time.Now(),
+	}
+	if nc != nil {
+		e.ServerURL = nc.ConnectedUrl()
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	if cb := c.Opts.connEventCB; cb != nil {
+		c.hook(func() { cb(e) })
+	}
+
+	if nc == nil || nc.IsClosed() {
+		return
+	}
+	data, mErr := e.MarshalBinary()
+	if mErr != nil {
+		log.Err(mErr).Msg("unable to marshal conn event")
+		return
+	}
+	if pErr := nc.Publish(protocol.ConnEventsSubject, data); pErr != nil {
+		log.Err(pErr).Msg("unable to publish conn event")
+	}
+}
@@ -0,0 +1,122 @@
+package requeue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/ticker"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultExpirySweepInterval is how often the queues are swept for messages
+// whose TTL has elapsed.
+const DefaultExpirySweepInterval = time.Minute
+
+// ExpirySweepInterval sets how often the queues are swept for messages whose
+// TTL has elapsed. Expired messages are never republished, but they stay on
+// disk until they're swept. Every message swept is counted in the expired
+// stats of its queue and the expired_total of the instance, recorded as
+// expired if terminal records are retained, and passed to the
+// ExpiredMessageHandler. A zero interval disables the sweeper.
+func ExpirySweepInterval(interval time.Duration) Option {
+	return func(o *Options) error {
+		if interval < 0 {
+			return fmt.Errorf("expiry sweep interval cannot be negative: %s", interval)
+		}
+		o.expirySweepInterval = interval
+		return nil
+	}
+}
+
+// ExpiredMessageHandler sets a callback that will be triggered for every
+// message the sweeper removes because its TTL elapsed, so expirations aren't
+// silent data loss.
+func ExpiredMessageHandler(cb func(protocol.ExpiredMessage)) Option {
+	return func(o *Options) error {
+		o.expiredMessageCB = cb
+		return nil
+	}
+}
+
+// StorageTTL also sets the TTL of messages in the store so it expires them
+// itself, grace after the sweeper should have, as a backstop in case the
+// sweeper falls behind or is disabled. Messages expired by the store are gone
+// before the sweeper sees them so they don't trigger the
+// ExpiredMessageHandler or count as expired, and the number of messages in
+// their queue is off until its stats are refreshed.
+func StorageTTL(grace time.Duration) Option {
+	return func(o *Options) error {
+		if grace < 0 {
+			return fmt.Errorf("storage ttl grace cannot be negative: %s", grace)
+		}
+		o.storageTTL = true
+		o.storageTTLGrace = grace
+		return nil
+	}
+}
+
+// storageTTL returns the TTL of the message in the store for a message
+// enqueued now. Zero means it doesn't expire in the store.
+func (c *Conn) storageTTL(fb *flatbuf.RequeueMessage) time.Duration {
+	if !c.Opts.storageTTL || fb.Ttl() == 0 {
+		return 0
+	}
+	return time.Duration(fb.Ttl()) + c.Opts.storageTTLGrace
+}
+
+func (c *Conn) initExpirySweeper() error {
+	if c.Opts.expirySweepInterval == 0 {
+		return nil
+	}
+
+	c.closers.sweeper.AddRunning(1)
+	go func() {
+		defer c.closers.sweeper.Done()
+		t := ticker.New(c.Opts.expirySweepInterval)
+		go func() {
+			<-c.closers.sweeper.HasBeenClosed()
+			t.Stop()
+		}()
+		t.Loop(func() bool {
+			c.sweepExpired(time.Now())
+			return true
+		})
+	}()
+
+	return nil
+}
+
+// sweepExpired removes the messages whose TTL elapsed as of now from every
+// queue.
+func (c *Conn) sweepExpired(now time.Time) {
+	m := c.Manager()
+	if m == nil {
+		return
+	}
+	for _, q := range m.Queues() {
+		n, err := q.SweepExpired(now, c.messageExpired)
+		if err != nil {
+			log.Err(err).Str("queue", q.Name()).Msg("problem sweeping expired messages")
+			continue
+		}
+		if n > 0 {
+			log.Debug().Str("queue", q.Name()).Msgf("swept %d expired messages", n)
+		}
+	}
+}
+
+func (c *Conn) messageExpired(e protocol.ExpiredMessage) {
+	c.counters.AddExpired(1)
+	c.recordTerminal(protocol.TerminalRecord{
+		Queue:   e.Queue,
+		Key:     e.Key,
+		Subject: e.Subject,
+		State:   protocol.TerminalStateExpired,
+		Time:    time.Now(),
+	})
+	if cb := c.Opts.expiredMessageCB; cb != nil {
+		c.hook(func() { cb(e) })
+	}
+}
@@ -0,0 +1,50 @@
+package requeue
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweepExpiredCountsAndHandles(t *testing.T) {
+	o := GetDefaultOptions()
+	assert.NoError(t, Storage(StorageMemory)(&o))
+	handled := make(chan protocol.ExpiredMessage, 1)
+	assert.NoError(t, ExpiredMessageHandler(func(e protocol.ExpiredMessage) {
+		handled <- e
+	})(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	assert.NoError(t, c.initQueueManager())
+
+	q, err := c.qManager.CreateQueue(queue.NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+	m := protocol.DefaultRequeueMessage()
+	m.OriginalSubject = "orders.created"
+	m.TTL = uint64(time.Minute)
+	k := key.New(time.Now())
+	assert.NoError(t, c.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(queue.NewQueueKeyForMessage("orders", k).Bytes(), m.Bytes())
+	}))
+
+	c.sweepExpired(time.Now().Add(time.Hour))
+	select {
+	case e := <-handled:
+		assert.Equal(t, "orders", e.Queue)
+		assert.Equal(t, k.String(), e.Key)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the expired message handler")
+	}
+	assert.Equal(t, int64(1), q.Stats.Expired())
+	assert.Equal(t, int64(1), c.counters.Expired())
+
+	// The instance total outlives the queue.
+	assert.NoError(t, c.qManager.DropQueue("orders"))
+	assert.Equal(t, int64(1), c.counters.Expired())
+}
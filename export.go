@@ -0,0 +1,224 @@
+package requeue
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// exportBatchSize is how many messages are read from the store at a time
+// while they're exported, so a slow export doesn't hold a read transaction
+// open.
+const exportBatchSize = 256
+
+// ExportQueue publishes a copy of every message pending in the queue, and the
+// sub-queues of a time bucketed queue, on req.Subject in the order they'd be
+// republished, no faster than req.Rate. The messages are left in the queue.
+// Their original payloads are published, decrypted, unless req.Envelope is
+// set. The reply is returned even if the export fails part way so it's known
+// how far it got.
+func (c *Conn) ExportQueue(ctx context.Context, req protocol.ExportRequest) (protocol.ExportReply, error) {
+	nc := c.egressConn()
+	if nc == nil || nc.IsClosed() {
+		reply := c.newExportReply(req)
+		err := fmt.Errorf("export queue: not connected to nats")
+		reply.Error = err.Error()
+		return reply, err
+	}
+
+	reply, err := c.exportQueue(ctx, req, func(data []byte) error {
+		return nc.Publish(req.Subject, data)
+	})
+	if err == nil && reply.Exported > 0 {
+		if ferr := nc.FlushWithContext(ctx); ferr != nil {
+			err = fmt.Errorf("export queue: %w", ferr)
+		}
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	return reply, err
+}
+
+func (c *Conn) newExportReply(req protocol.ExportRequest) protocol.ExportReply {
+	return protocol.ExportReply{
+		InstanceID: c.instanceId,
+		Queue:      req.Queue,
+		Subject:    req.Subject,
+		Time:       time.Now(),
+	}
+}
+
+// exportQueue exports the queue with publish.
+func (c *Conn) exportQueue(ctx context.Context, req protocol.ExportRequest, publish func([]byte) error) (protocol.ExportReply, error) {
+	reply := c.newExportReply(req)
+	if req.Queue == "" {
+		return reply, fmt.Errorf("export queue: queue name cannot be blank")
+	}
+	if err := protocol.ValidateSubject(req.Subject); err != nil {
+		return reply, fmt.Errorf("export queue: %w", err)
+	}
+	if strings.ContainsAny(req.Subject, "*>") {
+		return reply, fmt.Errorf("export queue: cannot publish to a wildcard subject: %q", req.Subject)
+	}
+	if c.reserved.Contains(req.Subject) {
+		return reply, fmt.Errorf("export queue: cannot publish to a system subject: %q", req.Subject)
+	}
+	if req.Rate < 0 {
+		return reply, fmt.Errorf("export queue: rate cannot be negative: %g", req.Rate)
+	}
+
+	c.mu.RLock()
+	qManager := c.qManager
+	c.mu.RUnlock()
+	if qManager == nil {
+		return reply, fmt.Errorf("export queue: queue manager is not running")
+	}
+
+	queues := make([]*queue.Queue, 0)
+	for _, q := range qManager.Queues() {
+		if base, _ := queue.SplitBucketName(q.Name()); base == req.Queue {
+			queues = append(queues, q)
+		}
+	}
+	// Sub-queues are named so they sort in time order.
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Name() < queues[j].Name()
+	})
+
+	var interval time.Duration
+	if req.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / req.Rate)
+	}
+	next := time.Now()
+	for _, q := range queues {
+		var after []byte
+		for {
+			batch, err := readExportBatch(q, after)
+			if err != nil {
+				return reply, fmt.Errorf("export queue: %s: %w", q.Name(), err)
+			}
+			if len(batch) == 0 {
+				break
+			}
+			after = batch[len(batch)-1].K
+
+			for _, qi := range batch {
+				fb := flatbuf.GetRootAsRequeueMessage(qi.V, 0)
+				if expiresAt, ok := qi.Expiry(fb); ok && !expiresAt.After(time.Now()) {
+					// It's waiting to be swept.
+					continue
+				}
+				if err := waitUntil(ctx, next); err != nil {
+					return reply, fmt.Errorf("export queue: %w", err)
+				}
+				next = next.Add(interval)
+
+				data := qi.V
+				if !req.Envelope {
+					data, err = c.decryptPayload(fb)
+					if err != nil {
+						log.Err(err).
+							Str("queue", q.Name()).
+							Str("key", queue.ParseQueueKey(qi.K).Key.String()).
+							Msg("unable to decrypt payload for export")
+						reply.Skipped++
+						continue
+					}
+				}
+				if err := publish(data); err != nil {
+					return reply, fmt.Errorf("export queue: %w", err)
+				}
+				reply.Exported++
+			}
+		}
+	}
+
+	log.Info().
+		Str("queue", req.Queue).
+		Str("subject", req.Subject).
+		Int64("exported", reply.Exported).
+		Int64("skipped", reply.Skipped).
+		Msg("exported queue")
+	return reply, nil
+}
+
+// readExportBatch reads the next batch of messages in the queue after the key.
+func readExportBatch(q *queue.Queue, after []byte) ([]queue.QueueItem, error) {
+	seek := queue.FirstMessage(q.Name())
+	if after != nil {
+		seek = queue.ParseQueueKey(after)
+	}
+	batch := make([]queue.QueueItem, 0, exportBatchSize)
+	_, err := q.Range(seek, queue.LastMessage(q.Name()), func(qi queue.QueueItem) bool {
+		if after != nil && bytes.Equal(qi.K, after) {
+			return true
+		}
+		batch = append(batch, qi)
+		return len(batch) < exportBatchSize
+	})
+	return batch, err
+}
+
+// waitUntil blocks until t, or ctx is done.
+func waitUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleExportRequest runs the export in the background and replies once it
+// has finished. It's stopped if the connection is closed.
+func (c *Conn) handleExportRequest(msg *nats.Msg) {
+	req := protocol.ExportRequest{}
+	if err := req.UnmarshalBinary(msg.Data); err != nil {
+		reply := c.newExportReply(req)
+		reply.Error = fmt.Sprintf("invalid export request: %s", err)
+		c.respondExport(msg, reply)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(c.Opts.ctx)
+	go func() {
+		select {
+		case <-c.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		defer cancel()
+		reply, _ := c.ExportQueue(ctx, req)
+		reply.Time = time.Now()
+		c.respondExport(msg, reply)
+	}()
+}
+
+func (c *Conn) respondExport(msg *nats.Msg, reply protocol.ExportReply) {
+	data, err := reply.MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("unable to marshal export reply")
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Err(err).Msg("unable to respond to export request")
+	}
+}
@@ -0,0 +1,111 @@
+package requeue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	assert.NoError(t, PayloadEncrypter(xorEncrypter(7))(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	assert.NoError(t, c.initQueueManager())
+
+	// Two sub-queues of a time bucketed queue, out of order, and a message
+	// that's expired.
+	now := time.Now()
+	writes := []struct {
+		queue   string
+		payload string
+		ttl     time.Duration
+	}{
+		{"orders@2024-06-02", "c", 0},
+		{"orders@2024-06-01", "a", 0},
+		{"orders@2024-06-01", "b", 0},
+		{"orders@2024-06-01", "expired", time.Nanosecond},
+		{"emails", "x", 0},
+	}
+	assert.NoError(t, c.badgerDB.Update(func(txn *badger.Txn) error {
+		for i, w := range writes {
+			m := protocol.DefaultRequeueMessage()
+			m.QueueName = w.queue
+			m.OriginalPayload, _ = xorEncrypter(7).Encrypt([]byte(w.payload))
+			m.TTL = uint64(w.ttl)
+			k := key.New(now.Add(time.Duration(i-10) * time.Second))
+			if err := txn.Set(queue.NewQueueKeyForMessage(w.queue, k).Bytes(), m.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	for _, q := range []string{"orders@2024-06-01", "orders@2024-06-02", "emails"} {
+		_, err := c.qManager.CreateQueue(queue.NewQueueKeyForState(q, ""))
+		assert.NoError(t, err)
+	}
+
+	var published []string
+	publish := func(data []byte) error {
+		published = append(published, string(data))
+		return nil
+	}
+	ctx := context.Background()
+	for _, req := range []protocol.ExportRequest{
+		{Subject: "staging.orders"},
+		{Queue: "orders", Subject: "staging.*"},
+		{Queue: "orders", Subject: protocol.ReceiptSubject("orders")},
+		{Queue: "orders", Subject: "staging.orders", Rate: -1},
+	} {
+		_, err := c.exportQueue(ctx, req, publish)
+		assert.Error(t, err, "%+v", req)
+	}
+
+	// The payloads are decrypted, in the order they'd be republished, at the
+	// rate.
+	start := time.Now()
+	reply, err := c.exportQueue(ctx, protocol.ExportRequest{Queue: "orders", Subject: "staging.orders", Rate: 50}, publish)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, published)
+	assert.Equal(t, int64(3), reply.Exported)
+	assert.True(t, time.Since(start) >= 40*time.Millisecond, "too fast: %s", time.Since(start))
+
+	// The envelopes are published as they're stored and left in the queue.
+	published = nil
+	reply, err = c.exportQueue(ctx, protocol.ExportRequest{Queue: "emails", Subject: "staging.emails", Envelope: true}, publish)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), reply.Exported)
+	if assert.Len(t, published, 1) {
+		fb := flatbuf.GetRootAsRequeueMessage([]byte(published[0]), 0)
+		assert.Equal(t, "emails", string(fb.QueueName()))
+	}
+	reply, err = c.exportQueue(ctx, protocol.ExportRequest{Queue: "emails", Subject: "staging.emails"}, publish)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), reply.Exported)
+
+	// It stops when the context is done.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	reply, err = c.exportQueue(cctx, protocol.ExportRequest{Queue: "orders", Subject: "staging.orders", Rate: 1}, publish)
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), reply.Exported)
+
+	// Without nats there's nowhere to publish.
+	_, err = c.ExportQueue(ctx, protocol.ExportRequest{Queue: "orders", Subject: "staging.orders"})
+	assert.Error(t, err)
+}
@@ -11,21 +11,27 @@ import (
 type BackoffStrategy int8
 
 const (
-	BackoffStrategyUndefined   BackoffStrategy = 0
-	BackoffStrategyExponential BackoffStrategy = 1
-	BackoffStrategyFixed       BackoffStrategy = 2
+	BackoffStrategyUndefined             BackoffStrategy = 0
+	BackoffStrategyExponential           BackoffStrategy = 1
+	BackoffStrategyFixed                 BackoffStrategy = 2
+	BackoffStrategyExponentialWithJitter BackoffStrategy = 3
+	BackoffStrategyLinear                BackoffStrategy = 4
 )
 
 var EnumNamesBackoffStrategy = map[BackoffStrategy]string{
-	BackoffStrategyUndefined:   "Undefined",
-	BackoffStrategyExponential: "Exponential",
-	BackoffStrategyFixed:       "Fixed",
+	BackoffStrategyUndefined:             "Undefined",
+	BackoffStrategyExponential:           "Exponential",
+	BackoffStrategyFixed:                 "Fixed",
+	BackoffStrategyExponentialWithJitter: "ExponentialWithJitter",
+	BackoffStrategyLinear:                "Linear",
 }
 
 var EnumValuesBackoffStrategy = map[string]BackoffStrategy{
-	"Undefined":   BackoffStrategyUndefined,
-	"Exponential": BackoffStrategyExponential,
-	"Fixed":       BackoffStrategyFixed,
+	"Undefined":             BackoffStrategyUndefined,
+	"Exponential":           BackoffStrategyExponential,
+	"Fixed":                 BackoffStrategyFixed,
+	"ExponentialWithJitter": BackoffStrategyExponentialWithJitter,
+	"Linear":                BackoffStrategyLinear,
 }
 
 func (v BackoffStrategy) String() string {
@@ -104,7 +110,8 @@ func (rcv *RequeueMessage) MutateDelay(n uint64) bool {
 
 /// Backoff strategy that will be used for determining the next delay should
 /// the message fail to be acknowledged on replay. i.e. fixed interval or
-/// exponential
+/// exponential. The delay is the base of the backoff. When it's undefined
+/// the default backoff of the instance is used.
 func (rcv *RequeueMessage) BackoffStrategy() BackoffStrategy {
 	o := flatbuffers.UOffsetT(rcv._tab.Offset(10))
 	if o != 0 {
@@ -115,7 +122,8 @@ func (rcv *RequeueMessage) BackoffStrategy() BackoffStrategy {
 
 /// Backoff strategy that will be used for determining the next delay should
 /// the message fail to be acknowledged on replay. i.e. fixed interval or
-/// exponential
+/// exponential. The delay is the base of the backoff. When it's undefined
+/// the default backoff of the instance is used.
 func (rcv *RequeueMessage) MutateBackoffStrategy(n BackoffStrategy) bool {
 	return rcv._tab.MutateInt8Slot(10, int8(n))
 }
@@ -134,12 +142,6 @@ func (rcv *RequeueMessage) QueueName() []byte {
 	return nil
 }
 
-/// The persistence queue events will be stored in.
-/// This can be useful if you need multiple queues by priority.
-/// On the sever you can configure the priority certain queues 
-/// should have over other. This way you can ensure a given high volume 
-/// queue does not starve out a low volume queue.
-/// The default queue is "default" when one is not provided.
 /// The original subject of the message.
 func (rcv *RequeueMessage) OriginalSubject() []byte {
 	o := flatbuffers.UOffsetT(rcv._tab.Offset(14))
@@ -149,7 +151,6 @@ func (rcv *RequeueMessage) OriginalSubject() []byte {
 	return nil
 }
 
-/// The original subject of the message.
 /// Original message payload
 func (rcv *RequeueMessage) OriginalPayload(j int) byte {
 	o := flatbuffers.UOffsetT(rcv._tab.Offset(16))
@@ -186,8 +187,200 @@ func (rcv *RequeueMessage) MutateOriginalPayload(j int, n byte) bool {
 	return false
 }
 
+/// How long to wait for the republished message to be acknowledged in
+/// nanoseconds. Overrides the timeout set for the queue when non-zero.
+func (rcv *RequeueMessage) AckTimeout() uint64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(18))
+	if o != 0 {
+		return rcv._tab.GetUint64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// How long to wait for the republished message to be acknowledged in
+/// nanoseconds. Overrides the timeout set for the queue when non-zero.
+func (rcv *RequeueMessage) MutateAckTimeout(n uint64) bool {
+	return rcv._tab.MutateUint64Slot(18, n)
+}
+
+/// The number of times the message has been republished without being
+/// acknowledged. This is set by requeue and not by producers.
+func (rcv *RequeueMessage) Attempts() uint64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(20))
+	if o != 0 {
+		return rcv._tab.GetUint64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The number of times the message has been republished without being
+/// acknowledged. This is set by requeue and not by producers.
+func (rcv *RequeueMessage) MutateAttempts(n uint64) bool {
+	return rcv._tab.MutateUint64Slot(20, n)
+}
+
+/// Messages with the same dedupe key replace each other while pending in
+/// a coalescing queue, so only the latest is republished.
+func (rcv *RequeueMessage) DedupeKey() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(22))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// The subjects the message has already been acknowledged on when it's
+/// fanned out to more than one. They are skipped when it's retried. This
+/// is set by requeue and not by producers.
+func (rcv *RequeueMessage) AckedSubjects(j int) []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(24))
+	if o != 0 {
+		a := rcv._tab.Vector(o)
+		return rcv._tab.ByteVector(a + flatbuffers.UOffsetT(j*4))
+	}
+	return nil
+}
+
+func (rcv *RequeueMessage) AckedSubjectsLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(24))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
+/// The key the message was first stored under, in its readable form. Set
+/// by requeue when the message is first retried since its key changes.
+func (rcv *RequeueMessage) MessageId() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(26))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// When the message was first enqueued in Unix nanoseconds. Set by
+/// requeue when the message is first retried.
+func (rcv *RequeueMessage) EnqueuedAt() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(28))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// When the message was first enqueued in Unix nanoseconds. Set by
+/// requeue when the message is first retried.
+func (rcv *RequeueMessage) MutateEnqueuedAt(n int64) bool {
+	return rcv._tab.MutateInt64Slot(28, n)
+}
+
+/// When the message becomes ready to be republished in Unix nanoseconds,
+/// which is more precise than its key. Set by requeue when delivery
+/// precision is enabled.
+func (rcv *RequeueMessage) ReadyAt() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(30))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// When the message becomes ready to be republished in Unix nanoseconds,
+/// which is more precise than its key. Set by requeue when delivery
+/// precision is enabled.
+func (rcv *RequeueMessage) MutateReadyAt(n int64) bool {
+	return rcv._tab.MutateInt64Slot(30, n)
+}
+
+/// The W3C traceparent the message was ingested with, if any. Set by
+/// requeue when trace headers are enabled so every attempt to republish
+/// the message continues the same trace.
+func (rcv *RequeueMessage) TraceParent() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(32))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// The id of the key the original payload is encrypted with when payloads
+/// are encrypted with per-queue keys. Set by requeue and not by producers.
+func (rcv *RequeueMessage) KeyId() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(34))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// Metadata about the message set by producers, e.g., the id of the user
+/// it belongs to, so it can be found and erased. It's stored in the clear
+/// even when payloads are encrypted.
+func (rcv *RequeueMessage) Metadata(obj *MetadataEntry, j int) bool {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(36))
+	if o != 0 {
+		x := rcv._tab.Vector(o)
+		x += flatbuffers.UOffsetT(j) * 4
+		x = rcv._tab.Indirect(x)
+		obj.Init(rcv._tab.Bytes, x)
+		return true
+	}
+	return false
+}
+
+func (rcv *RequeueMessage) MetadataLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(36))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
+/// The downstream queue group the message is intended for, set by
+/// producers. When queue group headers are enabled it's sent with every
+/// attempt to republish the message so the members of other groups can
+/// ignore it.
+func (rcv *RequeueMessage) QueueGroup() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(38))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// The subject to acknowledge the message to its producer on once it has
+/// been delivered, when acks are deferred until delivery. It's persisted
+/// with the message so the producer is still acknowledged if the instance
+/// restarts in the meantime. Set by requeue and not by producers.
+func (rcv *RequeueMessage) ReplyTo() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(40))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// The version of the protocol the message was encoded with. Messages of
+/// producers that predate protocol versions don't set it and are
+/// upgraded when they are received.
+func (rcv *RequeueMessage) ProtocolVersion() uint32 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(42))
+	if o != 0 {
+		return rcv._tab.GetUint32(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The version of the protocol the message was encoded with. Messages of
+/// producers that predate protocol versions don't set it and are
+/// upgraded when they are received.
+func (rcv *RequeueMessage) MutateProtocolVersion(n uint32) bool {
+	return rcv._tab.MutateUint32Slot(42, n)
+}
+
 func RequeueMessageStart(builder *flatbuffers.Builder) {
-	builder.StartObject(7)
+	builder.StartObject(20)
 }
 func RequeueMessageAddRetries(builder *flatbuffers.Builder, retries uint64) {
 	builder.PrependUint64Slot(0, retries, 0)
@@ -213,6 +406,100 @@ func RequeueMessageAddOriginalPayload(builder *flatbuffers.Builder, originalPayl
 func RequeueMessageStartOriginalPayloadVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
 	return builder.StartVector(1, numElems, 1)
 }
+func RequeueMessageAddAckTimeout(builder *flatbuffers.Builder, ackTimeout uint64) {
+	builder.PrependUint64Slot(7, ackTimeout, 0)
+}
+func RequeueMessageAddAttempts(builder *flatbuffers.Builder, attempts uint64) {
+	builder.PrependUint64Slot(8, attempts, 0)
+}
+func RequeueMessageAddDedupeKey(builder *flatbuffers.Builder, dedupeKey flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(9, flatbuffers.UOffsetT(dedupeKey), 0)
+}
+func RequeueMessageAddAckedSubjects(builder *flatbuffers.Builder, ackedSubjects flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(10, flatbuffers.UOffsetT(ackedSubjects), 0)
+}
+func RequeueMessageStartAckedSubjectsVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
+func RequeueMessageAddMessageId(builder *flatbuffers.Builder, messageId flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(11, flatbuffers.UOffsetT(messageId), 0)
+}
+func RequeueMessageAddEnqueuedAt(builder *flatbuffers.Builder, enqueuedAt int64) {
+	builder.PrependInt64Slot(12, enqueuedAt, 0)
+}
+func RequeueMessageAddReadyAt(builder *flatbuffers.Builder, readyAt int64) {
+	builder.PrependInt64Slot(13, readyAt, 0)
+}
+func RequeueMessageAddTraceParent(builder *flatbuffers.Builder, traceParent flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(14, flatbuffers.UOffsetT(traceParent), 0)
+}
+func RequeueMessageAddKeyId(builder *flatbuffers.Builder, keyId flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(15, flatbuffers.UOffsetT(keyId), 0)
+}
+func RequeueMessageAddMetadata(builder *flatbuffers.Builder, metadata flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(16, flatbuffers.UOffsetT(metadata), 0)
+}
+func RequeueMessageStartMetadataVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
+func RequeueMessageAddQueueGroup(builder *flatbuffers.Builder, queueGroup flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(17, flatbuffers.UOffsetT(queueGroup), 0)
+}
+func RequeueMessageAddReplyTo(builder *flatbuffers.Builder, replyTo flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(18, flatbuffers.UOffsetT(replyTo), 0)
+}
+func RequeueMessageAddProtocolVersion(builder *flatbuffers.Builder, protocolVersion uint32) {
+	builder.PrependUint32Slot(19, protocolVersion, 0)
+}
 func RequeueMessageEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
 	return builder.EndObject()
 }
+/// A key value pair of message metadata.
+type MetadataEntry struct {
+	_tab flatbuffers.Table
+}
+
+func GetRootAsMetadataEntry(buf []byte, offset flatbuffers.UOffsetT) *MetadataEntry {
+	n := flatbuffers.GetUOffsetT(buf[offset:])
+	x := &MetadataEntry{}
+	x.Init(buf, n+offset)
+	return x
+}
+
+func (rcv *MetadataEntry) Init(buf []byte, i flatbuffers.UOffsetT) {
+	rcv._tab.Bytes = buf
+	rcv._tab.Pos = i
+}
+
+func (rcv *MetadataEntry) Table() flatbuffers.Table {
+	return rcv._tab
+}
+
+func (rcv *MetadataEntry) Key() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(4))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+func (rcv *MetadataEntry) Value() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+func MetadataEntryStart(builder *flatbuffers.Builder) {
+	builder.StartObject(2)
+}
+func MetadataEntryAddKey(builder *flatbuffers.Builder, key flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(key), 0)
+}
+func MetadataEntryAddValue(builder *flatbuffers.Builder, value flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(1, flatbuffers.UOffsetT(value), 0)
+}
+func MetadataEntryEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	return builder.EndObject()
+}
@@ -36,7 +36,6 @@ func (rcv *InstanceStatsMessage) InstanceId() []byte {
 	return nil
 }
 
-/// The unique id of the instance.
 func (rcv *InstanceStatsMessage) Queues(obj *QueueStatsMessage, j int) bool {
 	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
 	if o != 0 {
@@ -57,19 +56,1055 @@ func (rcv *InstanceStatsMessage) QueuesLength() int {
 	return 0
 }
 
+/// The stats for the storage backing the instance.
+func (rcv *InstanceStatsMessage) Storage(obj *StorageStats) *StorageStats {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(8))
+	if o != 0 {
+		x := rcv._tab.Indirect(o + rcv._tab.Pos)
+		if obj == nil {
+			obj = new(StorageStats)
+		}
+		obj.Init(rcv._tab.Bytes, x)
+		return obj
+	}
+	return nil
+}
+
+/// Static labels for the instance, e.g., region, environment, or team.
+func (rcv *InstanceStatsMessage) Labels(obj *Label, j int) bool {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(10))
+	if o != 0 {
+		x := rcv._tab.Vector(o)
+		x += flatbuffers.UOffsetT(j) * 4
+		x = rcv._tab.Indirect(x)
+		obj.Init(rcv._tab.Bytes, x)
+		return true
+	}
+	return false
+}
+
+func (rcv *InstanceStatsMessage) LabelsLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(10))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
+/// The number of messages rejected at ingest by reason.
+func (rcv *InstanceStatsMessage) Rejected(obj *ReasonCount, j int) bool {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(12))
+	if o != 0 {
+		x := rcv._tab.Vector(o)
+		x += flatbuffers.UOffsetT(j) * 4
+		x = rcv._tab.Indirect(x)
+		obj.Init(rcv._tab.Bytes, x)
+		return true
+	}
+	return false
+}
+
+func (rcv *InstanceStatsMessage) RejectedLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(12))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
+/// The original subjects with the most messages ingested, most first.
+/// The counts are approximate since only a bounded number of subjects
+/// are tracked.
+func (rcv *InstanceStatsMessage) TopIngested(obj *SubjectCount, j int) bool {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(14))
+	if o != 0 {
+		x := rcv._tab.Vector(o)
+		x += flatbuffers.UOffsetT(j) * 4
+		x = rcv._tab.Indirect(x)
+		obj.Init(rcv._tab.Bytes, x)
+		return true
+	}
+	return false
+}
+
+func (rcv *InstanceStatsMessage) TopIngestedLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(14))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
+/// The original subjects with the most messages republished, most first.
+func (rcv *InstanceStatsMessage) TopRepublished(obj *SubjectCount, j int) bool {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(16))
+	if o != 0 {
+		x := rcv._tab.Vector(o)
+		x += flatbuffers.UOffsetT(j) * 4
+		x = rcv._tab.Indirect(x)
+		obj.Init(rcv._tab.Bytes, x)
+		return true
+	}
+	return false
+}
+
+func (rcv *InstanceStatsMessage) TopRepublishedLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(16))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
+/// The version of requeue the instance is running.
+func (rcv *InstanceStatsMessage) Version() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(18))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// The features the instance supports so clients can tell what they can
+/// rely on during a rolling upgrade.
+func (rcv *InstanceStatsMessage) Features(j int) []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(20))
+	if o != 0 {
+		a := rcv._tab.Vector(o)
+		return rcv._tab.ByteVector(a + flatbuffers.UOffsetT(j*4))
+	}
+	return nil
+}
+
+func (rcv *InstanceStatsMessage) FeaturesLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(20))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
+/// The original subjects with the most messages ingested without a reply
+/// subject, most first. Their producers never get a delivery
+/// confirmation.
+func (rcv *InstanceStatsMessage) TopNoReply(obj *SubjectCount, j int) bool {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(22))
+	if o != 0 {
+		x := rcv._tab.Vector(o)
+		x += flatbuffers.UOffsetT(j) * 4
+		x = rcv._tab.Indirect(x)
+		obj.Init(rcv._tab.Bytes, x)
+		return true
+	}
+	return false
+}
+
+func (rcv *InstanceStatsMessage) TopNoReplyLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(22))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
+/// The progress of the current, or last, key rotation if there has been
+/// one since the instance started.
+func (rcv *InstanceStatsMessage) KeyRotation(obj *KeyRotationStats) *KeyRotationStats {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(24))
+	if o != 0 {
+		x := rcv._tab.Indirect(o + rcv._tab.Pos)
+		if obj == nil {
+			obj = new(KeyRotationStats)
+		}
+		obj.Init(rcv._tab.Bytes, x)
+		return obj
+	}
+	return nil
+}
+
+/// Whether the per-message instrumentation is being sampled because of
+/// the ingest rate. Only set when sampling is configured.
+func (rcv *InstanceStatsMessage) Sampling(obj *SamplingStats) *SamplingStats {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(26))
+	if o != 0 {
+		x := rcv._tab.Indirect(o + rcv._tab.Pos)
+		if obj == nil {
+			obj = new(SamplingStats)
+		}
+		obj.Init(rcv._tab.Bytes, x)
+		return obj
+	}
+	return nil
+}
+
+/// The progress of the long running operations that are running or
+/// finished recently.
+func (rcv *InstanceStatsMessage) Operations(obj *OperationStats, j int) bool {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(28))
+	if o != 0 {
+		x := rcv._tab.Vector(o)
+		x += flatbuffers.UOffsetT(j) * 4
+		x = rcv._tab.Indirect(x)
+		obj.Init(rcv._tab.Bytes, x)
+		return true
+	}
+	return false
+}
+
+func (rcv *InstanceStatsMessage) OperationsLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(28))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
+/// The number of messages removed from the queues because their TTL
+/// elapsed since the instance started.
+func (rcv *InstanceStatsMessage) ExpiredTotal() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(30))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The number of messages removed from the queues because their TTL
+/// elapsed since the instance started.
+func (rcv *InstanceStatsMessage) MutateExpiredTotal(n int64) bool {
+	return rcv._tab.MutateInt64Slot(30, n)
+}
+
 func InstanceStatsMessageStart(builder *flatbuffers.Builder) {
-	builder.StartObject(2)
+	builder.StartObject(14)
 }
 func InstanceStatsMessageAddInstanceId(builder *flatbuffers.Builder, instanceId flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(instanceId), 0)
 }
-func InstanceStatsMessageAddQueues(builder *flatbuffers.Builder, queues flatbuffers.UOffsetT) {
-	builder.PrependUOffsetTSlot(1, flatbuffers.UOffsetT(queues), 0)
+func InstanceStatsMessageAddQueues(builder *flatbuffers.Builder, queues flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(1, flatbuffers.UOffsetT(queues), 0)
+}
+func InstanceStatsMessageStartQueuesVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
+func InstanceStatsMessageAddStorage(builder *flatbuffers.Builder, storage flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(2, flatbuffers.UOffsetT(storage), 0)
+}
+func InstanceStatsMessageAddLabels(builder *flatbuffers.Builder, labels flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(3, flatbuffers.UOffsetT(labels), 0)
+}
+func InstanceStatsMessageStartLabelsVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
+func InstanceStatsMessageAddRejected(builder *flatbuffers.Builder, rejected flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(4, flatbuffers.UOffsetT(rejected), 0)
+}
+func InstanceStatsMessageStartRejectedVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
+func InstanceStatsMessageAddTopIngested(builder *flatbuffers.Builder, topIngested flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(5, flatbuffers.UOffsetT(topIngested), 0)
+}
+func InstanceStatsMessageStartTopIngestedVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
+func InstanceStatsMessageAddTopRepublished(builder *flatbuffers.Builder, topRepublished flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(6, flatbuffers.UOffsetT(topRepublished), 0)
+}
+func InstanceStatsMessageStartTopRepublishedVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
+func InstanceStatsMessageAddVersion(builder *flatbuffers.Builder, version flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(7, flatbuffers.UOffsetT(version), 0)
+}
+func InstanceStatsMessageAddFeatures(builder *flatbuffers.Builder, features flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(8, flatbuffers.UOffsetT(features), 0)
+}
+func InstanceStatsMessageStartFeaturesVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
+func InstanceStatsMessageAddTopNoReply(builder *flatbuffers.Builder, topNoReply flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(9, flatbuffers.UOffsetT(topNoReply), 0)
+}
+func InstanceStatsMessageStartTopNoReplyVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
+func InstanceStatsMessageAddKeyRotation(builder *flatbuffers.Builder, keyRotation flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(10, flatbuffers.UOffsetT(keyRotation), 0)
+}
+func InstanceStatsMessageAddSampling(builder *flatbuffers.Builder, sampling flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(11, flatbuffers.UOffsetT(sampling), 0)
+}
+func InstanceStatsMessageAddOperations(builder *flatbuffers.Builder, operations flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(12, flatbuffers.UOffsetT(operations), 0)
+}
+func InstanceStatsMessageStartOperationsVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
+func InstanceStatsMessageAddExpiredTotal(builder *flatbuffers.Builder, expiredTotal int64) {
+	builder.PrependInt64Slot(13, expiredTotal, 0)
+}
+func InstanceStatsMessageEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	return builder.EndObject()
+}
+/// The sampling of the per-message logs and handlers of an instance.
+type SamplingStats struct {
+	_tab flatbuffers.Table
+}
+
+func GetRootAsSamplingStats(buf []byte, offset flatbuffers.UOffsetT) *SamplingStats {
+	n := flatbuffers.GetUOffsetT(buf[offset:])
+	x := &SamplingStats{}
+	x.Init(buf, n+offset)
+	return x
+}
+
+func (rcv *SamplingStats) Init(buf []byte, i flatbuffers.UOffsetT) {
+	rcv._tab.Bytes = buf
+	rcv._tab.Pos = i
+}
+
+func (rcv *SamplingStats) Table() flatbuffers.Table {
+	return rcv._tab
+}
+
+/// True while only a sample of the messages are instrumented.
+func (rcv *SamplingStats) Active() bool {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(4))
+	if o != 0 {
+		return rcv._tab.GetBool(o + rcv._tab.Pos)
+	}
+	return false
+}
+
+/// True while only a sample of the messages are instrumented.
+func (rcv *SamplingStats) MutateActive(n bool) bool {
+	return rcv._tab.MutateBoolSlot(4, n)
+}
+
+/// The messages ingested per second over the last second, and the rate
+/// above which sampling is switched on.
+func (rcv *SamplingStats) IngestRate() float64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
+	if o != 0 {
+		return rcv._tab.GetFloat64(o + rcv._tab.Pos)
+	}
+	return 0.0
+}
+
+/// The messages ingested per second over the last second, and the rate
+/// above which sampling is switched on.
+func (rcv *SamplingStats) MutateIngestRate(n float64) bool {
+	return rcv._tab.MutateFloat64Slot(6, n)
+}
+
+func (rcv *SamplingStats) Threshold() float64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(8))
+	if o != 0 {
+		return rcv._tab.GetFloat64(o + rcv._tab.Pos)
+	}
+	return 0.0
+}
+
+func (rcv *SamplingStats) MutateThreshold(n float64) bool {
+	return rcv._tab.MutateFloat64Slot(8, n)
+}
+
+/// One in every this many messages is instrumented while sampling.
+func (rcv *SamplingStats) Every() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(10))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// One in every this many messages is instrumented while sampling.
+func (rcv *SamplingStats) MutateEvery(n int64) bool {
+	return rcv._tab.MutateInt64Slot(10, n)
+}
+
+/// The number of times instrumentation was skipped since the instance
+/// started.
+func (rcv *SamplingStats) Skipped() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(12))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The number of times instrumentation was skipped since the instance
+/// started.
+func (rcv *SamplingStats) MutateSkipped(n int64) bool {
+	return rcv._tab.MutateInt64Slot(12, n)
+}
+
+func SamplingStatsStart(builder *flatbuffers.Builder) {
+	builder.StartObject(5)
+}
+func SamplingStatsAddActive(builder *flatbuffers.Builder, active bool) {
+	builder.PrependBoolSlot(0, active, false)
+}
+func SamplingStatsAddIngestRate(builder *flatbuffers.Builder, ingestRate float64) {
+	builder.PrependFloat64Slot(1, ingestRate, 0.0)
+}
+func SamplingStatsAddThreshold(builder *flatbuffers.Builder, threshold float64) {
+	builder.PrependFloat64Slot(2, threshold, 0.0)
+}
+func SamplingStatsAddEvery(builder *flatbuffers.Builder, every int64) {
+	builder.PrependInt64Slot(3, every, 0)
+}
+func SamplingStatsAddSkipped(builder *flatbuffers.Builder, skipped int64) {
+	builder.PrependInt64Slot(4, skipped, 0)
+}
+func SamplingStatsEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	return builder.EndObject()
+}
+/// The progress of re-encrypting stored payloads with new keys.
+type KeyRotationStats struct {
+	_tab flatbuffers.Table
+}
+
+func GetRootAsKeyRotationStats(buf []byte, offset flatbuffers.UOffsetT) *KeyRotationStats {
+	n := flatbuffers.GetUOffsetT(buf[offset:])
+	x := &KeyRotationStats{}
+	x.Init(buf, n+offset)
+	return x
+}
+
+func (rcv *KeyRotationStats) Init(buf []byte, i flatbuffers.UOffsetT) {
+	rcv._tab.Bytes = buf
+	rcv._tab.Pos = i
+}
+
+func (rcv *KeyRotationStats) Table() flatbuffers.Table {
+	return rcv._tab
+}
+
+/// One of running, done, failed, or canceled.
+func (rcv *KeyRotationStats) State() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(4))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// The queue currently being rotated.
+func (rcv *KeyRotationStats) Queue() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// The number of queues being rotated and how many of them are done.
+func (rcv *KeyRotationStats) Queues() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(8))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The number of queues being rotated and how many of them are done.
+func (rcv *KeyRotationStats) MutateQueues(n int64) bool {
+	return rcv._tab.MutateInt64Slot(8, n)
+}
+
+func (rcv *KeyRotationStats) QueuesDone() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(10))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *KeyRotationStats) MutateQueuesDone(n int64) bool {
+	return rcv._tab.MutateInt64Slot(10, n)
+}
+
+/// The number of messages looked at, re-encrypted, and that couldn't be
+/// re-encrypted so far.
+func (rcv *KeyRotationStats) Scanned() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(12))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The number of messages looked at, re-encrypted, and that couldn't be
+/// re-encrypted so far.
+func (rcv *KeyRotationStats) MutateScanned(n int64) bool {
+	return rcv._tab.MutateInt64Slot(12, n)
+}
+
+func (rcv *KeyRotationStats) Reencrypted() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(14))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *KeyRotationStats) MutateReencrypted(n int64) bool {
+	return rcv._tab.MutateInt64Slot(14, n)
+}
+
+func (rcv *KeyRotationStats) Failed() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(16))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *KeyRotationStats) MutateFailed(n int64) bool {
+	return rcv._tab.MutateInt64Slot(16, n)
+}
+
+/// The last error, which is why the rotation failed if it did.
+func (rcv *KeyRotationStats) Error() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(18))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// When the rotation started and last made progress in Unix nanoseconds.
+func (rcv *KeyRotationStats) StartedAt() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(20))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// When the rotation started and last made progress in Unix nanoseconds.
+func (rcv *KeyRotationStats) MutateStartedAt(n int64) bool {
+	return rcv._tab.MutateInt64Slot(20, n)
+}
+
+func (rcv *KeyRotationStats) UpdatedAt() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(22))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *KeyRotationStats) MutateUpdatedAt(n int64) bool {
+	return rcv._tab.MutateInt64Slot(22, n)
+}
+
+func KeyRotationStatsStart(builder *flatbuffers.Builder) {
+	builder.StartObject(10)
+}
+func KeyRotationStatsAddState(builder *flatbuffers.Builder, state flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(state), 0)
+}
+func KeyRotationStatsAddQueue(builder *flatbuffers.Builder, queue flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(1, flatbuffers.UOffsetT(queue), 0)
+}
+func KeyRotationStatsAddQueues(builder *flatbuffers.Builder, queues int64) {
+	builder.PrependInt64Slot(2, queues, 0)
+}
+func KeyRotationStatsAddQueuesDone(builder *flatbuffers.Builder, queuesDone int64) {
+	builder.PrependInt64Slot(3, queuesDone, 0)
+}
+func KeyRotationStatsAddScanned(builder *flatbuffers.Builder, scanned int64) {
+	builder.PrependInt64Slot(4, scanned, 0)
+}
+func KeyRotationStatsAddReencrypted(builder *flatbuffers.Builder, reencrypted int64) {
+	builder.PrependInt64Slot(5, reencrypted, 0)
+}
+func KeyRotationStatsAddFailed(builder *flatbuffers.Builder, failed int64) {
+	builder.PrependInt64Slot(6, failed, 0)
+}
+func KeyRotationStatsAddError(builder *flatbuffers.Builder, error flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(7, flatbuffers.UOffsetT(error), 0)
+}
+func KeyRotationStatsAddStartedAt(builder *flatbuffers.Builder, startedAt int64) {
+	builder.PrependInt64Slot(8, startedAt, 0)
+}
+func KeyRotationStatsAddUpdatedAt(builder *flatbuffers.Builder, updatedAt int64) {
+	builder.PrependInt64Slot(9, updatedAt, 0)
+}
+func KeyRotationStatsEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	return builder.EndObject()
+}
+/// The progress of a long running operation, such as a purge.
+type OperationStats struct {
+	_tab flatbuffers.Table
+}
+
+func GetRootAsOperationStats(buf []byte, offset flatbuffers.UOffsetT) *OperationStats {
+	n := flatbuffers.GetUOffsetT(buf[offset:])
+	x := &OperationStats{}
+	x.Init(buf, n+offset)
+	return x
+}
+
+func (rcv *OperationStats) Init(buf []byte, i flatbuffers.UOffsetT) {
+	rcv._tab.Bytes = buf
+	rcv._tab.Pos = i
+}
+
+func (rcv *OperationStats) Table() flatbuffers.Table {
+	return rcv._tab
+}
+
+func (rcv *OperationStats) Id() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(4))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// One of purge, redrive, migration, reencryption, or replay.
+func (rcv *OperationStats) Kind() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// The queue operated on, if it's only one.
+func (rcv *OperationStats) Queue() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(8))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// One of running, done, failed, or canceled.
+func (rcv *OperationStats) State() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(10))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// How much of the total is done. The total is 0 when it isn't known.
+func (rcv *OperationStats) Done() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(12))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// How much of the total is done. The total is 0 when it isn't known.
+func (rcv *OperationStats) MutateDone(n int64) bool {
+	return rcv._tab.MutateInt64Slot(12, n)
+}
+
+func (rcv *OperationStats) Total() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(14))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *OperationStats) MutateTotal(n int64) bool {
+	return rcv._tab.MutateInt64Slot(14, n)
+}
+
+/// The estimated nanoseconds until it's done. 0 when it isn't known.
+func (rcv *OperationStats) Eta() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(16))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The estimated nanoseconds until it's done. 0 when it isn't known.
+func (rcv *OperationStats) MutateEta(n int64) bool {
+	return rcv._tab.MutateInt64Slot(16, n)
+}
+
+/// Why the operation failed if it did.
+func (rcv *OperationStats) Error() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(18))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// When the operation started and last made progress in Unix
+/// nanoseconds.
+func (rcv *OperationStats) StartedAt() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(20))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// When the operation started and last made progress in Unix
+/// nanoseconds.
+func (rcv *OperationStats) MutateStartedAt(n int64) bool {
+	return rcv._tab.MutateInt64Slot(20, n)
+}
+
+func (rcv *OperationStats) UpdatedAt() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(22))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *OperationStats) MutateUpdatedAt(n int64) bool {
+	return rcv._tab.MutateInt64Slot(22, n)
+}
+
+func OperationStatsStart(builder *flatbuffers.Builder) {
+	builder.StartObject(10)
+}
+func OperationStatsAddId(builder *flatbuffers.Builder, id flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(id), 0)
+}
+func OperationStatsAddKind(builder *flatbuffers.Builder, kind flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(1, flatbuffers.UOffsetT(kind), 0)
+}
+func OperationStatsAddQueue(builder *flatbuffers.Builder, queue flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(2, flatbuffers.UOffsetT(queue), 0)
+}
+func OperationStatsAddState(builder *flatbuffers.Builder, state flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(3, flatbuffers.UOffsetT(state), 0)
+}
+func OperationStatsAddDone(builder *flatbuffers.Builder, done int64) {
+	builder.PrependInt64Slot(4, done, 0)
+}
+func OperationStatsAddTotal(builder *flatbuffers.Builder, total int64) {
+	builder.PrependInt64Slot(5, total, 0)
+}
+func OperationStatsAddEta(builder *flatbuffers.Builder, eta int64) {
+	builder.PrependInt64Slot(6, eta, 0)
+}
+func OperationStatsAddError(builder *flatbuffers.Builder, error flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(7, flatbuffers.UOffsetT(error), 0)
+}
+func OperationStatsAddStartedAt(builder *flatbuffers.Builder, startedAt int64) {
+	builder.PrependInt64Slot(8, startedAt, 0)
+}
+func OperationStatsAddUpdatedAt(builder *flatbuffers.Builder, updatedAt int64) {
+	builder.PrependInt64Slot(9, updatedAt, 0)
+}
+func OperationStatsEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	return builder.EndObject()
+}
+/// A count of messages for an original subject.
+type SubjectCount struct {
+	_tab flatbuffers.Table
+}
+
+func GetRootAsSubjectCount(buf []byte, offset flatbuffers.UOffsetT) *SubjectCount {
+	n := flatbuffers.GetUOffsetT(buf[offset:])
+	x := &SubjectCount{}
+	x.Init(buf, n+offset)
+	return x
+}
+
+func (rcv *SubjectCount) Init(buf []byte, i flatbuffers.UOffsetT) {
+	rcv._tab.Bytes = buf
+	rcv._tab.Pos = i
+}
+
+func (rcv *SubjectCount) Table() flatbuffers.Table {
+	return rcv._tab
+}
+
+func (rcv *SubjectCount) Subject() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(4))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+func (rcv *SubjectCount) Count() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *SubjectCount) MutateCount(n int64) bool {
+	return rcv._tab.MutateInt64Slot(6, n)
+}
+
+func SubjectCountStart(builder *flatbuffers.Builder) {
+	builder.StartObject(2)
+}
+func SubjectCountAddSubject(builder *flatbuffers.Builder, subject flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(subject), 0)
+}
+func SubjectCountAddCount(builder *flatbuffers.Builder, count int64) {
+	builder.PrependInt64Slot(1, count, 0)
+}
+func SubjectCountEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	return builder.EndObject()
+}
+/// A count of events for a reason.
+type ReasonCount struct {
+	_tab flatbuffers.Table
+}
+
+func GetRootAsReasonCount(buf []byte, offset flatbuffers.UOffsetT) *ReasonCount {
+	n := flatbuffers.GetUOffsetT(buf[offset:])
+	x := &ReasonCount{}
+	x.Init(buf, n+offset)
+	return x
+}
+
+func (rcv *ReasonCount) Init(buf []byte, i flatbuffers.UOffsetT) {
+	rcv._tab.Bytes = buf
+	rcv._tab.Pos = i
+}
+
+func (rcv *ReasonCount) Table() flatbuffers.Table {
+	return rcv._tab
+}
+
+func (rcv *ReasonCount) Reason() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(4))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+func (rcv *ReasonCount) Count() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *ReasonCount) MutateCount(n int64) bool {
+	return rcv._tab.MutateInt64Slot(6, n)
+}
+
+func ReasonCountStart(builder *flatbuffers.Builder) {
+	builder.StartObject(2)
+}
+func ReasonCountAddReason(builder *flatbuffers.Builder, reason flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(reason), 0)
+}
+func ReasonCountAddCount(builder *flatbuffers.Builder, count int64) {
+	builder.PrependInt64Slot(1, count, 0)
+}
+func ReasonCountEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	return builder.EndObject()
+}
+/// A static key value label.
+type Label struct {
+	_tab flatbuffers.Table
+}
+
+func GetRootAsLabel(buf []byte, offset flatbuffers.UOffsetT) *Label {
+	n := flatbuffers.GetUOffsetT(buf[offset:])
+	x := &Label{}
+	x.Init(buf, n+offset)
+	return x
+}
+
+func (rcv *Label) Init(buf []byte, i flatbuffers.UOffsetT) {
+	rcv._tab.Bytes = buf
+	rcv._tab.Pos = i
+}
+
+func (rcv *Label) Table() flatbuffers.Table {
+	return rcv._tab
+}
+
+func (rcv *Label) Key() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(4))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+func (rcv *Label) Value() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+func LabelStart(builder *flatbuffers.Builder) {
+	builder.StartObject(2)
+}
+func LabelAddKey(builder *flatbuffers.Builder, key flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(key), 0)
+}
+func LabelAddValue(builder *flatbuffers.Builder, value flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(1, flatbuffers.UOffsetT(value), 0)
+}
+func LabelEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	return builder.EndObject()
+}
+/// The stats for the Badger store of an instance.
+type StorageStats struct {
+	_tab flatbuffers.Table
+}
+
+func GetRootAsStorageStats(buf []byte, offset flatbuffers.UOffsetT) *StorageStats {
+	n := flatbuffers.GetUOffsetT(buf[offset:])
+	x := &StorageStats{}
+	x.Init(buf, n+offset)
+	return x
+}
+
+func (rcv *StorageStats) Init(buf []byte, i flatbuffers.UOffsetT) {
+	rcv._tab.Bytes = buf
+	rcv._tab.Pos = i
+}
+
+func (rcv *StorageStats) Table() flatbuffers.Table {
+	return rcv._tab
+}
+
+/// The size of the LSM tree in bytes.
+func (rcv *StorageStats) LsmSize() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(4))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The size of the LSM tree in bytes.
+func (rcv *StorageStats) MutateLsmSize(n int64) bool {
+	return rcv._tab.MutateInt64Slot(4, n)
+}
+
+/// The size of the value log in bytes.
+func (rcv *StorageStats) VlogSize() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The size of the value log in bytes.
+func (rcv *StorageStats) MutateVlogSize(n int64) bool {
+	return rcv._tab.MutateInt64Slot(6, n)
+}
+
+/// The number of tables across all levels.
+func (rcv *StorageStats) NumTables() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(8))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The number of tables across all levels.
+func (rcv *StorageStats) MutateNumTables(n int64) bool {
+	return rcv._tab.MutateInt64Slot(8, n)
+}
+
+/// The number of level 0 tables waiting to be compacted.
+func (rcv *StorageStats) Level0Tables() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(10))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The number of level 0 tables waiting to be compacted.
+func (rcv *StorageStats) MutateLevel0Tables(n int64) bool {
+	return rcv._tab.MutateInt64Slot(10, n)
+}
+
+/// The hit ratio of the block cache between 0 and 1.
+func (rcv *StorageStats) BlockCacheHitRatio() float64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(12))
+	if o != 0 {
+		return rcv._tab.GetFloat64(o + rcv._tab.Pos)
+	}
+	return 0.0
+}
+
+/// The hit ratio of the block cache between 0 and 1.
+func (rcv *StorageStats) MutateBlockCacheHitRatio(n float64) bool {
+	return rcv._tab.MutateFloat64Slot(12, n)
+}
+
+/// The number of times the value log has been garbage collected.
+func (rcv *StorageStats) VlogGcRuns() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(14))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The number of times the value log has been garbage collected.
+func (rcv *StorageStats) MutateVlogGcRuns(n int64) bool {
+	return rcv._tab.MutateInt64Slot(14, n)
+}
+
+/// The bytes of the value log reclaimed by garbage collection.
+func (rcv *StorageStats) VlogReclaimedBytes() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(16))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The bytes of the value log reclaimed by garbage collection.
+func (rcv *StorageStats) MutateVlogReclaimedBytes(n int64) bool {
+	return rcv._tab.MutateInt64Slot(16, n)
+}
+
+func StorageStatsStart(builder *flatbuffers.Builder) {
+	builder.StartObject(7)
+}
+func StorageStatsAddLsmSize(builder *flatbuffers.Builder, lsmSize int64) {
+	builder.PrependInt64Slot(0, lsmSize, 0)
+}
+func StorageStatsAddVlogSize(builder *flatbuffers.Builder, vlogSize int64) {
+	builder.PrependInt64Slot(1, vlogSize, 0)
+}
+func StorageStatsAddNumTables(builder *flatbuffers.Builder, numTables int64) {
+	builder.PrependInt64Slot(2, numTables, 0)
+}
+func StorageStatsAddLevel0Tables(builder *flatbuffers.Builder, level0Tables int64) {
+	builder.PrependInt64Slot(3, level0Tables, 0)
+}
+func StorageStatsAddBlockCacheHitRatio(builder *flatbuffers.Builder, blockCacheHitRatio float64) {
+	builder.PrependFloat64Slot(4, blockCacheHitRatio, 0.0)
 }
-func InstanceStatsMessageStartQueuesVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
-	return builder.StartVector(4, numElems, 4)
+func StorageStatsAddVlogGcRuns(builder *flatbuffers.Builder, vlogGcRuns int64) {
+	builder.PrependInt64Slot(5, vlogGcRuns, 0)
 }
-func InstanceStatsMessageEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+func StorageStatsAddVlogReclaimedBytes(builder *flatbuffers.Builder, vlogReclaimedBytes int64) {
+	builder.PrependInt64Slot(6, vlogReclaimedBytes, 0)
+}
+func StorageStatsEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
 	return builder.EndObject()
 }
 /// The stats for a queue.
@@ -102,7 +1137,6 @@ func (rcv *QueueStatsMessage) QueueName() []byte {
 	return nil
 }
 
-/// The name of the queue.
 /// The number of messages in the queue.
 func (rcv *QueueStatsMessage) Enqueued() int64 {
 	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
@@ -131,8 +1165,170 @@ func (rcv *QueueStatsMessage) MutateInFlight(n int64) bool {
 	return rcv._tab.MutateInt64Slot(8, n)
 }
 
+/// The unique id of the instance the queue belongs to. Only set when the
+/// stats for the queue are published on their own.
+func (rcv *QueueStatsMessage) InstanceId() []byte {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(10))
+	if o != 0 {
+		return rcv._tab.ByteVector(o + rcv._tab.Pos)
+	}
+	return nil
+}
+
+/// Latency from receiving a message to acknowledging it was persisted.
+func (rcv *QueueStatsMessage) PersistLatency(obj *LatencyStats) *LatencyStats {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(12))
+	if o != 0 {
+		x := rcv._tab.Indirect(o + rcv._tab.Pos)
+		if obj == nil {
+			obj = new(LatencyStats)
+		}
+		obj.Init(rcv._tab.Bytes, x)
+		return obj
+	}
+	return nil
+}
+
+/// Latency from a message being enqueued to it being successfully
+/// republished, including any delay.
+func (rcv *QueueStatsMessage) RepublishLatency(obj *LatencyStats) *LatencyStats {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(14))
+	if o != 0 {
+		x := rcv._tab.Indirect(o + rcv._tab.Pos)
+		if obj == nil {
+			obj = new(LatencyStats)
+		}
+		obj.Init(rcv._tab.Bytes, x)
+		return obj
+	}
+	return nil
+}
+
+/// Static labels for the instance the queue belongs to. Only set when the
+/// stats for the queue are published on their own.
+func (rcv *QueueStatsMessage) Labels(obj *Label, j int) bool {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(16))
+	if o != 0 {
+		x := rcv._tab.Vector(o)
+		x += flatbuffers.UOffsetT(j) * 4
+		x = rcv._tab.Indirect(x)
+		obj.Init(rcv._tab.Bytes, x)
+		return true
+	}
+	return false
+}
+
+func (rcv *QueueStatsMessage) LabelsLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(16))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
+/// A histogram of the age of the messages in the queue, youngest bucket
+/// first.
+func (rcv *QueueStatsMessage) Age(obj *AgeBucket, j int) bool {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(18))
+	if o != 0 {
+		x := rcv._tab.Vector(o)
+		x += flatbuffers.UOffsetT(j) * 4
+		x = rcv._tab.Indirect(x)
+		obj.Init(rcv._tab.Bytes, x)
+		return true
+	}
+	return false
+}
+
+func (rcv *QueueStatsMessage) AgeLength() int {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(18))
+	if o != 0 {
+		return rcv._tab.VectorLen(o)
+	}
+	return 0
+}
+
+/// The number of messages removed from the queue because their TTL
+/// elapsed since the instance started.
+func (rcv *QueueStatsMessage) Expired() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(20))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The number of messages removed from the queue because their TTL
+/// elapsed since the instance started.
+func (rcv *QueueStatsMessage) MutateExpired(n int64) bool {
+	return rcv._tab.MutateInt64Slot(20, n)
+}
+
+/// The number of messages enqueued since the instance started.
+func (rcv *QueueStatsMessage) EnqueuedTotal() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(22))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The number of messages enqueued since the instance started.
+func (rcv *QueueStatsMessage) MutateEnqueuedTotal(n int64) bool {
+	return rcv._tab.MutateInt64Slot(22, n)
+}
+
+/// The number of messages enqueued per second between the last two
+/// refreshes of the stats.
+func (rcv *QueueStatsMessage) EnqueueRate() float64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(24))
+	if o != 0 {
+		return rcv._tab.GetFloat64(o + rcv._tab.Pos)
+	}
+	return 0.0
+}
+
+/// The number of messages enqueued per second between the last two
+/// refreshes of the stats.
+func (rcv *QueueStatsMessage) MutateEnqueueRate(n float64) bool {
+	return rcv._tab.MutateFloat64Slot(24, n)
+}
+
+/// When the oldest message in the queue was first enqueued in Unix
+/// nanoseconds, as of the last refresh of the stats. Zero if the queue
+/// was empty.
+func (rcv *QueueStatsMessage) OldestEnqueuedAt() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(26))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// When the oldest message in the queue was first enqueued in Unix
+/// nanoseconds, as of the last refresh of the stats. Zero if the queue
+/// was empty.
+func (rcv *QueueStatsMessage) MutateOldestEnqueuedAt(n int64) bool {
+	return rcv._tab.MutateInt64Slot(26, n)
+}
+
+/// The batches of messages committed to the queue since the instance
+/// started.
+func (rcv *QueueStatsMessage) BatchCommits(obj *BatchCommitStats) *BatchCommitStats {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(28))
+	if o != 0 {
+		x := rcv._tab.Indirect(o + rcv._tab.Pos)
+		if obj == nil {
+			obj = new(BatchCommitStats)
+		}
+		obj.Init(rcv._tab.Bytes, x)
+		return obj
+	}
+	return nil
+}
+
 func QueueStatsMessageStart(builder *flatbuffers.Builder) {
-	builder.StartObject(3)
+	builder.StartObject(13)
 }
 func QueueStatsMessageAddQueueName(builder *flatbuffers.Builder, queueName flatbuffers.UOffsetT) {
 	builder.PrependUOffsetTSlot(0, flatbuffers.UOffsetT(queueName), 0)
@@ -143,6 +1339,264 @@ func QueueStatsMessageAddEnqueued(builder *flatbuffers.Builder, enqueued int64)
 func QueueStatsMessageAddInFlight(builder *flatbuffers.Builder, inFlight int64) {
 	builder.PrependInt64Slot(2, inFlight, 0)
 }
+func QueueStatsMessageAddInstanceId(builder *flatbuffers.Builder, instanceId flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(3, flatbuffers.UOffsetT(instanceId), 0)
+}
+func QueueStatsMessageAddPersistLatency(builder *flatbuffers.Builder, persistLatency flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(4, flatbuffers.UOffsetT(persistLatency), 0)
+}
+func QueueStatsMessageAddRepublishLatency(builder *flatbuffers.Builder, republishLatency flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(5, flatbuffers.UOffsetT(republishLatency), 0)
+}
+func QueueStatsMessageAddLabels(builder *flatbuffers.Builder, labels flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(6, flatbuffers.UOffsetT(labels), 0)
+}
+func QueueStatsMessageStartLabelsVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
+func QueueStatsMessageAddAge(builder *flatbuffers.Builder, age flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(7, flatbuffers.UOffsetT(age), 0)
+}
+func QueueStatsMessageStartAgeVector(builder *flatbuffers.Builder, numElems int) flatbuffers.UOffsetT {
+	return builder.StartVector(4, numElems, 4)
+}
+func QueueStatsMessageAddExpired(builder *flatbuffers.Builder, expired int64) {
+	builder.PrependInt64Slot(8, expired, 0)
+}
+func QueueStatsMessageAddEnqueuedTotal(builder *flatbuffers.Builder, enqueuedTotal int64) {
+	builder.PrependInt64Slot(9, enqueuedTotal, 0)
+}
+func QueueStatsMessageAddEnqueueRate(builder *flatbuffers.Builder, enqueueRate float64) {
+	builder.PrependFloat64Slot(10, enqueueRate, 0.0)
+}
+func QueueStatsMessageAddOldestEnqueuedAt(builder *flatbuffers.Builder, oldestEnqueuedAt int64) {
+	builder.PrependInt64Slot(11, oldestEnqueuedAt, 0)
+}
+func QueueStatsMessageAddBatchCommits(builder *flatbuffers.Builder, batchCommits flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(12, flatbuffers.UOffsetT(batchCommits), 0)
+}
 func QueueStatsMessageEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
 	return builder.EndObject()
 }
+/// The batches of writes committed to a queue.
+type BatchCommitStats struct {
+	_tab flatbuffers.Table
+}
+
+func GetRootAsBatchCommitStats(buf []byte, offset flatbuffers.UOffsetT) *BatchCommitStats {
+	n := flatbuffers.GetUOffsetT(buf[offset:])
+	x := &BatchCommitStats{}
+	x.Init(buf, n+offset)
+	return x
+}
+
+func (rcv *BatchCommitStats) Init(buf []byte, i flatbuffers.UOffsetT) {
+	rcv._tab.Bytes = buf
+	rcv._tab.Pos = i
+}
+
+func (rcv *BatchCommitStats) Table() flatbuffers.Table {
+	return rcv._tab
+}
+
+/// The number of batches committed, and the messages and bytes in them.
+func (rcv *BatchCommitStats) Commits() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(4))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The number of batches committed, and the messages and bytes in them.
+func (rcv *BatchCommitStats) MutateCommits(n int64) bool {
+	return rcv._tab.MutateInt64Slot(4, n)
+}
+
+func (rcv *BatchCommitStats) Entries() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *BatchCommitStats) MutateEntries(n int64) bool {
+	return rcv._tab.MutateInt64Slot(6, n)
+}
+
+func (rcv *BatchCommitStats) Bytes() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(8))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *BatchCommitStats) MutateBytes(n int64) bool {
+	return rcv._tab.MutateInt64Slot(8, n)
+}
+
+/// How long the batches took to commit.
+func (rcv *BatchCommitStats) Latency(obj *LatencyStats) *LatencyStats {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(10))
+	if o != 0 {
+		x := rcv._tab.Indirect(o + rcv._tab.Pos)
+		if obj == nil {
+			obj = new(LatencyStats)
+		}
+		obj.Init(rcv._tab.Bytes, x)
+		return obj
+	}
+	return nil
+}
+
+func BatchCommitStatsStart(builder *flatbuffers.Builder) {
+	builder.StartObject(4)
+}
+func BatchCommitStatsAddCommits(builder *flatbuffers.Builder, commits int64) {
+	builder.PrependInt64Slot(0, commits, 0)
+}
+func BatchCommitStatsAddEntries(builder *flatbuffers.Builder, entries int64) {
+	builder.PrependInt64Slot(1, entries, 0)
+}
+func BatchCommitStatsAddBytes(builder *flatbuffers.Builder, bytes int64) {
+	builder.PrependInt64Slot(2, bytes, 0)
+}
+func BatchCommitStatsAddLatency(builder *flatbuffers.Builder, latency flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(3, flatbuffers.UOffsetT(latency), 0)
+}
+func BatchCommitStatsEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	return builder.EndObject()
+}
+/// The number of messages younger than a max age.
+type AgeBucket struct {
+	_tab flatbuffers.Table
+}
+
+func GetRootAsAgeBucket(buf []byte, offset flatbuffers.UOffsetT) *AgeBucket {
+	n := flatbuffers.GetUOffsetT(buf[offset:])
+	x := &AgeBucket{}
+	x.Init(buf, n+offset)
+	return x
+}
+
+func (rcv *AgeBucket) Init(buf []byte, i flatbuffers.UOffsetT) {
+	rcv._tab.Bytes = buf
+	rcv._tab.Pos = i
+}
+
+func (rcv *AgeBucket) Table() flatbuffers.Table {
+	return rcv._tab
+}
+
+/// The max age in nanoseconds. Zero means the bucket is unbounded.
+func (rcv *AgeBucket) MaxAge() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(4))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+/// The max age in nanoseconds. Zero means the bucket is unbounded.
+func (rcv *AgeBucket) MutateMaxAge(n int64) bool {
+	return rcv._tab.MutateInt64Slot(4, n)
+}
+
+func (rcv *AgeBucket) Count() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *AgeBucket) MutateCount(n int64) bool {
+	return rcv._tab.MutateInt64Slot(6, n)
+}
+
+func AgeBucketStart(builder *flatbuffers.Builder) {
+	builder.StartObject(2)
+}
+func AgeBucketAddMaxAge(builder *flatbuffers.Builder, maxAge int64) {
+	builder.PrependInt64Slot(0, maxAge, 0)
+}
+func AgeBucketAddCount(builder *flatbuffers.Builder, count int64) {
+	builder.PrependInt64Slot(1, count, 0)
+}
+func AgeBucketEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	return builder.EndObject()
+}
+/// Latency percentiles in nanoseconds.
+type LatencyStats struct {
+	_tab flatbuffers.Table
+}
+
+func GetRootAsLatencyStats(buf []byte, offset flatbuffers.UOffsetT) *LatencyStats {
+	n := flatbuffers.GetUOffsetT(buf[offset:])
+	x := &LatencyStats{}
+	x.Init(buf, n+offset)
+	return x
+}
+
+func (rcv *LatencyStats) Init(buf []byte, i flatbuffers.UOffsetT) {
+	rcv._tab.Bytes = buf
+	rcv._tab.Pos = i
+}
+
+func (rcv *LatencyStats) Table() flatbuffers.Table {
+	return rcv._tab
+}
+
+func (rcv *LatencyStats) P50() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(4))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *LatencyStats) MutateP50(n int64) bool {
+	return rcv._tab.MutateInt64Slot(4, n)
+}
+
+func (rcv *LatencyStats) P95() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(6))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *LatencyStats) MutateP95(n int64) bool {
+	return rcv._tab.MutateInt64Slot(6, n)
+}
+
+func (rcv *LatencyStats) P99() int64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(8))
+	if o != 0 {
+		return rcv._tab.GetInt64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *LatencyStats) MutateP99(n int64) bool {
+	return rcv._tab.MutateInt64Slot(8, n)
+}
+
+func LatencyStatsStart(builder *flatbuffers.Builder) {
+	builder.StartObject(3)
+}
+func LatencyStatsAddP50(builder *flatbuffers.Builder, p50 int64) {
+	builder.PrependInt64Slot(0, p50, 0)
+}
+func LatencyStatsAddP95(builder *flatbuffers.Builder, p95 int64) {
+	builder.PrependInt64Slot(1, p95, 0)
+}
+func LatencyStatsAddP99(builder *flatbuffers.Builder, p99 int64) {
+	builder.PrependInt64Slot(2, p99, 0)
+}
+func LatencyStatsEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	return builder.EndObject()
+}
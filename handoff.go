@@ -0,0 +1,130 @@
+package requeue
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofrs/uuid"
+	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/internal/ticker"
+	"github.com/rs/zerolog/log"
+)
+
+// HandoffInterval has an instance that doesn't republish hand off the store it
+// writes to every interval, so the messages it ingests are drained elsewhere.
+// The store is closed and a new one is opened in the data dir in its place, so
+// the reaper of a RoleRepublish instance sharing the data dir merges the closed
+// one in and republishes its messages. Nothing is handed off while the store
+// is empty.
+//
+// The instance doesn't reap the stores in its data dir itself, since it would
+// merge them back in where they're never republished. It's required for
+// RoleIngest and needs a store on disk.
+func HandoffInterval(interval time.Duration) Option {
+	return func(o *Options) error {
+		if interval < 0 {
+			return fmt.Errorf("handoff interval cannot be negative: %s", interval)
+		}
+		o.handoffInterval = interval
+		return nil
+	}
+}
+
+func (c *Conn) initHandoff() error {
+	if c.Opts.handoffInterval == 0 {
+		return nil
+	}
+
+	c.closers.handoff.AddRunning(1)
+	go func() {
+		defer c.closers.handoff.Done()
+		t := ticker.New(c.Opts.handoffInterval)
+		go func() {
+			<-c.closers.handoff.HasBeenClosed()
+			t.Stop()
+		}()
+		t.Loop(func() bool {
+			if err := c.handOff(); err != nil {
+				log.Err(err).Msg("problem handing off the store")
+			}
+			return true
+		})
+	}()
+
+	return nil
+}
+
+// handOff closes the store once a new one has been opened in the data dir to
+// be written to in its place.
+func (c *Conn) handOff() error {
+	if m := c.Manager(); m == nil || !hasMessages(m) {
+		return nil
+	}
+
+	c.mu.Lock()
+	from := c.instanceDir
+	retired, err := c.replaceStore()
+	to := c.instanceDir
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("hand off: %w", err)
+	}
+
+	// Closing the queues waits on their writes, so it mustn't be done with
+	// the lock held.
+	retired.close()
+	log.Info().Str("handedOff", from).Str("instanceDir", to).Msg("handed off the store")
+	return nil
+}
+
+// hasMessages returns true if any of the queues of m has messages stored.
+func hasMessages(m *queue.Manager) bool {
+	for _, q := range m.Queues() {
+		if q.Stats.Count() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceStore opens a new store in the data dir and has it written to in
+// place of the current one, which is returned to be closed. The new store is
+// in a directory of its own rather than the one named after the instance, so
+// the instance isn't reclaimed from it on restart. Should be called with the
+// lock acquired.
+func (c *Conn) replaceStore() (retiredStore, error) {
+	old := retiredStore{db: c.badgerDB, qManager: c.qManager}
+
+	dir := badgerInternal.InstanceDir(c.Opts.dataDir, uuid.Must(uuid.NewV4()).String())
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return retiredStore{}, fmt.Errorf("create store directory: %w", err)
+	}
+	db, err := badgerInternal.Open(dir, c.badgerOpenOptions()...)
+	if err != nil {
+		return retiredStore{}, err
+	}
+	if err := badgerInternal.WriteInstanceID(db, c.instanceId); err != nil {
+		db.Close()
+		return retiredStore{}, err
+	}
+
+	c.badgerDB = db
+	manager, err := c.newQueueManager()
+	if err != nil {
+		c.badgerDB = old.db
+		db.Close()
+		return retiredStore{}, err
+	}
+	c.qManager = manager
+	c.instanceDir = dir
+
+	if c.statsPublisher != nil {
+		c.statsPublisher.SetStore(c.qManager, c.badgerDB)
+	}
+	if c.vlogGC != nil {
+		c.vlogGC.SetStore(c.badgerDB, c.instanceDir)
+	}
+	return old, nil
+}
@@ -0,0 +1,72 @@
+package requeue
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/internal/reaper"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandOff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handoff-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	assert.NoError(t, InstanceRole(RoleIngest)(&o))
+	assert.NoError(t, HandoffInterval(time.Hour)(&o))
+	c := NewConn(o)
+	assert.NoError(t, c.initBadger())
+	defer c.Close()
+	assert.NoError(t, c.initQueueManager())
+
+	// Nothing is handed off while the store is empty.
+	instanceDir := c.instanceDir
+	assert.NoError(t, c.handOff())
+	assert.Equal(t, instanceDir, c.instanceDir)
+
+	q, err := c.Manager().UpsertQueueState(queue.NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+	m := protocol.DefaultRequeueMessage()
+	m.OriginalSubject = "orders.created"
+	k := key.New(time.Now())
+	committed := make(chan error, 1)
+	assert.NoError(t, q.AddMessage(queue.NewQueueKeyForMessage("orders", k).Bytes(), m.Bytes(), 0, func(err error) {
+		committed <- err
+	}))
+	q.Flush()
+	assert.NoError(t, <-committed)
+
+	assert.NoError(t, c.handOff())
+	assert.NotEqual(t, instanceDir, c.instanceDir)
+	assert.Equal(t, dir, filepath.Dir(c.instanceDir))
+	assert.Empty(t, c.Manager().Queues())
+
+	// The instance keeps ingesting into the new store.
+	_, err = c.Manager().UpsertQueueState(queue.NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+
+	// A republishing instance sharing the data dir merges in the store
+	// handed off.
+	republisherDir, err := ioutil.TempDir("", "handoff-republisher-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(republisherDir)
+	db, err := badgerInternal.Open(republisherDir)
+	assert.NoError(t, err)
+	defer db.Close()
+	reaped, err := reaper.Reap(db, dir, filepath.Base(instanceDir))
+	assert.NoError(t, err)
+	assert.True(t, reaped)
+	_, ok, err := queue.GetMessage(db, "orders", k)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,173 @@
+package requeue
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/internal/ticker"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultHealthCheckInterval is how often the watchdog verifies the
+// subscription and consumers are healthy.
+const DefaultHealthCheckInterval = 10 * time.Second
+
+// HealthCheckInterval sets how often the watchdog verifies the subscription is
+// still valid, its pending messages are moving, and the consumers are alive.
+// A zero interval disables the watchdog.
+func HealthCheckInterval(interval time.Duration) Option {
+	return func(o *Options) error {
+		if interval < 0 {
+			return fmt.Errorf("health check interval cannot be negative: %s", interval)
+		}
+		o.healthCheckInterval = interval
+		return nil
+	}
+}
+
+// HealthEventHandler sets a callback that will be triggered for every
+// HealthEvent. The events are also published on
+// protocol.HealthEventsSubject.
+func HealthEventHandler(cb func(protocol.HealthEvent)) Option {
+	return func(o *Options) error {
+		o.healthEventCB = cb
+		return nil
+	}
+}
+
+// watchdog periodically checks the health of the ingest side of a Conn and
+// attempts to heal it when it's degraded.
+type watchdog struct {
+	c *Conn
+
+	// Snapshot from the previous check used to detect a stalled subscription.
+	lastDelivered int64
+	lastPending   int
+
+	status protocol.HealthStatus
+}
+
+func (c *Conn) initWatchdog() error {
+	// There is nothing to watch without an ingest subscription.
+	if c.Opts.healthCheckInterval == 0 || c.Opts.ingestDisabled {
+		return nil
+	}
+
+	w := &watchdog{
+		c:      c,
+		status: protocol.HealthStatusHealthy,
+	}
+
+	c.closers.watchdog.AddRunning(1)
+	go func() {
+		defer c.closers.watchdog.Done()
+		t := ticker.New(c.Opts.healthCheckInterval)
+		go func() {
+			<-c.closers.watchdog.HasBeenClosed()
+			t.Stop()
+		}()
+		t.Loop(func() bool {
+			w.check()
+			return true
+		})
+	}()
+
+	return nil
+}
+
+func (w *watchdog) check() {
+	c := w.c
+	c.mu.RLock()
+	nc := c.nc
+	sub := c.sub
+	left := c.leftQueueGroup
+	c.mu.RUnlock()
+
+	// Reconnecting is handled by the nats client. There is nothing we can
+	// verify until it's connected again.
+	if nc == nil || !nc.IsConnected() {
+		return
+	}
+
+	reasons := make([]string, 0)
+	healed := true
+
+	// The subscription was drained on purpose when the instance left the
+	// queue group.
+	if left {
+		w.lastDelivered, w.lastPending = 0, 0
+	} else if sub == nil || !sub.IsValid() {
+		reasons = append(reasons, "subscription is no longer valid")
+		if err := c.resubscribe(); err != nil {
+			log.Err(err).Msg("watchdog: unable to resubscribe")
+			healed = false
+		}
+		w.lastDelivered, w.lastPending = 0, 0
+	} else {
+		pending, _, pErr := sub.Pending()
+		delivered, dErr := sub.Delivered()
+		if pErr == nil && dErr == nil {
+			if pending > 0 && w.lastPending > 0 && delivered == w.lastDelivered {
+				reasons = append(reasons, fmt.Sprintf("subscription is stalled with %d pending messages", pending))
+				// There is nothing we can do if the consumers are alive and
+				// simply not keeping up. If some died, restarting them below
+				// should get things moving again.
+				if atomic.LoadInt32(&c.natsConsumersAlive) >= int32(c.numNatsConsumers()) {
+					healed = false
+				}
+			}
+			w.lastDelivered, w.lastPending = delivered, pending
+		}
+	}
+
+	if r := c.checkStorage(); len(r) > 0 {
+		reasons = append(reasons, r...)
+		healed = false
+	}
+
+	if alive, want := atomic.LoadInt32(&c.natsConsumersAlive), int32(c.numNatsConsumers()); alive < want {
+		reasons = append(reasons, fmt.Sprintf("%d of %d consumers are alive", alive, want))
+		c.restartNatsConsumers(int(want - alive))
+	}
+
+	// There is nothing the watchdog can do about an instance that isn't
+	// keeping up, other than let it shed traffic.
+	if r := c.checkReadiness(); !r.Ready && !left {
+		reasons = append(reasons, r.Reasons...)
+		healed = false
+	}
+
+	if len(reasons) > 0 {
+		log.Warn().Strs("reasons", reasons).Bool("healed", healed).Msg("watchdog: health is degraded")
+		w.status = protocol.HealthStatusDegraded
+		c.publishHealthEvent(protocol.HealthEvent{
+			Status:  protocol.HealthStatusDegraded,
+			Reasons: reasons,
+			Healed:  healed,
+		})
+		return
+	}
+
+	if w.status != protocol.HealthStatusHealthy {
+		log.Info().Msg("watchdog: health has recovered")
+		w.status = protocol.HealthStatusHealthy
+		c.publishHealthEvent(protocol.HealthEvent{
+			Status: protocol.HealthStatusHealthy,
+			Healed: true,
+		})
+	}
+}
+
+func (c *Conn) publishHealthEvent(e protocol.HealthEvent) {
+	e.InstanceID = c.instanceId
+	e.Labels = c.Opts.labels
+	e.Time = time.Now()
+
+	if cb := c.Opts.healthEventCB; cb != nil {
+		c.hook(func() { cb(e) })
+	}
+
+	c.publishEvent(protocol.HealthEventsSubject, e)
+}
@@ -0,0 +1,283 @@
+package requeue
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+	"github.com/nickpoorman/nats-requeue/internal/reaper"
+	"github.com/nickpoorman/nats-requeue/internal/ticker"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultHeartbeatInterval is a reasonable interval to publish heartbeats
+	// at.
+	DefaultHeartbeatInterval = 5 * time.Second
+
+	// heartbeatMisses is how many heartbeats in a row a peer can miss before
+	// it's taken for dead.
+	heartbeatMisses = 3
+
+	// peerForgetAfter is how long a dead peer that can't be taken over is
+	// still reported for.
+	peerForgetAfter = time.Hour
+)
+
+// Heartbeats publishes a heartbeat on protocol.HeartbeatSubject every interval,
+// and keeps track of the other instances from theirs. An instance that misses
+// three heartbeats in a row is taken for dead, and is taken over if Takeover
+// is configured. Every instance should publish them at a similar interval.
+func Heartbeats(interval time.Duration) Option {
+	return func(o *Options) error {
+		if interval <= 0 {
+			return fmt.Errorf("heartbeat interval must be positive: %s", interval)
+		}
+		o.heartbeatInterval = interval
+		return nil
+	}
+}
+
+// Takeover sets the data dirs, such as shared volumes, where the stores of
+// other instances can be reached. Once a peer is taken for dead from its
+// heartbeats, the messages in its instance dir in any of them are merged into
+// the store of this instance, and the dir is removed, the same as a reaped
+// instance. The peer's directory lock must be acquired first, so a peer that
+// is still running, but can't be heard from, is never taken over.
+func Takeover(dataDirs ...string) Option {
+	return func(o *Options) error {
+		if len(dataDirs) == 0 {
+			return fmt.Errorf("takeover data dirs cannot be empty")
+		}
+		for _, dir := range dataDirs {
+			if strings.TrimSpace(dir) == "" {
+				return fmt.Errorf("takeover data dirs cannot be blank: %q", dataDirs)
+			}
+		}
+		o.takeoverDataDirs = append([]string(nil), dataDirs...)
+		return nil
+	}
+}
+
+// TakeoverHandler sets a callback that will be triggered for every
+// TakeoverEvent. The events are also published on
+// protocol.TakeoverEventsSubject.
+func TakeoverHandler(cb func(protocol.TakeoverEvent)) Option {
+	return func(o *Options) error {
+		o.takeoverCB = cb
+		return nil
+	}
+}
+
+// peer is another instance as seen from its heartbeats.
+type peer struct {
+	hb       protocol.Heartbeat
+	lastSeen time.Time
+	dead     bool
+}
+
+// peerRegistry keeps track of the other instances from their heartbeats.
+type peerRegistry struct {
+	mu    sync.Mutex
+	peers map[string]*peer
+}
+
+// seen records the heartbeat of a peer. A peer that is stopping is forgotten.
+func (r *peerRegistry) seen(hb protocol.Heartbeat, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if hb.Stopping {
+		delete(r.peers, hb.InstanceID)
+		return
+	}
+	if r.peers == nil {
+		r.peers = make(map[string]*peer)
+	}
+	r.peers[hb.InstanceID] = &peer{hb: hb, lastSeen: now}
+}
+
+// dead returns the ids of the peers that have missed too many heartbeats,
+// along with whether they were only just taken for dead. The peers assumed to
+// publish at interval when they don't say are forgotten once they have been
+// dead for a while.
+func (r *peerRegistry) dead(now time.Time, interval time.Duration) (ids []string, newly []bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, p := range r.peers {
+		i := p.hb.Interval
+		if i <= 0 {
+			i = interval
+		}
+		silence := now.Sub(p.lastSeen)
+		if silence <= heartbeatMisses*i {
+			continue
+		}
+		if silence > peerForgetAfter {
+			delete(r.peers, id)
+			continue
+		}
+		ids = append(ids, id)
+		newly = append(newly, !p.dead)
+		p.dead = true
+	}
+	return ids, newly
+}
+
+func (r *peerRegistry) forget(id string) {
+	r.mu.Lock()
+	delete(r.peers, id)
+	r.mu.Unlock()
+}
+
+func (r *peerRegistry) list() []protocol.Peer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	peers := make([]protocol.Peer, 0, len(r.peers))
+	for id, p := range r.peers {
+		peers = append(peers, protocol.Peer{
+			InstanceID: id,
+			DataDir:    p.hb.DataDir,
+			Dead:       p.dead,
+			LastSeen:   p.lastSeen,
+		})
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].InstanceID < peers[j].InstanceID
+	})
+	return peers
+}
+
+// Peers returns the other instances heard from, in order of their ids, when
+// Heartbeats are enabled.
+func (c *Conn) Peers() []protocol.Peer {
+	return c.peers.list()
+}
+
+// initHeartbeats starts publishing heartbeats and listening to those of the
+// other instances.
+func (c *Conn) initHeartbeats() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	interval := c.Opts.heartbeatInterval
+	if interval == 0 {
+		return nil
+	}
+	if len(c.Opts.takeoverDataDirs) > 0 && badgerInternal.InMemory(c.badgerOpenOptions()...) {
+		// Merging other instances into memory would lose their messages.
+		log.Warn().Msg("the store is in memory so dead instances won't be taken over")
+	} else {
+		c.takeoverDataDirs = c.Opts.takeoverDataDirs
+	}
+
+	if _, err := c.nc.Subscribe(protocol.InstancesSubject, c.handleHeartbeat); err != nil {
+		return fmt.Errorf("init heartbeats: %w", err)
+	}
+
+	c.closers.heartbeats.AddRunning(1)
+	go func() {
+		defer c.closers.heartbeats.Done()
+		c.publishHeartbeat(false)
+		t := ticker.New(interval)
+		go func() {
+			<-c.closers.heartbeats.HasBeenClosed()
+			t.Stop()
+		}()
+		t.Loop(func() bool {
+			c.publishHeartbeat(false)
+			c.checkPeers()
+			return true
+		})
+		// Let the others know we aren't dead.
+		c.publishHeartbeat(true)
+	}()
+
+	return nil
+}
+
+func (c *Conn) handleHeartbeat(msg *nats.Msg) {
+	var hb protocol.Heartbeat
+	if err := hb.UnmarshalBinary(msg.Data); err != nil {
+		log.Err(err).Str("subject", msg.Subject).Msg("unable to unmarshal heartbeat")
+		return
+	}
+	if hb.InstanceID == "" || hb.InstanceID == c.instanceId {
+		return
+	}
+	c.peers.seen(hb, time.Now())
+}
+
+func (c *Conn) publishHeartbeat(stopping bool) {
+	dataDir, _ := c.storeDirs()
+	c.publishEvent(protocol.HeartbeatSubject(c.instanceId), protocol.Heartbeat{
+		InstanceID: c.instanceId,
+		DataDir:    dataDir,
+		Interval:   c.Opts.heartbeatInterval,
+		Stopping:   stopping,
+		Version:    Version,
+		Labels:     c.Opts.labels,
+		Time:       time.Now(),
+	})
+}
+
+// checkPeers takes over the peers that are dead.
+func (c *Conn) checkPeers() {
+	ids, newly := c.peers.dead(time.Now(), c.Opts.heartbeatInterval)
+	for i, id := range ids {
+		if newly[i] {
+			log.Warn().Str("peer", id).Msgf("instance missed %d heartbeats", heartbeatMisses)
+		}
+		if c.takeover(id) {
+			c.peers.forget(id)
+		}
+	}
+}
+
+// takeover merges the store of the dead peer into ours if it can be found in
+// one of the takeover data dirs. It returns true if it was.
+func (c *Conn) takeover(id string) bool {
+	c.mu.RLock()
+	db, instanceDir := c.badgerDB, c.instanceDir
+	c.mu.RUnlock()
+	for _, dir := range c.takeoverDataDirs {
+		path := badgerInternal.InstanceDir(dir, id)
+		if path == instanceDir {
+			continue
+		}
+		if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+			continue
+		}
+		reaped, err := reaper.Reap(db, dir, id)
+		if err != nil {
+			log.Err(err).Str("peer", id).Str("dataDir", dir).Msg("unable to take over instance")
+			continue
+		}
+		if !reaped {
+			// Still locked, so it may not be dead after all. Tried again on
+			// the next heartbeat.
+			log.Debug().Str("peer", id).Str("dataDir", dir).Msg("instance is locked, not taking it over")
+			continue
+		}
+
+		log.Info().Str("peer", id).Str("dataDir", dir).Msg("took over instance")
+		e := protocol.TakeoverEvent{
+			InstanceID: c.instanceId,
+			Peer:       id,
+			DataDir:    dir,
+			Labels:     c.Opts.labels,
+			Time:       time.Now(),
+		}
+		if cb := c.Opts.takeoverCB; cb != nil {
+			c.hook(func() { cb(e) })
+		}
+		c.publishEvent(protocol.TakeoverEventsSubject, e)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package requeue
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerRegistry(t *testing.T) {
+	var r peerRegistry
+	now := time.Now()
+	r.seen(protocol.Heartbeat{InstanceID: "a", Interval: time.Second}, now)
+	r.seen(protocol.Heartbeat{InstanceID: "b"}, now)
+
+	ids, _ := r.dead(now.Add(3*time.Second), time.Minute)
+	assert.Empty(t, ids)
+
+	// Peers that don't say how often they publish are assumed to do so at
+	// our interval.
+	ids, newly := r.dead(now.Add(4*time.Second), time.Minute)
+	assert.Equal(t, []string{"a"}, ids)
+	assert.Equal(t, []bool{true}, newly)
+	_, newly = r.dead(now.Add(5*time.Second), time.Minute)
+	assert.Equal(t, []bool{false}, newly)
+
+	peers := r.list()
+	if assert.Len(t, peers, 2) {
+		assert.True(t, peers[0].Dead)
+		assert.False(t, peers[1].Dead)
+	}
+
+	// A peer heard from again is alive, and one that is stopping is
+	// forgotten.
+	r.seen(protocol.Heartbeat{InstanceID: "a", Interval: time.Second}, now.Add(6*time.Second))
+	r.seen(protocol.Heartbeat{InstanceID: "b", Stopping: true}, now.Add(6*time.Second))
+	peers = r.list()
+	if assert.Len(t, peers, 1) {
+		assert.False(t, peers[0].Dead)
+	}
+
+	// Dead peers are forgotten eventually.
+	ids, _ = r.dead(now.Add(2*peerForgetAfter), time.Minute)
+	assert.Empty(t, ids)
+	assert.Empty(t, r.list())
+}
+
+func TestTakeover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "takeover-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	shared, err := ioutil.TempDir("", "takeover-shared-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(shared)
+
+	// The store of a peer that died on another host.
+	peerDir := badgerInternal.InstanceDir(shared, "peer1")
+	db, err := badgerInternal.Open(peerDir)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("foo"), []byte("bar"))
+	}))
+	assert.NoError(t, db.Close())
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	assert.NoError(t, Takeover(shared)(&o))
+	assert.Error(t, o.Validate(), "takeover needs heartbeats")
+	assert.NoError(t, Heartbeats(time.Second)(&o))
+	events := make(chan protocol.TakeoverEvent, 1)
+	assert.NoError(t, TakeoverHandler(func(e protocol.TakeoverEvent) {
+		events <- e
+	})(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	c.takeoverDataDirs = c.Opts.takeoverDataDirs
+
+	// A peer that is alive isn't taken over.
+	c.peers.seen(protocol.Heartbeat{InstanceID: "peer1", Interval: time.Second}, time.Now())
+	c.checkPeers()
+	_, err = os.Stat(peerDir)
+	assert.NoError(t, err)
+
+	c.peers.seen(protocol.Heartbeat{InstanceID: "peer1", Interval: time.Second}, time.Now().Add(-time.Minute))
+	c.checkPeers()
+	_, err = os.Stat(peerDir)
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, c.Peers())
+	assert.NoError(t, c.badgerDB.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte("foo"))
+		return err
+	}))
+
+	select {
+	case e := <-events:
+		assert.Equal(t, c.instanceId, e.InstanceID)
+		assert.Equal(t, "peer1", e.Peer)
+		assert.Equal(t, shared, e.DataDir)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the takeover event")
+	}
+}
@@ -0,0 +1,70 @@
+package requeue
+
+import (
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+const (
+	// DefaultHookWorkers is how many hooks run at once. With one they run in
+	// the order their events happened.
+	DefaultHookWorkers = 1
+
+	// DefaultHookQueueSize is how many hooks can wait to run before more are
+	// dropped.
+	DefaultHookQueueSize = 1024
+)
+
+// HookWorkers sets how many of the handlers given as options, e.g., the
+// HealthEventHandler, run at once. Handlers run in the background so a slow
+// one never holds up ingesting or republishing messages. With more than one
+// worker they may run out of order.
+func HookWorkers(n int) Option {
+	return func(o *Options) error {
+		if n < 1 {
+			return fmt.Errorf("hook workers must be at least 1: %d", n)
+		}
+		o.hookWorkers = n
+		return nil
+	}
+}
+
+// HookQueueSize sets how many handler calls can wait for a worker. Once it's
+// full calls are dropped and counted, see Conn.DroppedHooks, rather than
+// waited on.
+func HookQueueSize(n int) Option {
+	return func(o *Options) error {
+		if n < 0 {
+			return fmt.Errorf("hook queue size cannot be negative: %d", n)
+		}
+		o.hookQueueSize = n
+		return nil
+	}
+}
+
+// DroppedHooks returns the number of handler calls dropped because they
+// couldn't keep up.
+func (c *Conn) DroppedHooks() uint64 {
+	return c.hooks.Dropped()
+}
+
+// hook runs f, which calls a handler given as an option, in the background.
+func (c *Conn) hook(f func()) {
+	c.hooks.Dispatch(f)
+}
+
+// batchCommitted runs the BatchCommitHandler off the batch writer so a slow
+// handler doesn't hold up the commits.
+func (c *Conn) batchCommitted(bc protocol.BatchCommit) {
+	if cb := c.Opts.batchCommitCB; cb != nil {
+		c.hook(func() { cb(bc) })
+	}
+}
+
+func (c *Conn) badgerWriteMsgErr(msg *nats.Msg, err error) {
+	if cb := c.Opts.badgerWriteMsgErr; cb != nil && c.sampler.sample() {
+		c.hook(func() { cb(msg, err) })
+	}
+}
@@ -4,14 +4,93 @@ import (
 	"path/filepath"
 
 	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/dgraph-io/badger/v2/y"
 )
 
-func Open(instancePath string) (*badger.DB, error) {
-	openOpts := badger.DefaultOptions(instancePath)
-	openOpts.Logger = badgerLogger{}
+// OpenOption is a function on the options used to open a store.
+type OpenOption func(*badger.Options)
+
+// SyncWrites sets whether writes are synced to disk before they are
+// committed. Not syncing is faster but the most recent writes can be lost if
+// the machine crashes.
+func SyncWrites(sync bool) OpenOption {
+	return func(o *badger.Options) {
+		o.SyncWrites = sync
+	}
+}
+
+// Compression sets how newly written tables are compressed. The level is
+// only used for ZSTD. Existing tables are left as they are.
+func Compression(c options.CompressionType, zstdLevel int) OpenOption {
+	return func(o *badger.Options) {
+		o.Compression = c
+		if c == options.ZSTD && zstdLevel > 0 {
+			o.ZSTDCompressionLevel = zstdLevel
+		}
+	}
+}
+
+// BlockCacheSize sets the size in bytes of the cache of decompressed table
+// blocks. Zero disables it. A cache is recommended when compression is
+// enabled since every read would decompress a block otherwise.
+func BlockCacheSize(size int64) OpenOption {
+	return func(o *badger.Options) {
+		o.MaxCacheSize = size
+	}
+}
+
+// Tune passes the options through fn once the others have been applied so
+// anything else can be set, e.g., the ValueLogFileSize or NumVersionsToKeep.
+// The directories are always those of the instance unless the store is in
+// memory, in which case it has none.
+func Tune(fn func(badger.Options) badger.Options) OpenOption {
+	return func(o *badger.Options) {
+		if fn != nil {
+			*o = fn(*o)
+		}
+	}
+}
+
+// Memory opens the store in memory rather than in the instance directory.
+func Memory() OpenOption {
+	return func(o *badger.Options) {
+		o.InMemory = true
+	}
+}
+
+// InMemory returns true if the options open the store in memory.
+func InMemory(options ...OpenOption) bool {
+	return openOptions("", options...).InMemory
+}
+
+// ZSTDSupported returns true if the store was built with ZSTD support, which
+// requires cgo.
+func ZSTDSupported() bool {
+	return y.CgoEnabled
+}
+
+func Open(instancePath string, options ...OpenOption) (*badger.DB, error) {
 	// Open the Badger database located in the instancePath directory.
 	// It will be created if it doesn't exist.
-	return badger.Open(openOpts)
+	return badger.Open(openOptions(instancePath, options...))
+}
+
+func openOptions(instancePath string, options ...OpenOption) badger.Options {
+	openOpts := badger.DefaultOptions(instancePath)
+	openOpts.Logger = badgerLogger{}
+	for _, opt := range options {
+		if opt != nil {
+			opt(&openOpts)
+		}
+	}
+	openOpts.Dir = instancePath
+	openOpts.ValueDir = instancePath
+	if openOpts.InMemory {
+		openOpts.Dir = ""
+		openOpts.ValueDir = ""
+	}
+	return openOpts
 }
 
 func InstanceDir(dataDir, instanceId string) string {
@@ -3,6 +3,8 @@ package badger
 import (
 	"testing"
 
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/options"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -12,3 +14,45 @@ func TestInstanceDir(t *testing.T) {
 	path := InstanceDir(dir, instanceId)
 	assert.Equal(t, "mydir/123456", path)
 }
+
+func TestCompressionOptions(t *testing.T) {
+	o := badger.DefaultOptions("")
+	Compression(options.Snappy, 5)(&o)
+	assert.Equal(t, options.Snappy, o.Compression)
+	// The level only applies to ZSTD.
+	assert.Equal(t, 1, o.ZSTDCompressionLevel)
+
+	Compression(options.ZSTD, 5)(&o)
+	assert.Equal(t, options.ZSTD, o.Compression)
+	assert.Equal(t, 5, o.ZSTDCompressionLevel)
+
+	BlockCacheSize(1 << 20)(&o)
+	assert.Equal(t, int64(1<<20), o.MaxCacheSize)
+}
+
+func TestTune(t *testing.T) {
+	o := openOptions("mydir",
+		SyncWrites(false),
+		Tune(func(o badger.Options) badger.Options {
+			// Tuned after the other options.
+			assert.False(t, o.SyncWrites)
+			return o.WithValueLogFileSize(1 << 20).
+				WithNumVersionsToKeep(2).
+				WithDir("elsewhere")
+		}),
+	)
+	assert.Equal(t, int64(1<<20), o.ValueLogFileSize)
+	assert.Equal(t, 2, o.NumVersionsToKeep)
+	// The directories are left to the instance.
+	assert.Equal(t, "mydir", o.Dir)
+	assert.Equal(t, "mydir", o.ValueDir)
+	assert.False(t, InMemory(Tune(nil)))
+
+	inMemory := Tune(func(o badger.Options) badger.Options {
+		return o.WithInMemory(true)
+	})
+	assert.True(t, InMemory(inMemory))
+	db, err := Open("mydir", inMemory)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+}
@@ -1,7 +1,10 @@
 package badger
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v2"
@@ -9,6 +12,29 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// ErrBatchedWriterClosed is returned when writing to a BatchedWriter that has
+// been closed or whose context is done.
+var ErrBatchedWriterClosed = errors.New("batched writer is closed")
+
+// Commit describes a batch of writes committed by a BatchedWriter.
+type Commit struct {
+	// The number of writes and the bytes of their keys and values.
+	Entries int
+	Bytes   int
+	// How long the batch took to commit.
+	Duration time.Duration
+	// The error committing the batch, if any.
+	Err error
+}
+
+// CommitMetrics are the totals of the batches committed by a BatchedWriter.
+type CommitMetrics struct {
+	Commits  int64
+	Entries  int64
+	Bytes    int64
+	Duration time.Duration
+}
+
 type BatchedWriter struct {
 	db *badger.DB
 	d  time.Duration
@@ -20,9 +46,33 @@ type BatchedWriter struct {
 	done chan struct{}
 
 	flushKicked bool
+
+	// The number of writes pending in the batch, and how many there can be
+	// before the batch is flushed without waiting. Zero is unbounded.
+	pending int
+	maxSize int
+
+	// The bytes of the writes pending in the batch.
+	pendingBytes int
+
+	// Called with every batch once it's committed.
+	commitCB func(Commit)
+
+	// Accessed atomically.
+	commits     int64
+	entries     int64
+	bytes       int64
+	commitNanos int64
 }
 
 func NewBatchedWriter(db *badger.DB, d time.Duration) *BatchedWriter {
+	return NewBatchedWriterContext(context.Background(), db, d)
+}
+
+// NewBatchedWriterContext creates a BatchedWriter that closes once ctx is
+// done. The pending writes are flushed and any writes after that fail with
+// ErrBatchedWriterClosed.
+func NewBatchedWriterContext(ctx context.Context, db *badger.DB, d time.Duration) *BatchedWriter {
 	bw := &BatchedWriter{
 		db:   db,
 		d:    d,
@@ -30,15 +80,17 @@ func NewBatchedWriter(db *badger.DB, d time.Duration) *BatchedWriter {
 		quit: make(chan struct{}),
 		done: make(chan struct{}),
 	}
-	go bw.loop(d)
+	go bw.loop(ctx)
 
 	return bw
 }
 
-// On duration, call flush so we don't end up with writes waiting too long to be
-// committed.
-func (bw *BatchedWriter) loop(d time.Duration) {
-	<-bw.quit
+// Wait until we are closed and flush what's pending.
+func (bw *BatchedWriter) loop(ctx context.Context) {
+	select {
+	case <-bw.quit:
+	case <-ctx.Done():
+	}
 	bw.flush(true)
 	close(bw.done)
 }
@@ -46,20 +98,77 @@ func (bw *BatchedWriter) loop(d time.Duration) {
 func (bw *BatchedWriter) flush(last bool) {
 	bw.mu.Lock()
 	defer bw.mu.Unlock()
+	bw.flushLocked(last)
+}
 
+// flushLocked should be called with the lock acquired.
+func (bw *BatchedWriter) flushLocked(last bool) {
 	if bw.flushKicked {
 		log.Info().Msg("batched-writer: flushing writes to badger")
+		c := Commit{Entries: bw.pending, Bytes: bw.pendingBytes}
+		start := time.Now()
 		if err := bw.wb.Flush(); err != nil {
-			// Not the best error handling, but you could have some kind of callback too.
 			log.Err(err).Msgf("batched-writer: could not flush: %v", err)
+			c.Err = err
 		}
+		c.Duration = time.Since(start)
+		bw.recordCommit(c)
 		bw.flushKicked = false
-		if last {
-			bw.wb = nil
-		} else {
+		bw.pending = 0
+		bw.pendingBytes = 0
+		if !last {
 			bw.wb = NewWriteBatch(bw.db)
 		}
 	}
+	if last {
+		bw.wb = nil
+	}
+}
+
+// Flush commits the pending writes now rather than once the interval is up.
+// The callbacks of the writes are run once they're committed.
+func (bw *BatchedWriter) Flush() {
+	bw.flush(false)
+}
+
+// SetMaxSize flushes a batch as soon as it has n writes pending rather than
+// waiting for the interval to be up. Zero, the default, doesn't bound the size
+// of a batch.
+func (bw *BatchedWriter) SetMaxSize(n int) {
+	bw.mu.Lock()
+	bw.maxSize = n
+	bw.mu.Unlock()
+}
+
+// SetCommitHandler sets a callback that will be triggered with every batch
+// once it has been committed, e.g., to adapt the batching to how long commits
+// take. It's called with the writer locked so it must not block or write.
+func (bw *BatchedWriter) SetCommitHandler(cb func(Commit)) {
+	bw.mu.Lock()
+	bw.commitCB = cb
+	bw.mu.Unlock()
+}
+
+// Metrics returns the totals of the batches committed so far.
+func (bw *BatchedWriter) Metrics() CommitMetrics {
+	return CommitMetrics{
+		Commits:  atomic.LoadInt64(&bw.commits),
+		Entries:  atomic.LoadInt64(&bw.entries),
+		Bytes:    atomic.LoadInt64(&bw.bytes),
+		Duration: time.Duration(atomic.LoadInt64(&bw.commitNanos)),
+	}
+}
+
+// recordCommit counts the batch that was committed. Should be called with the
+// lock acquired.
+func (bw *BatchedWriter) recordCommit(c Commit) {
+	atomic.AddInt64(&bw.commits, 1)
+	atomic.AddInt64(&bw.entries, int64(c.Entries))
+	atomic.AddInt64(&bw.bytes, int64(c.Bytes))
+	atomic.AddInt64(&bw.commitNanos, int64(c.Duration))
+	if bw.commitCB != nil {
+		bw.commitCB(c)
+	}
 }
 
 func (bw *BatchedWriter) Close() {
@@ -70,23 +179,32 @@ func (bw *BatchedWriter) Close() {
 func (bw *BatchedWriter) Set(k, v []byte, cb WriteBatchCommitCB) error {
 	bw.mu.Lock()
 	defer bw.mu.Unlock()
+	if bw.wb == nil {
+		return ErrBatchedWriterClosed
+	}
 	// Create a timeout
 	err := bw.wb.Set(k, v, cb)
-	if !bw.flushKicked {
-		bw.flushKicked = true
-		go func() {
-			<-time.After(bw.d)
-			bw.flush(false)
-		}()
-	}
+	bw.kickFlush(len(k) + len(v))
 	return err
 }
 
 func (bw *BatchedWriter) SetEntry(e *badger.Entry, cb WriteBatchCommitCB) error {
 	bw.mu.Lock()
 	defer bw.mu.Unlock()
+	if bw.wb == nil {
+		return ErrBatchedWriterClosed
+	}
 	// Create a timeout
 	err := bw.wb.SetEntry(e, cb)
+	bw.kickFlush(len(e.Key) + len(e.Value))
+	return err
+}
+
+// kickFlush counts a pending write of size bytes and makes sure the batch will
+// be flushed. Should be called with the lock acquired.
+func (bw *BatchedWriter) kickFlush(size int) {
+	bw.pending++
+	bw.pendingBytes += size
 	if !bw.flushKicked {
 		bw.flushKicked = true
 		go func() {
@@ -94,7 +212,9 @@ func (bw *BatchedWriter) SetEntry(e *badger.Entry, cb WriteBatchCommitCB) error
 			bw.flush(false)
 		}()
 	}
-	return err
+	if bw.maxSize > 0 && bw.pending >= bw.maxSize {
+		bw.flushLocked(false)
+	}
 }
 
 func (bw *BatchedWriter) WriteKVList(kvList *pb.KVList, cb WriteBatchCommitCB) error {
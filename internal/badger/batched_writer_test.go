@@ -0,0 +1,125 @@
+package badger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchedWriterContext(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// Long enough that only the cancel can flush the write.
+	bw := NewBatchedWriterContext(ctx, db, time.Hour)
+
+	committed := make(chan error, 1)
+	assert.NoError(t, bw.Set([]byte("foo"), []byte("bar"), func(err error) {
+		committed <- err
+	}))
+
+	cancel()
+	assert.NoError(t, <-committed)
+	assert.NoError(t, db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte("foo"))
+		return err
+	}))
+
+	// Nothing is accepted after the context is done.
+	<-bw.done
+	assert.Equal(t, ErrBatchedWriterClosed, bw.Set([]byte("baz"), []byte("qux"), nil))
+	bw.Close()
+}
+
+func TestBatchedWriterFlush(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Long enough that only the flush can commit the write.
+	bw := NewBatchedWriter(db, time.Hour)
+	defer bw.Close()
+
+	committed := make(chan error, 1)
+	assert.NoError(t, bw.Set([]byte("foo"), []byte("bar"), func(err error) {
+		committed <- err
+	}))
+
+	bw.Flush()
+	assert.NoError(t, <-committed)
+
+	// Writes are still accepted after a flush.
+	assert.NoError(t, bw.Set([]byte("baz"), []byte("qux"), nil))
+}
+
+func TestBatchedWriterMaxSize(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Long enough that only filling the batch can commit the writes.
+	bw := NewBatchedWriter(db, time.Hour)
+	defer bw.Close()
+	bw.SetMaxSize(2)
+
+	committed := make(chan error, 2)
+	cb := func(err error) {
+		committed <- err
+	}
+	assert.NoError(t, bw.Set([]byte("foo"), []byte("bar"), cb))
+	select {
+	case <-committed:
+		t.Fatal("committed before the batch was full")
+	case <-time.After(10 * time.Millisecond):
+	}
+	assert.NoError(t, bw.Set([]byte("baz"), []byte("qux"), cb))
+	assert.NoError(t, <-committed)
+	assert.NoError(t, <-committed)
+}
+
+func TestBatchedWriterMetrics(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	bw := NewBatchedWriter(db, time.Hour)
+	defer bw.Close()
+	var commits []Commit
+	bw.SetCommitHandler(func(c Commit) {
+		commits = append(commits, c)
+	})
+
+	assert.NoError(t, bw.Set([]byte("foo"), []byte("bar"), nil))
+	assert.NoError(t, bw.Set([]byte("baz"), []byte("quux"), nil))
+	bw.Flush()
+	assert.NoError(t, bw.Set([]byte("a"), []byte("b"), nil))
+	bw.Flush()
+	// Nothing pending isn't a commit.
+	bw.Flush()
+
+	if assert.Len(t, commits, 2) {
+		assert.Equal(t, 2, commits[0].Entries)
+		assert.Equal(t, 13, commits[0].Bytes)
+		assert.NoError(t, commits[0].Err)
+		assert.Equal(t, 1, commits[1].Entries)
+		assert.Equal(t, 2, commits[1].Bytes)
+	}
+	m := bw.Metrics()
+	assert.Equal(t, int64(2), m.Commits)
+	assert.Equal(t, int64(3), m.Entries)
+	assert.Equal(t, int64(15), m.Bytes)
+	assert.Equal(t, commits[0].Duration+commits[1].Duration, m.Duration)
+}
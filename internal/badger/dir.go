@@ -1,5 +1,26 @@
 package badger
 
+import "errors"
+
 const (
 	LockFile = "LOCK"
 )
+
+// IsLocked returns true if err is from failing to lock a directory, with
+// AcquireDirectoryLock or by opening Badger, because another process holds
+// the lock, i.e., the instance using it is still running.
+func IsLocked(err error) bool {
+	for err != nil {
+		if isLocked(err) {
+			return true
+		}
+		// Badger wraps its errors with a version of github.com/pkg/errors
+		// that only supports Cause, not errors.Unwrap.
+		if c, ok := err.(interface{ Cause() error }); ok && c.Cause() != err {
+			err = c.Cause()
+		} else {
+			err = errors.Unwrap(err)
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package badger
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireDirectoryLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	guard, err := AcquireDirectoryLock(dir, LockFile, false)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, LockFile))
+	assert.NoError(t, err)
+
+	// Only one can hold the lock.
+	_, err = AcquireDirectoryLock(dir, LockFile, false)
+	assert.Error(t, err)
+	_, err = AcquireDirectoryLock(dir, LockFile, true)
+	assert.Error(t, err)
+
+	assert.NoError(t, guard.Release())
+	_, err = os.Stat(filepath.Join(dir, LockFile))
+	assert.True(t, os.IsNotExist(err))
+
+	// Read-only locks are shared.
+	ro1, err := AcquireDirectoryLock(dir, LockFile, true)
+	assert.NoError(t, err)
+	ro2, err := AcquireDirectoryLock(dir, LockFile, true)
+	assert.NoError(t, err)
+	_, err = AcquireDirectoryLock(dir, LockFile, false)
+	assert.Error(t, err)
+	assert.NoError(t, ro1.Release())
+	assert.NoError(t, ro2.Release())
+
+	guard, err = AcquireDirectoryLock(dir, LockFile, false)
+	assert.NoError(t, err)
+	assert.NoError(t, guard.Release())
+}
+
+func TestIsLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dir-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Whether it's Badger or us that finds the lock held, and however it's
+	// wrapped.
+	_, err = Open(dir)
+	assert.True(t, IsLocked(err), "%v", err)
+	_, err = AcquireDirectoryLock(dir, LockFile, false)
+	assert.True(t, IsLocked(err), "%v", err)
+	assert.True(t, IsLocked(fmt.Errorf("reclaim instance: %w", err)))
+
+	assert.False(t, IsLocked(nil))
+	assert.False(t, IsLocked(errors.New("resource temporarily unavailable")))
+	_, err = AcquireDirectoryLock(filepath.Join(dir, "missing"), LockFile, false)
+	assert.False(t, IsLocked(err), "%v", err)
+}
@@ -20,6 +20,7 @@
 package badger
 
 import (
+	stderrors "errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -81,6 +82,12 @@ func AcquireDirectoryLock(dirPath string, pidFileName string, readOnly bool) (
 	return &DirectoryLockGuard{f, absPidFilePath, readOnly}, nil
 }
 
+// isLocked returns true if err is flock failing because another process holds
+// the lock.
+func isLocked(err error) bool {
+	return stderrors.Is(err, unix.EWOULDBLOCK)
+}
+
 // Release deletes the pid file and releases our lock on the directory.
 func (guard *DirectoryLockGuard) Release() error {
 	var err error
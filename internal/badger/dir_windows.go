@@ -19,25 +19,18 @@
 
 package badger
 
-// OpenDir opens a directory in windows with write access for syncing.
 import (
+	stderrors "errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"syscall"
 
 	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
 )
 
-// FILE_ATTRIBUTE_TEMPORARY - A file that is being used for temporary storage.
-// FILE_FLAG_DELETE_ON_CLOSE - The file is to be deleted immediately after all of its handles are
-// closed, which includes the specified handle and any other open or duplicated handles.
-// See: https://docs.microsoft.com/en-us/windows/desktop/FileIO/file-attribute-constants
-// NOTE: Added here to avoid importing golang.org/x/sys/windows
-const (
-	FILE_ATTRIBUTE_TEMPORARY  = 0x00000100
-	FILE_FLAG_DELETE_ON_CLOSE = 0x04000000
-)
-
+// openDir opens a directory in windows with write access for syncing.
 func openDir(path string) (*os.File, error) {
 	fd, err := openDirWin(path)
 	if err != nil {
@@ -61,49 +54,108 @@ func openDirWin(path string) (fd syscall.Handle, err error) {
 	return syscall.CreateFile(pathp, access, sharemode, nil, createmode, fl, 0)
 }
 
-// DirectoryLockGuard holds a lock on the directory.
-type directoryLockGuard struct {
-	h    syscall.Handle
+// DirectoryLockGuard holds a lock on a pid file inside a directory, which
+// stands in for a lock on the directory itself since Windows can't lock
+// directories.
+type DirectoryLockGuard struct {
+	// File handle on the pid file, which we've locked with LockFileEx.
+	h windows.Handle
+	// The absolute path to our pid file.
 	path string
+	// Was this a shared lock for a read-only database?
+	readOnly bool
 }
 
-// AcquireDirectoryLock acquires exclusive access to a directory.
-func AcquireDirectoryLock(dirPath string, pidFileName string, readOnly bool) (*directoryLockGuard, error) {
-	if readOnly {
-		return nil, ErrWindowsNotSupported
-	}
-
+// AcquireDirectoryLock gets a lock on the directory by locking
+// dirPath/pidFileName (using LockFileEx). If this is not read-only, it will
+// also write our pid to the file for convenience.
+func AcquireDirectoryLock(dirPath string, pidFileName string, readOnly bool) (
+	*DirectoryLockGuard, error) {
 	// Convert to absolute path so that Release still works even if we do an unbalanced
 	// chdir in the meantime.
-	absLockFilePath, err := filepath.Abs(filepath.Join(dirPath, pidFileName))
+	absPidFilePath, err := filepath.Abs(filepath.Join(dirPath, pidFileName))
 	if err != nil {
-		return nil, errors.Wrap(err, "Cannot get absolute path for pid lock file")
+		return nil, errors.Wrap(err, "cannot get absolute path for pid lock file")
+	}
+	pathp, err := windows.UTF16PtrFromString(absPidFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open pid lock file %q", absPidFilePath)
 	}
 
-	// This call creates a file handler in memory that only one process can use at a time. When
-	// that process ends, the file is deleted by the system.
-	// FILE_ATTRIBUTE_TEMPORARY is used to tell Windows to try to create the handle in memory.
-	// FILE_FLAG_DELETE_ON_CLOSE is not specified in syscall_windows.go but tells Windows to delete
-	// the file when all processes holding the handler are closed.
-	// XXX: this works but it's a bit klunky. i'd prefer to use LockFileEx but it needs unsafe pkg.
-	h, err := syscall.CreateFile(
-		syscall.StringToUTF16Ptr(absLockFilePath), 0, 0, nil,
-		syscall.OPEN_ALWAYS,
-		uint32(FILE_ATTRIBUTE_TEMPORARY|FILE_FLAG_DELETE_ON_CLOSE),
-		0)
+	// Share the file so other processes can open it to try the lock, and so
+	// the directory can be removed while we hold it.
+	h, err := windows.CreateFile(pathp,
+		windows.GENERIC_READ|windows.GENERIC_WRITE,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_ALWAYS, windows.FILE_ATTRIBUTE_NORMAL, 0)
 	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open pid lock file %q", absPidFilePath)
+	}
+
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK | windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if readOnly {
+		flags = windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	// Lock the whole file, however big it gets.
+	ol := new(windows.Overlapped)
+	if err := windows.LockFileEx(h, flags, 0, ^uint32(0), ^uint32(0), ol); err != nil {
+		windows.CloseHandle(h)
 		return nil, errors.Wrapf(err,
-			"Cannot create lock file %q.  Another process is using this Badger database",
-			absLockFilePath)
+			"Cannot acquire directory lock on %q.  Another process is using this Badger database.",
+			dirPath)
 	}
 
-	return &directoryLockGuard{h: h, path: absLockFilePath}, nil
+	if !readOnly {
+		// Yes, we happily overwrite a pre-existing pid file.  We're the
+		// only read-write badger process using this directory.
+		if err := writePid(h); err != nil {
+			windows.CloseHandle(h)
+			return nil, errors.Wrapf(err,
+				"Cannot write pid file %q", absPidFilePath)
+		}
+	}
+	return &DirectoryLockGuard{h, absPidFilePath, readOnly}, nil
+}
+
+// isLocked returns true if err is LockFileEx failing because another process
+// holds the lock, or, for the lock file Badger opens without sharing it,
+// CreateFile failing because another process has it open.
+func isLocked(err error) bool {
+	return stderrors.Is(err, windows.ERROR_LOCK_VIOLATION) ||
+		stderrors.Is(err, windows.ERROR_SHARING_VIOLATION)
 }
 
-// Release removes the directory lock.
-func (g *directoryLockGuard) Release() error {
-	g.path = ""
-	return syscall.CloseHandle(g.h)
+// writePid replaces the contents of the file with our pid. It's written through
+// the handle since wrapping it in an *os.File would close it, and release the
+// lock, once the file is garbage collected.
+func writePid(h windows.Handle) error {
+	if _, err := windows.Seek(h, 0, 0); err != nil {
+		return err
+	}
+	if err := windows.SetEndOfFile(h); err != nil {
+		return err
+	}
+	var done uint32
+	return windows.WriteFile(h, []byte(fmt.Sprintf("%d\n", os.Getpid())), &done, nil)
+}
+
+// Release deletes the pid file and releases our lock on the directory.
+func (guard *DirectoryLockGuard) Release() error {
+	var err error
+	if !guard.readOnly {
+		// It's important that we remove the pid file first. It's deleted
+		// once the handle is closed.
+		err = os.Remove(guard.path)
+	}
+
+	// Closing the handle releases the lock.
+	if closeErr := windows.CloseHandle(guard.h); err == nil {
+		err = closeErr
+	}
+	guard.path = ""
+	guard.h = windows.InvalidHandle
+
+	return err
 }
 
 // Windows doesn't support syncing directories to the file system. See
@@ -0,0 +1,23 @@
+package badger
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	free, total, err := DiskUsage(dir)
+	assert.NoError(t, err)
+	assert.True(t, total > 0)
+	assert.True(t, free <= total)
+
+	_, _, err = DiskUsage(dir + "/missing")
+	assert.Error(t, err)
+}
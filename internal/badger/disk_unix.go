@@ -0,0 +1,19 @@
+// +build !windows
+
+package badger
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// DiskUsage returns the bytes free for use and the total bytes of the file
+// system the path is on.
+func DiskUsage(path string) (free, total uint64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, 0, fmt.Errorf("disk usage: %w", err)
+	}
+	return st.Bavail * uint64(st.Bsize), st.Blocks * uint64(st.Bsize), nil
+}
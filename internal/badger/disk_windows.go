@@ -0,0 +1,22 @@
+// +build windows
+
+package badger
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// DiskUsage returns the bytes free for use and the total bytes of the file
+// system the path is on.
+func DiskUsage(path string) (free, total uint64, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("disk usage: %w", err)
+	}
+	if err := windows.GetDiskFreeSpaceEx(p, &free, &total, nil); err != nil {
+		return 0, 0, fmt.Errorf("disk usage: %w", err)
+	}
+	return free, total, nil
+}
@@ -0,0 +1,105 @@
+package badger
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// ValueLogGC garbage collects the value log of a store, which otherwise only
+// grows, and keeps count of what it reclaimed.
+type ValueLogGC struct {
+	// Held while running so the store isn't replaced out from under it.
+	mu           sync.Mutex
+	db           *badger.DB
+	dir          string
+	discardRatio float64
+
+	// Accessed atomically.
+	runs      int64
+	rewrites  int64
+	reclaimed int64
+}
+
+// NewValueLogGC creates a ValueLogGC for the store in dir. A value log file is
+// rewritten once at least discardRatio of it can be discarded.
+func NewValueLogGC(db *badger.DB, dir string, discardRatio float64) *ValueLogGC {
+	return &ValueLogGC{
+		db:           db,
+		dir:          dir,
+		discardRatio: discardRatio,
+	}
+}
+
+// Run rewrites value log files until none are left with enough to discard, as
+// recommended by badger, returning the number rewritten.
+func (gc *ValueLogGC) Run() int {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	before := valueLogSize(gc.dir)
+	n := 0
+	for {
+		err := gc.db.RunValueLogGC(gc.discardRatio)
+		if err == badger.ErrNoRewrite {
+			break
+		}
+		if err != nil {
+			log.Err(err).Msg("problem garbage collecting the value log")
+			break
+		}
+		n++
+	}
+	atomic.AddInt64(&gc.runs, 1)
+	atomic.AddInt64(&gc.rewrites, int64(n))
+	if reclaimed := before - valueLogSize(gc.dir); n > 0 && reclaimed > 0 {
+		atomic.AddInt64(&gc.reclaimed, reclaimed)
+	}
+	return n
+}
+
+// SetStore has the value log of the store db in dir garbage collected from
+// now on, e.g., after the store failed over to another data dir. It waits for
+// a run on the previous store to finish. The counts carry over.
+func (gc *ValueLogGC) SetStore(db *badger.DB, dir string) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.db = db
+	gc.dir = dir
+}
+
+// Runs returns the number of times the value log has been garbage collected.
+func (gc *ValueLogGC) Runs() int64 {
+	return atomic.LoadInt64(&gc.runs)
+}
+
+// Rewrites returns the number of value log files rewritten.
+func (gc *ValueLogGC) Rewrites() int64 {
+	return atomic.LoadInt64(&gc.rewrites)
+}
+
+// Reclaimed returns the bytes of the value log reclaimed so far.
+func (gc *ValueLogGC) Reclaimed() int64 {
+	return atomic.LoadInt64(&gc.reclaimed)
+}
+
+// valueLogSize returns the size in bytes of the value log files in dir. It's
+// read from disk since the size the store reports is only refreshed every so
+// often.
+func valueLogSize(dir string) int64 {
+	files, err := filepath.Glob(filepath.Join(dir, "*.vlog"))
+	if err != nil {
+		return 0
+	}
+	var size int64
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
@@ -0,0 +1,40 @@
+package badger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueLogGC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gc-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	gc := NewValueLogGC(db, dir, 0.5)
+	// There is nothing to rewrite in a new store.
+	assert.Equal(t, 0, gc.Run())
+	assert.Equal(t, int64(1), gc.Runs())
+	assert.Equal(t, int64(0), gc.Rewrites())
+	assert.Equal(t, int64(0), gc.Reclaimed())
+	assert.True(t, valueLogSize(dir) > 0)
+}
+
+func TestValueLogSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gc-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, int64(0), valueLogSize(dir))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "000001.vlog"), make([]byte, 10), 0666))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "000002.vlog"), make([]byte, 5), 0666))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "000001.sst"), make([]byte, 7), 0666))
+	assert.Equal(t, int64(15), valueLogSize(dir))
+}
@@ -0,0 +1,102 @@
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// InstanceIDKey is where the id of the instance owning a store is persisted.
+// It lives outside of the queues namespace so it is never mistaken for queue
+// state and must never be copied between stores.
+var InstanceIDKey = []byte("_i._id")
+
+// ReadInstanceID returns the instance id persisted in the store. False is
+// returned if one has not been persisted.
+func ReadInstanceID(db *badger.DB) (string, bool, error) {
+	var id string
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(InstanceIDKey)
+		if err != nil {
+			return err
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		id = string(v)
+		return nil
+	})
+	if err == badger.ErrKeyNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("read instance id: %w", err)
+	}
+	return id, true, nil
+}
+
+// WriteInstanceID persists the instance id in the store.
+func WriteInstanceID(db *badger.DB, id string) error {
+	if err := db.Update(func(txn *badger.Txn) error {
+		return txn.Set(InstanceIDKey, []byte(id))
+	}); err != nil {
+		return fmt.Errorf("write instance id: %w", err)
+	}
+	return nil
+}
+
+// ReclaimInstance looks through the data dir for the store of a previous
+// instance that is no longer running and returns it opened along with its id.
+// A store is only reclaimed if the id persisted in it matches its directory,
+// which guards against stores that have already been reaped. A nil db is
+// returned if there is nothing to reclaim.
+func ReclaimInstance(dataDir string, options ...OpenOption) (*badger.DB, string, error) {
+	files, err := ioutil.ReadDir(dataDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("reclaim instance: %w", err)
+	}
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		dir := InstanceDir(dataDir, name)
+		// Try the lock before opening Badger so a running instance's store
+		// is never opened, e.g., creating files in it on some platforms.
+		guard, err := AcquireDirectoryLock(dir, LockFile, false)
+		if err != nil {
+			// Another instance holds the lock so it's still running.
+			if IsLocked(err) {
+				continue
+			}
+			return nil, "", fmt.Errorf("reclaim instance: %w", err)
+		}
+		if err := guard.Release(); err != nil {
+			return nil, "", fmt.Errorf("reclaim instance: %w", err)
+		}
+		db, err := Open(dir, options...)
+		if err != nil {
+			// Another instance took the lock in the meantime.
+			if IsLocked(err) {
+				continue
+			}
+			return nil, "", fmt.Errorf("reclaim instance: %w", err)
+		}
+		id, ok, err := ReadInstanceID(db)
+		if err != nil || !ok || id != name {
+			db.Close()
+			continue
+		}
+		log.Info().Str("instanceId", id).Msg("reclaimed instance")
+		return db, id, nil
+	}
+	return nil, "", nil
+}
@@ -0,0 +1,53 @@
+package badger
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReclaimInstance(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "TestReclaimInstance-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dataDir)
+
+	// Nothing to reclaim.
+	db, id, err := ReclaimInstance(dataDir)
+	assert.NoError(t, err)
+	assert.Nil(t, db)
+	assert.Equal(t, "", id)
+
+	// A previous instance that persisted its id.
+	prev, err := Open(InstanceDir(dataDir, "instance-a"))
+	assert.NoError(t, err)
+	assert.NoError(t, WriteInstanceID(prev, "instance-a"))
+
+	// It's still running so it can't be reclaimed.
+	db, _, err = ReclaimInstance(dataDir)
+	assert.NoError(t, err)
+	assert.Nil(t, db)
+
+	assert.NoError(t, prev.Close())
+
+	// Nor while anyone else holds its lock.
+	guard, err := AcquireDirectoryLock(InstanceDir(dataDir, "instance-a"), LockFile, false)
+	assert.NoError(t, err)
+	db, _, err = ReclaimInstance(dataDir)
+	assert.NoError(t, err)
+	assert.Nil(t, db)
+	assert.NoError(t, guard.Release())
+
+	db, id, err = ReclaimInstance(dataDir)
+	assert.NoError(t, err)
+	if assert.NotNil(t, db) {
+		defer db.Close()
+	}
+	assert.Equal(t, "instance-a", id)
+
+	got, ok, err := ReadInstanceID(db)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "instance-a", got)
+}
@@ -0,0 +1,25 @@
+package badger
+
+import (
+	"github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// StorageStats collects the internal metrics of the Badger store so storage
+// pressure can be reported alongside the queues.
+func StorageStats(db *badger.DB) protocol.StorageStats {
+	lsm, vlog := db.Size()
+	s := protocol.StorageStats{
+		LSMSize:  lsm,
+		VlogSize: vlog,
+		// Returns 0 when the block cache is disabled.
+		BlockCacheHitRatio: db.DataCacheMetrics().Ratio(),
+	}
+	for _, t := range db.Tables(false) {
+		s.NumTables++
+		if t.Level == 0 {
+			s.Level0Tables++
+		}
+	}
+	return s
+}
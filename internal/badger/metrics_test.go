@@ -0,0 +1,24 @@
+package badger
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageStats(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("foo"), []byte("bar"))
+	}))
+
+	s := StorageStats(db)
+	assert.True(t, s.NumTables >= s.Level0Tables)
+	assert.True(t, s.BlockCacheHitRatio >= 0 && s.BlockCacheHitRatio <= 1)
+}
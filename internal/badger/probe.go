@@ -0,0 +1,54 @@
+package badger
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v2"
+)
+
+// ProbeKey is written, read back, and deleted by Probe. It lives outside of
+// the queues namespace, with the InstanceIDKey, so it's never mistaken for a
+// message.
+var ProbeKey = []byte("_i._probe")
+
+// Probe checks that the store can be written to, read from, and deleted from
+// by doing each with the ProbeKey.
+func Probe(db *badger.DB, value []byte) error {
+	if err := db.Update(func(txn *badger.Txn) error {
+		return txn.Set(ProbeKey, value)
+	}); err != nil {
+		return fmt.Errorf("probe: write: %w", err)
+	}
+
+	if err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(ProbeKey)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			if !bytes.Equal(v, value) {
+				return fmt.Errorf("read back %q rather than %q", v, value)
+			}
+			return nil
+		})
+	}); err != nil {
+		return fmt.Errorf("probe: read: %w", err)
+	}
+
+	if err := db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(ProbeKey)
+	}); err != nil {
+		return fmt.Errorf("probe: delete: %w", err)
+	}
+	if err := db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(ProbeKey)
+		return err
+	}); err != badger.ErrKeyNotFound {
+		if err == nil {
+			err = fmt.Errorf("still there")
+		}
+		return fmt.Errorf("probe: delete: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package badger
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbe(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+
+	assert.NoError(t, Probe(db, []byte("1")))
+	err = db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(ProbeKey)
+		return err
+	})
+	assert.Equal(t, badger.ErrKeyNotFound, err)
+
+	assert.NoError(t, db.Close())
+	assert.Error(t, Probe(db, []byte("2")))
+}
@@ -0,0 +1,95 @@
+package histogram
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Every power of two range is split into 2^subBucketBits linear
+	// sub-buckets which bounds the relative error of any value to ~3%.
+	subBucketBits  = 5
+	subBucketCount = 1 << subBucketBits
+	numBuckets     = subBucketCount + (64-subBucketBits)*subBucketCount
+)
+
+// Histogram is an HDR style histogram of durations. The buckets grow
+// exponentially with linear sub-buckets so it can record anything from
+// nanoseconds to hours in a fixed amount of memory while keeping the relative
+// error of the quantiles small. It's safe for concurrent use.
+type Histogram struct {
+	counts [numBuckets]uint64
+	total  uint64
+}
+
+// New creates an empty Histogram.
+func New() *Histogram {
+	return &Histogram{}
+}
+
+// Record adds d to the histogram. Negative durations are recorded as zero.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	atomic.AddUint64(&h.counts[bucketOf(uint64(d))], 1)
+	atomic.AddUint64(&h.total, 1)
+}
+
+// Count returns the number of recorded values.
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.total)
+}
+
+// Quantile returns the value at the quantile q, where q is in [0, 1]. Zero is
+// returned if nothing has been recorded.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(q * float64(total)))
+	if target == 0 {
+		target = 1
+	}
+	var seen uint64
+	for i := range h.counts {
+		seen += atomic.LoadUint64(&h.counts[i])
+		if seen >= target {
+			return time.Duration(valueOf(i))
+		}
+	}
+	// Values recorded while we were iterating may push the total past what we
+	// have seen. Return the largest value we found.
+	for i := len(h.counts) - 1; i >= 0; i-- {
+		if atomic.LoadUint64(&h.counts[i]) > 0 {
+			return time.Duration(valueOf(i))
+		}
+	}
+	return 0
+}
+
+// bucketOf returns the index of the bucket v falls into.
+func bucketOf(v uint64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	n := bits.Len64(v) // v is in [2^(n-1), 2^n)
+	shift := uint(n - 1 - subBucketBits)
+	sub := int(v>>shift) - subBucketCount
+	return subBucketCount + (n-1-subBucketBits)*subBucketCount + sub
+}
+
+// valueOf returns the midpoint of the bucket at index i.
+func valueOf(i int) uint64 {
+	if i < subBucketCount {
+		return uint64(i)
+	}
+	octave := (i - subBucketCount) / subBucketCount
+	sub := (i - subBucketCount) % subBucketCount
+	shift := uint(octave)
+	lower := uint64(sub+subBucketCount) << shift
+	return lower + (uint64(1)<<shift)/2
+}
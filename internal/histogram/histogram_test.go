@@ -0,0 +1,35 @@
+package histogram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucketOf(t *testing.T) {
+	// Small values are exact.
+	for v := uint64(0); v < subBucketCount; v++ {
+		assert.Equal(t, v, valueOf(bucketOf(v)))
+	}
+	// Larger values are within the relative error.
+	for _, v := range []uint64{32, 33, 100, 1000, 123456789, 1 << 40, 1<<63 + 12345} {
+		got := valueOf(bucketOf(v))
+		assert.InEpsilon(t, float64(v), float64(got), 1.0/subBucketCount, "value %d", v)
+	}
+	assert.Equal(t, numBuckets-1, bucketOf(^uint64(0)))
+}
+
+func TestQuantile(t *testing.T) {
+	h := New()
+	assert.Equal(t, time.Duration(0), h.Quantile(0.5))
+
+	for i := 1; i <= 100; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, uint64(100), h.Count())
+	assert.InEpsilon(t, float64(50*time.Millisecond), float64(h.Quantile(0.5)), 0.04)
+	assert.InEpsilon(t, float64(95*time.Millisecond), float64(h.Quantile(0.95)), 0.04)
+	assert.InEpsilon(t, float64(99*time.Millisecond), float64(h.Quantile(0.99)), 0.04)
+	assert.InEpsilon(t, float64(100*time.Millisecond), float64(h.Quantile(1)), 0.04)
+}
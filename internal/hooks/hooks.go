@@ -0,0 +1,107 @@
+package hooks
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Dispatcher runs hooks on a bounded pool of workers so a slow hook never
+// holds up whoever triggered it. Hooks dispatched while the queue is full are
+// dropped and counted rather than waited on.
+type Dispatcher struct {
+	queue chan func()
+	wg    sync.WaitGroup
+
+	// The number of hooks dropped. Accessed atomically.
+	dropped uint64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New starts a Dispatcher with the number of workers and room for size hooks
+// waiting to run. With a single worker hooks run in the order dispatched.
+func New(workers, size int) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	if size < 0 {
+		size = 0
+	}
+	d := &Dispatcher{
+		queue: make(chan func(), size),
+	}
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer d.wg.Done()
+			for f := range d.queue {
+				run(f)
+			}
+		}()
+	}
+	return d
+}
+
+// Dispatch queues f to run without waiting for it. False is returned if the
+// queue was full and f was dropped. Once the Dispatcher is closed there is
+// nothing left to hold up so f is run right away.
+func (d *Dispatcher) Dispatch(f func()) bool {
+	d.mu.RLock()
+	if d.closed {
+		d.mu.RUnlock()
+		run(f)
+		return true
+	}
+	select {
+	case d.queue <- f:
+		d.mu.RUnlock()
+		return true
+	default:
+		d.mu.RUnlock()
+	}
+	if n := atomic.AddUint64(&d.dropped, 1); n&(n-1) == 0 {
+		// Only log when the count hits a power of two so a stuck hook
+		// doesn't flood the logs too.
+		log.Warn().Uint64("dropped", n).Msg("hooks: queue is full, dropping hooks")
+	}
+	return false
+}
+
+// Dropped returns the number of hooks dropped because the queue was full.
+func (d *Dispatcher) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+// Close waits for the hooks already queued to run. Hooks dispatched after
+// it's closed run right away.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.queue)
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// run calls f, recovering from any panic so a bad hook can't take down the
+// worker.
+func run(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Err(fmt.Errorf("%v", r)).Msg("hooks: hook panicked")
+		}
+	}()
+	f()
+}
+
+// Pending returns the number of hooks waiting to run and how many can wait.
+func (d *Dispatcher) Pending() (int, int) {
+	return len(d.queue), cap(d.queue)
+}
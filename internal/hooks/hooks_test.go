@@ -0,0 +1,48 @@
+package hooks
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcher(t *testing.T) {
+	d := New(1, 2)
+
+	// Block the worker so the queue fills up.
+	block := make(chan struct{})
+	started := make(chan struct{})
+	assert.True(t, d.Dispatch(func() {
+		close(started)
+		<-block
+	}))
+	<-started
+
+	var ran int32
+	inc := func() { atomic.AddInt32(&ran, 1) }
+	assert.True(t, d.Dispatch(inc))
+	assert.True(t, d.Dispatch(inc))
+	// The queue is full so these are dropped instead of blocking.
+	assert.False(t, d.Dispatch(inc))
+	assert.False(t, d.Dispatch(inc))
+	assert.Equal(t, uint64(2), d.Dropped())
+
+	close(block)
+	d.Close()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&ran))
+
+	// Once closed hooks run right away.
+	assert.True(t, d.Dispatch(inc))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&ran))
+	d.Close()
+}
+
+func TestDispatcherRecoversPanics(t *testing.T) {
+	d := New(1, 2)
+	done := make(chan struct{})
+	assert.True(t, d.Dispatch(func() { panic("boom") }))
+	assert.True(t, d.Dispatch(func() { close(done) }))
+	<-done
+	d.Close()
+}
@@ -37,7 +37,8 @@ func newUID() uint64 {
 	return binary.BigEndian.Uint64(b)
 }
 
-// New generates a new key.
+// New generates a new key. Keys are always stored in their raw binary form,
+// use Print only for display.
 func New(time time.Time) Key {
 	out := make([]byte, Size)
 	binary.BigEndian.PutUint64(out[0:8], uint64(time.Unix()))
@@ -46,6 +47,21 @@ func New(time time.Time) Key {
 	return out
 }
 
+// FromTime returns the smallest possible key for the time t. Seeking to it
+// will position an iterator at the first key created at or after t. Keys only
+// have second precision so t is truncated to the second.
+func FromTime(t time.Time) Key {
+	out := make([]byte, Size)
+	binary.BigEndian.PutUint64(out[0:8], uint64(t.Unix()))
+	return out
+}
+
+// TimeOf returns the time encoded in the key k. Keys only have second
+// precision.
+func TimeOf(k Key) time.Time {
+	return time.Unix(int64(k.UnixTimestamp()), 0)
+}
+
 // Clone returns a close of a key.
 // func Clone(k Key) Key {
 // 	b := make(Key, 16)
@@ -95,6 +111,11 @@ func Last() Key {
 	return out
 }
 
+// Time returns the time encoded in the key.
+func (k Key) Time() time.Time {
+	return TimeOf(k)
+}
+
 func (k Key) UnixTimestamp() uint64 {
 	return binary.BigEndian.Uint64(k[0:8])
 }
@@ -124,6 +145,22 @@ func (k Key) Print() string {
 	)
 }
 
+// Parse parses the Print representation of a key.
+func Parse(s string) (Key, error) {
+	var ts, seq, id uint64
+	if n, err := fmt.Sscanf(s, "%d.%d.%d", &ts, &seq, &id); err != nil || n != 3 {
+		return nil, fmt.Errorf("invalid key: %q", s)
+	}
+	out := make([]byte, Size)
+	binary.BigEndian.PutUint64(out[0:8], ts)
+	binary.BigEndian.PutUint64(out[8:16], seq)
+	binary.BigEndian.PutUint64(out[16:24], id)
+	if out := Key(out); out.Print() != s {
+		return nil, fmt.Errorf("invalid key: %q", s)
+	}
+	return out, nil
+}
+
 func (k Key) Bytes() []byte {
 	return k
 }
@@ -62,3 +62,35 @@ func TestString64(t *testing.T) {
 
 	assert.Equal(t, -1, bytes.Compare(k2, k3), "k2 should be less than k3")
 }
+
+func TestFromTime(t *testing.T) {
+	t1 := time.Unix(100, 500)
+	k := FromTime(t1)
+	assert.Equal(t, uint64(100), k.UnixTimestamp())
+	assert.Equal(t, uint64(0), k.Seq())
+	assert.Equal(t, uint64(0), k.InstanceID())
+
+	// Any key created at the same second sorts after it.
+	assert.Equal(t, -1, Compare(k, New(t1)))
+	// Any key created the second before sorts before it.
+	assert.Equal(t, 1, Compare(k, New(t1.Add(-1*time.Second))))
+}
+
+func TestTimeOf(t *testing.T) {
+	t1 := time.Unix(100, 500)
+	assert.Equal(t, time.Unix(100, 0), TimeOf(New(t1)))
+	assert.Equal(t, time.Unix(100, 0), New(t1).Time())
+	assert.Equal(t, time.Unix(100, 0), TimeOf(FromTime(t1)))
+}
+
+func TestParse(t *testing.T) {
+	k := New(time.Unix(1600000000, 0))
+	parsed, err := Parse(k.Print())
+	assert.NoError(t, err)
+	assert.Equal(t, k, parsed)
+
+	for _, s := range []string{"", "1.2", "1.2.x", "1.2.3.4", "-1.2.3", " 1.2.3"} {
+		_, err := Parse(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,43 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+)
+
+// PutAckFailure stores the record of the message with the key k in the queue
+// that couldn't be acknowledged to its producer. Any retention less than or
+// equal to zero will be ignored and the record is kept until it's deleted or
+// the queue is dropped.
+func PutAckFailure(db *badger.DB, queue string, k key.Key, record []byte, retention time.Duration) error {
+	entry := badger.NewEntry(NewQueueKeyForAckFailure(queue, k).Bytes(), record)
+	if retention > 0 {
+		entry = entry.WithTTL(retention)
+	}
+	if err := db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(entry)
+	}); err != nil {
+		return fmt.Errorf("put ack failure: %w", err)
+	}
+	return nil
+}
+
+// DeleteAckFailure removes the ack failure record of the message with the key
+// k in the queue, e.g., once it has been reconciled with the producer.
+func DeleteAckFailure(db *badger.DB, queue string, k key.Key) error {
+	if err := db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(NewQueueKeyForAckFailure(queue, k).Bytes())
+	}); err != nil {
+		return fmt.Errorf("delete ack failure: %w", err)
+	}
+	return nil
+}
+
+// RangeAckFailures calls f, in message key order, with the ack failure records
+// of the queue. If f returns false, range stops the iteration.
+func RangeAckFailures(db *badger.DB, queue string, f func(QueueItem) bool) error {
+	return rangePrefix(db, NewQueueKeyForAckFailure(queue, nil).NamePrefixBytes(), f)
+}
@@ -0,0 +1,41 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAckFailures(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	k1 := key.New(time.Now())
+	k2 := key.New(time.Now())
+	assert.NoError(t, PutAckFailure(db, "orders", k1, []byte("first"), time.Hour))
+	assert.NoError(t, PutAckFailure(db, "orders", k2, []byte("second"), 0))
+	assert.NoError(t, PutAckFailure(db, "other", k1, []byte("other"), 0))
+
+	collect := func(queue string) []string {
+		var records []string
+		assert.NoError(t, RangeAckFailures(db, queue, func(qi QueueItem) bool {
+			qk := ParseQueueKey(qi.K)
+			assert.Equal(t, AckFailureBucket, qk.Bucket)
+			assert.Equal(t, queue, qk.Name)
+			records = append(records, string(qi.V))
+			return true
+		}))
+		return records
+	}
+	assert.Equal(t, []string{"first", "second"}, collect("orders"))
+
+	assert.NoError(t, DeleteAckFailure(db, "orders", k1))
+	assert.Equal(t, []string{"second"}, collect("orders"))
+	assert.Equal(t, []string{"other"}, collect("other"))
+}
@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// BacklogBySubject scans every message in the queue with a stream and returns
+// the backlog for each original subject in no particular order.
+func BacklogBySubject(ctx context.Context, db *badger.DB, queue string) ([]protocol.SubjectBacklog, error) {
+	var mu sync.Mutex
+	backlog := make(map[string]*protocol.SubjectBacklog)
+
+	stream := db.NewStream()
+	stream.LogPrefix = "Queue.Backlog.Streaming"
+	stream.Prefix = NewQueueKeyForMessage(queue, nil).NamePrefixBytes()
+
+	// We only aggregate so there is never anything to send.
+	stream.KeyToList = func(key []byte, itr *badger.Iterator) (*pb.KVList, error) {
+		// Only the latest version of the key matters.
+		item := itr.Item()
+		if item.IsDeletedOrExpired() {
+			return nil, nil
+		}
+		var subject string
+		size := item.ValueSize()
+		if err := item.Value(func(v []byte) error {
+			subject = string(flatbuf.GetRootAsRequeueMessage(v, 0).OriginalSubject())
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		b, ok := backlog[subject]
+		if !ok {
+			b = &protocol.SubjectBacklog{Subject: subject}
+			backlog[subject] = b
+		}
+		b.Count++
+		b.Bytes += size
+		mu.Unlock()
+		return nil, nil
+	}
+	stream.Send = func(*pb.KVList) error {
+		return nil
+	}
+
+	if err := stream.Orchestrate(ctx); err != nil {
+		return nil, err
+	}
+
+	out := make([]protocol.SubjectBacklog, 0, len(backlog))
+	for _, b := range backlog {
+		out = append(out, *b)
+	}
+	return out, nil
+}
+
+// SortBacklog sorts the backlog largest first by count or bytes. Ties are
+// broken by subject.
+func SortBacklog(backlog []protocol.SubjectBacklog, by protocol.BacklogOrder) {
+	sort.Slice(backlog, func(i, j int) bool {
+		a, b := backlog[i], backlog[j]
+		if by == protocol.BacklogOrderBytes && a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Subject < b.Subject
+	})
+}
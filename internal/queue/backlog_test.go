@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBacklogBySubject(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	add := func(queue, subject string, payload []byte) {
+		m := protocol.DefaultRequeueMessage()
+		m.QueueName = queue
+		m.OriginalSubject = subject
+		m.OriginalPayload = payload
+		assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+			return txn.Set(NewQueueKeyForMessage(queue, key.New(time.Now())).Bytes(), m.Bytes())
+		}))
+	}
+	for i := 0; i < 3; i++ {
+		add("orders", "orders.created", []byte("small"))
+	}
+	add("orders", "orders.paid", make([]byte, 1024))
+	add("invoices", "invoices.sent", []byte("other queue"))
+
+	backlog, err := BacklogBySubject(context.Background(), db, "orders")
+	assert.NoError(t, err)
+	assert.Len(t, backlog, 2)
+
+	SortBacklog(backlog, protocol.BacklogOrderCount)
+	assert.Equal(t, "orders.created", backlog[0].Subject)
+	assert.Equal(t, int64(3), backlog[0].Count)
+
+	SortBacklog(backlog, protocol.BacklogOrderBytes)
+	assert.Equal(t, "orders.paid", backlog[0].Subject)
+	assert.Equal(t, int64(1), backlog[0].Count)
+	assert.True(t, backlog[0].Bytes > 1024)
+}
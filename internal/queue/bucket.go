@@ -0,0 +1,115 @@
+package queue
+
+import (
+	"strings"
+	"time"
+)
+
+// TimeBucket controls whether messages are sliced into time based sub-queues
+// when they are ingested. Each sub-queue is named after the base queue and the
+// time slice it covers, e.g., orders@2024-06-01T13. Because every slice lives
+// under its own key prefix, dropping a window of messages is a single prefix
+// delete and replaying a window only needs to walk the slices it covers.
+type TimeBucket int
+
+const (
+	// NoTimeBucket stores all messages in the base queue.
+	NoTimeBucket TimeBucket = iota
+
+	// HourlyTimeBucket creates a sub-queue per hour.
+	HourlyTimeBucket
+
+	// DailyTimeBucket creates a sub-queue per day.
+	DailyTimeBucket
+)
+
+const (
+	// bucketSep separates the base queue name from the time slice. We can't
+	// use the key separator because queue names are split on it.
+	bucketSep = "@"
+
+	hourlyBucketLayout = "2006-01-02T15"
+	dailyBucketLayout  = "2006-01-02"
+)
+
+func (b TimeBucket) layout() string {
+	switch b {
+	case HourlyTimeBucket:
+		return hourlyBucketLayout
+	case DailyTimeBucket:
+		return dailyBucketLayout
+	default:
+		return ""
+	}
+}
+
+func (b TimeBucket) duration() time.Duration {
+	switch b {
+	case HourlyTimeBucket:
+		return time.Hour
+	case DailyTimeBucket:
+		return 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// Start returns the beginning of the slice t falls into.
+func (b TimeBucket) Start(t time.Time) time.Time {
+	d := b.duration()
+	if d == 0 {
+		return t
+	}
+	return t.UTC().Truncate(d)
+}
+
+// QueueName returns the name of the sub-queue a message for the base queue
+// ingested at t belongs to. With NoTimeBucket the base name is returned as is.
+func (b TimeBucket) QueueName(base string, t time.Time) string {
+	layout := b.layout()
+	if layout == "" {
+		return base
+	}
+	return base + bucketSep + b.Start(t).Format(layout)
+}
+
+// QueueNames returns the names of all the sub-queues covering [from, until].
+func (b TimeBucket) QueueNames(base string, from, until time.Time) []string {
+	d := b.duration()
+	if d == 0 {
+		return []string{base}
+	}
+	names := make([]string, 0)
+	for t := b.Start(from); !t.After(until); t = t.Add(d) {
+		names = append(names, b.QueueName(base, t))
+	}
+	return names
+}
+
+// ParseTime returns the start of the slice encoded in a sub-queue name. False
+// is returned if the name was not created by this TimeBucket.
+func (b TimeBucket) ParseTime(name string) (time.Time, bool) {
+	layout := b.layout()
+	if layout == "" {
+		return time.Time{}, false
+	}
+	_, slice := SplitBucketName(name)
+	if slice == "" {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(layout, slice, time.UTC)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// SplitBucketName splits a sub-queue name into the base queue name and the
+// time slice. For queues that are not time bucketed slice will be empty.
+func SplitBucketName(name string) (base, slice string) {
+	i := strings.LastIndex(name, bucketSep)
+	if i < 0 {
+		return name, ""
+	}
+	return name[:i], name[i+len(bucketSep):]
+}
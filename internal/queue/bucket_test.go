@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeBucketQueueName(t *testing.T) {
+	ts := time.Date(2024, 6, 1, 13, 45, 0, 0, time.UTC)
+	assert.Equal(t, "orders", NoTimeBucket.QueueName("orders", ts))
+	assert.Equal(t, "orders@2024-06-01T13", HourlyTimeBucket.QueueName("orders", ts))
+	assert.Equal(t, "orders@2024-06-01", DailyTimeBucket.QueueName("orders", ts))
+}
+
+func TestTimeBucketQueueNames(t *testing.T) {
+	from := time.Date(2024, 6, 1, 22, 10, 0, 0, time.UTC)
+	until := time.Date(2024, 6, 2, 1, 5, 0, 0, time.UTC)
+	assert.Equal(t, []string{
+		"orders@2024-06-01T22",
+		"orders@2024-06-01T23",
+		"orders@2024-06-02T00",
+		"orders@2024-06-02T01",
+	}, HourlyTimeBucket.QueueNames("orders", from, until))
+	assert.Equal(t, []string{
+		"orders@2024-06-01",
+		"orders@2024-06-02",
+	}, DailyTimeBucket.QueueNames("orders", from, until))
+}
+
+func TestTimeBucketParseTime(t *testing.T) {
+	ts, ok := HourlyTimeBucket.ParseTime("orders@2024-06-01T13")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC), ts)
+
+	_, ok = HourlyTimeBucket.ParseTime("orders")
+	assert.False(t, ok)
+	_, ok = DailyTimeBucket.ParseTime("orders@2024-06-01T13")
+	assert.False(t, ok)
+}
+
+func TestDropTimeBucketsBefore(t *testing.T) {
+	openOpts := badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR)
+	db, err := badger.Open(openOpts)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m, err := NewManager(db)
+	assert.NoError(t, err)
+	defer m.Close()
+
+	old := HourlyTimeBucket.QueueName("orders", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	cur := HourlyTimeBucket.QueueName("orders", time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC))
+	for _, name := range []string{old, cur} {
+		_, err := m.CreateQueue(NewQueueKeyForState(name, ""))
+		assert.NoError(t, err)
+		assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+			return txn.Set(NewQueueKeyForMessage(name, key.New(time.Now())).Bytes(), []byte("msg"))
+		}))
+	}
+
+	dropped, err := m.DropTimeBucketsBefore("orders", HourlyTimeBucket, time.Date(2024, 6, 1, 13, 30, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{old}, dropped)
+
+	_, ok := m.GetQueue(old)
+	assert.False(t, ok)
+	_, ok = m.GetQueue(cur)
+	assert.True(t, ok)
+
+	var count int
+	assert.NoError(t, db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := NewQueueKeyForMessage(old, nil).NamePrefixBytes()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			count++
+		}
+		return nil
+	}))
+	assert.Equal(t, 0, count)
+}
@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// maxCoalesceConflicts is how many times a replace is retried when it
+// conflicts with a concurrent write to the same dedupe key.
+const maxCoalesceConflicts = 10
+
+// ReplaceMessage adds a message to the queue in place of the pending message
+// with the same dedupe key, if there is one, so only the latest is republished.
+// Unlike AddMessage the write is not batched since the replace has to be done
+// atomically. Any TTL less than or equal to zero will be ignored. True is
+// returned if a pending message was replaced.
+func (q *Queue) ReplaceMessage(key []byte, value []byte, ttl time.Duration, dedupeKey string) (bool, error) {
+	indexKey := NewQueueKeyForCoalesce(q.name, dedupeKey).Bytes()
+
+	var replaced bool
+	var err error
+	for i := 0; i < maxCoalesceConflicts; i++ {
+		replaced = false
+		err = q.db.Update(func(txn *badger.Txn) error {
+			item, err := txn.Get(indexKey)
+			switch {
+			case err == badger.ErrKeyNotFound:
+			case err != nil:
+				return err
+			default:
+				pending, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				// The index isn't cleaned up when messages expire so the
+				// pending message may already be gone.
+				if _, err := txn.Get(pending); err == nil {
+					replaced = true
+					if err := txn.Delete(pending); err != nil {
+						return err
+					}
+				} else if err != badger.ErrKeyNotFound {
+					return err
+				}
+			}
+			entry := badger.NewEntry(key, value)
+			if ttl > 0 {
+				entry = entry.WithTTL(ttl)
+			}
+			if err := txn.SetEntry(entry); err != nil {
+				return err
+			}
+			idx, err := ExpiryIndexEntry(entry)
+			if err != nil {
+				return err
+			}
+			if idx != nil {
+				if err := txn.SetEntry(idx); err != nil {
+					return err
+				}
+			}
+			return txn.Set(indexKey, key)
+		})
+		if err != badger.ErrConflict {
+			break
+		}
+		log.Debug().Str("queue", q.name).Msg("replace message: conflict, retrying")
+	}
+	if err != nil {
+		return false, fmt.Errorf("replace message: %w", err)
+	}
+	if !replaced {
+		q.Stats.AddCount(1)
+	}
+	q.Stats.AddEnqueued(1)
+	return replaced, nil
+}
+
+// UpdateCoalesceIndex points the coalescing index entry for the dedupe key at
+// newKey if it's still pointing at oldKey, i.e., the message hasn't been
+// replaced since. A nil newKey removes the entry. It must be called in the
+// same transaction that moves or removes the message.
+func UpdateCoalesceIndex(txn *badger.Txn, queue, dedupeKey string, oldKey, newKey []byte) error {
+	indexKey := NewQueueKeyForCoalesce(queue, dedupeKey).Bytes()
+	item, err := txn.Get(indexKey)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	current, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(current, oldKey) {
+		return nil
+	}
+	if newKey == nil {
+		return txn.Delete(indexKey)
+	}
+	return txn.Set(indexKey, newKey)
+}
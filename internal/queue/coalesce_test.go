@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueReplaceMessage(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	q, err := NewQueue(db, "prices")
+	assert.NoError(t, err)
+	defer q.Close()
+
+	msg := func(payload string) []byte {
+		m := protocol.DefaultRequeueMessage()
+		m.OriginalPayload = []byte(payload)
+		return m.Bytes()
+	}
+	pending := func() []string {
+		out := make([]string, 0)
+		_, err := q.Range(FirstMessage("prices"), LastMessage("prices"), func(qi QueueItem) bool {
+			var m protocol.RequeueMessage
+			assert.NoError(t, m.UnmarshalBinary(qi.V))
+			out = append(out, string(m.OriginalPayload))
+			return true
+		})
+		assert.NoError(t, err)
+		return out
+	}
+
+	k1 := NewQueueKeyForMessage("prices", key.New(time.Now())).Bytes()
+	replaced, err := q.ReplaceMessage(k1, msg("acme 1"), 0, "acme")
+	assert.NoError(t, err)
+	assert.False(t, replaced)
+
+	k2 := NewQueueKeyForMessage("prices", key.New(time.Now())).Bytes()
+	replaced, err = q.ReplaceMessage(k2, msg("globex 1"), 0, "globex")
+	assert.NoError(t, err)
+	assert.False(t, replaced)
+
+	k3 := NewQueueKeyForMessage("prices", key.New(time.Now())).Bytes()
+	replaced, err = q.ReplaceMessage(k3, msg("acme 2"), 0, "acme")
+	assert.NoError(t, err)
+	assert.True(t, replaced)
+	assert.ElementsMatch(t, []string{"globex 1", "acme 2"}, pending())
+	assert.Equal(t, int64(2), q.Stats.Count())
+
+	// Moving the message, e.g., when it's requeued, moves the index with it.
+	k4 := NewQueueKeyForMessage("prices", key.New(time.Now())).Bytes()
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		assert.NoError(t, txn.Set(k4, msg("acme 2")))
+		assert.NoError(t, txn.Delete(k3))
+		return UpdateCoalesceIndex(txn, "prices", "acme", k3, k4)
+	}))
+	k5 := NewQueueKeyForMessage("prices", key.New(time.Now())).Bytes()
+	replaced, err = q.ReplaceMessage(k5, msg("acme 3"), 0, "acme")
+	assert.NoError(t, err)
+	assert.True(t, replaced)
+	assert.ElementsMatch(t, []string{"globex 1", "acme 3"}, pending())
+
+	// Once removed, the next message with the dedupe key is added as new.
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		assert.NoError(t, txn.Delete(k5))
+		return UpdateCoalesceIndex(txn, "prices", "acme", k5, nil)
+	}))
+	k6 := NewQueueKeyForMessage("prices", key.New(time.Now())).Bytes()
+	replaced, err = q.ReplaceMessage(k6, msg("acme 4"), 0, "acme")
+	assert.NoError(t, err)
+	assert.False(t, replaced)
+	assert.ElementsMatch(t, []string{"globex 1", "acme 4"}, pending())
+}
@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+)
+
+const (
+	// redriveBatchSize is how many dead letters are redriven in each
+	// transaction.
+	redriveBatchSize = 1000
+
+	// maxRedriveConflicts is how many times a batch is retried when it
+	// conflicts with another write before giving up.
+	maxRedriveConflicts = 10
+)
+
+// DeadLetterEntry returns the entry that stores the dead letter record of the
+// message with the key k in the queue for the retention. It should be written
+// in the same transaction the message is removed in. Any retention less than
+// or equal to zero will be ignored and the record is kept until it's deleted,
+// redriven or the queue is dropped.
+func DeadLetterEntry(queue string, k key.Key, record []byte, retention time.Duration) *badger.Entry {
+	entry := badger.NewEntry(NewQueueKeyForDeadLetter(queue, k).Bytes(), record)
+	if retention > 0 {
+		entry = entry.WithTTL(retention)
+	}
+	return entry
+}
+
+// DeleteDeadLetter removes the dead letter record of the message with the key
+// k in the queue.
+func DeleteDeadLetter(db *badger.DB, queue string, k key.Key) error {
+	if err := db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(NewQueueKeyForDeadLetter(queue, k).Bytes())
+	}); err != nil {
+		return fmt.Errorf("delete dead letter: %w", err)
+	}
+	return nil
+}
+
+// RangeDeadLetters calls f, in message key order, with the dead letter records
+// of the queue. If f returns false, range stops the iteration.
+func RangeDeadLetters(db *badger.DB, queue string, f func(QueueItem) bool) error {
+	return rangePrefix(db, NewQueueKeyForDeadLetter(queue, nil).NamePrefixBytes(), f)
+}
+
+// RedriveFunc returns the message to put back in the queue for a dead letter
+// record, along with its TTL in the store.
+type RedriveFunc func(record []byte) ([]byte, time.Duration, error)
+
+// RedriveDeadLetters moves every dead letter of the queue back into it, in
+// order, as the messages returned by f. They are stored under new keys so
+// they are ready to be republished right away. The number of messages
+// redriven is returned, even if f fails part way, in which case the rest are
+// left where they are.
+func RedriveDeadLetters(db *badger.DB, queue string, f RedriveFunc) (int, error) {
+	return RedriveDeadLettersProgress(context.Background(), db, queue, f, nil)
+}
+
+// RedriveDeadLettersProgress is RedriveDeadLetters reporting its progress to
+// progress after each batch is moved, out of the number of dead letters there
+// were when it started. More may be redriven than that if messages are dead
+// lettered in the meantime. It stops between batches once ctx is done, keeping
+// the batches moved so far.
+func RedriveDeadLettersProgress(ctx context.Context, db *badger.DB, queue string, f RedriveFunc, progress Progress) (int, error) {
+	var total int
+	if progress != nil {
+		if err := RangeDeadLetters(db, queue, func(QueueItem) bool {
+			total++
+			return true
+		}); err != nil {
+			return 0, fmt.Errorf("redrive dead letters: %s: %w", queue, err)
+		}
+	}
+
+	var redriven int
+	progress.report(redriven, total)
+	for {
+		if err := ctx.Err(); err != nil {
+			return redriven, fmt.Errorf("redrive dead letters: %s: %w", queue, err)
+		}
+		n, err := redriveBatch(db, queue, f)
+		redriven += n
+		if err != nil {
+			return redriven, fmt.Errorf("redrive dead letters: %s: %w", queue, err)
+		}
+		if n == 0 {
+			return redriven, nil
+		}
+		if redriven > total {
+			total = redriven
+		}
+		progress.report(redriven, total)
+	}
+}
+
+func redriveBatch(db *badger.DB, queue string, f RedriveFunc) (int, error) {
+	var n int
+	var err error
+	for i := 0; i < maxRedriveConflicts; i++ {
+		n = 0
+		err = db.Update(func(txn *badger.Txn) error {
+			entries, deleted, err := redriveEntries(txn, queue, f)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if err := txn.SetEntry(e); err != nil {
+					return err
+				}
+			}
+			for _, k := range deleted {
+				if err := txn.Delete(k); err != nil {
+					return err
+				}
+			}
+			n = len(deleted)
+			return nil
+		})
+		if !errors.Is(err, badger.ErrConflict) {
+			break
+		}
+	}
+	return n, err
+}
+
+// redriveEntries reads a batch of dead letters and returns the entries of the
+// messages to write, with their expiry index entries, and the keys of the dead
+// letters to delete.
+func redriveEntries(txn *badger.Txn, queue string, f RedriveFunc) ([]*badger.Entry, [][]byte, error) {
+	prefix := NewQueueKeyForDeadLetter(queue, nil).NamePrefixBytes()
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	entries := make([]*badger.Entry, 0)
+	deleted := make([][]byte, 0)
+	for it.Seek(prefix); it.ValidForPrefix(prefix) && len(deleted) < redriveBatchSize; it.Next() {
+		item := it.Item()
+		if item.IsDeletedOrExpired() {
+			continue
+		}
+		record, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		v, ttl, err := f(record)
+		if err != nil {
+			return nil, nil, err
+		}
+		e := badger.NewEntry(NewQueueKeyForMessage(queue, key.New(time.Now())).Bytes(), v)
+		if ttl > 0 {
+			e = e.WithTTL(ttl)
+		}
+		entries = append(entries, e)
+		idx, err := ExpiryIndexEntry(e)
+		if err != nil {
+			return nil, nil, err
+		}
+		if idx != nil {
+			entries = append(entries, idx)
+		}
+		deleted = append(deleted, item.KeyCopy(nil))
+	}
+	return entries, deleted, nil
+}
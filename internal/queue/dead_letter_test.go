@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedriveDeadLetters(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	keys := make([]key.Key, 3)
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		for i := range keys {
+			keys[i] = key.New(now.Add(time.Duration(i) * time.Second))
+			if err := txn.SetEntry(DeadLetterEntry("orders", keys[i], []byte{byte('a' + i)}, 0)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	assert.NoError(t, DeleteDeadLetter(db, "orders", keys[1]))
+
+	// Nothing is moved if f fails.
+	boom := errors.New("boom")
+	n, err := RedriveDeadLetters(db, "orders", func([]byte) ([]byte, time.Duration, error) {
+		return nil, 0, boom
+	})
+	assert.True(t, errors.Is(err, boom))
+	assert.Equal(t, 0, n)
+
+	// Nothing is moved once canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err = RedriveDeadLettersProgress(ctx, db, "orders", func([]byte) ([]byte, time.Duration, error) {
+		return nil, 0, boom
+	}, nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 0, n)
+
+	var reported [][2]int
+	n, err = RedriveDeadLettersProgress(context.Background(), db, "orders", func(record []byte) ([]byte, time.Duration, error) {
+		m := protocol.DefaultRequeueMessage()
+		m.OriginalPayload = record
+		return m.Bytes(), 0, nil
+	}, func(done, total int) {
+		reported = append(reported, [2]int{done, total})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, [][2]int{{0, 2}, {2, 2}}, reported)
+
+	var left int
+	assert.NoError(t, RangeDeadLetters(db, "orders", func(QueueItem) bool {
+		left++
+		return true
+	}))
+	assert.Equal(t, 0, left)
+
+	var messages []string
+	assert.NoError(t, rangePrefix(db, NewQueueKeyForMessage("orders", nil).NamePrefixBytes(), func(qi QueueItem) bool {
+		messages = append(messages, string(flatbuf.GetRootAsRequeueMessage(qi.V, 0).OriginalPayloadBytes()))
+		return true
+	}))
+	assert.Equal(t, []string{"a", "c"}, messages)
+}
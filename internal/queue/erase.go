@@ -0,0 +1,207 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// ErasureKeyPrefix is the prefix of the keys the audit records of erasures
+// are stored under, which is followed by a message key so they're kept in the
+// order they were made.
+var ErasureKeyPrefix = []byte(QueuesNamespace + sep + "_d" + sep)
+
+const (
+	// eraseBatchSize is how many messages are removed in each transaction.
+	eraseBatchSize = 1000
+
+	// maxEraseConflicts is how many times a batch is retried when it
+	// conflicts with the republisher before giving up.
+	maxEraseConflicts = 10
+)
+
+// EraseMessages removes every message in the queue that match returns true
+// for, along with its coalescing index entry. Only the number of matching
+// messages is returned when dryRun is set. Messages that leave the queue
+// while they're being erased aren't counted.
+func EraseMessages(db *badger.DB, queue string, match func(*flatbuf.RequeueMessage) bool, dryRun bool) (int, error) {
+	return EraseMessagesProgress(context.Background(), db, queue, match, dryRun, nil)
+}
+
+// EraseMessagesProgress is EraseMessages reporting its progress to progress
+// after each batch of messages is removed, out of the number that matched. It
+// stops between batches once ctx is done, keeping the batches removed so far.
+func EraseMessagesProgress(ctx context.Context, db *badger.DB, queue string, match func(*flatbuf.RequeueMessage) bool, dryRun bool, progress Progress) (int, error) {
+	n, err := erase(ctx, db, NewQueueKeyForMessage(queue, nil).NamePrefixBytes(), queue, messageOf, match, dryRun, progress)
+	if err != nil {
+		return n, fmt.Errorf("erase messages: %s: %w", queue, err)
+	}
+	return n, nil
+}
+
+// EraseQuarantined removes every quarantined message of the queue that match
+// returns true for. Only the number of matching messages is returned when
+// dryRun is set.
+func EraseQuarantined(db *badger.DB, queue string, match func(*flatbuf.RequeueMessage) bool, dryRun bool) (int, error) {
+	n, err := erase(context.Background(), db, NewQueueKeyForQuarantine(queue, nil).NamePrefixBytes(), queue, quarantinedMessageOf, match, dryRun, nil)
+	if err != nil {
+		return n, fmt.Errorf("erase quarantined: %s: %w", queue, err)
+	}
+	return n, nil
+}
+
+// EraseDeadLettered removes every dead letter of the queue that match returns
+// true for. Only the number of matching messages is returned when dryRun is
+// set.
+func EraseDeadLettered(db *badger.DB, queue string, match func(*flatbuf.RequeueMessage) bool, dryRun bool) (int, error) {
+	n, err := erase(context.Background(), db, NewQueueKeyForDeadLetter(queue, nil).NamePrefixBytes(), queue, deadLetterMessageOf, match, dryRun, nil)
+	if err != nil {
+		return n, fmt.Errorf("erase dead lettered: %s: %w", queue, err)
+	}
+	return n, nil
+}
+
+func messageOf(v []byte) (*flatbuf.RequeueMessage, bool) {
+	return flatbuf.GetRootAsRequeueMessage(v, 0), true
+}
+
+func quarantinedMessageOf(v []byte) (*flatbuf.RequeueMessage, bool) {
+	var qm protocol.QuarantinedMessage
+	if err := qm.UnmarshalBinary(v); err != nil || len(qm.Message) == 0 {
+		return nil, false
+	}
+	return flatbuf.GetRootAsRequeueMessage(qm.Message, 0), true
+}
+
+func deadLetterMessageOf(v []byte) (*flatbuf.RequeueMessage, bool) {
+	var dl protocol.DeadLetter
+	if err := dl.UnmarshalBinary(v); err != nil || len(dl.Message) == 0 {
+		return nil, false
+	}
+	return flatbuf.GetRootAsRequeueMessage(dl.Message, 0), true
+}
+
+// erasable is a message to be erased.
+type erasable struct {
+	k         []byte
+	dedupeKey string
+}
+
+func erase(ctx context.Context, db *badger.DB, prefix []byte, queue string, decode func([]byte) (*flatbuf.RequeueMessage, bool), match func(*flatbuf.RequeueMessage) bool, dryRun bool, progress Progress) (int, error) {
+	matched := make([]erasable, 0)
+	if err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			item := it.Item()
+			if item.IsDeletedOrExpired() {
+				continue
+			}
+			if err := item.Value(func(v []byte) error {
+				fb, ok := decode(v)
+				if ok && match(fb) {
+					matched = append(matched, erasable{k: item.KeyCopy(nil), dedupeKey: string(fb.DedupeKey())})
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	if dryRun {
+		return len(matched), nil
+	}
+
+	total := len(matched)
+	var erased, done int
+	progress.report(done, total)
+	for len(matched) > 0 {
+		if err := ctx.Err(); err != nil {
+			return erased, err
+		}
+		batch := matched
+		if len(batch) > eraseBatchSize {
+			batch = batch[:eraseBatchSize]
+		}
+		n, err := eraseBatch(db, queue, batch)
+		if err != nil {
+			return erased, err
+		}
+		erased += n
+		done += len(batch)
+		matched = matched[len(batch):]
+		progress.report(done, total)
+	}
+	return erased, nil
+}
+
+// eraseBatch removes the messages that are still there in one transaction,
+// retrying if it conflicts with the republisher moving any of them.
+func eraseBatch(db *badger.DB, queue string, batch []erasable) (int, error) {
+	var erased int
+	var err error
+	for i := 0; i < maxEraseConflicts; i++ {
+		erased = 0
+		err = db.Update(func(txn *badger.Txn) error {
+			for _, e := range batch {
+				if _, err := txn.Get(e.k); err == badger.ErrKeyNotFound {
+					continue
+				} else if err != nil {
+					return err
+				}
+				if err := txn.Delete(e.k); err != nil {
+					return err
+				}
+				if e.dedupeKey != "" {
+					if err := UpdateCoalesceIndex(txn, queue, e.dedupeKey, e.k, nil); err != nil {
+						return err
+					}
+				}
+				erased++
+			}
+			return nil
+		})
+		if !errors.Is(err, badger.ErrConflict) {
+			break
+		}
+	}
+	return erased, err
+}
+
+// PutErasureRecord stores the audit record of an erasure under the key k.
+// They are kept until the store is removed.
+func PutErasureRecord(db *badger.DB, k key.Key, record []byte) error {
+	if err := db.Update(func(txn *badger.Txn) error {
+		return txn.Set(erasureKey(k), record)
+	}); err != nil {
+		return fmt.Errorf("put erasure record: %w", err)
+	}
+	return nil
+}
+
+// RangeErasureRecords calls f with the audit records of erasures in the order
+// they were made. If f returns false, range stops the iteration.
+func RangeErasureRecords(db *badger.DB, f func(QueueItem) bool) error {
+	return rangePrefix(db, ErasureKeyPrefix, f)
+}
+
+func erasureKey(k key.Key) []byte {
+	out := make([]byte, len(ErasureKeyPrefix)+len(k))
+	n := copy(out, ErasureKeyPrefix)
+	copy(out[n:], k)
+	return out
+}
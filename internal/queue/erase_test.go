@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEraseMessages(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	msg := func(userID, dedupeKey string) []byte {
+		m := protocol.DefaultRequeueMessage()
+		m.Metadata = protocol.Metadata{"user_id": userID}
+		m.DedupeKey = dedupeKey
+		return m.Bytes()
+	}
+	now := time.Now()
+	keys := make([]key.Key, 4)
+	for i := range keys {
+		keys[i] = key.New(now.Add(time.Duration(i) * time.Second))
+	}
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		for i, userID := range []string{"123", "456", "123"} {
+			if err := txn.Set(NewQueueKeyForMessage("orders", keys[i]).Bytes(), msg(userID, "")); err != nil {
+				return err
+			}
+		}
+		if err := txn.Set(NewQueueKeyForMessage("orders", keys[3]).Bytes(), msg("123", "profile")); err != nil {
+			return err
+		}
+		return txn.Set(NewQueueKeyForCoalesce("orders", "profile").Bytes(), NewQueueKeyForMessage("orders", keys[3]).Bytes())
+	}))
+	record, err := protocol.QuarantinedMessage{Message: msg("123", "")}.MarshalBinary()
+	assert.NoError(t, err)
+	assert.NoError(t, Quarantine(db, "orders", keys[0], record, 0))
+	record, err = protocol.DeadLetter{Message: msg("123", "")}.MarshalBinary()
+	assert.NoError(t, err)
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(DeadLetterEntry("orders", keys[0], record, 0))
+	}))
+
+	match := func(fb *flatbuf.RequeueMessage) bool {
+		return protocol.MatchMetadata(fb, protocol.Metadata{"user_id": "123"})
+	}
+
+	// A dry run only counts them.
+	n, err := EraseMessages(db, "orders", match, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	var reported [][2]int
+	n, err = EraseMessagesProgress(context.Background(), db, "orders", match, false, func(done, total int) {
+		reported = append(reported, [2]int{done, total})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, [][2]int{{0, 3}, {3, 3}}, reported)
+	n, err = EraseQuarantined(db, "orders", match, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = EraseDeadLettered(db, "orders", match, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	assert.NoError(t, db.View(func(txn *badger.Txn) error {
+		for i, k := range keys {
+			_, err := txn.Get(NewQueueKeyForMessage("orders", k).Bytes())
+			if i == 1 {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, badger.ErrKeyNotFound, err)
+			}
+		}
+		_, err := txn.Get(NewQueueKeyForCoalesce("orders", "profile").Bytes())
+		assert.Equal(t, badger.ErrKeyNotFound, err)
+		return nil
+	}))
+	var quarantined int
+	assert.NoError(t, RangeQuarantine(db, "orders", func(QueueItem) bool {
+		quarantined++
+		return true
+	}))
+	assert.Equal(t, 0, quarantined)
+	assert.NoError(t, RangeDeadLetters(db, "orders", func(QueueItem) bool {
+		t.Error("dead letter was not erased")
+		return false
+	}))
+
+	// Audit records are kept in order.
+	assert.NoError(t, PutErasureRecord(db, keys[1], []byte("second")))
+	assert.NoError(t, PutErasureRecord(db, keys[0], []byte("first")))
+	var records []string
+	assert.NoError(t, RangeErasureRecords(db, func(qi QueueItem) bool {
+		records = append(records, string(qi.V))
+		return true
+	}))
+	assert.Equal(t, []string{"first", "second"}, records)
+}
@@ -0,0 +1,222 @@
+package queue
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// fullSweepEvery is how often SweepExpired scans the whole queue rather than
+// only the expiry index. Full sweeps find messages written without an index
+// entry, e.g., before the index existed, and add the missing entries.
+const fullSweepEvery = 60
+
+// expirySweep collects what a sweep found so it can be removed in one batch.
+type expirySweep struct {
+	expired []protocol.ExpiredMessage
+	// Messages to remove.
+	keys [][]byte
+	// Index entries to remove, which includes those left behind by messages
+	// that have since been republished or requeued.
+	stale [][]byte
+	// Index entries missing for messages that expire later.
+	backfill []*badger.Entry
+}
+
+// SweepExpired removes every message in the queue whose TTL has elapsed as of
+// now and calls f with each one once they're removed. The number of messages
+// removed is returned.
+//
+// Messages are found through the expiry index, which is a range scan of the
+// entries that came due instead of a scan of the whole queue. The first sweep
+// and every fullSweepEvery sweeps after it scan the whole queue anyway.
+func (q *Queue) SweepExpired(now time.Time, f func(protocol.ExpiredMessage)) (int, error) {
+	var s expirySweep
+	var err error
+	if atomic.AddUint64(&q.sweeps, 1)%fullSweepEvery == 1 {
+		err = q.db.View(func(txn *badger.Txn) error {
+			return q.scanExpired(txn, now, &s)
+		})
+	} else {
+		err = q.db.View(func(txn *badger.Txn) error {
+			return q.scanExpiryIndex(txn, now, &s)
+		})
+	}
+	if err != nil {
+		return 0, fmt.Errorf("sweep expired: %s: %w", q.name, err)
+	}
+	if len(s.keys) == 0 && len(s.stale) == 0 && len(s.backfill) == 0 {
+		return 0, nil
+	}
+
+	wb := q.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range s.keys {
+		if err := wb.Delete(k); err != nil {
+			return 0, fmt.Errorf("sweep expired: %s: %w", q.name, err)
+		}
+	}
+	for _, k := range s.stale {
+		if err := wb.Delete(k); err != nil {
+			return 0, fmt.Errorf("sweep expired: %s: %w", q.name, err)
+		}
+	}
+	for _, e := range s.backfill {
+		if err := wb.SetEntry(e); err != nil {
+			return 0, fmt.Errorf("sweep expired: %s: %w", q.name, err)
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, fmt.Errorf("sweep expired: %s: %w", q.name, err)
+	}
+
+	if len(s.keys) == 0 {
+		return 0, nil
+	}
+	q.Stats.AddCount(-int64(len(s.keys)))
+	q.Stats.AddExpired(int64(len(s.keys)))
+	if f != nil {
+		for _, e := range s.expired {
+			f(e)
+		}
+	}
+	return len(s.keys), nil
+}
+
+// scanExpiryIndex finds the expired messages through the index entries that
+// came due as of now.
+func (q *Queue) scanExpiryIndex(txn *badger.Txn, now time.Time, s *expirySweep) error {
+	prefix := NewQueueKeyForExpiry(q.name, time.Time{}, nil).NamePrefixBytes()
+	until := NewQueueKeyForExpiry(q.name, now, key.Max).Bytes()
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		if bytes.Compare(item.Key(), until) > 0 {
+			break
+		}
+		if item.IsDeletedOrExpired() {
+			continue
+		}
+		idxKey := item.KeyCopy(nil)
+		_, k, ok := parseExpiryProperty(ParseQueueKey(idxKey).Property)
+		if !ok {
+			s.stale = append(s.stale, idxKey)
+			continue
+		}
+		msg, err := txn.Get(NewQueueKeyForMessage(q.name, k).Bytes())
+		if err == badger.ErrKeyNotFound {
+			// The message has already left the queue.
+			s.stale = append(s.stale, idxKey)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.stale = append(s.stale, idxKey)
+		expired, err := q.checkExpired(msg, now, s)
+		if err != nil {
+			return err
+		}
+		if expired {
+			continue
+		}
+		// The entry was out of date, e.g., the message was written by an
+		// older revision. Index it again under when it really expires.
+		if err := q.reindex(txn, msg, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanExpired finds the expired messages by scanning the whole queue. Index
+// entries are added for the messages that expire later and don't have one.
+func (q *Queue) scanExpired(txn *badger.Txn, now time.Time, s *expirySweep) error {
+	seek := FirstMessage(q.name)
+	prefix := PrefixOf(seek.Bytes(), LastMessage(q.name).Bytes())
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(seek.Bytes()); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		if item.IsDeletedOrExpired() {
+			continue
+		}
+		expired, err := q.checkExpired(item, now, s)
+		if err != nil {
+			return err
+		}
+		if expired {
+			continue
+		}
+		if err := q.reindex(txn, item, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindex adds the expiry index entry of the message held by item to the
+// sweep if it expires and the entry is missing.
+func (q *Queue) reindex(txn *badger.Txn, item *badger.Item, s *expirySweep) error {
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	idx, err := ExpiryIndexEntry(&badger.Entry{Key: item.KeyCopy(nil), Value: v, ExpiresAt: item.ExpiresAt()})
+	if err != nil {
+		// A corrupt message can't hold up the sweep of the rest.
+		log.Err(err).Str("queue", q.name).Msg("expiry sweep: problem indexing message")
+		return nil
+	}
+	if idx == nil {
+		return nil
+	}
+	_, err = txn.Get(idx.Key)
+	if err == badger.ErrKeyNotFound {
+		s.backfill = append(s.backfill, idx)
+		return nil
+	}
+	return err
+}
+
+// checkExpired adds the message held by item to the sweep if it expired as of
+// now. True is returned if it did.
+func (q *Queue) checkExpired(item *badger.Item, now time.Time, s *expirySweep) (bool, error) {
+	qi := QueueItem{K: item.KeyCopy(nil), ExpiresAt: item.ExpiresAt()}
+	var expired bool
+	err := item.Value(func(v []byte) error {
+		fb := flatbuf.GetRootAsRequeueMessage(v, 0)
+		expiresAt, ok := qi.Expiry(fb)
+		if !ok || expiresAt.After(now) {
+			return nil
+		}
+		expired = true
+		s.keys = append(s.keys, qi.K)
+		s.expired = append(s.expired, protocol.ExpiredMessage{
+			Queue:   q.name,
+			Key:     key.Key(ParseQueueKey(qi.K).Key).String(),
+			Subject: string(fb.OriginalSubject()),
+			Age:     now.Sub(qi.EnqueuedAt(fb)),
+		})
+		return nil
+	})
+	return expired, err
+}
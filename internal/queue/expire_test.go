@@ -0,0 +1,171 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueSweepExpired(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	q, err := createQueue(db, "orders")
+	assert.NoError(t, err)
+	defer q.Close()
+
+	now := time.Now()
+	add := func(subject string, enqueuedAt time.Time, delay, ttl time.Duration) {
+		m := protocol.DefaultRequeueMessage()
+		m.OriginalSubject = subject
+		m.Delay = uint64(delay)
+		m.TTL = uint64(ttl)
+		assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+			return txn.Set(NewQueueKeyForMessage("orders", key.New(enqueuedAt.Add(delay))).Bytes(), m.Bytes())
+		}))
+	}
+	add("expired", now.Add(-2*time.Hour), 0, time.Hour)
+	// The TTL counts from when it was enqueued, not when it's ready.
+	add("delayed", now.Add(-2*time.Hour), 3*time.Hour, time.Hour)
+	add("alive", now.Add(-2*time.Hour), 0, 3*time.Hour)
+	add("forever", now.Add(-48*time.Hour), 0, 0)
+
+	expired := make([]protocol.ExpiredMessage, 0)
+	n, err := q.SweepExpired(now, func(e protocol.ExpiredMessage) {
+		expired = append(expired, e)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, int64(2), q.Stats.Expired())
+	if assert.Len(t, expired, 2) {
+		for _, e := range expired {
+			assert.Equal(t, "orders", e.Queue)
+			assert.NotEmpty(t, e.Key)
+			assert.InDelta(t, float64(2*time.Hour), float64(e.Age), float64(time.Second))
+		}
+		assert.ElementsMatch(t, []string{"expired", "delayed"}, []string{expired[0].Subject, expired[1].Subject})
+	}
+
+	remaining := make([]string, 0)
+	_, err = q.Range(FirstMessage("orders"), LastMessage("orders"), func(qi QueueItem) bool {
+		var m protocol.RequeueMessage
+		assert.NoError(t, m.UnmarshalBinary(qi.V))
+		remaining = append(remaining, m.OriginalSubject)
+		return true
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"alive", "forever"}, remaining)
+
+	// Nothing left to sweep.
+	n, err = q.SweepExpired(now, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestQueueSweepExpiredIndex(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	q, err := createQueue(db, "orders")
+	assert.NoError(t, err)
+	defer q.Close()
+
+	now := time.Now()
+	add := func(subject string, ttl time.Duration) {
+		m := protocol.DefaultRequeueMessage()
+		m.OriginalSubject = subject
+		m.TTL = uint64(ttl)
+		done := make(chan error, 1)
+		assert.NoError(t, q.AddMessage(NewQueueKeyForMessage("orders", key.New(now)).Bytes(), m.Bytes(), 0, func(err error) {
+			done <- err
+		}))
+		assert.NoError(t, <-done)
+	}
+	add("short", time.Minute)
+	add("long", time.Hour)
+	add("forever", 0)
+
+	// Written without an index entry, like messages stored before the index
+	// existed.
+	unindexed := protocol.DefaultRequeueMessage()
+	unindexed.OriginalSubject = "unindexed"
+	unindexed.TTL = uint64(time.Minute)
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		return txn.Set(NewQueueKeyForMessage("orders", key.New(now)).Bytes(), unindexed.Bytes())
+	}))
+
+	countIndex := func() int {
+		n := 0
+		prefix := NewQueueKeyForExpiry("orders", time.Time{}, nil).NamePrefixBytes()
+		assert.NoError(t, db.View(func(txn *badger.Txn) error {
+			it := txn.NewIterator(badger.DefaultIteratorOptions)
+			defer it.Close()
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				n++
+			}
+			return nil
+		}))
+		return n
+	}
+	assert.Equal(t, 2, countIndex())
+
+	// The first sweep scans the whole queue and indexes what's missing.
+	n, err := q.SweepExpired(now, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 3, countIndex())
+
+	// Later sweeps only visit the index entries that came due.
+	subjects := make([]string, 0)
+	n, err = q.SweepExpired(now.Add(2*time.Minute), func(e protocol.ExpiredMessage) {
+		subjects = append(subjects, e.Subject)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.ElementsMatch(t, []string{"short", "unindexed"}, subjects)
+	assert.Equal(t, 1, countIndex())
+
+	// Entries left behind by messages that already left the queue are
+	// dropped once they come due.
+	assert.NoError(t, db.DropPrefix(NewQueueKeyForMessage("orders", nil).NamePrefixBytes()))
+	n, err = q.SweepExpired(now.Add(2*time.Hour), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 0, countIndex())
+}
+
+func TestExpiryIndexEntry(t *testing.T) {
+	k := NewQueueKeyForMessage("orders", key.New(time.Now())).Bytes()
+
+	msg := protocol.DefaultRequeueMessage()
+	msg.TTL = uint64(time.Hour)
+	idx, err := ExpiryIndexEntry(badger.NewEntry(k, msg.Bytes()))
+	assert.NoError(t, err)
+	assert.NotNil(t, idx)
+
+	// Without a TTL the message never expires.
+	msg.TTL = 0
+	idx, err = ExpiryIndexEntry(badger.NewEntry(k, msg.Bytes()))
+	assert.NoError(t, err)
+	assert.Nil(t, idx)
+
+	// A corrupt envelope is an error rather than a panic.
+	for _, v := range [][]byte{
+		{0xff, 0xff, 0xff, 0x7f, 0, 0, 0, 0},
+		{4, 0, 0, 0, 0xff, 0xff, 0xff, 0x7f},
+		{4, 0, 0, 0, 0xfc, 0xff, 0xff, 0xff, 0xff, 0x7f, 0xff, 0x7f},
+	} {
+		_, err = ExpiryIndexEntry(badger.NewEntry(k, v))
+		assert.Error(t, err, "value %v", v)
+	}
+}
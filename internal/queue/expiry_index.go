@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+)
+
+// expiryTimeSize is the number of bytes the expiry time takes up at the front
+// of the property of an expiry index key.
+const expiryTimeSize = 8
+
+// NewQueueKeyForExpiry creates the key of the expiry index entry for the
+// message with key k that expires at expiresAt. The expiry time comes first so
+// the entries of a queue are ordered by when their messages expire.
+func NewQueueKeyForExpiry(queue string, expiresAt time.Time, k key.Key) QueueKey {
+	p := make([]byte, expiryTimeSize+len(k))
+	binary.BigEndian.PutUint64(p, uint64(expiresAt.UnixNano()))
+	copy(p[expiryTimeSize:], k)
+	return QueueKey{
+		Namespace: QueuesNamespace,
+		Bucket:    ExpiryBucket,
+		Name:      queue,
+		Property:  string(p),
+	}
+}
+
+// parseExpiryProperty returns the expiry time and message key held by the
+// property of an expiry index key. False is returned if it's malformed.
+func parseExpiryProperty(p string) (time.Time, key.Key, bool) {
+	if len(p) != expiryTimeSize+key.Size {
+		return time.Time{}, nil, false
+	}
+	b := []byte(p)
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b))), key.Key(b[expiryTimeSize:]), true
+}
+
+// ExpiryIndexEntry returns the expiry index entry for the message entry e, or
+// nil if the message never expires. It should be written along with the
+// message so the sweeper can find it without scanning the whole queue. The
+// entry isn't removed with the message; the sweeper drops entries left behind
+// once they come due. An error is returned if the message envelope is corrupt.
+func ExpiryIndexEntry(e *badger.Entry) (*badger.Entry, error) {
+	qk := ParseQueueKey(e.Key)
+	if qk.Bucket != MessagesBucket || len(qk.Key) != key.Size {
+		return nil, nil
+	}
+	qi := QueueItem{K: e.Key, ExpiresAt: e.ExpiresAt}
+	expiresAt, ok, err := messageExpiry(qi, e.Value)
+	if err != nil {
+		return nil, fmt.Errorf("expiry index: %s: %w", qk.Key, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	idx := badger.NewEntry(NewQueueKeyForExpiry(qk.Name, expiresAt, qk.Key).Bytes(), nil)
+	// Don't outlive the message in the store.
+	idx.ExpiresAt = e.ExpiresAt
+	return idx, nil
+}
+
+// messageExpiry returns when the message with the envelope v expires. The
+// envelope isn't trusted: flatbuffers panics reading a table out of bounds, so
+// a corrupt one is returned as an error instead.
+func messageExpiry(qi QueueItem, v []byte) (expiresAt time.Time, ok bool, err error) {
+	if len(v) < flatbuffers.SizeUOffsetT {
+		// Too short to hold an envelope so only the TTL in the store counts.
+		return qi.ExpiresAtTime(), qi.ExpiresAt != 0, nil
+	}
+	if root := flatbuffers.GetUOffsetT(v); int(root)+flatbuffers.SizeSOffsetT > len(v) {
+		return time.Time{}, false, fmt.Errorf("corrupt message envelope: root offset %d out of bounds", root)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			expiresAt, ok, err = time.Time{}, false, fmt.Errorf("corrupt message envelope: %v", r)
+		}
+	}()
+	expiresAt, ok = qi.Expiry(flatbuf.GetRootAsRequeueMessage(v, 0))
+	return expiresAt, ok, nil
+}
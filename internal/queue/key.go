@@ -2,7 +2,10 @@ package queue
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
+	"time"
 
 	"github.com/nickpoorman/nats-requeue/internal/debug"
 	"github.com/nickpoorman/nats-requeue/internal/key"
@@ -15,19 +18,46 @@ import (
 // Buckets are used to group properties. For example all messages are written
 // to the _m bucket and all state properties are written to the _s bucket.
 //
-// Some examples:
+// Some examples (as printed):
 // _q._m.high.aWgEPTl1tmebfsQzFP4bxwgy80V
 // _q._s.high.checkpoint
 // _q._s.medium.checkpoint
 // _q._s.low.checkpoint
 // _q._s.low.other_state_property
+//
+// On disk the queue name is not followed by a separator. Instead it is length
+// prefixed and the message key is stored as raw bytes:
+//
+// namespace | . | bucket | . | uint16 name length | name | key or property
+//
+// This keeps every segment either fixed width or length prefixed, so queue
+// names may contain any character, prefixes are exact and keys are smaller.
 
 const (
 	sep                = "."
 	QueuesNamespace    = "_q"
 	MessagesBucket     = "_m"
 	StateBucket        = "_s"
+	QuarantineBucket   = "_x"
+	TerminalBucket     = "_r"
+	CoalesceBucket     = "_c"
+	ExpiryBucket       = "_e"
+	AckFailureBucket   = "_a"
 	CheckpointProperty = "checkpoint"
+
+	// DeadLetterBucket holds the messages given up on. It isn't _d, as in
+	// dead, because _q._d. is already the prefix of the erasure audit records,
+	// which are keyed by a message key rather than a queue name. A queue
+	// prefix under it would overlap them, so neither could be parsed or
+	// ranged over without tripping on the other.
+	DeadLetterBucket = "_l"
+
+	// nameLenSize is the number of bytes used to prefix the queue name with its
+	// length.
+	nameLenSize = 2
+
+	// MaxNameLength is the longest queue name that can be encoded in a key.
+	MaxNameLength = math.MaxUint16
 )
 
 type QueueKey struct {
@@ -48,6 +78,63 @@ func NewQueueKeyForMessage(queue string, key key.Key) QueueKey {
 	}
 }
 
+// NewQueueKeyForQuarantine creates a key for a message that was quarantined at
+// ingest. Quarantined messages are kept apart from the messages bucket so they
+// are never republished.
+func NewQueueKeyForQuarantine(queue string, key key.Key) QueueKey {
+	return QueueKey{
+		Namespace: QueuesNamespace,
+		Bucket:    QuarantineBucket,
+		Name:      queue,
+		Key:       key,
+	}
+}
+
+// NewQueueKeyForTerminal creates a key for the terminal record of a message,
+// i.e., what happened to it once it left the queue.
+func NewQueueKeyForTerminal(queue string, key key.Key) QueueKey {
+	return QueueKey{
+		Namespace: QueuesNamespace,
+		Bucket:    TerminalBucket,
+		Name:      queue,
+		Key:       key,
+	}
+}
+
+// NewQueueKeyForAckFailure creates a key for the record of a message that was
+// persisted but couldn't be acknowledged to its producer.
+func NewQueueKeyForAckFailure(queue string, key key.Key) QueueKey {
+	return QueueKey{
+		Namespace: QueuesNamespace,
+		Bucket:    AckFailureBucket,
+		Name:      queue,
+		Key:       key,
+	}
+}
+
+// NewQueueKeyForDeadLetter creates a key for a message that was given up on.
+// Dead letters are kept apart from the messages bucket so they are never
+// republished unless they're redriven.
+func NewQueueKeyForDeadLetter(queue string, key key.Key) QueueKey {
+	return QueueKey{
+		Namespace: QueuesNamespace,
+		Bucket:    DeadLetterBucket,
+		Name:      queue,
+		Key:       key,
+	}
+}
+
+// NewQueueKeyForCoalesce creates the key of the coalescing index entry for the
+// dedupe key, which holds the key of the pending message with it.
+func NewQueueKeyForCoalesce(queue, dedupeKey string) QueueKey {
+	return QueueKey{
+		Namespace: QueuesNamespace,
+		Bucket:    CoalesceBucket,
+		Name:      queue,
+		Property:  dedupeKey,
+	}
+}
+
 func NewQueueKeyForState(queue, property string) QueueKey {
 	return QueueKey{
 		Namespace: QueuesNamespace,
@@ -57,16 +144,42 @@ func NewQueueKeyForState(queue, property string) QueueKey {
 	}
 }
 
+// ParseQueueKey parses the bytes of a key created by QueueKey.Bytes. A zero
+// QueueKey is returned if k is not a valid key.
 func ParseQueueKey(k []byte) QueueKey {
-	spl := bytes.SplitN(k, []byte(sep), 4)
-	// The last slice will be the remainer. Assert it's the correct length.
-	debug.Assert(len(spl[3]) == key.Size, fmt.Errorf("invalid QueueKey.Key size: Expected=%d Got=%d QueueKey=%v", key.Size, len(spl[3]), spl[3]))
-	return QueueKey{
+	spl := bytes.SplitN(k, []byte(sep), 3)
+	if len(spl) != 3 || len(spl[2]) < nameLenSize {
+		return QueueKey{}
+	}
+	rest := spl[2]
+	n := int(binary.BigEndian.Uint16(rest[:nameLenSize]))
+	if len(rest) < nameLenSize+n {
+		return QueueKey{}
+	}
+	qk := QueueKey{
 		Namespace: string(spl[0]),
 		Bucket:    string(spl[1]),
-		Name:      string(spl[2]),
-		Key:       spl[3],
+		Name:      string(rest[nameLenSize : nameLenSize+n]),
 	}
+	tail := rest[nameLenSize+n:]
+	if !hasMessageKey(qk.Bucket) {
+		qk.Property = string(tail)
+		return qk
+	}
+	// The remainder is the message key. Assert it's the correct length.
+	debug.Assert(len(tail) == key.Size, fmt.Errorf("invalid QueueKey.Key size: Expected=%d Got=%d QueueKey=%v", key.Size, len(tail), tail))
+	qk.Key = tail
+	return qk
+}
+
+// hasMessageKey reports whether the keys of the bucket end with a message key
+// rather than a property.
+func hasMessageKey(bucket string) bool {
+	switch bucket {
+	case MessagesBucket, QuarantineBucket, TerminalBucket, AckFailureBucket, DeadLetterBucket:
+		return true
+	}
+	return false
 }
 
 func assertMessageQueueKeyIsValid(key []byte, queueName string) bool {
@@ -81,27 +194,38 @@ func (q QueueKey) IsKey() bool {
 }
 
 func (q QueueKey) Bytes() []byte {
-	ns := []byte(q.Namespace)
-	bk := []byte(q.Bucket)
-	na := []byte(q.Name)
-	sp := []byte(sep)
+	prefix := q.NamePrefixBytes()
 	var p []byte
 	if q.IsKey() {
 		p = q.Key
 	} else {
 		p = []byte(q.Property)
 	}
-	qk := make([]byte, len(ns)+len(sp)+len(bk)+len(sp)+len(na)+len(sp)+len(p))
-	off := copy(qk, ns)
-	off += copy(qk[off:], sp)
-	off += copy(qk[off:], bk)
-	off += copy(qk[off:], sp)
-	off += copy(qk[off:], na)
-	off += copy(qk[off:], sp)
+	qk := make([]byte, len(prefix)+len(p))
+	off := copy(qk, prefix)
 	copy(qk[off:], p)
 	return qk
 }
 
+// BucketPrefixBytes returns the encoded prefix shared by every key in the
+// bucket.
+func (q QueueKey) BucketPrefixBytes() []byte {
+	return []byte(q.BucketPrefix())
+}
+
+// NamePrefixBytes returns the encoded prefix shared by every key in the bucket
+// for the queue.
+func (q QueueKey) NamePrefixBytes() []byte {
+	debug.Assert(len(q.Name) <= MaxNameLength, fmt.Errorf("queue name is too long: %d", len(q.Name)))
+	bp := q.BucketPrefix()
+	out := make([]byte, len(bp)+nameLenSize+len(q.Name))
+	off := copy(out, bp)
+	binary.BigEndian.PutUint16(out[off:], uint16(len(q.Name)))
+	off += nameLenSize
+	copy(out[off:], q.Name)
+	return out
+}
+
 func (q QueueKey) BucketPath() string {
 	return fmt.Sprintf("%s%s%s", q.Namespace, sep, q.Bucket)
 }
@@ -110,6 +234,8 @@ func (q QueueKey) BucketPrefix() string {
 	return fmt.Sprintf("%s%s", q.BucketPath(), sep)
 }
 
+// NamePath is the printable path of the queue. Use NamePrefixBytes when
+// scanning the store.
 func (q QueueKey) NamePath() string {
 	return fmt.Sprintf("%s%s", q.BucketPrefix(), q.Name)
 }
@@ -167,3 +293,18 @@ func FirstMessage(queue string) QueueKey {
 func LastMessage(queue string) QueueKey {
 	return NewQueueKeyForMessage(queue, key.Max)
 }
+
+// FirstMessageAt returns the smallest possible key in the queue for messages
+// that became ready at or after t.
+func FirstMessageAt(queue string, t time.Time) QueueKey {
+	return NewQueueKeyForMessage(queue, key.FromTime(t))
+}
+
+// Time returns the time encoded in the message key. The zero time is returned
+// if this is not a message key.
+func (q QueueKey) Time() time.Time {
+	if !q.IsKey() || len(q.Key) != key.Size {
+		return time.Time{}
+	}
+	return key.TimeOf(q.Key)
+}
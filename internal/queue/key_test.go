@@ -125,7 +125,7 @@ func TestNewQueueKeyForMessage(t *testing.T) {
 }
 
 func TestPrefixOf(t *testing.T) {
-	want := "_q._m.testqueue."
+	want := "_q._m.\x00\x09testqueue"
 	queueName := "testqueue"
 	qk1 := FirstMessage(queueName)
 	qk2 := LastMessage(queueName)
@@ -198,3 +198,38 @@ func TestPropertyPrefix(t *testing.T) {
 	}
 	assert.Equal(t, want, qk.PropertyPrefix())
 }
+
+func TestParseQueueKeyNameWithSep(t *testing.T) {
+	// Queue names are length prefixed so they may contain the separator.
+	queueName := "orders.created"
+	k1 := key.New(time.Now())
+	qk := ParseQueueKey(NewQueueKeyForMessage(queueName, k1).Bytes())
+	assert.Equal(t, queueName, qk.Name)
+	assert.Equal(t, k1, qk.Key)
+
+	sk := ParseQueueKey(NewQueueKeyForState(queueName, CheckpointProperty).Bytes())
+	assert.Equal(t, StateBucket, sk.Bucket)
+	assert.Equal(t, queueName, sk.Name)
+	assert.Equal(t, CheckpointProperty, sk.Property)
+}
+
+func TestPrefixOfDoesNotMatchOtherQueues(t *testing.T) {
+	// With the legacy encoding the prefix for "a" would also match "a.b".
+	prefix := PrefixOf(FirstMessage("a").Bytes(), LastMessage("a").Bytes())
+	other := NewQueueKeyForMessage("a.b", key.New(time.Now())).Bytes()
+	assert.False(t, bytes.HasPrefix(other, prefix))
+}
+
+func TestParseQueueKeyInvalid(t *testing.T) {
+	assert.Equal(t, QueueKey{}, ParseQueueKey(nil))
+	assert.Equal(t, QueueKey{}, ParseQueueKey([]byte("_q._m.")))
+}
+
+func TestMessageKeyIsRawBytes(t *testing.T) {
+	// The message key must be stored in its raw binary form rather than an
+	// encoded string so keys stay small and no encode step is needed on ingest.
+	qk := NewQueueKeyForMessage("testqueue", key.New(time.Now()))
+	by := qk.Bytes()
+	assert.Len(t, by, len(qk.NamePrefixBytes())+key.Size)
+	assert.Equal(t, qk.Key.Bytes(), by[len(by)-key.Size:])
+}
@@ -1,7 +1,9 @@
 package queue
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -10,6 +12,10 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// ManagerClosedError is returned when creating a queue once the manager has
+// been closed.
+var ManagerClosedError = errors.New("queue manager is closed")
+
 // TODO: Set this to something much higher and allow to be pased to manager.
 const checkQueueStatesInterval = 5 * time.Second
 
@@ -18,23 +24,40 @@ type Manager struct {
 	db                       *badger.DB
 	checkQueueStatesInterval time.Duration
 
+	// The options every queue is created with.
+	queueOpts []QueueOption
+
 	mu     sync.RWMutex
 	queues map[string]*Queue
+	// Set once the queues have been closed.
+	closed bool
 
 	quit chan struct{}
 	done chan struct{}
 }
 
-// NewManger creates a NewManager responsible for managing the queues.
-func NewManager(db *badger.DB) (*Manager, error) {
+// NewManger creates a NewManager responsible for managing the queues. The queue
+// options are applied to every queue it manages.
+func NewManager(db *badger.DB, queueOptions ...QueueOption) (*Manager, error) {
 	m := &Manager{
 		db:                       db,
 		checkQueueStatesInterval: checkQueueStatesInterval,
+		queueOpts:                queueOptions,
 		queues:                   make(map[string]*Queue),
 		quit:                     make(chan struct{}),
 		done:                     make(chan struct{}),
 	}
+	if _, err := MigrateKeyFormat(db); err != nil {
+		return nil, err
+	}
+	if _, err := CompactState(db); err != nil {
+		return nil, err
+	}
 	if err := m.loadFromDisk(); err != nil {
+		// Don't leave the queues loaded so far running.
+		for _, q := range m.Queues() {
+			q.Close()
+		}
 		return nil, err
 	}
 	go m.initBackgroundTasks()
@@ -52,6 +75,7 @@ func (m *Manager) initBackgroundTasks() {
 		defer wg.Done()
 		<-m.quit
 		m.mu.Lock()
+		defer m.mu.Unlock()
 		var cWg sync.WaitGroup
 		cWg.Add(len(m.queues))
 		for _, v := range m.queues {
@@ -61,6 +85,7 @@ func (m *Manager) initBackgroundTasks() {
 			}(v)
 		}
 		cWg.Wait()
+		m.closed = true
 	}()
 
 	go func() {
@@ -99,45 +124,54 @@ func (m *Manager) loadFromDisk() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if err := m.loadQueueStates(); err != nil {
+		return err
+	}
+	return m.discoverQueues()
+}
+
+// loadQueueStates loads every queue that has its state on disk.
+// Should be called with lock acquired.
+func (m *Manager) loadQueueStates() error {
 	// List out all the queues under the namespace and load up each one.
 	return m.db.View(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
-		prefix := []byte(QueueKey{
+		prefix := QueueKey{
 			Namespace: QueuesNamespace,
 			Bucket:    StateBucket,
-		}.BucketPrefix())
+		}.BucketPrefixBytes()
 
 		// Keys are iterated over in order so we can build one queue at a time.
 		builder := NewQueueBuilder()
 
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			item := it.Item()
-			key, err := item.ValueCopy(nil)
-			if err != nil {
-				return err
-			}
+			key := item.KeyCopy(nil)
 			value, err := item.ValueCopy(nil)
 			if err != nil {
 				return err
 			}
 			if err := builder.Set(key, value); err != nil {
-				if err == DifferentQueueNameError {
-					// We've reached a new queue.
-					q, err := builder.Build(m.db)
-					if err != nil {
-						return err
-					}
-					// Add the queue to our manager.
-					m.addQueue(q)
-					continue // Move on to next
+				if err != DifferentQueueNameError {
+					return err
+				}
+				// We've reached a new queue.
+				q, err := builder.Build(m.db, m.queueOpts...)
+				if err != nil {
+					return err
+				}
+				// Add the queue to our manager.
+				m.addQueue(q)
+				// The key is the first of the next queue.
+				if err := builder.Set(key, value); err != nil {
+					return err
 				}
-				return err
 			}
 		}
 		// Add the queue from the final iteration if there is one.
 		if !builder.IsZero() {
-			q, err := builder.Build(m.db)
+			q, err := builder.Build(m.db, m.queueOpts...)
 			if err != nil {
 				return err
 			}
@@ -148,6 +182,69 @@ func (m *Manager) loadFromDisk() error {
 	})
 }
 
+// discoverQueues registers the queues that have messages on disk but no state,
+// e.g., because they were created by a previous version, so they are
+// republished and included in the stats rather than ignored. Their state is
+// created so they're loaded like any other queue from then on.
+// Should be called with lock acquired.
+func (m *Manager) discoverQueues() error {
+	names := make([]string, 0)
+	if err := m.db.View(func(txn *badger.Txn) error {
+		prefix := NewQueueKeyForMessage("", nil).BucketPrefixBytes()
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); {
+			name := ParseQueueKey(it.Item().Key()).Name
+			if name == "" {
+				// Not a key we know how to read.
+				it.Next()
+				continue
+			}
+			if _, ok := m.queues[name]; !ok {
+				names = append(names, name)
+			}
+			// Skip over the rest of the messages in the queue.
+			end := prefixEnd(NewQueueKeyForMessage(name, nil).NamePrefixBytes())
+			if end == nil {
+				return nil
+			}
+			it.Seek(end)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("discover queues: %w", err)
+	}
+
+	for _, name := range names {
+		q, err := createQueue(m.db, name, m.queueOpts...)
+		if err != nil {
+			return fmt.Errorf("discover queues: %w", err)
+		}
+		m.addQueue(q)
+	}
+	if len(names) > 0 {
+		log.Info().Strs("queues", names).Msg("registered queues found on disk without any state")
+	}
+	return nil
+}
+
+// prefixEnd returns the smallest key that sorts after every key with the
+// prefix, or nil if there isn't one.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < math.MaxUint8 {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
 func (m *Manager) CreateQueue(qk QueueKey) (*Queue, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -162,8 +259,11 @@ func (m *Manager) CreateQueue(qk QueueKey) (*Queue, error) {
 		// It exists and we don't need to create it.
 		return q, nil
 	}
+	if m.closed {
+		return nil, ManagerClosedError
+	}
 
-	queue, err := createQueue(m.db, name)
+	queue, err := createQueue(m.db, name, m.queueOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -192,6 +292,13 @@ func (m *Manager) Queues() []*Queue {
 	return qs
 }
 
+// Flush commits the messages waiting to be batched in every queue now.
+func (m *Manager) Flush() {
+	for _, q := range m.Queues() {
+		q.Flush()
+	}
+}
+
 func (m *Manager) Close() {
 	close(m.quit)
 	<-m.done
@@ -203,3 +310,60 @@ func (m *Manager) GetQueue(name string) (*Queue, bool) {
 	m.mu.RUnlock()
 	return q, ok
 }
+
+// DropQueue removes a queue along with all of its messages and state. The
+// messages are removed with a prefix delete so this is cheap regardless of how
+// many messages are in the queue.
+func (m *Manager) DropQueue(name string) error {
+	if name == "" {
+		return fmt.Errorf("drop queue: queue name cannot be blank")
+	}
+
+	m.mu.Lock()
+	q, ok := m.queues[name]
+	delete(m.queues, name)
+	m.mu.Unlock()
+
+	if ok {
+		q.Close()
+	}
+
+	if err := m.db.DropPrefix(
+		NewQueueKeyForMessage(name, nil).NamePrefixBytes(),
+		NewQueueKeyForState(name, "").NamePrefixBytes(),
+		NewQueueKeyForQuarantine(name, nil).NamePrefixBytes(),
+		NewQueueKeyForTerminal(name, nil).NamePrefixBytes(),
+		NewQueueKeyForAckFailure(name, nil).NamePrefixBytes(),
+		NewQueueKeyForDeadLetter(name, nil).NamePrefixBytes(),
+		NewQueueKeyForCoalesce(name, "").NamePrefixBytes(),
+		NewQueueKeyForExpiry(name, time.Time{}, nil).NamePrefixBytes(),
+	); err != nil {
+		return fmt.Errorf("drop queue: %s: %w", name, err)
+	}
+	return nil
+}
+
+// DropTimeBucketsBefore drops every sub-queue of base created by the
+// TimeBucket b whose time slice ended before t. The names of the dropped
+// queues are returned.
+func (m *Manager) DropTimeBucketsBefore(base string, b TimeBucket, t time.Time) ([]string, error) {
+	dropped := make([]string, 0)
+	for _, q := range m.Queues() {
+		qBase, _ := SplitBucketName(q.Name())
+		if qBase != base {
+			continue
+		}
+		start, ok := b.ParseTime(q.Name())
+		if !ok {
+			continue
+		}
+		if start.Add(b.duration()).After(t) {
+			continue
+		}
+		if err := m.DropQueue(q.Name()); err != nil {
+			return dropped, err
+		}
+		dropped = append(dropped, q.Name())
+	}
+	return dropped, nil
+}
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagerLoadFromDisk(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	queueNames := func(m *Manager) []string {
+		names := make([]string, 0)
+		for _, q := range m.Queues() {
+			names = append(names, q.Name())
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	m, err := NewManager(db)
+	assert.NoError(t, err)
+	for _, name := range []string{"orders", "payments"} {
+		_, err := m.CreateQueue(NewQueueKeyForState(name, ""))
+		assert.NoError(t, err)
+	}
+	m.Close()
+
+	// A queue with messages but no state, e.g., from a previous version.
+	msg := protocol.DefaultRequeueMessage()
+	msg.OriginalSubject = "foo"
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		for i := 0; i < 3; i++ {
+			k := NewQueueKeyForMessage("legacy", key.New(time.Now()))
+			if err := txn.Set(k.Bytes(), msg.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	m, err = NewManager(db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"legacy", "orders", "payments"}, queueNames(m))
+	m.Close()
+
+	// Its state was created so it's loaded like the others from then on.
+	m, err = NewManager(db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"legacy", "orders", "payments"}, queueNames(m))
+	m.Close()
+}
+
+func TestManagerClosed(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m, err := NewManager(db)
+	assert.NoError(t, err)
+	_, err = m.CreateQueue(NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+	m.Close()
+
+	// Whoever still holds the manager isn't blocked, but can't create queues.
+	_, ok := m.GetQueue("orders")
+	assert.True(t, ok)
+	_, err = m.UpsertQueueState(NewQueueKeyForState("payments", ""))
+	assert.True(t, errors.Is(err, ManagerClosedError))
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("ab"), prefixEnd([]byte("aa")))
+	assert.Equal(t, []byte("b"), prefixEnd([]byte{'a', 0xff}))
+	assert.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+}
@@ -0,0 +1,144 @@
+package queue
+
+import (
+	"bytes"
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// legacyKeyFormat is the original encoding where every segment was
+	// separated by sep, i.e., _q._m.<name>.<key>.
+	legacyKeyFormat byte = 1
+
+	// currentKeyFormat is the length prefixed encoding produced by
+	// QueueKey.Bytes.
+	currentKeyFormat byte = 2
+)
+
+// KeyFormatKey is where the key format version of a store is persisted.
+var KeyFormatKey = []byte(QueuesNamespace + sep + "_v")
+
+// parseLegacyQueueKey parses a key written with the legacy key format.
+func parseLegacyQueueKey(k []byte) (QueueKey, bool) {
+	spl := bytes.SplitN(k, []byte(sep), 4)
+	if len(spl) != 4 {
+		return QueueKey{}, false
+	}
+	qk := QueueKey{
+		Namespace: string(spl[0]),
+		Bucket:    string(spl[1]),
+		Name:      string(spl[2]),
+	}
+	if hasMessageKey(qk.Bucket) {
+		qk.Key = spl[3]
+	} else {
+		qk.Property = string(spl[3])
+	}
+	return qk, true
+}
+
+// MigrateKeyFormat rewrites any queue keys written with the legacy key format
+// to the current format and records the format version so the work is only
+// done once. The number of migrated keys is returned.
+func MigrateKeyFormat(db *badger.DB) (int, error) {
+	return MigrateKeyFormatProgress(db, nil)
+}
+
+// MigrateKeyFormatProgress is MigrateKeyFormat reporting the number of keys
+// migrated so far to progress every so often. The total isn't known until
+// it's done. Nothing is reported if there's nothing to migrate.
+func MigrateKeyFormatProgress(db *badger.DB, progress Progress) (int, error) {
+	var format byte
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(KeyFormatKey)
+		if err == badger.ErrKeyNotFound {
+			format = legacyKeyFormat
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			if len(v) > 0 {
+				format = v[0]
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("migrate key format: %w", err)
+	}
+	if format == currentKeyFormat {
+		return 0, nil
+	}
+	if format != legacyKeyFormat {
+		return 0, fmt.Errorf("migrate key format: unknown key format: %d", format)
+	}
+
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+
+	var migrated int
+	err = db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(QueuesNamespace + sep)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			if item.IsDeletedOrExpired() || bytes.Equal(item.Key(), KeyFormatKey) {
+				continue
+			}
+			// The erasure audit records aren't queue keys, so there's
+			// nothing to migrate.
+			if bytes.HasPrefix(item.Key(), ErasureKeyPrefix) {
+				continue
+			}
+			oldKey := item.KeyCopy(nil)
+			qk, ok := parseLegacyQueueKey(oldKey)
+			if !ok {
+				log.Warn().Bytes("key", oldKey).Msg("migrate key format: skipping unknown key")
+				continue
+			}
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			// Checkpoints are themselves message keys so they need migrating too.
+			if qk.Property == CheckpointProperty {
+				if cp, ok := parseLegacyQueueKey(value); ok {
+					value = cp.Bytes()
+				}
+			}
+			e := badger.NewEntry(qk.Bytes(), value).WithMeta(item.UserMeta())
+			e.ExpiresAt = item.ExpiresAt()
+			if err := wb.SetEntry(e); err != nil {
+				return err
+			}
+			if err := wb.Delete(oldKey); err != nil {
+				return err
+			}
+			migrated++
+			if migrated%progressEvery == 0 {
+				progress.report(migrated, 0)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return migrated, fmt.Errorf("migrate key format: %w", err)
+	}
+	if err := wb.Set(KeyFormatKey, []byte{currentKeyFormat}); err != nil {
+		return migrated, fmt.Errorf("migrate key format: %w", err)
+	}
+	if err := wb.Flush(); err != nil {
+		return migrated, fmt.Errorf("migrate key format: %w", err)
+	}
+	if migrated > 0 {
+		progress.report(migrated, migrated)
+		log.Info().Msgf("migrated %d queue keys to key format %d", migrated, currentKeyFormat)
+	}
+	return migrated, nil
+}
@@ -0,0 +1,90 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func legacyBytes(qk QueueKey) []byte {
+	p := []byte(qk.Property)
+	if qk.IsKey() {
+		p = qk.Key
+	}
+	return append([]byte(qk.NamePrefix()), p...)
+}
+
+func TestMigrateKeyFormat(t *testing.T) {
+	openOpts := badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR)
+	db, err := badger.Open(openOpts)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	queueName := "testqueue"
+	msg := NewQueueKeyForMessage(queueName, key.New(time.Now()))
+	cp := NewQueueKeyForState(queueName, CheckpointProperty)
+	dl := NewQueueKeyForDeadLetter(queueName, key.New(time.Now()))
+	erasure := append(append([]byte{}, ErasureKeyPrefix...), key.New(time.Now())...)
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		if err := txn.SetEntry(badger.NewEntry(legacyBytes(msg), []byte("foo")).WithTTL(time.Hour)); err != nil {
+			return err
+		}
+		if err := txn.SetEntry(badger.NewEntry(legacyBytes(dl), []byte("bar")).WithTTL(time.Hour)); err != nil {
+			return err
+		}
+		if err := txn.Set(erasure, []byte("baz")); err != nil {
+			return err
+		}
+		return txn.Set(legacyBytes(cp), legacyBytes(FirstMessage(queueName)))
+	}))
+
+	var reported [][2]int
+	migrated, err := MigrateKeyFormatProgress(db, func(done, total int) {
+		reported = append(reported, [2]int{done, total})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, migrated)
+	assert.Equal(t, [][2]int{{3, 3}}, reported)
+
+	assert.NoError(t, db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(msg.Bytes())
+		if err != nil {
+			return err
+		}
+		assert.NotZero(t, item.ExpiresAt(), "the TTL should be kept")
+		v, err := item.ValueCopy(nil)
+		assert.Equal(t, "foo", string(v))
+
+		item, err = txn.Get(cp.Bytes())
+		if err != nil {
+			return err
+		}
+		v, err = item.ValueCopy(nil)
+		assert.Equal(t, FirstMessage(queueName).Bytes(), v, "the checkpoint should be migrated")
+
+		_, err = txn.Get(legacyBytes(msg))
+		assert.Equal(t, badger.ErrKeyNotFound, err)
+
+		item, err = txn.Get(dl.Bytes())
+		if err != nil {
+			return err
+		}
+		assert.NotZero(t, item.ExpiresAt(), "the dead letter retention should be kept")
+		assert.Equal(t, dl, ParseQueueKey(item.Key()))
+
+		// The erasure records are left as they are.
+		_, err = txn.Get(erasure)
+		assert.NoError(t, err)
+		return nil
+	}))
+
+	// Running it again is a noop.
+	migrated, err = MigrateKeyFormat(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+}
@@ -0,0 +1,34 @@
+package queue
+
+import (
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+)
+
+// GetMessage returns the message with the key k pending in the queue without
+// removing it. False is returned if there isn't one, e.g., because it has
+// been republished or retried under a new key.
+func GetMessage(db *badger.DB, queue string, k key.Key) (QueueItem, bool, error) {
+	var qi QueueItem
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(NewQueueKeyForMessage(queue, k).Bytes())
+		if err != nil {
+			return err
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		qi = QueueItem{K: item.KeyCopy(nil), V: v, ExpiresAt: item.ExpiresAt()}
+		return nil
+	})
+	if err == badger.ErrKeyNotFound {
+		return qi, false, nil
+	}
+	if err != nil {
+		return qi, false, fmt.Errorf("get message: %w", err)
+	}
+	return qi, true, nil
+}
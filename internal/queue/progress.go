@@ -0,0 +1,16 @@
+package queue
+
+// progressEvery is how many keys a migration goes through between reports of
+// its progress.
+const progressEvery = 1000
+
+// Progress is told how much of a long running operation is done, out of a
+// total that is 0 when it isn't known. It's called from the goroutine running
+// the operation so it shouldn't block.
+type Progress func(done, total int)
+
+func (p Progress) report(done, total int) {
+	if p != nil {
+		p(done, total)
+	}
+}
@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+)
+
+// Quarantine stores a record of a message that was rejected at ingest for the
+// queue. Any TTL less than or equal to zero will be ignored.
+func Quarantine(db *badger.DB, queue string, k key.Key, record []byte, ttl time.Duration) error {
+	entry := badger.NewEntry(NewQueueKeyForQuarantine(queue, k).Bytes(), record)
+	if ttl > 0 {
+		entry = entry.WithTTL(ttl)
+	}
+	if err := db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(entry)
+	}); err != nil {
+		return fmt.Errorf("quarantine: %w", err)
+	}
+	return nil
+}
+
+// RangeQuarantine calls f, in order, with the records of the messages
+// quarantined for the queue. If f returns false, range stops the iteration.
+func RangeQuarantine(db *badger.DB, queue string, f func(QueueItem) bool) error {
+	return rangePrefix(db, NewQueueKeyForQuarantine(queue, nil).NamePrefixBytes(), f)
+}
+
+// rangePrefix calls f, in order, with the items under the prefix. If f returns
+// false, range stops the iteration.
+func rangePrefix(db *badger.DB, prefix []byte, f func(QueueItem) bool) error {
+	return db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			if item.IsDeletedOrExpired() {
+				continue
+			}
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if !f(QueueItem{K: item.KeyCopy(nil), V: value, ExpiresAt: item.ExpiresAt()}) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
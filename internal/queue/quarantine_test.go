@@ -0,0 +1,39 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantine(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	q, err := NewQueue(db, "orders")
+	assert.NoError(t, err)
+	defer q.Close()
+
+	assert.NoError(t, Quarantine(db, "orders", key.New(time.Now()), []byte("bad"), time.Hour))
+
+	// Quarantined messages are never seen by the republisher.
+	_, err = q.ReadFromCheckpoint(time.Now().Add(time.Hour), func(qi QueueItem) bool {
+		t.Errorf("unexpected message: %s", qi.V)
+		return true
+	})
+	assert.NoError(t, err)
+
+	var records []string
+	assert.NoError(t, RangeQuarantine(db, "orders", func(qi QueueItem) bool {
+		assert.Equal(t, QuarantineBucket, ParseQueueKey(qi.K).Bucket)
+		records = append(records, string(qi.V))
+		return true
+	}))
+	assert.Equal(t, []string{"bad"}, records)
+}
@@ -2,6 +2,7 @@ package queue
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -10,6 +11,7 @@ import (
 	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
 	"github.com/nickpoorman/nats-requeue/internal/debug"
 	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
 	"github.com/rs/zerolog/log"
 )
 
@@ -27,6 +29,76 @@ func (c Checkpoint) Key() key.Key {
 	return c.Bytes()
 }
 
+// DefaultBatchInterval is how long messages added to a queue are batched
+// before they're committed.
+const DefaultBatchInterval = 15 * time.Millisecond
+
+// QueueOptions can be used to set custom options for a Queue.
+type QueueOptions struct {
+	batchInterval time.Duration
+	batchMaxSize  int
+	ctx           context.Context
+	commitCB      func(protocol.BatchCommit)
+}
+
+func QueueOptionsDefault() QueueOptions {
+	return QueueOptions{
+		batchInterval: DefaultBatchInterval,
+		ctx:           context.Background(),
+	}
+}
+
+// QueueOption is a function on the options for a Queue.
+type QueueOption func(*QueueOptions) error
+
+// Context stops the queue from accepting messages once ctx is done. The
+// messages waiting to be committed are flushed first.
+func Context(ctx context.Context) QueueOption {
+	return func(o *QueueOptions) error {
+		if ctx == nil {
+			return fmt.Errorf("context cannot be nil")
+		}
+		o.ctx = ctx
+		return nil
+	}
+}
+
+// BatchInterval sets how long messages added to the queue are batched before
+// they're committed. Longer intervals make for bigger, more efficient
+// commits at the cost of latency.
+func BatchInterval(interval time.Duration) QueueOption {
+	return func(o *QueueOptions) error {
+		if interval <= 0 {
+			return fmt.Errorf("batch interval must be positive: %s", interval)
+		}
+		o.batchInterval = interval
+		return nil
+	}
+}
+
+// BatchMaxSize commits the messages added to the queue as soon as n writes are
+// waiting rather than once the batch interval is up. Zero doesn't bound the
+// size of a batch.
+func BatchMaxSize(n int) QueueOption {
+	return func(o *QueueOptions) error {
+		if n < 0 {
+			return fmt.Errorf("batch max size cannot be negative: %d", n)
+		}
+		o.batchMaxSize = n
+		return nil
+	}
+}
+
+// CommitHandler sets a callback that will be triggered with every batch of
+// messages committed to the queue. It's called with the batch writer locked so
+// it must not block.
+func CommitHandler(cb func(protocol.BatchCommit)) QueueOption {
+	return func(o *QueueOptions) error {
+		o.commitCB = cb
+		return nil
+	}
+}
+
 type Queue struct {
 	quit        chan struct{}
 	done        chan struct{}
@@ -37,23 +109,54 @@ type Queue struct {
 	name       string
 	checkpoint Checkpoint
 	Stats      *QueueStats
+
+	// The number of times the queue has been swept for expired messages.
+	sweeps uint64
 }
 
-func NewQueue(db *badger.DB, name string) (*Queue, error) {
+func NewQueue(db *badger.DB, name string, options ...QueueOption) (*Queue, error) {
 	if name == "" {
 		return nil, fmt.Errorf("new queue: queue name cannot be empty")
 	}
 
+	opts := QueueOptionsDefault()
+	for _, opt := range options {
+		if opt != nil {
+			if err := opt(&opts); err != nil {
+				return nil, fmt.Errorf("new queue: %w", err)
+			}
+		}
+	}
+
 	qStats, err := NewQueueStats(db, name)
 	if err != nil {
 		return nil, fmt.Errorf("new queue: %w", err)
 	}
 
+	batchWriter := badgerInternal.NewBatchedWriterContext(opts.ctx, db, opts.batchInterval)
+	batchWriter.SetMaxSize(opts.batchMaxSize)
+	batchWriter.SetCommitHandler(func(c badgerInternal.Commit) {
+		qStats.RecordCommit(c.Entries, c.Bytes, c.Duration)
+		if opts.commitCB != nil {
+			bc := protocol.BatchCommit{
+				Queue:    name,
+				Entries:  c.Entries,
+				Bytes:    c.Bytes,
+				Duration: c.Duration,
+				Time:     time.Now(),
+			}
+			if c.Err != nil {
+				bc.Error = c.Err.Error()
+			}
+			opts.commitCB(bc)
+		}
+	})
+
 	q := &Queue{
 		quit:        make(chan struct{}),
 		done:        make(chan struct{}),
 		db:          db,
-		batchWriter: badgerInternal.NewBatchedWriter(db, 15*time.Millisecond),
+		batchWriter: batchWriter,
 		name:        name,
 		checkpoint:  FirstMessage(name).Bytes(), // set to the min possible value
 		Stats:       qStats,
@@ -75,9 +178,9 @@ func NewQueue(db *badger.DB, name string) (*Queue, error) {
 }
 
 // TODO: Combine this with NewQueue
-func createQueue(db *badger.DB, name string) (*Queue, error) {
+func createQueue(db *badger.DB, name string, options ...QueueOption) (*Queue, error) {
 	// Create the queue and persist it.
-	q, err := NewQueue(db, name)
+	q, err := NewQueue(db, name, options...)
 	if err != nil {
 		return nil, fmt.Errorf("create queue: %w", err)
 	}
@@ -86,10 +189,7 @@ func createQueue(db *badger.DB, name string) (*Queue, error) {
 	// Save the queue state to disk
 	if err := q.db.Update(func(txn *badger.Txn) error {
 		// Save the checkpoint for the queue
-		if err := txn.Set(
-			NewQueueKeyForState(q.name, CheckpointProperty).Bytes(),
-			q.checkpoint,
-		); err != nil {
+		if err := txn.SetEntry(stateEntry(q.name, CheckpointProperty, q.checkpoint)); err != nil {
 			return err
 		}
 
@@ -107,6 +207,15 @@ func (q *Queue) Close() {
 	<-q.done
 }
 
+// Flush commits the messages waiting to be batched now.
+func (q *Queue) Flush() {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.batchWriter != nil {
+		q.batchWriter.Flush()
+	}
+}
+
 func (q *Queue) Name() string {
 	return q.name
 }
@@ -138,6 +247,13 @@ func (q *Queue) UpdateCheckpointCond(checkpoint Checkpoint, cond func(Checkpoint
 	return q.updateCheckpoint(checkpoint)
 }
 
+// ResetCheckpoint moves the checkpoint back to the start of the queue so every
+// message in it is read again, rather than resuming from where reading left
+// off.
+func (q *Queue) ResetCheckpoint() error {
+	return q.UpdateCheckpoint(FirstMessage(q.name).Bytes())
+}
+
 // This must be called with a lock acquired.
 func (q *Queue) updateCheckpoint(checkpoint Checkpoint) error {
 	if err := q.saveCheckpoint(checkpoint); err != nil {
@@ -159,10 +275,7 @@ func (q *Queue) setCheckpoint(checkpoint Checkpoint) {
 func (q *Queue) saveCheckpoint(checkpoint Checkpoint) error {
 	// Save it to disk.
 	return q.db.Update(func(txn *badger.Txn) error {
-		return txn.Set(
-			NewQueueKeyForState(q.name, CheckpointProperty).Bytes(),
-			checkpoint,
-		)
+		return txn.SetEntry(stateEntry(q.name, CheckpointProperty, checkpoint))
 	})
 }
 
@@ -172,9 +285,13 @@ func (q *Queue) SetKV(qk QueueKey, v []byte) error {
 
 	switch qk.PropertyString() {
 	case CheckpointProperty: // queues.high.checkpoint
+		// Resuming from a key outside the queue would skip its messages.
+		if cp := ParseQueueKey(v); cp.Bucket != MessagesBucket || cp.Name != q.name {
+			return fmt.Errorf("queue: SetKV: invalid checkpoint: %q", v)
+		}
 		q.checkpoint = v
 	default:
-		err := fmt.Errorf("queue: SetKV: unknown property: %s", string(qk.Property))
+		err := fmt.Errorf("queue: SetKV: %w: %s", UnknownPropertyError, string(qk.Property))
 		log.Debug().Msgf(err.Error())
 		return err
 	}
@@ -264,6 +381,41 @@ func (q *Queue) ReadFromCheckpoint(until time.Time, f func(QueueItem) bool) (Che
 	return q.Range(ParseQueueKey(checkpoint), untilQK, f)
 }
 
+// NextReadyAt returns the time the first message from the checkpoint on
+// becomes ready. Messages are keyed by the time they become ready so this only
+// has to look at one key. False is returned if there are no messages.
+func (q *Queue) NextReadyAt() (time.Time, bool, error) {
+	q.mu.RLock()
+	name := q.name
+	checkpoint := q.checkpoint
+	q.mu.RUnlock()
+
+	var next time.Time
+	var ok bool
+	prefix := PrefixOf(FirstMessage(name).Bytes(), LastMessage(name).Bytes())
+	err := q.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(checkpoint); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			if item.IsDeletedOrExpired() {
+				continue
+			}
+			next, ok = ParseQueueKey(item.Key()).Time(), true
+			return nil
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("next ready at: %s: %w", name, err)
+	}
+	return next, ok, nil
+}
+
 // EarliestCheckpoint will return the earliest Checkpoint up until the specified time.
 // It does this by looking for the earliest message that has not been deleted.
 func (q *Queue) EarliestCheckpoint(until time.Time) (Checkpoint, error) {
@@ -305,6 +457,9 @@ func (q *Queue) AddMessage(key []byte, value []byte, ttl time.Duration, cb func(
 	if err := q.batchWriter.SetEntry(entry, func(e error) {
 		// Update the stats.
 		q.Stats.AddCount(1)
+		if e == nil {
+			q.Stats.AddEnqueued(1)
+		}
 		// Exec the callback.
 		if cb != nil {
 			cb(e)
@@ -314,5 +469,15 @@ func (q *Queue) AddMessage(key []byte, value []byte, ttl time.Duration, cb func(
 		log.Err(err).Msg("problem calling SetEntry")
 		return err
 	}
+	// Failing to index the message only delays its expiry until the next
+	// full sweep.
+	idx, err := ExpiryIndexEntry(entry)
+	if err != nil {
+		log.Err(err).Msg("add message: problem indexing expiry")
+	} else if idx != nil {
+		if err := q.batchWriter.SetEntry(idx, nil); err != nil {
+			log.Err(err).Msg("add message: problem indexing expiry")
+		}
+	}
 	return nil
 }
@@ -2,12 +2,17 @@ package queue
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/dgraph-io/badger/v2"
 )
 
 var (
 	DifferentQueueNameError = errors.New("different queue name")
+
+	// UnknownPropertyError is returned by Queue.SetKV for a property this
+	// version doesn't know about.
+	UnknownPropertyError = errors.New("unknown property")
 )
 
 type kv struct {
@@ -26,14 +31,25 @@ func NewQueueBuilder() *QueueBuilder {
 	return &QueueBuilder{}
 }
 
-// Build will create a new Queue and then call Reset so that this builder may be
-// resued.
-func (q *QueueBuilder) Build(db *badger.DB) (*Queue, error) {
-	newQ, err := NewQueue(db, q.name)
+// Build will create a new Queue from the state that was set, so it resumes from
+// its saved checkpoint, and then call Reset so that this builder may be resued.
+func (q *QueueBuilder) Build(db *badger.DB, options ...QueueOption) (*Queue, error) {
+	newQ, err := NewQueue(db, q.name, options...)
 	if err != nil {
 		return nil, err
 	}
-	q.Reset()
+	defer q.Reset()
+	for _, kv := range q.kvs {
+		if err := newQ.SetKV(kv.k, kv.v); err != nil {
+			// A property this version doesn't know about is left on disk as
+			// it is rather than failing to load the queue.
+			if errors.Is(err, UnknownPropertyError) {
+				continue
+			}
+			newQ.Close()
+			return nil, fmt.Errorf("queue: build %s: %w", q.name, err)
+		}
+	}
 	return newQ, nil
 }
 
@@ -41,7 +57,7 @@ func (q *QueueBuilder) Build(db *badger.DB) (*Queue, error) {
 // the key passed in does not match the existing queue.
 func (q *QueueBuilder) Set(key, value []byte) error {
 	qk := ParseQueueKey(key)
-	if q.name != "" {
+	if q.name == "" {
 		// Set the name
 		q.name = qk.Name
 	} else if q.name != qk.Name {
@@ -1,6 +1,11 @@
 package queue
 
-import "time"
+import (
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+)
 
 type QueueItem struct {
 	// K is the key of the item.
@@ -10,13 +15,13 @@ type QueueItem struct {
 	V []byte
 
 	// ExpiresAt is a Unix time, the number of seconds elapsed
-	// since January 1, 1970 UTC.
+	// since January 1, 1970 UTC. Zero if the item has no storage TTL.
 	ExpiresAt uint64
 }
 
-// IsExpired returns true if this item has expired.
+// IsExpired returns true if this item has a storage TTL that has expired.
 func (qi QueueItem) IsExpired() bool {
-	return qi.ExpiresAt <= uint64(time.Now().Unix())
+	return qi.ExpiresAt != 0 && qi.ExpiresAt <= uint64(time.Now().Unix())
 }
 
 // ExpiresAtTime returns the Time this item will expire.
@@ -29,3 +34,51 @@ func (qi QueueItem) ExpiresAtTime() time.Time {
 func (qi QueueItem) DurationUntilExpires() time.Duration {
 	return time.Until(qi.ExpiresAtTime())
 }
+
+// ReadyAt returns the time the item became ready to be republished, which is
+// the time encoded in its key.
+func (qi QueueItem) ReadyAt() time.Time {
+	return ParseQueueKey(qi.K).Time()
+}
+
+// EnqueuedAt returns the time the message fb held by the item was enqueued.
+// The key holds the time the message became ready so this takes off the delay.
+func (qi QueueItem) EnqueuedAt(fb *flatbuf.RequeueMessage) time.Time {
+	return qi.ReadyAt().Add(-time.Duration(fb.Delay()))
+}
+
+// FirstEnqueuedAt returns the time the message fb held by the item was first
+// enqueued, before it was retried.
+func (qi QueueItem) FirstEnqueuedAt(fb *flatbuf.RequeueMessage) time.Time {
+	if t := fb.EnqueuedAt(); t != 0 {
+		return time.Unix(0, t)
+	}
+	return qi.EnqueuedAt(fb)
+}
+
+// MessageID returns the id of the message fb held by the item, which is the
+// readable form of the key it was first stored under. It's empty if the item
+// doesn't have a message key.
+func (qi QueueItem) MessageID(fb *flatbuf.RequeueMessage) string {
+	if id := fb.MessageId(); len(id) > 0 {
+		return string(id)
+	}
+	qk := ParseQueueKey(qi.K)
+	if !qk.IsKey() || len(qk.Key) != key.Size {
+		return ""
+	}
+	return key.Key(qk.Key).String()
+}
+
+// Expiry returns the time the message fb held by the item expires. The TTL of
+// a message counts from when it was enqueued. False is returned if the
+// message never expires.
+func (qi QueueItem) Expiry(fb *flatbuf.RequeueMessage) (time.Time, bool) {
+	if ttl := fb.Ttl(); ttl > 0 {
+		return qi.EnqueuedAt(fb).Add(time.Duration(ttl)), true
+	}
+	if qi.ExpiresAt != 0 {
+		return qi.ExpiresAtTime(), true
+	}
+	return time.Time{}, false
+}
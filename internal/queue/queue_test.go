@@ -9,6 +9,7 @@ import (
 
 	badger "github.com/dgraph-io/badger/v2"
 	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -43,6 +44,14 @@ func TestNewQueue(t *testing.T) {
 	q, err := NewQueue(db, queueName)
 	assert.NoError(t, err)
 	assert.Equal(t, queueName, q.name, "Queue names should be equal")
+	q.Close()
+
+	q, err = NewQueue(db, queueName, BatchInterval(time.Millisecond))
+	assert.NoError(t, err)
+	q.Close()
+
+	_, err = NewQueue(db, queueName, BatchInterval(0))
+	assert.Error(t, err)
 }
 
 func TestEarliestCheckpoint(t *testing.T) {
@@ -90,3 +99,130 @@ func TestEarliestCheckpoint(t *testing.T) {
 	}
 	assert.Equal(t, keys[0].PropertyPath(), ParseQueueKey(earliest).PropertyPath(), "they should be equal")
 }
+
+func TestNextReadyAt(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	q, err := createQueue(db, "orders")
+	assert.NoError(t, err)
+	defer q.Close()
+
+	_, ok, err := q.NextReadyAt()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	now := time.Now().Truncate(time.Second)
+	keys := []QueueKey{
+		NewQueueKeyForMessage("orders", key.New(now.Add(time.Minute))),
+		NewQueueKeyForMessage("orders", key.New(now.Add(time.Hour))),
+	}
+	for _, qk := range keys {
+		assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+			return txn.Set(qk.Bytes(), []byte("message"))
+		}))
+	}
+
+	next, ok, err := q.NextReadyAt()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, now.Add(time.Minute).Equal(next))
+
+	// Only messages from the checkpoint on are considered.
+	assert.NoError(t, q.UpdateCheckpoint(keys[1].Bytes()))
+	next, ok, err = q.NextReadyAt()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, now.Add(time.Hour).Equal(next))
+}
+
+func TestCheckpointResume(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m, err := NewManager(db)
+	assert.NoError(t, err)
+	q, err := m.CreateQueue(NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+	cp := NewQueueKeyForMessage("orders", key.New(time.Now())).Bytes()
+	assert.NoError(t, q.UpdateCheckpoint(cp))
+	m.Close()
+
+	// A restarted instance resumes from the saved checkpoint.
+	m, err = NewManager(db)
+	assert.NoError(t, err)
+	q, ok := m.GetQueue("orders")
+	assert.True(t, ok)
+	assert.Equal(t, 0, q.CompareCheckpoint(cp))
+
+	// Unless it's reset.
+	assert.NoError(t, q.ResetCheckpoint())
+	m.Close()
+	m, err = NewManager(db)
+	assert.NoError(t, err)
+	q, ok = m.GetQueue("orders")
+	assert.True(t, ok)
+	assert.Equal(t, 0, q.CompareCheckpoint(FirstMessage("orders").Bytes()))
+	m.Close()
+
+	// A property this version doesn't know about is left alone.
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(stateEntry("orders", "future", []byte("x")))
+	}))
+	m, err = NewManager(db)
+	assert.NoError(t, err)
+	_, ok = m.GetQueue("orders")
+	assert.True(t, ok)
+	m.Close()
+
+	// But a checkpoint outside the queue fails the load rather than skipping
+	// its messages.
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		cp := NewQueueKeyForMessage("payments", key.New(time.Now())).Bytes()
+		return txn.SetEntry(stateEntry("orders", CheckpointProperty, cp))
+	}))
+	_, err = NewManager(db)
+	assert.Error(t, err)
+}
+
+func TestQueueCommitHandler(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	commits := make(chan protocol.BatchCommit, 1)
+	q, err := NewQueue(db, "orders", BatchInterval(time.Hour), CommitHandler(func(bc protocol.BatchCommit) {
+		commits <- bc
+	}))
+	assert.NoError(t, err)
+	defer q.Close()
+
+	m := protocol.DefaultRequeueMessage()
+	m.OriginalSubject = "orders.created"
+	k := NewQueueKeyForMessage("orders", key.New(time.Now())).Bytes()
+	committed := make(chan error, 1)
+	assert.NoError(t, q.AddMessage(k, m.Bytes(), 0, func(err error) {
+		committed <- err
+	}))
+	q.Flush()
+	assert.NoError(t, <-committed)
+
+	bc := <-commits
+	assert.Equal(t, "orders", bc.Queue)
+	assert.Equal(t, 1, bc.Entries)
+	assert.Equal(t, len(k)+len(m.Bytes()), bc.Bytes)
+	assert.Empty(t, bc.Error)
+
+	stats := q.Stats.QueueStatsMessage().BatchCommits
+	assert.Equal(t, int64(1), stats.Commits)
+	assert.Equal(t, int64(1), stats.Entries)
+	assert.Equal(t, int64(bc.Bytes), stats.Bytes)
+}
@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+)
+
+// KeyRotationKey is where the progress of a key rotation is persisted so it
+// can resume where it left off.
+var KeyRotationKey = []byte(QueuesNamespace + sep + "_k")
+
+// maxReencryptConflicts is how many times a batch is retried when it conflicts
+// with the republisher before giving up.
+const maxReencryptConflicts = 10
+
+// ReencryptFunc returns the value of a message re-encrypted. It returns false
+// if the message is already encrypted the way it should be.
+type ReencryptFunc func(v []byte) ([]byte, bool, error)
+
+// ReencryptResult is the result of re-encrypting a batch of messages.
+type ReencryptResult struct {
+	// Last is the key of the last message in the batch, or nil if there were
+	// no messages left in the queue.
+	Last key.Key
+	// Scanned is the number of messages in the batch.
+	Scanned int
+	// Reencrypted is the number of messages that were rewritten.
+	Reencrypted int
+	// Failed is the number of messages f returned an error for. They are left
+	// as they are.
+	Failed int
+	// Err is the last error returned by f, if any.
+	Err error
+}
+
+// ReencryptMessages rewrites up to n messages in the queue with f, in key
+// order, starting after the message with the key after or from the first
+// message when it's nil. Their keys, and TTLs in the store, are kept. The
+// batch is written in a single transaction so it's retried if the republisher
+// changes any of its messages at the same time.
+func ReencryptMessages(db *badger.DB, queue string, after key.Key, n int, f ReencryptFunc) (ReencryptResult, error) {
+	var res ReencryptResult
+	var err error
+	for i := 0; i < maxReencryptConflicts; i++ {
+		res, err = reencryptBatch(db, queue, after, n, f)
+		if !errors.Is(err, badger.ErrConflict) {
+			break
+		}
+	}
+	if err != nil {
+		return ReencryptResult{}, fmt.Errorf("reencrypt messages: %s: %w", queue, err)
+	}
+	return res, nil
+}
+
+func reencryptBatch(db *badger.DB, queue string, after key.Key, n int, f ReencryptFunc) (ReencryptResult, error) {
+	var res ReencryptResult
+	err := db.Update(func(txn *badger.Txn) error {
+		entries, err := reencryptEntries(txn, queue, after, n, f, &res)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := txn.SetEntry(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// reencryptEntries reads the batch and returns the entries to rewrite. Reading
+// them in the transaction is what makes it conflict with concurrent changes.
+func reencryptEntries(txn *badger.Txn, queue string, after key.Key, n int, f ReencryptFunc, res *ReencryptResult) ([]*badger.Entry, error) {
+	prefix := NewQueueKeyForMessage(queue, nil).NamePrefixBytes()
+	start := prefix
+	if after != nil {
+		start = NewQueueKeyForMessage(queue, after).Bytes()
+	}
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	entries := make([]*badger.Entry, 0)
+	for it.Seek(start); it.ValidForPrefix(prefix) && res.Scanned < n; it.Next() {
+		item := it.Item()
+		if after != nil && bytes.Equal(item.Key(), start) {
+			continue
+		}
+		k := item.KeyCopy(nil)
+		res.Last = ParseQueueKey(k).Key
+		res.Scanned++
+
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		out, ok, err := f(v)
+		if err != nil {
+			res.Failed++
+			res.Err = err
+			continue
+		}
+		if !ok {
+			continue
+		}
+		e := badger.NewEntry(k, out).WithMeta(item.UserMeta())
+		e.ExpiresAt = item.ExpiresAt()
+		entries = append(entries, e)
+		res.Reencrypted++
+	}
+	return entries, nil
+}
@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReencryptMessages(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	values := []string{"a", "b", "new-c", "bad", "e"}
+	keys := make([]key.Key, len(values))
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		for i, v := range values {
+			keys[i] = key.New(now.Add(time.Duration(i) * time.Second))
+			e := badger.NewEntry(NewQueueKeyForMessage("orders", keys[i]).Bytes(), []byte(v))
+			if i == 0 {
+				e = e.WithTTL(time.Hour)
+			}
+			if err := txn.SetEntry(e); err != nil {
+				return err
+			}
+		}
+		return txn.Set(NewQueueKeyForMessage("other", keys[0]).Bytes(), []byte("x"))
+	}))
+
+	f := func(v []byte) ([]byte, bool, error) {
+		switch {
+		case bytes.HasPrefix(v, []byte("new-")):
+			return nil, false, nil
+		case bytes.Equal(v, []byte("bad")):
+			return nil, false, fmt.Errorf("no key")
+		}
+		return append([]byte("new-"), v...), true, nil
+	}
+
+	// Resuming after the last key of each batch visits every message once.
+	var after key.Key
+	var total ReencryptResult
+	for {
+		res, err := ReencryptMessages(db, "orders", after, 2, f)
+		assert.NoError(t, err)
+		if res.Last == nil {
+			break
+		}
+		after = res.Last
+		total.Scanned += res.Scanned
+		total.Reencrypted += res.Reencrypted
+		total.Failed += res.Failed
+	}
+	assert.Equal(t, 5, total.Scanned)
+	assert.Equal(t, 3, total.Reencrypted)
+	assert.Equal(t, 1, total.Failed)
+
+	assert.NoError(t, db.View(func(txn *badger.Txn) error {
+		want := []string{"new-a", "new-b", "new-c", "bad", "new-e"}
+		for i, k := range keys {
+			item, err := txn.Get(NewQueueKeyForMessage("orders", k).Bytes())
+			if !assert.NoError(t, err) {
+				continue
+			}
+			v, _ := item.ValueCopy(nil)
+			assert.Equal(t, want[i], string(v))
+			// The TTL is kept.
+			assert.Equal(t, i == 0, item.ExpiresAt() > 0)
+		}
+		item, err := txn.Get(NewQueueKeyForMessage("other", keys[0]).Bytes())
+		assert.NoError(t, err)
+		v, _ := item.ValueCopy(nil)
+		assert.Equal(t, "x", string(v))
+		return nil
+	}))
+}
@@ -0,0 +1,153 @@
+package queue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// RejectionKeyPrefix is the prefix of the keys the counts of the messages
+// rejected at ingest are stored under. They're ordered by the hour they were
+// rejected in, then by original subject and reason.
+var RejectionKeyPrefix = []byte(QueuesNamespace + sep + "_j" + sep)
+
+// maxRejectionConflicts is how many times adding rejections is retried when it
+// conflicts with another instance of the store adding them.
+const maxRejectionConflicts = 10
+
+// Rejections are the messages of an original subject rejected at ingest for a
+// reason within an hour.
+type Rejections struct {
+	Hour    time.Time
+	Subject string
+	Reason  string
+	Count   int64
+	// The message given with the last rejection.
+	LastMessage string
+}
+
+// AddRejections adds the counts to the ones already stored for the same hour,
+// subject and reason, replacing their last message. Any retention less than or
+// equal to zero will be ignored and the counts are kept until the store is
+// removed.
+func AddRejections(db *badger.DB, rs []Rejections, retention time.Duration) error {
+	var err error
+	for i := 0; i < maxRejectionConflicts; i++ {
+		err = db.Update(func(txn *badger.Txn) error {
+			for _, r := range rs {
+				k := rejectionsKey(r.Hour, r.Subject, r.Reason)
+				count := r.Count
+				item, err := txn.Get(k)
+				switch {
+				case err == badger.ErrKeyNotFound:
+				case err != nil:
+					return err
+				default:
+					v, err := item.ValueCopy(nil)
+					if err != nil {
+						return err
+					}
+					if len(v) >= 8 {
+						count += int64(binary.BigEndian.Uint64(v))
+					}
+				}
+
+				v := make([]byte, 8+len(r.LastMessage))
+				binary.BigEndian.PutUint64(v, uint64(count))
+				copy(v[8:], r.LastMessage)
+				entry := badger.NewEntry(k, v)
+				if retention > 0 {
+					entry = entry.WithTTL(retention)
+				}
+				if err := txn.SetEntry(entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if !errors.Is(err, badger.ErrConflict) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("add rejections: %w", err)
+	}
+	return nil
+}
+
+// RangeRejections calls f with the counts of the rejections from the hour of
+// since onwards, in the order of the hour they were rejected in. If f returns
+// false, range stops the iteration.
+func RangeRejections(db *badger.DB, since time.Time, f func(Rejections) bool) error {
+	seek := make([]byte, len(RejectionKeyPrefix)+8)
+	n := copy(seek, RejectionKeyPrefix)
+	binary.BigEndian.PutUint64(seek[n:], uint64(since.Truncate(time.Hour).Unix()))
+
+	return db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = RejectionKeyPrefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(seek); it.ValidForPrefix(RejectionKeyPrefix); it.Next() {
+			item := it.Item()
+			if item.IsDeletedOrExpired() {
+				continue
+			}
+			r, ok := parseRejectionsKey(item.Key())
+			if !ok {
+				continue
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if len(v) < 8 {
+				continue
+			}
+			r.Count = int64(binary.BigEndian.Uint64(v))
+			r.LastMessage = string(v[8:])
+			if !f(r) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// rejectionsKey encodes the key of the rejections as:
+//
+// prefix | unix hour | uint16 subject length | subject | reason
+func rejectionsKey(hour time.Time, subject, reason string) []byte {
+	out := make([]byte, len(RejectionKeyPrefix)+8+nameLenSize+len(subject)+len(reason))
+	n := copy(out, RejectionKeyPrefix)
+	binary.BigEndian.PutUint64(out[n:], uint64(hour.Truncate(time.Hour).Unix()))
+	n += 8
+	binary.BigEndian.PutUint16(out[n:], uint16(len(subject)))
+	n += nameLenSize
+	n += copy(out[n:], subject)
+	copy(out[n:], reason)
+	return out
+}
+
+func parseRejectionsKey(k []byte) (Rejections, bool) {
+	rest := k[len(RejectionKeyPrefix):]
+	if len(rest) < 8+nameLenSize {
+		return Rejections{}, false
+	}
+	hour := time.Unix(int64(binary.BigEndian.Uint64(rest)), 0)
+	rest = rest[8:]
+	n := int(binary.BigEndian.Uint16(rest))
+	rest = rest[nameLenSize:]
+	if len(rest) < n {
+		return Rejections{}, false
+	}
+	return Rejections{
+		Hour:    hour,
+		Subject: string(rest[:n]),
+		Reason:  string(rest[n:]),
+	}, true
+}
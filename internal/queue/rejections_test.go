@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRejections(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	hour := time.Unix(1600000000, 0).Truncate(time.Hour)
+	assert.NoError(t, AddRejections(db, []Rejections{
+		{Hour: hour, Subject: "orders.created", Reason: "invalid_payload", Count: 2, LastMessage: "missing id"},
+		{Hour: hour, Subject: "orders.created", Reason: "quota_exceeded", Count: 1},
+		{Hour: hour.Add(time.Hour), Subject: "orders", Reason: "invalid_payload", Count: 1},
+	}, time.Hour))
+	// The counts are added to and the last message replaced.
+	assert.NoError(t, AddRejections(db, []Rejections{
+		{Hour: hour.Add(time.Minute), Subject: "orders.created", Reason: "invalid_payload", Count: 3, LastMessage: "missing name"},
+	}, 0))
+
+	collect := func(since time.Time) []Rejections {
+		var rs []Rejections
+		assert.NoError(t, RangeRejections(db, since, func(r Rejections) bool {
+			rs = append(rs, r)
+			return true
+		}))
+		return rs
+	}
+	assert.Equal(t, []Rejections{
+		{Hour: hour, Subject: "orders.created", Reason: "invalid_payload", Count: 5, LastMessage: "missing name"},
+		{Hour: hour, Subject: "orders.created", Reason: "quota_exceeded", Count: 1},
+		{Hour: hour.Add(time.Hour), Subject: "orders", Reason: "invalid_payload", Count: 1},
+	}, collect(hour.Add(-time.Hour)))
+
+	// Only the hours from that of since on are ranged over.
+	assert.Equal(t, []Rejections{
+		{Hour: hour.Add(time.Hour), Subject: "orders", Reason: "invalid_payload", Count: 1},
+	}, collect(hour.Add(90*time.Minute)))
+}
@@ -0,0 +1,187 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+)
+
+const (
+	// replayBatchSize is how many messages a replay moves in each
+	// transaction.
+	replayBatchSize = 1000
+
+	// maxReplayConflicts is how many times a batch is retried when it
+	// conflicts with the republisher before giving up.
+	maxReplayConflicts = 10
+)
+
+// ReplayMessages moves the messages of the queue with keys from from to to,
+// inclusive, that aren't ready yet at now under new keys for now, so they are
+// republished right away regardless of their delay or backoff. A nil from or
+// to leaves the range open at that end. The messages keep their order, TTLs
+// in the store, and coalescing index entries. Its progress is reported to
+// progress after each batch, out of the number of messages in the range when
+// it started. The number of messages moved is returned, even if it fails part
+// way.
+func ReplayMessages(ctx context.Context, db *badger.DB, queue string, from, to key.Key, now time.Time, progress Progress) (int, error) {
+	prefix := NewQueueKeyForMessage(queue, nil).NamePrefixBytes()
+	// Messages with keys before the next second are already ready. Moving
+	// them to keys for now puts them before start, so they aren't seen again.
+	start := key.FromTime(now.Add(time.Second))
+	if from != nil && key.Compare(from, start) > 0 {
+		start = from
+	}
+	r := replay{db: db, queue: queue, prefix: prefix, start: NewQueueKeyForMessage(queue, start).Bytes(), to: to, now: now}
+
+	var total int
+	if progress != nil {
+		var err error
+		if total, err = r.count(); err != nil {
+			return 0, fmt.Errorf("replay messages: %s: %w", queue, err)
+		}
+	}
+
+	var replayed int
+	progress.report(replayed, total)
+	for {
+		if err := ctx.Err(); err != nil {
+			return replayed, fmt.Errorf("replay messages: %s: %w", queue, err)
+		}
+		n, err := r.batch()
+		replayed += n
+		if err != nil {
+			return replayed, fmt.Errorf("replay messages: %s: %w", queue, err)
+		}
+		if n == 0 {
+			return replayed, nil
+		}
+		if replayed > total {
+			total = replayed
+		}
+		progress.report(replayed, total)
+	}
+}
+
+// replay is the range of messages of a queue being replayed.
+type replay struct {
+	db     *badger.DB
+	queue  string
+	prefix []byte
+	start  []byte
+	to     key.Key
+	now    time.Time
+}
+
+// inRange returns true if the message key k isn't past the end of the range.
+func (r replay) inRange(k []byte) bool {
+	return r.to == nil || key.Compare(ParseQueueKey(k).Key, r.to) <= 0
+}
+
+func (r replay) count() (int, error) {
+	var n int
+	err := r.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = r.prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(r.start); it.ValidForPrefix(r.prefix) && r.inRange(it.Item().Key()); it.Next() {
+			if !it.Item().IsDeletedOrExpired() {
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// batch moves the next batch of messages, retrying if it conflicts with the
+// republisher.
+func (r replay) batch() (int, error) {
+	var n int
+	var err error
+	for i := 0; i < maxReplayConflicts; i++ {
+		n = 0
+		err = r.db.Update(func(txn *badger.Txn) error {
+			moves, err := r.moves(txn)
+			if err != nil {
+				return err
+			}
+			for _, m := range moves {
+				if err := txn.SetEntry(m.entry); err != nil {
+					return err
+				}
+				// The index entry of the old key is left for the sweeper.
+				idx, err := ExpiryIndexEntry(m.entry)
+				if err != nil {
+					return err
+				}
+				if idx != nil {
+					if err := txn.SetEntry(idx); err != nil {
+						return err
+					}
+				}
+				if err := txn.Delete(m.oldKey); err != nil {
+					return err
+				}
+				if m.dedupeKey != "" {
+					if err := UpdateCoalesceIndex(txn, r.queue, m.dedupeKey, m.oldKey, m.entry.Key); err != nil {
+						return err
+					}
+				}
+			}
+			n = len(moves)
+			return nil
+		})
+		if !errors.Is(err, badger.ErrConflict) {
+			break
+		}
+	}
+	return n, err
+}
+
+// replayMove is a message to move under a new key.
+type replayMove struct {
+	oldKey    []byte
+	entry     *badger.Entry
+	dedupeKey string
+}
+
+// moves reads a batch of messages and returns where to move them. Reading
+// them in the transaction is what makes it conflict with concurrent changes.
+func (r replay) moves(txn *badger.Txn) ([]replayMove, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = r.prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	moves := make([]replayMove, 0)
+	for it.Seek(r.start); it.ValidForPrefix(r.prefix) && len(moves) < replayBatchSize; it.Next() {
+		item := it.Item()
+		if !r.inRange(item.Key()) {
+			break
+		}
+		if item.IsDeletedOrExpired() {
+			continue
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		e := badger.NewEntry(NewQueueKeyForMessage(r.queue, key.New(r.now)).Bytes(), v).WithMeta(item.UserMeta())
+		e.ExpiresAt = item.ExpiresAt()
+		m := replayMove{oldKey: item.KeyCopy(nil), entry: e}
+		if len(v) >= flatbuffers.SizeUOffsetT {
+			m.dedupeKey = string(flatbuf.GetRootAsRequeueMessage(v, 0).DedupeKey())
+		}
+		moves = append(moves, m)
+	}
+	return moves, nil
+}
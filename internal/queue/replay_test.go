@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayMessages(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	// A message that is ready, and four delayed by an hour or more.
+	keys := []key.Key{key.New(now.Add(-time.Minute))}
+	for i := 1; i <= 4; i++ {
+		keys = append(keys, key.New(now.Add(time.Duration(i)*time.Hour)))
+	}
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		for i, k := range keys {
+			m := protocol.DefaultRequeueMessage()
+			m.OriginalPayload = []byte{byte('a' + i)}
+			if i == 2 {
+				m.DedupeKey = "profile"
+			}
+			if err := txn.Set(NewQueueKeyForMessage("orders", k).Bytes(), m.Bytes()); err != nil {
+				return err
+			}
+		}
+		return txn.Set(NewQueueKeyForCoalesce("orders", "profile").Bytes(), NewQueueKeyForMessage("orders", keys[2]).Bytes())
+	}))
+
+	pending := func() (ready, delayed []string) {
+		assert.NoError(t, rangePrefix(db, NewQueueKeyForMessage("orders", nil).NamePrefixBytes(), func(qi QueueItem) bool {
+			payload := string(flatbuf.GetRootAsRequeueMessage(qi.V, 0).OriginalPayloadBytes())
+			if qi.ReadyAt().After(now) {
+				delayed = append(delayed, payload)
+			} else {
+				ready = append(ready, payload)
+			}
+			return true
+		}))
+		return ready, delayed
+	}
+
+	// Only the delayed messages in the range are moved.
+	var reported [][2]int
+	n, err := ReplayMessages(context.Background(), db, "orders", keys[0], keys[2], now, func(done, total int) {
+		reported = append(reported, [2]int{done, total})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, [][2]int{{0, 2}, {2, 2}}, reported)
+	ready, delayed := pending()
+	assert.Equal(t, []string{"a", "b", "c"}, ready)
+	assert.Equal(t, []string{"d", "e"}, delayed)
+
+	// The coalescing index follows the message to its new key.
+	assert.NoError(t, db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(NewQueueKeyForCoalesce("orders", "profile").Bytes())
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		v, err := item.ValueCopy(nil)
+		assert.NoError(t, err)
+		assert.NotEqual(t, NewQueueKeyForMessage("orders", keys[2]).Bytes(), v)
+		_, err = txn.Get(v)
+		assert.NoError(t, err)
+		return nil
+	}))
+
+	n, err = ReplayMessages(context.Background(), db, "orders", nil, nil, now, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	ready, delayed = pending()
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, ready)
+	assert.Empty(t, delayed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ReplayMessages(ctx, db, "orders", nil, nil, now, nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
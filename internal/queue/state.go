@@ -0,0 +1,126 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// maxCompactStateConflicts is how many times compacting the state is retried
+// when it conflicts with another write before giving up.
+const maxCompactStateConflicts = 10
+
+// stateEntry returns the entry that sets the state property of the queue.
+// Only the latest version of a state property is ever read, so the earlier
+// versions are discarded when the store is compacted no matter how many
+// versions it's configured to keep.
+func stateEntry(queue, property string, v []byte) *badger.Entry {
+	return badger.NewEntry(NewQueueKeyForState(queue, property).Bytes(), v).WithDiscard()
+}
+
+// CompactState rewrites the state properties that were written without
+// discarding their earlier versions, e.g., by a previous version, so those
+// are discarded the next time the store is compacted too. The number of
+// properties rewritten is returned.
+func CompactState(db *badger.DB) (int, error) {
+	var n int
+	var err error
+	for i := 0; i < maxCompactStateConflicts; i++ {
+		n = 0
+		err = db.Update(func(txn *badger.Txn) error {
+			entries, err := compactStateEntries(txn)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if err := txn.SetEntry(e); err != nil {
+					return err
+				}
+			}
+			n = len(entries)
+			return nil
+		})
+		if !errors.Is(err, badger.ErrConflict) {
+			break
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("compact state: %w", err)
+	}
+	return n, nil
+}
+
+func compactStateEntries(txn *badger.Txn) ([]*badger.Entry, error) {
+	prefix := QueueKey{Namespace: QueuesNamespace, Bucket: StateBucket}.BucketPrefixBytes()
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	entries := make([]*badger.Entry, 0)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		if item.IsDeletedOrExpired() || item.DiscardEarlierVersions() {
+			continue
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, badger.NewEntry(item.KeyCopy(nil), v).WithDiscard())
+	}
+	return entries, nil
+}
+
+// StateSize is how much of the state bucket a queue takes up.
+type StateSize struct {
+	Queue string
+	// The number of state properties of the queue.
+	Properties int64
+	// The number of versions of the properties still in the store, including
+	// the ones that have been overwritten or deleted but not yet compacted
+	// away.
+	Versions int64
+	// The estimated size of every version.
+	Bytes int64
+}
+
+// StateSizes returns how much of the state bucket each queue takes up, in
+// queue name order.
+func StateSizes(db *badger.DB) ([]StateSize, error) {
+	sizes := make([]StateSize, 0)
+	err := db.View(func(txn *badger.Txn) error {
+		prefix := QueueKey{Namespace: QueuesNamespace, Bucket: StateBucket}.BucketPrefixBytes()
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		opts.PrefetchValues = false
+		opts.AllVersions = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		var last []byte
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			qk := ParseQueueKey(item.Key())
+			if len(sizes) == 0 || sizes[len(sizes)-1].Queue != qk.Name {
+				sizes = append(sizes, StateSize{Queue: qk.Name})
+			}
+			s := &sizes[len(sizes)-1]
+			s.Versions++
+			s.Bytes += item.EstimatedSize()
+			// The versions of a key are iterated newest first.
+			if string(last) != string(item.Key()) {
+				last = item.KeyCopy(last[:0])
+				if !item.IsDeletedOrExpired() {
+					s.Properties++
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("state sizes: %w", err)
+	}
+	return sizes, nil
+}
@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactState(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Checkpoints written the way a previous version did.
+	cp := NewQueueKeyForState("orders", CheckpointProperty).Bytes()
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+			return txn.Set(cp, FirstMessage("orders").Bytes())
+		}))
+	}
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(stateEntry("emails", CheckpointProperty, FirstMessage("emails").Bytes()))
+	}))
+
+	sizes, err := StateSizes(db)
+	assert.NoError(t, err)
+	if assert.Len(t, sizes, 2) {
+		assert.Equal(t, "emails", sizes[0].Queue)
+		assert.Equal(t, int64(1), sizes[0].Properties)
+		assert.Equal(t, int64(1), sizes[0].Versions)
+		assert.Equal(t, "orders", sizes[1].Queue)
+		assert.Equal(t, int64(1), sizes[1].Properties)
+		assert.Equal(t, int64(3), sizes[1].Versions)
+		assert.True(t, sizes[1].Bytes > 0)
+	}
+
+	// Only the checkpoint written without discarding its earlier versions is
+	// rewritten, and only once.
+	n, err := CompactState(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = CompactState(db)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	assert.NoError(t, db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(cp)
+		if err != nil {
+			return err
+		}
+		assert.True(t, item.DiscardEarlierVersions())
+		v, err := item.ValueCopy(nil)
+		assert.Equal(t, FirstMessage("orders").Bytes(), v)
+		return err
+	}))
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/histogram"
 	"github.com/nickpoorman/nats-requeue/internal/ticker"
 	"github.com/nickpoorman/nats-requeue/protocol"
 	"github.com/rs/zerolog/log"
@@ -16,6 +18,15 @@ const (
 	DefaultStatsRefreshInterval = 60 * time.Second
 )
 
+// DefaultAgeBuckets are the max ages of the buckets of the message age
+// histogram. Anything older falls into a final unbounded bucket.
+var DefaultAgeBuckets = []time.Duration{
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	24 * time.Hour,
+}
+
 type QueueStatsOptions struct {
 	refreshInterval time.Duration
 }
@@ -54,6 +65,31 @@ type QueueStats struct {
 
 	// This should always be consistent.
 	inFlight int64
+
+	// The number of messages removed because their TTL elapsed.
+	expired int64
+
+	// The number of messages enqueued since the stats were created, and the
+	// rate they were enqueued at between the last two refreshes.
+	enqueuedTotal     int64
+	enqueueRate       float64
+	lastRefresh       time.Time
+	lastEnqueuedTotal int64
+
+	// When the oldest message was first enqueued as of the last refresh.
+	oldestEnqueuedAt time.Time
+
+	persistLatency   *histogram.Histogram
+	republishLatency *histogram.Histogram
+
+	// The batches of messages committed to the queue.
+	commits       int64
+	commitEntries int64
+	commitBytes   int64
+	commitLatency *histogram.Histogram
+
+	// The age of the messages as of the last refresh.
+	age protocol.AgeHistogram
 }
 
 func NewQueueStats(db *badger.DB, queueName string, options ...QueueStatsOption) (*QueueStats, error) {
@@ -71,26 +107,32 @@ func NewQueueStats(db *badger.DB, queueName string, options ...QueueStatsOption)
 	}
 
 	qs := &QueueStats{
-		quit:      make(chan struct{}),
-		opts:      opts,
-		db:        db,
-		queueName: queueName,
+		quit:             make(chan struct{}),
+		opts:             opts,
+		db:               db,
+		queueName:        queueName,
+		persistLatency:   histogram.New(),
+		republishLatency: histogram.New(),
+		commitLatency:    histogram.New(),
 	}
 
-	go func() { _ = qs.refreshStats() }() // Refresh stats now.
-	go qs.initBackgroundTasks()
+	qs.initBackgroundTasks()
 	return qs, nil
 }
 
 func (qs *QueueStats) initBackgroundTasks() {
-	qs.mu.Lock()
-	defer qs.mu.Unlock()
-
 	qs.doneWg.Add(1)
 
 	// Stats refresh
 	go func() {
 		defer qs.doneWg.Done()
+		// Refresh stats now.
+		select {
+		case <-qs.quit:
+			return
+		default:
+			_ = qs.refreshStats()
+		}
 		t := ticker.New(qs.opts.refreshInterval)
 		go func() {
 			<-qs.quit
@@ -105,9 +147,9 @@ func (qs *QueueStats) initBackgroundTasks() {
 }
 
 // Close will stop the QueueStats background tasks.
+// It must not hold the lock while waiting since a refresh may need it to
+// finish.
 func (qs *QueueStats) Close() {
-	qs.mu.Lock()
-	defer qs.mu.Unlock()
 	close(qs.quit)
 	qs.doneWg.Wait()
 }
@@ -116,10 +158,68 @@ func (qs *QueueStats) AddCount(num int64) {
 	atomic.AddInt64(&qs.count, num)
 }
 
+// Count returns the number of messages in the queue. It's eventually
+// consistent since messages can expire from the TTL.
+func (qs *QueueStats) Count() int64 {
+	count := atomic.LoadInt64(&qs.count)
+	if count < 0 {
+		return 0
+	}
+	return count
+}
+
 func (qs *QueueStats) AddInFlight(num int64) {
 	atomic.AddInt64(&qs.inFlight, num)
 }
 
+// AddExpired counts messages removed because their TTL elapsed.
+func (qs *QueueStats) AddExpired(num int64) {
+	atomic.AddInt64(&qs.expired, num)
+}
+
+// Expired returns the number of messages removed because their TTL elapsed.
+func (qs *QueueStats) Expired() int64 {
+	return atomic.LoadInt64(&qs.expired)
+}
+
+// AddEnqueued counts messages enqueued.
+func (qs *QueueStats) AddEnqueued(num int64) {
+	atomic.AddInt64(&qs.enqueuedTotal, num)
+}
+
+// EnqueuedTotal returns the number of messages enqueued since the stats were
+// created.
+func (qs *QueueStats) EnqueuedTotal() int64 {
+	return atomic.LoadInt64(&qs.enqueuedTotal)
+}
+
+// RecordPersistLatency records the time it took from receiving a message to
+// acknowledging it was persisted.
+func (qs *QueueStats) RecordPersistLatency(d time.Duration) {
+	qs.persistLatency.Record(d)
+}
+
+// RecordRepublishLatency records the time it took from a message being
+// enqueued to it being successfully republished.
+func (qs *QueueStats) RecordRepublishLatency(d time.Duration) {
+	qs.republishLatency.Record(d)
+}
+
+// RecordCommit counts a batch of entries, of the total bytes, that took d to
+// be committed to the queue.
+func (qs *QueueStats) RecordCommit(entries, bytes int, d time.Duration) {
+	atomic.AddInt64(&qs.commits, 1)
+	atomic.AddInt64(&qs.commitEntries, int64(entries))
+	atomic.AddInt64(&qs.commitBytes, int64(bytes))
+	qs.commitLatency.Record(d)
+}
+
+// Refresh recounts the messages in the queue now rather than on the next
+// scheduled refresh, e.g., after many were removed at once.
+func (qs *QueueStats) Refresh() error {
+	return qs.refreshStats()
+}
+
 func (qs *QueueStats) refreshStats() error {
 	// Lock so that we don't ever end up running two refreshes at once for this
 	// queue.
@@ -132,6 +232,10 @@ func (qs *QueueStats) refreshStats() error {
 	prefix := PrefixOf(seek.Bytes(), until.Bytes())
 
 	var count int64
+	now := time.Now()
+	enqueuedTotal := qs.EnqueuedTotal()
+	age := newAgeHistogram(DefaultAgeBuckets)
+	var oldest time.Time
 
 	err := qs.db.View(func(tx *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
@@ -152,6 +256,24 @@ func (qs *QueueStats) refreshStats() error {
 				continue
 			}
 			count++
+
+			// The key holds the time the message becomes ready so we need
+			// the delay from the value to know when it was enqueued.
+			var delay time.Duration
+			var firstEnqueuedAt time.Time
+			if err := item.Value(func(v []byte) error {
+				fb := flatbuf.GetRootAsRequeueMessage(v, 0)
+				delay = time.Duration(fb.Delay())
+				firstEnqueuedAt = QueueItem{K: item.Key()}.FirstEnqueuedAt(fb)
+				return nil
+			}); err != nil {
+				return err
+			}
+			readyAt := ParseQueueKey(item.Key()).Time()
+			addAge(age, now.Sub(readyAt.Add(-delay)))
+			if oldest.IsZero() || firstEnqueuedAt.Before(oldest) {
+				oldest = firstEnqueuedAt
+			}
 		}
 		return nil
 	})
@@ -161,6 +283,15 @@ func (qs *QueueStats) refreshStats() error {
 
 	// Update the count
 	atomic.StoreInt64(&qs.count, count)
+	qs.age = age
+	qs.oldestEnqueuedAt = oldest
+	if !qs.lastRefresh.IsZero() {
+		if elapsed := now.Sub(qs.lastRefresh).Seconds(); elapsed > 0 {
+			qs.enqueueRate = float64(enqueuedTotal-qs.lastEnqueuedTotal) / elapsed
+		}
+	}
+	qs.lastRefresh = now
+	qs.lastEnqueuedTotal = enqueuedTotal
 
 	return err
 }
@@ -173,9 +304,57 @@ func (qs *QueueStats) QueueStatsMessage() protocol.QueueStatsMessage {
 	if enqueued < 0 {
 		enqueued = 0
 	}
-	return protocol.QueueStatsMessage{
+	m := protocol.QueueStatsMessage{
 		QueueName: qs.queueName,
 		Enqueued:  enqueued,
 		InFlight:  qs.inFlight,
+
+		PersistLatency:   latencyStats(qs.persistLatency),
+		RepublishLatency: latencyStats(qs.republishLatency),
+
+		Age:     qs.age,
+		Expired: qs.Expired(),
+
+		EnqueuedTotal: qs.EnqueuedTotal(),
+		EnqueueRate:   qs.enqueueRate,
+
+		BatchCommits: protocol.BatchCommitStats{
+			Commits: atomic.LoadInt64(&qs.commits),
+			Entries: atomic.LoadInt64(&qs.commitEntries),
+			Bytes:   atomic.LoadInt64(&qs.commitBytes),
+			Latency: latencyStats(qs.commitLatency),
+		},
+	}
+	if !qs.oldestEnqueuedAt.IsZero() {
+		m.OldestEnqueuedAt = qs.oldestEnqueuedAt.UnixNano()
+	}
+	return m
+}
+
+// newAgeHistogram returns an empty histogram with a bucket for each of the
+// max ages, which must be ascending, and a final unbounded bucket.
+func newAgeHistogram(maxAges []time.Duration) protocol.AgeHistogram {
+	h := make(protocol.AgeHistogram, len(maxAges)+1)
+	for i, max := range maxAges {
+		h[i].MaxAge = max
+	}
+	return h
+}
+
+// addAge counts a message of the age in the first bucket it's younger than.
+func addAge(h protocol.AgeHistogram, age time.Duration) {
+	for i := range h {
+		if h[i].MaxAge == 0 || age < h[i].MaxAge {
+			h[i].Count++
+			return
+		}
+	}
+}
+
+func latencyStats(h *histogram.Histogram) protocol.LatencyStats {
+	return protocol.LatencyStats{
+		P50: h.Quantile(0.50),
+		P95: h.Quantile(0.95),
+		P99: h.Quantile(0.99),
 	}
 }
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueStatsAge(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	add := func(enqueuedAt time.Time, delay time.Duration) {
+		m := protocol.DefaultRequeueMessage()
+		m.Delay = uint64(delay)
+		assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+			return txn.Set(NewQueueKeyForMessage("orders", key.New(enqueuedAt.Add(delay))).Bytes(), m.Bytes())
+		}))
+	}
+	add(now.Add(-30*time.Second), 0)
+	add(now.Add(-2*time.Minute), 0)
+	// Delayed into the future but enqueued five hours ago.
+	add(now.Add(-5*time.Hour), 6*time.Hour)
+	add(now.Add(-48*time.Hour), 0)
+
+	qs, err := NewQueueStats(db, "orders")
+	assert.NoError(t, err)
+	defer qs.Close()
+	assert.NoError(t, qs.refreshStats())
+
+	msg := qs.QueueStatsMessage()
+	assert.Equal(t, int64(4), msg.Enqueued)
+	assert.Equal(t, protocol.AgeHistogram{
+		{MaxAge: time.Minute, Count: 1},
+		{MaxAge: 10 * time.Minute, Count: 1},
+		{MaxAge: time.Hour, Count: 0},
+		{MaxAge: 24 * time.Hour, Count: 1},
+		{MaxAge: 0, Count: 1},
+	}, msg.Age)
+}
+
+func TestQueueStatsEnqueueRateAndOldest(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	qs, err := NewQueueStats(db, "orders")
+	assert.NoError(t, err)
+	defer qs.Close()
+	assert.NoError(t, qs.refreshStats())
+
+	// An empty queue has no oldest message and nothing has been enqueued.
+	msg := qs.QueueStatsMessage()
+	assert.Equal(t, int64(0), msg.OldestEnqueuedAt)
+	assert.Equal(t, int64(0), msg.EnqueuedTotal)
+	assert.Equal(t, float64(0), msg.EnqueueRate)
+
+	// The oldest message is the one first enqueued, even after it's retried.
+	now := time.Now()
+	firstEnqueuedAt := now.Add(-time.Hour)
+	retried := protocol.DefaultRequeueMessage()
+	retried.EnqueuedAt = firstEnqueuedAt.UnixNano()
+	fresh := protocol.DefaultRequeueMessage()
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(NewQueueKeyForMessage("orders", key.New(now)).Bytes(), retried.Bytes()); err != nil {
+			return err
+		}
+		return txn.Set(NewQueueKeyForMessage("orders", key.New(now.Add(-time.Minute))).Bytes(), fresh.Bytes())
+	}))
+
+	// The rate is measured between refreshes.
+	qs.mu.Lock()
+	qs.lastRefresh = now.Add(-10 * time.Second)
+	qs.mu.Unlock()
+	qs.AddEnqueued(20)
+	assert.NoError(t, qs.refreshStats())
+
+	msg = qs.QueueStatsMessage()
+	assert.Equal(t, firstEnqueuedAt.UnixNano(), msg.OldestEnqueuedAt)
+	assert.Equal(t, int64(20), msg.EnqueuedTotal)
+	assert.InDelta(t, 2, msg.EnqueueRate, 0.1)
+}
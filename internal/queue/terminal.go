@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+)
+
+// PutTerminalRecord stores the terminal record of the message with the key k
+// in the queue for the retention. Any retention less than or equal to zero
+// will be ignored and the record is kept until the queue is dropped.
+func PutTerminalRecord(db *badger.DB, queue string, k key.Key, record []byte, retention time.Duration) error {
+	entry := badger.NewEntry(NewQueueKeyForTerminal(queue, k).Bytes(), record)
+	if retention > 0 {
+		entry = entry.WithTTL(retention)
+	}
+	if err := db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(entry)
+	}); err != nil {
+		return fmt.Errorf("put terminal record: %w", err)
+	}
+	return nil
+}
+
+// GetTerminalRecord returns the terminal record of the message with the key k
+// in the queue. False is returned if there isn't one.
+func GetTerminalRecord(db *badger.DB, queue string, k key.Key) ([]byte, bool, error) {
+	var record []byte
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(NewQueueKeyForTerminal(queue, k).Bytes())
+		if err != nil {
+			return err
+		}
+		record, err = item.ValueCopy(nil)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("get terminal record: %w", err)
+	}
+	return record, true, nil
+}
+
+// RangeTerminalRecords calls f, in message key order, with the terminal
+// records of the queue. If f returns false, range stops the iteration.
+func RangeTerminalRecords(db *badger.DB, queue string, f func(QueueItem) bool) error {
+	return rangePrefix(db, NewQueueKeyForTerminal(queue, nil).NamePrefixBytes(), f)
+}
@@ -0,0 +1,40 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTerminalRecords(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	k1 := key.New(time.Now())
+	k2 := key.New(time.Now())
+	assert.NoError(t, PutTerminalRecord(db, "orders", k1, []byte("delivered"), time.Hour))
+	assert.NoError(t, PutTerminalRecord(db, "orders", k2, []byte("expired"), 0))
+
+	record, ok, err := GetTerminalRecord(db, "orders", k1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "delivered", string(record))
+
+	_, ok, err = GetTerminalRecord(db, "other", k1)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	var records []string
+	assert.NoError(t, RangeTerminalRecords(db, "orders", func(qi QueueItem) bool {
+		assert.Equal(t, TerminalBucket, ParseQueueKey(qi.K).Bucket)
+		records = append(records, string(qi.V))
+		return true
+	}))
+	assert.Equal(t, []string{"delivered", "expired"}, records)
+}
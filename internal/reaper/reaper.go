@@ -5,14 +5,13 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v2"
 	"github.com/dgraph-io/badger/v2/pb"
 	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
 	"github.com/nickpoorman/nats-requeue/internal/ticker"
 	"github.com/rs/zerolog/log"
 )
@@ -28,6 +27,8 @@ type ReapedCallbackFunc func(dir, instanceId string)
 type Options struct {
 	// The interval in which to check for zombied instances.
 	reapInterval time.Duration
+	// The most the reap interval is randomly offset by.
+	reapJitter time.Duration
 
 	// Callbacks to trigger when an instance is reaped.
 	reapedCallbacks []ReapedCallbackFunc
@@ -51,6 +52,15 @@ func ReapInterval(reapInterval time.Duration) Option {
 	}
 }
 
+// ReapJitter offsets the reap interval by a random amount, up to max, chosen
+// once per instance so instances sharing a data dir don't sweep in lockstep.
+func ReapJitter(max time.Duration) Option {
+	return func(o *Options) error {
+		o.reapJitter = max
+		return nil
+	}
+}
+
 // ReapedCallbacks appends a callback to trigger when an instance is reaped.
 func ReapedCallbacks(callbacks ...ReapedCallbackFunc) Option {
 	return func(o *Options) error {
@@ -99,7 +109,7 @@ func (r *Reaper) initBackgroundTasks() {
 
 	go func() {
 		defer wg.Done()
-		t := ticker.New(r.opts.reapInterval)
+		t := ticker.New(r.opts.reapInterval, ticker.Jitter(r.opts.reapJitter))
 		go func() {
 			<-r.quit
 			t.Stop()
@@ -154,45 +164,57 @@ func (r *Reaper) reap() error {
 	}
 
 	for _, instanceId := range instancePaths {
-		instancePath := badgerInternal.InstanceDir(r.dataDir, instanceId)
-
-		// Try to merge the instance on that directory.
-		// This will only succeed if the badger directory is not already locked.
-		merged, err := r.mergeInstance(instancePath)
+		reaped, err := Reap(r.dst, r.dataDir, instanceId)
 		if err != nil {
-			log.Err(err).
-				Str("instancePath", instancePath).
-				Msg("unable to merge instance for directory")
 			return err
 		}
-		if !merged {
-			// Could not merge. This could be because it was locked.
-			continue
+		if reaped {
+			r.triggerReapedCallbacks(r.dataDir, instanceId)
 		}
+	}
 
-		// Clean up by removing the instance directory from disk.
-		log.Debug().
-			Str("instancePath", instancePath).
-			Msgf("removing instance directory from disk")
-		if err := r.removeInstance(instancePath); err != nil {
-			log.Err(err).
-				Str("instancePath", instancePath).
-				Msg("unable to remove instance directory")
-			return err
-		}
+	return nil
+}
 
-		r.triggerReapedCallbacks(r.dataDir, instanceId)
+// Reap merges the instance with the id in the dataDir into dst and removes it
+// from disk, e.g., to take over an instance known to be dead. It returns false
+// if the instance couldn't be merged because its directory is locked.
+func Reap(dst *badger.DB, dataDir, instanceId string) (bool, error) {
+	instancePath := badgerInternal.InstanceDir(dataDir, instanceId)
+
+	// Try to merge the instance on that directory.
+	// This will only succeed if the badger directory is not already locked.
+	merged, err := mergeInstance(dst, instancePath)
+	if err != nil {
+		log.Err(err).
+			Str("instancePath", instancePath).
+			Msg("unable to merge instance for directory")
+		return false, err
+	}
+	if !merged {
+		// Could not merge. This could be because it was locked.
+		return false, nil
 	}
 
-	return nil
+	// Clean up by removing the instance directory from disk.
+	log.Debug().
+		Str("instancePath", instancePath).
+		Msgf("removing instance directory from disk")
+	if err := removeInstance(instancePath); err != nil {
+		log.Err(err).
+			Str("instancePath", instancePath).
+			Msg("unable to remove instance directory")
+		return false, err
+	}
+	return true, nil
 }
 
-func (r *Reaper) mergeInstance(instancePath string) (bool, error) {
+func mergeInstance(dst *badger.DB, instancePath string) (bool, error) {
 	log.Debug().
 		Str("instancePath", instancePath).
 		Msg("attempting to merge badger instance")
 
-	instance, err := r.openBadgerInstance(instancePath)
+	instance, err := openBadgerInstance(instancePath)
 	if err != nil {
 		return false, err
 	}
@@ -202,7 +224,12 @@ func (r *Reaper) mergeInstance(instancePath string) (bool, error) {
 	}
 	defer instance.Close()
 
-	if err := copyBadger(r.dst, instance); err != nil {
+	// The instance may have been written by an older version.
+	if _, err := queue.MigrateKeyFormat(instance); err != nil {
+		return false, fmt.Errorf("merge instance: %w", err)
+	}
+
+	if err := copyBadger(dst, instance); err != nil {
 		return false, fmt.Errorf("merge instance: problem copying badger: %w", err)
 	}
 
@@ -217,7 +244,7 @@ func (r *Reaper) mergeInstance(instancePath string) (bool, error) {
 // This will try to acquire the directory lock before removing it.
 // It then swallows the ".../LOCK: no such file or directory" error since we
 // have already deleted the lock file.
-func (r *Reaper) removeInstance(instancePath string) error {
+func removeInstance(instancePath string) error {
 	// It's possible another instance could try to reap this instance
 	// in the meantime. To prevent that, we try to acquire the lock first.
 	dirLockGuard, err := badgerInternal.AcquireDirectoryLock(
@@ -242,16 +269,14 @@ func (r *Reaper) removeInstance(instancePath string) error {
 	return nil
 }
 
-func (r *Reaper) openBadgerInstance(path string) (*badger.DB, error) {
+func openBadgerInstance(path string) (*badger.DB, error) {
 	instance, err := badgerInternal.Open(path)
 	if err != nil {
-		// Really don't like this. We should probably check syscall.EWOULDBLOCK
-		// by unwrapping. Badger isn't currently using wrapping.
-		if !strings.Contains(err.Error(), syscall.EWOULDBLOCK.Error()) {
+		if !badgerInternal.IsLocked(err) {
 			log.Err(err).Msg("problem opening badger instance")
 			return instance, err
 		}
-		// Ignore the "resource temporarily unavailable" errors.
+		// The instance holding the lock is still running.
 		// Return that we didn't merge the instance.
 		return nil, nil
 	}
@@ -273,6 +298,11 @@ func copyBadger(dst, src *badger.DB) error {
 	// -- Optional settings
 	streamReader.LogPrefix = "Reaper.Badger.Streaming" // For identifying stream logs. Outputs to Logger.
 
+	// The instance id belongs to the store it was written in.
+	streamReader.ChooseKey = func(item *badger.Item) bool {
+		return !bytes.Equal(item.Key(), badgerInternal.InstanceIDKey)
+	}
+
 	// KeyToList is called concurrently for chosen keys. This can be used to convert
 	// Badger data into custom key-values. If nil, uses stream.ToList, a default
 	// implementation, which picks all valid key-values.
@@ -312,8 +342,20 @@ func copyBadger(dst, src *badger.DB) error {
 	wb := dst.NewWriteBatch()
 	defer wb.Cancel()
 
+	// WriteBatch.Write drops the TTLs, which would keep the dead letters
+	// and terminal records merged past their retention, so set each entry.
 	streamReader.Send = func(list *pb.KVList) error {
-		return wb.Write(list)
+		for _, kv := range list.Kv {
+			e := badger.NewEntry(kv.Key, kv.Value)
+			if len(kv.UserMeta) > 0 {
+				e = e.WithMeta(kv.UserMeta[0])
+			}
+			e.ExpiresAt = kv.ExpiresAt
+			if err := wb.SetEntry(e); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 
 	// Run the stream
@@ -13,6 +13,8 @@ import (
 	"github.com/dgraph-io/badger/v2"
 	"github.com/gofrs/uuid"
 	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -56,6 +58,12 @@ func createBadgerInstance(dataDir string) (string, *badger.DB, error) {
 	if err != nil {
 		return instanceId, nil, err
 	}
+	// Mark it as written with the current key format, like the store of any
+	// running instance, so the keys aren't migrated when it's reaped.
+	if _, err := queue.MigrateKeyFormat(db); err != nil {
+		db.Close()
+		return instanceId, nil, err
+	}
 	return instanceId, db, nil
 }
 
@@ -148,7 +156,7 @@ func TestReaper_AlreadyOpen(t *testing.T) {
 		t.Fatal(fmt.Errorf("problem creating reaper: %w", err))
 	}
 
-	ok, err := reaper.mergeInstance(zombiePath)
+	ok, err := mergeInstance(reaper.dst, zombiePath)
 	assert.NoError(t, err, "should not return an error when trying to merge a locked instance")
 	assert.False(t, ok, "should not have been able to merge the instance")
 }
@@ -259,12 +267,12 @@ func TestReaper_AlreadyRemovedInstanceDirectoryRace(t *testing.T) {
 	}
 
 	// Trigger a mergeInstance
-	merged, err := reaper.mergeInstance(zombiePath)
+	merged, err := mergeInstance(reaper.dst, zombiePath)
 	assert.True(t, merged, "should have merged")
 	assert.NoError(t, err, "error should be nil")
 
 	// Remove the zombie directory
-	assert.NoError(t, reaper.removeInstance(zombiePath), "error should be nil")
+	assert.NoError(t, removeInstance(zombiePath), "error should be nil")
 
 	// Verify that the src instance directory (zombie) has been removed.
 	assert.False(t, fileExists(zombiePath), "zombie instance should not exist: %s", zombiePath)
@@ -299,7 +307,7 @@ func TestReaper_RemoveAlreadyOpenInstanceDirectoryRace(t *testing.T) {
 	}
 
 	// Trigger a mergeInstance
-	merged, err := reaper.mergeInstance(zombiePath)
+	merged, err := mergeInstance(reaper.dst, zombiePath)
 	assert.True(t, merged, "should have merged")
 	assert.NoError(t, err, "error should be nil")
 
@@ -309,7 +317,7 @@ func TestReaper_RemoveAlreadyOpenInstanceDirectoryRace(t *testing.T) {
 	defer db.Close()
 
 	// Attempt to remove the zombie instance
-	assert.Error(t, reaper.removeInstance(zombiePath),
+	assert.Error(t, removeInstance(zombiePath),
 		"should error when trying to remove a zombie instance that's locked")
 
 	// Verify that the src instance directory (zombie) has not been removed.
@@ -317,3 +325,70 @@ func TestReaper_RemoveAlreadyOpenInstanceDirectoryRace(t *testing.T) {
 
 	assert.NoError(t, db.Close(), "should not be an error when closing")
 }
+
+func TestReaper_ReapDeadLetters(t *testing.T) {
+	dataDir := setup(t)
+
+	dstInstanceId, dstDb, err := createBadgerInstance(dataDir)
+	assert.NoError(t, err, "should have been able to create badger instance")
+	defer dstDb.Close()
+
+	// The zombie kept a dead letter, and an erasure audit record, which
+	// aren't in the messages bucket.
+	zombieInstanceId, zombieDb, err := createBadgerInstance(dataDir)
+	assert.NoError(t, err, "should have been able to create badger instance")
+	k := key.New(time.Now())
+	dl := queue.NewQueueKeyForDeadLetter("orders", k).Bytes()
+	erasure := append(append([]byte{}, queue.ErasureKeyPrefix...), k...)
+	assert.NoError(t, zombieDb.Update(func(txn *badger.Txn) error {
+		if err := txn.SetEntry(queue.DeadLetterEntry("orders", k, []byte("dead letter"), time.Hour)); err != nil {
+			return err
+		}
+		return txn.Set(erasure, []byte("erasure"))
+	}))
+	assert.NoError(t, zombieDb.Close())
+
+	reaped, err := Reap(dstDb, dataDir, zombieInstanceId)
+	assert.NoError(t, err)
+	assert.True(t, reaped)
+	assert.NotEqual(t, dstInstanceId, zombieInstanceId)
+
+	assert.NoError(t, dstDb.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(dl)
+		if err != nil {
+			return err
+		}
+		assert.NotZero(t, item.ExpiresAt(), "the dead letter retention should be kept")
+		_, err = txn.Get(erasure)
+		return err
+	}))
+}
+
+func TestReaper_ReapLegacyStore(t *testing.T) {
+	dataDir := setup(t)
+
+	_, dstDb, err := createBadgerInstance(dataDir)
+	assert.NoError(t, err, "should have been able to create badger instance")
+	defer dstDb.Close()
+
+	// The zombie was written by a version from before the key format was
+	// recorded, so its keys are migrated when it's reaped.
+	zombieInstanceId := uuid.Must(uuid.NewV4()).String()
+	zombieDb, err := badgerInternal.Open(badgerInternal.InstanceDir(dataDir, zombieInstanceId))
+	assert.NoError(t, err, "should have been able to create badger instance")
+	dl := queue.NewQueueKeyForDeadLetter("orders", key.New(time.Now()))
+	legacy := append([]byte(dl.NamePrefix()), dl.Key...)
+	assert.NoError(t, zombieDb.Update(func(txn *badger.Txn) error {
+		return txn.Set(legacy, []byte("dead letter"))
+	}))
+	assert.NoError(t, zombieDb.Close())
+
+	reaped, err := Reap(dstDb, dataDir, zombieInstanceId)
+	assert.NoError(t, err)
+	assert.True(t, reaped)
+
+	assert.NoError(t, dstDb.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(dl.Bytes())
+		return err
+	}))
+}
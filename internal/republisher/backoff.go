@@ -0,0 +1,117 @@
+package republisher
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+)
+
+// BackoffStrategy decides how long a message waits before it's republished
+// again. Attempt is the number of times the message has been republished
+// without being acknowledged, starting at one, and base is the delay the
+// message asked for.
+type BackoffStrategy interface {
+	NextDelay(attempt int, base time.Duration) time.Duration
+}
+
+// FixedBackoff waits the base delay before every attempt.
+type FixedBackoff struct{}
+
+func (FixedBackoff) NextDelay(attempt int, base time.Duration) time.Duration {
+	return base
+}
+
+// ExponentialBackoff doubles the delay on every attempt, starting at the base
+// delay, up to Max. Zero means no limit.
+type ExponentialBackoff struct {
+	Max time.Duration
+}
+
+func (b ExponentialBackoff) NextDelay(attempt int, base time.Duration) time.Duration {
+	return capDelay(exponential(attempt, base), b.Max)
+}
+
+// ExponentialWithJitterBackoff is an ExponentialBackoff that waits a random
+// delay between half and all of it, so messages that failed together aren't
+// all retried together.
+type ExponentialWithJitterBackoff struct {
+	Max time.Duration
+}
+
+func (b ExponentialWithJitterBackoff) NextDelay(attempt int, base time.Duration) time.Duration {
+	d := capDelay(exponential(attempt, base), b.Max)
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// LinearBackoff adds the base delay on every attempt, up to Max. Zero means no
+// limit.
+type LinearBackoff struct {
+	Max time.Duration
+}
+
+func (b LinearBackoff) NextDelay(attempt int, base time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	if base > 0 && int64(attempt) > math.MaxInt64/int64(base) {
+		return capDelay(math.MaxInt64, b.Max)
+	}
+	return capDelay(base*time.Duration(attempt), b.Max)
+}
+
+// exponential returns base * 2^(attempt-1) without overflowing.
+func exponential(attempt int, base time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := base
+	for i := 1; i < attempt && d > 0; i++ {
+		if d > math.MaxInt64/2 {
+			return math.MaxInt64
+		}
+		d *= 2
+	}
+	return d
+}
+
+func capDelay(d, max time.Duration) time.Duration {
+	if max > 0 && d > max {
+		return max
+	}
+	return d
+}
+
+// backoff returns the backoff the message asks for, or the default backoff
+// when it doesn't ask for one this instance knows.
+func (rp *Republisher) backoff(fb *flatbuf.RequeueMessage) BackoffStrategy {
+	switch fb.BackoffStrategy() {
+	case flatbuf.BackoffStrategyFixed:
+		return FixedBackoff{}
+	case flatbuf.BackoffStrategyExponential:
+		return ExponentialBackoff{Max: rp.opts.maxBackoff}
+	case flatbuf.BackoffStrategyExponentialWithJitter:
+		return ExponentialWithJitterBackoff{Max: rp.opts.maxBackoff}
+	case flatbuf.BackoffStrategyLinear:
+		return LinearBackoff{Max: rp.opts.maxBackoff}
+	}
+	if rp.opts.defaultBackoff != nil {
+		return rp.opts.defaultBackoff
+	}
+	return FixedBackoff{}
+}
+
+// nextDelay returns how long the message waits before it's republished again
+// after its attempt that just failed.
+func (rp *Republisher) nextDelay(fb *flatbuf.RequeueMessage) time.Duration {
+	d := rp.backoff(fb).NextDelay(int(fb.Attempts())+1, time.Duration(fb.Delay()))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
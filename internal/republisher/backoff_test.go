@@ -0,0 +1,56 @@
+package republisher
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffStrategies(t *testing.T) {
+	base := time.Second
+	for attempt, want := range map[int]time.Duration{1: base, 2: 2 * base, 4: 8 * base} {
+		assert.Equal(t, base, FixedBackoff{}.NextDelay(attempt, base))
+		assert.Equal(t, want, ExponentialBackoff{}.NextDelay(attempt, base))
+		d := ExponentialWithJitterBackoff{}.NextDelay(attempt, base)
+		assert.True(t, d >= want/2 && d <= want, "jitter out of range: %s", d)
+		assert.Equal(t, time.Duration(attempt)*base, LinearBackoff{}.NextDelay(attempt, base))
+	}
+
+	// They're capped and never overflow.
+	assert.Equal(t, time.Minute, ExponentialBackoff{Max: time.Minute}.NextDelay(100, base))
+	assert.Equal(t, time.Duration(math.MaxInt64), ExponentialBackoff{}.NextDelay(1000, base))
+	assert.Equal(t, time.Duration(math.MaxInt64), LinearBackoff{}.NextDelay(math.MaxInt32, math.MaxInt64/2))
+	assert.Equal(t, 3*time.Second, LinearBackoff{Max: 3 * time.Second}.NextDelay(10, base))
+	assert.Equal(t, time.Duration(0), ExponentialWithJitterBackoff{}.NextDelay(5, 0))
+}
+
+func TestNextDelay(t *testing.T) {
+	rp := &Republisher{opts: GetDefaultOptions()}
+	msg := protocol.DefaultRequeueMessage()
+	msg.Delay = uint64(time.Second)
+	msg.Attempts = 2
+	delay := func() time.Duration {
+		return rp.nextDelay(flatbuf.GetRootAsRequeueMessage(msg.Bytes(), 0))
+	}
+
+	// Messages without a backoff wait their delay unless there's a default.
+	assert.Equal(t, time.Second, delay())
+	assert.Error(t, DefaultBackoff(nil)(&rp.opts))
+	assert.NoError(t, DefaultBackoff(LinearBackoff{})(&rp.opts))
+	assert.Equal(t, 3*time.Second, delay())
+
+	// The backoff the message asks for wins.
+	msg.BackoffStrategy = protocol.BackoffStrategy_Exponential
+	assert.Equal(t, 4*time.Second, delay())
+	msg.BackoffStrategy = protocol.BackoffStrategy_Fixed
+	assert.Equal(t, time.Second, delay())
+
+	assert.Error(t, MaxBackoff(0)(&rp.opts))
+	assert.NoError(t, MaxBackoff(2*time.Second)(&rp.opts))
+	msg.BackoffStrategy = protocol.BackoffStrategy_Exponential
+	assert.Equal(t, 2*time.Second, delay())
+}
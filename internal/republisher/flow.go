@@ -0,0 +1,96 @@
+package republisher
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultMaxFlowWindow caps the flow control window when MaxInFlight is
+// unlimited.
+const DefaultMaxFlowWindow = 1024
+
+// flowWindow limits the number of requests in flight to what the downstream
+// responders can handle. The limit grows additively while responses come back
+// within the target latency and is halved when they don't, the same way TCP
+// finds the capacity of a link.
+type flowWindow struct {
+	target time.Duration
+	max    int
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    float64
+	inFlight int
+	// No further decrease happens until this time so a burst of slow
+	// responses to requests sent under the old limit only halves it once.
+	holdUntil time.Time
+}
+
+func newFlowWindow(target time.Duration, max int) *flowWindow {
+	if max <= 0 {
+		max = DefaultMaxFlowWindow
+	}
+	w := &flowWindow{
+		target: target,
+		max:    max,
+		limit:  1,
+	}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// acquire blocks until a request may be sent. It returns false if quit is
+// closed first.
+func (w *flowWindow) acquire(quit <-chan struct{}) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.inFlight >= int(w.limit) {
+		select {
+		case <-quit:
+			return false
+		default:
+		}
+		w.cond.Wait()
+	}
+	w.inFlight++
+	return true
+}
+
+// release records the latency of a request and whether it failed, adjusting
+// the limit accordingly.
+func (w *flowWindow) release(latency time.Duration, failed bool, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inFlight--
+
+	if failed || latency > w.target {
+		if now.After(w.holdUntil) {
+			w.limit /= 2
+			if w.limit < 1 {
+				w.limit = 1
+			}
+			w.holdUntil = now.Add(w.target)
+		}
+	} else {
+		// Grows by about one every time a full window is acknowledged.
+		w.limit += 1 / w.limit
+		if w.limit > float64(w.max) {
+			w.limit = float64(w.max)
+		}
+	}
+	w.cond.Broadcast()
+}
+
+// wake releases any callers blocked in acquire so they can see quit.
+func (w *flowWindow) wake() {
+	w.mu.Lock()
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// size returns the current number of requests allowed in flight.
+func (w *flowWindow) size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return int(w.limit)
+}
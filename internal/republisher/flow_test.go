@@ -0,0 +1,56 @@
+package republisher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlowWindow(t *testing.T) {
+	quit := make(chan struct{})
+	w := newFlowWindow(100*time.Millisecond, 8)
+	now := time.Now()
+
+	// Fast responses grow the window up to the max.
+	for i := 0; i < 100; i++ {
+		assert.True(t, w.acquire(quit))
+		w.release(10*time.Millisecond, false, now)
+	}
+	assert.Equal(t, 8, w.size())
+
+	// A slow response halves it, but only once while the hold lasts.
+	assert.True(t, w.acquire(quit))
+	w.release(time.Second, false, now)
+	assert.Equal(t, 4, w.size())
+	assert.True(t, w.acquire(quit))
+	w.release(time.Second, true, now)
+	assert.Equal(t, 4, w.size())
+
+	// Failures after the hold halve it again, never below one.
+	for i := 1; i <= 5; i++ {
+		assert.True(t, w.acquire(quit))
+		w.release(0, true, now.Add(time.Duration(i)*time.Second))
+	}
+	assert.Equal(t, 1, w.size())
+}
+
+func TestFlowWindowBlocks(t *testing.T) {
+	quit := make(chan struct{})
+	w := newFlowWindow(time.Second, 0)
+	assert.True(t, w.acquire(quit))
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- w.acquire(quit)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired more than the window allows")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(quit)
+	w.wake()
+	assert.False(t, <-acquired)
+}
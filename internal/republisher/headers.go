@@ -0,0 +1,64 @@
+package republisher
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// headers returns the headers to republish the message fb held by qi with, or
+// nil if there are none.
+func (rp *Republisher) headers(qi queue.QueueItem, fb *flatbuf.RequeueMessage) http.Header {
+	var h http.Header
+	// Consumers need the id of the key to decrypt a payload republished
+	// encrypted with a per-queue key.
+	if keyID := fb.KeyId(); len(keyID) > 0 && rp.opts.keyring == nil {
+		h = make(http.Header, 7)
+		h.Set(protocol.KeyIDHeader, string(keyID))
+	}
+	// Consumers in other queue groups need to know to ignore the message.
+	if group := fb.QueueGroup(); len(group) > 0 && rp.opts.queueGroupHeaders {
+		if h == nil {
+			h = make(http.Header, 6)
+		}
+		h.Set(protocol.QueueGroupHeader, string(group))
+	}
+	if !rp.opts.traceHeaders {
+		return h
+	}
+	if h == nil {
+		h = make(http.Header, 5)
+	}
+	messageID := qi.MessageID(fb)
+	h.Set(protocol.TraceParentHeader, traceParent(fb, messageID).String())
+	h.Set(protocol.MessageIDHeader, messageID)
+	h.Set(protocol.EnqueuedAtHeader, qi.FirstEnqueuedAt(fb).UTC().Format(time.RFC3339Nano))
+	h.Set(protocol.AttemptHeader, strconv.FormatUint(fb.Attempts()+1, 10))
+	h.Set(protocol.InstanceIDHeader, rp.opts.instanceID)
+	return h
+}
+
+// traceParent returns the traceparent of an attempt to republish the message
+// fb, which is a new span in the trace it was ingested with. Messages ingested
+// without one get a trace derived from their message id so every attempt still
+// belongs to the same trace. Those aren't marked as sampled since no one has
+// decided to record them.
+func traceParent(fb *flatbuf.RequeueMessage, messageID string) protocol.TraceParent {
+	tp, err := protocol.ParseTraceParent(string(fb.TraceParent()))
+	if err != nil {
+		sum := sha256.Sum256([]byte(messageID))
+		tp = protocol.TraceParent{}
+		copy(tp.TraceID[:], sum[:len(tp.TraceID)])
+	}
+	if _, err := rand.Read(tp.ParentID[:]); err != nil || tp.ParentID == ([8]byte{}) {
+		// An all zero id is invalid.
+		tp.ParentID[len(tp.ParentID)-1] = 1
+	}
+	return tp
+}
@@ -0,0 +1,105 @@
+package republisher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaders(t *testing.T) {
+	opts := GetDefaultOptions()
+	rp := &Republisher{opts: opts}
+
+	enqueuedAt := time.Unix(1600000000, 0)
+	k := key.New(enqueuedAt)
+	msg := protocol.DefaultRequeueMessage()
+	msg.Attempts = 2
+	qi := queue.QueueItem{K: queue.NewQueueKeyForMessage("orders", k).Bytes(), V: msg.Bytes()}
+	fb := flatbuf.GetRootAsRequeueMessage(qi.V, 0)
+
+	// They are only added when enabled.
+	assert.Nil(t, rp.headers(qi, fb))
+
+	assert.NoError(t, TraceHeaders("Inst1234")(&rp.opts))
+	h := rp.headers(qi, fb)
+	assert.Equal(t, k.String(), h.Get(protocol.MessageIDHeader))
+	assert.Equal(t, enqueuedAt.UTC().Format(time.RFC3339Nano), h.Get(protocol.EnqueuedAtHeader))
+	assert.Equal(t, "3", h.Get(protocol.AttemptHeader))
+	assert.Equal(t, "Inst1234", h.Get(protocol.InstanceIDHeader))
+
+	// Without a traceparent every attempt gets a new span in the same trace,
+	// derived from the message id.
+	first, err := protocol.ParseTraceParent(h.Get(protocol.TraceParentHeader))
+	assert.NoError(t, err)
+	second, err := protocol.ParseTraceParent(rp.headers(qi, fb).Get(protocol.TraceParentHeader))
+	assert.NoError(t, err)
+	assert.Equal(t, first.TraceID, second.TraceID)
+	assert.NotEqual(t, first.ParentID, second.ParentID)
+	assert.Equal(t, byte(0), first.Flags)
+
+	// The trace the message was ingested with is continued.
+	ingested := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	qi.V = protocol.SetTraceParent(qi.V, ingested)
+	fb = flatbuf.GetRootAsRequeueMessage(qi.V, 0)
+	want, _ := protocol.ParseTraceParent(ingested)
+	got, err := protocol.ParseTraceParent(rp.headers(qi, fb).Get(protocol.TraceParentHeader))
+	assert.NoError(t, err)
+	assert.Equal(t, want.TraceID, got.TraceID)
+	assert.Equal(t, want.Flags, got.Flags)
+	assert.NotEqual(t, want.ParentID, got.ParentID)
+}
+
+func TestHeadersKeyID(t *testing.T) {
+	opts := GetDefaultOptions()
+	rp := &Republisher{opts: opts}
+
+	msg := protocol.DefaultRequeueMessage()
+	msg.KeyID = "orders-1"
+	qi := queue.QueueItem{K: queue.NewQueueKeyForMessage("orders", key.New(time.Now())).Bytes(), V: msg.Bytes()}
+	fb := flatbuf.GetRootAsRequeueMessage(qi.V, 0)
+
+	// The payload is republished encrypted so consumers need the key id.
+	assert.Equal(t, "orders-1", rp.headers(qi, fb).Get(protocol.KeyIDHeader))
+
+	// It's decrypted before it's republished so they don't.
+	assert.NoError(t, PayloadKeyring(nopKeyring{})(&rp.opts))
+	assert.Nil(t, rp.headers(qi, fb))
+}
+
+func TestHeadersQueueGroup(t *testing.T) {
+	opts := GetDefaultOptions()
+	rp := &Republisher{opts: opts}
+
+	msg := protocol.DefaultRequeueMessage()
+	msg.QueueGroup = "workers"
+	qi := queue.QueueItem{K: queue.NewQueueKeyForMessage("orders", key.New(time.Now())).Bytes(), V: msg.Bytes()}
+	fb := flatbuf.GetRootAsRequeueMessage(qi.V, 0)
+
+	// It's only added when enabled.
+	assert.Nil(t, rp.headers(qi, fb))
+
+	assert.NoError(t, QueueGroupHeaders()(&rp.opts))
+	assert.Equal(t, "workers", rp.headers(qi, fb).Get(protocol.QueueGroupHeader))
+
+	// It's sent along with the trace headers.
+	assert.NoError(t, TraceHeaders("Inst1234")(&rp.opts))
+	h := rp.headers(qi, fb)
+	assert.Equal(t, "workers", h.Get(protocol.QueueGroupHeader))
+	assert.Equal(t, "Inst1234", h.Get(protocol.InstanceIDHeader))
+
+	// Messages that aren't intended for a group don't get one.
+	msg.QueueGroup = ""
+	qi.V = msg.Bytes()
+	fb = flatbuf.GetRootAsRequeueMessage(qi.V, 0)
+	assert.Empty(t, rp.headers(qi, fb).Get(protocol.QueueGroupHeader))
+}
+
+type nopKeyring struct{}
+
+func (nopKeyring) KeyID(string) (string, error)                 { return "", protocol.ErrKeyNotFound }
+func (nopKeyring) Encrypter(string) (protocol.Encrypter, error) { return nil, protocol.ErrKeyNotFound }
@@ -0,0 +1,175 @@
+package republisher
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+)
+
+// QueuePriority is how the messages of a queue are republished relative to
+// the messages of the other queues when they're ready at the same time.
+type QueuePriority struct {
+	// The messages of the queues in a higher tier are always republished
+	// before those in a lower one. Queues without a priority are in tier 0.
+	Tier int
+
+	// The share of the messages republished from the queue relative to the
+	// other queues in its tier, e.g., a queue with a weight of 3 gets three
+	// messages republished for every one of a queue with a weight of 1. Zero
+	// is the same as 1.
+	Weight int
+}
+
+// QueuePriorities sets the priorities of the queues by name, e.g., so the
+// retries in a high queue are republished before the backfill in a low one.
+// Time bucketed sub-queues have the priority of their base queue. Without
+// priorities every queue is read from as fast as it can be.
+func QueuePriorities(priorities map[string]QueuePriority) Option {
+	return func(o *Options) error {
+		for name, p := range priorities {
+			if name == "" {
+				return fmt.Errorf("queue priorities: queue name cannot be blank")
+			}
+			if p.Weight < 0 {
+				return fmt.Errorf("queue priorities: %s: weight cannot be negative: %d", name, p.Weight)
+			}
+		}
+		if o.queuePriorities == nil {
+			o.queuePriorities = make(map[string]QueuePriority, len(priorities))
+		}
+		for name, p := range priorities {
+			o.queuePriorities[name] = p
+		}
+		return nil
+	}
+}
+
+// priority returns the priority of the named queue.
+func (rp *Republisher) priority(name string) QueuePriority {
+	base, _ := queue.SplitBucketName(name)
+	p := rp.opts.queuePriorities[base]
+	if p.Weight == 0 {
+		p.Weight = 1
+	}
+	return p
+}
+
+// priorityScheduler picks the queue to republish the next message from out of
+// those with one ready. The queues in the highest tier with a message ready
+// are picked from in proportion to their weights with a smooth weighted round
+// robin, so a heavy queue doesn't get all its turns in a row.
+type priorityScheduler struct {
+	priorities []QueuePriority
+	current    []int
+}
+
+func newPriorityScheduler(priorities []QueuePriority) *priorityScheduler {
+	return &priorityScheduler{
+		priorities: priorities,
+		current:    make([]int, len(priorities)),
+	}
+}
+
+// next returns the queue to take the next message from, or -1 if none of them
+// have one ready. It doesn't count as a turn until it's taken.
+func (s *priorityScheduler) next(ready []bool) int {
+	next := -1
+	for i, ok := range ready {
+		if !ok {
+			continue
+		}
+		if next == -1 || s.priorities[i].Tier > s.priorities[next].Tier {
+			next = i
+			continue
+		}
+		if s.priorities[i].Tier == s.priorities[next].Tier &&
+			s.current[i]+s.priorities[i].Weight > s.current[next]+s.priorities[next].Weight {
+			next = i
+		}
+	}
+	return next
+}
+
+// took records that the message of queue i was taken out of the ones ready.
+func (s *priorityScheduler) took(i int, ready []bool) {
+	var total int
+	for j, ok := range ready {
+		if ok && s.priorities[j].Tier == s.priorities[i].Tier {
+			s.current[j] += s.priorities[j].Weight
+			total += s.priorities[j].Weight
+		}
+	}
+	s.current[i] -= total
+}
+
+// prioritize sends the messages read from each of the queues, in order of the
+// priorities of the queues, to out until every queue has been read. out is
+// closed once they have. The message sent next is only decided once the
+// previous one has been taken, so a message from a queue with a higher
+// priority that's read in the meantime goes first.
+func prioritize(ins []chan runQueueItem, priorities []QueuePriority, out chan<- runQueueItem) {
+	defer close(out)
+	s := newPriorityScheduler(priorities)
+	pending := make([]runQueueItem, len(ins))
+	ready := make([]bool, len(ins))
+	open := len(ins)
+	received := func(i int, rqi runQueueItem, ok bool) {
+		if !ok {
+			ins[i] = nil
+			open--
+			return
+		}
+		pending[i] = rqi
+		ready[i] = true
+	}
+
+	cases := make([]reflect.SelectCase, 0, len(ins)+1)
+	from := make([]int, 0, len(ins))
+	for {
+		// Take the messages that have already been read without waiting.
+		for i, in := range ins {
+			if in == nil || ready[i] {
+				continue
+			}
+			select {
+			case rqi, ok := <-in:
+				received(i, rqi, ok)
+			default:
+			}
+		}
+		next := s.next(ready)
+		if next == -1 && open == 0 {
+			return
+		}
+
+		// Wait for the next message to be taken, or for another message to be
+		// read since it may go first.
+		cases, from = cases[:0], from[:0]
+		for i, in := range ins {
+			if in != nil && !ready[i] {
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(in)})
+				from = append(from, i)
+			}
+		}
+		if next != -1 {
+			cases = append(cases, reflect.SelectCase{
+				Dir:  reflect.SelectSend,
+				Chan: reflect.ValueOf(out),
+				Send: reflect.ValueOf(pending[next]),
+			})
+		}
+		chosen, v, ok := reflect.Select(cases)
+		if chosen < len(from) {
+			var rqi runQueueItem
+			if ok {
+				rqi = v.Interface().(runQueueItem)
+			}
+			received(from[chosen], rqi, ok)
+			continue
+		}
+		s.took(next, ready)
+		ready[next] = false
+		pending[next] = runQueueItem{}
+	}
+}
@@ -0,0 +1,87 @@
+package republisher
+
+import (
+	"testing"
+
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueuePriorities(t *testing.T) {
+	opts := GetDefaultOptions()
+	assert.NoError(t, QueuePriorities(map[string]QueuePriority{
+		"high": {Tier: 1},
+		"low":  {Weight: 3},
+	})(&opts))
+	rp := &Republisher{opts: opts}
+
+	assert.Equal(t, QueuePriority{Tier: 1, Weight: 1}, rp.priority("high"))
+	assert.Equal(t, QueuePriority{Weight: 3}, rp.priority("low"))
+	// Sub-queues have the priority of their base queue.
+	assert.Equal(t, QueuePriority{Tier: 1, Weight: 1}, rp.priority("high@1600000000"))
+	assert.Equal(t, QueuePriority{Weight: 1}, rp.priority("other"))
+
+	assert.Error(t, QueuePriorities(map[string]QueuePriority{"": {}})(&opts))
+	assert.Error(t, QueuePriorities(map[string]QueuePriority{"low": {Weight: -1}})(&opts))
+}
+
+func TestPrioritySchedulerWeights(t *testing.T) {
+	s := newPriorityScheduler([]QueuePriority{{Weight: 1}, {Weight: 3}})
+	ready := []bool{true, true}
+
+	counts := make([]int, 2)
+	var order []int
+	for i := 0; i < 8; i++ {
+		next := s.next(ready)
+		s.took(next, ready)
+		counts[next]++
+		order = append(order, next)
+	}
+	assert.Equal(t, []int{2, 6}, counts)
+	// The turns of the heavier queue are spread out.
+	assert.Equal(t, []int{1, 0, 1, 1, 1, 0, 1, 1}, order)
+
+	// Only the queues with a message ready get a turn.
+	assert.Equal(t, 0, s.next([]bool{true, false}))
+	assert.Equal(t, -1, s.next([]bool{false, false}))
+}
+
+func TestPrioritySchedulerTiers(t *testing.T) {
+	s := newPriorityScheduler([]QueuePriority{{Weight: 100}, {Tier: 1, Weight: 1}, {Tier: 2, Weight: 1}})
+
+	// The highest tier with a message ready always goes first, whatever the
+	// weights of the lower ones.
+	assert.Equal(t, 2, s.next([]bool{true, true, true}))
+	assert.Equal(t, 1, s.next([]bool{true, true, false}))
+	assert.Equal(t, 0, s.next([]bool{true, false, false}))
+}
+
+func TestPrioritize(t *testing.T) {
+	item := func(k string) runQueueItem {
+		return runQueueItem{queueItem: queue.QueueItem{K: []byte(k)}}
+	}
+	ins := make([]chan runQueueItem, 3)
+	for i, keys := range [][]string{
+		{"low1", "low2"},
+		{"high1", "high2", "high3"},
+		{"mid1", "mid2"},
+	} {
+		ins[i] = make(chan runQueueItem, len(keys))
+		for _, k := range keys {
+			ins[i] <- item(k)
+		}
+		close(ins[i])
+	}
+	out := make(chan runQueueItem)
+	go prioritize(ins, []QueuePriority{
+		{Tier: 0, Weight: 1},
+		{Tier: 2, Weight: 1},
+		{Tier: 1, Weight: 1},
+	}, out)
+
+	var got []string
+	for rqi := range out {
+		got = append(got, string(rqi.queueItem.K))
+	}
+	assert.Equal(t, []string{"high1", "high2", "high3", "mid1", "mid2", "low1", "low2"}, got)
+}
@@ -1,7 +1,10 @@
 package republisher
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,6 +28,10 @@ const (
 	// the message will be placed back into the queue.
 	DefaultRepublisherInterval = 15 * time.Second
 
+	// The least amount of time between two scans of the queues. Wakeups for
+	// messages that become ready within it are coalesced into one scan.
+	DefaultMinRepublishInterval = 100 * time.Millisecond
+
 	// On this interval, the queues will be scanned for any messages that might
 	// exist before our current checkpoint. If any such messages are found, the
 	// checkpoint will be updated to the found message key.
@@ -34,44 +41,128 @@ const (
 	// set to -1 there is no limit. A limit should be set in production
 	// environments to avoid overloading the consumers.
 	DefaultMaxInFlight = -1
+
+	// The longest a message is delayed by the backoff it asks for.
+	DefaultMaxBackoff = 24 * time.Hour
 )
 
 // Options can be used to set custom options for a Republisher.
 type Options struct {
-	// On this interval, the queue will be scanned for messages
-	// that are ready to be published.
+	// The queues are scanned as messages become ready, but at least on this
+	// interval.
 	pubInterval time.Duration
 
+	// The least amount of time between two scans of the queues.
+	minPubInterval time.Duration
+
 	// The amount of time a request (publish) will wait to be acknowledged
 	// by the reviever. In the event an acknowledgement it not received,
 	// the message will be placed back into the queue.
 	ackTimeout time.Duration
 
+	// Overrides ackTimeout for the queues by name.
+	queueAckTimeouts map[string]time.Duration
+
+	// The max number of times a message is republished regardless of how
+	// many retries it asked for. Zero means no limit.
+	maxRedeliveries uint64
+
+	// Overrides maxRedeliveries for the queues by name.
+	queueMaxRedeliveries map[string]uint64
+
+	// The subjects each message in the queues, by name, is also sent to.
+	queueMirrors map[string][]string
+
 	// On this interval, the queues will be scanned for any messages that might
 	// exist before our current checkpoint. If any such messages are found, the
 	// checkpoint will be updated to the found message key.
 	checkpointCorrectionInterval time.Duration
 
+	// When set, the checkpoints of the queues are reset when the republisher
+	// starts so every queue is read from the start.
+	rescan bool
+
 	// The concurrent limit for messages in flight waiting for a response. When
 	// set to -1 there is no limit. A limit should be set in production
 	// environments to avoid overloading the consumers.
 	maxInFlight int
+
+	// Decrypts the original payload of a message before it's republished.
+	decrypter protocol.Encrypter
+	keyring   protocol.Keyring
+
+	// Called with the original subject of every message successfully
+	// republished.
+	republishedCB func(subject string)
+
+	// Called once a message has been removed from its queue.
+	terminalCB func(protocol.TerminalRecord)
+
+	// When set, messages that are given up on are kept as dead letters for
+	// the retention rather than thrown away. Zero keeps them until they're
+	// deleted or redriven.
+	deadLetters         bool
+	deadLetterRetention time.Duration
+
+	// Called with every message that is given up on.
+	deadLetterCB func(protocol.DeadLetter)
+
+	// The backoff of the messages that don't ask for one, and the cap on the
+	// delay of those that do.
+	defaultBackoff BackoffStrategy
+	maxBackoff     time.Duration
+
+	// When non-zero, the number of requests in flight is adapted to keep the
+	// downstream response latency under this target.
+	flowTarget time.Duration
+
+	// Returns the revision of the envelopes to write back to disk. The
+	// CurrentRevision is used when it's nil.
+	revision func() protocol.Revision
+
+	// When set, messages with a TTL are written back to disk with a TTL in
+	// the store too, this long after they expire.
+	storageTTL      bool
+	storageTTLGrace time.Duration
+
+	// When non-zero, messages are republished within this long of the
+	// precise time they become ready rather than the second of their key.
+	precision time.Duration
+
+	// When set, trace and provenance headers are added to every message that
+	// is republished, with this as the id of the instance.
+	traceHeaders bool
+	instanceID   string
+
+	// When set, the queue group a message is intended for is sent with it.
+	queueGroupHeaders bool
+
+	// The priorities of the queues by name. When there are none the queues
+	// are read from as fast as they can be.
+	queuePriorities map[string]QueuePriority
+
+	// Translations of the original subjects of the messages to the subjects
+	// they're republished on, most specific first.
+	translations []subjectTranslation
 }
 
 func GetDefaultOptions() Options {
 	return Options{
 		pubInterval:                  DefaultRepublisherInterval,
+		minPubInterval:               DefaultMinRepublishInterval,
 		ackTimeout:                   DefaultACKTimeout,
 		checkpointCorrectionInterval: DefaultCheckpointCorrectionInterval,
 		maxInFlight:                  DefaultMaxInFlight,
+		maxBackoff:                   DefaultMaxBackoff,
 	}
 }
 
 // Option is a function on the options for a Republisher.
 type Option func(*Options) error
 
-// On this interval, the queue will be scanned for messages
-// that are ready to be published.
+// The queues are scanned for messages that are ready to be published as they
+// become ready, and at least on this interval in case we weren't told about
+// some of them.
 func RepublishInterval(interval time.Duration) Option {
 	return func(o *Options) error {
 		o.pubInterval = interval
@@ -79,6 +170,33 @@ func RepublishInterval(interval time.Duration) Option {
 	}
 }
 
+// Precision republishes messages no more than tolerance after the precise
+// time they become ready, and never before, rather than anywhere in the second
+// of their key. The messages need to have their precise ready time set. The
+// min republish interval is capped to the tolerance.
+func Precision(tolerance time.Duration) Option {
+	return func(o *Options) error {
+		if tolerance <= 0 {
+			return fmt.Errorf("precision tolerance must be positive: %s", tolerance)
+		}
+		o.precision = tolerance
+		return nil
+	}
+}
+
+// MinRepublishInterval sets the least amount of time between two scans of the
+// queues. Messages that become ready within it of each other are republished
+// in the same scan.
+func MinRepublishInterval(interval time.Duration) Option {
+	return func(o *Options) error {
+		if interval < 0 {
+			return fmt.Errorf("min republish interval cannot be negative: %s", interval)
+		}
+		o.minPubInterval = interval
+		return nil
+	}
+}
+
 // The amount of time a request (publish) will wait to be acknowledged by the
 // reviever. In the event an acknowledgement it not received, the message will
 // be placed back into the queue.
@@ -89,6 +207,75 @@ func AckTimeout(timeout time.Duration) Option {
 	}
 }
 
+// QueueAckTimeout sets the ack timeout for the messages in the named queue,
+// e.g., a short one for a fast API and a long one for a slow batch system.
+// Messages can still override it with their own timeout.
+func QueueAckTimeout(name string, timeout time.Duration) Option {
+	return func(o *Options) error {
+		if name == "" {
+			return fmt.Errorf("queue ack timeout: queue name cannot be blank")
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("queue ack timeout: %s: timeout must be positive: %s", name, timeout)
+		}
+		if o.queueAckTimeouts == nil {
+			o.queueAckTimeouts = make(map[string]time.Duration)
+		}
+		o.queueAckTimeouts[name] = timeout
+		return nil
+	}
+}
+
+// MaxRedeliveries caps the number of times a message is republished,
+// regardless of the number of retries the producer set on it. This protects
+// the store from messages configured to retry forever. Zero, the default,
+// means no limit.
+func MaxRedeliveries(n uint64) Option {
+	return func(o *Options) error {
+		o.maxRedeliveries = n
+		return nil
+	}
+}
+
+// QueueMaxRedeliveries overrides MaxRedeliveries for the named queue.
+func QueueMaxRedeliveries(name string, n uint64) Option {
+	return func(o *Options) error {
+		if name == "" {
+			return fmt.Errorf("queue max redeliveries: queue name cannot be blank")
+		}
+		if o.queueMaxRedeliveries == nil {
+			o.queueMaxRedeliveries = make(map[string]uint64)
+		}
+		o.queueMaxRedeliveries[name] = n
+		return nil
+	}
+}
+
+// QueueMirrors sends every message in the named queue to the subjects as well
+// as its original subject, e.g., to dual write during a migration. Each
+// subject must acknowledge the message independently. When some fail, the
+// message is retried on only those.
+func QueueMirrors(name string, subjects ...string) Option {
+	return func(o *Options) error {
+		if name == "" {
+			return fmt.Errorf("queue mirrors: queue name cannot be blank")
+		}
+		for _, subj := range subjects {
+			if err := protocol.ValidateSubject(subj); err != nil {
+				return fmt.Errorf("queue mirrors: %s: %w", name, err)
+			}
+			if strings.ContainsAny(subj, "*>") {
+				return fmt.Errorf("queue mirrors: %s: cannot publish to a wildcard subject: %q", name, subj)
+			}
+		}
+		if o.queueMirrors == nil {
+			o.queueMirrors = make(map[string][]string)
+		}
+		o.queueMirrors[name] = subjects
+		return nil
+	}
+}
+
 // On this interval, the queues will be scanned for any messages that might
 // exist before our current checkpoint. If any such messages are found, the
 // checkpoint will be updated to the found message key.
@@ -99,6 +286,17 @@ func CheckpointCorrectionInterval(interval time.Duration) Option {
 	}
 }
 
+// RescanOnStart reads every queue from the start when the republisher starts
+// rather than resuming from the checkpoint it saved when it last ran, e.g.,
+// after restoring a backup or if the checkpoints are suspected to be wrong.
+// Messages that aren't ready yet are still left alone.
+func RescanOnStart() Option {
+	return func(o *Options) error {
+		o.rescan = true
+		return nil
+	}
+}
+
 // The concurrent limit for messages in flight waiting for a response. When set
 // to -1 there is no limit. A limit should be set in production environments to
 // avoid overloading the consumers.
@@ -109,6 +307,155 @@ func MaxInFlight(concurrent int) Option {
 	}
 }
 
+// PayloadDecrypter decrypts the original payload of each message with e before
+// it's republished.
+func PayloadDecrypter(e protocol.Encrypter) Option {
+	return func(o *Options) error {
+		o.decrypter = e
+		return nil
+	}
+}
+
+// PayloadKeyring decrypts the original payload of each message encrypted with
+// a per-queue key with the key from k before it's republished. Messages
+// without a key id are left to the PayloadDecrypter, if any.
+func PayloadKeyring(k protocol.Keyring) Option {
+	return func(o *Options) error {
+		o.keyring = k
+		return nil
+	}
+}
+
+// RepublishedHandler sets a callback that will be triggered with the original
+// subject of every message that is successfully republished.
+func RepublishedHandler(cb func(subject string)) Option {
+	return func(o *Options) error {
+		o.republishedCB = cb
+		return nil
+	}
+}
+
+// TerminalHandler sets a callback that will be triggered once a message has
+// been removed from its queue, either because it was delivered or because it
+// was dead lettered.
+func TerminalHandler(cb func(protocol.TerminalRecord)) Option {
+	return func(o *Options) error {
+		o.terminalCB = cb
+		return nil
+	}
+}
+
+// DeadLetterQueue keeps the messages that run out of retries, reach the max
+// redeliveries of their queue or can't be decrypted, as protocol.DeadLetters
+// in the store for the retention rather than throwing them away. A message is moved to the dead
+// letters in the same transaction it's removed from its queue in. Zero keeps
+// them until they're deleted, redriven or the queue is dropped.
+func DeadLetterQueue(retention time.Duration) Option {
+	return func(o *Options) error {
+		if retention < 0 {
+			return fmt.Errorf("dead letter retention cannot be negative: %s", retention)
+		}
+		o.deadLetters = true
+		o.deadLetterRetention = retention
+		return nil
+	}
+}
+
+// DeadLetterHandler sets a callback that will be triggered with every message
+// that is given up on, once it has been removed from its queue.
+func DeadLetterHandler(cb func(protocol.DeadLetter)) Option {
+	return func(o *Options) error {
+		o.deadLetterCB = cb
+		return nil
+	}
+}
+
+// DefaultBackoff sets the backoff of the messages that don't ask for one. They
+// wait the delay they asked for before every attempt by default.
+func DefaultBackoff(b BackoffStrategy) Option {
+	return func(o *Options) error {
+		if b == nil {
+			return fmt.Errorf("default backoff cannot be nil")
+		}
+		o.defaultBackoff = b
+		return nil
+	}
+}
+
+// MaxBackoff caps the delay of the messages that ask for a growing backoff.
+// It doesn't apply to the DefaultBackoff, which caps itself.
+func MaxBackoff(max time.Duration) Option {
+	return func(o *Options) error {
+		if max <= 0 {
+			return fmt.Errorf("max backoff must be positive: %s", max)
+		}
+		o.maxBackoff = max
+		return nil
+	}
+}
+
+// AdaptiveFlowControl adapts the number of requests in flight to the capacity
+// of the downstream responders. The limit starts at one and grows while
+// responses come back within target, and is halved when a response is slower
+// or the request fails. MaxInFlight, when set, caps the limit.
+func AdaptiveFlowControl(target time.Duration) Option {
+	return func(o *Options) error {
+		if target <= 0 {
+			return fmt.Errorf("flow control target latency must be positive: %s", target)
+		}
+		o.flowTarget = target
+		return nil
+	}
+}
+
+// TraceHeaders adds the headers in protocol, such as the W3C traceparent and
+// the attempt number, to every message that is republished so consumers and
+// tracing systems can see they're handling a retried message and where it came
+// from. The instance id is sent as the republishing instance. The NATS servers
+// need to support headers.
+func TraceHeaders(instanceID string) Option {
+	return func(o *Options) error {
+		o.traceHeaders = true
+		o.instanceID = instanceID
+		return nil
+	}
+}
+
+// QueueGroupHeaders sends the downstream queue group a message is intended
+// for, if any, in the protocol.QueueGroupHeader of every attempt to republish
+// it. The NATS servers need to support headers.
+func QueueGroupHeaders() Option {
+	return func(o *Options) error {
+		o.queueGroupHeaders = true
+		return nil
+	}
+}
+
+// EmitRevision sets a function returning the revision of the envelopes that
+// are written back to disk when messages are retried, so instances that
+// haven't been upgraded yet can read them.
+func EmitRevision(f func() protocol.Revision) Option {
+	return func(o *Options) error {
+		o.revision = f
+		return nil
+	}
+}
+
+// StorageTTL sets the TTL in the store of messages written back to disk,
+// grace after their own TTL elapses, as a backstop to the expiry sweeper.
+func StorageTTL(grace time.Duration) Option {
+	return func(o *Options) error {
+		if grace < 0 {
+			return fmt.Errorf("storage ttl grace cannot be negative: %s", grace)
+		}
+		o.storageTTL = true
+		o.storageTTLGrace = grace
+		return nil
+	}
+}
+
+var errClosing = errors.New("republisher is closing")
+
 type Republisher struct {
 	db       *badger.DB
 	qManager *queue.Manager
@@ -116,6 +463,14 @@ type Republisher struct {
 
 	opts Options
 
+	// Only set when adaptive flow control is enabled.
+	flow *flowWindow
+
+	// The UnixNano time of the next scheduled run, read by Notify.
+	nextRun int64
+	// Signals the scheduler to run early.
+	wake chan struct{}
+
 	mu sync.RWMutex
 
 	quit chan struct{}
@@ -190,19 +545,45 @@ func New(nc *nats.Conn, db *badger.DB, qManager *queue.Manager, options ...Optio
 		}
 	}
 
+	// Runs can't be further apart than the precision allows.
+	if opts.precision > 0 && opts.minPubInterval > opts.precision {
+		opts.minPubInterval = opts.precision
+	}
+
 	rq := &Republisher{
 		db:       db,
 		nc:       nc,
 		qManager: qManager,
 		opts:     opts,
+		nextRun:  noRunScheduled,
+		wake:     make(chan struct{}, 1),
 		quit:     make(chan struct{}),
 		done:     make(chan struct{}),
 	}
+	if opts.flowTarget > 0 {
+		rq.flow = newFlowWindow(opts.flowTarget, opts.maxInFlight)
+	}
+	if opts.rescan {
+		if err := rq.resetCheckpoints(); err != nil {
+			return nil, err
+		}
+	}
 	go rq.initBackgroundTasks()
 
 	return rq, nil
 }
 
+// resetCheckpoints moves the checkpoint of every queue back to its start.
+func (rp *Republisher) resetCheckpoints() error {
+	for _, q := range rp.qManager.Queues() {
+		if err := q.ResetCheckpoint(); err != nil {
+			return fmt.Errorf("reset checkpoint: %s: %w", q.Name(), err)
+		}
+	}
+	log.Info().Msg("republisher: rescanning the queues from the start")
+	return nil
+}
+
 func (rp *Republisher) initBackgroundTasks() {
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -214,15 +595,7 @@ func (rp *Republisher) initBackgroundTasks() {
 	// republish loop
 	go func() {
 		defer wg.Done()
-		t := ticker.New(rp.opts.pubInterval)
-		go func() {
-			<-rp.quit
-			t.Stop()
-		}()
-		t.Loop(func() bool {
-			rp.republish()
-			return true
-		})
+		rp.schedule()
 	}()
 
 	// Our checkpoint is optimistic. To solve the checkpoint getting ahead of
@@ -245,12 +618,20 @@ func (rp *Republisher) initBackgroundTasks() {
 
 func (rp *Republisher) Close() {
 	close(rp.quit)
+	if rp.flow != nil {
+		rp.flow.wake()
+	}
 	<-rp.done
 }
 
 // republish check all the queues for new messages
 // that are ready to be sent and trigger a publish for any that are.
-// This is called in a loop on an interval.
+// This is called by the scheduler whenever messages are due.
+//
+// The store isn't sharded, but each queue, including every time bucketed
+// sub-queue, is read by its own iterator from its own checkpoint concurrently
+// with the others. Republish throughput scales with the number of queues
+// rather than being held up by a single iterator.
 func (rp *Republisher) republish() {
 	log.Debug().Msg("republisher: republish: triggered.")
 	rp.mu.Lock()
@@ -264,25 +645,41 @@ func (rp *Republisher) republish() {
 		return
 	}
 
-	writeCh := make(chan runQueueItem)
-	var wg sync.WaitGroup
-	wg.Add(len(qs))
-
-	go func() {
-		wg.Wait()
-		close(writeCh)
-	}()
-
 	run := newRun(
 		time.Now(), // Read up until now.
 		qs,
 	)
 
-	for i := range run.queues {
-		go func(rq *runQueue) {
-			defer wg.Done()
-			rp.processQueue(rq, writeCh, run.until)
-		}(&run.queues[i])
+	writeCh := make(chan runQueueItem)
+	if len(rp.opts.queuePriorities) > 0 {
+		// Each queue is read into its own channel so the messages can be
+		// taken from them in order of their priorities.
+		ins := make([]chan runQueueItem, len(run.queues))
+		priorities := make([]QueuePriority, len(run.queues))
+		for i := range run.queues {
+			ins[i] = make(chan runQueueItem)
+			priorities[i] = rp.priority(run.queues[i].q.Name())
+			go func(rq *runQueue, ch chan runQueueItem) {
+				defer close(ch)
+				rp.processQueue(rq, ch, run.until)
+			}(&run.queues[i], ins[i])
+		}
+		go prioritize(ins, priorities, writeCh)
+	} else {
+		var wg sync.WaitGroup
+		wg.Add(len(qs))
+
+		go func() {
+			wg.Wait()
+			close(writeCh)
+		}()
+
+		for i := range run.queues {
+			go func(rq *runQueue) {
+				defer wg.Done()
+				rp.processQueue(rq, writeCh, run.until)
+			}(&run.queues[i])
+		}
 	}
 
 	// Based on our max in flight limit, create workers to publish messages and
@@ -335,6 +732,12 @@ func (rp *Republisher) republish() {
 func (rp *Republisher) processQueue(rq *runQueue, ch chan<- runQueueItem, untilTime time.Time) {
 	log.Debug().Msgf("republisher: republish: processing queue: %s", rq.q.Name())
 	checkpoint, err := rq.q.ReadFromCheckpoint(untilTime, func(qi queue.QueueItem) bool {
+		if !ready(qi, untilTime) {
+			// Its key is from the current second but it isn't ready yet.
+			// Make sure the checkpoint doesn't pass it.
+			rq.setMinCheckpoint(key.Key(queue.ParseQueueKey(qi.K).Key))
+			return true
+		}
 		rqi := runQueueItem{
 			runQueue:  rq,
 			queueItem: qi,
@@ -372,9 +775,10 @@ func (rp *Republisher) publishMessages(ch <-chan runQueueItem) {
 			Str("msg", string(fb.OriginalPayloadBytes())).
 			Msg("republishing message")
 
-		if rqi.queueItem.IsExpired() {
+		if expiresAt, ok := rqi.queueItem.Expiry(fb); ok && !expiresAt.After(time.Now()) {
 			// Don't send a message with an expired TTL.
-			// TTL will take care of removing the message from disk for us.
+			// The expiry sweeper will take care of removing the message from
+			// disk for us.
 			log.Debug().
 				Str("msg", string(fb.OriginalPayloadBytes())).
 				Msg("message is expired")
@@ -382,32 +786,85 @@ func (rp *Republisher) publishMessages(ch <-chan runQueueItem) {
 		}
 
 		subj := string(fb.OriginalSubject())
-		data := fb.OriginalPayloadBytes()
-
-		rqi.runQueue.q.Stats.AddInFlight(1)
-		_, err := rp.nc.Request(subj, data, rp.opts.ackTimeout)
-		rqi.runQueue.q.Stats.AddInFlight(-1)
-		if err != nil {
+		var acked []string
+		data, err := rp.payload(fb)
+		decryptFailed := err != nil
+		if err == nil {
+			acked, err = rp.fanOut(rqi.runQueue.q, fb, rp.translate(subj), data, rp.headers(rqi.queueItem, fb))
+			if err == errClosing {
+				// The message was never sent so leave it on disk without
+				// spending a retry and make sure the checkpoint doesn't pass it.
+				rqi.runQueue.setMinCheckpoint(key.Key(queue.ParseQueueKey(rqi.queueItem.K).Key))
+				continue
+			}
+		}
+		record := protocol.TerminalRecord{
+			Queue:     rqi.runQueue.q.Name(),
+			MessageID: rqi.queueItem.MessageID(fb),
+			Subject:   subj,
+			State:     protocol.TerminalStateDelivered,
+			Attempts:  fb.Attempts() + 1,
+		}
+		if err == nil {
+			record.Latency = time.Since(rqi.queueItem.FirstEnqueuedAt(fb))
+			rqi.runQueue.q.Stats.RecordRepublishLatency(record.Latency)
+			record.ReplyTo = string(fb.ReplyTo())
+			if rp.opts.republishedCB != nil {
+				rp.opts.republishedCB(subj)
+			}
+		}
+		if err != nil && decryptFailed {
+			// A missing or wrong key isn't fixed by retrying, so it's dead
+			// lettered now rather than spending its retries on it.
+			log.Err(err).
+				Str("queue", rqi.runQueue.q.Name()).
+				Str("subject", subj).
+				Str("keyId", string(fb.KeyId())).
+				Msg("unable to decrypt the payload of message")
+
+			record.State = protocol.TerminalStateDeadLettered
+			record.Reason = protocol.TerminalReasonDecryptFailed
+			record.Error = err.Error()
+			record.Attempts = fb.Attempts()
+		} else if err != nil {
 			log.Err(err).
 				Str("msg", string(fb.OriginalPayloadBytes())).
 				Msg("error doing Request for message")
 
+			record.State = protocol.TerminalStateDeadLettered
+			record.Reason = protocol.TerminalReasonRetriesExhausted
+			record.Error = err.Error()
+
 			// We just spent a retry.
 			// So if retires == 1 it will now be zero and we should throw away the message.
 			// If retires > 1 then there are retries still left to be spent.
 			if fb.Retries() > 1 {
-				// Requeue the message to disk for a future time.
-				if err := rp.requeueMessageToDisk(rqi, fb); err != nil {
-					log.Err(err).
-						Interface("queueItem", rqi.queueItem).
-						Msg("unable to requeue message")
+				if !rp.redeliveriesExhausted(rqi.runQueue.q.Name(), fb) {
+					// Requeue the message to disk for a future time.
+					if err := rp.requeueMessageToDisk(rqi, fb, acked); err != nil {
+						log.Err(err).
+							Interface("queueItem", rqi.queueItem).
+							Msg("unable to requeue message")
+					}
+					continue
 				}
-				continue
+				log.Warn().
+					Str("queue", rqi.runQueue.q.Name()).
+					Str("subject", subj).
+					Uint64("attempts", fb.Attempts()+1).
+					Msg("message reached the max redeliveries for its queue")
+				record.Reason = protocol.TerminalReasonMaxRedeliveries
 			}
 		}
 		// Got the ACK or ran out of retries.
-		// Remove the message from disk.
-		if err := rp.removeMessageFromDisk(rqi.queueItem, fb); err != nil {
+		// Remove the message from disk, keeping it as a dead letter if it ran
+		// out of retries and they're being kept.
+		var dl *protocol.DeadLetter
+		var dlEntry *badger.Entry
+		if record.State == protocol.TerminalStateDeadLettered {
+			dl, dlEntry = rp.deadLetter(rqi, fb, record)
+		}
+		if err := rp.removeMessageFromDisk(rqi.runQueue.q.Name(), rqi.queueItem, fb, dlEntry); err != nil {
 			log.Err(err).
 				Interface("queueItem", rqi.queueItem).
 				Msg("unable to remove message from store")
@@ -415,19 +872,140 @@ func (rp *Republisher) publishMessages(ch <-chan runQueueItem) {
 			continue
 		}
 		rqi.runQueue.q.Stats.AddCount(-1)
+		if dl != nil && rp.opts.deadLetterCB != nil {
+			rp.opts.deadLetterCB(*dl)
+		}
+		if rp.opts.terminalCB != nil {
+			record.Key = key.Key(queue.ParseQueueKey(rqi.queueItem.K).Key).String()
+			record.Time = time.Now()
+			rp.opts.terminalCB(record)
+		}
 	}
 }
 
+// request sends the message and waits for the acknowledgement, within the
+// flow control window if there is one.
+//
+// Nothing is written to disk while a message is in flight. It stays under its
+// key until it's acknowledged and removed, or requeued under a new one, so a
+// message in flight when an instance dies is republished once it restarts and
+// there is no in-flight state that could be orphaned.
+func (rp *Republisher) request(q *queue.Queue, subj string, data []byte, hdr http.Header, timeout time.Duration) error {
+	if rp.flow != nil {
+		if !rp.flow.acquire(rp.quit) {
+			return errClosing
+		}
+	}
+	q.Stats.AddInFlight(1)
+	start := time.Now()
+	var err error
+	if len(hdr) > 0 {
+		_, err = rp.nc.RequestMsg(&nats.Msg{Subject: subj, Data: data, Header: hdr}, timeout)
+	} else {
+		_, err = rp.nc.Request(subj, data, timeout)
+	}
+	q.Stats.AddInFlight(-1)
+	if rp.flow != nil {
+		now := time.Now()
+		rp.flow.release(now.Sub(start), err != nil, now)
+	}
+	return err
+}
+
+// fanOut sends the message to the original subject and any mirrors of its
+// queue that haven't acked it yet, waiting for each to acknowledge it
+// independently. The subjects that acked are returned along with the first
+// error, if any, so only the rest are retried.
+func (rp *Republisher) fanOut(q *queue.Queue, fb *flatbuf.RequeueMessage, subj string, data []byte, hdr http.Header) ([]string, error) {
+	timeout := rp.ackTimeout(q, fb)
+	base, _ := queue.SplitBucketName(q.Name())
+	mirrors := rp.opts.queueMirrors[base]
+	if len(mirrors) == 0 && fb.AckedSubjectsLength() == 0 {
+		return nil, rp.request(q, subj, data, hdr, timeout)
+	}
+
+	done := make(map[string]bool, fb.AckedSubjectsLength())
+	for i := 0; i < fb.AckedSubjectsLength(); i++ {
+		done[string(fb.AckedSubjects(i))] = true
+	}
+	targets := make([]string, 0, len(mirrors)+1)
+	for _, t := range append([]string{subj}, mirrors...) {
+		if !done[t] {
+			targets = append(targets, t)
+		}
+	}
+
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	wg.Add(len(targets))
+	for i, t := range targets {
+		go func(i int, t string) {
+			defer wg.Done()
+			errs[i] = rp.request(q, t, data, hdr, timeout)
+		}(i, t)
+	}
+	wg.Wait()
+
+	var acked []string
+	var firstErr error
+	for i, err := range errs {
+		if err == nil {
+			acked = append(acked, targets[i])
+		} else if firstErr == nil || err == errClosing {
+			firstErr = err
+		}
+	}
+	return acked, firstErr
+}
+
+// ackTimeout returns how long to wait for the message to be acknowledged. The
+// timeout set on the message wins over the one set for its queue.
+func (rp *Republisher) ackTimeout(q *queue.Queue, fb *flatbuf.RequeueMessage) time.Duration {
+	if t := fb.AckTimeout(); t > 0 {
+		return time.Duration(t)
+	}
+	// Time bucketed sub-queues use the timeout of their base queue.
+	base, _ := queue.SplitBucketName(q.Name())
+	if t, ok := rp.opts.queueAckTimeouts[base]; ok {
+		return t
+	}
+	return rp.opts.ackTimeout
+}
+
+// redeliveriesExhausted returns true if the attempt that just failed was the
+// last one allowed for the queue.
+func (rp *Republisher) redeliveriesExhausted(queueName string, fb *flatbuf.RequeueMessage) bool {
+	max := rp.opts.maxRedeliveries
+	// Time bucketed sub-queues use the limit of their base queue.
+	base, _ := queue.SplitBucketName(queueName)
+	if n, ok := rp.opts.queueMaxRedeliveries[base]; ok {
+		max = n
+	}
+	return max > 0 && fb.Attempts()+1 >= max
+}
+
+// payload returns the original payload of the message as it should be
+// republished.
+func (rp *Republisher) payload(fb *flatbuf.RequeueMessage) ([]byte, error) {
+	if rp.opts.keyring != nil && len(fb.KeyId()) > 0 {
+		return protocol.DecryptPayloadWithKeyring(rp.opts.keyring, fb)
+	}
+	if rp.opts.decrypter == nil {
+		return fb.OriginalPayloadBytes(), nil
+	}
+	return protocol.DecryptPayload(rp.opts.decrypter, fb)
+}
+
 // Requeue the message to disk for a future time.
 // This should be called with a lock already held on rp.
-func (rp *Republisher) requeueMessageToDisk(rqi runQueueItem, fb *flatbuf.RequeueMessage) error {
+func (rp *Republisher) requeueMessageToDisk(rqi runQueueItem, fb *flatbuf.RequeueMessage, acked []string) error {
 	// If this process were to shut off before we requeue to disk, we could end
 	// up with zombie data on disk and won't be picked up because of our
 	// checkpoint. To solve this, we have another goroutine in the background
 	// that infrequently checks for messages that are not marked as deleted, but
 	// are before our checkpoint.
 
-	entry, err := rp.createEntry(rqi, fb)
+	entry, err := rp.createEntry(rqi, fb, acked)
 	if err != nil {
 		log.Err(err).
 			Str("msg", string(fb.OriginalPayloadBytes())).
@@ -436,6 +1014,14 @@ func (rp *Republisher) requeueMessageToDisk(rqi runQueueItem, fb *flatbuf.Requeu
 	}
 
 	return rp.db.Update(func(txn *badger.Txn) error {
+		// The message may have been removed while it was in flight, e.g.,
+		// erased, in which case it mustn't be brought back.
+		if _, err := txn.Get(rqi.queueItem.K); err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
 		// First insert our new entry
 		err := txn.SetEntry(entry)
 		if err != nil {
@@ -443,20 +1029,46 @@ func (rp *Republisher) requeueMessageToDisk(rqi runQueueItem, fb *flatbuf.Requeu
 			return err
 		}
 
+		// The index entry of the existing key is left for the sweeper.
+		idx, err := queue.ExpiryIndexEntry(entry)
+		if err != nil {
+			return err
+		}
+		if idx != nil {
+			if err := txn.SetEntry(idx); err != nil {
+				return err
+			}
+		}
+
 		// Then delete our existing key
 		err = txn.Delete(rqi.queueItem.K)
 		if err != nil {
 			return err
 		}
 
+		// Keep a coalescing queue pointing at the message under its new key.
+		if dk := fb.DedupeKey(); len(dk) > 0 {
+			return queue.UpdateCoalesceIndex(txn, rqi.runQueue.q.Name(), string(dk), rqi.queueItem.K, entry.Key)
+		}
 		return nil
 	})
 }
 
 // This should be called with a lock already held on rp.
-func (rp *Republisher) removeMessageFromDisk(qi queue.QueueItem, fb *flatbuf.RequeueMessage) error {
+func (rp *Republisher) removeMessageFromDisk(queueName string, qi queue.QueueItem, fb *flatbuf.RequeueMessage, deadLetter *badger.Entry) error {
 	err := rp.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete(qi.K)
+		if err := txn.Delete(qi.K); err != nil {
+			return err
+		}
+		if deadLetter != nil {
+			if err := txn.SetEntry(deadLetter); err != nil {
+				return err
+			}
+		}
+		if dk := fb.DedupeKey(); len(dk) > 0 {
+			return queue.UpdateCoalesceIndex(txn, queueName, string(dk), qi.K, nil)
+		}
+		return nil
 	})
 	if err != nil {
 		log.Err(err).
@@ -468,17 +1080,51 @@ func (rp *Republisher) removeMessageFromDisk(qi queue.QueueItem, fb *flatbuf.Req
 	return nil
 }
 
+// deadLetter returns the dead letter of the message that was given up on, and
+// the entry to store it under if they're being kept. Nil is returned for both
+// if no one wants it.
+func (rp *Republisher) deadLetter(rqi runQueueItem, fb *flatbuf.RequeueMessage, record protocol.TerminalRecord) (*protocol.DeadLetter, *badger.Entry) {
+	if !rp.opts.deadLetters && rp.opts.deadLetterCB == nil {
+		return nil, nil
+	}
+	k := key.Key(queue.ParseQueueKey(rqi.queueItem.K).Key)
+	dl := &protocol.DeadLetter{
+		Queue:     record.Queue,
+		Key:       k.String(),
+		MessageID: record.MessageID,
+		Subject:   record.Subject,
+		Reason:    record.Reason,
+		Error:     record.Error,
+		Attempts:  record.Attempts,
+		Message:   append([]byte(nil), rqi.queueItem.V...),
+		Time:      time.Now(),
+	}
+	if !rp.opts.deadLetters {
+		return dl, nil
+	}
+	data, err := dl.MarshalBinary()
+	if err != nil {
+		log.Err(err).
+			Str("queue", dl.Queue).
+			Str("key", dl.Key).
+			Msg("problem marshaling dead letter")
+		return dl, nil
+	}
+	return dl, queue.DeadLetterEntry(dl.Queue, k, data, rp.opts.deadLetterRetention)
+}
+
 // This should be called with a lock already held on rp.
-func (rp *Republisher) createEntry(rqi runQueueItem, fb *flatbuf.RequeueMessage) (*badger.Entry, error) {
-	// TODO: We need to change the delay based on the BackoffStrategy.
-	// for now we'll just do fixed backoff.
-	delay := time.Now().Add(time.Duration(fb.Delay()))
+func (rp *Republisher) createEntry(rqi runQueueItem, fb *flatbuf.RequeueMessage, acked []string) (*badger.Entry, error) {
+	delay := time.Now().Add(rp.nextDelay(fb))
 	persistKey := key.New(delay)
 
-	qk := queue.NewQueueKeyForMessage(protocol.GetQueueName(fb), persistKey)
+	// Requeue into the queue the message was read from rather than the one
+	// named in the message, since they differ when time bucketing is enabled.
+	qk := queue.NewQueueKeyForMessage(rqi.runQueue.q.Name(), persistKey)
 
 	// Update the message with the new retry count, ttl, etc.
-	if err := adjMsgBeforeRequeueToDisk(rqi.queueItem, fb); err != nil {
+	value, err := adjMsgBeforeRequeueToDisk(rqi.queueItem, fb, acked, rp.revision())
+	if err != nil {
 		return nil, fmt.Errorf("createEntry: %w", err)
 	}
 
@@ -488,10 +1134,48 @@ func (rp *Republisher) createEntry(rqi runQueueItem, fb *flatbuf.RequeueMessage)
 	// update the checkpoint once the run has completed.
 	rqi.runQueue.setMinCheckpoint(persistKey)
 
-	return badger.NewEntry(qk.Bytes(), rqi.queueItem.V).WithTTL(time.Duration(fb.Ttl())), nil
+	// Keep the precise time it becomes ready in step with its key.
+	if rp.opts.precision > 0 || flatbuf.GetRootAsRequeueMessage(value, 0).ReadyAt() != 0 {
+		value = protocol.SetReadyAt(value, delay)
+	}
+
+	entry := badger.NewEntry(qk.Bytes(), value)
+	if ttl := rp.storageTTL(flatbuf.GetRootAsRequeueMessage(value, 0)); ttl > 0 {
+		entry = entry.WithTTL(ttl)
+	}
+	return entry, nil
+}
+
+// storageTTL returns the TTL in the store of a message being written back to
+// disk now, whose TTL has been adjusted to what's left of it. Zero means it
+// doesn't expire in the store.
+func (rp *Republisher) storageTTL(fb *flatbuf.RequeueMessage) time.Duration {
+	if !rp.opts.storageTTL || fb.Ttl() == 0 {
+		return 0
+	}
+	return time.Duration(fb.Ttl()) + rp.opts.storageTTLGrace
+}
+
+// ready returns true if the message held by qi is ready to be republished as
+// of now. Keys only have second precision so messages with a precise ready
+// time are held until then.
+func ready(qi queue.QueueItem, now time.Time) bool {
+	readyAt := flatbuf.GetRootAsRequeueMessage(qi.V, 0).ReadyAt()
+	return readyAt == 0 || readyAt <= now.UnixNano()
+}
+
+// revision returns the revision of the envelopes to emit.
+func (rp *Republisher) revision() protocol.Revision {
+	if rp.opts.revision == nil {
+		return protocol.CurrentRevision
+	}
+	return rp.opts.revision()
 }
 
-func adjMsgBeforeRequeueToDisk(qi queue.QueueItem, fb *flatbuf.RequeueMessage) error {
+// adjMsgBeforeRequeueToDisk updates the message for its next attempt, adding
+// the subjects that were just acked, and returns the value of the revision to
+// write back to disk.
+func adjMsgBeforeRequeueToDisk(qi queue.QueueItem, fb *flatbuf.RequeueMessage, acked []string, rev protocol.Revision) ([]byte, error) {
 	// Because we just retried, subtract 1 from the number of retries left.
 	retries := fb.Retries()
 	if retries <= 1 {
@@ -499,20 +1183,50 @@ func adjMsgBeforeRequeueToDisk(qi queue.QueueItem, fb *flatbuf.RequeueMessage) e
 	}
 	ok := fb.MutateRetries(retries - 1)
 	if !ok {
-		return fmt.Errorf("unable to mutate retries on RequeueMessage flatbuffer to: %d", retries-1)
+		return nil, fmt.Errorf("unable to mutate retries on RequeueMessage flatbuffer to: %d", retries-1)
 	}
 
 	// We don't want to write the message back to disk with the same retry it
 	// had before. So this time we update the ttl that is left if there is one.
+	// The requeued message is enqueued now, which is when its TTL counts from.
 	ttl := fb.Ttl()
 	if ttl != 0 {
-		newTTL := uint64(qi.DurationUntilExpires())
+		expiresAt, _ := qi.Expiry(fb)
+		left := time.Until(expiresAt)
+		if left <= 0 {
+			// Zero would mean it never expires.
+			left = 1
+		}
+		newTTL := uint64(left)
 		ok := fb.MutateTtl(newTTL)
 		if !ok {
-			return fmt.Errorf("unable to mutate ttl on RequeueMessage flatbuffer from: %d to: %d", ttl, newTTL)
+			return nil, fmt.Errorf("unable to mutate ttl on RequeueMessage flatbuffer from: %d to: %d", ttl, newTTL)
 		}
 	}
-	return nil
+
+	// Producers don't set the attempts so the field is usually missing from
+	// the buffer and can't be mutated in place. In that case the message is
+	// rebuilt with it. Newly acked subjects always need a rebuild too.
+	// The first retry also records the id and enqueue time of the message
+	// since both are lost with its key.
+	firstRetry := rev >= protocol.Revision2 && len(fb.MessageId()) == 0
+	mutated := fb.MutateAttempts(fb.Attempts() + 1)
+	if mutated && len(acked) == 0 && !firstRetry {
+		return qi.V, nil
+	}
+	var msg protocol.RequeueMessage
+	if err := msg.UnmarshalBinary(qi.V); err != nil {
+		return nil, err
+	}
+	if !mutated {
+		msg.Attempts++
+	}
+	if id := qi.MessageID(fb); firstRetry && id != "" {
+		msg.MessageID = id
+		msg.EnqueuedAt = qi.FirstEnqueuedAt(fb).UnixNano()
+	}
+	msg.AckedSubjects = append(msg.AckedSubjects, acked...)
+	return msg.Bytes(), nil
 }
 
 // TODO: Write a test for this.
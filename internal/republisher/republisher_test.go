@@ -0,0 +1,270 @@
+package republisher
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdjMsgBeforeRequeueToDisk(t *testing.T) {
+	msg := protocol.DefaultRequeueMessage()
+	msg.Retries = 100
+	msg.OriginalSubject = "foo.bar"
+	msg.OriginalPayload = []byte("hello")
+	v := msg.Bytes()
+
+	// The first requeue adds the attempts to the message and the second
+	// mutates it in place.
+	for i := uint64(1); i <= 2; i++ {
+		var err error
+		v, err = adjMsgBeforeRequeueToDisk(queue.QueueItem{V: v}, flatbuf.GetRootAsRequeueMessage(v, 0), nil, protocol.CurrentRevision)
+		assert.NoError(t, err)
+		fb := flatbuf.GetRootAsRequeueMessage(v, 0)
+		assert.Equal(t, i, fb.Attempts())
+		assert.Equal(t, 100-i, fb.Retries())
+		assert.Equal(t, "hello", string(fb.OriginalPayloadBytes()))
+	}
+}
+
+func TestRedeliveriesExhausted(t *testing.T) {
+	opts := GetDefaultOptions()
+	assert.NoError(t, MaxRedeliveries(3)(&opts))
+	assert.NoError(t, QueueMaxRedeliveries("slow", 0)(&opts))
+	rp := &Republisher{opts: opts}
+
+	msg := protocol.DefaultRequeueMessage()
+	msg.Retries = 1000
+	msg.Attempts = 1
+	fb := flatbuf.GetRootAsRequeueMessage(msg.Bytes(), 0)
+	assert.False(t, rp.redeliveriesExhausted("default", fb))
+
+	msg.Attempts = 2
+	fb = flatbuf.GetRootAsRequeueMessage(msg.Bytes(), 0)
+	assert.True(t, rp.redeliveriesExhausted("default", fb))
+	// Sub-queues use the limit of their base queue.
+	assert.False(t, rp.redeliveriesExhausted(queue.HourlyTimeBucket.QueueName("slow", time.Now()), fb))
+}
+
+func TestAdjMsgBeforeRequeueToDiskAcked(t *testing.T) {
+	msg := protocol.DefaultRequeueMessage()
+	msg.Retries = 5
+	msg.OriginalSubject = "orders.v1"
+	v := msg.Bytes()
+
+	v, err := adjMsgBeforeRequeueToDisk(queue.QueueItem{V: v}, flatbuf.GetRootAsRequeueMessage(v, 0), []string{"orders.v2"}, protocol.CurrentRevision)
+	assert.NoError(t, err)
+	// Attempts is now in the buffer so this time it's mutated in place before
+	// the rebuild for the acked subject.
+	v, err = adjMsgBeforeRequeueToDisk(queue.QueueItem{V: v}, flatbuf.GetRootAsRequeueMessage(v, 0), []string{"orders.v1"}, protocol.CurrentRevision)
+	assert.NoError(t, err)
+
+	var got protocol.RequeueMessage
+	assert.NoError(t, got.UnmarshalBinary(v))
+	assert.Equal(t, uint64(2), got.Attempts)
+	assert.Equal(t, uint64(3), got.Retries)
+	assert.Equal(t, []string{"orders.v2", "orders.v1"}, got.AckedSubjects)
+}
+
+func TestQueueMirrorsOption(t *testing.T) {
+	opts := GetDefaultOptions()
+	assert.NoError(t, QueueMirrors("orders", "orders.v2", "audit.orders")(&opts))
+	assert.Equal(t, []string{"orders.v2", "audit.orders"}, opts.queueMirrors["orders"])
+	assert.Error(t, QueueMirrors("", "orders.v2")(&opts))
+	assert.Error(t, QueueMirrors("orders", "orders..v2")(&opts))
+	assert.Error(t, QueueMirrors("orders", "orders.*")(&opts))
+}
+
+func TestAdjMsgBeforeRequeueToDiskMessageID(t *testing.T) {
+	msg := protocol.DefaultRequeueMessage()
+	msg.Retries = 5
+	msg.Delay = uint64(time.Second)
+	v := msg.Bytes()
+
+	enqueuedAt := time.Unix(100, 0)
+	qk := queue.NewQueueKeyForMessage("default", key.FromTime(enqueuedAt.Add(time.Second)))
+	qi := queue.QueueItem{K: qk.Bytes(), V: v}
+	id := key.Key(qk.Key).String()
+
+	// The id and enqueue time of the message are kept once it moves to a new
+	// key.
+	v, err := adjMsgBeforeRequeueToDisk(qi, flatbuf.GetRootAsRequeueMessage(v, 0), nil, protocol.CurrentRevision)
+	assert.NoError(t, err)
+	qi = queue.QueueItem{K: queue.NewQueueKeyForMessage("default", key.New(time.Now())).Bytes(), V: v}
+	fb := flatbuf.GetRootAsRequeueMessage(v, 0)
+	assert.Equal(t, id, qi.MessageID(fb))
+	assert.True(t, enqueuedAt.Equal(qi.FirstEnqueuedAt(fb)))
+
+	// They aren't added to envelopes of the previous revision.
+	v, err = adjMsgBeforeRequeueToDisk(queue.QueueItem{K: qk.Bytes(), V: msg.Bytes()}, flatbuf.GetRootAsRequeueMessage(msg.Bytes(), 0), nil, protocol.Revision1)
+	assert.NoError(t, err)
+	assert.Empty(t, flatbuf.GetRootAsRequeueMessage(v, 0).MessageId())
+}
+
+func TestStorageTTL(t *testing.T) {
+	msg := protocol.DefaultRequeueMessage()
+	msg.TTL = uint64(time.Minute)
+	fb := flatbuf.GetRootAsRequeueMessage(msg.Bytes(), 0)
+
+	rp := &Republisher{opts: GetDefaultOptions()}
+	assert.Equal(t, time.Duration(0), rp.storageTTL(fb))
+
+	assert.NoError(t, StorageTTL(time.Hour)(&rp.opts))
+	assert.Equal(t, time.Hour+time.Minute, rp.storageTTL(fb))
+
+	// Messages without a TTL never expire.
+	msg.TTL = 0
+	assert.Equal(t, time.Duration(0), rp.storageTTL(flatbuf.GetRootAsRequeueMessage(msg.Bytes(), 0)))
+}
+
+func TestDeadLetter(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	msg := protocol.DefaultRequeueMessage()
+	msg.OriginalSubject = "orders.created"
+	msg.DedupeKey = "order-1"
+	k := key.New(time.Now())
+	qk := queue.NewQueueKeyForMessage("orders", k)
+	qi := queue.QueueItem{K: qk.Bytes(), V: msg.Bytes()}
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(qi.K, qi.V); err != nil {
+			return err
+		}
+		return txn.Set(queue.NewQueueKeyForCoalesce("orders", "order-1").Bytes(), qi.K)
+	}))
+	record := protocol.TerminalRecord{
+		Queue:    "orders",
+		Subject:  "orders.created",
+		State:    protocol.TerminalStateDeadLettered,
+		Reason:   protocol.TerminalReasonRetriesExhausted,
+		Error:    "nats: timeout",
+		Attempts: 1,
+	}
+	rqi := runQueueItem{queueItem: qi}
+	fb := flatbuf.GetRootAsRequeueMessage(qi.V, 0)
+
+	// Nothing is kept unless someone wants it.
+	rp := &Republisher{db: db, opts: GetDefaultOptions()}
+	dl, entry := rp.deadLetter(rqi, fb, record)
+	assert.Nil(t, dl)
+	assert.Nil(t, entry)
+
+	assert.NoError(t, DeadLetterHandler(func(protocol.DeadLetter) {})(&rp.opts))
+	dl, entry = rp.deadLetter(rqi, fb, record)
+	assert.NotNil(t, dl)
+	assert.Nil(t, entry)
+
+	assert.Error(t, DeadLetterQueue(-time.Second)(&rp.opts))
+	assert.NoError(t, DeadLetterQueue(time.Hour)(&rp.opts))
+	dl, entry = rp.deadLetter(rqi, fb, record)
+	if assert.NotNil(t, dl) && assert.NotNil(t, entry) {
+		assert.Equal(t, k.String(), dl.Key)
+		assert.Equal(t, protocol.TerminalReasonRetriesExhausted, dl.Reason)
+		assert.Equal(t, msg.Bytes(), dl.Message)
+	}
+
+	// The message is moved to the dead letters along with its index entry.
+	assert.NoError(t, rp.removeMessageFromDisk("orders", qi, fb, entry))
+	assert.NoError(t, db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(qi.K)
+		assert.Equal(t, badger.ErrKeyNotFound, err)
+		_, err = txn.Get(queue.NewQueueKeyForCoalesce("orders", "order-1").Bytes())
+		assert.Equal(t, badger.ErrKeyNotFound, err)
+		return nil
+	}))
+	var stored []protocol.DeadLetter
+	assert.NoError(t, queue.RangeDeadLetters(db, "orders", func(qi queue.QueueItem) bool {
+		var dl protocol.DeadLetter
+		assert.NoError(t, dl.UnmarshalBinary(qi.V))
+		stored = append(stored, dl)
+		return true
+	}))
+	if assert.Len(t, stored, 1) {
+		assert.Equal(t, dl.Key, stored[0].Key)
+		assert.Equal(t, dl.Message, stored[0].Message)
+	}
+}
+
+func TestRescanOnStart(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m, err := queue.NewManager(db)
+	assert.NoError(t, err)
+	defer m.Close()
+	q, err := m.CreateQueue(queue.NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+	cp := queue.NewQueueKeyForMessage("orders", key.New(time.Now())).Bytes()
+	assert.NoError(t, q.UpdateCheckpoint(cp))
+
+	opts := GetDefaultOptions()
+	assert.NoError(t, RescanOnStart()(&opts))
+	assert.True(t, opts.rescan)
+
+	rp := &Republisher{qManager: m, opts: opts}
+	assert.NoError(t, rp.resetCheckpoints())
+	assert.Equal(t, 0, q.CompareCheckpoint(queue.FirstMessage("orders").Bytes()))
+}
+
+func TestDeadLetterDecryptFailed(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m, err := queue.NewManager(db)
+	assert.NoError(t, err)
+	defer m.Close()
+	q, err := m.CreateQueue(queue.NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+
+	// The key of the message isn't in the keyring.
+	msg := protocol.DefaultRequeueMessage()
+	msg.OriginalSubject = "orders.created"
+	msg.KeyID = "orders-1"
+	msg.Retries = 10
+	qi := queue.QueueItem{K: queue.NewQueueKeyForMessage("orders", key.New(time.Now())).Bytes(), V: msg.Bytes()}
+	assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+		return txn.Set(qi.K, qi.V)
+	}))
+
+	opts := GetDefaultOptions()
+	assert.NoError(t, PayloadKeyring(nopKeyring{})(&opts))
+	dead := make(chan protocol.DeadLetter, 1)
+	assert.NoError(t, DeadLetterHandler(func(dl protocol.DeadLetter) {
+		dead <- dl
+	})(&opts))
+	rp := &Republisher{db: db, qManager: m, opts: opts}
+
+	// It's dead lettered without spending any of its retries.
+	ch := make(chan runQueueItem, 1)
+	ch <- runQueueItem{queueItem: qi, runQueue: &runQueue{q: q}}
+	close(ch)
+	rp.publishMessages(ch)
+	select {
+	case dl := <-dead:
+		assert.Equal(t, protocol.TerminalReasonDecryptFailed, dl.Reason)
+		assert.NotEmpty(t, dl.Error)
+		assert.Equal(t, uint64(0), dl.Attempts)
+	default:
+		t.Fatal("the message wasn't dead lettered")
+	}
+	assert.NoError(t, db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(qi.K)
+		assert.Equal(t, badger.ErrKeyNotFound, err)
+		return nil
+	}))
+}
@@ -0,0 +1,90 @@
+package republisher
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// noRunScheduled is the value of nextRun before the first run is scheduled.
+const noRunScheduled = math.MaxInt64
+
+// schedule runs republish whenever the next message is due rather than on a
+// fixed interval, sleeping in between. It never sleeps longer than the
+// republish interval, and runs are at least the min republish interval apart
+// so a burst of wakeups is coalesced into a single run.
+func (rp *Republisher) schedule() {
+	// Start with a run to pick up anything that came due while we were down.
+	wait := time.Duration(0)
+	var lastRun time.Time
+	for {
+		t := time.NewTimer(wait)
+		select {
+		case <-rp.quit:
+			t.Stop()
+			return
+		case <-t.C:
+		case <-rp.wake:
+			t.Stop()
+		}
+
+		if d := rp.opts.minPubInterval - time.Since(lastRun); d > 0 {
+			select {
+			case <-rp.quit:
+				return
+			case <-time.After(d):
+			}
+		}
+		lastRun = time.Now()
+		// Wakeups up to now are covered by this run.
+		select {
+		case <-rp.wake:
+		default:
+		}
+
+		// Messages committed during the run may have been missed by it, so
+		// anything ready before the longest we'd sleep has to wake us.
+		atomic.StoreInt64(&rp.nextRun, time.Now().Add(rp.opts.pubInterval).UnixNano())
+		rp.republish()
+
+		next := rp.nextWakeup(time.Now())
+		atomic.StoreInt64(&rp.nextRun, next.UnixNano())
+		wait = time.Until(next)
+	}
+}
+
+// nextWakeup returns when the next message in any of the queues becomes
+// ready, or the longest we'd sleep if that's sooner.
+func (rp *Republisher) nextWakeup(now time.Time) time.Time {
+	next := now.Add(rp.opts.pubInterval)
+	for _, q := range rp.qManager.Queues() {
+		t, ok, err := q.NextReadyAt()
+		if err != nil {
+			log.Err(err).Str("queue", q.Name()).Msg("republisher: problem finding the next message")
+			continue
+		}
+		if ok && t.Before(next) {
+			next = t
+		}
+	}
+	if next.Before(now) {
+		return now
+	}
+	return next
+}
+
+// Notify lets the republisher know a message that becomes ready at readyAt
+// has been committed, so it wakes up for it if it wasn't going to before
+// then.
+func (rp *Republisher) Notify(readyAt time.Time) {
+	if readyAt.UnixNano() >= atomic.LoadInt64(&rp.nextRun) {
+		return
+	}
+	select {
+	case rp.wake <- struct{}{}:
+	default:
+		// A wakeup is already pending.
+	}
+}
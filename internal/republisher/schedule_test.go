@@ -0,0 +1,163 @@
+package republisher
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextWakeup(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m, err := queue.NewManager(db)
+	assert.NoError(t, err)
+	defer m.Close()
+
+	rp := &Republisher{qManager: m, opts: GetDefaultOptions()}
+	now := time.Now().Truncate(time.Second)
+
+	// With nothing queued we sleep for the republish interval.
+	assert.Equal(t, now.Add(DefaultRepublisherInterval), rp.nextWakeup(now))
+
+	add := func(name string, readyAt time.Time) {
+		qk := queue.NewQueueKeyForMessage(name, key.New(readyAt))
+		_, err := m.CreateQueue(qk)
+		assert.NoError(t, err)
+		assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+			return txn.Set(qk.Bytes(), []byte("message"))
+		}))
+	}
+	add("orders", now.Add(time.Hour))
+	assert.Equal(t, now.Add(DefaultRepublisherInterval), rp.nextWakeup(now))
+
+	// The soonest message across the queues wakes us.
+	add("orders", now.Add(10*time.Second))
+	add("invoices", now.Add(5*time.Second))
+	assert.True(t, now.Add(5*time.Second).Equal(rp.nextWakeup(now)))
+
+	// Messages already due wake us right away.
+	add("invoices", now.Add(-time.Minute))
+	assert.Equal(t, now, rp.nextWakeup(now))
+}
+
+func TestNotify(t *testing.T) {
+	rp := &Republisher{nextRun: noRunScheduled, wake: make(chan struct{}, 1)}
+	now := time.Now()
+
+	// Wakeups are coalesced.
+	rp.Notify(now)
+	rp.Notify(now)
+	assert.Len(t, rp.wake, 1)
+	<-rp.wake
+
+	// Messages ready after the next run don't wake us.
+	rp.nextRun = now.UnixNano()
+	rp.Notify(now.Add(time.Second))
+	assert.Len(t, rp.wake, 0)
+	rp.Notify(now.Add(-time.Second))
+	assert.Len(t, rp.wake, 1)
+}
+
+func TestPrecisionOption(t *testing.T) {
+	o := GetDefaultOptions()
+	assert.Error(t, Precision(0)(&o))
+
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m, err := queue.NewManager(db)
+	assert.NoError(t, err)
+	defer m.Close()
+
+	rp, err := New(nil, db, m, Precision(50*time.Millisecond))
+	assert.NoError(t, err)
+	defer rp.Close()
+	// Runs are close enough together for the tolerance.
+	assert.Equal(t, 50*time.Millisecond, rp.opts.minPubInterval)
+}
+
+// TestDeliveryWithinTolerance drives the scheduler with a fake clock to show
+// messages are never republished early and never later than the tolerance,
+// wherever in the second of their key they become ready.
+func TestDeliveryWithinTolerance(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions("").
+		WithInMemory(true).
+		WithLoggingLevel(badger.ERROR))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m, err := queue.NewManager(db)
+	assert.NoError(t, err)
+	defer m.Close()
+
+	tolerance := 250 * time.Millisecond
+	rp := &Republisher{qManager: m, quit: make(chan struct{})}
+	rp.opts = GetDefaultOptions()
+	assert.NoError(t, Precision(tolerance)(&rp.opts))
+	rp.opts.minPubInterval = tolerance
+
+	base := time.Unix(1600000000, 0)
+	readyAts := make(map[string]time.Time)
+	for _, offset := range []time.Duration{
+		0,
+		time.Nanosecond,
+		130 * time.Millisecond,
+		700 * time.Millisecond,
+		999 * time.Millisecond,
+		1500 * time.Millisecond,
+		2250 * time.Millisecond,
+		10 * time.Second,
+	} {
+		readyAt := base.Add(offset)
+		qk := queue.NewQueueKeyForMessage("orders", key.New(readyAt))
+		_, err := m.CreateQueue(qk)
+		assert.NoError(t, err)
+		msg := protocol.DefaultRequeueMessage()
+		msg.ReadyAt = readyAt.UnixNano()
+		assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+			return txn.Set(qk.Bytes(), msg.Bytes())
+		}))
+		readyAts[string(qk.Bytes())] = readyAt
+	}
+	q, _ := m.GetQueue("orders")
+
+	now := base.Add(-time.Second)
+	var lastRun time.Time
+	for i := 0; len(readyAts) > 0; i++ {
+		if i > 1000 {
+			t.Fatal("scheduler stopped making progress")
+		}
+		// Sleep until the next wakeup, no sooner than the min interval.
+		now = rp.nextWakeup(now)
+		if min := lastRun.Add(rp.opts.minPubInterval); now.Before(min) {
+			now = min
+		}
+		lastRun = now
+
+		ch := make(chan runQueueItem, len(readyAts))
+		rp.processQueue(&runQueue{q: q}, ch, now)
+		close(ch)
+		for rqi := range ch {
+			readyAt, ok := readyAts[string(rqi.queueItem.K)]
+			assert.True(t, ok)
+			assert.False(t, now.Before(readyAt), "republished %s early", readyAt.Sub(now))
+			assert.LessOrEqual(t, int64(now.Sub(readyAt)), int64(tolerance), "republished %s late", now.Sub(readyAt))
+			assert.NoError(t, db.Update(func(txn *badger.Txn) error {
+				return txn.Delete(rqi.queueItem.K)
+			}))
+			delete(readyAts, string(rqi.queueItem.K))
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package republisher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// subjectTranslation maps the subjects matching from onto to. The tokens the
+// wildcards in from match are put in place of the wildcards in to, in order.
+type subjectTranslation struct {
+	from []string
+	to   []string
+}
+
+// SubjectTranslations republishes the messages whose original subject matches
+// one of the keys on the subject it maps to instead, e.g., when bridging to a
+// cluster where the subjects are named differently. The keys may have
+// wildcards, and the tokens they match are put in place of the same wildcards
+// in the subject they map to, so "orders.*.>" can map to "east.orders.*.>".
+// The most specific key that matches wins. Messages whose subject matches none
+// of them are republished on their original subject.
+func SubjectTranslations(translations map[string]string) Option {
+	return func(o *Options) error {
+		ts, err := newSubjectTranslations(translations)
+		if err != nil {
+			return err
+		}
+		o.translations = ts
+		return nil
+	}
+}
+
+// ValidateSubjectTranslations returns an error if the translations can't be
+// used with SubjectTranslations.
+func ValidateSubjectTranslations(translations map[string]string) error {
+	_, err := newSubjectTranslations(translations)
+	return err
+}
+
+func newSubjectTranslations(translations map[string]string) ([]subjectTranslation, error) {
+	ts := make([]subjectTranslation, 0, len(translations))
+	for from, to := range translations {
+		if err := protocol.ValidateSubject(from); err != nil {
+			return nil, fmt.Errorf("subject translations: %w", err)
+		}
+		if err := protocol.ValidateSubject(to); err != nil {
+			return nil, fmt.Errorf("subject translations: %s: %w", from, err)
+		}
+		t := subjectTranslation{
+			from: strings.Split(from, "."),
+			to:   strings.Split(to, "."),
+		}
+		fromStars, fromTail := wildcards(t.from)
+		toStars, toTail := wildcards(t.to)
+		if (toStars > 0 || toTail) && (toStars != fromStars || toTail != fromTail) {
+			return nil, fmt.Errorf("subject translations: %s: %q must have the same wildcards or none", from, to)
+		}
+		ts = append(ts, t)
+	}
+
+	// Literal tokens are more specific than a *, which is more specific than
+	// a >.
+	sort.Slice(ts, func(i, j int) bool {
+		a, b := ts[i].from, ts[j].from
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if ra, rb := tokenRank(a[k]), tokenRank(b[k]); ra != rb {
+				return ra < rb
+			}
+		}
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return strings.Join(a, ".") < strings.Join(b, ".")
+	})
+	return ts, nil
+}
+
+// wildcards returns the number of * tokens and whether there's a >.
+func wildcards(tokens []string) (stars int, tail bool) {
+	for _, t := range tokens {
+		switch t {
+		case "*":
+			stars++
+		case ">":
+			tail = true
+		}
+	}
+	return stars, tail
+}
+
+func tokenRank(t string) int {
+	switch t {
+	case ">":
+		return 2
+	case "*":
+		return 1
+	}
+	return 0
+}
+
+// translate returns the subject to republish a message with the original
+// subject on.
+func (rp *Republisher) translate(subject string) string {
+	if len(rp.opts.translations) == 0 {
+		return subject
+	}
+	tokens := strings.Split(subject, ".")
+	for _, t := range rp.opts.translations {
+		if s, ok := t.apply(tokens); ok {
+			return s
+		}
+	}
+	return subject
+}
+
+// apply returns the translation of the subject tokens, or false if they don't
+// match.
+func (t subjectTranslation) apply(tokens []string) (string, bool) {
+	var stars []string
+	var tail string
+	for i, f := range t.from {
+		if f == ">" {
+			if i >= len(tokens) {
+				return "", false
+			}
+			tail = strings.Join(tokens[i:], ".")
+			break
+		}
+		if i >= len(tokens) || (f != "*" && f != tokens[i]) {
+			return "", false
+		}
+		if f == "*" {
+			stars = append(stars, tokens[i])
+		}
+		if i == len(t.from)-1 && len(tokens) != len(t.from) {
+			return "", false
+		}
+	}
+
+	out := make([]string, 0, len(t.to)+len(tokens))
+	for _, to := range t.to {
+		switch to {
+		case "*":
+			out = append(out, stars[0])
+			stars = stars[1:]
+		case ">":
+			out = append(out, tail)
+		default:
+			out = append(out, to)
+		}
+	}
+	return strings.Join(out, "."), true
+}
@@ -0,0 +1,48 @@
+package republisher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubjectTranslations(t *testing.T) {
+	opts := GetDefaultOptions()
+	assert.NoError(t, SubjectTranslations(map[string]string{
+		"orders.created":   "east.orders.new",
+		"orders.*":         "east.orders.*",
+		"orders.>":         "east.archive",
+		"users.*.events.>": "east.*.users.>",
+		"*.audit":          "audit.*",
+	})(&opts))
+	rp := &Republisher{opts: opts}
+
+	for subject, want := range map[string]string{
+		// The most specific translation wins.
+		"orders.created":          "east.orders.new",
+		"orders.updated":          "east.orders.updated",
+		"orders.updated.v2":       "east.archive",
+		"users.42.events.a.b":     "east.42.users.a.b",
+		"billing.audit":           "audit.billing",
+		"users.42.events":         "users.42.events",
+		"orders":                  "orders",
+		"payments.captured.audit": "payments.captured.audit",
+	} {
+		assert.Equal(t, want, rp.translate(subject), subject)
+	}
+
+	// Without translations the subject is left alone.
+	rp = &Republisher{opts: GetDefaultOptions()}
+	assert.Equal(t, "orders.created", rp.translate("orders.created"))
+
+	for _, translations := range []map[string]string{
+		{"orders..created": "east.orders"},
+		{"orders.*": "east..orders"},
+		{"orders.*": "east.*.*"},
+		{"orders.*": "east.>"},
+		{"orders.>": "east.*"},
+	} {
+		assert.Error(t, ValidateSubjectTranslations(translations), translations)
+	}
+	assert.NoError(t, ValidateSubjectTranslations(map[string]string{"orders.*": "east.orders"}))
+}
@@ -0,0 +1,114 @@
+package statspub
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/nickpoorman/nats-requeue/internal/topn"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// DefaultTrackedSubjects is how many original subjects are tracked for the
+// top ingested and republished counts. This bounds the memory used no matter
+// how many distinct subjects there are.
+const DefaultTrackedSubjects = 256
+
+// Counters are instance wide counters that are included in the stats. They
+// are kept by whoever observes the events, e.g., ingest, and read by the
+// StatsPublisher.
+type Counters struct {
+	mu       sync.Mutex
+	rejected map[protocol.NakReason]int64
+
+	expired int64
+
+	ingested    *topn.TopN
+	republished *topn.TopN
+	noReply     *topn.TopN
+}
+
+func NewCounters() *Counters {
+	return &Counters{
+		rejected:    make(map[protocol.NakReason]int64),
+		ingested:    topn.New(DefaultTrackedSubjects),
+		republished: topn.New(DefaultTrackedSubjects),
+		noReply:     topn.New(DefaultTrackedSubjects),
+	}
+}
+
+// AddRejected counts a message rejected at ingest for the reason.
+func (c *Counters) AddRejected(reason protocol.NakReason) {
+	c.mu.Lock()
+	c.rejected[reason]++
+	c.mu.Unlock()
+}
+
+// Rejected returns a snapshot of the rejected counts.
+func (c *Counters) Rejected() protocol.ReasonCounts {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.rejected) == 0 {
+		return nil
+	}
+	out := make(protocol.ReasonCounts, len(c.rejected))
+	for k, v := range c.rejected {
+		out[string(k)] = v
+	}
+	return out
+}
+
+// AddExpired counts n messages removed from a queue because their TTL elapsed.
+func (c *Counters) AddExpired(n int64) {
+	atomic.AddInt64(&c.expired, n)
+}
+
+// Expired returns the number of messages removed because their TTL elapsed.
+func (c *Counters) Expired() int64 {
+	return atomic.LoadInt64(&c.expired)
+}
+
+// AddIngested counts a message for the original subject persisted at ingest.
+func (c *Counters) AddIngested(subject string) {
+	c.ingested.Add(subject, 1)
+}
+
+// AddRepublished counts a message successfully republished to the original
+// subject.
+func (c *Counters) AddRepublished(subject string) {
+	c.republished.Add(subject, 1)
+}
+
+// AddNoReply counts a message for the original subject ingested without a
+// reply subject, so it couldn't be acknowledged.
+func (c *Counters) AddNoReply(subject string) {
+	c.noReply.Add(subject, 1)
+}
+
+// TopIngested returns up to n of the original subjects with the most messages
+// ingested, most first.
+func (c *Counters) TopIngested(n int) protocol.SubjectCounts {
+	return subjectCounts(c.ingested.Top(n))
+}
+
+// TopRepublished returns up to n of the original subjects with the most
+// messages republished, most first.
+func (c *Counters) TopRepublished(n int) protocol.SubjectCounts {
+	return subjectCounts(c.republished.Top(n))
+}
+
+// TopNoReply returns up to n of the original subjects with the most messages
+// ingested without a reply subject, most first.
+func (c *Counters) TopNoReply(n int) protocol.SubjectCounts {
+	return subjectCounts(c.noReply.Top(n))
+}
+
+func subjectCounts(entries []topn.Entry) protocol.SubjectCounts {
+	if len(entries) == 0 {
+		return nil
+	}
+	out := make(protocol.SubjectCounts, len(entries))
+	for i, e := range entries {
+		out[i] = protocol.SubjectCount{Subject: e.Key, Count: e.Count}
+	}
+	return out
+}
@@ -0,0 +1,46 @@
+package statspub
+
+import (
+	"testing"
+
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountersRejected(t *testing.T) {
+	c := NewCounters()
+	assert.Nil(t, c.Rejected())
+
+	c.AddRejected(protocol.NakReasonPayloadTooLarge)
+	c.AddRejected(protocol.NakReasonPayloadTooLarge)
+	assert.Equal(t, protocol.ReasonCounts{string(protocol.NakReasonPayloadTooLarge): 2}, c.Rejected())
+}
+
+func TestCountersExpired(t *testing.T) {
+	c := NewCounters()
+	assert.Equal(t, int64(0), c.Expired())
+
+	c.AddExpired(2)
+	c.AddExpired(1)
+	assert.Equal(t, int64(3), c.Expired())
+}
+
+func TestCountersTopSubjects(t *testing.T) {
+	c := NewCounters()
+	assert.Nil(t, c.TopIngested(10))
+
+	for i := 0; i < 3; i++ {
+		c.AddIngested("orders.created")
+	}
+	c.AddIngested("orders.paid")
+	c.AddRepublished("orders.paid")
+	c.AddNoReply("metrics.cpu")
+
+	assert.Equal(t, protocol.SubjectCounts{
+		{Subject: "orders.created", Count: 3},
+		{Subject: "orders.paid", Count: 1},
+	}, c.TopIngested(10))
+	assert.Equal(t, protocol.SubjectCounts{{Subject: "orders.created", Count: 3}}, c.TopIngested(1))
+	assert.Equal(t, protocol.SubjectCounts{{Subject: "orders.paid", Count: 1}}, c.TopRepublished(10))
+	assert.Equal(t, protocol.SubjectCounts{{Subject: "metrics.cpu", Count: 1}}, c.TopNoReply(10))
+}
@@ -1,10 +1,13 @@
 package statspub
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
+	badger "github.com/dgraph-io/badger/v2"
 	"github.com/nats-io/nats.go"
+	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
 	"github.com/nickpoorman/nats-requeue/internal/queue"
 	"github.com/nickpoorman/nats-requeue/internal/ticker"
 	"github.com/nickpoorman/nats-requeue/protocol"
@@ -14,6 +17,10 @@ import (
 const (
 	DefaultStatsPublisherInterval = 5 * time.Second
 	StatsSubject                  = "_requeue._stats"
+
+	// DefaultTopSubjects is how many of the top original subjects are
+	// included in the stats.
+	DefaultTopSubjects = 10
 )
 
 // Options can be used to set custom options for a StatsPublisher.
@@ -21,14 +28,85 @@ type Options struct {
 	// On this interval, the queue will be scanned for messages
 	// that are ready to be published.
 	pubInterval time.Duration
+
+	// Options for the publish ticker, i.e., alignment and jitter.
+	tickerOpts []ticker.Option
+
+	// The encodings the stats will be published with.
+	encodings []protocol.Encoding
+
+	// Also publish the stats on the fleet subjects.
+	fleetStats bool
+
+	// When set, the internal metrics of the store are included in the stats.
+	db *badger.DB
+
+	// When set, what the value log GC reclaimed is included in the stats.
+	vlogGC *badgerInternal.ValueLogGC
+
+	// The subject the stats are published on.
+	subject string
+
+	// The prefix of the request and fleet subjects.
+	subjectPrefix string
+
+	// Static labels included in every stats payload.
+	labels protocol.Labels
+
+	// Instance wide counters included in the stats.
+	counters *Counters
+
+	// How many of the top original subjects are included in the stats.
+	topSubjects int
+
+	// The version of requeue and the features of the instance included in
+	// the stats.
+	version  string
+	features protocol.Features
+
+	// Returns the revision of the stats to publish. The CurrentRevision is
+	// used when it's nil.
+	revision func() protocol.Revision
+
+	// Returns the progress of the current, or last, key rotation, if any.
+	keyRotation func() *protocol.KeyRotation
+
+	// Returns the state of the sampling, if it's configured.
+	sampling func() *protocol.Sampling
+
+	// Returns the progress of the long running operations, if any.
+	operations func() protocol.Operations
 }
 
 func OptionsDefault() Options {
 	return Options{
-		pubInterval: DefaultStatsPublisherInterval,
+		pubInterval:   DefaultStatsPublisherInterval,
+		encodings:     []protocol.Encoding{protocol.EncodingFlatbuf},
+		subject:       StatsSubject,
+		subjectPrefix: protocol.StatsSubjectPrefix,
+		topSubjects:   DefaultTopSubjects,
 	}
 }
 
+// ApplyOptions applies the options to the defaults.
+func ApplyOptions(options ...Option) (Options, error) {
+	opts := OptionsDefault()
+	for _, opt := range options {
+		if opt != nil {
+			if err := opt(&opts); err != nil {
+				return opts, err
+			}
+		}
+	}
+	return opts, nil
+}
+
+// Subjects returns the subject the stats are published on and the prefix of
+// the request and fleet subjects.
+func (o Options) Subjects() (subject, prefix string) {
+	return o.subject, o.subjectPrefix
+}
+
 // Option is a function on the options for a StatsPublisher.
 type Option func(*Options) error
 
@@ -40,25 +118,193 @@ func StatsPublishInterval(interval time.Duration) Option {
 	}
 }
 
+// StatsPublishAligned aligns publishing to multiples of the interval on the
+// wall clock, e.g., every minute on the minute.
+func StatsPublishAligned() Option {
+	return func(o *Options) error {
+		o.tickerOpts = append(o.tickerOpts, ticker.Aligned())
+		return nil
+	}
+}
+
+// StatsPublishJitter offsets publishing by a random amount, up to max, chosen
+// once per instance so a large fleet doesn't publish in bursts.
+func StatsPublishJitter(max time.Duration) Option {
+	return func(o *Options) error {
+		o.tickerOpts = append(o.tickerOpts, ticker.Jitter(max))
+		return nil
+	}
+}
+
+// StatsEncodings sets the encodings the stats will be published with. Each
+// encoding is published on its own subject, i.e., JSON is published on the
+// stats subject with a .json suffix.
+func StatsEncodings(encodings ...protocol.Encoding) Option {
+	return func(o *Options) error {
+		if len(encodings) == 0 {
+			return fmt.Errorf("at least one stats encoding is required")
+		}
+		o.encodings = encodings
+		return nil
+	}
+}
+
+// FleetStats publishes the stats on the fleet subjects in addition to the
+// stats subject. The stats for the instance are published on
+// protocol.InstanceStatsSubject and the stats for each queue are published,
+// tagged with the instance id, on protocol.QueueStatsSubject.
+func FleetStats(enabled bool) Option {
+	return func(o *Options) error {
+		o.fleetStats = enabled
+		return nil
+	}
+}
+
+// StorageMetrics includes the internal metrics of the Badger store, such as
+// the LSM and value log sizes, in the published stats.
+func StorageMetrics(db *badger.DB) Option {
+	return func(o *Options) error {
+		o.db = db
+		return nil
+	}
+}
+
+// ValueLogGCMetrics includes the number of value log GC runs and the bytes
+// they reclaimed in the storage stats.
+func ValueLogGCMetrics(gc *badgerInternal.ValueLogGC) Option {
+	return func(o *Options) error {
+		o.vlogGC = gc
+		return nil
+	}
+}
+
+// Subject sets the subject the stats are published on. The default is
+// StatsSubject.
+func Subject(subject string) Option {
+	return func(o *Options) error {
+		if subject == "" {
+			return fmt.Errorf("stats subject cannot be empty")
+		}
+		o.subject = subject
+		return nil
+	}
+}
+
+// SubjectPrefix sets the prefix of the stats request and fleet subjects,
+// including the trailing separator, e.g., "prod.requeue.stats.". The default
+// is protocol.StatsSubjectPrefix.
+func SubjectPrefix(prefix string) Option {
+	return func(o *Options) error {
+		if prefix == "" {
+			return fmt.Errorf("stats subject prefix cannot be empty")
+		}
+		o.subjectPrefix = prefix
+		return nil
+	}
+}
+
+// ServerVersion includes the version of requeue and the features the
+// instance supports in the stats.
+func ServerVersion(version string, features protocol.Features) Option {
+	return func(o *Options) error {
+		o.version = version
+		o.features = features
+		return nil
+	}
+}
+
+// EmitRevision sets a function returning the revision of the stats to
+// publish, so instances that haven't been upgraded yet can read them.
+func EmitRevision(f func() protocol.Revision) Option {
+	return func(o *Options) error {
+		o.revision = f
+		return nil
+	}
+}
+
+// Labels sets static labels, e.g., region, environment, or team, that are
+// included in every stats payload.
+func Labels(labels map[string]string) Option {
+	return func(o *Options) error {
+		o.labels = labels
+		return nil
+	}
+}
+
+// InstanceCounters includes the instance wide counters, such as the number of
+// rejected messages, in the stats.
+func InstanceCounters(counters *Counters) Option {
+	return func(o *Options) error {
+		o.counters = counters
+		return nil
+	}
+}
+
+// TopSubjects sets how many of the original subjects with the most messages
+// ingested, republished, and ingested without a reply subject are included in
+// the stats. Zero leaves them out.
+func TopSubjects(n int) Option {
+	return func(o *Options) error {
+		if n < 0 {
+			return fmt.Errorf("top subjects cannot be negative: %d", n)
+		}
+		o.topSubjects = n
+		return nil
+	}
+}
+
+// KeyRotationProgress sets a function returning the progress of the current,
+// or last, key rotation to include in the stats. It returns nil if there
+// hasn't been one.
+func KeyRotationProgress(f func() *protocol.KeyRotation) Option {
+	return func(o *Options) error {
+		o.keyRotation = f
+		return nil
+	}
+}
+
+// OperationsProgress sets a function returning the progress of the long
+// running operations that are running or finished recently to include in the
+// stats.
+func OperationsProgress(f func() protocol.Operations) Option {
+	return func(o *Options) error {
+		o.operations = f
+		return nil
+	}
+}
+
+// SamplingState sets a function returning the state of the sampling of the
+// per-message instrumentation to include in the stats. It returns nil if
+// sampling isn't configured.
+func SamplingState(f func() *protocol.Sampling) Option {
+	return func(o *Options) error {
+		o.sampling = f
+		return nil
+	}
+}
+
 type StatsPublisher struct {
-	qManager   *queue.Manager
+	// Guards the queue manager and the store, which are replaced if the
+	// store fails over.
+	mu       sync.RWMutex
+	qManager *queue.Manager
+
 	nc         *nats.Conn
 	instanceId string
 
 	opts Options
 
+	// Subscriptions answering on-demand stats requests.
+	reqSubs []*nats.Subscription
+
 	quit chan struct{}
 	done chan struct{}
 }
 
 func NewStatsPublisher(nc *nats.Conn, qManager *queue.Manager, instanceId string, options ...Option) (*StatsPublisher, error) {
-	opts := OptionsDefault()
-	for _, opt := range options {
-		if opt != nil {
-			if err := opt(&opts); err != nil {
-				return nil, err
-			}
-		}
+	opts, err := ApplyOptions(options...)
+	if err != nil {
+		return nil, err
 	}
 
 	rq := &StatsPublisher{
@@ -69,6 +315,9 @@ func NewStatsPublisher(nc *nats.Conn, qManager *queue.Manager, instanceId string
 		quit:       make(chan struct{}),
 		done:       make(chan struct{}),
 	}
+	if err := rq.subscribeRequests(); err != nil {
+		return nil, err
+	}
 	go rq.initBackgroundTasks()
 
 	return rq, nil
@@ -85,7 +334,7 @@ func (sp *StatsPublisher) initBackgroundTasks() {
 	// republish loop
 	go func() {
 		defer wg.Done()
-		t := ticker.New(sp.opts.pubInterval)
+		t := ticker.New(sp.opts.pubInterval, sp.opts.tickerOpts...)
 		go func() {
 			<-sp.quit
 			t.Stop()
@@ -97,15 +346,87 @@ func (sp *StatsPublisher) initBackgroundTasks() {
 	}()
 }
 
+// subscribeRequests answers stats requests for this instance with a fresh
+// snapshot so stats can be pulled instead of waiting for the next publish.
+// Requests are answered with the encoding of the subject they were made on.
+func (sp *StatsPublisher) subscribeRequests() error {
+	subject := protocol.StatsRequestSubject(sp.opts.subjectPrefix, sp.instanceId)
+	for _, enc := range []protocol.Encoding{protocol.EncodingFlatbuf, protocol.EncodingJSON} {
+		sub, err := sp.nc.Subscribe(enc.Subject(subject), sp.handleRequest)
+		if err != nil {
+			sp.unsubscribeRequests()
+			return fmt.Errorf("subscribe to stats requests: %w", err)
+		}
+		sp.reqSubs = append(sp.reqSubs, sub)
+	}
+	return nil
+}
+
+func (sp *StatsPublisher) unsubscribeRequests() {
+	for _, sub := range sp.reqSubs {
+		if err := sub.Unsubscribe(); err != nil {
+			log.Err(err).Msg("problem unsubscribing from stats requests")
+		}
+	}
+	sp.reqSubs = nil
+}
+
+func (sp *StatsPublisher) handleRequest(msg *nats.Msg) {
+	ism := sp.snapshot()
+	data, err := ism.Encode(protocol.EncodingOfSubject(msg.Subject))
+	if err != nil {
+		log.Err(err).Msg("problem encoding stats")
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Err(err).Msg("problem responding to stats request")
+	}
+}
+
+// SetStore has the stats collected from the queues of qManager, and the store
+// db if its metrics are included, from now on, e.g., after the store failed
+// over to another data dir.
+func (sp *StatsPublisher) SetStore(qManager *queue.Manager, db *badger.DB) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.qManager = qManager
+	if sp.opts.db != nil {
+		sp.opts.db = db
+	}
+}
+
 func (sp *StatsPublisher) Close() {
+	sp.unsubscribeRequests()
 	close(sp.quit)
 	<-sp.done
 }
 
-func (sp *StatsPublisher) publish() error {
-	log.Debug().Msg("StatsPublisher: publish: triggered.")
+// publishFleet publishes the stats on the fleet subjects. data is ism
+// already encoded with enc.
+func (sp *StatsPublisher) publishFleet(enc protocol.Encoding, ism *protocol.InstanceStatsMessage, data []byte) {
+	if err := sp.nc.Publish(enc.Subject(protocol.InstanceStatsSubject(sp.opts.subjectPrefix, sp.instanceId)), data); err != nil {
+		log.Err(err).Msg("problem publishing instance stats")
+	}
+	for _, q := range ism.Queues {
+		q.InstanceId = sp.instanceId
+		q.Labels = sp.opts.labels
+		qData, err := q.Encode(enc)
+		if err != nil {
+			log.Err(err).Msg("problem encoding queue stats")
+			continue
+		}
+		if err := sp.nc.Publish(enc.Subject(protocol.QueueStatsSubject(sp.opts.subjectPrefix, q.QueueName)), qData); err != nil {
+			log.Err(err).Str("queue", q.QueueName).Msg("problem publishing queue stats")
+		}
+	}
+}
 
-	queues := sp.qManager.Queues()
+// snapshot collects the current stats for all the queues.
+func (sp *StatsPublisher) snapshot() *protocol.InstanceStatsMessage {
+	sp.mu.RLock()
+	qManager, db := sp.qManager, sp.opts.db
+	sp.mu.RUnlock()
+	queues := qManager.Queues()
 
 	log.Debug().Interface("queues", queues).Msg("StatsPublisher: publish: got queues.")
 
@@ -118,13 +439,60 @@ func (sp *StatsPublisher) publish() error {
 	for i, q := range queues {
 		ism.Queues[i] = q.Stats.QueueStatsMessage()
 	}
+	ism.Labels = sp.opts.labels
+	ism.Version = sp.opts.version
+	ism.Features = sp.opts.features
+	if sp.opts.counters != nil {
+		ism.Rejected = sp.opts.counters.Rejected()
+		ism.ExpiredTotal = sp.opts.counters.Expired()
+		if n := sp.opts.topSubjects; n > 0 {
+			ism.TopIngested = sp.opts.counters.TopIngested(n)
+			ism.TopRepublished = sp.opts.counters.TopRepublished(n)
+			ism.TopNoReply = sp.opts.counters.TopNoReply(n)
+		}
+	}
+	if sp.opts.keyRotation != nil {
+		ism.KeyRotation = sp.opts.keyRotation()
+	}
+	if sp.opts.sampling != nil {
+		ism.Sampling = sp.opts.sampling()
+	}
+	if sp.opts.operations != nil {
+		ism.Operations = sp.opts.operations()
+	}
+	if db != nil {
+		ism.Storage = badgerInternal.StorageStats(db)
+	}
+	if gc := sp.opts.vlogGC; gc != nil {
+		ism.Storage.VlogGCRuns = gc.Runs()
+		ism.Storage.VlogReclaimedBytes = gc.Reclaimed()
+	}
+	if sp.opts.revision != nil {
+		ism.Downgrade(sp.opts.revision())
+	}
+	return ism
+}
+
+func (sp *StatsPublisher) publish() error {
+	log.Debug().Msg("StatsPublisher: publish: triggered.")
+
+	ism := sp.snapshot()
 
 	log.Debug().Msg("StatsPublisher: publish: collected stats")
 
-	// Emit the stats on a topic
-	err := sp.nc.Publish(StatsSubject, ism.Bytes())
-	if err != nil {
-		log.Err(err).Msg("problem publishing stats")
+	// Emit the stats on a topic for each encoding
+	for _, enc := range sp.opts.encodings {
+		data, err := ism.Encode(enc)
+		if err != nil {
+			log.Err(err).Msg("problem encoding stats")
+			continue
+		}
+		if err := sp.nc.Publish(enc.Subject(sp.opts.subject), data); err != nil {
+			log.Err(err).Msg("problem publishing stats")
+		}
+		if sp.opts.fleetStats {
+			sp.publishFleet(enc, ism, data)
+		}
 	}
 	log.Debug().Msg("StatsPublisher: publish: emitted stats")
 
@@ -83,19 +83,53 @@ func TestPublish(t *testing.T) {
 	})
 	assert.NoError(t, err)
 
+	// The JSON encoding is published on its own subject.
+	_, err = ncSub.Subscribe(protocol.EncodingJSON.Subject(StatsSubject), func(msg *nats.Msg) {
+		ism := protocol.InstanceStatsMessageFromNATS(msg)
+		validateInstanceStats(t, instanceId, queueName, ism)
+		wait <- struct{}{}
+	})
+	assert.NoError(t, err)
+
+	// Fleet stats for the queue are tagged with the instance.
+	_, err = ncSub.Subscribe(protocol.QueueStatsSubject(protocol.StatsSubjectPrefix, queueName), func(msg *nats.Msg) {
+		qsm := protocol.QueueStatsMessageFromNATS(msg)
+		assert.Equal(t, instanceId, qsm.InstanceId)
+		assert.Equal(t, queueName, qsm.QueueName)
+		assert.Equal(t, int64(1), qsm.Enqueued)
+		wait <- struct{}{}
+	})
+	assert.NoError(t, err)
+
 	ncPub, err := nats.Connect(s.ClientURL())
 	assert.NoError(t, err)
 	t.Cleanup(func() {
 		ncPub.Close()
 	})
 
-	spub, err := NewStatsPublisher(ncPub, qManager, instanceId, StatsPublishInterval(500*time.Millisecond))
+	spub, err := NewStatsPublisher(ncPub, qManager, instanceId,
+		StatsPublishInterval(500*time.Millisecond),
+		StatsEncodings(protocol.EncodingFlatbuf, protocol.EncodingJSON),
+		FleetStats(true),
+		StorageMetrics(db),
+	)
 	assert.NoError(t, err)
 	t.Cleanup(func() {
 		spub.Close()
 	})
 
 	<-wait
+	<-wait
+	<-wait
+
+	// Stats can also be pulled on demand.
+	for _, enc := range []protocol.Encoding{protocol.EncodingFlatbuf, protocol.EncodingJSON} {
+		msg, err := ncSub.Request(enc.Subject(protocol.StatsRequestSubject(protocol.StatsSubjectPrefix, instanceId)), nil, 5*time.Second)
+		assert.NoError(t, err)
+		ism := protocol.DefaultInstanceStatsMessage()
+		assert.NoError(t, ism.Decode(enc, msg.Data))
+		validateInstanceStats(t, instanceId, queueName, ism)
+	}
 	// Done.
 }
 
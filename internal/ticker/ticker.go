@@ -1,25 +1,100 @@
 package ticker
 
 import (
+	"math/rand"
 	"time"
 )
 
 type Ticker struct {
+	d      time.Duration
 	ticker *time.Ticker
 	quit   chan struct{}
+
+	// Align the ticks to the wall clock, i.e., on the minute for a minute
+	// interval.
+	aligned bool
+	// A fixed offset from the ticks chosen at random, up to the jitter, for
+	// each Ticker.
+	offset time.Duration
+}
+
+// Option is a function on a Ticker.
+type Option func(*Ticker)
+
+// Aligned will align the ticks to multiples of the interval on the wall clock,
+// e.g., every minute on the minute.
+func Aligned() Option {
+	return func(t *Ticker) {
+		t.aligned = true
+	}
+}
+
+// Jitter offsets every tick by a random amount, up to max, chosen once for
+// the Ticker. This keeps a fleet of instances from ticking in lockstep while
+// each instance still ticks on a steady interval. max is capped to the
+// interval.
+func Jitter(max time.Duration) Option {
+	return func(t *Ticker) {
+		if max > t.d {
+			max = t.d
+		}
+		if max > 0 {
+			t.offset = time.Duration(rand.Int63n(int64(max)))
+		}
+	}
 }
 
-func New(d time.Duration) *Ticker {
-	return &Ticker{
-		ticker: time.NewTicker(d),
-		quit:   make(chan struct{}),
+func New(d time.Duration, opts ...Option) *Ticker {
+	t := &Ticker{
+		d:    d,
+		quit: make(chan struct{}),
 	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(t)
+		}
+	}
+	if !t.aligned && t.offset == 0 {
+		// Nothing special about this ticker so start ticking right away.
+		t.ticker = time.NewTicker(d)
+	}
+	return t
+}
+
+// firstTick returns how long to wait from now for the first tick of an
+// aligned or jittered Ticker.
+func (t *Ticker) firstTick(now time.Time) time.Duration {
+	next := now.Add(t.d)
+	if t.aligned {
+		next = now.Truncate(t.d).Add(t.d)
+	}
+	next = next.Add(t.offset)
+	// The offset can push us past a full interval when aligned.
+	if next.Sub(now) > t.d {
+		next = next.Add(-t.d)
+	}
+	return next.Sub(now)
 }
 
 // Loop will run the provided function fn on a loop. Once Stop() has been called,
 // the loop will not run even if there are pending ticks from the ticker.
 // If the provided function fn returns false then the loop will terminate.
 func (t *Ticker) Loop(fn func() bool) {
+	if t.ticker == nil {
+		timer := time.NewTimer(t.firstTick(time.Now()))
+		select {
+		case <-t.quit:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		// Keep ticking on the interval from the first tick.
+		t.ticker = time.NewTicker(t.d)
+		if !t.run(fn) {
+			t.ticker.Stop()
+			return
+		}
+	}
 	defer t.ticker.Stop()
 	for {
 		select {
@@ -39,6 +114,16 @@ func (t *Ticker) Loop(fn func() bool) {
 	}
 }
 
+// run calls fn unless we've been told to stop.
+func (t *Ticker) run(fn func() bool) bool {
+	select {
+	case <-t.quit:
+		return false
+	default:
+		return fn()
+	}
+}
+
 func (t *Ticker) Stop() {
 	close(t.quit)
 }
@@ -0,0 +1,45 @@
+package ticker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirstTickAligned(t *testing.T) {
+	tk := New(time.Minute, Aligned())
+	now := time.Date(2024, 6, 1, 13, 45, 20, 0, time.UTC)
+	assert.Equal(t, 40*time.Second, tk.firstTick(now))
+}
+
+func TestFirstTickAlignedWithOffset(t *testing.T) {
+	tk := New(time.Minute, Aligned())
+	tk.offset = 10 * time.Second
+	now := time.Date(2024, 6, 1, 13, 45, 20, 0, time.UTC)
+	assert.Equal(t, 50*time.Second, tk.firstTick(now))
+
+	// The offset for this minute has not passed yet.
+	now = time.Date(2024, 6, 1, 13, 45, 5, 0, time.UTC)
+	assert.Equal(t, 5*time.Second, tk.firstTick(now))
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		tk := New(time.Minute, Jitter(10*time.Second))
+		assert.True(t, tk.offset >= 0 && tk.offset < 10*time.Second)
+	}
+	// Capped to the interval.
+	tk := New(time.Second, Jitter(time.Hour))
+	assert.True(t, tk.offset < time.Second)
+}
+
+func TestLoopJittered(t *testing.T) {
+	tk := New(10*time.Millisecond, Jitter(5*time.Millisecond))
+	var n int
+	tk.Loop(func() bool {
+		n++
+		return n < 3
+	})
+	assert.Equal(t, 3, n)
+}
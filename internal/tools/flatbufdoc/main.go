@@ -0,0 +1,118 @@
+// +build ignore
+
+// flatbufdoc removes the doc comments flatc leaves behind for the fields it
+// doesn't generate a mutator for. flatc writes the doc comment of every field
+// again before its mutator, even when there is none, so it ends up above the
+// accessor of the next field. Run it on the directory of the generated files
+// after flatc.
+//
+//	go run internal/tools/flatbufdoc/main.go flatbuf
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	for _, dir := range os.Args[1:] {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.gen.go"))
+		if err == nil && len(paths) == 0 {
+			err = fmt.Errorf("no generated files in %s", dir)
+		}
+		for _, path := range paths {
+			if err == nil {
+				err = fix(path)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "flatbufdoc: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func fix(path string) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(src), "\n")
+	out := make([]string, 0, len(lines))
+
+	// The doc comment of the last function that had one, and its name.
+	var lastDoc []string
+	var lastFunc string
+	var doc []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "///") {
+			doc = append(doc, line)
+			continue
+		}
+		if name, ok := funcName(line); ok && len(doc) > 0 {
+			switch {
+			case len(doc) > len(lastDoc) && hasPrefix(doc, lastDoc):
+				// The previous field's doc comment is left over.
+				doc = doc[len(lastDoc):]
+			case equal(doc, lastDoc) && name != "Mutate"+lastFunc:
+				// The previous field's doc comment is left over and
+				// this function has none of its own.
+				doc = nil
+			}
+			if len(doc) > 0 {
+				lastDoc, lastFunc = doc, name
+			}
+		}
+		out = append(out, doc...)
+		out = append(out, line)
+		doc = nil
+	}
+	out = append(out, doc...)
+
+	fixed := []byte(strings.Join(out, "\n"))
+	if bytes.Equal(fixed, src) {
+		return nil
+	}
+	return ioutil.WriteFile(path, fixed, 0644)
+}
+
+// funcName returns the name of the function declared on the line, without
+// its receiver.
+func funcName(line string) (string, bool) {
+	if !strings.HasPrefix(line, "func ") {
+		return "", false
+	}
+	line = strings.TrimPrefix(line, "func ")
+	if strings.HasPrefix(line, "(") {
+		i := strings.Index(line, ") ")
+		if i < 0 {
+			return "", false
+		}
+		line = line[i+2:]
+	}
+	i := strings.Index(line, "(")
+	if i < 0 {
+		return "", false
+	}
+	return line[:i], true
+}
+
+func hasPrefix(s, prefix []string) bool {
+	return len(s) >= len(prefix) && equal(s[:len(prefix)], prefix)
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
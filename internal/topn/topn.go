@@ -0,0 +1,117 @@
+// Package topn keeps approximate counts of the most frequent keys using a
+// bounded amount of memory.
+package topn
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// Entry is the count of a key.
+type Entry struct {
+	Key   string
+	Count int64
+}
+
+// TopN tracks the most frequent keys with the Space-Saving algorithm. At most
+// capacity keys are tracked. When a new key arrives and there is no room, it
+// replaces the least frequent key and inherits its count, so counts can be
+// overestimated by at most the count of the key it replaced. Keys that are
+// frequent enough are always tracked.
+type TopN struct {
+	capacity int
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	// A min heap of the entries by count.
+	heap entryHeap
+}
+
+type entry struct {
+	Entry
+	index int
+}
+
+func New(capacity int) *TopN {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &TopN{
+		capacity: capacity,
+		entries:  make(map[string]*entry, capacity),
+		heap:     make(entryHeap, 0, capacity),
+	}
+}
+
+// Add adds n to the count for key.
+func (t *TopN) Add(key string, n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[key]; ok {
+		e.Count += n
+		heap.Fix(&t.heap, e.index)
+		return
+	}
+	if len(t.heap) < t.capacity {
+		e := &entry{Entry: Entry{Key: key, Count: n}}
+		t.entries[key] = e
+		heap.Push(&t.heap, e)
+		return
+	}
+	// Replace the least frequent key.
+	e := t.heap[0]
+	delete(t.entries, e.Key)
+	e.Key = key
+	e.Count += n
+	t.entries[key] = e
+	heap.Fix(&t.heap, e.index)
+}
+
+// Top returns up to n of the most frequent keys, most frequent first. Ties are
+// broken by key.
+func (t *TopN) Top(n int) []Entry {
+	t.mu.Lock()
+	out := make([]Entry, 0, len(t.heap))
+	for _, e := range t.heap {
+		out = append(out, e.Entry)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Key < out[j].Key
+	})
+	if n >= 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+type entryHeap []*entry
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
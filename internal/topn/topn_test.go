@@ -0,0 +1,35 @@
+package topn
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopN(t *testing.T) {
+	top := New(3)
+	top.Add("a", 5)
+	top.Add("b", 3)
+	top.Add("c", 1)
+	top.Add("a", 1)
+	assert.Equal(t, []Entry{{"a", 6}, {"b", 3}, {"c", 1}}, top.Top(-1))
+	assert.Equal(t, []Entry{{"a", 6}}, top.Top(1))
+
+	// d replaces c, the least frequent, and inherits its count.
+	top.Add("d", 1)
+	assert.Equal(t, []Entry{{"a", 6}, {"b", 3}, {"d", 2}}, top.Top(-1))
+}
+
+func TestTopNBoundedCardinality(t *testing.T) {
+	top := New(10)
+	// A flood of distinct subjects can't push out a heavy hitter.
+	for i := 0; i < 1000; i++ {
+		top.Add("heavy", 1)
+		top.Add(fmt.Sprintf("noise.%d", i), 1)
+	}
+	entries := top.Top(-1)
+	assert.Len(t, entries, 10)
+	assert.Equal(t, "heavy", entries[0].Key)
+	assert.True(t, entries[0].Count >= 1000)
+}
@@ -0,0 +1,262 @@
+package requeue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// JetStreamConsumer names a durable pull consumer of a JetStream stream.
+type JetStreamConsumer struct {
+	Stream   string
+	Consumer string
+}
+
+// JetStream ingests messages from the durable pull consumer of a JetStream
+// stream instead of the NATSSubject's queue group, so a message published
+// while no instance is running, or lost before it was committed, is
+// redelivered by JetStream rather than relying on its producer waiting for the
+// ack. The stream and the consumer must already exist, and the consumer must
+// require explicit acks. Messages are acknowledged to JetStream as the
+// IngestAckMode says, and rejected messages are acknowledged too so they
+// aren't redelivered. Every instance pulling from the same consumer shares its
+// messages, the same as a queue group.
+func JetStream(js JetStreamConsumer) Option {
+	return func(o *Options) error {
+		if err := js.validate(); err != nil {
+			return fmt.Errorf("jetstream: %w", err)
+		}
+		o.jetStream = js
+		return nil
+	}
+}
+
+func (js JetStreamConsumer) validate() error {
+	for _, n := range []struct{ kind, name string }{
+		{"stream", js.Stream},
+		{"consumer", js.Consumer},
+	} {
+		if n.name == "" {
+			return fmt.Errorf("%s name cannot be empty", n.kind)
+		}
+		if strings.ContainsAny(n.name, ".*> \t\r\n") {
+			return fmt.Errorf("invalid %s name: %q", n.kind, n.name)
+		}
+	}
+	return nil
+}
+
+// nextSubject returns the subject to request the next messages of the
+// consumer on.
+func (js JetStreamConsumer) nextSubject() string {
+	return "$JS.API.CONSUMER.MSG.NEXT." + js.Stream + "." + js.Consumer
+}
+
+// jetStreamEnabled returns true if messages are ingested from JetStream.
+func (o Options) jetStreamEnabled() bool {
+	return o.jetStream.Stream != ""
+}
+
+// jetStreamPullBatch is the number of messages pulled from the consumer ahead
+// of the ingest consumers.
+func (o Options) jetStreamPullBatch() int {
+	if n := o.consumerBuffer(); n > 0 {
+		return n
+	}
+	return o.numConsumers
+}
+
+// jetStreamPuller keeps track of the messages pulled from the JetStream
+// consumer that are still on their way. Each pull request gets its own reply
+// subject under the inbox so a status replied to it, which ends it, can be
+// told apart from the others.
+type jetStreamPuller struct {
+	next    string
+	inbox   string
+	request func(subject, reply string, data []byte) error
+
+	mu  sync.Mutex
+	seq uint64
+	// The number of messages each pull request still has on their way, by
+	// the sequence in its reply subject.
+	pending map[uint64]int
+}
+
+func newJetStreamPuller(next, inbox string, request func(subject, reply string, data []byte) error) *jetStreamPuller {
+	return &jetStreamPuller{
+		next:    next,
+		inbox:   inbox,
+		request: request,
+		pending: make(map[uint64]int),
+	}
+}
+
+// subject returns the subject the replies to every pull request are received
+// on.
+func (p *jetStreamPuller) subject() string {
+	return p.inbox + ".*"
+}
+
+// pull requests the next n messages of the consumer. The batch is sent as a
+// plain number, which every version of the server understands.
+func (p *jetStreamPuller) pull(n int) error {
+	if n <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	p.seq++
+	seq := p.seq
+	p.pending[seq] = n
+	p.mu.Unlock()
+
+	reply := p.inbox + "." + strconv.FormatUint(seq, 10)
+	if err := p.request(p.next, reply, []byte(strconv.Itoa(n))); err != nil {
+		p.mu.Lock()
+		delete(p.pending, seq)
+		p.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (p *jetStreamPuller) pullOrLog(n int) {
+	if err := p.pull(n); err != nil {
+		log.Err(err).Str("subject", p.next).Msg("nats-replay: unable to pull from jetstream")
+	}
+}
+
+// receive hands a message of the consumer to deliver and pulls another in its
+// place. Anything else replied to a pull request is a status. Apart from
+// heartbeats, a status, e.g., that the request expired or the consumer
+// reached its max waiting, ends the request, so the messages it still had on
+// their way are pulled again.
+func (p *jetStreamPuller) receive(msg *nats.Msg, deliver func(*nats.Msg)) {
+	seq, _ := strconv.ParseUint(msg.Subject[strings.LastIndexByte(msg.Subject, '.')+1:], 10, 64)
+
+	// Only the messages of the consumer can be acknowledged.
+	if msg.Reply == "" {
+		if strings.HasPrefix(msg.Header.Get("Status"), "100") {
+			return
+		}
+		p.pullOrLog(p.ended(seq))
+		return
+	}
+	deliver(msg)
+	if p.delivered(seq) {
+		p.pullOrLog(1)
+	}
+}
+
+// delivered counts a message of the pull request seq as arrived. It returns
+// true if another should be pulled in its place, which isn't the case for the
+// requests forgotten by repull.
+func (p *jetStreamPuller) delivered(seq uint64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n, ok := p.pending[seq]
+	if !ok {
+		return false
+	}
+	if n <= 1 {
+		delete(p.pending, seq)
+	} else {
+		p.pending[seq] = n - 1
+	}
+	return true
+}
+
+// ended forgets the pull request seq and returns the number of messages it
+// still had on their way.
+func (p *jetStreamPuller) ended(seq uint64) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := p.pending[seq]
+	delete(p.pending, seq)
+	return n
+}
+
+// repull pulls every message still on its way again in one request, e.g.,
+// because the pull requests may have been lost with the connection. The old
+// requests are forgotten so the messages that do still arrive for them aren't
+// replaced, which keeps the number pulled from growing.
+func (p *jetStreamPuller) repull() {
+	p.mu.Lock()
+	var n int
+	for _, pending := range p.pending {
+		n += pending
+	}
+	p.pending = make(map[uint64]int)
+	p.mu.Unlock()
+	p.pullOrLog(n)
+}
+
+// subscribeJetStream creates the ingest subscription for the messages pulled
+// from the JetStream consumer. A batch of them is requested up front, and
+// another is requested as each arrives, so there are always about as many on
+// their way as the ingest consumers can take. Should be called with the lock
+// acquired.
+func (c *Conn) subscribeJetStream() error {
+	o := c.Opts
+	nc := c.nc
+	p := newJetStreamPuller(o.jetStream.nextSubject(), nats.NewInbox(), nc.PublishRequest)
+
+	sub, err := nc.Subscribe(p.subject(), func(msg *nats.Msg) {
+		p.receive(msg, func(msg *nats.Msg) {
+			c.inflight.add(1)
+			c.natsMsgCh <- msg
+		})
+	})
+	if err != nil {
+		log.Err(err).Dict("jetstream", c.jetStreamDict()).
+			Msg("nats-replay: unable to subscribe to jetstream consumer")
+		return err
+	}
+
+	if err := p.pull(o.jetStreamPullBatch()); err != nil {
+		sub.Unsubscribe()
+		log.Err(err).Dict("jetstream", c.jetStreamDict()).
+			Msg("nats-replay: unable to pull from jetstream consumer")
+		return err
+	}
+
+	c.sub = sub
+	c.jsPuller = p
+	return nil
+}
+
+// repullJetStream pulls the messages still on their way from the JetStream
+// consumer again after a reconnect, since the pull requests may not have
+// survived it.
+func (c *Conn) repullJetStream() {
+	c.mu.RLock()
+	p := c.jsPuller
+	if c.leftQueueGroup {
+		p = nil
+	}
+	c.mu.RUnlock()
+	if p != nil {
+		p.repull()
+	}
+}
+
+func (c *Conn) jetStreamDict() *zerolog.Event {
+	return zerolog.Dict().
+		Str("stream", c.Opts.jetStream.Stream).
+		Str("consumer", c.Opts.jetStream.Consumer)
+}
+
+// rejectReply returns the reply to a rejected message. JetStream would
+// redeliver a message that isn't acknowledged and there's no producer waiting
+// to hear why, so it's acknowledged.
+func (c *Conn) rejectReply(nak protocol.Nak) ([]byte, error) {
+	if c.Opts.jetStreamEnabled() {
+		return nil, nil
+	}
+	return nak.MarshalBinary()
+}
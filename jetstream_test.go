@@ -0,0 +1,95 @@
+package requeue
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJetStreamConsumer(t *testing.T) {
+	js := JetStreamConsumer{Stream: "ORDERS", Consumer: "requeue"}
+	assert.Equal(t, "$JS.API.CONSUMER.MSG.NEXT.ORDERS.requeue", js.nextSubject())
+
+	o := GetDefaultOptions()
+	assert.False(t, o.jetStreamEnabled())
+	o.jetStream = js
+	assert.True(t, o.jetStreamEnabled())
+	o.consumerBatchSize = 1
+	assert.Equal(t, o.numConsumers, o.jetStreamPullBatch())
+	o.consumerBatchSize = 8
+	assert.Equal(t, 8*o.numConsumers, o.jetStreamPullBatch())
+}
+
+func TestRejectReply(t *testing.T) {
+	nak := protocol.Nak{Reason: protocol.NakReasonSubjectDenied, Message: "denied"}
+
+	c := &Conn{Opts: GetDefaultOptions()}
+	data, err := c.rejectReply(nak)
+	assert.NoError(t, err)
+	want, _ := nak.MarshalBinary()
+	assert.Equal(t, want, data)
+
+	// Rejected JetStream messages are acknowledged.
+	c.Opts.jetStream = JetStreamConsumer{Stream: "ORDERS", Consumer: "requeue"}
+	data, err = c.rejectReply(nak)
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+}
+
+func TestJetStreamPuller(t *testing.T) {
+	type pull struct{ reply, batch string }
+	var pulls []pull
+	p := newJetStreamPuller("$JS.API.CONSUMER.MSG.NEXT.ORDERS.requeue", "_INBOX.js", func(subject, reply string, data []byte) error {
+		assert.Equal(t, "$JS.API.CONSUMER.MSG.NEXT.ORDERS.requeue", subject)
+		pulls = append(pulls, pull{reply, string(data)})
+		return nil
+	})
+	var delivered int
+	deliver := func(*nats.Msg) { delivered++ }
+	status := func(reply, code string) *nats.Msg {
+		return &nats.Msg{Subject: reply, Header: http.Header{"Status": []string{code}}}
+	}
+
+	assert.Equal(t, "_INBOX.js.*", p.subject())
+	assert.NoError(t, p.pull(3))
+	assert.Equal(t, []pull{{"_INBOX.js.1", "3"}}, pulls)
+
+	// Each message is replaced as it arrives.
+	p.receive(&nats.Msg{Subject: "_INBOX.js.1", Reply: "$JS.ACK.ORDERS.requeue.1"}, deliver)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, pull{"_INBOX.js.2", "1"}, pulls[1])
+
+	// Heartbeats don't end the request.
+	p.receive(status("_INBOX.js.1", "100 Idle Heartbeat"), deliver)
+	assert.Len(t, pulls, 2)
+
+	// The request expired with 2 messages still to come, so they're pulled
+	// again.
+	p.receive(status("_INBOX.js.1", "408 Request Timeout"), deliver)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, pull{"_INBOX.js.3", "2"}, pulls[2])
+	// It's only pulled again once.
+	p.receive(status("_INBOX.js.1", "408 Request Timeout"), deliver)
+	assert.Len(t, pulls, 3)
+
+	// After a reconnect everything on its way is pulled again in one request,
+	// unless the instance left the queue group.
+	c := &Conn{Opts: GetDefaultOptions(), jsPuller: p, natsConnected: 1, leftQueueGroup: true}
+	c.NATSReconnectHandler(nil)
+	assert.Len(t, pulls, 3)
+	c.leftQueueGroup = false
+	c.NATSReconnectHandler(nil)
+	assert.Equal(t, pull{"_INBOX.js.4", "3"}, pulls[3])
+
+	// A message that still arrives for a request from before the reconnect is
+	// ingested but not replaced.
+	p.receive(&nats.Msg{Subject: "_INBOX.js.3", Reply: "$JS.ACK.ORDERS.requeue.2"}, deliver)
+	assert.Equal(t, 2, delivered)
+	assert.Len(t, pulls, 4)
+	p.receive(&nats.Msg{Subject: "_INBOX.js.4", Reply: "$JS.ACK.ORDERS.requeue.3"}, deliver)
+	assert.Equal(t, 3, delivered)
+	assert.Equal(t, pull{"_INBOX.js.5", "1"}, pulls[4])
+}
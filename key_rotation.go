@@ -0,0 +1,339 @@
+package requeue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultKeyRotationBatchSize is how many messages a key rotation re-encrypts
+// in each transaction.
+const DefaultKeyRotationBatchSize = 256
+
+// KeyRotationBatchSize sets how many messages a key rotation re-encrypts in
+// each transaction. Its progress is persisted after every batch.
+func KeyRotationBatchSize(n int) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return fmt.Errorf("key rotation batch size must be positive: %d", n)
+		}
+		o.keyRotationBatchSize = n
+		return nil
+	}
+}
+
+// keyRotationCheckpoint is persisted after every batch of a key rotation so it
+// resumes where it left off if the instance restarts.
+type keyRotationCheckpoint struct {
+	Progress protocol.KeyRotation `json:"progress"`
+	// Remaining are the queues left to rotate, the current one first.
+	Remaining []string `json:"remaining"`
+	// After is the key of the last message rotated in the current queue.
+	After key.Key `json:"after,omitempty"`
+}
+
+// keyRotator holds the progress of the current, or last, key rotation.
+type keyRotator struct {
+	mu       sync.Mutex
+	progress *protocol.KeyRotation
+}
+
+func (r *keyRotator) get() *protocol.KeyRotation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.progress == nil {
+		return nil
+	}
+	p := *r.progress
+	return &p
+}
+
+func (r *keyRotator) set(p protocol.KeyRotation) {
+	r.mu.Lock()
+	r.progress = &p
+	r.mu.Unlock()
+}
+
+// start records p as the progress of a new rotation unless one is already
+// running.
+func (r *keyRotator) start(p protocol.KeyRotation) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.progress != nil && r.progress.State == protocol.KeyRotationRunning {
+		return false
+	}
+	r.progress = &p
+	return true
+}
+
+// RotateKeys re-encrypts the payloads of the messages stored in the queues
+// with the current key of their queue in the PayloadKeyring, so old keys can
+// be retired without waiting for long-lived backlogs to drain. Naming a queue
+// includes the sub-queues of a time bucketed queue, and every queue is rotated
+// when none are named. The rotation runs in the background, a batch at a time,
+// and resumes where it left off if the instance restarts. Its progress is
+// returned by KeyRotation and included in the stats, and it can be stopped
+// between batches with CancelOperation. Messages that can't be re-encrypted,
+// e.g., because their key has been destroyed, are counted and left as they
+// are. Quarantined messages aren't rotated.
+func (c *Conn) RotateKeys(queues ...string) (protocol.KeyRotation, error) {
+	if c.Opts.payloadKeyring == nil {
+		return protocol.KeyRotation{}, fmt.Errorf("rotate keys: payloads aren't encrypted with a keyring")
+	}
+	m := c.Manager()
+	if m == nil {
+		return protocol.KeyRotation{}, fmt.Errorf("rotate keys: queue manager is not running")
+	}
+
+	names := make([]string, 0)
+	for _, q := range m.Queues() {
+		base, _ := queue.SplitBucketName(q.Name())
+		if len(queues) == 0 || contains(queues, base) || contains(queues, q.Name()) {
+			names = append(names, q.Name())
+		}
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	cp := keyRotationCheckpoint{
+		Progress: protocol.KeyRotation{
+			State:     protocol.KeyRotationRunning,
+			Queues:    int64(len(names)),
+			StartedAt: now,
+			UpdatedAt: now,
+		},
+		Remaining: names,
+	}
+	if !c.keyRotator.start(cp.Progress) {
+		return *c.keyRotator.get(), fmt.Errorf("rotate keys: a rotation is already running")
+	}
+	if err := c.saveKeyRotation(cp); err != nil {
+		cp.Progress.State = protocol.KeyRotationFailed
+		cp.Progress.Error = err.Error()
+		c.keyRotator.set(cp.Progress)
+		return cp.Progress, err
+	}
+	log.Info().Strs("queues", names).Msg("rotating payload keys")
+	c.runKeyRotation(cp, c.operations.start(context.Background(), protocol.OperationReencryption, ""))
+	return cp.Progress, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyRotation returns the progress of the current, or last, key rotation. It
+// returns nil if there hasn't been one.
+func (c *Conn) KeyRotation() *protocol.KeyRotation {
+	return c.keyRotator.get()
+}
+
+// initKeyRotation resumes a key rotation that was interrupted, and loads the
+// progress of the last one.
+func (c *Conn) initKeyRotation() error {
+	var cp keyRotationCheckpoint
+	found := false
+	if err := c.badgerDB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(queue.KeyRotationKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &cp)
+		})
+	}); err != nil {
+		return fmt.Errorf("init key rotation: %w", err)
+	}
+	if !found {
+		return nil
+	}
+
+	if cp.Progress.State == protocol.KeyRotationRunning && c.Opts.payloadKeyring == nil {
+		log.Warn().Msg("unable to resume rotating payload keys without a keyring")
+		cp.Progress.State = protocol.KeyRotationFailed
+		cp.Progress.Error = "payloads aren't encrypted with a keyring"
+		c.keyRotator.set(cp.Progress)
+		return c.saveKeyRotation(cp)
+	}
+	c.keyRotator.set(cp.Progress)
+	if cp.Progress.State == protocol.KeyRotationRunning {
+		log.Info().Strs("queues", cp.Remaining).Msg("resuming rotating payload keys")
+		op := c.operations.start(context.Background(), protocol.OperationReencryption, "")
+		op.progress(int(cp.Progress.QueuesDone), int(cp.Progress.Queues))
+		c.runKeyRotation(cp, op)
+	}
+	return nil
+}
+
+// runKeyRotation re-encrypts the remaining queues of the rotation in the
+// background, reporting its progress in queues to op.
+func (c *Conn) runKeyRotation(cp keyRotationCheckpoint, op *operation) {
+	c.closers.keyRotation.AddRunning(1)
+	go func() {
+		defer c.closers.keyRotation.Done()
+		for len(cp.Remaining) > 0 {
+			select {
+			case <-c.closers.keyRotation.HasBeenClosed():
+				// Picked up from the checkpoint when restarted.
+				return
+			case <-op.ctx.Done():
+				// The queues rotated so far stay rotated, and it isn't
+				// resumed.
+				log.Info().Strs("queues", cp.Remaining).Msg("canceled rotating payload keys")
+				cp.Progress.State = protocol.KeyRotationCanceled
+				c.finishKeyRotation(cp)
+				op.finish(op.ctx.Err())
+				return
+			default:
+			}
+
+			name := cp.Remaining[0]
+			cp.Progress.Queue = name
+			c.mu.RLock()
+			db := c.badgerDB
+			c.mu.RUnlock()
+			res, err := queue.ReencryptMessages(db, name, cp.After, c.Opts.keyRotationBatchSize, c.reencryptPayload)
+			if err != nil {
+				log.Err(err).Str("queue", name).Msg("unable to rotate payload keys")
+				cp.Progress.State = protocol.KeyRotationFailed
+				cp.Progress.Error = err.Error()
+				c.finishKeyRotation(cp)
+				op.finish(err)
+				return
+			}
+			cp.Progress.Scanned += int64(res.Scanned)
+			cp.Progress.Reencrypted += int64(res.Reencrypted)
+			cp.Progress.Failed += int64(res.Failed)
+			if res.Err != nil {
+				cp.Progress.Error = res.Err.Error()
+			}
+			if res.Last == nil {
+				cp.Remaining = cp.Remaining[1:]
+				cp.After = nil
+				cp.Progress.QueuesDone++
+			} else {
+				cp.After = res.Last
+			}
+			cp.Progress.UpdatedAt = time.Now()
+			c.keyRotator.set(cp.Progress)
+			op.progress(int(cp.Progress.QueuesDone), int(cp.Progress.Queues))
+			if err := c.saveKeyRotation(cp); err != nil {
+				log.Err(err).Msg("unable to checkpoint rotating payload keys")
+			}
+		}
+		cp.Progress.State = protocol.KeyRotationDone
+		c.finishKeyRotation(cp)
+		op.finish(nil)
+		log.Info().
+			Int64("reencrypted", cp.Progress.Reencrypted).
+			Int64("failed", cp.Progress.Failed).
+			Msg("finished rotating payload keys")
+	}()
+}
+
+func (c *Conn) finishKeyRotation(cp keyRotationCheckpoint) {
+	cp.Progress.Queue = ""
+	cp.Progress.UpdatedAt = time.Now()
+	cp.Remaining = nil
+	cp.After = nil
+	c.keyRotator.set(cp.Progress)
+	if err := c.saveKeyRotation(cp); err != nil {
+		log.Err(err).Msg("unable to checkpoint rotating payload keys")
+	}
+}
+
+func (c *Conn) saveKeyRotation(cp keyRotationCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("save key rotation: %w", err)
+	}
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if err := db.Update(func(txn *badger.Txn) error {
+		return txn.Set(queue.KeyRotationKey, data)
+	}); err != nil {
+		return fmt.Errorf("save key rotation: %w", err)
+	}
+	return nil
+}
+
+// reencryptPayload re-encrypts the payload of the stored message v with the
+// current key of its queue. Messages without a key id were encrypted with the
+// PayloadEncrypter, or stored before payloads were encrypted at all when
+// there isn't one.
+func (c *Conn) reencryptPayload(v []byte) ([]byte, bool, error) {
+	k := c.Opts.payloadKeyring
+	fb := flatbuf.GetRootAsRequeueMessage(v, 0)
+	queueName := protocol.GetQueueName(fb)
+	keyID, err := k.KeyID(queueName)
+	if err != nil {
+		return nil, false, fmt.Errorf("queue %s: %w", queueName, err)
+	}
+	if string(fb.KeyId()) == keyID {
+		return nil, false, nil
+	}
+
+	payload, err := c.decryptPayload(fb)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var m protocol.RequeueMessage
+	_ = m.UnmarshalBinary(v)
+	m.OriginalPayload = payload
+	m.KeyID = ""
+	out, err := protocol.EncryptPayloadWithKeyring(k, queueName, m.Bytes())
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+func (c *Conn) handleKeyRotationRequest(msg *nats.Msg) {
+	reply := protocol.KeyRotationReply{InstanceID: c.instanceId}
+	req := protocol.KeyRotationRequest{}
+	if err := req.UnmarshalBinary(msg.Data); err != nil {
+		reply.Error = fmt.Sprintf("invalid key rotation request: %s", err)
+	} else if req.Status {
+		reply.Rotation = c.KeyRotation()
+	} else {
+		rotation, err := c.RotateKeys(req.Queues...)
+		if err != nil {
+			reply.Error = err.Error()
+			reply.Rotation = c.KeyRotation()
+		} else {
+			reply.Rotation = &rotation
+		}
+	}
+	reply.Time = time.Now()
+
+	data, err := reply.MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("unable to marshal key rotation reply")
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Err(err).Msg("unable to respond to key rotation request")
+	}
+}
@@ -0,0 +1,184 @@
+package requeue
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// xorEncrypter is a toy protocol.Encrypter for testing.
+type xorEncrypter byte
+
+func (x xorEncrypter) Encrypt(p []byte) ([]byte, error) {
+	out := make([]byte, len(p))
+	for i := range p {
+		out[i] = p[i] ^ byte(x)
+	}
+	return out, nil
+}
+
+func (x xorEncrypter) Decrypt(p []byte) ([]byte, error) {
+	return x.Encrypt(p)
+}
+
+// rotatingKeyring is a protocol.Keyring where every queue shares the current
+// key.
+type rotatingKeyring struct {
+	mu      sync.Mutex
+	current string
+	keys    map[string]protocol.Encrypter
+}
+
+func (k *rotatingKeyring) KeyID(string) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.current, nil
+}
+
+func (k *rotatingKeyring) Encrypter(keyID string) (protocol.Encrypter, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	e, ok := k.keys[keyID]
+	if !ok {
+		return nil, protocol.ErrKeyNotFound
+	}
+	return e, nil
+}
+
+func (k *rotatingKeyring) rotate(keyID string, e protocol.Encrypter) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.current = keyID
+	k.keys[keyID] = e
+}
+
+func TestRotateKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate-keys-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	k := &rotatingKeyring{
+		current: "v1",
+		keys:    map[string]protocol.Encrypter{"v1": xorEncrypter(0x5a)},
+	}
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	assert.NoError(t, InstanceID("rotate")(&o))
+	assert.NoError(t, PayloadKeyring(k)(&o))
+	assert.NoError(t, KeyRotationBatchSize(2)(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	assert.NoError(t, c.initQueueManager())
+
+	// Store messages encrypted with the first key.
+	now := time.Now()
+	keys := make([]key.Key, 5)
+	assert.NoError(t, c.badgerDB.Update(func(txn *badger.Txn) error {
+		for i := range keys {
+			m := protocol.DefaultRequeueMessage()
+			m.OriginalPayload = []byte("secret")
+			data, err := protocol.EncryptPayloadWithKeyring(k, m.QueueName, m.Bytes())
+			if err != nil {
+				return err
+			}
+			keys[i] = key.New(now.Add(time.Duration(i) * time.Second))
+			if err := txn.Set(queue.NewQueueKeyForMessage(m.QueueName, keys[i]).Bytes(), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	_, err = c.qManager.CreateQueue(queue.NewQueueKeyForState(protocol.DefaultQueueName, ""))
+	assert.NoError(t, err)
+
+	waitDone := func() *protocol.KeyRotation {
+		var p *protocol.KeyRotation
+		assert.Eventually(t, func() bool {
+			p = c.KeyRotation()
+			return p != nil && p.State != protocol.KeyRotationRunning
+		}, 5*time.Second, 10*time.Millisecond)
+		return p
+	}
+	assertKeyID := func(keyID string) {
+		assert.NoError(t, c.badgerDB.View(func(txn *badger.Txn) error {
+			for _, mk := range keys {
+				item, err := txn.Get(queue.NewQueueKeyForMessage(protocol.DefaultQueueName, mk).Bytes())
+				if !assert.NoError(t, err) {
+					continue
+				}
+				v, _ := item.ValueCopy(nil)
+				fb := flatbuf.GetRootAsRequeueMessage(v, 0)
+				assert.Equal(t, keyID, string(fb.KeyId()))
+				payload, err := protocol.DecryptPayloadWithKeyring(k, fb)
+				assert.NoError(t, err)
+				assert.Equal(t, "secret", string(payload))
+			}
+			return nil
+		}))
+	}
+
+	k.rotate("v2", xorEncrypter(0x3c))
+	_, err = c.RotateKeys()
+	assert.NoError(t, err)
+	p := waitDone()
+	assert.Equal(t, protocol.KeyRotationDone, p.State)
+	assert.Equal(t, int64(1), p.QueuesDone)
+	assert.Equal(t, int64(5), p.Scanned)
+	assert.Equal(t, int64(5), p.Reencrypted)
+	assertKeyID("v2")
+
+	// An interrupted rotation resumes from its checkpoint.
+	k.rotate("v3", xorEncrypter(0x11))
+	assert.NoError(t, c.saveKeyRotation(keyRotationCheckpoint{
+		Progress: protocol.KeyRotation{
+			State:   protocol.KeyRotationRunning,
+			Queues:  1,
+			Scanned: 2,
+		},
+		Remaining: []string{protocol.DefaultQueueName},
+		After:     keys[1],
+	}))
+	assert.NoError(t, c.initKeyRotation())
+	p = waitDone()
+	assert.Equal(t, protocol.KeyRotationDone, p.State)
+	assert.Equal(t, int64(5), p.Scanned)
+	assert.Equal(t, int64(3), p.Reencrypted)
+
+	// Only the messages after the checkpoint were rotated.
+	assert.NoError(t, c.badgerDB.View(func(txn *badger.Txn) error {
+		for i, mk := range keys {
+			item, err := txn.Get(queue.NewQueueKeyForMessage(protocol.DefaultQueueName, mk).Bytes())
+			assert.NoError(t, err)
+			v, _ := item.ValueCopy(nil)
+			want := "v3"
+			if i < 2 {
+				want = "v2"
+			}
+			assert.Equal(t, want, string(flatbuf.GetRootAsRequeueMessage(v, 0).KeyId()))
+		}
+		return nil
+	}))
+
+	// Rotating again catches up the rest.
+	_, err = c.RotateKeys(protocol.DefaultQueueName)
+	assert.NoError(t, err)
+	p = waitDone()
+	assert.Equal(t, int64(2), p.Reencrypted)
+	assertKeyID("v3")
+}
+
+func TestRotateKeysWithoutKeyring(t *testing.T) {
+	c := NewConn(GetDefaultOptions())
+	_, err := c.RotateKeys()
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+package requeue
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrIngestDisabled is returned when asking an instance that doesn't ingest
+// messages to leave or join the ingest queue group.
+var ErrIngestDisabled = errors.New("ingest is disabled")
+
+// LeaveQueueGroup stops the instance from taking new messages from the ingest
+// queue group without shutting down, e.g., to take it out of rotation for
+// maintenance. The subscription is drained so the messages it already took
+// are still committed and acknowledged, and the backlog keeps being
+// republished. The instance reports that it isn't ready until it joins again.
+func (c *Conn) LeaveQueueGroup() error {
+	if c.Opts.ingestDisabled {
+		return fmt.Errorf("leave queue group: %w", ErrIngestDisabled)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.leftQueueGroup {
+		return nil
+	}
+	if c.sub != nil && c.sub.IsValid() {
+		if err := c.sub.Drain(); err != nil && err != nats.ErrBadSubscription {
+			return fmt.Errorf("leave queue group: %w", err)
+		}
+	}
+	c.leftQueueGroup = true
+	log.Info().Str("queue", c.Opts.natsQueueName).Msg("left the ingest queue group")
+	return nil
+}
+
+// JoinQueueGroup has the instance take messages from the ingest queue group
+// again after LeaveQueueGroup.
+func (c *Conn) JoinQueueGroup() error {
+	if c.Opts.ingestDisabled {
+		return fmt.Errorf("join queue group: %w", ErrIngestDisabled)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.leftQueueGroup {
+		return nil
+	}
+	if c.nc == nil {
+		return fmt.Errorf("join queue group: %w", nats.ErrConnectionClosed)
+	}
+	if err := c.subscribe(); err != nil {
+		return fmt.Errorf("join queue group: %w", err)
+	}
+	if err := c.nc.Flush(); err != nil {
+		return fmt.Errorf("join queue group: %w", err)
+	}
+	c.leftQueueGroup = false
+	log.Info().Str("queue", c.Opts.natsQueueName).Msg("joined the ingest queue group")
+	return nil
+}
+
+// QueueGroupMember returns true if the instance is taking messages from the
+// ingest queue group.
+func (c *Conn) QueueGroupMember() bool {
+	if c.Opts.ingestDisabled {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !c.leftQueueGroup
+}
+
+func (c *Conn) handleMembershipRequest(msg *nats.Msg) {
+	reply := protocol.MembershipReply{InstanceID: c.instanceId}
+	req := protocol.MembershipRequest{}
+	if err := req.UnmarshalBinary(msg.Data); err != nil {
+		reply.Error = fmt.Sprintf("invalid membership request: %s", err)
+	} else {
+		var err error
+		switch req.Action {
+		case protocol.MembershipLeave:
+			err = c.LeaveQueueGroup()
+		case protocol.MembershipJoin:
+			err = c.JoinQueueGroup()
+		case protocol.MembershipStatus:
+		default:
+			err = fmt.Errorf("unknown membership action: %q", req.Action)
+		}
+		if err != nil {
+			reply.Error = err.Error()
+		}
+	}
+	reply.Member = c.QueueGroupMember()
+	reply.Time = time.Now()
+
+	data, err := reply.MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("unable to marshal membership reply")
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Err(err).Msg("unable to respond to membership request")
+	}
+}
@@ -0,0 +1,214 @@
+package requeue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// maxFinishedOperations is how many of the operations that have finished are
+// kept to be reported.
+const maxFinishedOperations = 32
+
+// cancelOperationTimeout is how long a request to cancel an operation waits
+// for it to stop before replying with its progress so far.
+const cancelOperationTimeout = 10 * time.Second
+
+// operations holds the progress of the long running operations, i.e., purges,
+// redrives, migrations, re-encryptions and replays, that are running or
+// finished recently.
+type operations struct {
+	mu  sync.Mutex
+	ops []*operation
+}
+
+// operation reports the progress of a long running operation. The operation
+// should stop at the next point it's safe to once its context is done.
+type operation struct {
+	ops *operations
+	op  *protocol.Operation
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Closed once the operation has finished.
+	done chan struct{}
+}
+
+// start records a new operation of the kind on the queue, which may be empty.
+// Its context is done once parent is, or it's canceled.
+func (r *operations) start(parent context.Context, kind protocol.OperationKind, queue string) *operation {
+	now := time.Now()
+	ctx, cancel := context.WithCancel(parent)
+	o := &operation{
+		ops: r,
+		op: &protocol.Operation{
+			ID:        uuid.Must(uuid.NewV4()).String(),
+			Kind:      kind,
+			State:     protocol.OperationRunning,
+			Queue:     queue,
+			StartedAt: now,
+			UpdatedAt: now,
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	r.mu.Lock()
+	r.ops = append(r.ops, o)
+	r.mu.Unlock()
+	return o
+}
+
+// progress records that done of total is done. It's a queue.Progress.
+func (o *operation) progress(done, total int) {
+	o.ops.mu.Lock()
+	o.op.Progress(int64(done), int64(total), time.Now())
+	o.ops.mu.Unlock()
+}
+
+// finish records that the operation finished, having failed if err isn't nil,
+// or been canceled if it's a context.Canceled. The oldest finished operations
+// are forgotten once there are too many.
+func (o *operation) finish(err error) {
+	r := o.ops
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o.op.Finish(err, time.Now())
+	o.cancel()
+	close(o.done)
+
+	finished := 0
+	for _, op := range r.ops {
+		if op.op.State != protocol.OperationRunning {
+			finished++
+		}
+	}
+	kept := r.ops[:0]
+	for _, op := range r.ops {
+		if finished > maxFinishedOperations && op.op.State != protocol.OperationRunning {
+			finished--
+			continue
+		}
+		kept = append(kept, op)
+	}
+	for i := len(kept); i < len(r.ops); i++ {
+		r.ops[i] = nil
+	}
+	r.ops = kept
+}
+
+func (o *operation) id() string {
+	return o.op.ID
+}
+
+// get returns the progress of the operation so far.
+func (o *operation) get() protocol.Operation {
+	o.ops.mu.Lock()
+	defer o.ops.mu.Unlock()
+	return *o.op
+}
+
+func (r *operations) list() protocol.Operations {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.ops) == 0 {
+		return nil
+	}
+	ops := make(protocol.Operations, len(r.ops))
+	for i, o := range r.ops {
+		ops[i] = *o.op
+	}
+	return ops
+}
+
+func (r *operations) find(id string) (*operation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, o := range r.ops {
+		if o.op.ID == id {
+			return o, true
+		}
+	}
+	return nil, false
+}
+
+// Operations returns the progress of the purges, redrives, migrations, and
+// re-encryptions that are running or finished recently, in the order they
+// were started. They are also included in the stats.
+func (c *Conn) Operations() protocol.Operations {
+	return c.operations.list()
+}
+
+// Operation returns the progress of the operation with the id, if it's
+// running or finished recently.
+func (c *Conn) Operation(id string) (protocol.Operation, bool) {
+	o, ok := c.operations.find(id)
+	if !ok {
+		return protocol.Operation{}, false
+	}
+	return o.get(), true
+}
+
+// CancelOperation stops the running operation with the id once it reaches a
+// point where it's safe to, e.g., between the batches of a purge, keeping what
+// it completed before then. It waits for the operation to stop, or ctx to be
+// done, and returns its progress. Operations started while connecting, such as
+// migrations, can't be canceled.
+func (c *Conn) CancelOperation(ctx context.Context, id string) (protocol.Operation, error) {
+	o, ok := c.operations.find(id)
+	if !ok {
+		return protocol.Operation{}, fmt.Errorf("cancel operation: no such operation: %q", id)
+	}
+	if p := o.get(); p.State != protocol.OperationRunning {
+		return p, fmt.Errorf("cancel operation: operation %s is %s", id, p.State)
+	}
+	o.cancel()
+	log.Info().Str("operation", id).Msg("canceling operation")
+	select {
+	case <-o.done:
+	case <-ctx.Done():
+	}
+	return o.get(), nil
+}
+
+func (c *Conn) handleOperationsRequest(msg *nats.Msg) {
+	reply := protocol.OperationsReply{InstanceID: c.instanceId}
+	req := protocol.OperationsRequest{}
+	if err := req.UnmarshalBinary(msg.Data); err != nil {
+		reply.Error = fmt.Sprintf("invalid operations request: %s", err)
+	} else if req.Cancel {
+		ctx, cancel := context.WithTimeout(c.Opts.ctx, cancelOperationTimeout)
+		op, err := c.CancelOperation(ctx, req.ID)
+		cancel()
+		if err != nil {
+			reply.Error = err.Error()
+		}
+		if op.ID != "" {
+			reply.Operations = protocol.Operations{op}
+		}
+	} else if req.ID != "" {
+		if op, ok := c.Operation(req.ID); ok {
+			reply.Operations = protocol.Operations{op}
+		} else {
+			reply.Error = fmt.Sprintf("no such operation: %q", req.ID)
+		}
+	} else {
+		reply.Operations = c.Operations()
+	}
+	reply.Time = time.Now()
+
+	data, err := reply.MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("unable to marshal operations reply")
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Err(err).Msg("unable to respond to operations request")
+	}
+}
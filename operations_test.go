@@ -0,0 +1,126 @@
+package requeue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperations(t *testing.T) {
+	var r operations
+	op := r.start(context.Background(), protocol.OperationPurge, "orders")
+	op.progress(5, 10)
+
+	got := op.get()
+	assert.Equal(t, protocol.OperationRunning, got.State)
+	assert.Equal(t, "orders", got.Queue)
+	assert.Equal(t, float64(50), got.Percent)
+
+	op.finish(errors.New("boom"))
+	got = op.get()
+	assert.Equal(t, protocol.OperationFailed, got.State)
+	assert.Equal(t, "boom", got.Error)
+
+	// Only the most recent finished operations are kept, while the running
+	// ones are kept no matter how old.
+	running := r.start(context.Background(), protocol.OperationMigration, "")
+	for i := 0; i < maxFinishedOperations; i++ {
+		r.start(context.Background(), protocol.OperationRedrive, fmt.Sprint(i)).finish(nil)
+	}
+	ops := r.list()
+	assert.Len(t, ops, maxFinishedOperations+1)
+	assert.Equal(t, running.id(), ops[0].ID)
+	_, ok := r.find(op.id())
+	assert.False(t, ok)
+}
+
+func TestPurgeQueueOperation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "operations-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	assert.NoError(t, c.initQueueManager())
+	assert.True(t, c.Features().Supports(protocol.FeatureOperations))
+	assert.Empty(t, c.Operations())
+
+	_, err = c.qManager.CreateQueue(queue.NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+	m := protocol.DefaultRequeueMessage()
+	assert.NoError(t, c.badgerDB.Update(func(txn *badger.Txn) error {
+		for i := 0; i < 3; i++ {
+			k := key.New(time.Now().Add(time.Duration(i) * time.Second))
+			if err := txn.Set(queue.NewQueueKeyForMessage("orders", k).Bytes(), m.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	n, err := c.PurgeQueue("orders")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	ops := c.Operations()
+	if assert.Len(t, ops, 1) {
+		assert.Equal(t, protocol.OperationPurge, ops[0].Kind)
+		assert.Equal(t, protocol.OperationDone, ops[0].State)
+		assert.Equal(t, "orders", ops[0].Queue)
+		assert.Equal(t, int64(3), ops[0].Done)
+		assert.Equal(t, int64(3), ops[0].Total)
+		assert.Equal(t, float64(100), ops[0].Percent)
+
+		op, ok := c.Operation(ops[0].ID)
+		assert.True(t, ok)
+		assert.Equal(t, ops[0], op)
+	}
+}
+
+func TestCancelOperation(t *testing.T) {
+	c := NewConn(GetDefaultOptions())
+	ctx := context.Background()
+
+	_, err := c.CancelOperation(ctx, "nope")
+	assert.Error(t, err)
+
+	// Stops at its next batch, keeping what it did.
+	op := c.operations.start(ctx, protocol.OperationPurge, "orders")
+	batches := make(chan struct{})
+	go func() {
+		var done int
+		for op.ctx.Err() == nil {
+			done++
+			op.progress(done, 0)
+			batches <- struct{}{}
+		}
+		op.finish(fmt.Errorf("purge: %w", op.ctx.Err()))
+	}()
+	<-batches
+	go func() {
+		for range batches {
+		}
+	}()
+
+	got, err := c.CancelOperation(ctx, op.id())
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.OperationCanceled, got.State)
+	assert.NotZero(t, got.Done)
+	close(batches)
+
+	_, err = c.CancelOperation(ctx, op.id())
+	assert.Error(t, err, "it isn't running")
+}
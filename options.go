@@ -0,0 +1,125 @@
+package requeue
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// OptionsError is returned by Connect when the options are invalid. It lists
+// every problem found rather than just the first.
+type OptionsError struct {
+	Errs []error
+}
+
+func (e *OptionsError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid options: %s", strings.Join(msgs, "; "))
+}
+
+// Validate checks the options for problems before anything is initialized. An
+// *OptionsError listing all of them is returned if any are found.
+func (o Options) Validate() error {
+	errs := make([]error, 0)
+	add := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	if o.ctx == nil {
+		add("connect context cannot be nil")
+	}
+
+	// Nats
+	if strings.TrimSpace(o.natsServers) == "" {
+		add("nats servers cannot be empty")
+	}
+	if err := protocol.ValidateSubject(o.natsSubject); err != nil {
+		add("invalid nats subject: %w", err)
+	}
+	if o.egressNatsServers != "" && strings.TrimSpace(o.egressNatsServers) == "" {
+		add("egress nats servers cannot be blank")
+	}
+	if o.bridge && !o.egressEnabled() {
+		add("bridge needs an egress nats connection")
+	}
+	if strings.ContainsAny(o.natsQueueName, " \t\r\n") {
+		add("nats queue name cannot contain whitespace: %q", o.natsQueueName)
+	}
+	if o.jetStreamEnabled() {
+		if err := o.jetStream.validate(); err != nil {
+			add("invalid jetstream consumer: %w", err)
+		}
+		if o.bridge {
+			add("bridge cannot ingest from jetstream")
+		}
+		if o.ackMode == AckOnDelivery {
+			add("ack on delivery cannot be used with jetstream")
+		}
+		if o.batchAckWindow > 0 {
+			add("batch acks cannot be used with jetstream")
+		}
+	}
+
+	// Badger
+	switch o.storage {
+	case StorageDisk:
+		if strings.TrimSpace(o.dataDir) == "" {
+			add("data dir cannot be empty")
+		}
+	case StorageMemory:
+	default:
+		add("unknown storage backend: %d", o.storage)
+	}
+	if len(o.takeoverDataDirs) > 0 && o.heartbeatInterval == 0 {
+		add("takeover needs heartbeats")
+	}
+	if o.handoffInterval > 0 {
+		if o.storage != StorageDisk {
+			add("handoff needs a store on disk")
+		}
+		if !o.republishDisabled {
+			add("handoff needs republishing disabled")
+		}
+	}
+
+	// Roles
+	if o.role == RoleIngest && o.handoffInterval == 0 {
+		add("ingest role needs a handoff interval so its store is drained")
+	}
+
+	// Queues
+	switch o.timeBucket {
+	case TimeBucketNone, TimeBucketHourly, TimeBucketDaily:
+	default:
+		add("unknown queue time bucket: %d", o.timeBucket)
+	}
+
+	// Ingest
+	switch o.ackMode {
+	case AckOnCommit, AckOnReceive, AckOnDelivery:
+	default:
+		add("unknown ack mode: %d", o.ackMode)
+	}
+	switch o.invalidPayloadAction {
+	case InvalidPayloadNak, InvalidPayloadQuarantine:
+	default:
+		add("unknown invalid payload action: %d", o.invalidPayloadAction)
+	}
+	if o.republishEncrypted && o.payloadEncrypter == nil && o.payloadKeyring == nil {
+		add("republishing encrypted payloads requires a payload encrypter or keyring")
+	}
+
+	// Quotas
+	if len(o.tenantQuotas) > 0 && o.queueTenantFn == nil {
+		add("tenant quotas require a function mapping queues to tenants")
+	}
+
+	if len(errs) > 0 {
+		return &OptionsError{Errs: errs}
+	}
+	return nil
+}
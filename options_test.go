@@ -0,0 +1,377 @@
+package requeue_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/nats-io/nats.go"
+	requeue "github.com/nickpoorman/nats-requeue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstanceIDOption(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.InstanceID("us-east-1a")(&o))
+	for _, id := range []string{"", "a.b", "a*", "a>", "a b", "a/b"} {
+		assert.Error(t, requeue.InstanceID(id)(&o), "id %q", id)
+	}
+}
+
+func TestQuotaOptions(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.QueueQuota("orders", 10)(&o))
+	assert.NoError(t, requeue.TenantQuota("acme", 0)(&o))
+	assert.Error(t, requeue.QueueQuota("", 10)(&o))
+	assert.Error(t, requeue.QueueQuota("orders", -1)(&o))
+	assert.Error(t, requeue.TenantQuota("", 10)(&o))
+	assert.Error(t, requeue.TenantQuota("acme", -1)(&o))
+}
+
+func TestQueueMinDelayOption(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.QueueMinDelay("digest", 10*time.Minute)(&o))
+	assert.Error(t, requeue.QueueMinDelay("", time.Minute)(&o))
+	assert.Error(t, requeue.QueueMinDelay("digest", -time.Minute)(&o))
+}
+
+func TestStorageTTLOption(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.StorageTTL(0)(&o))
+	assert.NoError(t, requeue.StorageTTL(time.Hour)(&o))
+	assert.Error(t, requeue.StorageTTL(-time.Hour)(&o))
+}
+
+func TestDeliveryPrecisionOption(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.DeliveryPrecision(requeue.DefaultDeliveryPrecision)(&o))
+	assert.Error(t, requeue.DeliveryPrecision(0)(&o))
+	assert.Error(t, requeue.DeliveryPrecision(-time.Second)(&o))
+}
+
+func TestTraceHeadersOption(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.TraceHeaders()(&o))
+	assert.NoError(t, requeue.DataDir("/tmp/requeue")(&o))
+	assert.NoError(t, o.Validate())
+}
+
+func TestCoalesceQueueOption(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.CoalesceQueue("prices")(&o))
+	assert.Error(t, requeue.CoalesceQueue("")(&o))
+}
+
+func TestOptionsValidate(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.DataDir("/tmp/requeue")(&o))
+	assert.NoError(t, o.Validate())
+
+	o = requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.NATSSubject("foo..bar")(&o))
+	assert.NoError(t, requeue.RepublishEncrypted()(&o))
+	err := o.Validate()
+	var optsErr *requeue.OptionsError
+	if assert.True(t, errors.As(err, &optsErr)) {
+		// The data dir, subject and missing encrypter are all reported.
+		assert.Len(t, optsErr.Errs, 3)
+	}
+}
+
+func TestEgressNATSOptions(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.DataDir("/tmp/requeue")(&o))
+	assert.NoError(t, requeue.EgressNATSServers("nats://egress:4222")(&o))
+	assert.NoError(t, requeue.EgressNATSOptions([]nats.Option{nats.Name("egress")})(&o))
+	assert.NoError(t, o.Validate())
+
+	assert.NoError(t, requeue.EgressNATSServers(" ")(&o))
+	assert.Error(t, o.Validate())
+}
+
+type nopKeyring struct{}
+
+func (nopKeyring) KeyID(string) (string, error)                 { return "", protocol.ErrKeyNotFound }
+func (nopKeyring) Encrypter(string) (protocol.Encrypter, error) { return nil, protocol.ErrKeyNotFound }
+
+func TestPayloadKeyringOption(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.Error(t, requeue.PayloadKeyring(nil)(&o))
+
+	// A keyring is enough to republish encrypted payloads.
+	assert.NoError(t, requeue.DataDir("/tmp/requeue")(&o))
+	assert.NoError(t, requeue.PayloadKeyring(nopKeyring{})(&o))
+	assert.NoError(t, requeue.RepublishEncrypted()(&o))
+	assert.NoError(t, o.Validate())
+}
+
+func TestBackoffOptions(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.Error(t, requeue.DefaultBackoff(nil)(&o))
+	assert.Error(t, requeue.MaxBackoff(0)(&o))
+	assert.NoError(t, requeue.DefaultBackoff(requeue.ExponentialWithJitterBackoff{Max: time.Minute})(&o))
+	assert.NoError(t, requeue.MaxBackoff(time.Hour)(&o))
+}
+
+func TestConnectAggregatesOptionErrors(t *testing.T) {
+	_, err := requeue.Connect(
+		requeue.InstanceID("a.b"),
+		requeue.MaxPayloadSize(-1),
+		requeue.NATSServers(""),
+	)
+	var optsErr *requeue.OptionsError
+	if assert.True(t, errors.As(err, &optsErr)) {
+		// Both bad options, the empty servers and the missing data dir.
+		assert.Len(t, optsErr.Errs, 4)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	for _, p := range []requeue.Option{
+		requeue.ProfileDurable(),
+		requeue.ProfileThroughput(),
+		requeue.ProfileLowLatency(),
+		requeue.ProfileLargePayloads(),
+	} {
+		o := requeue.GetDefaultOptions()
+		assert.NoError(t, p(&o))
+		assert.NoError(t, requeue.DataDir("/tmp/requeue")(&o))
+		assert.NoError(t, o.Validate())
+	}
+
+	o := requeue.GetDefaultOptions()
+	assert.Error(t, requeue.NumConsumers(0)(&o))
+	assert.Error(t, requeue.BatchMaxWait(0)(&o))
+	assert.NoError(t, requeue.BatchMaxSize(0)(&o))
+	assert.NoError(t, requeue.BatchMaxSize(512)(&o))
+	assert.Error(t, requeue.BatchMaxSize(-1)(&o))
+	assert.NoError(t, requeue.BatchCommitHandler(func(protocol.BatchCommit) {})(&o))
+	assert.NoError(t, requeue.DataDir("/tmp/requeue")(&o))
+	assert.NoError(t, requeue.IngestAckMode(requeue.AckOnDelivery)(&o))
+	assert.NoError(t, o.Validate())
+	assert.NoError(t, requeue.IngestAckMode(requeue.AckMode(9))(&o))
+	assert.Error(t, o.Validate())
+}
+
+func TestConsumerBatchSizeOption(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.ConsumerBatchSize(1)(&o))
+	assert.NoError(t, requeue.ConsumerBatchSize(requeue.DefaultConsumerBatchSize)(&o))
+	assert.Error(t, requeue.ConsumerBatchSize(0)(&o))
+}
+
+func TestCrashDumpsOption(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.CrashDumps(0)(&o))
+	assert.NoError(t, requeue.CrashDumps(32)(&o))
+	assert.Error(t, requeue.CrashDumps(-1)(&o))
+}
+
+func TestSubjectOptions(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.AllowSubjects("orders.>", "payments.*")(&o))
+	assert.NoError(t, requeue.DenySubjects("orders.test.>")(&o))
+	assert.Error(t, requeue.AllowSubjects("orders.")(&o))
+	assert.Error(t, requeue.DenySubjects("")(&o))
+}
+
+func TestCompressionOptions(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.TableCompression(requeue.CompressionSnappy)(&o))
+	assert.Error(t, requeue.TableCompression(requeue.Compression(9))(&o))
+	assert.NoError(t, requeue.ZSTDCompressionLevel(3)(&o))
+	assert.Error(t, requeue.ZSTDCompressionLevel(0)(&o))
+	assert.NoError(t, requeue.BlockCacheSize(requeue.DefaultBlockCacheSize)(&o))
+	assert.Error(t, requeue.BlockCacheSize(-1)(&o))
+}
+
+func TestHookOptions(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.HookWorkers(4)(&o))
+	assert.Error(t, requeue.HookWorkers(0)(&o))
+	assert.NoError(t, requeue.HookQueueSize(0)(&o))
+	assert.Error(t, requeue.HookQueueSize(-1)(&o))
+
+	c := requeue.NewConn(o)
+	assert.Equal(t, uint64(0), c.DroppedHooks())
+}
+
+func TestAckFailureOptions(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.AckFailureRetention(0)(&o))
+	assert.NoError(t, requeue.AckFailureRetention(time.Hour)(&o))
+	assert.Error(t, requeue.AckFailureRetention(-time.Second)(&o))
+	assert.NoError(t, requeue.AckFailureHandler(func(protocol.AckFailure) {})(&o))
+
+	c := requeue.NewConn(o)
+	assert.Error(t, c.AckFailures("orders", func(protocol.AckFailure) bool { return true }))
+	assert.Error(t, c.ResolveAckFailure("orders", "not a key"))
+}
+
+func TestRejectionsOptions(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.RejectionsRetention(0)(&o))
+	assert.NoError(t, requeue.RejectionsRetention(time.Hour)(&o))
+	assert.Error(t, requeue.RejectionsRetention(-time.Second)(&o))
+
+	// There is nothing to report on until the store is open.
+	c := requeue.NewConn(o)
+	_, err := c.Rejections(protocol.RejectionsRequest{})
+	assert.Error(t, err)
+}
+
+func TestInstanceRole(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.InstanceRole(requeue.RoleIngest)(&o))
+	assert.NoError(t, requeue.InstanceRole(requeue.RoleRepublish)(&o))
+	assert.NoError(t, requeue.InstanceRole(requeue.RoleAll)(&o))
+	assert.Error(t, requeue.InstanceRole(requeue.Role(9))(&o))
+	assert.Equal(t, "ingest", requeue.RoleIngest.String())
+
+	// The stores of an ingest instance must be handed off to be drained.
+	o = requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.DataDir("/tmp/requeue")(&o))
+	assert.NoError(t, requeue.InstanceRole(requeue.RoleIngest)(&o))
+	assert.Error(t, o.Validate())
+	assert.NoError(t, requeue.HandoffInterval(time.Minute)(&o))
+	assert.NoError(t, o.Validate())
+	assert.Error(t, requeue.HandoffInterval(-time.Minute)(&o))
+
+	// There is nothing to hand off from memory, and handing off the store of
+	// an instance that republishes would strand its messages.
+	assert.NoError(t, requeue.Storage(requeue.StorageMemory)(&o))
+	assert.Error(t, o.Validate())
+	o = requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.DataDir("/tmp/requeue")(&o))
+	assert.NoError(t, requeue.HandoffInterval(time.Minute)(&o))
+	assert.Error(t, o.Validate())
+}
+
+func TestCompatibilityRevisionOption(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.CompatibilityRevision(protocol.Revision1)(&o))
+	assert.Error(t, requeue.CompatibilityRevision(0)(&o))
+
+	c := requeue.NewConn(o)
+	assert.Equal(t, protocol.Revision1, c.Revision())
+	assert.NoError(t, c.SetRevision(protocol.CurrentRevision))
+	assert.Equal(t, protocol.CurrentRevision, c.Revision())
+	assert.Error(t, c.SetRevision(protocol.CurrentRevision+1))
+	assert.Equal(t, protocol.CurrentRevision, c.Revision())
+}
+
+func TestSampleAboveRate(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.SampleAboveRate(10000, requeue.DefaultSampleEvery)(&o))
+	assert.Error(t, requeue.SampleAboveRate(0, requeue.DefaultSampleEvery)(&o))
+	assert.Error(t, requeue.SampleAboveRate(10000, 0)(&o))
+
+	c := requeue.NewConn(o)
+	if assert.NotNil(t, c.Sampling()) {
+		assert.False(t, c.Sampling().Active)
+		assert.Equal(t, float64(10000), c.Sampling().Threshold)
+	}
+	assert.Nil(t, requeue.NewConn(requeue.GetDefaultOptions()).Sampling())
+}
+
+func TestReadinessChecks(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.ReadinessChecks(requeue.ReadinessThresholds{MaxPending: 10})(&o))
+	assert.Error(t, requeue.ReadinessChecks(requeue.ReadinessThresholds{MaxCommitLatency: -time.Second})(&o))
+	assert.Error(t, requeue.ReadinessChecks(requeue.ReadinessThresholds{MaxPending: -1})(&o))
+	assert.Error(t, requeue.ReadinessChecks(requeue.ReadinessThresholds{MinFreeDisk: 1})(&o))
+}
+
+func TestJetStream(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.DataDir("/tmp/requeue")(&o))
+	assert.NoError(t, requeue.JetStream(requeue.JetStreamConsumer{Stream: "ORDERS", Consumer: "requeue"})(&o))
+	assert.NoError(t, o.Validate())
+
+	assert.NoError(t, requeue.IngestAckMode(requeue.AckOnDelivery)(&o))
+	assert.Error(t, o.Validate())
+
+	for _, js := range []requeue.JetStreamConsumer{
+		{Consumer: "requeue"},
+		{Stream: "ORDERS"},
+		{Stream: "ORDERS.east", Consumer: "requeue"},
+		{Stream: "ORDERS", Consumer: "re queue"},
+		{Stream: "ORDERS", Consumer: "*"},
+	} {
+		assert.Error(t, requeue.JetStream(js)(&o), js)
+	}
+}
+
+func TestIsolateQueueWriters(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.IsolateQueueWriters(64)(&o))
+	assert.Error(t, requeue.IsolateQueueWriters(0)(&o))
+	assert.Error(t, requeue.IsolateQueueWriters(-1)(&o))
+}
+
+func TestBadgerOptions(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.BadgerOptions(func(o badger.Options) badger.Options {
+		return o.WithNumVersionsToKeep(2)
+	})(&o))
+}
+
+func TestPreflightMinFreeDisk(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.PreflightMinFreeDisk(0)(&o))
+	assert.NoError(t, requeue.PreflightMinFreeDisk(0.1)(&o))
+	assert.Error(t, requeue.PreflightMinFreeDisk(-0.1)(&o))
+	assert.Error(t, requeue.PreflightMinFreeDisk(1)(&o))
+}
+
+func TestValueLogGC(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.ValueLogGC(time.Minute, 0.7)(&o))
+	assert.NoError(t, requeue.ValueLogGC(0, 0.5)(&o))
+	assert.Error(t, requeue.ValueLogGC(-time.Minute, 0.5)(&o))
+	assert.Error(t, requeue.ValueLogGC(time.Minute, 0)(&o))
+	assert.Error(t, requeue.ValueLogGC(time.Minute, 1)(&o))
+}
+
+func TestDataDirs(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.DataDirs("/mnt/a/requeue", "/mnt/b/requeue")(&o))
+	assert.NoError(t, o.Validate())
+	assert.Error(t, requeue.DataDirs()(&o))
+	assert.Error(t, requeue.DataDirs("/mnt/a/requeue", " ")(&o))
+}
+
+func TestHeartbeats(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.DataDir("/tmp/requeue")(&o))
+	assert.Error(t, requeue.Heartbeats(0)(&o))
+	assert.Error(t, requeue.Takeover()(&o))
+	assert.Error(t, requeue.Takeover("/mnt/shared", "")(&o))
+	assert.NoError(t, requeue.Takeover("/mnt/shared")(&o))
+	assert.Error(t, o.Validate())
+	assert.NoError(t, requeue.Heartbeats(requeue.DefaultHeartbeatInterval)(&o))
+	assert.NoError(t, o.Validate())
+}
+
+func TestBatchAcks(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.NoError(t, requeue.DataDir("/tmp/requeue")(&o))
+	assert.Error(t, requeue.BatchAcks(0, 100)(&o))
+	assert.Error(t, requeue.BatchAcks(time.Millisecond, 0)(&o))
+	assert.NoError(t, requeue.BatchAcks(time.Millisecond, 100)(&o))
+	assert.NoError(t, o.Validate())
+	assert.NoError(t, requeue.JetStream(requeue.JetStreamConsumer{Stream: "ORDERS", Consumer: "requeue"})(&o))
+	assert.Error(t, o.Validate())
+}
+
+func TestStorage(t *testing.T) {
+	o := requeue.GetDefaultOptions()
+	assert.Error(t, o.Validate())
+	assert.NoError(t, requeue.Storage(requeue.StorageMemory)(&o))
+	assert.NoError(t, o.Validate())
+	assert.NoError(t, requeue.Storage(requeue.StorageBackend(42))(&o))
+	assert.Error(t, o.Validate())
+	assert.Equal(t, "memory", requeue.StorageMemory.String())
+}
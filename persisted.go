@@ -0,0 +1,63 @@
+package requeue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// persistedCounter counts the messages that have been durably committed so
+// callers can wait for them.
+type persistedCounter struct {
+	mu sync.Mutex
+	n  int64
+	// Closed, and replaced, every time the count changes.
+	changed chan struct{}
+}
+
+// add counts delta more committed messages and wakes up the waiters.
+func (p *persistedCounter) add(delta int64) {
+	p.mu.Lock()
+	p.n += delta
+	if p.changed != nil {
+		close(p.changed)
+		p.changed = nil
+	}
+	p.mu.Unlock()
+}
+
+// load returns the count and a channel that is closed when it next changes.
+func (p *persistedCounter) load() (int64, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.changed == nil {
+		p.changed = make(chan struct{})
+	}
+	return p.n, p.changed
+}
+
+// Persisted returns the number of messages that have been durably committed
+// since the connection was created.
+func (c *Conn) Persisted() int64 {
+	n, _ := c.persisted.load()
+	return n
+}
+
+// WaitForPersisted blocks until n more messages have been durably committed
+// since it was called, so tests and batch producers can synchronize with the
+// store without sleeping. It doesn't know which messages were committed, only
+// how many, so it should be called before the messages are published. An error
+// is returned if ctx is done, or the connection is closed, first.
+func (c *Conn) WaitForPersisted(ctx context.Context, n int) error {
+	start, changed := c.persisted.load()
+	for cur := start; cur-start < int64(n); cur, changed = c.persisted.load() {
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return fmt.Errorf("wait for persisted: %w", ctx.Err())
+		case <-c.closed:
+			return fmt.Errorf("wait for persisted: connection closed")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package requeue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForPersisted(t *testing.T) {
+	c := NewConn(GetDefaultOptions())
+
+	// Nothing to wait for.
+	assert.NoError(t, c.WaitForPersisted(context.Background(), 0))
+
+	// Only the messages committed after the call count.
+	c.persisted.add(2)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.WaitForPersisted(context.Background(), 3)
+	}()
+	waiting(t, c)
+	c.persisted.add(2)
+	select {
+	case err := <-done:
+		t.Fatalf("returned before the messages were persisted: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.persisted.add(1)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for persisted messages")
+	}
+	assert.Equal(t, int64(5), c.Persisted())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.True(t, errors.Is(c.WaitForPersisted(ctx, 1), context.DeadlineExceeded))
+
+	go c.Close()
+	assert.Error(t, c.WaitForPersisted(context.Background(), 1))
+}
+
+// waiting blocks until something is waiting for the count to change.
+func waiting(t *testing.T, c *Conn) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.persisted.mu.Lock()
+		ok := c.persisted.changed != nil
+		c.persisted.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("timed out waiting for a waiter")
+}
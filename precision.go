@@ -0,0 +1,38 @@
+package requeue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// DefaultDeliveryPrecision is a sensible tolerance for DeliveryPrecision.
+const DefaultDeliveryPrecision = 250 * time.Millisecond
+
+// DeliveryPrecision republishes messages no more than tolerance after the
+// time they become ready, and never before, as long as the instance keeps up.
+// Without it messages are republished anywhere in the second they become
+// ready since that's the precision of their keys. The precise time is stored
+// with each message, which costs a rebuild of messages whose producer didn't
+// leave room for it.
+func DeliveryPrecision(tolerance time.Duration) Option {
+	return func(o *Options) error {
+		if tolerance <= 0 {
+			return fmt.Errorf("delivery precision tolerance must be positive: %s", tolerance)
+		}
+		o.deliveryPrecision = tolerance
+		return nil
+	}
+}
+
+// stampReadyAt returns the message data with the precise time it becomes
+// ready when delivery precision is enabled.
+func (c *Conn) stampReadyAt(data []byte, received time.Time) []byte {
+	if c.Opts.deliveryPrecision == 0 {
+		return data
+	}
+	fb := flatbuf.GetRootAsRequeueMessage(data, 0)
+	return protocol.SetReadyAt(data, received.Add(time.Duration(fb.Delay())))
+}
@@ -0,0 +1,97 @@
+package requeue
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultPreflightMinFreeDisk is the least fraction of the disk that must be
+// free for Connect to succeed by default.
+const DefaultPreflightMinFreeDisk = 0.01
+
+// PreflightMinFreeDisk sets the least fraction of the disk the data dir is on
+// that must be free for Connect to succeed. Zero disables the check.
+func PreflightMinFreeDisk(fraction float64) Option {
+	return func(o *Options) error {
+		if fraction < 0 || fraction >= 1 {
+			return fmt.Errorf("preflight min free disk must be at least 0 and less than 1: %v", fraction)
+		}
+		o.preflightMinFreeDisk = fraction
+		return nil
+	}
+}
+
+// PreflightError is returned by Connect when the storage fails one of the
+// checks made before subscribing, so an instance never takes messages it
+// can't persist.
+type PreflightError struct {
+	// The check that failed.
+	Check string
+	Err   error
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("preflight: %s: %v", e.Check, e.Err)
+}
+
+func (e *PreflightError) Unwrap() error {
+	return e.Err
+}
+
+// preflight checks that the instance directory can be written to, that there
+// is enough disk free, and that the store can be written to, read from, and
+// deleted from. The directory and disk aren't checked for a store in memory.
+func (c *Conn) preflight() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !badgerInternal.InMemory(c.badgerOpenOptions()...) {
+		if err := checkDirWritable(c.instanceDir); err != nil {
+			return &PreflightError{Check: "data directory", Err: err}
+		}
+
+		if min := c.Opts.preflightMinFreeDisk; min > 0 {
+			free, total, err := badgerInternal.DiskUsage(c.Opts.dataDir)
+			if err != nil {
+				return &PreflightError{Check: "disk", Err: err}
+			}
+			if total > 0 && float64(free)/float64(total) < min {
+				return &PreflightError{Check: "disk", Err: fmt.Errorf(
+					"%d of %d bytes are free, below %.1f%%", free, total, min*100)}
+			}
+		}
+	}
+
+	probe := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+	if err := badgerInternal.Probe(c.badgerDB, probe); err != nil {
+		return &PreflightError{Check: "store", Err: err}
+	}
+
+	log.Debug().Msg("preflight checks passed")
+	return nil
+}
+
+// checkDirWritable creates, writes, and removes a file in the directory.
+func checkDirWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".preflight-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte("preflight"))
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := os.Remove(f.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
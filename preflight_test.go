@@ -0,0 +1,51 @@
+package requeue
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreflight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preflight-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	c := NewConn(o)
+	assert.NoError(t, c.initBadger())
+	defer c.Close()
+	assert.NoError(t, c.preflight())
+
+	// The probe doesn't leave anything behind.
+	files, err := ioutil.ReadDir(c.instanceDir)
+	assert.NoError(t, err)
+	for _, f := range files {
+		assert.NotContains(t, f.Name(), ".preflight-")
+	}
+
+	c.Opts.preflightMinFreeDisk = 0.999999
+	var pErr *PreflightError
+	assert.True(t, errors.As(c.preflight(), &pErr))
+	assert.Equal(t, "disk", pErr.Check)
+	c.Opts.preflightMinFreeDisk = 0
+
+	instanceDir := c.instanceDir
+	c.instanceDir = filepath.Join(dir, "missing")
+	assert.True(t, errors.As(c.preflight(), &pErr))
+	assert.Equal(t, "data directory", pErr.Check)
+	c.instanceDir = instanceDir
+
+	// The directory and disk aren't checked for a store in memory.
+	c.Opts.badgerOptions = func(o badger.Options) badger.Options {
+		return o.WithInMemory(true)
+	}
+	c.Opts.preflightMinFreeDisk = 0.999999
+	assert.NoError(t, c.preflight())
+}
@@ -0,0 +1,82 @@
+package requeue
+
+import (
+	"runtime"
+	"time"
+
+	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+)
+
+// ProfileDurable favors never losing an acknowledged message. Writes are
+// synced to disk and messages are only acknowledged once committed. This
+// matches the defaults.
+//
+// Options given after a profile override it.
+func ProfileDurable() Option {
+	return combine(
+		SyncWrites(true),
+		BatchMaxWait(15*time.Millisecond),
+		IngestAckMode(AckOnCommit),
+		NumConsumers(DefaultNumConcurrentBatchTransactions),
+	)
+}
+
+// ProfileThroughput favors ingesting as many messages as possible. Writes are
+// batched for longer and not synced to disk, so the most recently
+// acknowledged messages can be lost if the machine crashes, but not if the
+// process does.
+//
+// Options given after a profile override it.
+func ProfileThroughput() Option {
+	return combine(
+		SyncWrites(false),
+		BatchMaxWait(50*time.Millisecond),
+		IngestAckMode(AckOnCommit),
+		NumConsumers(2*runtime.NumCPU()),
+	)
+}
+
+// ProfileLowLatency favors replying to publishers as quickly as possible.
+// Messages are acknowledged as soon as they're received and committed in
+// small batches without syncing, so acknowledged messages can be lost if the
+// process or machine crashes.
+//
+// Options given after a profile override it.
+func ProfileLowLatency() Option {
+	return combine(
+		SyncWrites(false),
+		BatchMaxWait(time.Millisecond),
+		IngestAckMode(AckOnReceive),
+		NumConsumers(runtime.NumCPU()),
+	)
+}
+
+// ProfileLargePayloads favors keeping the store small when payloads are
+// large and compressible, e.g., JSON or text. Tables are compressed with ZSTD,
+// or Snappy when built without cgo, and decompressed blocks are cached so
+// reads stay fast. It only sets how messages are stored so it can be combined
+// with the other profiles.
+//
+// Options given after a profile override it.
+func ProfileLargePayloads() Option {
+	compression := CompressionZSTD
+	if !badgerInternal.ZSTDSupported() {
+		compression = CompressionSnappy
+	}
+	return combine(
+		TableCompression(compression),
+		BlockCacheSize(DefaultBlockCacheSize),
+	)
+}
+
+// combine returns an Option that applies all the options in order.
+func combine(options ...Option) Option {
+	return func(o *Options) error {
+		for _, opt := range options {
+			if err := opt(o); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
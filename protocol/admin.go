@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// QueueSummary describes a queue for the admin API.
+type QueueSummary struct {
+	Name string `json:"name"`
+	// The number of messages waiting in the queue. It's eventually
+	// consistent.
+	Depth    int64 `json:"depth"`
+	InFlight int64 `json:"in_flight"`
+	// When the oldest message in the queue was first enqueued and how long
+	// ago that was. They're omitted when the queue is empty.
+	OldestEnqueuedAt *time.Time `json:"oldest_enqueued_at,omitempty"`
+	OldestAgeSeconds float64    `json:"oldest_age_seconds,omitempty"`
+}
+
+func (s QueueSummary) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s *QueueSummary) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, s)
+}
+
+// PeekedMessage is a message pending in a queue, looked up by its key without
+// taking it out of the queue.
+type PeekedMessage struct {
+	Queue string `json:"queue"`
+	// Key is the readable form of the key the message is stored under.
+	Key        string    `json:"key"`
+	MessageID  string    `json:"message_id"`
+	Subject    string    `json:"subject"`
+	ReadyAt    time.Time `json:"ready_at"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	Attempts   uint64    `json:"attempts"`
+	Retries    uint64    `json:"retries"`
+	// ExpiresAt is omitted if the message never expires.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// The RequeueMessage as it's stored, so its payload is still encrypted if
+	// payloads are encrypted.
+	Message []byte `json:"message"`
+}
+
+func (m PeekedMessage) MarshalBinary() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+func (m *PeekedMessage) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, m)
+}
+
+// PurgeReply is the result of purging a queue.
+type PurgeReply struct {
+	Queue  string `json:"queue"`
+	Purged int64  `json:"purged"`
+	// Error is set if the purge failed part way.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+func (r PurgeReply) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *PurgeReply) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminMarshalUnmarshalBinary(t *testing.T) {
+	oldest := time.Unix(100, 0).UTC()
+	s := QueueSummary{
+		Name:             "orders",
+		Depth:            10,
+		InFlight:         2,
+		OldestEnqueuedAt: &oldest,
+		OldestAgeSeconds: 1.5,
+	}
+	b, err := s.MarshalBinary()
+	assert.NoError(t, err)
+	outSummary := QueueSummary{}
+	assert.NoError(t, outSummary.UnmarshalBinary(b))
+	assert.Equal(t, s, outSummary)
+
+	// An empty queue has no oldest message.
+	b, err = QueueSummary{Name: "empty"}.MarshalBinary()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name":"empty","depth":0,"in_flight":0}`, string(b))
+
+	m := PeekedMessage{
+		Queue:      "orders",
+		Key:        "1.2.3",
+		MessageID:  "1.2.3",
+		Subject:    "orders.created",
+		ReadyAt:    time.Unix(101, 0).UTC(),
+		EnqueuedAt: time.Unix(100, 0).UTC(),
+		Attempts:   1,
+		Retries:    5,
+		Message:    []byte("msg"),
+	}
+	b, err = m.MarshalBinary()
+	assert.NoError(t, err)
+	outMsg := PeekedMessage{}
+	assert.NoError(t, outMsg.UnmarshalBinary(b))
+	assert.Equal(t, m, outMsg)
+
+	r := PurgeReply{Queue: "orders", Purged: 10, Time: time.Unix(100, 0).UTC()}
+	b, err = r.MarshalBinary()
+	assert.NoError(t, err)
+	outReply := PurgeReply{}
+	assert.NoError(t, outReply.UnmarshalBinary(b))
+	assert.Equal(t, r, outReply)
+}
@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// CorrelationIDHeader holds the id a producer gives a message so it can
+	// be acknowledged in a BatchAck along with the other messages sent with
+	// the same reply subject. A Nak for the message is sent with the header
+	// too.
+	CorrelationIDHeader = "Requeue-Correlation-Id"
+
+	// BatchAckHeader is set on a BatchAck to the number of messages it
+	// acknowledges, which tells it apart from a Nak.
+	BatchAckHeader = "Requeue-Batch-Ack"
+)
+
+// BatchAck acknowledges the messages sent with the same reply subject, by
+// their correlation ids, in the order they were acknowledged.
+type BatchAck struct {
+	IDs []string `json:"ids"`
+}
+
+func (a BatchAck) MarshalBinary() ([]byte, error) {
+	return json.Marshal(a)
+}
+
+func (a *BatchAck) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, a)
+}
+
+// Msg returns the BatchAck as a message to send to the reply subject.
+func (a BatchAck) Msg(reply string) (*nats.Msg, error) {
+	data, err := a.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	msg := &nats.Msg{Subject: reply, Header: make(http.Header), Data: data}
+	msg.Header.Set(BatchAckHeader, strconv.Itoa(len(a.IDs)))
+	return msg, nil
+}
+
+// BatchAckFromNATS returns the BatchAck in the reply. False is returned if
+// the reply isn't one, e.g., because it's a Nak.
+func BatchAckFromNATS(msg *nats.Msg) (BatchAck, bool) {
+	a := BatchAck{}
+	if msg.Header == nil || msg.Header.Get(BatchAckHeader) == "" {
+		return a, false
+	}
+	if err := a.UnmarshalBinary(msg.Data); err != nil {
+		return a, false
+	}
+	return a, true
+}
@@ -0,0 +1,25 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchAck(t *testing.T) {
+	msg, err := BatchAck{IDs: []string{"1", "2"}}.Msg("inbox.a")
+	assert.NoError(t, err)
+	assert.Equal(t, "inbox.a", msg.Subject)
+	assert.Equal(t, "2", msg.Header.Get(BatchAckHeader))
+
+	ba, ok := BatchAckFromNATS(msg)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"1", "2"}, ba.IDs)
+
+	// A Nak isn't one.
+	data, err := Nak{Reason: NakReasonInvalidPayload}.MarshalBinary()
+	assert.NoError(t, err)
+	_, ok = BatchAckFromNATS(&nats.Msg{Data: data})
+	assert.False(t, ok)
+}
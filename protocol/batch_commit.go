@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+)
+
+// BatchCommitStats are the batches of messages committed to a queue since the
+// instance started. Entries are writes, like in a BatchCommit. Divide the
+// Entries and Bytes by the Commits for the size of the average batch.
+type BatchCommitStats struct {
+	Commits int64 `json:"commits"`
+	Entries int64 `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	// How long the batches took to commit.
+	Latency LatencyStats `json:"latency"`
+}
+
+func (s *BatchCommitStats) toFlatbuf(b *flatbuffers.Builder) flatbuffers.UOffsetT {
+	latency := s.Latency.toFlatbuf(b)
+	flatbuf.BatchCommitStatsStart(b)
+	flatbuf.BatchCommitStatsAddCommits(b, s.Commits)
+	flatbuf.BatchCommitStatsAddEntries(b, s.Entries)
+	flatbuf.BatchCommitStatsAddBytes(b, s.Bytes)
+	flatbuf.BatchCommitStatsAddLatency(b, latency)
+	return flatbuf.BatchCommitStatsEnd(b)
+}
+
+func (s *BatchCommitStats) fromFlatbuf(m *flatbuf.BatchCommitStats) {
+	if m == nil {
+		*s = BatchCommitStats{}
+		return
+	}
+	s.Commits = m.Commits()
+	s.Entries = m.Entries()
+	s.Bytes = m.Bytes()
+	s.Latency.fromFlatbuf(m.Latency(nil))
+}
+
+// BatchCommit describes a batch of messages committed to a queue.
+type BatchCommit struct {
+	Queue string `json:"queue"`
+	// The number of writes in the batch, which counts the expiry index of
+	// messages with a TTL too, and the bytes of their keys and values.
+	Entries int `json:"entries"`
+	Bytes   int `json:"bytes"`
+	// How long the batch took to commit.
+	Duration time.Duration `json:"duration"`
+	// Error is why the batch couldn't be committed, if it couldn't.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+func (c BatchCommit) MarshalBinary() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func (c *BatchCommit) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, c)
+}
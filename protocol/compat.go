@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Revision is a revision of the messages requeue emits, i.e., the envelopes it
+// stores and the stats it publishes. During a rolling upgrade the upgraded
+// instances can keep emitting the revision the rest of the fleet understands
+// until every instance has been upgraded.
+type Revision int
+
+const (
+	// Revision1 is the messages as they were before instances advertised
+	// their version.
+	Revision1 Revision = 1
+
+	// Revision2 adds the version and features of the instance to the stats,
+	// and the message id and first enqueue time to retried envelopes.
+	Revision2 Revision = 2
+
+	// CurrentRevision is the latest revision.
+	CurrentRevision = Revision2
+)
+
+// Validate returns an error if requeue doesn't know the revision.
+func (r Revision) Validate() error {
+	if r < Revision1 || r > CurrentRevision {
+		return fmt.Errorf("unknown revision %d, expected %d to %d", r, Revision1, CurrentRevision)
+	}
+	return nil
+}
+
+// CompatSubject is where every instance answers CompatRequests so the whole
+// fleet can be switched to a new revision at once.
+const CompatSubject = ControlSubjectPrefix + "compat"
+
+// InstanceCompatSubject is where a single instance answers CompatRequests.
+func InstanceCompatSubject(instanceId string) string {
+	return ControlSubjectPrefix + instanceId + ".compat"
+}
+
+// CompatRequest asks instances to emit a revision, e.g., the
+// CurrentRevision once the whole fleet has been upgraded.
+type CompatRequest struct {
+	Revision Revision `json:"revision"`
+}
+
+func (r CompatRequest) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *CompatRequest) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// CompatReport is the reply to a CompatRequest.
+type CompatReport struct {
+	InstanceID string `json:"instance_id"`
+	// Revision is the revision the instance now emits.
+	Revision Revision `json:"revision"`
+	// Error is set if the request couldn't be applied.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+func (r CompatReport) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *CompatReport) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// Downgrade removes what was added to the stats after the revision.
+func (i *InstanceStatsMessage) Downgrade(r Revision) {
+	if r < Revision2 {
+		i.Version = ""
+		i.Features = nil
+	}
+}
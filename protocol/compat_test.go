@@ -0,0 +1,43 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevisionValidate(t *testing.T) {
+	assert.NoError(t, Revision1.Validate())
+	assert.NoError(t, CurrentRevision.Validate())
+	assert.Error(t, Revision(0).Validate())
+	assert.Error(t, (CurrentRevision + 1).Validate())
+}
+
+func TestCompatReportMarshalUnmarshalBinary(t *testing.T) {
+	r := CompatReport{
+		InstanceID: "Inst1234",
+		Revision:   Revision1,
+		Time:       time.Unix(100, 0).UTC(),
+	}
+
+	b, err := r.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := CompatReport{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, r, out)
+}
+
+func TestInstanceStatsMessageDowngrade(t *testing.T) {
+	ism := InstanceStatsMessage{
+		InstanceId: "Inst1234",
+		Version:    "1.2.3",
+		Features:   Features{FeatureReceipts},
+	}
+	ism.Downgrade(CurrentRevision)
+	assert.Equal(t, "1.2.3", ism.Version)
+
+	ism.Downgrade(Revision1)
+	assert.Equal(t, InstanceStatsMessage{InstanceId: "Inst1234"}, ism)
+}
@@ -0,0 +1,179 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ConformanceVector is a RequeueMessage along with its flatbuffer encoding as
+// produced by this package. Producers written in other languages can check
+// that they decode Bytes to Message, and that requeue decodes what they
+// encode to the same Message. Flatbuffer builders are free to lay out a
+// message differently so the bytes they encode need not match exactly.
+type ConformanceVector struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Message is the decoded form of Bytes. The original payload is base64
+	// encoded in the JSON.
+	Message RequeueMessage `json:"message"`
+	// Bytes is the flatbuffer encoding of Message. It's hex encoded in the
+	// JSON.
+	Bytes []byte `json:"-"`
+	Hex   string `json:"hex"`
+}
+
+// ConformanceVectors returns the canonical conformance vectors.
+func ConformanceVectors() []ConformanceVector {
+	vectors := []struct {
+		name        string
+		description string
+		msg         RequeueMessage
+	}{
+		{
+			name:        "default",
+			description: "A message with only the subject and payload set.",
+			msg: func() RequeueMessage {
+				m := DefaultRequeueMessage()
+				m.OriginalSubject = "orders.created"
+				m.OriginalPayload = []byte(`{"id":1}`)
+				return m
+			}(),
+		},
+		{
+			name:        "empty_payload",
+			description: "A message without a payload.",
+			msg: func() RequeueMessage {
+				m := DefaultRequeueMessage()
+				m.OriginalSubject = "orders.created"
+				return m
+			}(),
+		},
+		{
+			name:        "exponential_backoff",
+			description: "A message with retries, a TTL, and a delay that backs off exponentially.",
+			msg: RequeueMessage{
+				Retries:         5,
+				TTL:             uint64(time.Hour),
+				Delay:           uint64(time.Second),
+				BackoffStrategy: BackoffStrategy_Exponential,
+				QueueName:       "orders",
+				OriginalSubject: "orders.created",
+				OriginalPayload: []byte("hello"),
+			},
+		},
+		{
+			name:        "fixed_backoff",
+			description: "A message with a fixed delay between retries and its own ack timeout.",
+			msg: RequeueMessage{
+				Retries:         10,
+				Delay:           uint64(30 * time.Second),
+				BackoffStrategy: BackoffStrategy_Fixed,
+				QueueName:       "webhooks",
+				OriginalSubject: "webhooks.deliver",
+				OriginalPayload: []byte{0x00, 0x01, 0xfe, 0xff},
+				AckTimeout:      uint64(10 * time.Second),
+			},
+		},
+		{
+			name:        "dedupe_key",
+			description: "A message for a coalescing queue.",
+			msg: RequeueMessage{
+				Retries:         3,
+				QueueName:       "profiles",
+				OriginalSubject: "profiles.updated",
+				OriginalPayload: []byte("v2"),
+				DedupeKey:       "user-42",
+			},
+		},
+		{
+			name:        "metadata",
+			description: "A message with metadata, whose entries are sorted by key.",
+			msg: RequeueMessage{
+				Retries:         1,
+				QueueName:       "orders",
+				OriginalSubject: "orders.created",
+				OriginalPayload: []byte("hello"),
+				Metadata:        Metadata{"user_id": "123", "tenant": "acme"},
+			},
+		},
+		{
+			name:        "queue_group",
+			description: "A message intended for the members of a downstream queue group.",
+			msg: RequeueMessage{
+				Retries:         3,
+				QueueName:       "orders",
+				OriginalSubject: "orders.created",
+				OriginalPayload: []byte("hello"),
+				QueueGroup:      "workers",
+			},
+		},
+		{
+			name:        "retried",
+			description: "A message with the fields requeue sets when it's retried. Producers never set these.",
+			msg: RequeueMessage{
+				Retries:         2,
+				Delay:           uint64(time.Second),
+				BackoffStrategy: BackoffStrategy_Exponential,
+				QueueName:       "orders",
+				OriginalSubject: "orders.created",
+				OriginalPayload: []byte("hello"),
+				Attempts:        3,
+				AckedSubjects:   []string{"orders.v1", "audit.orders"},
+				MessageID:       "1600000000000000000.1.42",
+				EnqueuedAt:      1600000000000000000,
+			},
+		},
+	}
+
+	out := make([]ConformanceVector, 0, len(vectors))
+	for _, v := range vectors {
+		b := v.msg.Bytes()
+		// The message is decoded from the bytes so it's exactly what a
+		// decoder should get, e.g., an empty payload rather than none.
+		var decoded RequeueMessage
+		_ = decoded.UnmarshalBinary(b)
+		out = append(out, ConformanceVector{
+			Name:        v.name,
+			Description: v.description,
+			Message:     decoded,
+			Bytes:       b,
+			Hex:         hex.EncodeToString(b),
+		})
+	}
+	return out
+}
+
+// JSON returns the indented JSON form of the vector.
+func (v ConformanceVector) JSON() ([]byte, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// WriteConformanceVectors writes each of the ConformanceVectors to dir as
+// <name>.bin, with the raw bytes, and <name>.json.
+func WriteConformanceVectors(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("write conformance vectors: %w", err)
+	}
+	for _, v := range ConformanceVectors() {
+		j, err := v.JSON()
+		if err != nil {
+			return fmt.Errorf("write conformance vectors: %s: %w", v.Name, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, v.Name+".json"), j, 0644); err != nil {
+			return fmt.Errorf("write conformance vectors: %w", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, v.Name+".bin"), v.Bytes, 0644); err != nil {
+			return fmt.Errorf("write conformance vectors: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//go:generate go test -run TestConformanceVectors -update
+
+var update = flag.Bool("update", false, "update the conformance vectors in testdata")
+
+const conformanceDir = "testdata/conformance"
+
+func TestConformanceVectors(t *testing.T) {
+	if *update {
+		assert.NoError(t, WriteConformanceVectors(conformanceDir))
+	}
+
+	for _, v := range ConformanceVectors() {
+		t.Run(v.Name, func(t *testing.T) {
+			j, err := v.JSON()
+			assert.NoError(t, err)
+			wantJSON, err := ioutil.ReadFile(filepath.Join(conformanceDir, v.Name+".json"))
+			assert.NoError(t, err)
+			assert.Equal(t, string(wantJSON), string(j), "run go generate to update the vectors")
+
+			wantBytes, err := ioutil.ReadFile(filepath.Join(conformanceDir, v.Name+".bin"))
+			assert.NoError(t, err)
+			assert.Equal(t, wantBytes, v.Bytes)
+			assert.Equal(t, hex.EncodeToString(wantBytes), v.Hex)
+
+			// The bytes decode to the message and encode back to the same
+			// bytes.
+			var got RequeueMessage
+			assert.NoError(t, got.UnmarshalBinary(wantBytes))
+			assert.Equal(t, v.Message, got)
+			assert.Equal(t, wantBytes, got.Bytes())
+		})
+	}
+}
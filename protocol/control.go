@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ControlSubjectPrefix is the prefix of the subjects instances answer control
+// requests on.
+const ControlSubjectPrefix = SystemSubjectPrefix + "control."
+
+// BacklogReportSubject is where an instance answers BacklogRequests.
+func BacklogReportSubject(instanceId string) string {
+	return ControlSubjectPrefix + instanceId + ".backlog"
+}
+
+// BacklogOrder is what the subjects in a BacklogReport are ranked by.
+type BacklogOrder string
+
+const (
+	BacklogOrderCount BacklogOrder = "count"
+	BacklogOrderBytes BacklogOrder = "bytes"
+)
+
+// DefaultBacklogTopN is how many subjects are reported when a BacklogRequest
+// doesn't say.
+const DefaultBacklogTopN = 10
+
+// BacklogRequest asks an instance for the original subjects with the largest
+// backlog in a queue.
+type BacklogRequest struct {
+	Queue   string       `json:"queue"`
+	N       int          `json:"n,omitempty"`
+	OrderBy BacklogOrder `json:"order_by,omitempty"`
+}
+
+func (r BacklogRequest) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *BacklogRequest) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// SubjectBacklog is the backlog of messages in a queue for an original
+// subject.
+type SubjectBacklog struct {
+	Subject string `json:"subject"`
+	Count   int64  `json:"count"`
+	// Bytes is the size of the stored messages.
+	Bytes int64 `json:"bytes"`
+}
+
+// BacklogReport is the reply to a BacklogRequest.
+type BacklogReport struct {
+	InstanceID string `json:"instance_id"`
+	Queue      string `json:"queue"`
+	// The top subjects, ranked as requested.
+	Subjects []SubjectBacklog `json:"subjects"`
+	// The totals across every subject in the queue.
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"`
+	// Error is set if the report couldn't be made.
+	Error string `json:"error,omitempty"`
+	// The version of requeue the instance is running and the features it
+	// supports.
+	Version  string    `json:"version,omitempty"`
+	Features Features  `json:"features,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+func (r BacklogReport) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *BacklogReport) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBacklogReportMarshalUnmarshalBinary(t *testing.T) {
+	r := BacklogReport{
+		InstanceID: "Inst1234",
+		Queue:      "orders",
+		Subjects:   []SubjectBacklog{{Subject: "orders.created", Count: 2, Bytes: 128}},
+		Count:      2,
+		Bytes:      128,
+		Time:       time.Unix(100, 0).UTC(),
+	}
+
+	b, err := r.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := BacklogReport{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, r, out)
+}
@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeadLettersSubjectPrefix is the prefix of the subjects DeadLetters are
+// published on.
+const DeadLettersSubjectPrefix = SystemSubjectPrefix + "dlq."
+
+// DeadLetterSubject is where the DeadLetters for messages with the original
+// subject are published. Subscribe to DeadLettersSubjectPrefix + ">" to get
+// them all.
+func DeadLetterSubject(originalSubject string) string {
+	return DeadLettersSubjectPrefix + originalSubject
+}
+
+// DeadLetter is a message that was given up on, e.g., because it ran out of
+// retries. It holds the whole message so it can be inspected and replayed,
+// either by redriving it into its queue or publishing Message back to the
+// ingest subject.
+type DeadLetter struct {
+	InstanceID string `json:"instance_id,omitempty"`
+	Queue      string `json:"queue"`
+	// Key is the readable form of the message key when it was dead lettered.
+	// The dead letter is stored under it.
+	Key string `json:"key"`
+	// MessageID is the readable form of the key the message was first stored
+	// under.
+	MessageID string `json:"message_id,omitempty"`
+	// Subject is the original subject of the message.
+	Subject string `json:"subject"`
+	// Reason is why the message was dead lettered, e.g.,
+	// TerminalReasonRetriesExhausted.
+	Reason string `json:"reason"`
+	// Error is the last error republishing the message.
+	Error string `json:"error,omitempty"`
+	// Attempts is the number of times the message was republished.
+	Attempts uint64 `json:"attempts"`
+	// Message is the RequeueMessage as it was stored.
+	Message []byte    `json:"message"`
+	Labels  Labels    `json:"labels,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+func (d DeadLetter) MarshalBinary() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+func (d *DeadLetter) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, d)
+}
@@ -0,0 +1,33 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterMarshalUnmarshalBinary(t *testing.T) {
+	m := DefaultRequeueMessage()
+	m.OriginalSubject = "orders.created"
+	d := DeadLetter{
+		InstanceID: "Inst1234",
+		Queue:      "orders",
+		Key:        "100.2.1",
+		MessageID:  "100.1.1",
+		Subject:    "orders.created",
+		Reason:     TerminalReasonRetriesExhausted,
+		Error:      "nats: timeout",
+		Attempts:   3,
+		Message:    m.Bytes(),
+		Time:       time.Unix(100, 0).UTC(),
+	}
+
+	b, err := d.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := DeadLetter{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, d, out)
+	assert.Equal(t, "requeue.dlq.orders.created", DeadLetterSubject(d.Subject))
+}
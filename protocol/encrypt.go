@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+)
+
+// Encrypter encrypts and decrypts the original payload of a RequeueMessage.
+// This is independent of any at-rest encryption of the store. When requeue is
+// configured to republish payloads encrypted, consumers use the same
+// Encrypter to decrypt them so they're protected over untrusted NATS links.
+type Encrypter interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// EncryptPayload returns a copy of the encoded RequeueMessage data with its
+// original payload encrypted.
+func EncryptPayload(e Encrypter, data []byte) ([]byte, error) {
+	m := DefaultRequeueMessage()
+	m.fromFlatbuf(flatbuf.GetRootAsRequeueMessage(data, 0))
+	payload, err := e.Encrypt(m.OriginalPayload)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt payload: %w", err)
+	}
+	m.OriginalPayload = payload
+	return m.Bytes(), nil
+}
+
+// DecryptPayload decrypts the original payload of the message.
+func DecryptPayload(e Encrypter, fb *flatbuf.RequeueMessage) ([]byte, error) {
+	payload, err := e.Decrypt(fb.OriginalPayloadBytes())
+	if err != nil {
+		return nil, fmt.Errorf("decrypt payload: %w", err)
+	}
+	return payload, nil
+}
+
+// KeyIDHeader holds the id of the key the payload of a republished message is
+// encrypted with when payloads are republished encrypted with per-queue keys,
+// so consumers know which key to decrypt it with.
+const KeyIDHeader = "Requeue-Key-Id"
+
+// ErrKeyNotFound is returned by a Keyring when it doesn't have a key, e.g.,
+// because it has been destroyed.
+var ErrKeyNotFound = errors.New("key not found")
+
+// Keyring holds the keys used to encrypt the original payloads of messages
+// when every queue, or tenant, has keys of its own. Each message records the
+// id of the key it was encrypted with so keys can be rotated without
+// re-encrypting what's already stored. Destroying a key in the keyring shreds
+// every message encrypted with it since they can no longer be decrypted.
+type Keyring interface {
+	// KeyID returns the id of the key new messages in the queue are
+	// encrypted with. Queues can be mapped to tenants here to share a key.
+	KeyID(queue string) (string, error)
+	// Encrypter returns the Encrypter for the key with the id. It should
+	// return an error wrapping ErrKeyNotFound if there is no such key.
+	Encrypter(keyID string) (Encrypter, error)
+}
+
+// EncryptPayloadWithKeyring returns a copy of the encoded RequeueMessage data
+// with its original payload encrypted with the current key of the queue in
+// the keyring, and the id of that key recorded in the message.
+func EncryptPayloadWithKeyring(k Keyring, queue string, data []byte) ([]byte, error) {
+	keyID, err := k.KeyID(queue)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt payload: queue %s: %w", queue, err)
+	}
+	e, err := k.Encrypter(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt payload: key %s: %w", keyID, err)
+	}
+	m := DefaultRequeueMessage()
+	m.fromFlatbuf(flatbuf.GetRootAsRequeueMessage(data, 0))
+	payload, err := e.Encrypt(m.OriginalPayload)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt payload: key %s: %w", keyID, err)
+	}
+	m.OriginalPayload = payload
+	m.KeyID = keyID
+	return m.Bytes(), nil
+}
+
+// DecryptPayloadWithKeyring decrypts the original payload of the message with
+// the key in the keyring it was encrypted with.
+func DecryptPayloadWithKeyring(k Keyring, fb *flatbuf.RequeueMessage) ([]byte, error) {
+	keyID := string(fb.KeyId())
+	if keyID == "" {
+		return nil, fmt.Errorf("decrypt payload: message has no key id")
+	}
+	e, err := k.Encrypter(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt payload: key %s: %w", keyID, err)
+	}
+	payload, err := e.Decrypt(fb.OriginalPayloadBytes())
+	if err != nil {
+		return nil, fmt.Errorf("decrypt payload: key %s: %w", keyID, err)
+	}
+	return payload, nil
+}
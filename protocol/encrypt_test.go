@@ -0,0 +1,121 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/stretchr/testify/assert"
+)
+
+// xorEncrypter is a toy Encrypter for testing.
+type xorEncrypter byte
+
+func (x xorEncrypter) Encrypt(p []byte) ([]byte, error) {
+	out := make([]byte, len(p))
+	for i := range p {
+		out[i] = p[i] ^ byte(x)
+	}
+	return out, nil
+}
+
+func (x xorEncrypter) Decrypt(p []byte) ([]byte, error) {
+	return x.Encrypt(p)
+}
+
+type failingEncrypter struct{}
+
+func (failingEncrypter) Encrypt(p []byte) ([]byte, error) { return nil, fmt.Errorf("nope") }
+func (failingEncrypter) Decrypt(p []byte) ([]byte, error) { return nil, fmt.Errorf("nope") }
+
+func TestEncryptPayload(t *testing.T) {
+	m := DefaultRequeueMessage()
+	m.Retries = 3
+	m.OriginalSubject = "foo.bar"
+	m.OriginalPayload = []byte("secret")
+
+	e := xorEncrypter(0x5a)
+	data, err := EncryptPayload(e, m.Bytes())
+	assert.NoError(t, err)
+
+	fb := flatbuf.GetRootAsRequeueMessage(data, 0)
+	assert.NotEqual(t, m.OriginalPayload, fb.OriginalPayloadBytes())
+	assert.Equal(t, m.Retries, fb.Retries())
+	assert.Equal(t, m.OriginalSubject, string(fb.OriginalSubject()))
+
+	payload, err := DecryptPayload(e, fb)
+	assert.NoError(t, err)
+	assert.Equal(t, m.OriginalPayload, payload)
+
+	_, err = EncryptPayload(failingEncrypter{}, m.Bytes())
+	assert.Error(t, err)
+	_, err = DecryptPayload(failingEncrypter{}, fb)
+	assert.Error(t, err)
+}
+
+// mapKeyring is a toy Keyring for testing.
+type mapKeyring struct {
+	queues map[string]string
+	keys   map[string]Encrypter
+}
+
+func (k mapKeyring) KeyID(queue string) (string, error) {
+	id, ok := k.queues[queue]
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+	return id, nil
+}
+
+func (k mapKeyring) Encrypter(keyID string) (Encrypter, error) {
+	e, ok := k.keys[keyID]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return e, nil
+}
+
+func TestEncryptPayloadWithKeyring(t *testing.T) {
+	k := mapKeyring{
+		queues: map[string]string{"a": "a-1", "b": "b-1"},
+		keys: map[string]Encrypter{
+			"a-1": xorEncrypter(0x5a),
+			"b-1": xorEncrypter(0x3c),
+		},
+	}
+	m := DefaultRequeueMessage()
+	m.OriginalPayload = []byte("secret")
+
+	a, err := EncryptPayloadWithKeyring(k, "a", m.Bytes())
+	assert.NoError(t, err)
+	b, err := EncryptPayloadWithKeyring(k, "b", m.Bytes())
+	assert.NoError(t, err)
+
+	fbA := flatbuf.GetRootAsRequeueMessage(a, 0)
+	fbB := flatbuf.GetRootAsRequeueMessage(b, 0)
+	assert.Equal(t, "a-1", string(fbA.KeyId()))
+	assert.Equal(t, "b-1", string(fbB.KeyId()))
+	assert.NotEqual(t, fbA.OriginalPayloadBytes(), fbB.OriginalPayloadBytes())
+
+	payload, err := DecryptPayloadWithKeyring(k, fbA)
+	assert.NoError(t, err)
+	assert.Equal(t, m.OriginalPayload, payload)
+
+	// Rotating a key leaves messages encrypted with the old one readable.
+	k.queues["a"] = "a-2"
+	k.keys["a-2"] = xorEncrypter(0x11)
+	payload, err = DecryptPayloadWithKeyring(k, fbA)
+	assert.NoError(t, err)
+	assert.Equal(t, m.OriginalPayload, payload)
+
+	// Destroying a key shreds the messages encrypted with it.
+	delete(k.keys, "b-1")
+	_, err = DecryptPayloadWithKeyring(k, fbB)
+	assert.True(t, errors.Is(err, ErrKeyNotFound))
+
+	_, err = EncryptPayloadWithKeyring(k, "c", m.Bytes())
+	assert.True(t, errors.Is(err, ErrKeyNotFound))
+	_, err = DecryptPayloadWithKeyring(k, flatbuf.GetRootAsRequeueMessage(m.Bytes(), 0))
+	assert.Error(t, err)
+}
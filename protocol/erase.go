@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+)
+
+// ErasuresSubject is where ErasureRecords are published.
+const ErasuresSubject = EventsSubjectPrefix + "erasure"
+
+// EraseSubject is where an instance answers EraseRequests.
+func EraseSubject(instanceId string) string {
+	return ControlSubjectPrefix + instanceId + ".erase"
+}
+
+// Metadata are key value pairs producers attach to a RequeueMessage.
+type Metadata map[string]string
+
+// toFlatbuf returns the offset of the metadata vector. The entries are sorted
+// by key so the encoding is deterministic.
+func (m Metadata) toFlatbuf(b *flatbuffers.Builder, startVector func(*flatbuffers.Builder, int) flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	offsets := make([]flatbuffers.UOffsetT, len(keys))
+	for i, k := range keys {
+		key := b.CreateByteString([]byte(k))
+		value := b.CreateByteString([]byte(m[k]))
+		flatbuf.MetadataEntryStart(b)
+		flatbuf.MetadataEntryAddKey(b, key)
+		flatbuf.MetadataEntryAddValue(b, value)
+		offsets[i] = flatbuf.MetadataEntryEnd(b)
+	}
+
+	// Add the offsets in reverse so we maintain order.
+	startVector(b, len(offsets))
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(offsets[i])
+	}
+	return b.EndVector(len(offsets))
+}
+
+func metadataFromFlatbuf(fb *flatbuf.RequeueMessage) Metadata {
+	n := fb.MetadataLength()
+	if n == 0 {
+		return nil
+	}
+	m := make(Metadata, n)
+	for i := 0; i < n; i++ {
+		obj := &flatbuf.MetadataEntry{}
+		if ok := fb.Metadata(obj, i); !ok {
+			continue
+		}
+		m[string(obj.Key())] = string(obj.Value())
+	}
+	return m
+}
+
+// MatchMetadata returns true if the message has every key value pair in
+// match. An empty match never matches anything.
+func MatchMetadata(fb *flatbuf.RequeueMessage, match Metadata) bool {
+	if len(match) == 0 {
+		return false
+	}
+	found := 0
+	obj := &flatbuf.MetadataEntry{}
+	for i := 0; i < fb.MetadataLength(); i++ {
+		if ok := fb.Metadata(obj, i); !ok {
+			continue
+		}
+		if v, ok := match[string(obj.Key())]; ok {
+			if v != string(obj.Value()) {
+				return false
+			}
+			found++
+		}
+	}
+	return found == len(match)
+}
+
+// EraseRequest asks an instance to erase every pending message whose
+// metadata matches, e.g., to comply with a request to erase a user's data.
+type EraseRequest struct {
+	// Match is the metadata a message must have every pair of to be erased.
+	// It can't be empty.
+	Match Metadata `json:"match"`
+	// Queues are the queues to erase from, including the sub-queues of time
+	// bucketed queues. Every queue is searched when it's empty.
+	Queues []string `json:"queues,omitempty"`
+	// Reason is recorded in the audit record, e.g., the id of the request.
+	Reason string `json:"reason,omitempty"`
+	// DryRun only counts the matching messages without erasing them or
+	// writing an audit record.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+func (r EraseRequest) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *EraseRequest) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// ErasedQueue is the number of messages erased from a queue.
+type ErasedQueue struct {
+	Queue        string `json:"queue"`
+	Messages     int64  `json:"messages"`
+	Quarantined  int64  `json:"quarantined"`
+	DeadLettered int64  `json:"dead_lettered"`
+}
+
+// ErasureRecord is the audit record of an EraseRequest, which is also the
+// reply to it. It's kept by the instance and published on ErasuresSubject.
+// It never holds any of the erased payloads.
+type ErasureRecord struct {
+	// ID is unique to the record and orders it in time.
+	ID         string   `json:"id,omitempty"`
+	InstanceID string   `json:"instance_id"`
+	Match      Metadata `json:"match"`
+	Reason     string   `json:"reason,omitempty"`
+	DryRun     bool     `json:"dry_run,omitempty"`
+	// Queues are the queues messages were erased from.
+	Queues []ErasedQueue `json:"queues"`
+	// The totals across every queue.
+	Messages     int64 `json:"messages"`
+	Quarantined  int64 `json:"quarantined"`
+	DeadLettered int64 `json:"dead_lettered"`
+	// Error is set if the erasure failed part way. The counts are what was
+	// erased before it did.
+	Error  string    `json:"error,omitempty"`
+	Labels Labels    `json:"labels,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+func (r ErasureRecord) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *ErasureRecord) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
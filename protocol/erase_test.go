@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchMetadata(t *testing.T) {
+	m := DefaultRequeueMessage()
+	m.Metadata = Metadata{"user_id": "123", "tenant": "acme"}
+	fb := flatbuf.GetRootAsRequeueMessage(m.Bytes(), 0)
+
+	out := DefaultRequeueMessage()
+	assert.NoError(t, out.UnmarshalBinary(m.Bytes()))
+	assert.Equal(t, m.Metadata, out.Metadata)
+
+	assert.True(t, MatchMetadata(fb, Metadata{"user_id": "123"}))
+	assert.True(t, MatchMetadata(fb, Metadata{"user_id": "123", "tenant": "acme"}))
+	assert.False(t, MatchMetadata(fb, Metadata{"user_id": "1234"}))
+	assert.False(t, MatchMetadata(fb, Metadata{"user_id": "123", "region": "eu"}))
+	assert.False(t, MatchMetadata(fb, nil))
+
+	none := DefaultRequeueMessage()
+	assert.False(t, MatchMetadata(flatbuf.GetRootAsRequeueMessage(none.Bytes(), 0), Metadata{"user_id": "123"}))
+}
+
+func TestErasureRecordMarshalUnmarshalBinary(t *testing.T) {
+	r := ErasureRecord{
+		ID:           "abc",
+		InstanceID:   "Inst1234",
+		Match:        Metadata{"user_id": "123"},
+		Reason:       "ticket-42",
+		Queues:       []ErasedQueue{{Queue: "orders", Messages: 3, Quarantined: 1, DeadLettered: 2}},
+		Messages:     3,
+		Quarantined:  1,
+		DeadLettered: 2,
+		Time:         time.Unix(100, 0).UTC(),
+	}
+
+	b, err := r.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := ErasureRecord{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, r, out)
+}
@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// HealthEventsSubject is where HealthEvents are published.
+	HealthEventsSubject = EventsSubjectPrefix + "health"
+
+	// ConnEventsSubject is where ConnEvents are published.
+	ConnEventsSubject = EventsSubjectPrefix + "conn"
+
+	// QuotaEventsSubject is where QuotaEvents are published.
+	QuotaEventsSubject = EventsSubjectPrefix + "quota"
+
+	// AckFailuresSubject is where AckFailures are published.
+	AckFailuresSubject = EventsSubjectPrefix + "ack_failure"
+)
+
+// HealthStatus is the health of an instance as seen by its watchdog.
+type HealthStatus string
+
+const (
+	HealthStatusHealthy  HealthStatus = "healthy"
+	HealthStatusDegraded HealthStatus = "degraded"
+)
+
+// HealthEvent is published when the health of an instance changes.
+type HealthEvent struct {
+	InstanceID string       `json:"instance_id"`
+	Status     HealthStatus `json:"status"`
+	// Reasons the instance is degraded.
+	Reasons []string `json:"reasons,omitempty"`
+	// Healed is true if the watchdog was able to repair the problems.
+	Healed bool      `json:"healed"`
+	Labels Labels    `json:"labels,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+func (e HealthEvent) MarshalBinary() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func (e *HealthEvent) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, e)
+}
+
+// ConnState is the state of the NATS connection of an instance.
+type ConnState string
+
+const (
+	ConnStateConnected    ConnState = "connected"
+	ConnStateDisconnected ConnState = "disconnected"
+	ConnStateReconnected  ConnState = "reconnected"
+	ConnStateClosed       ConnState = "closed"
+
+	// ConnStateDrained is reported when closing once the connection has
+	// drained cleanly.
+	ConnStateDrained ConnState = "drained"
+
+	// ConnStateDrainTimedOut is reported when closing if the connection
+	// didn't drain in time and was closed anyway.
+	ConnStateDrainTimedOut ConnState = "drain_timed_out"
+)
+
+// ConnEvent is published when the NATS connection of an instance changes
+// state. Events that happen while disconnected are buffered by the client and
+// published once reconnected. Closed events can only be observed with a
+// callback since the connection is gone.
+type ConnEvent struct {
+	InstanceID string    `json:"instance_id"`
+	State      ConnState `json:"state"`
+	ServerURL  string    `json:"server_url,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Labels     Labels    `json:"labels,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+func (e ConnEvent) MarshalBinary() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func (e *ConnEvent) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, e)
+}
+
+// QuotaScope is what a quota applies to.
+type QuotaScope string
+
+const (
+	QuotaScopeQueue  QuotaScope = "queue"
+	QuotaScopeTenant QuotaScope = "tenant"
+)
+
+// QuotaEvent is published when a queue or tenant exceeds its quota and
+// messages start being rejected. It's published once each time the quota
+// becomes exceeded rather than for every rejected message.
+type QuotaEvent struct {
+	InstanceID string     `json:"instance_id"`
+	Scope      QuotaScope `json:"scope"`
+	// Tenant is empty unless queues are mapped to tenants.
+	Tenant string `json:"tenant,omitempty"`
+	// Queue is the queue of the message that exceeded the quota.
+	Queue string `json:"queue"`
+	// Quota is the max number of messages allowed.
+	Quota int64 `json:"quota"`
+	// Usage is the number of messages stored when the quota was exceeded.
+	Usage  int64     `json:"usage"`
+	Labels Labels    `json:"labels,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+func (e QuotaEvent) MarshalBinary() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func (e *QuotaEvent) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, e)
+}
+
+// AckFailure is recorded, and published, when a message couldn't be
+// acknowledged to its producer, e.g., because the reply subject is gone. The
+// message may still have been persisted under Key, so operators can reconcile
+// it against any retries made by the producer.
+type AckFailure struct {
+	InstanceID string `json:"instance_id"`
+	Queue      string `json:"queue"`
+	// Key is the readable form of the key the message is stored under.
+	Key string `json:"key"`
+	// Subject is the original subject of the message.
+	Subject string `json:"subject"`
+	// Reply is the reply subject the ack couldn't be sent to.
+	Reply string `json:"reply"`
+	// Persisted is true if the message was committed to the store. Messages
+	// acked on receive are acked before they're committed.
+	Persisted bool      `json:"persisted"`
+	Error     string    `json:"error"`
+	Labels    Labels    `json:"labels,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+func (e AckFailure) MarshalBinary() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func (e *AckFailure) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, e)
+}
@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthEventMarshalUnmarshalBinary(t *testing.T) {
+	e := HealthEvent{
+		InstanceID: "Inst1234",
+		Status:     HealthStatusDegraded,
+		Reasons:    []string{"subscription is no longer valid"},
+		Healed:     true,
+		Labels:     Labels{"env": "prod"},
+		Time:       time.Unix(100, 0).UTC(),
+	}
+
+	b, err := e.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := HealthEvent{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, e, out)
+}
+
+func TestConnEventMarshalUnmarshalBinary(t *testing.T) {
+	e := ConnEvent{
+		InstanceID: "Inst1234",
+		State:      ConnStateDisconnected,
+		ServerURL:  "nats://127.0.0.1:4222",
+		Error:      "EOF",
+		Time:       time.Unix(100, 0).UTC(),
+	}
+
+	b, err := e.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := ConnEvent{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, e, out)
+	assert.True(t, IsReservedSubject(ConnEventsSubject))
+}
+
+func TestQuotaEventMarshalUnmarshalBinary(t *testing.T) {
+	e := QuotaEvent{
+		InstanceID: "Inst1234",
+		Scope:      QuotaScopeTenant,
+		Tenant:     "acme",
+		Queue:      "acme.orders",
+		Quota:      1000,
+		Usage:      1000,
+		Time:       time.Unix(100, 0).UTC(),
+	}
+
+	b, err := e.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := QuotaEvent{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, e, out)
+}
+
+func TestAckFailureMarshalUnmarshalBinary(t *testing.T) {
+	e := AckFailure{
+		InstanceID: "Inst1234",
+		Queue:      "orders",
+		Key:        "0Bx0Y4Qx2MV0000000000000000",
+		Subject:    "orders.created",
+		Reply:      "_INBOX.abc",
+		Persisted:  true,
+		Error:      "nats: connection closed",
+		Time:       time.Unix(100, 0).UTC(),
+	}
+
+	b, err := e.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := AckFailure{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, e, out)
+}
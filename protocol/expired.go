@@ -0,0 +1,15 @@
+package protocol
+
+import "time"
+
+// ExpiredMessage describes a message removed from a queue because its TTL
+// elapsed before it could be republished.
+type ExpiredMessage struct {
+	Queue string `json:"queue"`
+	// Key is the readable form of the message key.
+	Key string `json:"key"`
+	// Subject is the original subject of the message.
+	Subject string `json:"subject"`
+	// Age is how long the message was enqueued for.
+	Age time.Duration `json:"age"`
+}
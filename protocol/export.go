@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ExportSubject is where an instance answers ExportRequests. The reply is
+// sent once the export has finished, so requests should be made with a
+// timeout long enough for the whole queue at its rate.
+func ExportSubject(instanceId string) string {
+	return ControlSubjectPrefix + instanceId + ".export"
+}
+
+// ExportRequest asks an instance to publish a copy of every message pending
+// in a queue on a subject, e.g., to seed a staging environment or reprocess a
+// production backlog. The messages are left in the queue.
+type ExportRequest struct {
+	// Queue is the queue to export, including the sub-queues of a time
+	// bucketed queue.
+	Queue string `json:"queue"`
+	// Subject is where the messages are published.
+	Subject string `json:"subject"`
+	// Rate is the most messages published per second. Zero publishes them as
+	// fast as possible.
+	Rate float64 `json:"rate,omitempty"`
+	// Envelope publishes the RequeueMessages rather than their original
+	// payloads, e.g., to ingest them into another instance.
+	Envelope bool `json:"envelope,omitempty"`
+}
+
+func (r ExportRequest) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *ExportRequest) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// ExportReply is the result of an ExportRequest.
+type ExportReply struct {
+	InstanceID string `json:"instance_id"`
+	Queue      string `json:"queue"`
+	Subject    string `json:"subject"`
+	// Exported is the number of messages published.
+	Exported int64 `json:"exported"`
+	// Skipped is the number of messages that couldn't be published, e.g.,
+	// because their payload couldn't be decrypted.
+	Skipped int64 `json:"skipped"`
+	// Error is set if the export failed part way. The counts are what was
+	// exported before it did.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+func (r ExportReply) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *ExportReply) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
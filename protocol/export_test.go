@@ -0,0 +1,32 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportMarshalUnmarshalBinary(t *testing.T) {
+	req := ExportRequest{Queue: "orders", Subject: "staging.orders", Rate: 100, Envelope: true}
+	b, err := req.MarshalBinary()
+	assert.NoError(t, err)
+	outReq := ExportRequest{}
+	assert.NoError(t, outReq.UnmarshalBinary(b))
+	assert.Equal(t, req, outReq)
+
+	r := ExportReply{
+		InstanceID: "Inst1234",
+		Queue:      "orders",
+		Subject:    "staging.orders",
+		Exported:   10,
+		Skipped:    1,
+		Time:       time.Unix(100, 0).UTC(),
+	}
+	b, err = r.MarshalBinary()
+	assert.NoError(t, err)
+	out := ExportReply{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, r, out)
+	assert.True(t, IsReservedSubject(ExportSubject("Inst1234")))
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// InfoSubject is where every instance answers InfoRequests. A request gets the
+// first instance to answer.
+const InfoSubject = ControlSubjectPrefix + "info"
+
+// InstanceInfoSubject is where a single instance answers InfoRequests.
+func InstanceInfoSubject(instanceId string) string {
+	return ControlSubjectPrefix + instanceId + ".info"
+}
+
+// Feature is something an instance supports that a client may want to check
+// for before relying on it, e.g., while a fleet is being upgraded.
+type Feature string
+
+const (
+	// FeatureAckTimeout is support for the ack_timeout of a RequeueMessage.
+	FeatureAckTimeout Feature = "ack_timeout"
+
+	// FeatureDedupeKey is support for the dedupe_key of a RequeueMessage.
+	FeatureDedupeKey Feature = "dedupe_key"
+
+	// FeatureBacklogReport is support for BacklogRequests.
+	FeatureBacklogReport Feature = "backlog_report"
+
+	// FeatureReceipts is given when the instance publishes Receipts.
+	FeatureReceipts Feature = "receipts"
+
+	// FeatureTerminalRecords is given when the instance retains
+	// TerminalRecords.
+	FeatureTerminalRecords Feature = "terminal_records"
+
+	// FeatureErase is support for EraseRequests and the metadata of a
+	// RequeueMessage they match.
+	FeatureErase Feature = "erase"
+
+	// FeatureKeyRotation is support for KeyRotationRequests, given when
+	// payloads are encrypted with per-queue keys.
+	FeatureKeyRotation Feature = "key_rotation"
+
+	// FeatureExport is support for ExportRequests.
+	FeatureExport Feature = "export"
+
+	// FeatureStateReport is support for requests for a StateReport.
+	FeatureStateReport Feature = "state_report"
+
+	// FeatureDeadLetterQueue is given when the instance keeps the messages it
+	// gives up on as DeadLetters.
+	FeatureDeadLetterQueue Feature = "dead_letter_queue"
+
+	// FeatureQueueGroupHeaders is given when the instance sends the queue
+	// group of a RequeueMessage in the QueueGroupHeader when it's republished.
+	FeatureQueueGroupHeaders Feature = "queue_group_headers"
+
+	// FeatureRejections is support for RejectionsRequests.
+	FeatureRejections Feature = "rejections"
+
+	// FeatureMembership is support for MembershipRequests, given when the
+	// instance ingests messages.
+	FeatureMembership Feature = "membership"
+
+	// FeatureOperations is support for OperationsRequests, including
+	// canceling operations, and the operations in the stats.
+	FeatureOperations Feature = "operations"
+
+	// FeatureReplay is support for ReplayRequests.
+	FeatureReplay Feature = "replay"
+
+	// FeatureBatchAcks is given when the instance acknowledges the messages
+	// sent with a CorrelationIDHeader in BatchAcks.
+	FeatureBatchAcks Feature = "batch_acks"
+)
+
+// Features is a set of features.
+type Features []Feature
+
+// Supports returns true if f is one of the features.
+func (fs Features) Supports(f Feature) bool {
+	for _, s := range fs {
+		if s == f {
+			return true
+		}
+	}
+	return false
+}
+
+// InstanceInfo is the reply to an InfoRequest. It describes the version of
+// requeue an instance is running and the features it supports.
+type InstanceInfo struct {
+	InstanceID string   `json:"instance_id"`
+	Version    string   `json:"version"`
+	Features   Features `json:"features"`
+	// Revision is the revision of the messages the instance emits, which is
+	// older than the CurrentRevision in compatibility mode.
+	Revision Revision `json:"revision"`
+	// ProtocolVersion is the newest protocol version of the messages the
+	// instance accepts. Producers shouldn't encode messages with a newer one
+	// until every instance they send to accepts it.
+	ProtocolVersion ProtocolVersion `json:"protocol_version,omitempty"`
+	Labels          Labels          `json:"labels,omitempty"`
+	Time            time.Time       `json:"time"`
+}
+
+func (i InstanceInfo) MarshalBinary() ([]byte, error) {
+	return json.Marshal(i)
+}
+
+func (i *InstanceInfo) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, i)
+}
@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstanceInfoMarshalUnmarshalBinary(t *testing.T) {
+	i := InstanceInfo{
+		InstanceID: "Inst1234",
+		Version:    "1.2.3",
+		Features:   Features{FeatureAckTimeout, FeatureReceipts},
+		Labels:     Labels{"region": "us-east-1"},
+		Time:       time.Unix(100, 0).UTC(),
+	}
+
+	b, err := i.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := InstanceInfo{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, i, out)
+	assert.True(t, out.Features.Supports(FeatureReceipts))
+	assert.False(t, out.Features.Supports(FeatureDedupeKey))
+	assert.True(t, IsReservedSubject(InfoSubject))
+	assert.True(t, IsReservedSubject(InstanceInfoSubject("Inst1234")))
+}
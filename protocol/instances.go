@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// InstancesSubjectPrefix is the prefix of the subjects instances publish
+	// their Heartbeats on.
+	InstancesSubjectPrefix = SystemSubjectPrefix + "instances."
+
+	// InstancesSubject matches the Heartbeats of every instance.
+	InstancesSubject = InstancesSubjectPrefix + ">"
+
+	// TakeoverEventsSubject is where TakeoverEvents are published.
+	TakeoverEventsSubject = EventsSubjectPrefix + "takeover"
+)
+
+// HeartbeatSubject is where an instance publishes its Heartbeats.
+func HeartbeatSubject(instanceId string) string {
+	return InstancesSubjectPrefix + instanceId
+}
+
+// Heartbeat is published by an instance every so often so the others know it's
+// alive.
+type Heartbeat struct {
+	InstanceID string `json:"instance_id"`
+	// DataDir is the data dir the store of the instance is in, as the
+	// instance sees it.
+	DataDir string `json:"data_dir,omitempty"`
+	// Interval is how often the instance publishes a Heartbeat.
+	Interval time.Duration `json:"interval"`
+	// Stopping is set on the last Heartbeat of an instance that is closing,
+	// so it isn't taken for dead.
+	Stopping bool      `json:"stopping,omitempty"`
+	Version  string    `json:"version,omitempty"`
+	Labels   Labels    `json:"labels,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+func (h Heartbeat) MarshalBinary() ([]byte, error) {
+	return json.Marshal(h)
+}
+
+func (h *Heartbeat) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, h)
+}
+
+// Peer is another instance as seen from its Heartbeats.
+type Peer struct {
+	InstanceID string `json:"instance_id"`
+	DataDir    string `json:"data_dir,omitempty"`
+	// Dead is set once the instance has missed too many Heartbeats. It's
+	// taken over if its store can be reached.
+	Dead     bool      `json:"dead"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// TakeoverEvent is published when an instance takes over the store of a dead
+// peer, merging the messages in it into its own.
+type TakeoverEvent struct {
+	InstanceID string `json:"instance_id"`
+	// Peer is the id of the dead instance taken over.
+	Peer string `json:"peer"`
+	// DataDir is where the store of the peer was found.
+	DataDir string    `json:"data_dir"`
+	Labels  Labels    `json:"labels,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+func (e TakeoverEvent) MarshalBinary() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func (e *TakeoverEvent) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, e)
+}
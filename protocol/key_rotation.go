@@ -0,0 +1,131 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+)
+
+// KeyRotationSubject is where an instance answers KeyRotationRequests.
+func KeyRotationSubject(instanceId string) string {
+	return ControlSubjectPrefix + instanceId + ".rotate_keys"
+}
+
+// KeyRotationRequest asks an instance to re-encrypt the payloads it has stored
+// with the current key of their queue in its Keyring. The rotation runs in the
+// background and its progress is reported in the stats of the instance.
+type KeyRotationRequest struct {
+	// Queues are the queues to rotate, including the sub-queues of time
+	// bucketed queues. Every queue is rotated when it's empty.
+	Queues []string `json:"queues,omitempty"`
+	// Status only reports the progress of the current rotation, if any,
+	// rather than starting one.
+	Status bool `json:"status,omitempty"`
+}
+
+func (r KeyRotationRequest) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *KeyRotationRequest) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// KeyRotationState is the state of a key rotation.
+type KeyRotationState string
+
+const (
+	KeyRotationRunning  KeyRotationState = "running"
+	KeyRotationDone     KeyRotationState = "done"
+	KeyRotationFailed   KeyRotationState = "failed"
+	KeyRotationCanceled KeyRotationState = "canceled"
+)
+
+// KeyRotation is the progress of re-encrypting the stored payloads of an
+// instance with the current keys of their queues.
+type KeyRotation struct {
+	State KeyRotationState `json:"state"`
+	// Queue is the queue currently being rotated.
+	Queue string `json:"queue,omitempty"`
+	// The number of queues being rotated and how many of them are done.
+	Queues     int64 `json:"queues"`
+	QueuesDone int64 `json:"queues_done"`
+	// Scanned is the number of messages looked at so far.
+	Scanned int64 `json:"scanned"`
+	// Reencrypted is the number of messages encrypted with a new key.
+	Reencrypted int64 `json:"reencrypted"`
+	// Failed is the number of messages that couldn't be re-encrypted, e.g.,
+	// because their key has been destroyed. They are left as they are.
+	Failed int64 `json:"failed"`
+	// Error is the last error, which is why the rotation failed if it did.
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (r *KeyRotation) toFlatbuf(b *flatbuffers.Builder) flatbuffers.UOffsetT {
+	state := b.CreateByteString([]byte(r.State))
+	var queue, rotationErr flatbuffers.UOffsetT
+	if r.Queue != "" {
+		queue = b.CreateByteString([]byte(r.Queue))
+	}
+	if r.Error != "" {
+		rotationErr = b.CreateByteString([]byte(r.Error))
+	}
+	flatbuf.KeyRotationStatsStart(b)
+	flatbuf.KeyRotationStatsAddState(b, state)
+	if r.Queue != "" {
+		flatbuf.KeyRotationStatsAddQueue(b, queue)
+	}
+	flatbuf.KeyRotationStatsAddQueues(b, r.Queues)
+	flatbuf.KeyRotationStatsAddQueuesDone(b, r.QueuesDone)
+	flatbuf.KeyRotationStatsAddScanned(b, r.Scanned)
+	flatbuf.KeyRotationStatsAddReencrypted(b, r.Reencrypted)
+	flatbuf.KeyRotationStatsAddFailed(b, r.Failed)
+	if r.Error != "" {
+		flatbuf.KeyRotationStatsAddError(b, rotationErr)
+	}
+	flatbuf.KeyRotationStatsAddStartedAt(b, r.StartedAt.UnixNano())
+	flatbuf.KeyRotationStatsAddUpdatedAt(b, r.UpdatedAt.UnixNano())
+	return flatbuf.KeyRotationStatsEnd(b)
+}
+
+func keyRotationFromFlatbuf(m *flatbuf.KeyRotationStats) *KeyRotation {
+	if m == nil {
+		return nil
+	}
+	return &KeyRotation{
+		State:       KeyRotationState(m.State()),
+		Queue:       string(m.Queue()),
+		Queues:      m.Queues(),
+		QueuesDone:  m.QueuesDone(),
+		Scanned:     m.Scanned(),
+		Reencrypted: m.Reencrypted(),
+		Failed:      m.Failed(),
+		Error:       string(m.Error()),
+		StartedAt:   time.Unix(0, m.StartedAt()).UTC(),
+		UpdatedAt:   time.Unix(0, m.UpdatedAt()).UTC(),
+	}
+}
+
+// KeyRotationReply is the reply to a KeyRotationRequest.
+type KeyRotationReply struct {
+	InstanceID string `json:"instance_id"`
+	// Rotation is the progress of the rotation that was started, or of the
+	// current one when only the status was requested. It's nil if there
+	// isn't one.
+	Rotation *KeyRotation `json:"rotation,omitempty"`
+	// Error is set if the rotation couldn't be started.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+func (r KeyRotationReply) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *KeyRotationReply) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyRotationReplyMarshalUnmarshalBinary(t *testing.T) {
+	r := KeyRotationReply{
+		InstanceID: "Inst1234",
+		Rotation: &KeyRotation{
+			State:       KeyRotationDone,
+			Queues:      2,
+			QueuesDone:  2,
+			Scanned:     10,
+			Reencrypted: 10,
+			StartedAt:   time.Unix(100, 0).UTC(),
+			UpdatedAt:   time.Unix(110, 0).UTC(),
+		},
+		Time: time.Unix(120, 0).UTC(),
+	}
+
+	b, err := r.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := KeyRotationReply{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, r, out)
+}
@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// MembershipSubject is where an instance answers MembershipRequests.
+func MembershipSubject(instanceId string) string {
+	return ControlSubjectPrefix + instanceId + ".membership"
+}
+
+// MembershipAction is what a MembershipRequest asks an instance to do.
+type MembershipAction string
+
+const (
+	// MembershipLeave stops the instance from taking new messages from the
+	// ingest queue group, e.g., to take it out of rotation for maintenance.
+	// The messages it already took are still committed and acknowledged, and
+	// it keeps republishing its backlog.
+	MembershipLeave MembershipAction = "leave"
+	// MembershipJoin has the instance take messages from the ingest queue
+	// group again.
+	MembershipJoin MembershipAction = "join"
+	// MembershipStatus only reports whether the instance is a member.
+	MembershipStatus MembershipAction = "status"
+)
+
+// MembershipRequest asks an instance to leave or rejoin the ingest queue group
+// without shutting down.
+type MembershipRequest struct {
+	Action MembershipAction `json:"action"`
+}
+
+func (r MembershipRequest) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *MembershipRequest) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// MembershipReply is the answer to a MembershipRequest.
+type MembershipReply struct {
+	InstanceID string `json:"instance_id"`
+	// Member is whether the instance is taking messages from the ingest queue
+	// group after the request.
+	Member bool      `json:"member"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+func (r MembershipReply) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *MembershipReply) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
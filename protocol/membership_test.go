@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMembershipMarshalUnmarshalBinary(t *testing.T) {
+	req := MembershipRequest{Action: MembershipLeave}
+	b, err := req.MarshalBinary()
+	assert.NoError(t, err)
+	outReq := MembershipRequest{}
+	assert.NoError(t, outReq.UnmarshalBinary(b))
+	assert.Equal(t, req, outReq)
+
+	r := MembershipReply{
+		InstanceID: "Inst1234",
+		Member:     true,
+		Time:       time.Unix(100, 0).UTC(),
+	}
+	b, err = r.MarshalBinary()
+	assert.NoError(t, err)
+	out := MembershipReply{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, r, out)
+	assert.True(t, IsReservedSubject(MembershipSubject("Inst1234")))
+}
@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NakReason is why a message was rejected at ingest.
+type NakReason string
+
+const (
+	// NakReasonPayloadTooLarge is returned when the message exceeds the max
+	// payload size of the instance.
+	NakReasonPayloadTooLarge NakReason = "payload_too_large"
+
+	// NakReasonInvalidPayload is returned when the payload failed validation.
+	NakReasonInvalidPayload NakReason = "invalid_payload"
+
+	// NakReasonQuarantined is returned when the payload failed validation and
+	// the message was quarantined. It will not be republished.
+	NakReasonQuarantined NakReason = "quarantined"
+
+	// NakReasonEncryptionFailed is returned when the payload couldn't be
+	// encrypted before being persisted.
+	NakReasonEncryptionFailed NakReason = "encryption_failed"
+
+	// NakReasonQuotaExceeded is returned when accepting the message would
+	// exceed a queue or tenant quota.
+	NakReasonQuotaExceeded NakReason = "quota_exceeded"
+
+	// NakReasonSubjectDenied is returned when the original subject of the
+	// message isn't allowed or is denied.
+	NakReasonSubjectDenied NakReason = "subject_denied"
+
+	// NakReasonUnsupportedProtocol is returned when the message was encoded
+	// with a protocol version the instance doesn't support.
+	NakReasonUnsupportedProtocol NakReason = "unsupported_protocol"
+
+	// NakReasonReservedSubject is returned when the message was sent on a
+	// subject requeue reserves for itself, e.g., its events or control
+	// requests.
+	NakReasonReservedSubject NakReason = "reserved_subject"
+
+	// NakReasonInternalError is returned when requeue failed to process the
+	// message, e.g., because it panicked. The message wasn't persisted.
+	NakReasonInternalError NakReason = "internal_error"
+)
+
+// Nak is the reply to a message that was rejected at ingest. An ACK is always
+// an empty reply so any reply with a payload is a Nak.
+type Nak struct {
+	Reason  NakReason `json:"reason"`
+	Message string    `json:"message,omitempty"`
+}
+
+func (n Nak) MarshalBinary() ([]byte, error) {
+	return json.Marshal(n)
+}
+
+func (n *Nak) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, n)
+}
+
+// NakFromNATS returns the Nak in the reply. False is returned if the reply is
+// an ACK.
+func NakFromNATS(msg *nats.Msg) (Nak, bool) {
+	n := Nak{}
+	if len(msg.Data) == 0 {
+		return n, false
+	}
+	if err := n.UnmarshalBinary(msg.Data); err != nil {
+		// Still a Nak even if we don't understand it.
+		n.Message = string(msg.Data)
+	}
+	return n, true
+}
+
+// QuarantinedMessage is the record kept for a message that was quarantined at
+// ingest.
+type QuarantinedMessage struct {
+	// The subject the message was received on.
+	Subject string `json:"subject"`
+
+	// Why the message was quarantined, e.g., the validation error.
+	Reason NakReason `json:"reason"`
+	Error  string    `json:"error"`
+
+	Time time.Time `json:"time"`
+
+	// The RequeueMessage as it would have been persisted, i.e., with its
+	// original payload encrypted when requeue is configured to do so.
+	Message []byte `json:"message"`
+}
+
+func (q QuarantinedMessage) MarshalBinary() ([]byte, error) {
+	return json.Marshal(q)
+}
+
+func (q *QuarantinedMessage) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, q)
+}
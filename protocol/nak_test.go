@@ -0,0 +1,36 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNakFromNATS(t *testing.T) {
+	_, ok := NakFromNATS(&nats.Msg{})
+	assert.False(t, ok, "an empty reply is an ACK")
+
+	data, err := Nak{Reason: NakReasonPayloadTooLarge, Message: "too big"}.MarshalBinary()
+	assert.NoError(t, err)
+	n, ok := NakFromNATS(&nats.Msg{Data: data})
+	assert.True(t, ok)
+	assert.Equal(t, NakReasonPayloadTooLarge, n.Reason)
+	assert.Equal(t, "too big", n.Message)
+}
+
+func TestQuarantinedMessage(t *testing.T) {
+	want := QuarantinedMessage{
+		Subject: "requeue.ingest",
+		Reason:  NakReasonInvalidPayload,
+		Error:   "missing field",
+		Time:    time.Unix(1600000000, 0).UTC(),
+		Message: []byte("payload"),
+	}
+	data, err := want.MarshalBinary()
+	assert.NoError(t, err)
+	got := QuarantinedMessage{}
+	assert.NoError(t, got.UnmarshalBinary(data))
+	assert.Equal(t, want, got)
+}
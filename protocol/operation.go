@@ -0,0 +1,214 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+)
+
+// OperationsSubject is where an instance answers OperationsRequests.
+func OperationsSubject(instanceId string) string {
+	return ControlSubjectPrefix + instanceId + ".operations"
+}
+
+// OperationsRequest asks an instance for the progress of its long running
+// operations, or to cancel one.
+type OperationsRequest struct {
+	// ID only asks for the operation with the ID. Every operation that is
+	// running or finished recently is reported when it's empty.
+	ID string `json:"id,omitempty"`
+	// Cancel stops the operation with the ID once it reaches a point where
+	// it's safe to. What it completed before then is kept, and reported once
+	// it has stopped.
+	Cancel bool `json:"cancel,omitempty"`
+}
+
+func (r OperationsRequest) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *OperationsRequest) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// OperationKind is the kind of a long running operation.
+type OperationKind string
+
+const (
+	OperationPurge        OperationKind = "purge"
+	OperationRedrive      OperationKind = "redrive"
+	OperationMigration    OperationKind = "migration"
+	OperationReencryption OperationKind = "reencryption"
+	OperationReplay       OperationKind = "replay"
+)
+
+// OperationState is the state of a long running operation.
+type OperationState string
+
+const (
+	OperationRunning  OperationState = "running"
+	OperationDone     OperationState = "done"
+	OperationFailed   OperationState = "failed"
+	OperationCanceled OperationState = "canceled"
+)
+
+// Operation is the progress of a long running operation, such as a purge.
+type Operation struct {
+	ID    string         `json:"id"`
+	Kind  OperationKind  `json:"kind"`
+	State OperationState `json:"state"`
+	// Queue is the queue operated on, if it's only one.
+	Queue string `json:"queue,omitempty"`
+	// How much of the total is done. The total is 0 when it isn't known.
+	Done  int64 `json:"done"`
+	Total int64 `json:"total"`
+	// Percent is how much of the total is done from 0 to 100. It's 0 when
+	// the total isn't known, and 100 once the operation is done.
+	Percent float64 `json:"percent"`
+	// ETA is the estimated time until the operation is done, from the rate
+	// it has made progress at so far. It's 0 when it can't be estimated.
+	ETA time.Duration `json:"eta,omitempty"`
+	// Error is why the operation failed if it did.
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Progress records that done of total is done at now, estimating the percent
+// complete and the time left.
+func (o *Operation) Progress(done, total int64, now time.Time) {
+	o.Done = done
+	o.Total = total
+	o.UpdatedAt = now
+	o.Percent = operationPercent(o.State, done, total)
+	o.ETA = 0
+	if elapsed := now.Sub(o.StartedAt); done > 0 && total > done && elapsed > 0 {
+		o.ETA = time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+	}
+}
+
+// Finish records that the operation finished at now, having failed if err
+// isn't nil, or been canceled if err is a context.Canceled.
+func (o *Operation) Finish(err error, now time.Time) {
+	o.State = OperationDone
+	if errors.Is(err, context.Canceled) {
+		o.State = OperationCanceled
+		o.Error = err.Error()
+	} else if err != nil {
+		o.State = OperationFailed
+		o.Error = err.Error()
+	}
+	o.UpdatedAt = now
+	o.Percent = operationPercent(o.State, o.Done, o.Total)
+	o.ETA = 0
+}
+
+func operationPercent(state OperationState, done, total int64) float64 {
+	switch {
+	case state == OperationDone:
+		return 100
+	case total <= 0:
+		return 0
+	case done >= total:
+		return 100
+	}
+	return float64(done) / float64(total) * 100
+}
+
+// Operations are the progress of long running operations in the order they
+// were started.
+type Operations []Operation
+
+// toFlatbuf returns the offset of the operations vector.
+func (ops Operations) toFlatbuf(b *flatbuffers.Builder, startVector func(*flatbuffers.Builder, int) flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+	offsets := make([]flatbuffers.UOffsetT, len(ops))
+	for i, op := range ops {
+		offsets[i] = op.toFlatbuf(b)
+	}
+
+	// Add the offsets in reverse so we maintain order.
+	startVector(b, len(offsets))
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(offsets[i])
+	}
+	return b.EndVector(len(offsets))
+}
+
+func (o Operation) toFlatbuf(b *flatbuffers.Builder) flatbuffers.UOffsetT {
+	id := b.CreateByteString([]byte(o.ID))
+	kind := b.CreateByteString([]byte(o.Kind))
+	state := b.CreateByteString([]byte(o.State))
+	var queue, opErr flatbuffers.UOffsetT
+	if o.Queue != "" {
+		queue = b.CreateByteString([]byte(o.Queue))
+	}
+	if o.Error != "" {
+		opErr = b.CreateByteString([]byte(o.Error))
+	}
+	flatbuf.OperationStatsStart(b)
+	flatbuf.OperationStatsAddId(b, id)
+	flatbuf.OperationStatsAddKind(b, kind)
+	if o.Queue != "" {
+		flatbuf.OperationStatsAddQueue(b, queue)
+	}
+	flatbuf.OperationStatsAddState(b, state)
+	flatbuf.OperationStatsAddDone(b, o.Done)
+	flatbuf.OperationStatsAddTotal(b, o.Total)
+	flatbuf.OperationStatsAddEta(b, int64(o.ETA))
+	if o.Error != "" {
+		flatbuf.OperationStatsAddError(b, opErr)
+	}
+	flatbuf.OperationStatsAddStartedAt(b, o.StartedAt.UnixNano())
+	flatbuf.OperationStatsAddUpdatedAt(b, o.UpdatedAt.UnixNano())
+	return flatbuf.OperationStatsEnd(b)
+}
+
+func operationsFromFlatbuf(n int, get func(*flatbuf.OperationStats, int) bool) Operations {
+	if n == 0 {
+		return nil
+	}
+	ops := make(Operations, 0, n)
+	for i := 0; i < n; i++ {
+		obj := &flatbuf.OperationStats{}
+		if ok := get(obj, i); !ok {
+			continue
+		}
+		state := OperationState(obj.State())
+		ops = append(ops, Operation{
+			ID:        string(obj.Id()),
+			Kind:      OperationKind(obj.Kind()),
+			State:     state,
+			Queue:     string(obj.Queue()),
+			Done:      obj.Done(),
+			Total:     obj.Total(),
+			Percent:   operationPercent(state, obj.Done(), obj.Total()),
+			ETA:       time.Duration(obj.Eta()),
+			Error:     string(obj.Error()),
+			StartedAt: time.Unix(0, obj.StartedAt()).UTC(),
+			UpdatedAt: time.Unix(0, obj.UpdatedAt()).UTC(),
+		})
+	}
+	return ops
+}
+
+// OperationsReply is the reply to an OperationsRequest.
+type OperationsReply struct {
+	InstanceID string     `json:"instance_id"`
+	Operations Operations `json:"operations"`
+	// Error is set if the operation asked for isn't known, or couldn't be
+	// canceled.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+func (r OperationsReply) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *OperationsReply) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationProgress(t *testing.T) {
+	start := time.Unix(100, 0).UTC()
+	op := Operation{ID: "op1", Kind: OperationRedrive, State: OperationRunning, StartedAt: start}
+
+	// Nothing can be estimated until the total is known.
+	op.Progress(10, 0, start.Add(time.Second))
+	assert.Equal(t, float64(0), op.Percent)
+	assert.Equal(t, time.Duration(0), op.ETA)
+
+	op.Progress(25, 100, start.Add(10*time.Second))
+	assert.Equal(t, float64(25), op.Percent)
+	assert.Equal(t, 30*time.Second, op.ETA)
+
+	op.Finish(nil, start.Add(40*time.Second))
+	assert.Equal(t, OperationDone, op.State)
+	assert.Equal(t, float64(100), op.Percent)
+	assert.Equal(t, time.Duration(0), op.ETA)
+
+	failed := Operation{State: OperationRunning, StartedAt: start}
+	failed.Progress(5, 10, start.Add(time.Second))
+	failed.Finish(errors.New("disk full"), start.Add(2*time.Second))
+	assert.Equal(t, OperationFailed, failed.State)
+	assert.Equal(t, "disk full", failed.Error)
+	assert.Equal(t, float64(50), failed.Percent)
+
+	canceled := Operation{State: OperationRunning, StartedAt: start}
+	canceled.Finish(fmt.Errorf("purge: %w", context.Canceled), start.Add(time.Second))
+	assert.Equal(t, OperationCanceled, canceled.State)
+	assert.Equal(t, "purge: context canceled", canceled.Error)
+}
+
+func TestOperationsReplyMarshalUnmarshalBinary(t *testing.T) {
+	r := OperationsReply{
+		InstanceID: "Inst1234",
+		Operations: Operations{{
+			ID:        "op1",
+			Kind:      OperationReencryption,
+			State:     OperationDone,
+			Done:      2,
+			Total:     2,
+			Percent:   100,
+			StartedAt: time.Unix(100, 0).UTC(),
+			UpdatedAt: time.Unix(110, 0).UTC(),
+		}},
+		Time: time.Unix(120, 0).UTC(),
+	}
+
+	b, err := r.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := OperationsReply{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, r, out)
+	assert.True(t, IsReservedSubject(OperationsSubject("Inst1234")))
+}
@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+)
+
+// ProtocolVersion is the version of the protocol a RequeueMessage is encoded
+// with. It's bumped whenever the meaning of the message changes in a way an
+// instance that doesn't know about it would get wrong, so the instance can
+// reject the message instead.
+type ProtocolVersion uint32
+
+const (
+	// ProtocolVersionUnversioned is the version of the messages of producers
+	// that predate protocol versions, which don't set one.
+	ProtocolVersionUnversioned ProtocolVersion = 0
+
+	// ProtocolVersion1 is the first versioned protocol. It only adds the
+	// version to the message.
+	ProtocolVersion1 ProtocolVersion = 1
+
+	// CurrentProtocolVersion is the latest protocol version.
+	CurrentProtocolVersion = ProtocolVersion1
+)
+
+// ErrUnsupportedProtocol is returned for a message encoded with a protocol
+// version that can't be upgraded to the CurrentProtocolVersion, e.g., because
+// it's newer.
+var ErrUnsupportedProtocol = errors.New("unsupported protocol version")
+
+// protocolUpgrades upgrades a message from a protocol version to the next.
+var protocolUpgrades = map[ProtocolVersion]func(m *RequeueMessage){
+	// Unversioned messages mean the same as version 1 ones.
+	ProtocolVersionUnversioned: func(m *RequeueMessage) {},
+}
+
+// MessageProtocolVersion returns the protocol version of the encoded message
+// without decoding the rest of it.
+func MessageProtocolVersion(data []byte) ProtocolVersion {
+	return ProtocolVersion(flatbuf.GetRootAsRequeueMessage(data, 0).ProtocolVersion())
+}
+
+// UpgradeMessage upgrades the message in place from its protocol version to
+// the CurrentProtocolVersion, one version at a time. An error wrapping
+// ErrUnsupportedProtocol is returned if it can't be.
+func UpgradeMessage(m *RequeueMessage) error {
+	for m.ProtocolVersion < CurrentProtocolVersion {
+		up, ok := protocolUpgrades[m.ProtocolVersion]
+		if !ok {
+			return fmt.Errorf("%w: %d can't be upgraded to %d",
+				ErrUnsupportedProtocol, m.ProtocolVersion, CurrentProtocolVersion)
+		}
+		up(m)
+		m.ProtocolVersion++
+	}
+	if m.ProtocolVersion > CurrentProtocolVersion {
+		return fmt.Errorf("%w: %d is newer than %d",
+			ErrUnsupportedProtocol, m.ProtocolVersion, CurrentProtocolVersion)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpgradeMessage(t *testing.T) {
+	m := DefaultRequeueMessage()
+	m.OriginalSubject = "orders.created"
+	assert.NoError(t, UpgradeMessage(&m))
+	assert.Equal(t, CurrentProtocolVersion, m.ProtocolVersion)
+
+	// Bytes stamps the current version on messages without one.
+	data := m.Bytes()
+	assert.Equal(t, CurrentProtocolVersion, MessageProtocolVersion(data))
+	var got RequeueMessage
+	assert.NoError(t, got.UnmarshalBinary(data))
+	assert.Equal(t, CurrentProtocolVersion, got.ProtocolVersion)
+
+	m.ProtocolVersion = CurrentProtocolVersion + 1
+	err := UpgradeMessage(&m)
+	assert.True(t, errors.Is(err, ErrUnsupportedProtocol))
+	assert.Equal(t, CurrentProtocolVersion+1, MessageProtocolVersion(m.Bytes()))
+}
@@ -0,0 +1,20 @@
+package protocol
+
+import "github.com/nats-io/nats.go"
+
+// QueueGroupHeader holds the downstream queue group a republished message is
+// intended for when queue group headers are enabled. Every queue group
+// subscribed to a subject gets a copy of each message published on it,
+// including the ones that are retried, so the members of the other groups
+// should ignore it to keep load balancing the same as for the original.
+const QueueGroupHeader = "Requeue-Queue-Group"
+
+// IntendedFor returns true if the message is intended for the members of the
+// queue group, i.e., it wasn't republished for a different group.
+func IntendedFor(msg *nats.Msg, group string) bool {
+	if msg.Header == nil {
+		return true
+	}
+	intended := msg.Header.Get(QueueGroupHeader)
+	return intended == "" || intended == group
+}
@@ -0,0 +1,37 @@
+package protocol
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntendedFor(t *testing.T) {
+	// Messages without a group are for everyone.
+	assert.True(t, IntendedFor(&nats.Msg{}, "workers"))
+	assert.True(t, IntendedFor(&nats.Msg{Header: http.Header{}}, "workers"))
+
+	msg := &nats.Msg{Header: http.Header{}}
+	msg.Header.Set(QueueGroupHeader, "workers")
+	assert.True(t, IntendedFor(msg, "workers"))
+	assert.False(t, IntendedFor(msg, "auditors"))
+	assert.False(t, IntendedFor(msg, ""))
+}
+
+func TestQueueGroupRoundTrip(t *testing.T) {
+	m := DefaultRequeueMessage()
+	m.OriginalSubject = "orders.created"
+	m.QueueGroup = "workers"
+
+	var out RequeueMessage
+	assert.NoError(t, out.UnmarshalBinary(m.Bytes()))
+	assert.Equal(t, "workers", out.QueueGroup)
+
+	// It's left out of the buffer when it isn't set.
+	m.QueueGroup = ""
+	out = RequeueMessage{}
+	assert.NoError(t, out.UnmarshalBinary(m.Bytes()))
+	assert.Empty(t, out.QueueGroup)
+}
@@ -0,0 +1,32 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Readiness is whether an instance is keeping up with the messages it's
+// taking, so load balancers and queue group members can shed traffic from an
+// instance that is struggling.
+type Readiness struct {
+	InstanceID string `json:"instance_id"`
+	Ready      bool   `json:"ready"`
+	// Reasons the instance isn't ready.
+	Reasons []string `json:"reasons,omitempty"`
+	// The average time from receiving a message to it being committed since
+	// the last check.
+	CommitLatency time.Duration `json:"commit_latency"`
+	// The number of messages taken from NATS waiting for a consumer.
+	Pending int `json:"pending"`
+	// The fraction of the disk the data is stored on that is free.
+	FreeDisk float64   `json:"free_disk"`
+	Time     time.Time `json:"time"`
+}
+
+func (r Readiness) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *Readiness) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
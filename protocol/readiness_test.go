@@ -0,0 +1,25 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessMarshalUnmarshalBinary(t *testing.T) {
+	r := Readiness{
+		InstanceID:    "Inst1234",
+		Ready:         false,
+		Reasons:       []string{"commit latency of 2s exceeds 1s"},
+		CommitLatency: 2 * time.Second,
+		Pending:       12,
+		FreeDisk:      0.5,
+		Time:          time.Unix(100, 0).UTC(),
+	}
+	b, err := r.MarshalBinary()
+	assert.NoError(t, err)
+	out := Readiness{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, r, out)
+}
@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ReceiptsSubjectPrefix is the prefix of the subjects Receipts are published
+// on.
+const ReceiptsSubjectPrefix = SystemSubjectPrefix + "receipts."
+
+// ReceiptSubject is where the Receipts for messages republished to the
+// original subject are published. Subscribe to ReceiptsSubjectPrefix + ">" to
+// get them all.
+func ReceiptSubject(originalSubject string) string {
+	return ReceiptsSubjectPrefix + originalSubject
+}
+
+// Receipt is published once a message has been republished and acknowledged
+// so producers can confirm delivery without polling.
+type Receipt struct {
+	InstanceID string `json:"instance_id"`
+	Queue      string `json:"queue"`
+	// MessageID is the readable form of the key the message was first stored
+	// under.
+	MessageID string `json:"message_id"`
+	// Subject is the original subject of the message.
+	Subject string `json:"subject"`
+	// Attempts is the number of times the message was republished.
+	Attempts uint64 `json:"attempts"`
+	// Latency is the time from when the message was first enqueued until it
+	// was delivered.
+	Latency time.Duration `json:"latency"`
+	Labels  Labels        `json:"labels,omitempty"`
+	Time    time.Time     `json:"time"`
+}
+
+func (r Receipt) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *Receipt) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
@@ -0,0 +1,28 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiptMarshalUnmarshalBinary(t *testing.T) {
+	r := Receipt{
+		InstanceID: "Inst1234",
+		Queue:      "orders",
+		MessageID:  "100.1.1",
+		Subject:    "orders.created",
+		Attempts:   2,
+		Latency:    time.Second,
+		Time:       time.Unix(100, 0).UTC(),
+	}
+
+	b, err := r.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := Receipt{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, r, out)
+	assert.Equal(t, "requeue.receipts.orders.created", ReceiptSubject(r.Subject))
+}
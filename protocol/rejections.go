@@ -0,0 +1,66 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// RejectionsSubject is where an instance answers RejectionsRequests.
+func RejectionsSubject(instanceId string) string {
+	return ControlSubjectPrefix + instanceId + ".rejections"
+}
+
+// DefaultRejectionsWindow is how far back a RejectionsRequest looks when it
+// doesn't say.
+const DefaultRejectionsWindow = 24 * time.Hour
+
+// RejectionsRequest asks an instance why it rejected the messages of original
+// subjects at ingest, so producers can find out for themselves.
+type RejectionsRequest struct {
+	// The original subjects to report on, which may have wildcards. Blank is
+	// every subject.
+	Subject string `json:"subject,omitempty"`
+	// How far back to look. The zero time is DefaultRejectionsWindow ago.
+	Since time.Time `json:"since,omitempty"`
+}
+
+func (r RejectionsRequest) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *RejectionsRequest) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// SubjectRejections are the messages of an original subject rejected for a
+// reason within an hour.
+type SubjectRejections struct {
+	Subject string    `json:"subject"`
+	Reason  NakReason `json:"reason"`
+	Hour    time.Time `json:"hour"`
+	Count   int64     `json:"count"`
+	// The message of the last Nak, e.g., why the payload failed validation.
+	LastMessage string `json:"last_message,omitempty"`
+}
+
+// RejectionsReport is the reply to a RejectionsRequest.
+type RejectionsReport struct {
+	InstanceID string    `json:"instance_id"`
+	Subject    string    `json:"subject,omitempty"`
+	Since      time.Time `json:"since"`
+	// The rejections in the order of the hour they were in.
+	Rejections []SubjectRejections `json:"rejections"`
+	// The totals across every hour by reason.
+	Rejected ReasonCounts `json:"rejected,omitempty"`
+	// Error is set if the report couldn't be made.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+func (r RejectionsReport) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *RejectionsReport) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
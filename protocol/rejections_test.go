@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRejectionsMarshalUnmarshalBinary(t *testing.T) {
+	req := RejectionsRequest{Subject: "orders.>", Since: time.Unix(100, 0).UTC()}
+	b, err := req.MarshalBinary()
+	assert.NoError(t, err)
+	outReq := RejectionsRequest{}
+	assert.NoError(t, outReq.UnmarshalBinary(b))
+	assert.Equal(t, req, outReq)
+
+	r := RejectionsReport{
+		InstanceID: "Inst1234",
+		Subject:    "orders.>",
+		Since:      time.Unix(100, 0).UTC(),
+		Rejections: []SubjectRejections{
+			{
+				Subject:     "orders.created",
+				Reason:      NakReasonInvalidPayload,
+				Hour:        time.Unix(3600, 0).UTC(),
+				Count:       3,
+				LastMessage: "missing id",
+			},
+		},
+		Rejected: ReasonCounts{string(NakReasonInvalidPayload): 3},
+		Time:     time.Unix(7200, 0).UTC(),
+	}
+	b, err = r.MarshalBinary()
+	assert.NoError(t, err)
+	out := RejectionsReport{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, r, out)
+	assert.True(t, IsReservedSubject(RejectionsSubject("Inst1234")))
+}
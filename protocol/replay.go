@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ReplaySubject is where an instance answers ReplayRequests.
+func ReplaySubject(instanceId string) string {
+	return ControlSubjectPrefix + instanceId + ".replay"
+}
+
+// ReplayRequest asks an instance to republish the messages of a queue right
+// away, regardless of their delay or backoff, e.g., once consumers that were
+// down are back.
+type ReplayRequest struct {
+	// Queue is the queue to replay, including the sub-queues of a time
+	// bucketed queue.
+	Queue string `json:"queue"`
+	// From and To are the keys of the first and last messages to replay. The
+	// range is open at either end when they're empty.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Since and Until limit the replay to the sub-queues of a time bucketed
+	// queue covering the window. Until is now when it's zero.
+	Since time.Time `json:"since,omitempty"`
+	Until time.Time `json:"until,omitempty"`
+}
+
+func (r ReplayRequest) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *ReplayRequest) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// ReplayReply is the reply to a ReplayRequest, sent once the messages have
+// been made ready to republish.
+type ReplayReply struct {
+	InstanceID string `json:"instance_id"`
+	Queue      string `json:"queue"`
+	// Operation is the progress of the replay, which includes the number of
+	// messages replayed. It's nil if the replay couldn't be started.
+	Operation *Operation `json:"operation,omitempty"`
+	// Error is set if the replay failed.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+func (r ReplayReply) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *ReplayReply) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
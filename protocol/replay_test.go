@@ -0,0 +1,35 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayReplyMarshalUnmarshalBinary(t *testing.T) {
+	r := ReplayReply{
+		InstanceID: "Inst1234",
+		Queue:      "orders",
+		Operation: &Operation{
+			ID:        "op1",
+			Kind:      OperationReplay,
+			State:     OperationDone,
+			Queue:     "orders",
+			Done:      3,
+			Total:     3,
+			Percent:   100,
+			StartedAt: time.Unix(100, 0).UTC(),
+			UpdatedAt: time.Unix(110, 0).UTC(),
+		},
+		Time: time.Unix(120, 0).UTC(),
+	}
+
+	b, err := r.MarshalBinary()
+	assert.NoError(t, err)
+
+	out := ReplayReply{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, r, out)
+	assert.True(t, IsReservedSubject(ReplaySubject("Inst1234")))
+}
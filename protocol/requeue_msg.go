@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding"
 	"io"
+	"time"
 
 	flatbuffers "github.com/google/flatbuffers/go"
 	"github.com/nats-io/nats.go"
@@ -19,6 +20,8 @@ const (
 	BackoffStrategy_Undefined BackoffStrategy = iota
 	BackoffStrategy_Exponential
 	BackoffStrategy_Fixed
+	BackoffStrategy_ExponentialWithJitter
+	BackoffStrategy_Linear
 )
 
 // Things we need to save in order to replay this message:
@@ -27,21 +30,26 @@ const (
 //  + The TTL for when the message should expire.
 //  + The delay before it should be retried again.
 //  + Backoff strategy. i.e. fixed interval or exponential
+//
+// The JSON form uses the field names from the schema. It's only used to
+// describe messages, e.g., in the conformance vectors, since they are always
+// sent as flatbuffers.
 type RequeueMessage struct {
 	// The number of times requeue should be attempted.
-	Retries uint64
+	Retries uint64 `json:"retries"`
 
 	// The TTL for when the msssage should expire. This is useful for ensuring
 	// messages are not retried after a certain time.
-	TTL uint64
+	TTL uint64 `json:"ttl"`
 
 	// The delay before the message should be replayed in nanoseconds.
-	Delay uint64
+	Delay uint64 `json:"delay"`
 
 	// Backoff strategy that will be used for determining the next delay should
 	// the message fail to be acknowledged on replay. i.e. fixed interval or
-	// exponential
-	BackoffStrategy BackoffStrategy
+	// exponential. The delay is the base of the backoff. When it's undefined
+	// the default backoff of the instance is used.
+	BackoffStrategy BackoffStrategy `json:"backoff_strategy"`
 
 	// The persistence queue events will be stored in.
 	// This can be useful if you need multiple queues by priority.
@@ -49,13 +57,75 @@ type RequeueMessage struct {
 	// should have over other. This way you can ensure a given high volume
 	// queue does not starve out a low volume queue.
 	// The default queue is "default" when one is not provided.
-	QueueName string
+	QueueName string `json:"queue_name"`
 
 	// The original subject of the message.
-	OriginalSubject string
+	OriginalSubject string `json:"original_subject"`
 
 	// Original message payload.
-	OriginalPayload []byte
+	OriginalPayload []byte `json:"original_payload"`
+
+	// How long to wait for the republished message to be acknowledged in
+	// nanoseconds. Overrides the timeout set for the queue when non-zero.
+	AckTimeout uint64 `json:"ack_timeout"`
+
+	// The number of times the message has been republished without being
+	// acknowledged. This is set by requeue and not by producers.
+	Attempts uint64 `json:"attempts"`
+
+	// Messages with the same dedupe key replace each other while pending in a
+	// coalescing queue, so only the latest is republished.
+	DedupeKey string `json:"dedupe_key"`
+
+	// The subjects the message has already been acknowledged on when it's
+	// fanned out to more than one. They are skipped when it's retried. This
+	// is set by requeue and not by producers.
+	AckedSubjects []string `json:"acked_subjects,omitempty"`
+
+	// The key the message was first stored under, in its readable form. Set
+	// by requeue when the message is first retried since its key changes.
+	MessageID string `json:"message_id"`
+
+	// When the message was first enqueued in Unix nanoseconds. Set by requeue
+	// when the message is first retried.
+	EnqueuedAt int64 `json:"enqueued_at"`
+
+	// When the message becomes ready to be republished in Unix nanoseconds,
+	// which is more precise than its key. Set by requeue when delivery
+	// precision is enabled.
+	ReadyAt int64 `json:"ready_at"`
+
+	// The W3C traceparent the message was ingested with, if any. Set by
+	// requeue when trace headers are enabled so every attempt to republish
+	// the message continues the same trace.
+	TraceParent string `json:"trace_parent"`
+
+	// The id of the key the original payload is encrypted with when payloads
+	// are encrypted with per-queue keys. Set by requeue and not by producers.
+	KeyID string `json:"key_id"`
+
+	// Metadata about the message set by producers, e.g., the id of the user
+	// it belongs to, so it can be found and erased. It's stored in the clear
+	// even when payloads are encrypted.
+	Metadata Metadata `json:"metadata,omitempty"`
+
+	// The downstream queue group the message is intended for, set by
+	// producers. When queue group headers are enabled it's sent with every
+	// attempt to republish the message so the members of other groups can
+	// ignore it.
+	QueueGroup string `json:"queue_group"`
+
+	// The subject to acknowledge the message to its producer on once it has
+	// been delivered, when acks are deferred until delivery. It's persisted
+	// with the message so the producer is still acknowledged if the instance
+	// restarts in the meantime. Set by requeue and not by producers.
+	ReplyTo string `json:"reply_to,omitempty"`
+
+	// The version of the protocol the message was encoded with. It's
+	// ProtocolVersionUnversioned for the messages of producers that predate
+	// protocol versions. Bytes encodes the CurrentProtocolVersion when it's
+	// unset.
+	ProtocolVersion ProtocolVersion `json:"protocol_version"`
 }
 
 func DefaultRequeueMessage() RequeueMessage {
@@ -97,6 +167,47 @@ func (r *RequeueMessage) toFlatbuf(b *flatbuffers.Builder) flatbuffers.UOffsetT
 	queueName := b.CreateByteString([]byte(r.QueueName))
 	originalSubject := b.CreateByteString([]byte(r.OriginalSubject))
 	originalPayload := b.CreateByteVector(r.OriginalPayload)
+	var dedupeKey flatbuffers.UOffsetT
+	if r.DedupeKey != "" {
+		dedupeKey = b.CreateByteString([]byte(r.DedupeKey))
+	}
+	var messageID flatbuffers.UOffsetT
+	if r.MessageID != "" {
+		messageID = b.CreateByteString([]byte(r.MessageID))
+	}
+	var traceParent flatbuffers.UOffsetT
+	if r.TraceParent != "" {
+		traceParent = b.CreateByteString([]byte(r.TraceParent))
+	}
+	var keyID flatbuffers.UOffsetT
+	if r.KeyID != "" {
+		keyID = b.CreateByteString([]byte(r.KeyID))
+	}
+	var queueGroup flatbuffers.UOffsetT
+	if r.QueueGroup != "" {
+		queueGroup = b.CreateByteString([]byte(r.QueueGroup))
+	}
+	var replyTo flatbuffers.UOffsetT
+	if r.ReplyTo != "" {
+		replyTo = b.CreateByteString([]byte(r.ReplyTo))
+	}
+	var metadata flatbuffers.UOffsetT
+	if len(r.Metadata) > 0 {
+		metadata = r.Metadata.toFlatbuf(b, flatbuf.RequeueMessageStartMetadataVector)
+	}
+	var ackedSubjects flatbuffers.UOffsetT
+	if len(r.AckedSubjects) > 0 {
+		offsets := make([]flatbuffers.UOffsetT, len(r.AckedSubjects))
+		for i, subj := range r.AckedSubjects {
+			offsets[i] = b.CreateByteString([]byte(subj))
+		}
+		// Add the offsets in reverse so we maintain order.
+		flatbuf.RequeueMessageStartAckedSubjectsVector(b, len(offsets))
+		for i := len(offsets) - 1; i >= 0; i-- {
+			b.PrependUOffsetT(offsets[i])
+		}
+		ackedSubjects = b.EndVector(len(offsets))
+	}
 
 	flatbuf.RequeueMessageStart(b)
 	flatbuf.RequeueMessageAddRetries(b, r.Retries)
@@ -106,6 +217,39 @@ func (r *RequeueMessage) toFlatbuf(b *flatbuffers.Builder) flatbuffers.UOffsetT
 	flatbuf.RequeueMessageAddQueueName(b, queueName)
 	flatbuf.RequeueMessageAddOriginalSubject(b, originalSubject)
 	flatbuf.RequeueMessageAddOriginalPayload(b, originalPayload)
+	flatbuf.RequeueMessageAddAckTimeout(b, r.AckTimeout)
+	flatbuf.RequeueMessageAddAttempts(b, r.Attempts)
+	if r.DedupeKey != "" {
+		flatbuf.RequeueMessageAddDedupeKey(b, dedupeKey)
+	}
+	if len(r.AckedSubjects) > 0 {
+		flatbuf.RequeueMessageAddAckedSubjects(b, ackedSubjects)
+	}
+	if r.MessageID != "" {
+		flatbuf.RequeueMessageAddMessageId(b, messageID)
+	}
+	flatbuf.RequeueMessageAddEnqueuedAt(b, r.EnqueuedAt)
+	flatbuf.RequeueMessageAddReadyAt(b, r.ReadyAt)
+	if r.TraceParent != "" {
+		flatbuf.RequeueMessageAddTraceParent(b, traceParent)
+	}
+	if r.KeyID != "" {
+		flatbuf.RequeueMessageAddKeyId(b, keyID)
+	}
+	if len(r.Metadata) > 0 {
+		flatbuf.RequeueMessageAddMetadata(b, metadata)
+	}
+	if r.QueueGroup != "" {
+		flatbuf.RequeueMessageAddQueueGroup(b, queueGroup)
+	}
+	if r.ReplyTo != "" {
+		flatbuf.RequeueMessageAddReplyTo(b, replyTo)
+	}
+	version := r.ProtocolVersion
+	if version == ProtocolVersionUnversioned {
+		version = CurrentProtocolVersion
+	}
+	flatbuf.RequeueMessageAddProtocolVersion(b, uint32(version))
 	return flatbuf.RequeueMessageEnd(b)
 }
 
@@ -117,6 +261,53 @@ func (r *RequeueMessage) fromFlatbuf(m *flatbuf.RequeueMessage) {
 	r.QueueName = string(m.QueueName())
 	r.OriginalSubject = string(m.OriginalSubject())
 	r.OriginalPayload = m.OriginalPayloadBytes()
+	r.AckTimeout = m.AckTimeout()
+	r.Attempts = m.Attempts()
+	r.DedupeKey = string(m.DedupeKey())
+	r.AckedSubjects = nil
+	for i := 0; i < m.AckedSubjectsLength(); i++ {
+		r.AckedSubjects = append(r.AckedSubjects, string(m.AckedSubjects(i)))
+	}
+	r.MessageID = string(m.MessageId())
+	r.EnqueuedAt = m.EnqueuedAt()
+	r.ReadyAt = m.ReadyAt()
+	r.TraceParent = string(m.TraceParent())
+	r.KeyID = string(m.KeyId())
+	r.Metadata = metadataFromFlatbuf(m)
+	r.QueueGroup = string(m.QueueGroup())
+	r.ReplyTo = string(m.ReplyTo())
+	r.ProtocolVersion = ProtocolVersion(m.ProtocolVersion())
+}
+
+// SetReadyAt returns the message data with the time it becomes ready set to
+// t. The message is rebuilt if the field is missing from the buffer.
+func SetReadyAt(data []byte, t time.Time) []byte {
+	if flatbuf.GetRootAsRequeueMessage(data, 0).MutateReadyAt(t.UnixNano()) {
+		return data
+	}
+	var m RequeueMessage
+	_ = m.UnmarshalBinary(data)
+	m.ReadyAt = t.UnixNano()
+	return m.Bytes()
+}
+
+// SetTraceParent returns the message data with its traceparent set to tp. The
+// message is always rebuilt since strings can't be mutated in place.
+func SetTraceParent(data []byte, tp string) []byte {
+	var m RequeueMessage
+	_ = m.UnmarshalBinary(data)
+	m.TraceParent = tp
+	return m.Bytes()
+}
+
+// SetReplyTo returns the message data with the subject to acknowledge it on
+// once it's delivered set to reply. The message is always rebuilt since
+// strings can't be mutated in place.
+func SetReplyTo(data []byte, reply string) []byte {
+	var m RequeueMessage
+	_ = m.UnmarshalBinary(data)
+	m.ReplyTo = reply
+	return m.Bytes()
 }
 
 func (r *RequeueMessage) backoffStrategyToFlatbuf() flatbuf.BackoffStrategy {
@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetReadyAt(t *testing.T) {
+	readyAt := time.Unix(1600000000, 250000000)
+
+	// Producers don't set it so the message is rebuilt.
+	m := DefaultRequeueMessage()
+	m.OriginalSubject = "foo"
+	data := SetReadyAt(m.Bytes(), readyAt)
+	fb := flatbuf.GetRootAsRequeueMessage(data, 0)
+	assert.Equal(t, readyAt.UnixNano(), fb.ReadyAt())
+	assert.Equal(t, "foo", string(fb.OriginalSubject()))
+
+	// Once it's there it's updated in place.
+	later := readyAt.Add(time.Second)
+	assert.Equal(t, data, SetReadyAt(data, later))
+	assert.Equal(t, later.UnixNano(), fb.ReadyAt())
+}
+
+func TestSetReplyTo(t *testing.T) {
+	m := DefaultRequeueMessage()
+	m.OriginalSubject = "foo"
+
+	data := SetReplyTo(m.Bytes(), "_INBOX.abc")
+	fb := flatbuf.GetRootAsRequeueMessage(data, 0)
+	assert.Equal(t, "_INBOX.abc", string(fb.ReplyTo()))
+	assert.Equal(t, "foo", string(fb.OriginalSubject()))
+
+	var out RequeueMessage
+	assert.NoError(t, out.UnmarshalBinary(data))
+	assert.Equal(t, "_INBOX.abc", out.ReplyTo)
+}
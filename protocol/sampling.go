@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+)
+
+// Sampling is the state of the sampling of the per-message instrumentation of
+// an instance, i.e., its debug logs and per-message handlers, which is
+// switched on while the ingest rate is above a threshold.
+type Sampling struct {
+	// Active is true while only a sample of the messages are instrumented.
+	Active bool `json:"active"`
+	// The messages ingested per second over the last second, and the rate
+	// above which sampling is switched on.
+	IngestRate float64 `json:"ingest_rate"`
+	Threshold  float64 `json:"threshold"`
+	// One in every this many messages is instrumented while sampling.
+	Every int64 `json:"every"`
+	// Skipped is the number of times instrumentation was skipped since the
+	// instance started.
+	Skipped int64 `json:"skipped"`
+}
+
+func (s *Sampling) toFlatbuf(b *flatbuffers.Builder) flatbuffers.UOffsetT {
+	flatbuf.SamplingStatsStart(b)
+	flatbuf.SamplingStatsAddActive(b, s.Active)
+	flatbuf.SamplingStatsAddIngestRate(b, s.IngestRate)
+	flatbuf.SamplingStatsAddThreshold(b, s.Threshold)
+	flatbuf.SamplingStatsAddEvery(b, s.Every)
+	flatbuf.SamplingStatsAddSkipped(b, s.Skipped)
+	return flatbuf.SamplingStatsEnd(b)
+}
+
+func samplingFromFlatbuf(m *flatbuf.SamplingStats) *Sampling {
+	if m == nil {
+		return nil
+	}
+	return &Sampling{
+		Active:     m.Active(),
+		IngestRate: m.IngestRate(),
+		Threshold:  m.Threshold(),
+		Every:      m.Every(),
+		Skipped:    m.Skipped(),
+	}
+}
@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// StateReportSubject is where an instance answers requests for a StateReport.
+// The request has no body.
+func StateReportSubject(instanceId string) string {
+	return ControlSubjectPrefix + instanceId + ".state"
+}
+
+// QueueState is how much of the state bucket a queue takes up.
+type QueueState struct {
+	Queue string `json:"queue"`
+	// The number of state properties of the queue, e.g., its checkpoint.
+	Properties int64 `json:"properties"`
+	// The number of versions of the properties still in the store, including
+	// the ones that have been overwritten but not yet compacted away.
+	Versions int64 `json:"versions"`
+	// The estimated size of every version.
+	Bytes int64 `json:"bytes"`
+}
+
+// StateReport is the size of the state bucket of an instance, so it can be
+// checked that it isn't growing without bound.
+type StateReport struct {
+	InstanceID string       `json:"instance_id"`
+	Queues     []QueueState `json:"queues"`
+	// The totals across every queue.
+	Properties int64 `json:"properties"`
+	Versions   int64 `json:"versions"`
+	Bytes      int64 `json:"bytes"`
+	// Error is set if the report couldn't be made.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+func (r StateReport) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *StateReport) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
@@ -0,0 +1,27 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateReportMarshalUnmarshalBinary(t *testing.T) {
+	r := StateReport{
+		InstanceID: "Inst1234",
+		Queues: []QueueState{
+			{Queue: "orders", Properties: 1, Versions: 3, Bytes: 120},
+		},
+		Properties: 1,
+		Versions:   3,
+		Bytes:      120,
+		Time:       time.Unix(100, 0).UTC(),
+	}
+	b, err := r.MarshalBinary()
+	assert.NoError(t, err)
+	out := StateReport{}
+	assert.NoError(t, out.UnmarshalBinary(b))
+	assert.Equal(t, r, out)
+	assert.True(t, IsReservedSubject(StateReportSubject("Inst1234")))
+}
@@ -2,28 +2,359 @@ package protocol
 
 import (
 	"encoding"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
 
 	flatbuffers "github.com/google/flatbuffers/go"
 	"github.com/nats-io/nats.go"
 	"github.com/nickpoorman/nats-requeue/flatbuf"
 )
 
+// Encoding is how a stats message is serialized on the wire.
+type Encoding int
+
+const (
+	// EncodingFlatbuf is the default, compact, encoding.
+	EncodingFlatbuf Encoding = iota
+
+	// EncodingJSON is useful for scripts and dashboards that can't easily
+	// parse flatbuffers.
+	EncodingJSON
+)
+
+// JSONSubjectSuffix is appended to a subject to publish the JSON encoding of
+// a message alongside the flatbuf one.
+const JSONSubjectSuffix = ".json"
+
+// Subject returns the subject messages with this encoding are published on for
+// the base subject.
+func (e Encoding) Subject(base string) string {
+	if e == EncodingJSON {
+		return base + JSONSubjectSuffix
+	}
+	return base
+}
+
+// EncodingOfSubject returns the encoding of the messages published on the
+// subject.
+func EncodingOfSubject(subject string) Encoding {
+	if strings.HasSuffix(subject, JSONSubjectSuffix) {
+		return EncodingJSON
+	}
+	return EncodingFlatbuf
+}
+
 type InstanceStatsMessage struct {
-	InstanceId string
-	Queues     []QueueStatsMessage
+	InstanceId string              `json:"instance_id"`
+	Queues     []QueueStatsMessage `json:"queues"`
+	Storage    StorageStats        `json:"storage"`
+	Labels     Labels              `json:"labels,omitempty"`
+
+	// The number of messages rejected at ingest by reason.
+	Rejected ReasonCounts `json:"rejected,omitempty"`
+
+	// The original subjects with the most messages ingested and republished,
+	// most first. The counts are approximate.
+	TopIngested    SubjectCounts `json:"top_ingested,omitempty"`
+	TopRepublished SubjectCounts `json:"top_republished,omitempty"`
+
+	// The original subjects with the most messages ingested without a reply
+	// subject, most first. Their producers never get a delivery confirmation.
+	// The counts are approximate.
+	TopNoReply SubjectCounts `json:"top_no_reply,omitempty"`
+
+	// The progress of the current, or last, key rotation if there has been
+	// one since the instance started.
+	KeyRotation *KeyRotation `json:"key_rotation,omitempty"`
+
+	// Whether the per-message instrumentation is being sampled because of
+	// the ingest rate. Only set when sampling is configured.
+	Sampling *Sampling `json:"sampling,omitempty"`
+
+	// The progress of the long running operations, such as purges, that are
+	// running or finished recently.
+	Operations Operations `json:"operations,omitempty"`
+
+	// The number of messages removed from the queues because their TTL
+	// elapsed since the instance started. Unlike the expired count of each
+	// queue, it includes the queues that have since been removed.
+	ExpiredTotal int64 `json:"expired_total,omitempty"`
+
+	// The version of requeue the instance is running and the features it
+	// supports.
+	Version  string   `json:"version,omitempty"`
+	Features Features `json:"features,omitempty"`
+}
+
+// SubjectCount is a count of messages for an original subject.
+type SubjectCount struct {
+	Subject string `json:"subject"`
+	Count   int64  `json:"count"`
+}
+
+// SubjectCounts are counts of messages by original subject in the order they
+// were ranked.
+type SubjectCounts []SubjectCount
+
+// toFlatbuf returns the offset of the counts vector.
+func (s SubjectCounts) toFlatbuf(b *flatbuffers.Builder, startVector func(*flatbuffers.Builder, int) flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+	offsets := make([]flatbuffers.UOffsetT, len(s))
+	for i, sc := range s {
+		subject := b.CreateByteString([]byte(sc.Subject))
+		flatbuf.SubjectCountStart(b)
+		flatbuf.SubjectCountAddSubject(b, subject)
+		flatbuf.SubjectCountAddCount(b, sc.Count)
+		offsets[i] = flatbuf.SubjectCountEnd(b)
+	}
+
+	// Add the offsets in reverse so we maintain order.
+	startVector(b, len(offsets))
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(offsets[i])
+	}
+	return b.EndVector(len(offsets))
+}
+
+func subjectCountsFromFlatbuf(n int, get func(*flatbuf.SubjectCount, int) bool) SubjectCounts {
+	if n == 0 {
+		return nil
+	}
+	s := make(SubjectCounts, 0, n)
+	for i := 0; i < n; i++ {
+		obj := &flatbuf.SubjectCount{}
+		if ok := get(obj, i); !ok {
+			continue
+		}
+		s = append(s, SubjectCount{Subject: string(obj.Subject()), Count: obj.Count()})
+	}
+	return s
+}
+
+// ReasonCounts are counts of events keyed by their reason.
+type ReasonCounts map[string]int64
+
+// toFlatbuf returns the offset of the counts vector. The counts are sorted by
+// reason so the encoding is deterministic.
+func (r ReasonCounts) toFlatbuf(b *flatbuffers.Builder, startVector func(*flatbuffers.Builder, int) flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+	reasons := make([]string, 0, len(r))
+	for k := range r {
+		reasons = append(reasons, k)
+	}
+	sort.Strings(reasons)
+
+	offsets := make([]flatbuffers.UOffsetT, len(reasons))
+	for i, k := range reasons {
+		reason := b.CreateByteString([]byte(k))
+		flatbuf.ReasonCountStart(b)
+		flatbuf.ReasonCountAddReason(b, reason)
+		flatbuf.ReasonCountAddCount(b, r[k])
+		offsets[i] = flatbuf.ReasonCountEnd(b)
+	}
+
+	// Add the offsets in reverse so we maintain order.
+	startVector(b, len(offsets))
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(offsets[i])
+	}
+	return b.EndVector(len(offsets))
+}
+
+func reasonCountsFromFlatbuf(n int, get func(*flatbuf.ReasonCount, int) bool) ReasonCounts {
+	if n == 0 {
+		return nil
+	}
+	r := make(ReasonCounts, n)
+	for i := 0; i < n; i++ {
+		obj := &flatbuf.ReasonCount{}
+		if ok := get(obj, i); !ok {
+			continue
+		}
+		r[string(obj.Reason())] = obj.Count()
+	}
+	return r
+}
+
+// Labels are static key value pairs, e.g., region, environment, or team, used
+// to tell instances apart in multi-environment fleets.
+type Labels map[string]string
+
+// toFlatbuf returns the offset of the labels vector. The labels are sorted by
+// key so the encoding is deterministic.
+func (l Labels) toFlatbuf(b *flatbuffers.Builder, startVector func(*flatbuffers.Builder, int) flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	offsets := make([]flatbuffers.UOffsetT, len(keys))
+	for i, k := range keys {
+		key := b.CreateByteString([]byte(k))
+		value := b.CreateByteString([]byte(l[k]))
+		flatbuf.LabelStart(b)
+		flatbuf.LabelAddKey(b, key)
+		flatbuf.LabelAddValue(b, value)
+		offsets[i] = flatbuf.LabelEnd(b)
+	}
+
+	// Add the offsets in reverse so we maintain order.
+	startVector(b, len(offsets))
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(offsets[i])
+	}
+	return b.EndVector(len(offsets))
+}
+
+func labelsFromFlatbuf(n int, get func(*flatbuf.Label, int) bool) Labels {
+	if n == 0 {
+		return nil
+	}
+	l := make(Labels, n)
+	for i := 0; i < n; i++ {
+		obj := &flatbuf.Label{}
+		if ok := get(obj, i); !ok {
+			continue
+		}
+		l[string(obj.Key())] = string(obj.Value())
+	}
+	return l
+}
+
+// StorageStats are the stats for the Badger store of an instance.
+type StorageStats struct {
+	LSMSize            int64   `json:"lsm_size"`
+	VlogSize           int64   `json:"vlog_size"`
+	NumTables          int64   `json:"num_tables"`
+	Level0Tables       int64   `json:"level0_tables"`
+	BlockCacheHitRatio float64 `json:"block_cache_hit_ratio"`
+	VlogGCRuns         int64   `json:"vlog_gc_runs"`
+	VlogReclaimedBytes int64   `json:"vlog_reclaimed_bytes"`
+}
+
+func (s *StorageStats) toFlatbuf(b *flatbuffers.Builder) flatbuffers.UOffsetT {
+	flatbuf.StorageStatsStart(b)
+	flatbuf.StorageStatsAddLsmSize(b, s.LSMSize)
+	flatbuf.StorageStatsAddVlogSize(b, s.VlogSize)
+	flatbuf.StorageStatsAddNumTables(b, s.NumTables)
+	flatbuf.StorageStatsAddLevel0Tables(b, s.Level0Tables)
+	flatbuf.StorageStatsAddBlockCacheHitRatio(b, s.BlockCacheHitRatio)
+	flatbuf.StorageStatsAddVlogGcRuns(b, s.VlogGCRuns)
+	flatbuf.StorageStatsAddVlogReclaimedBytes(b, s.VlogReclaimedBytes)
+	return flatbuf.StorageStatsEnd(b)
+}
+
+func (s *StorageStats) fromFlatbuf(m *flatbuf.StorageStats) {
+	if m == nil {
+		*s = StorageStats{}
+		return
+	}
+	s.LSMSize = m.LsmSize()
+	s.VlogSize = m.VlogSize()
+	s.NumTables = m.NumTables()
+	s.Level0Tables = m.Level0Tables()
+	s.BlockCacheHitRatio = m.BlockCacheHitRatio()
+	s.VlogGCRuns = m.VlogGcRuns()
+	s.VlogReclaimedBytes = m.VlogReclaimedBytes()
 }
 
 func DefaultInstanceStatsMessage() InstanceStatsMessage {
 	return InstanceStatsMessage{}
 }
 
+// InstanceStatsMessageFromNATS decodes the message using the encoding of the
+// subject it was published on.
 func InstanceStatsMessageFromNATS(msg *nats.Msg) InstanceStatsMessage {
 	m := DefaultInstanceStatsMessage()
-	// Unmarshal currently doesn't return any errors
-	_ = m.UnmarshalBinary(msg.Data)
+	// Unmarshal currently doesn't return any errors for flatbuf
+	_ = m.Decode(EncodingOfSubject(msg.Subject), msg.Data)
+	return m
+}
+
+// InstanceStatsMessageFromBytes decodes the flatbuf encoding of the message.
+func InstanceStatsMessageFromBytes(data []byte) InstanceStatsMessage {
+	m := DefaultInstanceStatsMessage()
+	// Unmarshal currently doesn't return any errors for flatbuf
+	_ = m.UnmarshalBinary(data)
 	return m
 }
 
+// Queue returns the stats for the queue. False is returned if there are none.
+func (i *InstanceStatsMessage) Queue(name string) (QueueStatsMessage, bool) {
+	for _, q := range i.Queues {
+		if q.QueueName == name {
+			return q, true
+		}
+	}
+	return QueueStatsMessage{}, false
+}
+
+// SetQueue replaces the stats for the queue of q, or adds them if there are
+// none.
+func (i *InstanceStatsMessage) SetQueue(q QueueStatsMessage) {
+	for j := range i.Queues {
+		if i.Queues[j].QueueName == q.QueueName {
+			i.Queues[j] = q
+			return
+		}
+	}
+	i.Queues = append(i.Queues, q)
+}
+
+// RemoveQueue removes the stats for the queue. False is returned if there
+// were none.
+func (i *InstanceStatsMessage) RemoveQueue(name string) bool {
+	for j := range i.Queues {
+		if i.Queues[j].QueueName == name {
+			i.Queues = append(i.Queues[:j], i.Queues[j+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Enqueued returns the number of messages in every queue.
+func (i *InstanceStatsMessage) Enqueued() int64 {
+	var n int64
+	for _, q := range i.Queues {
+		n += q.Enqueued
+	}
+	return n
+}
+
+// SetLabel sets the label k to v.
+func (i *InstanceStatsMessage) SetLabel(k, v string) {
+	if i.Labels == nil {
+		i.Labels = make(Labels)
+	}
+	i.Labels[k] = v
+}
+
+// AddRejected counts n more messages rejected at ingest for the reason.
+func (i *InstanceStatsMessage) AddRejected(reason NakReason, n int64) {
+	if i.Rejected == nil {
+		i.Rejected = make(ReasonCounts)
+	}
+	i.Rejected[string(reason)] += n
+}
+
+// Encode serializes the message with the encoding.
+func (i *InstanceStatsMessage) Encode(enc Encoding) ([]byte, error) {
+	if enc == EncodingJSON {
+		return json.Marshal(i)
+	}
+	return i.MarshalBinary()
+}
+
+// Decode deserializes data that was serialized with the encoding.
+func (i *InstanceStatsMessage) Decode(enc Encoding, data []byte) error {
+	if enc == EncodingJSON {
+		return json.Unmarshal(data, i)
+	}
+	return i.UnmarshalBinary(data)
+}
+
 func (i *InstanceStatsMessage) Bytes() []byte {
 	b := flatbuffers.NewBuilder(0)
 	msg := i.toFlatbuf(b)
@@ -57,9 +388,80 @@ func (i *InstanceStatsMessage) toFlatbuf(b *flatbuffers.Builder) flatbuffers.UOf
 	queues := b.EndVector(len(queueOffsets))
 
 	instanceId := b.CreateByteString([]byte(i.InstanceId))
+	storage := i.Storage.toFlatbuf(b)
+	var labels flatbuffers.UOffsetT
+	if len(i.Labels) > 0 {
+		labels = i.Labels.toFlatbuf(b, flatbuf.InstanceStatsMessageStartLabelsVector)
+	}
+	var rejected flatbuffers.UOffsetT
+	if len(i.Rejected) > 0 {
+		rejected = i.Rejected.toFlatbuf(b, flatbuf.InstanceStatsMessageStartRejectedVector)
+	}
+	var topIngested, topRepublished flatbuffers.UOffsetT
+	if len(i.TopIngested) > 0 {
+		topIngested = i.TopIngested.toFlatbuf(b, flatbuf.InstanceStatsMessageStartTopIngestedVector)
+	}
+	if len(i.TopRepublished) > 0 {
+		topRepublished = i.TopRepublished.toFlatbuf(b, flatbuf.InstanceStatsMessageStartTopRepublishedVector)
+	}
+	var topNoReply flatbuffers.UOffsetT
+	if len(i.TopNoReply) > 0 {
+		topNoReply = i.TopNoReply.toFlatbuf(b, flatbuf.InstanceStatsMessageStartTopNoReplyVector)
+	}
+	var version, features flatbuffers.UOffsetT
+	if i.Version != "" {
+		version = b.CreateByteString([]byte(i.Version))
+	}
+	if len(i.Features) > 0 {
+		features = i.Features.toFlatbuf(b, flatbuf.InstanceStatsMessageStartFeaturesVector)
+	}
+	var keyRotation flatbuffers.UOffsetT
+	if i.KeyRotation != nil {
+		keyRotation = i.KeyRotation.toFlatbuf(b)
+	}
+	var sampling flatbuffers.UOffsetT
+	if i.Sampling != nil {
+		sampling = i.Sampling.toFlatbuf(b)
+	}
+	var operations flatbuffers.UOffsetT
+	if len(i.Operations) > 0 {
+		operations = i.Operations.toFlatbuf(b, flatbuf.InstanceStatsMessageStartOperationsVector)
+	}
 	flatbuf.InstanceStatsMessageStart(b)
 	flatbuf.InstanceStatsMessageAddInstanceId(b, instanceId)
 	flatbuf.InstanceStatsMessageAddQueues(b, queues)
+	flatbuf.InstanceStatsMessageAddStorage(b, storage)
+	if len(i.Labels) > 0 {
+		flatbuf.InstanceStatsMessageAddLabels(b, labels)
+	}
+	if len(i.Rejected) > 0 {
+		flatbuf.InstanceStatsMessageAddRejected(b, rejected)
+	}
+	if len(i.TopIngested) > 0 {
+		flatbuf.InstanceStatsMessageAddTopIngested(b, topIngested)
+	}
+	if len(i.TopRepublished) > 0 {
+		flatbuf.InstanceStatsMessageAddTopRepublished(b, topRepublished)
+	}
+	if i.Version != "" {
+		flatbuf.InstanceStatsMessageAddVersion(b, version)
+	}
+	if len(i.Features) > 0 {
+		flatbuf.InstanceStatsMessageAddFeatures(b, features)
+	}
+	if len(i.TopNoReply) > 0 {
+		flatbuf.InstanceStatsMessageAddTopNoReply(b, topNoReply)
+	}
+	if i.KeyRotation != nil {
+		flatbuf.InstanceStatsMessageAddKeyRotation(b, keyRotation)
+	}
+	if i.Sampling != nil {
+		flatbuf.InstanceStatsMessageAddSampling(b, sampling)
+	}
+	if len(i.Operations) > 0 {
+		flatbuf.InstanceStatsMessageAddOperations(b, operations)
+	}
+	flatbuf.InstanceStatsMessageAddExpiredTotal(b, i.ExpiredTotal)
 	return flatbuf.InstanceStatsMessageEnd(b)
 }
 
@@ -73,12 +475,212 @@ func (i *InstanceStatsMessage) fromFlatbuf(m *flatbuf.InstanceStatsMessage) {
 		}
 		i.Queues[idx].fromFlatbuf(obj)
 	}
+	i.Storage.fromFlatbuf(m.Storage(nil))
+	i.Labels = labelsFromFlatbuf(m.LabelsLength(), m.Labels)
+	i.Rejected = reasonCountsFromFlatbuf(m.RejectedLength(), m.Rejected)
+	i.TopIngested = subjectCountsFromFlatbuf(m.TopIngestedLength(), m.TopIngested)
+	i.TopRepublished = subjectCountsFromFlatbuf(m.TopRepublishedLength(), m.TopRepublished)
+	i.Version = string(m.Version())
+	i.Features = featuresFromFlatbuf(m.FeaturesLength(), m.Features)
+	i.TopNoReply = subjectCountsFromFlatbuf(m.TopNoReplyLength(), m.TopNoReply)
+	i.KeyRotation = keyRotationFromFlatbuf(m.KeyRotation(nil))
+	i.Sampling = samplingFromFlatbuf(m.Sampling(nil))
+	i.Operations = operationsFromFlatbuf(m.OperationsLength(), m.Operations)
+	i.ExpiredTotal = m.ExpiredTotal()
+}
+
+// toFlatbuf returns the offset of the features vector.
+func (fs Features) toFlatbuf(b *flatbuffers.Builder, startVector func(*flatbuffers.Builder, int) flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+	offsets := make([]flatbuffers.UOffsetT, len(fs))
+	for i, f := range fs {
+		offsets[i] = b.CreateByteString([]byte(f))
+	}
+
+	// Add the offsets in reverse so we maintain order.
+	startVector(b, len(offsets))
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(offsets[i])
+	}
+	return b.EndVector(len(offsets))
+}
+
+func featuresFromFlatbuf(n int, get func(int) []byte) Features {
+	if n == 0 {
+		return nil
+	}
+	fs := make(Features, n)
+	for i := range fs {
+		fs[i] = Feature(get(i))
+	}
+	return fs
 }
 
 type QueueStatsMessage struct {
-	QueueName string
-	Enqueued  int64
-	InFlight  int64
+	QueueName string `json:"queue_name"`
+	Enqueued  int64  `json:"enqueued"`
+	InFlight  int64  `json:"in_flight"`
+
+	// InstanceId is only set when the stats for the queue are published on
+	// their own so they can be aggregated across instances.
+	InstanceId string `json:"instance_id,omitempty"`
+
+	// Latency from receiving a message to acknowledging it was persisted.
+	PersistLatency LatencyStats `json:"persist_latency"`
+
+	// Latency from a message being enqueued to it being successfully
+	// republished, including any delay.
+	RepublishLatency LatencyStats `json:"republish_latency"`
+
+	// Labels are only set when the stats for the queue are published on
+	// their own.
+	Labels Labels `json:"labels,omitempty"`
+
+	// A histogram of the age of the messages in the queue, youngest bucket
+	// first. A message is counted in the first bucket it's younger than.
+	Age AgeHistogram `json:"age,omitempty"`
+
+	// The number of messages removed from the queue because their TTL elapsed
+	// since the instance started.
+	Expired int64 `json:"expired"`
+
+	// The number of messages enqueued since the instance started.
+	EnqueuedTotal int64 `json:"enqueued_total"`
+
+	// The number of messages enqueued per second between the last two
+	// refreshes of the stats.
+	EnqueueRate float64 `json:"enqueue_rate"`
+
+	// When the oldest message in the queue was first enqueued in Unix
+	// nanoseconds, as of the last refresh of the stats. Zero if the queue was
+	// empty.
+	OldestEnqueuedAt int64 `json:"oldest_enqueued_at,omitempty"`
+
+	// The batches of messages committed to the queue since the instance
+	// started.
+	BatchCommits BatchCommitStats `json:"batch_commits"`
+}
+
+// AgeBucket is the number of messages younger than MaxAge that aren't in a
+// younger bucket. A zero MaxAge means the bucket is unbounded.
+type AgeBucket struct {
+	MaxAge time.Duration `json:"max_age"`
+	Count  int64         `json:"count"`
+}
+
+// AgeHistogram is a histogram of message ages, youngest bucket first.
+type AgeHistogram []AgeBucket
+
+// toFlatbuf returns the offset of the buckets vector.
+func (h AgeHistogram) toFlatbuf(b *flatbuffers.Builder, startVector func(*flatbuffers.Builder, int) flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+	offsets := make([]flatbuffers.UOffsetT, len(h))
+	for i, bucket := range h {
+		flatbuf.AgeBucketStart(b)
+		flatbuf.AgeBucketAddMaxAge(b, int64(bucket.MaxAge))
+		flatbuf.AgeBucketAddCount(b, bucket.Count)
+		offsets[i] = flatbuf.AgeBucketEnd(b)
+	}
+
+	// Add the offsets in reverse so we maintain order.
+	startVector(b, len(offsets))
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(offsets[i])
+	}
+	return b.EndVector(len(offsets))
+}
+
+func ageHistogramFromFlatbuf(n int, get func(*flatbuf.AgeBucket, int) bool) AgeHistogram {
+	if n == 0 {
+		return nil
+	}
+	h := make(AgeHistogram, 0, n)
+	for i := 0; i < n; i++ {
+		obj := &flatbuf.AgeBucket{}
+		if ok := get(obj, i); !ok {
+			continue
+		}
+		h = append(h, AgeBucket{MaxAge: time.Duration(obj.MaxAge()), Count: obj.Count()})
+	}
+	return h
+}
+
+// LatencyStats are the percentiles of a latency histogram.
+type LatencyStats struct {
+	P50 time.Duration `json:"p50"`
+	P95 time.Duration `json:"p95"`
+	P99 time.Duration `json:"p99"`
+}
+
+func (l *LatencyStats) toFlatbuf(b *flatbuffers.Builder) flatbuffers.UOffsetT {
+	flatbuf.LatencyStatsStart(b)
+	flatbuf.LatencyStatsAddP50(b, int64(l.P50))
+	flatbuf.LatencyStatsAddP95(b, int64(l.P95))
+	flatbuf.LatencyStatsAddP99(b, int64(l.P99))
+	return flatbuf.LatencyStatsEnd(b)
+}
+
+func (l *LatencyStats) fromFlatbuf(m *flatbuf.LatencyStats) {
+	if m == nil {
+		*l = LatencyStats{}
+		return
+	}
+	l.P50 = time.Duration(m.P50())
+	l.P95 = time.Duration(m.P95())
+	l.P99 = time.Duration(m.P99())
+}
+
+// QueueStatsMessageFromNATS decodes the message using the encoding of the
+// subject it was published on.
+func QueueStatsMessageFromNATS(msg *nats.Msg) QueueStatsMessage {
+	m := QueueStatsMessage{}
+	// Unmarshal currently doesn't return any errors for flatbuf
+	_ = m.Decode(EncodingOfSubject(msg.Subject), msg.Data)
+	return m
+}
+
+// QueueStatsMessageFromBytes decodes the flatbuf encoding of the message.
+func QueueStatsMessageFromBytes(data []byte) QueueStatsMessage {
+	m := QueueStatsMessage{}
+	// Unmarshal currently doesn't return any errors for flatbuf
+	_ = m.UnmarshalBinary(data)
+	return m
+}
+
+// SetLabel sets the label k to v.
+func (q *QueueStatsMessage) SetLabel(k, v string) {
+	if q.Labels == nil {
+		q.Labels = make(Labels)
+	}
+	q.Labels[k] = v
+}
+
+// AgeCount returns the number of messages in the queue younger than maxAge,
+// as of when its age histogram was made, by adding up the buckets up to the
+// bucket of maxAge. False is returned if there's no bucket with that max age.
+func (q *QueueStatsMessage) AgeCount(maxAge time.Duration) (int64, bool) {
+	var n int64
+	for _, b := range q.Age {
+		n += b.Count
+		if b.MaxAge == maxAge {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// Encode serializes the message with the encoding.
+func (q *QueueStatsMessage) Encode(enc Encoding) ([]byte, error) {
+	if enc == EncodingJSON {
+		return json.Marshal(q)
+	}
+	return q.MarshalBinary()
+}
+
+// Decode deserializes data that was serialized with the encoding.
+func (q *QueueStatsMessage) Decode(enc Encoding, data []byte) error {
+	if enc == EncodingJSON {
+		return json.Unmarshal(data, q)
+	}
+	return q.UnmarshalBinary(data)
 }
 
 func (q *QueueStatsMessage) Bytes() []byte {
@@ -100,11 +702,42 @@ func (q *QueueStatsMessage) UnmarshalBinary(data []byte) error {
 
 func (q *QueueStatsMessage) toFlatbuf(b *flatbuffers.Builder) flatbuffers.UOffsetT {
 	queueName := b.CreateByteString([]byte(q.QueueName))
+	var instanceId flatbuffers.UOffsetT
+	if q.InstanceId != "" {
+		instanceId = b.CreateByteString([]byte(q.InstanceId))
+	}
+	persistLatency := q.PersistLatency.toFlatbuf(b)
+	republishLatency := q.RepublishLatency.toFlatbuf(b)
+	var labels flatbuffers.UOffsetT
+	if len(q.Labels) > 0 {
+		labels = q.Labels.toFlatbuf(b, flatbuf.QueueStatsMessageStartLabelsVector)
+	}
+	var age flatbuffers.UOffsetT
+	if len(q.Age) > 0 {
+		age = q.Age.toFlatbuf(b, flatbuf.QueueStatsMessageStartAgeVector)
+	}
+	batchCommits := q.BatchCommits.toFlatbuf(b)
 
 	flatbuf.QueueStatsMessageStart(b)
 	flatbuf.QueueStatsMessageAddQueueName(b, queueName)
 	flatbuf.QueueStatsMessageAddEnqueued(b, q.Enqueued)
 	flatbuf.QueueStatsMessageAddInFlight(b, q.InFlight)
+	if q.InstanceId != "" {
+		flatbuf.QueueStatsMessageAddInstanceId(b, instanceId)
+	}
+	flatbuf.QueueStatsMessageAddPersistLatency(b, persistLatency)
+	flatbuf.QueueStatsMessageAddRepublishLatency(b, republishLatency)
+	if len(q.Labels) > 0 {
+		flatbuf.QueueStatsMessageAddLabels(b, labels)
+	}
+	if len(q.Age) > 0 {
+		flatbuf.QueueStatsMessageAddAge(b, age)
+	}
+	flatbuf.QueueStatsMessageAddExpired(b, q.Expired)
+	flatbuf.QueueStatsMessageAddEnqueuedTotal(b, q.EnqueuedTotal)
+	flatbuf.QueueStatsMessageAddEnqueueRate(b, q.EnqueueRate)
+	flatbuf.QueueStatsMessageAddOldestEnqueuedAt(b, q.OldestEnqueuedAt)
+	flatbuf.QueueStatsMessageAddBatchCommits(b, batchCommits)
 	return flatbuf.RequeueMessageEnd(b)
 }
 
@@ -112,6 +745,16 @@ func (q *QueueStatsMessage) fromFlatbuf(m *flatbuf.QueueStatsMessage) {
 	q.QueueName = string(m.QueueName())
 	q.Enqueued = m.Enqueued()
 	q.InFlight = m.InFlight()
+	q.InstanceId = string(m.InstanceId())
+	q.PersistLatency.fromFlatbuf(m.PersistLatency(nil))
+	q.RepublishLatency.fromFlatbuf(m.RepublishLatency(nil))
+	q.Labels = labelsFromFlatbuf(m.LabelsLength(), m.Labels)
+	q.Age = ageHistogramFromFlatbuf(m.AgeLength(), m.Age)
+	q.Expired = m.Expired()
+	q.EnqueuedTotal = m.EnqueuedTotal()
+	q.EnqueueRate = m.EnqueueRate()
+	q.OldestEnqueuedAt = m.OldestEnqueuedAt()
+	q.BatchCommits.fromFlatbuf(m.BatchCommits(nil))
 }
 
 var (
@@ -3,6 +3,7 @@ package protocol
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -13,10 +14,86 @@ func TestInstanceStatsMessageMarshalUnmarshalBinary(t *testing.T) {
 		queues[i].QueueName = fmt.Sprintf("Q%d", i)
 		queues[i].Enqueued = 103
 		queues[i].InFlight = 22
+		queues[i].EnqueuedTotal = 500
+		queues[i].EnqueueRate = 2.5
+		queues[i].OldestEnqueuedAt = time.Unix(100, 0).UnixNano()
+		queues[i].BatchCommits = BatchCommitStats{
+			Commits: 4,
+			Entries: 103,
+			Bytes:   4096,
+			Latency: LatencyStats{P50: time.Millisecond, P95: 2 * time.Millisecond, P99: 3 * time.Millisecond},
+		}
+		queues[i].Age = AgeHistogram{
+			{MaxAge: time.Minute, Count: 90},
+			{MaxAge: time.Hour, Count: 13},
+			{Count: int64(i)},
+		}
 	}
 	ism := InstanceStatsMessage{
 		InstanceId: "Inst1234",
 		Queues:     queues,
+		Storage: StorageStats{
+			LSMSize:            1024,
+			VlogSize:           2048,
+			NumTables:          7,
+			Level0Tables:       2,
+			BlockCacheHitRatio: 0.75,
+			VlogGCRuns:         3,
+			VlogReclaimedBytes: 4096,
+		},
+		Labels:       Labels{"region": "us-east-1", "env": "prod"},
+		Rejected:     ReasonCounts{string(NakReasonPayloadTooLarge): 3},
+		ExpiredTotal: 11,
+		TopIngested: SubjectCounts{
+			{Subject: "orders.created", Count: 40},
+			{Subject: "orders.paid", Count: 12},
+		},
+		TopRepublished: SubjectCounts{{Subject: "orders.paid", Count: 9}},
+		TopNoReply:     SubjectCounts{{Subject: "metrics.cpu", Count: 7}},
+		Version:        "1.2.3",
+		Features:       Features{FeatureAckTimeout, FeatureReceipts},
+		KeyRotation: &KeyRotation{
+			State:       KeyRotationRunning,
+			Queue:       "orders",
+			Queues:      3,
+			QueuesDone:  1,
+			Scanned:     1000,
+			Reencrypted: 990,
+			Failed:      10,
+			Error:       "key not found",
+			StartedAt:   time.Unix(100, 0).UTC(),
+			UpdatedAt:   time.Unix(160, 0).UTC(),
+		},
+		Sampling: &Sampling{
+			Active:     true,
+			IngestRate: 25000.5,
+			Threshold:  10000,
+			Every:      100,
+			Skipped:    4950,
+		},
+		Operations: Operations{
+			{
+				ID:        "op1",
+				Kind:      OperationPurge,
+				State:     OperationRunning,
+				Queue:     "orders",
+				Done:      250,
+				Total:     1000,
+				Percent:   25,
+				ETA:       3 * time.Minute,
+				StartedAt: time.Unix(100, 0).UTC(),
+				UpdatedAt: time.Unix(160, 0).UTC(),
+			},
+			{
+				ID:        "op2",
+				Kind:      OperationMigration,
+				State:     OperationFailed,
+				Done:      10,
+				Error:     "disk full",
+				StartedAt: time.Unix(100, 0).UTC(),
+				UpdatedAt: time.Unix(110, 0).UTC(),
+			},
+		},
 	}
 
 	// Serialize
@@ -29,4 +106,106 @@ func TestInstanceStatsMessageMarshalUnmarshalBinary(t *testing.T) {
 
 	assert.Equal(t, "Inst1234", out.InstanceId)
 	assert.Equal(t, queues, out.Queues)
+	assert.Equal(t, ism.Storage, out.Storage)
+	assert.Equal(t, ism.Labels, out.Labels)
+	assert.Equal(t, ism.Rejected, out.Rejected)
+	assert.Equal(t, ism.TopIngested, out.TopIngested)
+	assert.Equal(t, ism.TopRepublished, out.TopRepublished)
+	assert.Equal(t, ism.TopNoReply, out.TopNoReply)
+	assert.Equal(t, ism.Version, out.Version)
+	assert.Equal(t, ism.Features, out.Features)
+	assert.Equal(t, ism.KeyRotation, out.KeyRotation)
+	assert.Equal(t, ism.Sampling, out.Sampling)
+	assert.Equal(t, ism.Operations, out.Operations)
+	assert.Equal(t, ism.ExpiredTotal, out.ExpiredTotal)
+}
+
+func TestInstanceStatsMessageEncodeDecodeJSON(t *testing.T) {
+	ism := InstanceStatsMessage{
+		InstanceId: "Inst1234",
+		Queues: []QueueStatsMessage{
+			{QueueName: "Q1", Enqueued: 103, InFlight: 22},
+		},
+	}
+
+	b, err := ism.Encode(EncodingJSON)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"instance_id":"Inst1234","queues":[{"queue_name":"Q1","enqueued":103,"in_flight":22,"persist_latency":{"p50":0,"p95":0,"p99":0},"republish_latency":{"p50":0,"p95":0,"p99":0},"expired":0,"enqueued_total":0,"enqueue_rate":0,"batch_commits":{"commits":0,"entries":0,"bytes":0,"latency":{"p50":0,"p95":0,"p99":0}}}],"storage":{"lsm_size":0,"vlog_size":0,"num_tables":0,"level0_tables":0,"block_cache_hit_ratio":0,"vlog_gc_runs":0,"vlog_reclaimed_bytes":0}}`, string(b))
+
+	out := &InstanceStatsMessage{}
+	assert.NoError(t, out.Decode(EncodingOfSubject(EncodingJSON.Subject("stats")), b))
+	assert.Equal(t, ism, *out)
+}
+
+func TestQueueStatsMessageInstanceId(t *testing.T) {
+	qsm := QueueStatsMessage{
+		QueueName:  "Q1",
+		Enqueued:   103,
+		InFlight:   22,
+		Expired:    7,
+		InstanceId: "Inst1234",
+		Labels:     Labels{"team": "payments"},
+		PersistLatency: LatencyStats{
+			P50: 1 * time.Millisecond,
+			P95: 5 * time.Millisecond,
+			P99: 9 * time.Millisecond,
+		},
+		RepublishLatency: LatencyStats{
+			P50: 1 * time.Second,
+			P95: 5 * time.Second,
+			P99: 9 * time.Second,
+		},
+	}
+	for _, enc := range []Encoding{EncodingFlatbuf, EncodingJSON} {
+		b, err := qsm.Encode(enc)
+		assert.NoError(t, err)
+
+		out := QueueStatsMessage{}
+		assert.NoError(t, out.Decode(enc, b))
+		assert.Equal(t, qsm, out)
+	}
+}
+
+func TestStatsMessageHelpers(t *testing.T) {
+	ism := DefaultInstanceStatsMessage()
+	ism.SetQueue(QueueStatsMessage{QueueName: "Q1", Enqueued: 1})
+	ism.SetQueue(QueueStatsMessage{QueueName: "Q2", Enqueued: 2})
+	ism.SetQueue(QueueStatsMessage{QueueName: "Q1", Enqueued: 3})
+	ism.SetLabel("region", "us-east-1")
+	ism.AddRejected(NakReasonPayloadTooLarge, 2)
+	ism.AddRejected(NakReasonPayloadTooLarge, 1)
+
+	out := InstanceStatsMessageFromBytes(ism.Bytes())
+	q, ok := out.Queue("Q1")
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), q.Enqueued)
+	assert.Equal(t, int64(5), out.Enqueued())
+	assert.Equal(t, Labels{"region": "us-east-1"}, out.Labels)
+	assert.Equal(t, ReasonCounts{string(NakReasonPayloadTooLarge): 3}, out.Rejected)
+
+	assert.True(t, out.RemoveQueue("Q1"))
+	assert.False(t, out.RemoveQueue("Q1"))
+	_, ok = out.Queue("Q1")
+	assert.False(t, ok)
+	assert.Equal(t, int64(2), out.Enqueued())
+
+	qsm := QueueStatsMessage{
+		QueueName: "Q1",
+		Age: AgeHistogram{
+			{MaxAge: time.Minute, Count: 90},
+			{MaxAge: time.Hour, Count: 13},
+			{Count: 2},
+		},
+	}
+	qsm.SetLabel("team", "payments")
+	outQ := QueueStatsMessageFromBytes(qsm.Bytes())
+	assert.Equal(t, qsm, outQ)
+	n, ok := outQ.AgeCount(time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, int64(103), n)
+	n, ok = outQ.AgeCount(0)
+	assert.True(t, ok)
+	assert.Equal(t, int64(105), n)
+	_, ok = outQ.AgeCount(time.Second)
+	assert.False(t, ok)
 }
@@ -0,0 +1,137 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// SystemSubjectPrefix is the namespace requeue publishes its own events
+	// and requests on. Messages ingested on these subjects are never
+	// persisted.
+	SystemSubjectPrefix = "requeue."
+
+	// EventsSubjectPrefix is the prefix of all the event subjects.
+	EventsSubjectPrefix = SystemSubjectPrefix + "events."
+
+	// StatsSubjectPrefix is the prefix of all the stats subjects.
+	StatsSubjectPrefix = SystemSubjectPrefix + "stats."
+)
+
+// ReservedSubjects are the system subjects requeue must not ingest. This
+// matters because the default ingest subject, requeue.>, would otherwise match
+// them.
+type ReservedSubjects struct {
+	prefixes []string
+	subjects []string
+}
+
+// NewReservedSubjects returns the system subjects of an instance whose stats
+// are published on statsSubject and requested under statsPrefix. Either can
+// be empty to leave the stats out.
+func NewReservedSubjects(statsSubject, statsPrefix string) ReservedSubjects {
+	r := ReservedSubjects{
+		prefixes: []string{
+			EventsSubjectPrefix,
+			ControlSubjectPrefix,
+			ReceiptsSubjectPrefix,
+			DeadLettersSubjectPrefix,
+			InstancesSubjectPrefix,
+		},
+	}
+	if statsPrefix != "" {
+		r.prefixes = append(r.prefixes, statsPrefix)
+	}
+	if statsSubject != "" {
+		r.subjects = append(r.subjects, statsSubject, EncodingJSON.Subject(statsSubject))
+	}
+	return r
+}
+
+// DefaultReservedSubjects are the system subjects of an instance with the
+// default stats prefix.
+var DefaultReservedSubjects = NewReservedSubjects("", StatsSubjectPrefix)
+
+// Contains returns true if the subject belongs to requeue itself.
+func (r ReservedSubjects) Contains(subject string) bool {
+	for _, p := range r.prefixes {
+		if strings.HasPrefix(subject, p) {
+			return true
+		}
+	}
+	for _, s := range r.subjects {
+		if subject == s {
+			return true
+		}
+	}
+	return false
+}
+
+// IsReservedSubject returns true if the subject is one of the
+// DefaultReservedSubjects.
+func IsReservedSubject(subject string) bool {
+	return DefaultReservedSubjects.Contains(subject)
+}
+
+// StatsRequestSubject is the subject an instance answers stats requests on
+// under the stats prefix, i.e., StatsSubjectPrefix by default. Requests made on
+// the subject with a JSONSubjectSuffix are answered with JSON.
+func StatsRequestSubject(prefix, instanceId string) string {
+	return prefix + instanceId
+}
+
+// InstanceStatsSubject is where an instance publishes its stats under the
+// stats prefix when fleet stats are enabled.
+func InstanceStatsSubject(prefix, instanceId string) string {
+	return prefix + "instance." + instanceId
+}
+
+// QueueStatsSubject is where every instance publishes the stats for a queue
+// under the stats prefix when fleet stats are enabled. Subscribe to it to
+// aggregate the queue across the fleet without knowing every instance.
+func QueueStatsSubject(prefix, queueName string) string {
+	return prefix + "queue." + queueName
+}
+
+// ValidateSubject returns an error if the subject isn't a valid NATS subject.
+// Wildcards are allowed so it can be used for subscriptions.
+func ValidateSubject(subject string) error {
+	if subject == "" {
+		return fmt.Errorf("subject cannot be empty")
+	}
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("subject cannot contain whitespace: %q", subject)
+	}
+	tokens := strings.Split(subject, ".")
+	for i, t := range tokens {
+		switch {
+		case t == "":
+			return fmt.Errorf("subject cannot contain empty tokens: %q", subject)
+		case t == ">" && i != len(tokens)-1:
+			return fmt.Errorf("subject can only have > as the last token: %q", subject)
+		case len(t) > 1 && strings.ContainsAny(t, "*>"):
+			return fmt.Errorf("subject wildcards must be whole tokens: %q", subject)
+		}
+	}
+	return nil
+}
+
+// MatchSubject returns true if the subject matches the pattern, which may
+// contain the * and > wildcards.
+func MatchSubject(pattern, subject string) bool {
+	pts := strings.Split(pattern, ".")
+	sts := strings.Split(subject, ".")
+	for i, pt := range pts {
+		if pt == ">" {
+			// Matches one or more remaining tokens.
+			return len(sts) > i
+		}
+		if i >= len(sts) {
+			return false
+		}
+		if pt != "*" && pt != sts[i] {
+			return false
+		}
+	}
+	return len(pts) == len(sts)
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReservedSubject(t *testing.T) {
+	assert.True(t, IsReservedSubject(HealthEventsSubject))
+	assert.True(t, IsReservedSubject(StatsRequestSubject(StatsSubjectPrefix, "Inst1234")))
+	assert.True(t, IsReservedSubject(EncodingJSON.Subject(StatsRequestSubject(StatsSubjectPrefix, "Inst1234"))))
+	assert.True(t, IsReservedSubject(BacklogReportSubject("Inst1234")))
+	assert.True(t, IsReservedSubject(ReceiptSubject("orders.created")))
+	assert.True(t, IsReservedSubject(DeadLetterSubject("orders.created")))
+	assert.True(t, IsReservedSubject(HeartbeatSubject("Inst1234")))
+	assert.False(t, IsReservedSubject("requeue.foo"))
+	assert.False(t, IsReservedSubject("requeue.eventsfoo"))
+}
+
+func TestNewReservedSubjects(t *testing.T) {
+	r := NewReservedSubjects("requeue.metrics", "requeue.prod.stats.")
+	assert.True(t, r.Contains("requeue.metrics"))
+	assert.True(t, r.Contains(EncodingJSON.Subject("requeue.metrics")))
+	assert.True(t, r.Contains(StatsRequestSubject("requeue.prod.stats.", "Inst1234")))
+	assert.True(t, r.Contains(HealthEventsSubject))
+	assert.False(t, r.Contains(StatsRequestSubject(StatsSubjectPrefix, "Inst1234")))
+	assert.False(t, r.Contains("requeue.metrics.daily"))
+}
+
+func TestValidateSubject(t *testing.T) {
+	for _, s := range []string{"foo", "foo.bar", "foo.*.baz", "foo.>", ">", "requeue.>"} {
+		assert.NoError(t, ValidateSubject(s), "subject %q", s)
+	}
+	for _, s := range []string{"", "foo..bar", ".foo", "foo.", "foo bar", "foo.>.bar", "foo.ba*", "foo>"} {
+		assert.Error(t, ValidateSubject(s), "subject %q", s)
+	}
+}
+
+func TestMatchSubject(t *testing.T) {
+	for _, c := range []struct {
+		pattern, subject string
+		match            bool
+	}{
+		{"foo.bar", "foo.bar", true},
+		{"foo.bar", "foo.baz", false},
+		{"foo.*", "foo.bar", true},
+		{"foo.*", "foo.bar.baz", false},
+		{"foo.*.baz", "foo.bar.baz", true},
+		{"foo.>", "foo.bar.baz", true},
+		{"foo.>", "foo", false},
+		{">", "foo", true},
+		{"foo", "foo.bar", false},
+	} {
+		assert.Equal(t, c.match, MatchSubject(c.pattern, c.subject), "pattern %q subject %q", c.pattern, c.subject)
+	}
+}
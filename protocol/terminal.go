@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TerminalState is how a message left its queue.
+type TerminalState string
+
+const (
+	// TerminalStateDelivered is a message that was republished and
+	// acknowledged.
+	TerminalStateDelivered TerminalState = "delivered"
+
+	// TerminalStateExpired is a message whose TTL elapsed before it could be
+	// delivered.
+	TerminalStateExpired TerminalState = "expired"
+
+	// TerminalStateDeadLettered is a message that was given up on, e.g.,
+	// because it ran out of retries.
+	TerminalStateDeadLettered TerminalState = "dead_lettered"
+)
+
+const (
+	// TerminalReasonRetriesExhausted is given when a message is dead lettered
+	// because it used all the retries it asked for.
+	TerminalReasonRetriesExhausted = "retries_exhausted"
+
+	// TerminalReasonMaxRedeliveries is given when a message is dead lettered
+	// because it reached the max redeliveries of its queue.
+	TerminalReasonMaxRedeliveries = "max_redeliveries"
+
+	// TerminalReasonDecryptFailed is given when a message is dead lettered
+	// because its payload couldn't be decrypted, e.g., its key is missing.
+	// Retrying wouldn't help so none of its retries are spent.
+	TerminalReasonDecryptFailed = "decrypt_failed"
+)
+
+// TerminalRecord is the compact record kept of what happened to a message once
+// it left its queue.
+type TerminalRecord struct {
+	Queue string `json:"queue"`
+	// Key is the readable form of the message key when it left the queue.
+	Key string `json:"key"`
+	// MessageID is the readable form of the key the message was first stored
+	// under. It stays the same when the message is retried, unlike Key.
+	MessageID string `json:"message_id,omitempty"`
+	// Subject is the original subject of the message.
+	Subject string        `json:"subject"`
+	State   TerminalState `json:"state"`
+	// Reason explains the state, e.g., why the message was dead lettered.
+	Reason string `json:"reason,omitempty"`
+	// Error is the last error republishing the message, if any.
+	Error string `json:"error,omitempty"`
+	// Attempts is the number of times the message was republished.
+	Attempts uint64 `json:"attempts"`
+	// Latency is the time from when the message was first enqueued until it
+	// was delivered.
+	Latency time.Duration `json:"latency,omitempty"`
+	// ReplyTo is the subject the producer is acknowledged on once the message
+	// is delivered, when acks are deferred until delivery.
+	ReplyTo string    `json:"reply_to,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+func (r TerminalRecord) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *TerminalRecord) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
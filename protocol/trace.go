@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// The headers requeue adds to the messages it republishes when trace headers
+// are enabled, so consumers can tell they're handling a retried message and
+// where it came from.
+const (
+	// TraceParentHeader is the W3C trace context header. Every attempt to
+	// republish a message continues the trace it was ingested with, or one
+	// derived from its message id, as a new span.
+	TraceParentHeader = "traceparent"
+
+	// MessageIDHeader holds the readable form of the key the message was
+	// first stored under, which stays the same when it's retried.
+	MessageIDHeader = "Requeue-Message-Id"
+
+	// EnqueuedAtHeader holds the time the message was first enqueued in
+	// RFC 3339 format with nanoseconds.
+	EnqueuedAtHeader = "Requeue-Enqueued-At"
+
+	// AttemptHeader holds the attempt number, starting at one for the first
+	// time the message is republished.
+	AttemptHeader = "Requeue-Attempt"
+
+	// InstanceIDHeader holds the id of the instance that republished the
+	// message.
+	InstanceIDHeader = "Requeue-Instance-Id"
+)
+
+// TraceParent is a version 00 W3C traceparent.
+type TraceParent struct {
+	TraceID  [16]byte
+	ParentID [8]byte
+	Flags    byte
+}
+
+// ParseTraceParent parses the traceparent header value s.
+func ParseTraceParent(s string) (TraceParent, error) {
+	var tp TraceParent
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return tp, fmt.Errorf("invalid traceparent: %q", s)
+	}
+	if err := decodeHexField(tp.TraceID[:], parts[1]); err != nil {
+		return tp, fmt.Errorf("invalid traceparent trace id: %w", err)
+	}
+	if err := decodeHexField(tp.ParentID[:], parts[2]); err != nil {
+		return tp, fmt.Errorf("invalid traceparent parent id: %w", err)
+	}
+	var flags [1]byte
+	if err := decodeHexField(flags[:], parts[3]); err != nil {
+		return tp, fmt.Errorf("invalid traceparent flags: %w", err)
+	}
+	tp.Flags = flags[0]
+	// All zero ids are invalid.
+	if tp.TraceID == ([16]byte{}) || tp.ParentID == ([8]byte{}) {
+		return tp, fmt.Errorf("invalid traceparent: %q", s)
+	}
+	return tp, nil
+}
+
+func decodeHexField(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return fmt.Errorf("expected %d lowercase hex characters: %q", hex.EncodedLen(len(dst)), s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// String returns the header value of the traceparent.
+func (tp TraceParent) String() string {
+	return fmt.Sprintf("00-%x-%x-%02x", tp.TraceID, tp.ParentID, tp.Flags)
+}
@@ -0,0 +1,41 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	s := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tp, err := ParseTraceParent(s)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x4b), tp.TraceID[0])
+	assert.Equal(t, byte(0xb7), tp.ParentID[7])
+	assert.Equal(t, byte(0x01), tp.Flags)
+	assert.Equal(t, s, tp.String())
+
+	for _, invalid := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+	} {
+		_, err := ParseTraceParent(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestSetTraceParent(t *testing.T) {
+	m := DefaultRequeueMessage()
+	m.OriginalSubject = "foo"
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	fb := flatbuf.GetRootAsRequeueMessage(SetTraceParent(m.Bytes(), tp), 0)
+	assert.Equal(t, tp, string(fb.TraceParent()))
+	assert.Equal(t, "foo", string(fb.OriginalSubject()))
+}
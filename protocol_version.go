@@ -0,0 +1,58 @@
+package requeue
+
+import (
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// ErrUnsupportedProtocol is the error an UnsupportedProtocolHandler is given
+// for a message encoded with a protocol version the instance can't upgrade to
+// protocol.CurrentProtocolVersion, which is wrapped with the version.
+var ErrUnsupportedProtocol = protocol.ErrUnsupportedProtocol
+
+// UnsupportedProtocolHandler handles a message received with a protocol version
+// the instance doesn't support, e.g., one sent by a newer producer during a
+// rolling upgrade. It returns the message to persist in its place, translated
+// to a supported version, or an error to reject it with
+// protocol.NakReasonUnsupportedProtocol.
+type UnsupportedProtocolHandler func(msg *nats.Msg, err error) (protocol.RequeueMessage, error)
+
+// UnsupportedProtocol sets the handler for messages received with a protocol
+// version the instance doesn't support. Without one they are rejected.
+func UnsupportedProtocol(h UnsupportedProtocolHandler) Option {
+	return func(o *Options) error {
+		o.unsupportedProtocolHandler = h
+		return nil
+	}
+}
+
+// upgradeProtocol returns the message with its envelope upgraded to the
+// current protocol version. Messages that already use it are returned as is.
+// False is returned if the message was rejected because it can't be upgraded.
+func (c *Conn) upgradeProtocol(msg *nats.Msg) (*nats.Msg, bool) {
+	if protocol.MessageProtocolVersion(msg.Data) == protocol.CurrentProtocolVersion {
+		return msg, true
+	}
+
+	m := protocol.DefaultRequeueMessage()
+	_ = m.UnmarshalBinary(msg.Data)
+	err := protocol.UpgradeMessage(&m)
+	if err != nil && c.Opts.unsupportedProtocolHandler != nil {
+		if m, err = c.Opts.unsupportedProtocolHandler(msg, err); err == nil {
+			// The handler can only translate to a version we understand.
+			err = protocol.UpgradeMessage(&m)
+		}
+	}
+	if err != nil {
+		c.nak(msg, protocol.NakReasonUnsupportedProtocol, err.Error())
+		return nil, false
+	}
+
+	return &nats.Msg{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Header:  msg.Header,
+		Data:    m.Bytes(),
+		Sub:     msg.Sub,
+	}, true
+}
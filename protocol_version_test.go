@@ -0,0 +1,73 @@
+package requeue
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpgradeProtocol(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protocol-version-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var handled error
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+
+	m := protocol.DefaultRequeueMessage()
+	m.OriginalSubject = "orders.created"
+	m.OriginalPayload = []byte("order")
+	current := m.Bytes()
+
+	// Messages with the current version are left alone.
+	msg := &nats.Msg{Subject: "requeue.in", Data: current}
+	got, ok := c.upgradeProtocol(msg)
+	assert.True(t, ok)
+	assert.True(t, got == msg)
+
+	// Unversioned messages are upgraded.
+	legacy := m.Bytes()
+	assert.True(t, flatbuf.GetRootAsRequeueMessage(legacy, 0).MutateProtocolVersion(0))
+	got, ok = c.upgradeProtocol(&nats.Msg{Subject: "requeue.in", Data: legacy})
+	assert.True(t, ok)
+	assert.Equal(t, protocol.CurrentProtocolVersion, protocol.MessageProtocolVersion(got.Data))
+	assert.Equal(t, "order", string(flatbuf.GetRootAsRequeueMessage(got.Data, 0).OriginalPayloadBytes()))
+
+	// Newer messages are rejected without a handler.
+	m.ProtocolVersion = protocol.CurrentProtocolVersion + 1
+	newer := m.Bytes()
+	_, ok = c.upgradeProtocol(&nats.Msg{Subject: "requeue.in", Data: newer})
+	assert.False(t, ok)
+	assert.Equal(t, int64(1), c.counters.Rejected()[string(protocol.NakReasonUnsupportedProtocol)])
+
+	// The handler can translate them.
+	c.Opts.unsupportedProtocolHandler = func(msg *nats.Msg, err error) (protocol.RequeueMessage, error) {
+		handled = err
+		var m protocol.RequeueMessage
+		_ = m.UnmarshalBinary(msg.Data)
+		m.ProtocolVersion = protocol.CurrentProtocolVersion
+		return m, nil
+	}
+	got, ok = c.upgradeProtocol(&nats.Msg{Subject: "requeue.in", Data: newer})
+	assert.True(t, ok)
+	assert.True(t, errors.Is(handled, ErrUnsupportedProtocol))
+	assert.Equal(t, protocol.CurrentProtocolVersion, protocol.MessageProtocolVersion(got.Data))
+
+	// Or still reject them.
+	c.Opts.unsupportedProtocolHandler = func(msg *nats.Msg, err error) (protocol.RequeueMessage, error) {
+		return protocol.RequeueMessage{}, err
+	}
+	_, ok = c.upgradeProtocol(&nats.Msg{Subject: "requeue.in", Data: newer})
+	assert.False(t, ok)
+	assert.Equal(t, int64(2), c.counters.Rejected()[string(protocol.NakReasonUnsupportedProtocol)])
+}
@@ -0,0 +1,15 @@
+package requeue
+
+// QueueGroupHeaders sends the downstream queue group a message is intended
+// for, which producers set in its queue_group, in the
+// protocol.QueueGroupHeader every time it's republished. Every queue group
+// subscribed to a subject gets a copy of a retried message, so this lets the
+// members of the other groups ignore it with protocol.IntendedFor and keeps
+// load balancing the same as for the original. The NATS servers need to
+// support headers.
+func QueueGroupHeaders() Option {
+	return func(o *Options) error {
+		o.queueGroupHeaders = true
+		return nil
+	}
+}
@@ -0,0 +1,20 @@
+package requeue
+
+import (
+	"testing"
+
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueGroupHeaders(t *testing.T) {
+	c := NewConn(GetDefaultOptions())
+	assert.False(t, c.Features().Supports(protocol.FeatureQueueGroupHeaders))
+	n := len(c.republisherOptions())
+
+	o := GetDefaultOptions()
+	assert.NoError(t, QueueGroupHeaders()(&o))
+	c = NewConn(o)
+	assert.True(t, c.Features().Supports(protocol.FeatureQueueGroupHeaders))
+	assert.Len(t, c.republisherOptions(), n+1)
+}
@@ -0,0 +1,146 @@
+package requeue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// queueWriterIdleTimeout is how long the writer of a queue waits for another
+// write before it stops. It's started again by the next write.
+const queueWriterIdleTimeout = time.Minute
+
+// IsolateQueueWriters dedicates a writer to each queue so the consumers hand
+// off the writes of a message rather than making them, and one queue's huge
+// payloads or commit stalls don't hold up the messages of the others. Up to
+// buffer writes wait for each writer before the consumers wait too. The
+// writes of a queue are still made in the order its messages were received.
+func IsolateQueueWriters(buffer int) Option {
+	return func(o *Options) error {
+		if buffer <= 0 {
+			return fmt.Errorf("queue writer buffer must be positive: %d", buffer)
+		}
+		o.queueWriterBuffer = buffer
+		return nil
+	}
+}
+
+// queueWriters runs the writes of each queue on a goroutine of its own.
+type queueWriters struct {
+	buffer int
+	idle   time.Duration
+
+	mu     sync.Mutex
+	queues map[string]*queueWriter
+	closed bool
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// queueWriter is the writer of a queue.
+type queueWriter struct {
+	ch chan func()
+	// The writes handed off that the writer is yet to receive. Guarded by
+	// the lock of the queueWriters.
+	pending int
+}
+
+func newQueueWriters(buffer int) *queueWriters {
+	return &queueWriters{
+		buffer: buffer,
+		idle:   queueWriterIdleTimeout,
+		queues: make(map[string]*queueWriter),
+		quit:   make(chan struct{}),
+	}
+}
+
+// write runs f on the writer of the queue after the writes before it. It's run
+// right away once the writers are closed.
+func (w *queueWriters) write(queue string, f func()) {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		f()
+		return
+	}
+	qw, ok := w.queues[queue]
+	if !ok {
+		qw = &queueWriter{ch: make(chan func(), w.buffer)}
+		w.queues[queue] = qw
+		w.wg.Add(1)
+		go w.run(queue, qw)
+	}
+	// The writer won't stop while there are writes pending.
+	qw.pending++
+	w.mu.Unlock()
+
+	qw.ch <- f
+}
+
+// run makes the writes of the queue until it has been idle for a while or the
+// writers are closed.
+func (w *queueWriters) run(queue string, qw *queueWriter) {
+	defer w.wg.Done()
+	timer := time.NewTimer(w.idle)
+	defer timer.Stop()
+	for {
+		select {
+		case f := <-qw.ch:
+			w.received(qw)
+			f()
+		case <-timer.C:
+			w.mu.Lock()
+			if qw.pending == 0 {
+				delete(w.queues, queue)
+				w.mu.Unlock()
+				return
+			}
+			w.mu.Unlock()
+		case <-w.quit:
+			// Nothing more is handed off once closed so make the writes
+			// that were.
+			for {
+				w.mu.Lock()
+				pending := qw.pending
+				w.mu.Unlock()
+				if pending == 0 {
+					return
+				}
+				f := <-qw.ch
+				w.received(qw)
+				f()
+			}
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(w.idle)
+	}
+}
+
+func (w *queueWriters) received(qw *queueWriter) {
+	w.mu.Lock()
+	qw.pending--
+	w.mu.Unlock()
+}
+
+// len returns the number of queues with a writer running.
+func (w *queueWriters) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.queues)
+}
+
+// close waits for the writes handed off so far to be made.
+func (w *queueWriters) close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.quit)
+	}
+	w.mu.Unlock()
+	w.wg.Wait()
+}
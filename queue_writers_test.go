@@ -0,0 +1,79 @@
+package requeue
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueWriters(t *testing.T) {
+	w := newQueueWriters(4)
+
+	// The writes of a queue are made in order.
+	var mu sync.Mutex
+	var orders []int
+	for i := 0; i < 100; i++ {
+		i := i
+		w.write("orders", func() {
+			mu.Lock()
+			orders = append(orders, i)
+			mu.Unlock()
+		})
+	}
+
+	// A stalled queue doesn't hold up the others.
+	stall := make(chan struct{})
+	w.write("stalled", func() { <-stall })
+	done := make(chan struct{})
+	w.write("users", func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write to users was held up by the stalled queue")
+	}
+	assert.Equal(t, 3, w.len())
+
+	// Closing waits for the writes handed off.
+	closed := make(chan struct{})
+	go func() {
+		w.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("closed before the stalled write was made")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(stall)
+	<-closed
+
+	mu.Lock()
+	assert.Len(t, orders, 100)
+	for i, n := range orders {
+		assert.Equal(t, i, n)
+	}
+	mu.Unlock()
+
+	// Writes are made right away once closed.
+	ran := false
+	w.write("orders", func() { ran = true })
+	assert.True(t, ran)
+}
+
+func TestQueueWritersIdle(t *testing.T) {
+	w := newQueueWriters(1)
+	w.idle = 10 * time.Millisecond
+	defer w.close()
+
+	done := make(chan struct{})
+	w.write("orders", func() { close(done) })
+	<-done
+	assert.Eventually(t, func() bool { return w.len() == 0 }, 5*time.Second, 5*time.Millisecond)
+
+	// The writer starts again with the next write.
+	done = make(chan struct{})
+	w.write("orders", func() { close(done) })
+	<-done
+}
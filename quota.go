@@ -0,0 +1,162 @@
+package requeue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// QueueQuota limits the number of messages that can be stored in the queue.
+// Once the limit is reached new messages for the queue are rejected with a
+// protocol.Nak until messages are republished or expire. Since the counts are
+// updated as messages are committed, the limit can be briefly overshot under
+// concurrent ingest.
+func QueueQuota(queueName string, maxMessages int64) Option {
+	return func(o *Options) error {
+		if queueName == "" {
+			return fmt.Errorf("queue quota: queue name cannot be blank")
+		}
+		if maxMessages < 0 {
+			return fmt.Errorf("queue quota: max messages cannot be negative: %d", maxMessages)
+		}
+		if o.queueQuotas == nil {
+			o.queueQuotas = make(map[string]int64)
+		}
+		o.queueQuotas[queueName] = maxMessages
+		return nil
+	}
+}
+
+// TenantQuota limits the number of messages that can be stored across all the
+// queues of the tenant. See QueueTenant for how queues are mapped to tenants.
+func TenantQuota(tenant string, maxMessages int64) Option {
+	return func(o *Options) error {
+		if tenant == "" {
+			return fmt.Errorf("tenant quota: tenant cannot be blank")
+		}
+		if maxMessages < 0 {
+			return fmt.Errorf("tenant quota: max messages cannot be negative: %d", maxMessages)
+		}
+		if o.tenantQuotas == nil {
+			o.tenantQuotas = make(map[string]int64)
+		}
+		o.tenantQuotas[tenant] = maxMessages
+		return nil
+	}
+}
+
+// QueueTenant sets the function used to find the tenant a queue belongs to,
+// e.g., by the prefix of the queue name. An empty tenant means the queue
+// doesn't belong to one.
+func QueueTenant(f func(queueName string) string) Option {
+	return func(o *Options) error {
+		o.queueTenantFn = f
+		return nil
+	}
+}
+
+// QuotaExceededHandler sets a callback that will be triggered each time a
+// queue or tenant quota becomes exceeded. The events are also published on
+// protocol.QuotaEventsSubject.
+func QuotaExceededHandler(cb func(protocol.QuotaEvent)) Option {
+	return func(o *Options) error {
+		o.quotaExceededCB = cb
+		return nil
+	}
+}
+
+// quotas tracks which quotas are currently exceeded so an event is only
+// published when a quota becomes exceeded.
+type quotas struct {
+	mu       sync.Mutex
+	exceeded map[string]bool
+}
+
+func newQuotas() *quotas {
+	return &quotas{exceeded: make(map[string]bool)}
+}
+
+// set records whether the quota is exceeded and returns true if it wasn't
+// exceeded before.
+func (q *quotas) set(scope protocol.QuotaScope, name string, exceeded bool) bool {
+	id := string(scope) + ":" + name
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	was := q.exceeded[id]
+	if exceeded {
+		q.exceeded[id] = true
+	} else {
+		delete(q.exceeded, id)
+	}
+	return exceeded && !was
+}
+
+func (c *Conn) tenantOf(queueName string) string {
+	if c.Opts.queueTenantFn == nil {
+		return ""
+	}
+	return c.Opts.queueTenantFn(queueName)
+}
+
+// usage returns the number of messages stored in the queues matching the
+// filter. Queues are matched on their name without any time bucket.
+func (c *Conn) usage(match func(queueName string) bool) int64 {
+	var n int64
+	for _, q := range c.Manager().Queues() {
+		base, _ := queue.SplitBucketName(q.Name())
+		if match(base) {
+			n += q.Stats.Count()
+		}
+	}
+	return n
+}
+
+// checkQuotas returns false if accepting the message would exceed a quota, in
+// which case the message has been rejected.
+func (c *Conn) checkQuotas(msg *nats.Msg, queueName string) bool {
+	if limit, ok := c.Opts.queueQuotas[queueName]; ok {
+		usage := c.usage(func(name string) bool { return name == queueName })
+		if !c.checkQuota(msg, protocol.QuotaScopeQueue, queueName, queueName, limit, usage) {
+			return false
+		}
+	}
+
+	tenant := c.tenantOf(queueName)
+	if limit, ok := c.Opts.tenantQuotas[tenant]; ok && tenant != "" {
+		usage := c.usage(func(name string) bool { return c.tenantOf(name) == tenant })
+		if !c.checkQuota(msg, protocol.QuotaScopeTenant, tenant, queueName, limit, usage) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Conn) checkQuota(msg *nats.Msg, scope protocol.QuotaScope, name, queueName string, limit, usage int64) bool {
+	exceeded := usage >= limit
+	if c.quotas.set(scope, name, exceeded) {
+		e := protocol.QuotaEvent{
+			InstanceID: c.instanceId,
+			Scope:      scope,
+			Tenant:     c.tenantOf(queueName),
+			Queue:      queueName,
+			Quota:      limit,
+			Usage:      usage,
+			Labels:     c.Opts.labels,
+			Time:       time.Now(),
+		}
+		if cb := c.Opts.quotaExceededCB; cb != nil {
+			c.hook(func() { cb(e) })
+		}
+		c.publishEvent(protocol.QuotaEventsSubject, e)
+	}
+	if exceeded {
+		c.nak(msg, protocol.NakReasonQuotaExceeded,
+			fmt.Sprintf("%s quota of %d messages exceeded for %s", scope, limit, name))
+		return false
+	}
+	return true
+}
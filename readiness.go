@@ -0,0 +1,157 @@
+package requeue
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// ReadinessThresholds are the limits past which an instance reports that it
+// isn't ready. A zero limit isn't checked.
+type ReadinessThresholds struct {
+	// The longest the messages received since the last check can take to be
+	// committed on average.
+	MaxCommitLatency time.Duration
+
+	// The most messages taken from NATS that can be waiting for a consumer.
+	MaxPending int
+
+	// The smallest fraction of the disk the data is stored on that can be
+	// free, between 0 and 1.
+	MinFreeDisk float64
+}
+
+// DefaultReadinessThresholds are the readiness thresholds used by default.
+var DefaultReadinessThresholds = ReadinessThresholds{
+	MaxCommitLatency: time.Second,
+	MaxPending:       10000,
+	MinFreeDisk:      0.05,
+}
+
+// ReadinessChecks sets the thresholds past which the instance reports it isn't
+// ready, on the /readyz endpoint of the admin API, with Conn.Readiness, and
+// as a degraded HealthEvent, so traffic can be shed from an instance that is
+// struggling before it falls over. Readiness is checked by the watchdog every
+// HealthCheckInterval.
+func ReadinessChecks(t ReadinessThresholds) Option {
+	return func(o *Options) error {
+		if t.MaxCommitLatency < 0 {
+			return fmt.Errorf("readiness: max commit latency cannot be negative: %s", t.MaxCommitLatency)
+		}
+		if t.MaxPending < 0 {
+			return fmt.Errorf("readiness: max pending cannot be negative: %d", t.MaxPending)
+		}
+		if t.MinFreeDisk < 0 || t.MinFreeDisk >= 1 {
+			return fmt.Errorf("readiness: min free disk must be at least 0 and less than 1: %v", t.MinFreeDisk)
+		}
+		o.readiness = t
+		return nil
+	}
+}
+
+// latencyWindow averages the latencies recorded since it was last reset.
+type latencyWindow struct {
+	// Accessed atomically.
+	sum   int64
+	count int64
+}
+
+func (w *latencyWindow) record(d time.Duration) {
+	atomic.AddInt64(&w.sum, int64(d))
+	atomic.AddInt64(&w.count, 1)
+}
+
+// reset returns the average latency since the last reset, or zero if nothing
+// was recorded.
+func (w *latencyWindow) reset() time.Duration {
+	count := atomic.SwapInt64(&w.count, 0)
+	sum := atomic.SwapInt64(&w.sum, 0)
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(sum / count)
+}
+
+// readinessState is the result of the last readiness check.
+type readinessState struct {
+	mu      sync.RWMutex
+	checked bool
+	last    protocol.Readiness
+}
+
+// Readiness returns whether the instance is keeping up with the messages it's
+// taking, as of the last check by the watchdog. It's checked now if the
+// watchdog isn't running.
+func (c *Conn) Readiness() protocol.Readiness {
+	c.readiness.mu.RLock()
+	r, checked := c.readiness.last, c.readiness.checked
+	c.readiness.mu.RUnlock()
+	if checked && c.Opts.healthCheckInterval > 0 && !c.Opts.ingestDisabled {
+		return r
+	}
+	return c.checkReadiness()
+}
+
+// checkReadiness measures the instance against the readiness thresholds and
+// keeps the result.
+func (c *Conn) checkReadiness() protocol.Readiness {
+	t := c.Opts.readiness
+	r := protocol.Readiness{
+		InstanceID:    c.instanceId,
+		CommitLatency: c.commitLatency.reset(),
+		Pending:       len(c.natsMsgCh),
+		FreeDisk:      1,
+		Time:          time.Now(),
+	}
+
+	c.mu.RLock()
+	sub := c.sub
+	left := c.leftQueueGroup
+	c.mu.RUnlock()
+	if sub != nil && sub.IsValid() {
+		if pending, _, err := sub.Pending(); err == nil {
+			r.Pending += pending
+		}
+	}
+
+	// Nothing is stored on disk when the store is in memory.
+	if !badgerInternal.InMemory(c.badgerOpenOptions()...) {
+		dataDir, _ := c.storeDirs()
+		if free, total, err := badgerInternal.DiskUsage(dataDir); err != nil {
+			log.Err(err).Msg("readiness: problem checking the free disk")
+		} else if total > 0 {
+			r.FreeDisk = float64(free) / float64(total)
+		}
+	}
+
+	if left {
+		r.Reasons = append(r.Reasons, "left the ingest queue group")
+	}
+	if err := c.storageFailure(); err != nil {
+		r.Reasons = append(r.Reasons, fmt.Sprintf("storage can't be written to: %v", err))
+	}
+	if t.MaxCommitLatency > 0 && r.CommitLatency > t.MaxCommitLatency {
+		r.Reasons = append(r.Reasons, fmt.Sprintf("commit latency of %s exceeds %s", r.CommitLatency, t.MaxCommitLatency))
+	}
+	if t.MaxPending > 0 && r.Pending > t.MaxPending {
+		r.Reasons = append(r.Reasons, fmt.Sprintf("%d pending messages exceeds %d", r.Pending, t.MaxPending))
+	}
+	if t.MinFreeDisk > 0 && r.FreeDisk < t.MinFreeDisk {
+		r.Reasons = append(r.Reasons, fmt.Sprintf("%.1f%% of the disk is free, below %.1f%%", r.FreeDisk*100, t.MinFreeDisk*100))
+	}
+	r.Ready = len(r.Reasons) == 0
+
+	c.readiness.mu.Lock()
+	if c.readiness.checked && c.readiness.last.Ready != r.Ready {
+		log.Info().Bool("ready", r.Ready).Strs("reasons", r.Reasons).Msg("readiness changed")
+	}
+	c.readiness.last = r
+	c.readiness.checked = true
+	c.readiness.mu.Unlock()
+	return r
+}
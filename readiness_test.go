@@ -0,0 +1,116 @@
+package requeue
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyWindow(t *testing.T) {
+	var w latencyWindow
+	assert.Equal(t, time.Duration(0), w.reset())
+	w.record(time.Second)
+	w.record(3 * time.Second)
+	assert.Equal(t, 2*time.Second, w.reset())
+	// Nothing committed since the last reset isn't latency.
+	assert.Equal(t, time.Duration(0), w.reset())
+}
+
+func TestReadiness(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readiness-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	assert.NoError(t, ReadinessChecks(ReadinessThresholds{
+		MaxCommitLatency: time.Second,
+		MaxPending:       1,
+	})(&o))
+	c := NewConn(o)
+	defer c.Close()
+
+	r := c.Readiness()
+	assert.True(t, r.Ready)
+	assert.Empty(t, r.Reasons)
+	assert.True(t, r.FreeDisk > 0 && r.FreeDisk <= 1)
+
+	c.commitLatency.record(2 * time.Second)
+	c.natsMsgCh <- &nats.Msg{}
+	c.natsMsgCh <- &nats.Msg{}
+	r = c.checkReadiness()
+	assert.False(t, r.Ready)
+	assert.Equal(t, 2*time.Second, r.CommitLatency)
+	assert.Equal(t, 2, r.Pending)
+	assert.Len(t, r.Reasons, 2)
+
+	srv := httptest.NewServer(c.adminHandler())
+	defer srv.Close()
+	res, err := http.Get(srv.URL + "/readyz")
+	if assert.NoError(t, err) {
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+		body, err := ioutil.ReadAll(res.Body)
+		assert.NoError(t, err)
+		var got protocol.Readiness
+		assert.NoError(t, got.UnmarshalBinary(body))
+		assert.False(t, got.Ready)
+	}
+
+	// Recovers once the messages are taken and committed in time.
+	<-c.natsMsgCh
+	<-c.natsMsgCh
+	c.commitLatency.record(time.Millisecond)
+	r = c.checkReadiness()
+	assert.True(t, r.Ready)
+}
+
+func TestReadinessInMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readiness-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// However the store ends up in memory, there's no disk to check, even
+	// with a data dir.
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	assert.NoError(t, BadgerOptions(func(bo badger.Options) badger.Options {
+		return bo.WithInMemory(true)
+	})(&o))
+	c := NewConn(o)
+	defer c.Close()
+
+	r := c.Readiness()
+	assert.True(t, r.Ready)
+	assert.Equal(t, float64(1), r.FreeDisk)
+}
+
+func TestReadinessLeftQueueGroup(t *testing.T) {
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(os.TempDir())(&o))
+	c := NewConn(o)
+	defer c.Close()
+
+	assert.True(t, c.QueueGroupMember())
+	c.leftQueueGroup = true
+	assert.False(t, c.QueueGroupMember())
+	r := c.checkReadiness()
+	assert.False(t, r.Ready)
+	assert.Equal(t, []string{"left the ingest queue group"}, r.Reasons)
+
+	o.ingestDisabled = true
+	c = NewConn(o)
+	defer c.Close()
+	assert.True(t, errors.Is(c.LeaveQueueGroup(), ErrIngestDisabled))
+	assert.True(t, errors.Is(c.JoinQueueGroup(), ErrIngestDisabled))
+	assert.False(t, c.QueueGroupMember())
+}
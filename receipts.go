@@ -0,0 +1,43 @@
+package requeue
+
+import (
+	"github.com/dgraph-io/badger/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// PublishReceipts publishes a protocol.Receipt on protocol.ReceiptSubject for
+// every message once it has been republished and acknowledged.
+func PublishReceipts() Option {
+	return func(o *Options) error {
+		o.receiptsEnabled = true
+		return nil
+	}
+}
+
+// messageLeftQueue is called by the republisher with the terminal record of
+// every message it removes from a queue. It's called from the republisher's
+// write loop, which closing the republisher waits on, so it uses the nc and db
+// the republisher was started with instead of acquiring the lock.
+func (c *Conn) messageLeftQueue(nc *nats.Conn, db *badger.DB, r protocol.TerminalRecord) {
+	c.recordTerminalIn(db, r)
+	if c.Opts.ackMode == AckOnDelivery && r.State == protocol.TerminalStateDelivered {
+		c.ackDeliveredOn(nc, db, r)
+	}
+	if c.Opts.receiptsEnabled && r.State == protocol.TerminalStateDelivered {
+		c.publishReceipt(nc, r)
+	}
+}
+
+func (c *Conn) publishReceipt(nc *nats.Conn, r protocol.TerminalRecord) {
+	c.publishEventOn(nc, protocol.ReceiptSubject(r.Subject), protocol.Receipt{
+		InstanceID: c.instanceId,
+		Queue:      r.Queue,
+		MessageID:  r.MessageID,
+		Subject:    r.Subject,
+		Attempts:   r.Attempts,
+		Latency:    r.Latency,
+		Labels:     c.Opts.labels,
+		Time:       r.Time,
+	})
+}
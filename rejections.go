@@ -0,0 +1,253 @@
+package requeue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/internal/ticker"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultRejectionsRetention is how long the counts of the messages rejected
+// at ingest are kept by default.
+const DefaultRejectionsRetention = 7 * 24 * time.Hour
+
+// rejectionsFlushInterval is how often the rejections counted since the last
+// flush are persisted.
+const rejectionsFlushInterval = 10 * time.Second
+
+// RejectionsRetention sets how long the counts of the messages rejected at
+// ingest, by original subject and reason, are kept. They are counted by the
+// hour and can be queried with Conn.Rejections, on
+// protocol.RejectionsSubject, or with the admin API. Zero keeps them until the
+// store is removed.
+func RejectionsRetention(retention time.Duration) Option {
+	return func(o *Options) error {
+		if retention < 0 {
+			return fmt.Errorf("rejections retention cannot be negative: %s", retention)
+		}
+		o.rejectionsRetention = retention
+		return nil
+	}
+}
+
+type rejectionsKey struct {
+	hour    time.Time
+	subject string
+	reason  protocol.NakReason
+}
+
+// rejectionLog counts the rejections until they're persisted.
+type rejectionLog struct {
+	mu      sync.Mutex
+	pending map[rejectionsKey]*queue.Rejections
+}
+
+// add counts a message of the original subject rejected for the reason.
+func (l *rejectionLog) add(subject string, reason protocol.NakReason, message string, now time.Time) {
+	k := rejectionsKey{
+		hour:    now.Truncate(time.Hour),
+		subject: subject,
+		reason:  reason,
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending == nil {
+		l.pending = make(map[rejectionsKey]*queue.Rejections)
+	}
+	r, ok := l.pending[k]
+	if !ok {
+		r = &queue.Rejections{Hour: k.hour, Subject: subject, Reason: string(reason)}
+		l.pending[k] = r
+	}
+	r.Count++
+	r.LastMessage = message
+}
+
+// take returns the rejections counted since it was last called.
+func (l *rejectionLog) take() []queue.Rejections {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.pending) == 0 {
+		return nil
+	}
+	rs := make([]queue.Rejections, 0, len(l.pending))
+	for _, r := range l.pending {
+		rs = append(rs, *r)
+	}
+	l.pending = nil
+	return rs
+}
+
+// restore counts the rejections again after they couldn't be persisted.
+func (l *rejectionLog) restore(rs []queue.Rejections) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending == nil {
+		l.pending = make(map[rejectionsKey]*queue.Rejections, len(rs))
+	}
+	for _, r := range rs {
+		k := rejectionsKey{hour: r.Hour, subject: r.Subject, reason: protocol.NakReason(r.Reason)}
+		if p, ok := l.pending[k]; ok {
+			// The message of the later rejection is kept.
+			p.Count += r.Count
+			continue
+		}
+		r := r
+		l.pending[k] = &r
+	}
+}
+
+// recordRejection counts the message rejected at ingest for the reason.
+func (c *Conn) recordRejection(msg *nats.Msg, reason protocol.NakReason, message string) {
+	c.rejections.add(rejectedSubject(msg), reason, message, time.Now())
+}
+
+// rejectedSubject returns the original subject of the rejected message, or the
+// subject it was sent on if the message can't be read, e.g., it was rejected
+// for being malformed.
+func rejectedSubject(msg *nats.Msg) (subject string) {
+	subject = msg.Subject
+	defer func() {
+		if r := recover(); r != nil {
+			subject = msg.Subject
+		}
+	}()
+	if fb := flatbuf.GetRootAsRequeueMessage(msg.Data, 0); len(fb.OriginalSubject()) > 0 {
+		subject = string(fb.OriginalSubject())
+	}
+	return subject
+}
+
+func (c *Conn) initRejections() error {
+	c.closers.rejections.AddRunning(1)
+	go func() {
+		defer c.closers.rejections.Done()
+		t := ticker.New(rejectionsFlushInterval)
+		go func() {
+			<-c.closers.rejections.HasBeenClosed()
+			t.Stop()
+		}()
+		t.Loop(func() bool {
+			c.flushRejections()
+			return true
+		})
+		// Persist what was counted since the last tick.
+		c.flushRejections()
+	}()
+
+	return nil
+}
+
+// flushRejections persists the rejections counted since the last flush. They
+// are counted again if they can't be, so they're persisted with the next
+// flush.
+func (c *Conn) flushRejections() {
+	rs := c.rejections.take()
+	if len(rs) == 0 {
+		return
+	}
+
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if db == nil {
+		return
+	}
+
+	if err := queue.AddRejections(db, rs, c.Opts.rejectionsRetention); err != nil {
+		log.Err(err).Msg("problem persisting rejections")
+		c.rejections.restore(rs)
+	}
+}
+
+// Rejections reports the messages rejected at ingest by original subject and
+// reason, so producers can find out why their messages are being rejected.
+// The rejections counted since the last flush are persisted first.
+func (c *Conn) Rejections(req protocol.RejectionsRequest) (protocol.RejectionsReport, error) {
+	now := time.Now()
+	since := req.Since
+	if since.IsZero() {
+		since = now.Add(-protocol.DefaultRejectionsWindow)
+	}
+	report := protocol.RejectionsReport{
+		InstanceID: c.instanceId,
+		Subject:    req.Subject,
+		Since:      since,
+		Rejections: make([]protocol.SubjectRejections, 0),
+		Time:       now,
+	}
+	if req.Subject != "" {
+		if err := protocol.ValidateSubject(req.Subject); err != nil {
+			return report, fmt.Errorf("rejections: %w", err)
+		}
+	}
+
+	c.flushRejections()
+
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if db == nil {
+		return report, fmt.Errorf("rejections: store is not open")
+	}
+
+	err := queue.RangeRejections(db, since, func(r queue.Rejections) bool {
+		if req.Subject != "" && !protocol.MatchSubject(req.Subject, r.Subject) {
+			return true
+		}
+		report.Rejections = append(report.Rejections, protocol.SubjectRejections{
+			Subject:     r.Subject,
+			Reason:      protocol.NakReason(r.Reason),
+			Hour:        r.Hour,
+			Count:       r.Count,
+			LastMessage: r.LastMessage,
+		})
+		if report.Rejected == nil {
+			report.Rejected = make(protocol.ReasonCounts)
+		}
+		report.Rejected[r.Reason] += r.Count
+		return true
+	})
+	if err != nil {
+		return report, fmt.Errorf("rejections: %w", err)
+	}
+	return report, nil
+}
+
+func (c *Conn) handleRejectionsRequest(msg *nats.Msg) {
+	req := protocol.RejectionsRequest{}
+	var report protocol.RejectionsReport
+	if len(msg.Data) > 0 {
+		if err := req.UnmarshalBinary(msg.Data); err != nil {
+			report = protocol.RejectionsReport{
+				InstanceID: c.instanceId,
+				Error:      fmt.Sprintf("invalid rejections request: %s", err),
+				Time:       time.Now(),
+			}
+			c.respondRejections(msg, report)
+			return
+		}
+	}
+	report, err := c.Rejections(req)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	c.respondRejections(msg, report)
+}
+
+func (c *Conn) respondRejections(msg *nats.Msg, report protocol.RejectionsReport) {
+	data, err := report.MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("unable to marshal rejections report")
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Err(err).Msg("unable to respond to rejections request")
+	}
+}
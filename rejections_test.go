@@ -0,0 +1,71 @@
+package requeue
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRejections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rejections-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+
+	rejected := func(subject string) *nats.Msg {
+		m := protocol.DefaultRequeueMessage()
+		m.OriginalSubject = subject
+		return &nats.Msg{Subject: "requeue.in", Data: m.Bytes()}
+	}
+	c.nak(rejected("orders.created"), protocol.NakReasonInvalidPayload, "missing id")
+	c.nak(rejected("orders.created"), protocol.NakReasonInvalidPayload, "missing name")
+	c.nak(rejected("orders.updated"), protocol.NakReasonQuotaExceeded, "queue quota exceeded")
+	c.nak(rejected("emails.sent"), protocol.NakReasonSubjectDenied, "denied")
+	// Messages without an original subject are counted by the subject they
+	// were sent on.
+	c.nak(&nats.Msg{Subject: "requeue.in", Data: []byte("garbage")}, protocol.NakReasonPayloadTooLarge, "too large")
+
+	report, err := c.Rejections(protocol.RejectionsRequest{Subject: "orders.*"})
+	assert.NoError(t, err)
+	assert.Equal(t, "orders.*", report.Subject)
+	if assert.Len(t, report.Rejections, 2) {
+		assert.Equal(t, "orders.created", report.Rejections[0].Subject)
+		assert.Equal(t, protocol.NakReasonInvalidPayload, report.Rejections[0].Reason)
+		assert.Equal(t, int64(2), report.Rejections[0].Count)
+		assert.Equal(t, "missing name", report.Rejections[0].LastMessage)
+		assert.Equal(t, "orders.updated", report.Rejections[1].Subject)
+	}
+	assert.Equal(t, protocol.ReasonCounts{
+		string(protocol.NakReasonInvalidPayload): 2,
+		string(protocol.NakReasonQuotaExceeded):  1,
+	}, report.Rejected)
+
+	// The counts are persisted so they add up across flushes.
+	c.nak(rejected("orders.created"), protocol.NakReasonInvalidPayload, "missing id")
+	report, err = c.Rejections(protocol.RejectionsRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, report.Rejections, 4)
+	assert.Equal(t, int64(6), report.Rejected[string(protocol.NakReasonInvalidPayload)]+
+		report.Rejected[string(protocol.NakReasonQuotaExceeded)]+
+		report.Rejected[string(protocol.NakReasonSubjectDenied)]+
+		report.Rejected[string(protocol.NakReasonPayloadTooLarge)])
+	assert.Equal(t, int64(3), report.Rejected[string(protocol.NakReasonInvalidPayload)])
+
+	// Nothing was rejected in the future.
+	report, err = c.Rejections(protocol.RejectionsRequest{Since: time.Now().Add(2 * time.Hour)})
+	assert.NoError(t, err)
+	assert.Empty(t, report.Rejections)
+
+	_, err = c.Rejections(protocol.RejectionsRequest{Subject: "orders..created"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package requeue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// ReplayOptions limit the messages replayed by ReplayQueue.
+type ReplayOptions struct {
+	// From and To are the keys of the first and last messages to replay, as
+	// given by PeekMessage. The range is open at either end when they're
+	// empty.
+	From string
+	To   string
+	// Since and Until limit the replay to the sub-queues of a time bucketed
+	// queue whose time slice overlaps the window, i.e., to the messages
+	// ingested in it, without walking the rest of the queue. Until is now
+	// when it's zero. There is no window when Since is zero.
+	Since time.Time
+	Until time.Time
+}
+
+// keys parses the range of message keys. A nil key leaves the range open at
+// that end.
+func (o ReplayOptions) keys() (from, to key.Key, err error) {
+	if o.From != "" {
+		if from, err = key.Parse(o.From); err != nil {
+			return nil, nil, fmt.Errorf("from: %w", err)
+		}
+	}
+	if o.To != "" {
+		if to, err = key.Parse(o.To); err != nil {
+			return nil, nil, fmt.Errorf("to: %w", err)
+		}
+	}
+	if from != nil && to != nil && key.Compare(from, to) > 0 {
+		return nil, nil, fmt.Errorf("from %s is after to %s", o.From, o.To)
+	}
+	return from, to, nil
+}
+
+// window returns the names of the sub-queues of the queue that cover the
+// window, or nil if there is no window.
+func (o ReplayOptions) window(b TimeBucket, queueName string) (map[string]bool, error) {
+	if o.Since.IsZero() {
+		if !o.Until.IsZero() {
+			return nil, fmt.Errorf("until needs a since")
+		}
+		return nil, nil
+	}
+	if b == TimeBucketNone {
+		return nil, fmt.Errorf("replaying a window needs time bucketed queues")
+	}
+	until := o.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+	if o.Since.After(until) {
+		return nil, fmt.Errorf("since %s is after until %s", o.Since, until)
+	}
+	names := make(map[string]bool)
+	for _, name := range b.QueueNames(queueName, o.Since, until) {
+		names[name] = true
+	}
+	return names, nil
+}
+
+// ReplayQueue makes the messages of the queue, or those in the range of the
+// options, ready to be republished right away regardless of their delay or
+// backoff, e.g., to recover once consumers that were down are back. Naming a
+// queue includes the sub-queues of a time bucketed queue. The messages keep
+// their order, and their retries and attempts are left as they are. With a
+// window in the options only the sub-queues covering it are replayed. A message
+// that is in flight when it's replayed may be delivered twice. Its progress is
+// reported by Operations, and it can be stopped part way with CancelOperation.
+func (c *Conn) ReplayQueue(ctx context.Context, queueName string, opts ReplayOptions) error {
+	_, err := c.replayQueue(ctx, queueName, opts)
+	return err
+}
+
+// replayQueue is ReplayQueue returning the operation reporting its progress,
+// or nil if it couldn't be started.
+func (c *Conn) replayQueue(ctx context.Context, queueName string, opts ReplayOptions) (*operation, error) {
+	from, to, err := opts.keys()
+	if err != nil {
+		return nil, fmt.Errorf("replay queue: %w", err)
+	}
+	window, err := opts.window(c.Opts.timeBucket, queueName)
+	if err != nil {
+		return nil, fmt.Errorf("replay queue: %w", err)
+	}
+
+	c.mu.RLock()
+	db := c.badgerDB
+	qManager := c.qManager
+	c.mu.RUnlock()
+	if db == nil || qManager == nil {
+		return nil, fmt.Errorf("replay queue: queue manager is not running")
+	}
+
+	names := make([]string, 0)
+	for _, q := range qManager.Queues() {
+		if window != nil {
+			if window[q.Name()] {
+				names = append(names, q.Name())
+			}
+			continue
+		}
+		base, _ := queue.SplitBucketName(q.Name())
+		if base == queueName || q.Name() == queueName {
+			names = append(names, q.Name())
+		}
+	}
+	if len(names) == 0 && window != nil {
+		return nil, fmt.Errorf("replay queue: no sub-queues of %q in the window", queueName)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("replay queue: no such queue: %q", queueName)
+	}
+
+	op := c.operations.start(ctx, protocol.OperationReplay, queueName)
+	now := time.Now()
+	var replayed, total int
+	for _, name := range names {
+		prevReplayed, prevTotal := replayed, total
+		n, rErr := queue.ReplayMessages(op.ctx, db, name, from, to, now, func(done, t int) {
+			total = prevTotal + t
+			op.progress(prevReplayed+done, total)
+		})
+		replayed += n
+		if rErr != nil {
+			err = rErr
+			break
+		}
+	}
+	op.finish(err)
+	if replayed > 0 {
+		c.wakeRepublisher(now)
+	}
+	log.Info().Str("queue", queueName).Str("operation", op.id()).Msgf("replayed %d messages", replayed)
+	if err != nil {
+		return op, fmt.Errorf("replay queue: %w", err)
+	}
+	return op, nil
+}
+
+func (c *Conn) handleReplayRequest(msg *nats.Msg) {
+	req := protocol.ReplayRequest{}
+	reply := protocol.ReplayReply{InstanceID: c.instanceId}
+	if err := req.UnmarshalBinary(msg.Data); err != nil {
+		reply.Error = fmt.Sprintf("invalid replay request: %s", err)
+	} else {
+		reply.Queue = req.Queue
+		op, err := c.replayQueue(c.Opts.ctx, req.Queue, ReplayOptions{
+			From:  req.From,
+			To:    req.To,
+			Since: req.Since,
+			Until: req.Until,
+		})
+		if err != nil {
+			reply.Error = err.Error()
+		}
+		if op != nil {
+			p := op.get()
+			reply.Operation = &p
+		}
+	}
+	reply.Time = time.Now()
+
+	data, err := reply.MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("unable to marshal replay reply")
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Err(err).Msg("unable to respond to replay request")
+	}
+}
@@ -0,0 +1,121 @@
+package requeue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	assert.NoError(t, c.initQueueManager())
+	assert.True(t, c.Features().Supports(protocol.FeatureReplay))
+
+	ctx := context.Background()
+	assert.Error(t, c.ReplayQueue(ctx, "orders", ReplayOptions{}), "no such queue")
+
+	_, err = c.qManager.CreateQueue(queue.NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+	now := time.Now()
+	keys := make([]key.Key, 3)
+	m := protocol.DefaultRequeueMessage()
+	assert.NoError(t, c.badgerDB.Update(func(txn *badger.Txn) error {
+		for i := range keys {
+			keys[i] = key.New(now.Add(time.Duration(i+1) * time.Hour))
+			if err := txn.Set(queue.NewQueueKeyForMessage("orders", keys[i]).Bytes(), m.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	delayed := func() int {
+		var n int
+		assert.NoError(t, c.badgerDB.View(func(txn *badger.Txn) error {
+			prefix := queue.NewQueueKeyForMessage("orders", nil).NamePrefixBytes()
+			it := txn.NewIterator(badger.DefaultIteratorOptions)
+			defer it.Close()
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				if key.TimeOf(queue.ParseQueueKey(it.Item().Key()).Key).After(now) {
+					n++
+				}
+			}
+			return nil
+		}))
+		return n
+	}
+
+	assert.Error(t, c.ReplayQueue(ctx, "orders", ReplayOptions{From: keys[1].String(), To: keys[0].String()}))
+	assert.Error(t, c.ReplayQueue(ctx, "orders", ReplayOptions{From: "nope"}))
+
+	assert.NoError(t, c.ReplayQueue(ctx, "orders", ReplayOptions{To: keys[1].String()}))
+	assert.Equal(t, 1, delayed())
+	assert.NoError(t, c.ReplayQueue(ctx, "orders", ReplayOptions{}))
+	assert.Equal(t, 0, delayed())
+
+	ops := c.Operations()
+	if assert.Len(t, ops, 2) {
+		assert.Equal(t, protocol.OperationReplay, ops[0].Kind)
+		assert.Equal(t, protocol.OperationDone, ops[0].State)
+		assert.Equal(t, int64(2), ops[0].Done)
+		assert.Equal(t, int64(1), ops[1].Done)
+	}
+}
+
+func TestReplayQueueWindow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := GetDefaultOptions()
+	assert.NoError(t, DataDir(dir)(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	assert.NoError(t, c.initQueueManager())
+
+	ctx := context.Background()
+	now := time.Now()
+	window := ReplayOptions{Since: now.Add(-2 * time.Hour), Until: now.Add(-2 * time.Hour)}
+	assert.Error(t, c.ReplayQueue(ctx, "orders", window), "not time bucketed")
+
+	c.Opts.timeBucket = TimeBucketHourly
+	assert.Error(t, c.ReplayQueue(ctx, "orders", ReplayOptions{Until: now}))
+	assert.Error(t, c.ReplayQueue(ctx, "orders", ReplayOptions{Since: now, Until: now.Add(-time.Hour)}))
+	assert.Error(t, c.ReplayQueue(ctx, "orders", window), "no sub-queues")
+
+	// A message delayed an hour in each of the last three hourly sub-queues.
+	m := protocol.DefaultRequeueMessage()
+	for i := 1; i <= 3; i++ {
+		name := TimeBucketHourly.QueueName("orders", now.Add(-time.Duration(i)*time.Hour))
+		_, err := c.qManager.CreateQueue(queue.NewQueueKeyForState(name, ""))
+		assert.NoError(t, err)
+		assert.NoError(t, c.badgerDB.Update(func(txn *badger.Txn) error {
+			return txn.Set(queue.NewQueueKeyForMessage(name, key.New(now.Add(time.Hour))).Bytes(), m.Bytes())
+		}))
+	}
+
+	assert.NoError(t, c.ReplayQueue(ctx, "orders", window))
+	assert.NoError(t, c.ReplayQueue(ctx, "orders", ReplayOptions{Since: now.Add(-time.Hour)}))
+
+	ops := c.Operations()
+	if assert.Len(t, ops, 2) {
+		assert.Equal(t, int64(1), ops[0].Done)
+		assert.Equal(t, int64(1), ops[1].Done)
+	}
+}
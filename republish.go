@@ -0,0 +1,254 @@
+package requeue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/internal/republisher"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// DisableIngest connects without subscribing to the ingest subject, e.g., for
+// a dedicated node that only drains the queues.
+func DisableIngest() Option {
+	return func(o *Options) error {
+		o.ingestDisabled = true
+		return nil
+	}
+}
+
+// DisableRepublish connects without republishing messages. Messages are still
+// ingested and can be republished later with Conn.StartRepublishing.
+func DisableRepublish() Option {
+	return func(o *Options) error {
+		o.republishDisabled = true
+		return nil
+	}
+}
+
+// QueuePriority is how the messages of a queue are republished relative to the
+// messages of the other queues when they're ready at the same time.
+type QueuePriority = republisher.QueuePriority
+
+// QueuePriorities sets the priorities of the queues by name. The ready
+// messages of the queues in a higher Tier are always republished before those
+// in a lower one, e.g., so the retries in a "high" queue go out before the
+// backfill in a "low" one. Queues in the same tier share the republisher in
+// proportion to their Weight. Queues without a priority are in tier 0 with a
+// weight of 1. Without priorities every queue is read from as fast as it can
+// be.
+func QueuePriorities(priorities map[string]QueuePriority) Option {
+	return func(o *Options) error {
+		for name, p := range priorities {
+			if name == "" {
+				return fmt.Errorf("queue priorities: queue name cannot be blank")
+			}
+			if p.Weight < 0 {
+				return fmt.Errorf("queue priorities: %s: weight cannot be negative: %d", name, p.Weight)
+			}
+		}
+		if o.queuePriorities == nil {
+			o.queuePriorities = make(map[string]QueuePriority, len(priorities))
+		}
+		for name, p := range priorities {
+			o.queuePriorities[name] = p
+		}
+		return nil
+	}
+}
+
+// AdaptiveFlowControl adapts the number of republished messages in flight to
+// the capacity of the downstream responders. The limit starts at one and grows
+// while acks come back within target, and is halved when one is slower or the
+// request fails, so a struggling consumer isn't flooded with retries.
+func AdaptiveFlowControl(target time.Duration) Option {
+	return republisherOption(republisher.AdaptiveFlowControl(target))
+}
+
+// QueueAckTimeout sets how long the messages in the named queue wait to be
+// acknowledged when they're republished, e.g., a short timeout for a fast API
+// and a long one for a slow batch system. Messages can still override it with
+// their own ack_timeout.
+func QueueAckTimeout(name string, timeout time.Duration) Option {
+	return republisherOption(republisher.QueueAckTimeout(name, timeout))
+}
+
+// MaxRedeliveries caps the number of times a message is republished,
+// regardless of the number of retries its producer set on it, so messages
+// configured to retry forever can't fill the store. Messages that reach it are
+// dead lettered. Zero, the default, means no limit.
+func MaxRedeliveries(n uint64) Option {
+	return republisherOption(republisher.MaxRedeliveries(n))
+}
+
+// QueueMaxRedeliveries overrides MaxRedeliveries for the named queue.
+func QueueMaxRedeliveries(name string, n uint64) Option {
+	return republisherOption(republisher.QueueMaxRedeliveries(name, n))
+}
+
+// QueueMirrors republishes every message in the named queue to the subjects as
+// well as its original subject, e.g., to dual write during a migration. Each
+// subject must acknowledge the message independently, and when some don't,
+// the message is retried on only those.
+func QueueMirrors(name string, subjects ...string) Option {
+	return republisherOption(republisher.QueueMirrors(name, subjects...))
+}
+
+// RescanOnStart reads every queue from the start when republishing starts,
+// rather than resuming from the checkpoint saved when it last ran, e.g., after
+// restoring a backup or if the checkpoints are suspected to be wrong. Messages
+// that aren't ready yet are still left alone.
+func RescanOnStart() Option {
+	return republisherOption(republisher.RescanOnStart())
+}
+
+// republisherOption validates opt up front, so a bad one fails Connect along
+// with the rest of the options, and adds it to the RepublisherOptions.
+func republisherOption(opt republisher.Option) Option {
+	return func(o *Options) error {
+		ro := republisher.GetDefaultOptions()
+		if err := opt(&ro); err != nil {
+			return err
+		}
+		o.republisherOpts = append(o.republisherOpts, opt)
+		return nil
+	}
+}
+
+func (c *Conn) initRepublisher() error {
+	if c.Opts.republishDisabled {
+		return nil
+	}
+	return c.StartRepublishing()
+}
+
+// Manager returns the manager of the queues in the store.
+func (c *Conn) Manager() *queue.Manager {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.qManager
+}
+
+// StartRepublishing starts republishing messages as they become ready. It's a
+// no-op if they're already being republished.
+func (c *Conn) StartRepublishing() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.closers.natsProducers.HasBeenClosed():
+		return fmt.Errorf("start republishing: connection is closed")
+	default:
+	}
+	if c.republisher != nil {
+		return nil
+	}
+	if c.qManager == nil {
+		return fmt.Errorf("start republishing: queue manager is not running")
+	}
+
+	nc := c.egressNC
+	if nc == nil {
+		nc = c.nc
+	}
+	rp, err := republisher.New(nc, c.badgerDB, c.qManager, c.republisherOptions()...)
+	if err != nil {
+		return fmt.Errorf("start republishing: %w", err)
+	}
+	c.republisher = rp
+	return nil
+}
+
+// StopRepublishing stops republishing messages and waits for any in flight to
+// finish. Messages continue to be ingested.
+func (c *Conn) StopRepublishing() {
+	// Closing waits on the write loop, which calls back into the connection,
+	// so it mustn't be done with the lock held.
+	c.mu.Lock()
+	rp := c.republisher
+	c.republisher = nil
+	c.mu.Unlock()
+
+	if rp != nil {
+		rp.Close()
+	}
+}
+
+// wakeRepublisher lets the republisher know a message that becomes ready at
+// readyAt was committed, so it can wake up for it instead of waiting for its
+// next scan.
+func (c *Conn) wakeRepublisher(readyAt time.Time) {
+	c.mu.RLock()
+	rp := c.republisher
+	c.mu.RUnlock()
+	if rp != nil {
+		rp.Notify(readyAt)
+	}
+}
+
+// IsRepublishing returns true if messages are being republished.
+func (c *Conn) IsRepublishing() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.republisher != nil
+}
+
+// republisherOptions returns the options for the republisher, with the
+// defaults derived from our own options first so they can be overridden.
+// Should be called with the lock acquired.
+func (c *Conn) republisherOptions() []republisher.Option {
+	// The handlers run on the republisher's write loop, which closing it
+	// waits on, so they're given what they need up front rather than
+	// acquiring the lock.
+	nc, db := c.nc, c.badgerDB
+	opts := make([]republisher.Option, 0, len(c.Opts.republisherOpts)+15)
+	opts = append(opts,
+		republisher.RepublishedHandler(c.counters.AddRepublished),
+		republisher.EmitRevision(c.Revision),
+	)
+	if c.Opts.terminalRetention > 0 || c.Opts.receiptsEnabled || c.Opts.ackMode == AckOnDelivery {
+		opts = append(opts, republisher.TerminalHandler(func(r protocol.TerminalRecord) {
+			c.messageLeftQueue(nc, db, r)
+		}))
+	}
+	if c.Opts.deadLetterQueue {
+		opts = append(opts, republisher.DeadLetterQueue(c.Opts.deadLetterRetention))
+	}
+	if c.Opts.deadLetterCB != nil || c.Opts.deadLettersPublished {
+		opts = append(opts, republisher.DeadLetterHandler(func(dl protocol.DeadLetter) {
+			c.messageDeadLettered(nc, dl)
+		}))
+	}
+	if c.Opts.defaultBackoff != nil {
+		opts = append(opts, republisher.DefaultBackoff(c.Opts.defaultBackoff))
+	}
+	if c.Opts.maxBackoff > 0 {
+		opts = append(opts, republisher.MaxBackoff(c.Opts.maxBackoff))
+	}
+	if c.Opts.storageTTL {
+		opts = append(opts, republisher.StorageTTL(c.Opts.storageTTLGrace))
+	}
+	if c.Opts.deliveryPrecision > 0 {
+		opts = append(opts, republisher.Precision(c.Opts.deliveryPrecision))
+	}
+	if c.Opts.payloadEncrypter != nil && !c.Opts.republishEncrypted {
+		opts = append(opts, republisher.PayloadDecrypter(c.Opts.payloadEncrypter))
+	}
+	if c.Opts.payloadKeyring != nil && !c.Opts.republishEncrypted {
+		opts = append(opts, republisher.PayloadKeyring(c.Opts.payloadKeyring))
+	}
+	if c.Opts.traceHeaders {
+		opts = append(opts, republisher.TraceHeaders(c.instanceId))
+	}
+	if c.Opts.queueGroupHeaders {
+		opts = append(opts, republisher.QueueGroupHeaders())
+	}
+	if len(c.Opts.queuePriorities) > 0 {
+		opts = append(opts, republisher.QueuePriorities(c.Opts.queuePriorities))
+	}
+	if len(c.Opts.subjectTranslations) > 0 {
+		opts = append(opts, republisher.SubjectTranslations(c.Opts.subjectTranslations))
+	}
+	return append(opts, c.Opts.republisherOpts...)
+}
@@ -0,0 +1,84 @@
+package requeue
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueuePriorities(t *testing.T) {
+	n := len(NewConn(GetDefaultOptions()).republisherOptions())
+
+	o := GetDefaultOptions()
+	assert.NoError(t, QueuePriorities(map[string]QueuePriority{"high": {Tier: 1}})(&o))
+	assert.NoError(t, QueuePriorities(map[string]QueuePriority{"low": {Weight: 2}})(&o))
+	assert.Equal(t, map[string]QueuePriority{
+		"high": {Tier: 1},
+		"low":  {Weight: 2},
+	}, o.queuePriorities)
+	assert.Len(t, NewConn(o).republisherOptions(), n+1)
+
+	assert.Error(t, QueuePriorities(map[string]QueuePriority{"": {}})(&o))
+	assert.Error(t, QueuePriorities(map[string]QueuePriority{"low": {Weight: -1}})(&o))
+}
+
+func TestStopRepublishingWithDeadLetterInFlight(t *testing.T) {
+	o := GetDefaultOptions()
+	assert.NoError(t, Storage(StorageMemory)(&o))
+	assert.NoError(t, DeadLetterQueue(time.Hour)(&o))
+	assert.NoError(t, PublishDeadLetters()(&o))
+	assert.NoError(t, RetainTerminalRecords(time.Hour)(&o))
+	handled := make(chan protocol.DeadLetter, 1)
+	assert.NoError(t, DeadLetterHandler(func(dl protocol.DeadLetter) {
+		handled <- dl
+	})(&o))
+	c := NewConn(o)
+	defer c.Close()
+	assert.NoError(t, c.initBadger())
+	assert.NoError(t, c.initQueueManager())
+
+	// Without a nats connection the request fails and the message, which has
+	// no retries left, is dead lettered.
+	_, err := c.qManager.CreateQueue(queue.NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+	m := protocol.DefaultRequeueMessage()
+	m.OriginalSubject = "orders.created"
+	m.Retries = 1
+	k := key.New(time.Now())
+	assert.NoError(t, c.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(queue.NewQueueKeyForMessage("orders", k).Bytes(), m.Bytes())
+	}))
+	assert.NoError(t, c.StartRepublishing())
+
+	// The handlers run on the write loop while the lock is held elsewhere,
+	// e.g., by a closer, so they must get by without it.
+	c.mu.Lock()
+	select {
+	case dl := <-handled:
+		assert.Equal(t, k.String(), dl.Key)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the dead letter handler")
+	}
+	assert.Eventually(t, func() bool {
+		_, ok, err := queue.GetTerminalRecord(c.badgerDB, "orders", k)
+		return err == nil && ok
+	}, 5*time.Second, 10*time.Millisecond)
+	c.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		c.StopRepublishing()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out stopping the republisher")
+	}
+	assert.False(t, c.IsRepublishing())
+}
@@ -3,10 +3,15 @@ package requeue
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,10 +21,12 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/nickpoorman/nats-requeue/flatbuf"
 	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+	"github.com/nickpoorman/nats-requeue/internal/hooks"
 	"github.com/nickpoorman/nats-requeue/internal/key"
 	"github.com/nickpoorman/nats-requeue/internal/queue"
 	"github.com/nickpoorman/nats-requeue/internal/reaper"
 	"github.com/nickpoorman/nats-requeue/internal/republisher"
+	"github.com/nickpoorman/nats-requeue/internal/statspub"
 	"github.com/nickpoorman/nats-requeue/protocol"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -53,15 +60,25 @@ const (
 	DefaultNumConcurrentBatchTransactions = 4
 )
 
+// Connect applies the options and connects. If any of the options are
+// invalid an *OptionsError listing every problem is returned.
 func Connect(options ...Option) (*Conn, error) {
 	opts := GetDefaultOptions()
+	errs := make([]error, 0)
 	for _, opt := range options {
 		if opt != nil {
 			if err := opt(&opts); err != nil {
-				return nil, err
+				errs = append(errs, err)
 			}
 		}
 	}
+	if len(errs) > 0 {
+		// Report the problems with the resulting options too.
+		if err, ok := opts.Validate().(*OptionsError); ok {
+			errs = append(errs, err.Errs...)
+		}
+		return nil, &OptionsError{Errs: errs}
+	}
 	return opts.Connect()
 }
 
@@ -114,6 +131,20 @@ func NATSOptions(natsOptions []nats.Option) Option {
 	}
 }
 
+// NATSDrainTimeout is the amount of time to wait for the nats connection to
+// drain when closing. If draining doesn't complete in time the connection is
+// closed without waiting any further. The outcome is reported as a ConnEvent.
+func NATSDrainTimeout(timeout time.Duration) Option {
+	return func(o *Options) error {
+		if timeout <= 0 {
+			return fmt.Errorf("nats drain timeout must be positive: %s", timeout)
+		}
+		o.natsDrainTimeout = timeout
+		o.natsOptions = append(o.natsOptions, nats.DrainTimeout(timeout))
+		return nil
+	}
+}
+
 // NATSConnectionError is a callback when the connection is unable to be
 // established.
 func NATSConnectionError(connErrCb func(*Conn, error)) Option {
@@ -126,7 +157,8 @@ func NATSConnectionError(connErrCb func(*Conn, error)) Option {
 // DataDir is the directory where data will be stored. An instance of the data
 // store will be created in this directory. This directory is also looped over
 // by the reaper looking for zombie instances that need to be merged into the
-// main data instance created at initialization.
+// main data instance created at initialization. It isn't needed when the
+// Storage is StorageMemory.
 func DataDir(path string) Option {
 	return func(o *Options) error {
 		o.dataDir = path
@@ -134,6 +166,16 @@ func DataDir(path string) Option {
 	}
 }
 
+// SyncWrites sets whether writes are synced to disk before messages are
+// committed. Not syncing is faster but the most recently committed messages
+// can be lost if the machine crashes. Writes are synced by default.
+func SyncWrites(sync bool) Option {
+	return func(o *Options) error {
+		o.syncWrites = sync
+		return nil
+	}
+}
+
 // BadgerWriteMsgErr sets the callback to be triggered when there is an error
 // writing a message to Badger.
 func BadgerWriteMsgErr(cb func(*nats.Msg, error)) Option {
@@ -143,6 +185,19 @@ func BadgerWriteMsgErr(cb func(*nats.Msg, error)) Option {
 	}
 }
 
+// BadgerOptions sets a function that is given the options the store is opened
+// with, once the others such as SyncWrites and TableCompression have been
+// applied, and returns them tuned, e.g., to change the ValueLogFileSize or
+// NumVersionsToKeep. The directories are always those of the instance. An
+// in-memory store loses every message when the instance stops, and other
+// instances aren't reaped into it.
+func BadgerOptions(fn func(badger.Options) badger.Options) Option {
+	return func(o *Options) error {
+		o.badgerOptions = fn
+		return nil
+	}
+}
+
 // RepublisherOpts sets the options for the republisher.
 func RepublisherOptions(options ...republisher.Option) Option {
 	return func(o *Options) error {
@@ -159,11 +214,172 @@ func ReaperOptions(options ...reaper.Option) Option {
 	}
 }
 
+// InstanceID sets the id of the instance instead of generating a random one.
+// The id names the instance directory under the data dir and is used in the
+// stats and event subjects, so it must be a single subject token.
+func InstanceID(id string) Option {
+	return func(o *Options) error {
+		if id == "" || strings.ContainsAny(id, ".*> \t\r\n/\\") {
+			return fmt.Errorf("invalid instance id: %q", id)
+		}
+		o.instanceId = id
+		return nil
+	}
+}
+
+// Labels sets static labels, e.g., region, environment, or team, that are
+// included in every event payload to tell instances apart in
+// multi-environment fleets.
+func Labels(labels map[string]string) Option {
+	return func(o *Options) error {
+		o.labels = labels
+		return nil
+	}
+}
+
+// MaxPayloadSize is the largest message, in bytes, that will be accepted at
+// ingest. Larger messages are rejected with a protocol.Nak so they never reach
+// the store or the republisher. Zero, the default, means no limit.
+func MaxPayloadSize(size int) Option {
+	return func(o *Options) error {
+		if size < 0 {
+			return fmt.Errorf("max payload size cannot be negative: %d", size)
+		}
+		o.maxPayloadSize = size
+		return nil
+	}
+}
+
+// NumConsumers sets the number of consumers concurrently pulling messages off
+// the subscription and writing them to the store.
+func NumConsumers(n int) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return fmt.Errorf("number of consumers must be positive: %d", n)
+		}
+		o.numConsumers = n
+		return nil
+	}
+}
+
+// BatchMaxWait is the longest an ingested message waits to be committed with
+// others in a batch. Longer waits make for bigger, more efficient commits at
+// the cost of latency.
+func BatchMaxWait(d time.Duration) Option {
+	return func(o *Options) error {
+		if d <= 0 {
+			return fmt.Errorf("batch max wait must be positive: %s", d)
+		}
+		o.batchMaxWait = d
+		return nil
+	}
+}
+
+// BatchMaxSize is the most writes committed together in a batch. A batch is
+// committed as soon as it's full rather than waiting for the BatchMaxWait, so
+// smaller batches cut the latency under load at the cost of more commits.
+// Messages with a TTL take up two writes since their expiry is indexed in the
+// same batch. Zero, the default, doesn't bound the size of a batch.
+func BatchMaxSize(n int) Option {
+	return func(o *Options) error {
+		if n < 0 {
+			return fmt.Errorf("batch max size cannot be negative: %d", n)
+		}
+		o.batchMaxSize = n
+		return nil
+	}
+}
+
+// BatchCommitHandler sets a callback that will be triggered with every batch of
+// messages committed to a queue, with its size and how long it took, e.g., to
+// tune BatchMaxWait and BatchMaxSize on real measurements. The totals are
+// included in the stats of each queue.
+func BatchCommitHandler(cb func(protocol.BatchCommit)) Option {
+	return func(o *Options) error {
+		o.batchCommitCB = cb
+		return nil
+	}
+}
+
+// AckMode controls when ingested messages are acknowledged.
+type AckMode int
+
+const (
+	// AckOnCommit acknowledges a message once it has been committed to the
+	// store. This is the default.
+	AckOnCommit AckMode = iota
+
+	// AckOnReceive acknowledges a message as soon as it's accepted, before
+	// it's committed. Publishers get a reply sooner but an acknowledged
+	// message can be lost if the process crashes before it's committed.
+	AckOnReceive
+
+	// AckOnDelivery acknowledges a message once it has been republished and
+	// acknowledged downstream, so the producer knows it was delivered rather
+	// than only stored. The reply subject is persisted with the message, so
+	// the producer is still acknowledged if the instance restarts in the
+	// meantime, and producers must wait at least as long as the message can
+	// take to be delivered. Nothing is sent if the message is never
+	// delivered, or if it's replaced by a newer message in a coalescing
+	// queue.
+	AckOnDelivery
+)
+
+// IngestAckMode sets when ingested messages are acknowledged.
+func IngestAckMode(mode AckMode) Option {
+	return func(o *Options) error {
+		o.ackMode = mode
+		return nil
+	}
+}
+
+// EnableStats starts a stats publisher that periodically publishes the stats
+// for the instance and its queues and answers on-demand stats requests. The
+// instance labels and storage metrics are included by default.
+func EnableStats(options ...statspub.Option) Option {
+	return func(o *Options) error {
+		o.statsEnabled = true
+		o.statsOpts = append(o.statsOpts, options...)
+		return nil
+	}
+}
+
+// TimeBucket controls whether messages are sliced into time based sub-queues
+// when they are ingested.
+type TimeBucket = queue.TimeBucket
+
+const (
+	// TimeBucketNone stores all messages in the queue named in the message.
+	TimeBucketNone = queue.NoTimeBucket
+
+	// TimeBucketHourly stores messages in a sub-queue per hour,
+	// e.g., orders@2024-06-01T13.
+	TimeBucketHourly = queue.HourlyTimeBucket
+
+	// TimeBucketDaily stores messages in a sub-queue per day,
+	// e.g., orders@2024-06-01.
+	TimeBucketDaily = queue.DailyTimeBucket
+)
+
+// QueueTimeBucket sets how ingested messages are sliced into time based
+// sub-queues. This is useful for very large backlogs since dropping or
+// replaying a window of messages only has to touch the sub-queues covering it.
+func QueueTimeBucket(b TimeBucket) Option {
+	return func(o *Options) error {
+		o.timeBucket = b
+		return nil
+	}
+}
+
 // TODO: These options should probably be lower case so they are private.
 // Options can be used to create a customized Service connections.
 type Options struct {
 	ctx context.Context
 
+	// Instance
+	instanceId string
+	labels     map[string]string
+
 	// Nats
 	natsServers   string
 	natsSubject   string
@@ -171,15 +387,157 @@ type Options struct {
 	natsOptions   []nats.Option
 	natsConnErrCB func(*Conn, error)
 
+	natsDrainTimeout time.Duration
+
+	// When set, messages are pulled from the JetStream consumer instead.
+	jetStream JetStreamConsumer
+
+	// When either is set, messages are republished on a separate connection.
+	egressNatsServers string
+	egressNatsOptions []nats.Option
+
 	// Badger
 	dataDir           string
+	storage           StorageBackend
+	fallbackDataDirs  []string
+	syncWrites        bool
 	badgerWriteMsgErr func(*nats.Msg, error)
+	compression       Compression
+	zstdLevel         int
+	blockCacheSize    int64
+	badgerOptions     func(badger.Options) badger.Options
+
+	preflightMinFreeDisk float64
+
+	vlogGCInterval     time.Duration
+	vlogGCDiscardRatio float64
+
+	// Queues
+	timeBucket     TimeBucket
+	queueMinDelays map[string]time.Duration
+
+	coalescingQueues map[string]bool
 
 	// Republisher
-	republisherOpts []republisher.Option
+	republisherOpts   []republisher.Option
+	republishDisabled bool
+
+	// The role the instance was given, and how often the store is handed off
+	// to be drained elsewhere.
+	role            Role
+	handoffInterval time.Duration
 
 	// Reaper
 	reaperOpts []reaper.Option
+
+	// When set, plain messages are ingested and forwarded on the egress
+	// connection with their subjects translated.
+	bridge              bool
+	subjectTranslations map[string]string
+
+	// Ingest
+	ingestDisabled       bool
+	numConsumers         int
+	consumerBatchSize    int
+	crashDumpKeys        int
+	batchMaxWait         time.Duration
+	batchMaxSize         int
+	batchCommitCB        func(protocol.BatchCommit)
+	queueWriterBuffer    int
+	ackMode              AckMode
+	batchAckWindow       time.Duration
+	batchAckMax          int
+	maxPayloadSize       int
+	allowSubjects        []string
+	denySubjects         []string
+	payloadValidator     PayloadValidator
+	invalidPayloadAction InvalidPayloadAction
+	payloadEncrypter     protocol.Encrypter
+	payloadKeyring       protocol.Keyring
+	keyRotationBatchSize int
+	republishEncrypted   bool
+
+	// Quotas
+	queueQuotas     map[string]int64
+	tenantQuotas    map[string]int64
+	queueTenantFn   func(queueName string) string
+	quotaExceededCB func(protocol.QuotaEvent)
+
+	// Stats
+	statsEnabled bool
+	statsOpts    []statspub.Option
+
+	// Expiry
+	expirySweepInterval time.Duration
+	expiredMessageCB    func(protocol.ExpiredMessage)
+	storageTTL          bool
+	storageTTLGrace     time.Duration
+	terminalRetention   time.Duration
+	receiptsEnabled     bool
+
+	// Health
+	healthCheckInterval time.Duration
+	healthEventCB       func(protocol.HealthEvent)
+	readiness           ReadinessThresholds
+
+	// Events
+	connEventCB func(protocol.ConnEvent)
+
+	// Heartbeats
+	heartbeatInterval time.Duration
+	takeoverDataDirs  []string
+	takeoverCB        func(protocol.TakeoverEvent)
+
+	// Protocol versions
+	unsupportedProtocolHandler UnsupportedProtocolHandler
+
+	// Ack failures
+	ackFailureRetention time.Duration
+	ackFailureCB        func(protocol.AckFailure)
+
+	// Rejections
+	rejectionsRetention time.Duration
+
+	// Dead letters
+	deadLetterQueue      bool
+	deadLetterRetention  time.Duration
+	deadLetterCB         func(protocol.DeadLetter)
+	deadLettersPublished bool
+
+	// The revision of the messages emitted until told otherwise.
+	revision protocol.Revision
+
+	// Hooks
+	hookWorkers   int
+	hookQueueSize int
+
+	// When non-zero, messages are republished within this long of the
+	// precise time they become ready.
+	deliveryPrecision time.Duration
+
+	// The backoff of the messages that don't ask for one, and the cap on the
+	// delay of those that do.
+	defaultBackoff BackoffStrategy
+	maxBackoff     time.Duration
+
+	// When set, trace and provenance headers are added to the messages that
+	// are republished.
+	traceHeaders bool
+
+	// When set, the queue group a message is intended for is sent with it
+	// when it's republished.
+	queueGroupHeaders bool
+
+	// The priorities of the queues by name.
+	queuePriorities map[string]QueuePriority
+
+	// When non-zero, the per-message instrumentation is sampled, one in every
+	// sampleEvery messages, while the ingest rate is above it.
+	sampleAboveRate float64
+	sampleEvery     int
+
+	// When set, the admin API is served over HTTP on the address.
+	adminAddr string
 }
 
 func GetDefaultOptions() Options {
@@ -192,14 +550,35 @@ func GetDefaultOptions() Options {
 			nats.Name(DefaultNatsClientName),
 			nats.RetryOnFailedConnect(DefaultNatsRetryOnFailure),
 		},
-		republisherOpts: make([]republisher.Option, 0),
-		reaperOpts:      make([]reaper.Option, 0),
+		natsDrainTimeout:     nats.DefaultDrainTimeout,
+		syncWrites:           true,
+		numConsumers:         DefaultNumConcurrentBatchTransactions,
+		consumerBatchSize:    DefaultConsumerBatchSize,
+		batchMaxWait:         queue.DefaultBatchInterval,
+		republisherOpts:      make([]republisher.Option, 0),
+		reaperOpts:           make([]reaper.Option, 0),
+		healthCheckInterval:  DefaultHealthCheckInterval,
+		readiness:            DefaultReadinessThresholds,
+		preflightMinFreeDisk: DefaultPreflightMinFreeDisk,
+		vlogGCInterval:       DefaultValueLogGCInterval,
+		vlogGCDiscardRatio:   DefaultValueLogGCDiscardRatio,
+		expirySweepInterval:  DefaultExpirySweepInterval,
+		ackFailureRetention:  DefaultAckFailureRetention,
+		rejectionsRetention:  DefaultRejectionsRetention,
+		keyRotationBatchSize: DefaultKeyRotationBatchSize,
+		revision:             protocol.CurrentRevision,
+		hookWorkers:          DefaultHookWorkers,
+		hookQueueSize:        DefaultHookQueueSize,
 	}
 }
 
 // Connect will attempt to connect to a NATS server with multiple options
 // and setup connections to the disk database.
 func (o Options) Connect() (*Conn, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
 	rc := NewConn(o)
 
 	if err := rc.initBadger(); err != nil {
@@ -207,6 +586,18 @@ func (o Options) Connect() (*Conn, error) {
 		return nil, err
 	}
 
+	// Make sure messages can be persisted before taking any.
+	if err := rc.preflight(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	// The consumers write to the queues so they need to be loaded first.
+	if err := rc.initQueueManager(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
 	if err := rc.initNATS(); err != nil {
 		rc.Close()
 		return nil, err
@@ -219,7 +610,19 @@ func (o Options) Connect() (*Conn, error) {
 	}
 
 	// Start up the service responsible for requeuing messages.
-	if err := rc.initNatsProducers(); err != nil {
+	if err := rc.initRepublisher(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	// Answer control requests, e.g., backlog reports.
+	if err := rc.initControl(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	// Start persisting the rejections counted at ingest.
+	if err := rc.initRejections(); err != nil {
 		rc.Close()
 		return nil, err
 	}
@@ -230,6 +633,54 @@ func (o Options) Connect() (*Conn, error) {
 		return nil, err
 	}
 
+	// Start handing off the store to be drained elsewhere.
+	if err := rc.initHandoff(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	// Start publishing heartbeats and taking over dead instances.
+	if err := rc.initHeartbeats(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	// Start sweeping expired messages from the queues.
+	if err := rc.initExpirySweeper(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	// Resume any key rotation that was interrupted.
+	if err := rc.initKeyRotation(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	// Start garbage collecting the value log.
+	if err := rc.initValueLogGC(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	// Start publishing stats.
+	if err := rc.initStats(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	// Start watching the health of the subscription and consumers.
+	if err := rc.initWatchdog(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	// Serve the admin API.
+	if err := rc.initAdmin(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
 	go func() {
 		// Context closed.
 		<-o.ctx.Done()
@@ -246,8 +697,12 @@ func (o Options) Connect() (*Conn, error) {
 
 	go func() {
 		<-c
-		// Got interrupt. Close things down.
-		rc.Close()
+		// Got interrupt. Finish what has been taken and close things down.
+		ctx, cancel := context.WithTimeout(context.Background(), o.natsDrainTimeout)
+		defer cancel()
+		if err := rc.Drain(ctx); err != nil {
+			log.Err(err).Msg("requeue: problem draining")
+		}
 	}()
 
 	return rc, nil
@@ -259,6 +714,15 @@ type closers struct {
 	badger        *y.Closer
 	reaper        *y.Closer
 	natsProducers *y.Closer
+	watchdog      *y.Closer
+	stats         *y.Closer
+	sweeper       *y.Closer
+	keyRotation   *y.Closer
+	vlogGC        *y.Closer
+	heartbeats    *y.Closer
+	admin         *y.Closer
+	rejections    *y.Closer
+	handoff       *y.Closer
 }
 
 type Conn struct {
@@ -270,18 +734,106 @@ type Conn struct {
 	nc        *nats.Conn
 	sub       *nats.Subscription
 	natsMsgCh chan *nats.Msg
+	// Pulls the messages of the ingest subscription when they're ingested
+	// from JetStream.
+	jsPuller *jetStreamPuller
+
+	// The number of nats consumers currently running. Accessed atomically.
+	natsConsumersAlive int32
+	// Set to 1 once nats has connected for the first time. Accessed
+	// atomically.
+	natsConnected int32
+	// Closed once the nats connection has been closed.
+	natsClosed     chan struct{}
+	natsClosedOnce sync.Once
+
+	// The connection messages are republished on when it's separate from the
+	// ingest connection.
+	egressNC         *nats.Conn
+	egressClosed     chan struct{}
+	egressClosedOnce sync.Once
 
 	// Badger
 	badgerDB    *badger.DB
 	instanceId  string
 	instanceDir string
+	// The data dirs that couldn't be used before the one that is.
+	failedDataDirs []string
+	vlogGC         *badgerInternal.ValueLogGC
+	// The data dirs after the one that is used, to fail over to.
+	spareDataDirs []string
 
 	// Badger Reaper
 	reaper *reaper.Reaper
+	// Reap the data dirs that were failed over from.
+	failedDataDirReapers []*reaper.Reaper
+
+	// The other instances heard from, and the data dirs where the stores of
+	// the dead ones are taken over from.
+	peers            peerRegistry
+	takeoverDataDirs []string
+
+	// Whether the store could be written to when it was last probed.
+	storage storageState
 
 	// Queues
 	qManager    *queue.Manager
 	republisher *republisher.Republisher
+	quotas      *quotas
+
+	// Stats
+	statsPublisher *statspub.StatsPublisher
+	counters       *statspub.Counters
+
+	// The protocol.Revision of the messages being emitted.
+	revision int32
+
+	// Runs the handlers given as options.
+	hooks *hooks.Dispatcher
+
+	// Samples the per-message instrumentation under load. Nil unless
+	// configured.
+	sampler *sampler
+
+	// The recent keys of the consumers for the crash dumps.
+	consumers consumerRegistry
+
+	// The progress of the current, or last, key rotation.
+	keyRotator keyRotator
+
+	// The progress of the long running operations.
+	operations operations
+
+	// The number of messages durably committed, for WaitForPersisted.
+	persisted persistedCounter
+
+	// The rejections counted at ingest that are yet to be persisted.
+	rejections rejectionLog
+
+	// The messages taken from the subscription that are yet to be committed
+	// and acknowledged, for Drain.
+	inflight inflightCounter
+
+	// The system subjects that are never ingested.
+	reserved protocol.ReservedSubjects
+
+	// The writers dedicated to each queue. Nil unless configured.
+	queueWriters *queueWriters
+
+	// Gathers the acks of the messages sent with a correlation id. Nil
+	// unless batch acks are enabled.
+	ackBatcher *ackBatcher
+
+	// Whether the instance left the ingest queue group with LeaveQueueGroup.
+	leftQueueGroup bool
+
+	// How long the messages take to be committed, and whether the instance
+	// is keeping up, for Readiness.
+	commitLatency latencyWindow
+	readiness     readinessState
+
+	// Where the admin API is served, if it is.
+	adminListener net.Listener
 
 	closeOnce sync.Once
 	closed    chan struct{}
@@ -289,36 +841,98 @@ type Conn struct {
 }
 
 func NewConn(o Options) *Conn {
-	instanceId := uuid.Must(uuid.NewV4()).String()
-	return &Conn{
-		Opts:        o,
-		natsMsgCh:   make(chan *nats.Msg),
-		closed:      make(chan struct{}),
-		instanceId:  instanceId,
-		instanceDir: filepath.Join(o.dataDir, instanceId),
+	instanceId := o.instanceId
+	if instanceId == "" {
+		instanceId = uuid.Must(uuid.NewV4()).String()
+	}
+	var s *sampler
+	if o.sampleAboveRate > 0 {
+		s = newSampler(o.sampleAboveRate, o.sampleEvery, time.Now())
+	}
+	var qw *queueWriters
+	if o.queueWriterBuffer > 0 {
+		qw = newQueueWriters(o.queueWriterBuffer)
+	}
+	c := &Conn{
+		Opts:         o,
+		natsMsgCh:    make(chan *nats.Msg, o.consumerBuffer()),
+		natsClosed:   make(chan struct{}),
+		egressClosed: make(chan struct{}),
+		counters:     statspub.NewCounters(),
+		quotas:       newQuotas(),
+		closed:       make(chan struct{}),
+		instanceId:   instanceId,
+		reserved:     o.reservedSubjects(),
+		instanceDir:  filepath.Join(o.dataDir, instanceId),
+		revision:     int32(o.revision),
+		hooks:        hooks.New(o.hookWorkers, o.hookQueueSize),
+		sampler:      s,
+		queueWriters: qw,
 		closers: closers{
 			nats:          y.NewCloser(0),
 			natsConsumers: y.NewCloser(0),
 			badger:        y.NewCloser(0),
 			reaper:        y.NewCloser(0),
 			natsProducers: y.NewCloser(0),
+			watchdog:      y.NewCloser(0),
+			stats:         y.NewCloser(0),
+			sweeper:       y.NewCloser(0),
+			keyRotation:   y.NewCloser(0),
+			vlogGC:        y.NewCloser(0),
+			heartbeats:    y.NewCloser(0),
+			admin:         y.NewCloser(0),
+			rejections:    y.NewCloser(0),
+			handoff:       y.NewCloser(0),
 		},
 	}
+	if o.batchAckWindow > 0 {
+		c.ackBatcher = newAckBatcher(o.batchAckWindow, o.batchAckMax, c.sendBatchAck)
+	}
+	return c
 }
 
 func (c *Conn) Close() {
 	c.closeOnce.Do(func() {
 		log.Info().Msg("requeue: closing...")
+		// Stop serving the admin API since it reads from the queues.
+		c.closers.admin.SignalAndWait()
+		// Stop the watchdog so it doesn't try to heal what we are closing.
+		c.closers.watchdog.SignalAndWait()
+		// Stop taking over dead instances, and tell the others we're
+		// stopping while nats is still up.
+		c.closers.heartbeats.SignalAndWait()
+		// Stop publishing stats since they are read from the queues.
+		c.closers.stats.SignalAndWait()
+		// Stop sweeping expired messages from the queues.
+		c.closers.sweeper.SignalAndWait()
+		// Stop rotating keys. It resumes from its checkpoint on restart.
+		c.closers.keyRotation.SignalAndWait()
+		// Stop garbage collecting the value log.
+		c.closers.vlogGC.SignalAndWait()
+		// Stop handing off the store.
+		c.closers.handoff.SignalAndWait()
 		// Stop the nats producers from sending out messages on nats.
 		c.closers.natsProducers.SignalAndWait()
+		// Send the acks waiting to be batched while nats is still up.
+		if c.ackBatcher != nil {
+			c.ackBatcher.close()
+		}
 		// Stop nats
 		c.closers.nats.SignalAndWait()
 		// Stop processing nats messages
 		c.closers.natsConsumers.SignalAndWait()
+		// Make the writes the consumers handed off.
+		if c.queueWriters != nil {
+			c.queueWriters.close()
+		}
+		// Persist the last of the rejections now that there can be no more.
+		c.closers.rejections.SignalAndWait()
 		// Stop the reaper
 		c.closers.reaper.SignalAndWait()
 		// Stop badger
 		c.closers.badger.SignalAndWait()
+		// Let the handlers still waiting run.
+		c.hooks.Close()
 		log.Info().Msg("requeue: closed")
 		close(c.closed)
 	})
@@ -330,6 +944,7 @@ func (c *Conn) HasBeenClosed() <-chan struct{} {
 
 func (c *Conn) NATSDisconnectErrHandler(nc *nats.Conn, err error) {
 	log.Err(err).Msgf("nats-replay: Got disconnected!")
+	c.publishConnEvent(nc, protocol.ConnStateDisconnected, err)
 }
 
 func (c *Conn) NATSErrorHandler(con *nats.Conn, sub *nats.Subscription, natsErr error) {
@@ -351,15 +966,31 @@ func (c *Conn) NATSErrorHandler(con *nats.Conn, sub *nats.Subscription, natsErr
 func (c *Conn) NATSReconnectHandler(nc *nats.Conn) {
 	// Note that this will be invoked for the first asynchronous connect.
 	log.Info().Msgf("nats-replay: Got reconnected to %s!", nc.ConnectedUrl())
+	if c.markConnected() {
+		c.publishConnEvent(nc, protocol.ConnStateConnected, nil)
+		return
+	}
+	c.repullJetStream()
+	c.publishConnEvent(nc, protocol.ConnStateReconnected, nil)
+}
+
+// markConnected returns true the first time it's called.
+func (c *Conn) markConnected() bool {
+	return atomic.CompareAndSwapInt32(&c.natsConnected, 0, 1)
 }
 
 func (c *Conn) NATSClosedHandler(nc *nats.Conn) {
 	err := nc.LastError()
 	log.Err(err).Msg("nats-replay: Connection closed")
+	c.publishConnEvent(nc, protocol.ConnStateClosed, err)
 	if c.Opts.natsConnErrCB != nil {
 		c.Opts.natsConnErrCB(c, err)
 	}
 
+	// Let anyone waiting on a drain know we are done before we block on
+	// closing everything else.
+	c.natsClosedOnce.Do(func() { close(c.natsClosed) })
+
 	// Close anything left open (such as badger).
 	c.Close()
 }
@@ -395,27 +1026,101 @@ func (c *Conn) initNATS() error {
 		defer rc.closers.nats.Done()
 		<-c.closers.nats.HasBeenClosed()
 
-		// Close nats
-		c.mu.Lock()
-		defer c.mu.Unlock()
-		if c.nc != nil {
-			log.Debug().Msg("draining nats...")
-			if err := c.nc.Drain(); err != nil {
-				log.Err(err).Msg("error draining nats")
-			}
-			log.Debug().Msg("drained nats")
-
-			log.Debug().Msg("closing nats...")
-			c.nc.Close()
-			log.Debug().Msg("closed nats")
+		// Close nats. Draining waits on the handlers and subscriptions,
+		// which may need the lock, so don't hold it while doing so.
+		c.mu.RLock()
+		nc, egressNC := c.nc, c.egressNC
+		c.mu.RUnlock()
+		if egressNC != nil {
+			c.drainEgressNATS(egressNC)
+		}
+		if nc != nil {
+			c.drainNATS(nc)
 		}
 	}()
 
-	sub, err := rc.nc.QueueSubscribe(o.natsSubject, o.natsQueueName, func(msg *nats.Msg) {
-		c.natsMsgCh <- msg
-	})
+	if o.egressEnabled() {
+		if err := rc.connectEgress(); err != nil {
+			return err
+		}
+	}
+
+	if !o.ingestDisabled {
+		// Subscribe to the subject using the queue group.
+		if err := rc.subscribe(); err != nil {
+			return err
+		}
+		rc.nc.Flush()
+
+		if err := rc.nc.LastError(); err != nil {
+			log.Err(err).Msg("nats-replay: LastError")
+			return err
+		}
+
+		if o.jetStreamEnabled() {
+			log.Info().
+				Dict("jetstream", rc.jetStreamDict()).
+				Msgf("Pulling from consumer [%s] of stream [%s]", o.jetStream.Consumer, o.jetStream.Stream)
+		} else {
+			log.Info().
+				Dict("nats",
+					zerolog.Dict().
+						Str("subject", o.natsSubject).
+						Str("queue", o.natsQueueName)).
+				Msgf("Listening on [%s] in queue group [%s]", o.natsSubject, o.natsQueueName)
+		}
+	}
+
+	// When retrying the initial connect, the reconnect handler will be the one
+	// to see the connection established.
+	if rc.nc.IsConnected() && rc.markConnected() {
+		rc.publishConnEvent(rc.nc, protocol.ConnStateConnected, nil)
+	}
+
+	return nil
+}
+
+// natsDrainFlushTimeout is how long nats will wait to flush publishes once the
+// subscriptions have drained.
+const natsDrainFlushTimeout = 5 * time.Second
+
+// drainNATS drains the nats connection and waits for it to close. Must not be
+// called with the lock acquired.
+func (c *Conn) drainNATS(nc *nats.Conn) {
+	log.Debug().Msg("draining nats...")
+	if err := nc.Drain(); err != nil {
+		log.Err(err).Msg("error draining nats")
+	}
 
-	// Subscribe to the subject using the queue group.
+	// Nats enforces the drain timeout for the subscriptions but not for
+	// flushing the publishes so give that a chance too before giving up.
+	timer := time.NewTimer(c.Opts.natsDrainTimeout + natsDrainFlushTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.natsClosed:
+		if err := nc.LastError(); err == nats.ErrDrainTimeout {
+			log.Warn().Msg("nats drain timed out")
+			c.publishConnEvent(nc, protocol.ConnStateDrainTimedOut, err)
+		} else {
+			log.Debug().Msg("drained nats")
+			c.publishConnEvent(nc, protocol.ConnStateDrained, nil)
+		}
+	case <-timer.C:
+		log.Warn().Msg("nats drain timed out. closing nats...")
+		nc.Close()
+		c.publishConnEvent(nc, protocol.ConnStateDrainTimedOut, nats.ErrDrainTimeout)
+	}
+	log.Debug().Msg("closed nats")
+}
+
+// subscribe creates the ingest subscription. Should be called with the lock
+// acquired.
+func (c *Conn) subscribe() error {
+	o := c.Opts
+	if o.jetStreamEnabled() {
+		return c.subscribeJetStream()
+	}
+	sub, err := c.nc.QueueSubscribe(o.natsSubject, o.natsQueueName, c.receiveIngressMessage)
 	if err != nil {
 		log.Err(err).Dict("nats",
 			zerolog.Dict().
@@ -426,40 +1131,71 @@ func (c *Conn) initNATS() error {
 	}
 	// We may want to set PendingLimits here.
 
-	rc.sub = sub
-	rc.nc.Flush()
+	c.sub = sub
+	return nil
+}
 
-	if err := rc.nc.LastError(); err != nil {
-		log.Err(err).Msg("nats-replay: LastError")
-		return err
+// receiveIngressMessage hands the message received on the ingest subscription
+// to the consumers.
+func (c *Conn) receiveIngressMessage(msg *nats.Msg) {
+	// Our own events would match the default subject so make sure we don't
+	// persist them. They're published without a reply subject, so only a
+	// producer waiting on one has sent its message to the wrong subject and
+	// is told so.
+	if c.reserved.Contains(msg.Subject) {
+		if msg.Reply != "" {
+			c.nak(msg, protocol.NakReasonReservedSubject,
+				fmt.Sprintf("subject %q is reserved for requeue", msg.Subject))
+		}
+		return
 	}
+	c.inflight.add(1)
+	c.natsMsgCh <- msg
+}
 
-	log.Info().
-		Dict("nats",
-			zerolog.Dict().
-				Str("subject", o.natsSubject).
-				Str("queue", o.natsQueueName)).
-		Msgf("Listening on [%s] in queue group [%s]", o.natsSubject, o.natsQueueName)
+// resubscribe replaces the ingest subscription with a new one.
+func (c *Conn) resubscribe() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return nil
+	// It's not meant to be subscribed.
+	if c.leftQueueGroup {
+		return nil
+	}
+	if c.sub != nil && c.sub.IsValid() {
+		if err := c.sub.Unsubscribe(); err != nil {
+			log.Err(err).Msg("nats-replay: problem unsubscribing")
+		}
+	}
+	if err := c.subscribe(); err != nil {
+		return err
+	}
+	return c.nc.Flush()
 }
 
 func (c *Conn) initBadger() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Create a new instance in our dataDir
-	if err := os.MkdirAll(c.instanceDir, os.ModePerm); err != nil {
-		return fmt.Errorf("init badger: create instance directory: %w", err)
+	// Fail over to the next data dir if one can't be used.
+	dirs := c.Opts.allDataDirs()
+	var err error
+	for i, dir := range dirs {
+		if err = c.openBadger(dir, true); err == nil {
+			if i > 0 {
+				log.Warn().Strs("failed", dirs[:i]).Str("dataDir", dir).Msg("failed over to another data dir")
+			}
+			c.failedDataDirs = dirs[:i]
+			c.spareDataDirs = dirs[i+1:]
+			break
+		}
+		if i < len(dirs)-1 {
+			log.Err(err).Str("dataDir", dir).Msg("data dir can't be used, trying the next one")
+		}
 	}
-
-	// We will then create a new instance in this dir.
-	db, err := badgerInternal.Open(c.instanceDir)
 	if err != nil {
-		log.Err(err).Msgf("problem opening badger data path: %s", c.Opts.dataDir)
 		return err
 	}
-	c.badgerDB = db
 
 	c.closers.badger.AddRunning(1)
 	go func() {
@@ -482,17 +1218,123 @@ func (c *Conn) initBadger() error {
 	return nil
 }
 
+// openBadger opens the store of the instance in the data dir. If reclaim is
+// set and no instance id was given, the store of a previous instance in it
+// that is no longer running is reclaimed. Should be called with the lock
+// acquired.
+func (c *Conn) openBadger(dataDir string, reclaim bool) error {
+	c.Opts.dataDir = dataDir
+	c.instanceDir = badgerInternal.InstanceDir(dataDir, c.instanceId)
+
+	// There's nothing on disk to create or reclaim for a store in memory.
+	if badgerInternal.InMemory(c.badgerOpenOptions()...) {
+		db, err := badgerInternal.Open("", c.badgerOpenOptions()...)
+		if err != nil {
+			return fmt.Errorf("init badger: open in memory: %w", err)
+		}
+		c.badgerDB = db
+		if err := badgerInternal.WriteInstanceID(c.badgerDB, c.instanceId); err != nil {
+			c.badgerDB.Close()
+			c.badgerDB = nil
+			return fmt.Errorf("init badger: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(c.Opts.dataDir, os.ModePerm); err != nil {
+		return fmt.Errorf("init badger: create data directory: %w", err)
+	}
+	if err := checkDirWritable(c.Opts.dataDir); err != nil {
+		return fmt.Errorf("init badger: data directory is not writable: %w", err)
+	}
+
+	// Keep the identity of a previous instance that is no longer running
+	// rather than appearing as a new instance each boot.
+	if reclaim && c.Opts.instanceId == "" {
+		db, instanceId, err := badgerInternal.ReclaimInstance(c.Opts.dataDir, c.badgerOpenOptions()...)
+		if err != nil {
+			log.Err(err).Msg("problem reclaiming a previous instance")
+		}
+		if db != nil {
+			c.badgerDB = db
+			c.instanceId = instanceId
+			c.instanceDir = badgerInternal.InstanceDir(c.Opts.dataDir, instanceId)
+		}
+	}
+
+	if c.badgerDB == nil {
+		// Create a new instance in our dataDir
+		if err := os.MkdirAll(c.instanceDir, os.ModePerm); err != nil {
+			return fmt.Errorf("init badger: create instance directory: %w", err)
+		}
+
+		// We will then create a new instance in this dir.
+		db, err := badgerInternal.Open(c.instanceDir, c.badgerOpenOptions()...)
+		if err != nil {
+			log.Err(err).Msgf("problem opening badger data path: %s", c.Opts.dataDir)
+			return err
+		}
+		c.badgerDB = db
+	}
+
+	if err := badgerInternal.WriteInstanceID(c.badgerDB, c.instanceId); err != nil {
+		c.badgerDB.Close()
+		c.badgerDB = nil
+		return fmt.Errorf("init badger: %w", err)
+	}
+	return nil
+}
+
+func (c *Conn) badgerOpenOptions() []badgerInternal.OpenOption {
+	opts := []badgerInternal.OpenOption{
+		badgerInternal.SyncWrites(c.Opts.syncWrites),
+		badgerInternal.Compression(c.Opts.compression.badger(), c.Opts.zstdLevel),
+		badgerInternal.BlockCacheSize(c.Opts.blockCacheSize),
+		badgerInternal.Tune(c.Opts.badgerOptions),
+	}
+	if c.Opts.storage == StorageMemory {
+		opts = append(opts, badgerInternal.Memory())
+	}
+	return opts
+}
+
 func (c *Conn) initNatsConsumers() error {
+	if c.Opts.ingestDisabled {
+		return nil
+	}
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	c.closers.natsConsumers.AddRunning(DefaultNumConcurrentBatchTransactions)
+	c.startNatsConsumers(c.numNatsConsumers())
+
+	return nil
+}
+
+func (c *Conn) numNatsConsumers() int {
+	return c.Opts.numConsumers
+}
 
-	for i := 0; i < DefaultNumConcurrentBatchTransactions; i++ {
+func (c *Conn) startNatsConsumers(n int) {
+	c.closers.natsConsumers.AddRunning(n)
+	atomic.AddInt32(&c.natsConsumersAlive, int32(n))
+	for i := 0; i < n; i++ {
 		go c.initNatsConsumer()
 	}
+}
 
-	return nil
+// restartNatsConsumers replaces n consumers that have died.
+func (c *Conn) restartNatsConsumers(n int) {
+	select {
+	case <-c.closers.natsConsumers.HasBeenClosed():
+		// We are closing so the consumers are expected to be gone.
+		return
+	case <-c.Opts.ctx.Done():
+		return
+	default:
+	}
+	log.Warn().Msgf("restarting %d nats consumers", n)
+	c.startNatsConsumers(n)
 }
 
 func (c *Conn) initNatsConsumer() {
@@ -501,62 +1343,227 @@ func (c *Conn) initNatsConsumer() {
 	defer natsConsumer.Done()
 	c.mu.RUnlock()
 
-	for {
-		select {
-		case msg := <-c.natsMsgCh:
-			c.processIngressMessage(msg)
-		case <-natsConsumer.HasBeenClosed():
-			// The consumer has been asked to close.
-			// Flushing will be handled by the above defer wb.Close()
-			return
+	defer atomic.AddInt32(&c.natsConsumersAlive, -1)
+	id, keys := c.consumers.add(c.Opts.crashDumpKeys)
+	defer c.consumers.remove(id)
+	defer func() {
+		// Don't take the whole process down if the consumer itself panics.
+		// The watchdog will notice this consumer is gone and replace it.
+		if r := recover(); r != nil {
+			log.Error().Msgf("nats consumer panic: %v", r)
+			c.writeCrashDump(r, debug.Stack())
 		}
+	}()
+
+	consume(c.Opts.ctx, natsConsumer.HasBeenClosed(), c.natsMsgCh, c.Opts.consumerBatchSize, func(msg *nats.Msg) {
+		defer c.inflight.add(-1)
+		defer c.recoverIngressMessage(msg)
+		c.processIngressMessage(msg, keys)
+	})
+}
+
+// recoverIngressMessage recovers from a panic processing the message, so a
+// single message doesn't take the consumer down with it, and rejects the
+// message since it wasn't persisted.
+func (c *Conn) recoverIngressMessage(msg *nats.Msg) {
+	r := recover()
+	if r == nil {
+		return
 	}
+	log.Error().Str("subject", msg.Subject).Msgf("nats consumer panic: %v", r)
+	c.writeCrashDump(r, debug.Stack())
+	c.nak(msg, protocol.NakReasonInternalError, fmt.Sprintf("panic processing the message: %v", r))
 }
 
-func (c *Conn) processIngressMessage(msg *nats.Msg) {
+// processIngressMessage persists the message, adding its key to the recent
+// keys of the consumer.
+func (c *Conn) processIngressMessage(msg *nats.Msg, keys *recentKeys) {
+	received := time.Now()
+	c.sampler.observe(received)
+
+	if c.Opts.bridge {
+		msg = bridgeEnvelope(msg)
+	}
+
+	if max := c.Opts.maxPayloadSize; max > 0 && len(msg.Data) > max {
+		c.nak(msg, protocol.NakReasonPayloadTooLarge,
+			fmt.Sprintf("message of %d bytes exceeds the max payload size of %d bytes", len(msg.Data), max))
+		return
+	}
+
+	msg, ok := c.upgradeProtocol(msg)
+	if !ok {
+		return
+	}
+
 	fb := flatbuf.GetRootAsRequeueMessage(msg.Data, 0)
-	log.Debug().
+	c.debugSampled().
 		Str("msg", string(fb.OriginalPayloadBytes())).
 		Msg("received a message")
 
+	if subj := string(fb.OriginalSubject()); !c.subjectAllowed(subj) {
+		c.nak(msg, protocol.NakReasonSubjectDenied,
+			fmt.Sprintf("original subject %q is not allowed", subj))
+		return
+	}
+
+	queueName := protocol.GetQueueName(fb)
+	if !c.checkQuotas(msg, queueName) {
+		return
+	}
+
+	// Encrypt first so nothing we persist, not even a quarantined message,
+	// holds the plaintext payload.
+	data, ok := c.encryptPayload(msg, queueName)
+	if !ok {
+		return
+	}
+
+	if !c.validatePayload(msg, data, fb) {
+		return
+	}
+
+	// Hold the message back for at least the min delay of its queue.
+	data = c.applyMinDelay(data)
+	data = c.stampReadyAt(data, received)
+	data = c.stampTraceParent(data, msg)
+	data = c.stampReplyTo(data, msg)
+
 	// Build the key
-	qk, err := c.newMessageQueueKey(msg, fb)
+	qk, err := c.newMessageQueueKey(msg, flatbuf.GetRootAsRequeueMessage(data, 0))
 	if err != nil {
 		return
 	}
+	keys.add(qk)
 
 	// Before we write the message, we need to create the state for the
 	// queue if it doesn't yet exist.
 	stateQK := queue.NewQueueKeyForState(qk.Name, "")
-	q, err := c.qManager.UpsertQueueState(stateQK)
+	q, err := c.Manager().UpsertQueueState(stateQK)
 	if err != nil {
 		log.Err(err).
 			Interface("stateQueueKey", stateQK).
 			Msg("problem upserting queue state for ingress message")
+		// E.g., the store is failing over so the queues are being moved.
+		c.nak(msg, protocol.NakReasonInternalError, fmt.Sprintf("unable to create queue %q", qk.Name))
+		return
 	}
 
-	if err := q.AddMessage(
-		qk.Bytes(),                              // key
-		msg.Data,                                // value
-		time.Duration(fb.Ttl()),                 // ttl
-		c.processIngressMessageCallback(q, msg), // commit callback
-	); err != nil {
-		if c.Opts.badgerWriteMsgErr != nil {
-			c.Opts.badgerWriteMsgErr(msg, err)
+	if c.Opts.ackMode == AckOnReceive {
+		c.ack(qk, msg, false)
+	}
+
+	if c.coalesceMessage(q, qk, data, fb, msg, received) {
+		return
+	}
+
+	// The message is in flight until it has been committed and acknowledged.
+	cb := c.processIngressMessageCallback(q, qk, msg, received)
+	c.inflight.add(1)
+
+	c.writeQueue(qk.Name, func() {
+		// The TTL is enforced by the expiry sweeper rather than the store so
+		// that expirations can be observed, unless the store is a backstop.
+		if err := q.AddMessage(
+			qk.Bytes(),       // key
+			data,             // value
+			c.storageTTL(fb), // ttl
+			func(err error) { // commit callback
+				defer c.inflight.add(-1)
+				cb(err)
+			},
+		); err != nil {
+			c.inflight.add(-1)
+			c.badgerWriteMsgErr(msg, err)
 		}
+	})
+}
+
+// writeQueue makes the writes of a message to the queue, on its own writer if
+// the queue writers are isolated.
+func (c *Conn) writeQueue(queueName string, f func()) {
+	if c.queueWriters == nil {
+		f()
+		return
+	}
+	c.queueWriters.write(queueName, f)
+}
+
+// nak rejects the message by replying with the reason.
+func (c *Conn) nak(msg *nats.Msg, reason protocol.NakReason, message string) {
+	c.counters.AddRejected(reason)
+	c.recordRejection(msg, reason, message)
+	c.debugSampled().
+		Str("subject", msg.Subject).
+		Str("reason", string(reason)).
+		Msg(message)
+
+	// There is no one to tell if the producer didn't give a reply subject.
+	if msg.Reply == "" {
+		return
+	}
+
+	data, err := c.rejectReply(protocol.Nak{Reason: reason, Message: message})
+	if err != nil {
+		log.Err(err).Msg("problem marshaling NAK for message")
+		return
+	}
+	if err := c.respondNak(msg, data); err != nil {
+		log.Err(err).
+			Str("subject", msg.Subject).
+			Msg("problem sending NAK for message")
+	}
+}
+
+// respondNak sends the Nak to the producer of the message, with its
+// correlation id if it's acknowledged in batches so the producer can tell
+// which of the batch was rejected.
+func (c *Conn) respondNak(msg *nats.Msg, data []byte) error {
+	id := c.correlationID(msg)
+	if id == "" {
+		return msg.Respond(data)
 	}
+	reply := &nats.Msg{Subject: msg.Reply, Header: make(http.Header), Data: data}
+	reply.Header.Set(protocol.CorrelationIDHeader, id)
+	return msg.RespondMsg(reply)
 }
 
+// newMessageQueueKey returns the key to store the message under. The key is
+// made from the time the message is ready to be republished, not when it was
+// received, so the messages of a queue are sorted by their ready time and a
+// delayed message never holds up one that's ready before it. The republisher
+// only reads each queue from its checkpoint up to the key of the current time.
 func (c *Conn) newMessageQueueKey(msg *nats.Msg, fb *flatbuf.RequeueMessage) (queue.QueueKey, error) {
+	now := time.Now()
+	delay := time.Duration(fb.Delay())
+	// Bridged messages are forwarded right away. Their delay is only the base
+	// of the backoff between retries.
+	if c.Opts.bridge {
+		delay = 0
+	}
 	return queue.NewQueueKeyForMessage(
-		protocol.GetQueueName(fb),
-		key.New(time.Now().Add(time.Duration(fb.Delay()))),
+		c.Opts.timeBucket.QueueName(protocol.GetQueueName(fb), now),
+		key.New(now.Add(delay)),
 	), nil
 }
 
+// DropTimeBucketsBefore drops every time bucketed sub-queue of the queue
+// whose time slice ended before t, returning the names of the dropped
+// sub-queues. This is a prefix delete per sub-queue so it stays cheap no matter
+// how many messages are being dropped.
+func (c *Conn) DropTimeBucketsBefore(queueName string, t time.Time) ([]string, error) {
+	c.mu.RLock()
+	qManager := c.qManager
+	c.mu.RUnlock()
+	if qManager == nil {
+		return nil, fmt.Errorf("drop time buckets: queue manager is not running")
+	}
+	return qManager.DropTimeBucketsBefore(queueName, c.Opts.timeBucket, t)
+}
+
 // A commit from batchedWriter will trigger a batch of callbacks,
 // one for each message.
-func (c *Conn) processIngressMessageCallback(q *queue.Queue, msg *nats.Msg) func(err error) {
+func (c *Conn) processIngressMessageCallback(q *queue.Queue, qk queue.QueueKey, msg *nats.Msg, received time.Time) func(err error) {
 	return func(err error) {
 		fb := flatbuf.GetRootAsRequeueMessage(msg.Data, 0)
 		if err != nil {
@@ -564,37 +1571,76 @@ func (c *Conn) processIngressMessageCallback(q *queue.Queue, msg *nats.Msg) func
 				Str("msg", string(fb.OriginalPayloadBytes())).
 				Msgf("problem committing message")
 		}
-		log.Debug().
+		c.debugSampled().
 			Str("msg", string(fb.OriginalPayloadBytes())).
 			Str("Reply", msg.Reply).
 			Str("Subject", msg.Subject).
 			Msgf("committed message")
+		if err == nil {
+			c.counters.AddIngested(string(fb.OriginalSubject()))
+			if msg.Reply == "" {
+				c.counters.AddNoReply(string(fb.OriginalSubject()))
+			}
+			c.wakeRepublisher(qk.Time())
+			c.persisted.add(1)
+			c.commitLatency.record(time.Since(received))
+		}
 
-		// Ack the message
-		if err := msg.Respond(nil); err != nil {
-			log.Err(err).
-				Str("msg", string(fb.OriginalPayloadBytes())).
-				Msgf("problem sending ACK for message")
+		// Ack the message unless it was acked when it was received, or will
+		// be once it's delivered.
+		if c.Opts.ackMode == AckOnCommit && !c.ack(qk, msg, err == nil) {
+			return
 		}
+		if err == nil {
+			q.Stats.RecordPersistLatency(time.Since(received))
+		}
+	}
+}
+
+// ack acknowledges the message stored under qk to its producer. Producers that
+// fire and forget don't give a reply subject, in which case there is no one to
+// acknowledge. It returns false if the ack couldn't be sent.
+func (c *Conn) ack(qk queue.QueueKey, msg *nats.Msg, persisted bool) bool {
+	if msg.Reply == "" {
+		return true
+	}
+	if id := c.correlationID(msg); id != "" {
+		c.ackBatcher.add(pendingAck{id: id, qk: qk, msg: msg, persisted: persisted})
+		return true
+	}
+	if err := msg.Respond(nil); err != nil {
+		c.ackFailed(qk, msg, persisted, err)
+		return false
 	}
+	return true
 }
 
-func (c *Conn) initNatsProducers() error {
+func (c *Conn) initQueueManager() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Load up all the queues we have on disk and manage them.
-	manager, err := queue.NewManager(c.badgerDB)
+	// Migrate the keys up front so the progress can be reported. There's
+	// only an operation if there is something to migrate.
+	var migration *operation
+	_, err := queue.MigrateKeyFormatProgress(c.badgerDB, func(done, total int) {
+		if migration == nil {
+			migration = c.operations.start(c.Opts.ctx, protocol.OperationMigration, "")
+		}
+		migration.progress(done, total)
+	})
+	if migration != nil {
+		migration.finish(err)
+	}
 	if err != nil {
 		return err
 	}
-	c.qManager = manager
 
-	// Create a republisher
-	c.republisher, err = republisher.New(c.nc, c.badgerDB, manager, c.Opts.republisherOpts...)
+	// Load up all the queues we have on disk and manage them.
+	manager, err := c.newQueueManager()
 	if err != nil {
 		return err
 	}
+	c.qManager = manager
 
 	c.closers.natsProducers.AddRunning(1)
 	go func() {
@@ -602,15 +1648,14 @@ func (c *Conn) initNatsProducers() error {
 		<-c.closers.natsProducers.HasBeenClosed()
 
 		log.Debug().Msg("closing nats producers...")
-		c.mu.Lock()
-		defer c.mu.Unlock()
 
-		// close the republisher
-		if c.republisher != nil {
-			c.republisher.Close()
-		}
+		// close the republisher. Its write loop calls back into the
+		// connection, so it's closed without holding the lock.
+		c.StopRepublishing()
 
 		// close the queue manager
+		c.mu.Lock()
+		defer c.mu.Unlock()
 		if c.qManager != nil {
 			c.qManager.Close()
 		}
@@ -619,10 +1664,32 @@ func (c *Conn) initNatsProducers() error {
 	return nil
 }
 
+// newQueueManager loads the queues in the store. Should be called with the lock
+// acquired.
+func (c *Conn) newQueueManager() (*queue.Manager, error) {
+	return queue.NewManager(c.badgerDB,
+		queue.BatchInterval(c.Opts.batchMaxWait),
+		queue.BatchMaxSize(c.Opts.batchMaxSize),
+		queue.CommitHandler(c.batchCommitted),
+		queue.Context(c.Opts.ctx),
+	)
+}
+
 func (c *Conn) initReaper() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// Merging other instances into memory would lose their messages.
+	if badgerInternal.InMemory(c.badgerOpenOptions()...) {
+		log.Warn().Msg("the store is in memory so other instances won't be reaped")
+		return nil
+	}
+	// The stores handed off would be merged back in.
+	if c.Opts.handoffInterval > 0 {
+		log.Info().Msg("the store is handed off so other instances won't be reaped")
+		return nil
+	}
+
 	// Create our reaper
 	reaper, err := reaper.NewReaper(
 		c.badgerDB,
@@ -635,6 +1702,10 @@ func (c *Conn) initReaper() error {
 	}
 	c.reaper = reaper
 
+	if err := c.initFailedDataDirReapers(); err != nil {
+		return err
+	}
+
 	c.closers.reaper.AddRunning(1)
 	go func() {
 		defer c.closers.reaper.Done()
@@ -648,6 +1719,53 @@ func (c *Conn) initReaper() error {
 		if c.reaper != nil {
 			c.reaper.Close()
 		}
+		for _, r := range c.failedDataDirReapers {
+			r.Close()
+		}
+	}()
+
+	return nil
+}
+
+func (c *Conn) initStats() error {
+	if !c.Opts.statsEnabled {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The defaults go first so they can be overridden.
+	opts := append([]statspub.Option{
+		statspub.StorageMetrics(c.badgerDB),
+		statspub.Labels(c.Opts.labels),
+		statspub.InstanceCounters(c.counters),
+		statspub.ServerVersion(Version, c.Features()),
+		statspub.EmitRevision(c.Revision),
+		statspub.KeyRotationProgress(c.KeyRotation),
+		statspub.OperationsProgress(c.Operations),
+		statspub.SamplingState(c.Sampling),
+		statspub.ValueLogGCMetrics(c.vlogGC),
+	}, c.Opts.statsOpts...)
+
+	sp, err := statspub.NewStatsPublisher(c.nc, c.qManager, c.instanceId, opts...)
+	if err != nil {
+		return err
+	}
+	c.statsPublisher = sp
+
+	c.closers.stats.AddRunning(1)
+	go func() {
+		defer c.closers.stats.Done()
+		<-c.closers.stats.HasBeenClosed()
+
+		log.Debug().Msg("closing stats publisher...")
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if c.statsPublisher != nil {
+			c.statsPublisher.Close()
+		}
 	}()
 
 	return nil
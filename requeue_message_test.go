@@ -2,6 +2,7 @@ package requeue_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/nickpoorman/nats-requeue/flatbuf"
 	"github.com/nickpoorman/nats-requeue/protocol"
@@ -29,3 +30,21 @@ func TestRequeueMessage_FlatbufMutate(t *testing.T) {
 	fb2 := flatbuf.GetRootAsRequeueMessage(msgBytes, 0)
 	assert.Equal(t, fb2.Retries(), uint64(4))
 }
+
+func TestRequeueMessage_Fields(t *testing.T) {
+	msg := protocol.DefaultRequeueMessage()
+	msg.OriginalSubject = "foo.bar"
+	msg.AckTimeout = uint64(time.Minute)
+
+	msg.DedupeKey = "acme"
+
+	var got protocol.RequeueMessage
+	assert.NoError(t, got.UnmarshalBinary(msg.Bytes()))
+	assert.Equal(t, uint64(time.Minute), got.AckTimeout)
+	assert.Equal(t, "acme", got.DedupeKey)
+
+	// Messages without a timeout fall back to the one for the queue.
+	msg.AckTimeout = 0
+	fb := flatbuf.GetRootAsRequeueMessage(msg.Bytes(), 0)
+	assert.Equal(t, uint64(0), fb.AckTimeout())
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -14,7 +15,9 @@ import (
 	natsserver "github.com/nats-io/nats-server/v2/test"
 	"github.com/nats-io/nats.go"
 	requeue "github.com/nickpoorman/nats-requeue"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
 	"github.com/nickpoorman/nats-requeue/internal/republisher"
+	"github.com/nickpoorman/nats-requeue/internal/statspub"
 	"github.com/nickpoorman/nats-requeue/protocol"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/sync/errgroup"
@@ -201,6 +204,1095 @@ func Test_RequeueEndToEnd(t *testing.T) {
 	t.Log("requeue closed")
 }
 
+func Test_RequeueConnEvents(t *testing.T) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	dataDir := setup(t)
+
+	events := make(chan protocol.ConnEvent, 10)
+	rc, err := requeue.Connect(
+		requeue.DataDir(dataDir),
+		requeue.NATSServers(s.ClientURL()),
+		requeue.NATSSubject(nats.NewInbox()),
+		requeue.NATSDrainTimeout(5*time.Second),
+		requeue.ConnEventHandler(func(e protocol.ConnEvent) {
+			events <- e
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+
+	e := <-events
+	assert.Equal(t, protocol.ConnStateConnected, e.State)
+	assert.Equal(t, s.ClientURL(), e.ServerURL)
+	assert.NotEmpty(t, e.InstanceID)
+
+	rc.Close()
+
+	e = <-events
+	assert.Equal(t, protocol.ConnStateClosed, e.State)
+
+	e = <-events
+	assert.Equal(t, protocol.ConnStateDrained, e.State)
+}
+
+func Test_RequeueStats(t *testing.T) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(s.ClientURL()),
+		requeue.NATSSubject(nats.NewInbox()),
+		requeue.InstanceID("stats-instance"),
+		requeue.Labels(map[string]string{"env": "test"}),
+		requeue.EnableStats(statspub.StatsPublishInterval(100*time.Millisecond)),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	nc, err := nats.Connect(s.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		nc.Close()
+	})
+
+	msg, err := nc.Request(protocol.StatsRequestSubject(protocol.StatsSubjectPrefix, "stats-instance"), nil, 5*time.Second)
+	assert.NoError(t, err)
+	ism := protocol.InstanceStatsMessageFromNATS(msg)
+	assert.Equal(t, "stats-instance", ism.InstanceId)
+	assert.Equal(t, protocol.Labels{"env": "test"}, ism.Labels)
+}
+
+func Test_RequeueMaxPayloadSize(t *testing.T) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	subject := nats.NewInbox()
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(s.ClientURL()),
+		requeue.NATSSubject(subject),
+		requeue.MaxPayloadSize(64),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	nc, err := nats.Connect(s.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		nc.Close()
+	})
+
+	payload := buildPayload(0, "foo.bar.baz")
+	payload.OriginalPayload = make([]byte, 128)
+	msg, err := nc.Request(subject, payload.Bytes(), 5*time.Second)
+	assert.NoError(t, err)
+	nak, ok := protocol.NakFromNATS(msg)
+	assert.True(t, ok)
+	assert.Equal(t, protocol.NakReasonPayloadTooLarge, nak.Reason)
+}
+
+func Test_RequeueValidatePayloads(t *testing.T) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	subject := nats.NewInbox()
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(s.ClientURL()),
+		requeue.NATSSubject(subject),
+		requeue.ValidatePayloads(
+			requeue.PayloadValidatorFunc(func(subject string, payload []byte) error {
+				if subject == "invalid" {
+					return fmt.Errorf("subject is invalid")
+				}
+				return nil
+			}),
+			requeue.InvalidPayloadQuarantine,
+		),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	nc, err := nats.Connect(s.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		nc.Close()
+	})
+
+	// A valid payload is ACKed.
+	valid := buildPayload(0, "foo.bar.baz")
+	msg, err := nc.Request(subject, valid.Bytes(), 5*time.Second)
+	assert.NoError(t, err)
+	_, ok := protocol.NakFromNATS(msg)
+	assert.False(t, ok)
+
+	// An invalid payload is NAKed and quarantined.
+	payload := buildPayload(1, "invalid")
+	msg, err = nc.Request(subject, payload.Bytes(), 5*time.Second)
+	assert.NoError(t, err)
+	nak, ok := protocol.NakFromNATS(msg)
+	assert.True(t, ok)
+	assert.Equal(t, protocol.NakReasonQuarantined, nak.Reason)
+	assert.Equal(t, "subject is invalid", nak.Message)
+
+	quarantined := make([]protocol.QuarantinedMessage, 0)
+	err = rc.QuarantinedMessages(payload.QueueName, func(m protocol.QuarantinedMessage) bool {
+		quarantined = append(quarantined, m)
+		return true
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, quarantined, 1) {
+		assert.Equal(t, subject, quarantined[0].Subject)
+		assert.Equal(t, "subject is invalid", quarantined[0].Error)
+		assert.Equal(t, payload.Bytes(), quarantined[0].Message)
+	}
+}
+
+func Test_RequeueQuotas(t *testing.T) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	quotaEvents := make(chan protocol.QuotaEvent, 10)
+	subject := nats.NewInbox()
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(s.ClientURL()),
+		requeue.NATSSubject(subject),
+		requeue.QueueTenant(func(queueName string) string {
+			return strings.SplitN(queueName, ".", 2)[0]
+		}),
+		requeue.TenantQuota("acme", 1),
+		requeue.QuotaExceededHandler(func(e protocol.QuotaEvent) {
+			quotaEvents <- e
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	nc, err := nats.Connect(s.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		nc.Close()
+	})
+
+	send := func(queueName string) (protocol.Nak, bool) {
+		payload := buildPayload(0, "foo.bar.baz")
+		payload.QueueName = queueName
+		// Don't let the message be republished while we're testing.
+		payload.Delay = uint64(time.Hour)
+		msg, err := nc.Request(subject, payload.Bytes(), 5*time.Second)
+		assert.NoError(t, err)
+		return protocol.NakFromNATS(msg)
+	}
+
+	_, ok := send("acme.orders")
+	assert.False(t, ok)
+
+	// The tenant is at its quota across all of its queues.
+	nak, ok := send("acme.invoices")
+	assert.True(t, ok)
+	assert.Equal(t, protocol.NakReasonQuotaExceeded, nak.Reason)
+
+	// Other tenants are unaffected.
+	_, ok = send("globex.orders")
+	assert.False(t, ok)
+
+	select {
+	case e := <-quotaEvents:
+		assert.Equal(t, protocol.QuotaScopeTenant, e.Scope)
+		assert.Equal(t, "acme", e.Tenant)
+		assert.Equal(t, "acme.invoices", e.Queue)
+		assert.Equal(t, int64(1), e.Quota)
+		assert.Equal(t, int64(1), e.Usage)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the quota event")
+	}
+}
+
+func Test_RequeueStartStopRepublishing(t *testing.T) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	subject := nats.NewInbox()
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(s.ClientURL()),
+		requeue.NATSSubject(subject),
+		requeue.RepublisherOptions(
+			republisher.RepublishInterval(100*time.Millisecond),
+		),
+		requeue.DisableRepublish(),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+	assert.False(t, rc.IsRepublishing())
+	assert.NotNil(t, rc.Manager())
+
+	nc, err := nats.Connect(s.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		nc.Close()
+	})
+
+	originalSubject := nats.NewInbox()
+	republished := make(chan struct{}, 1)
+	_, err = nc.Subscribe(originalSubject, func(msg *nats.Msg) {
+		_ = msg.Respond(nil)
+		select {
+		case republished <- struct{}{}:
+		default:
+		}
+	})
+	assert.NoError(t, err)
+
+	payload := buildPayload(0, originalSubject)
+	_, err = nc.Request(subject, payload.Bytes(), 5*time.Second)
+	assert.NoError(t, err)
+
+	select {
+	case <-republished:
+		t.Fatal("message was republished while republishing was disabled")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	assert.NoError(t, rc.StartRepublishing())
+	assert.True(t, rc.IsRepublishing())
+	select {
+	case <-republished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the message to be republished")
+	}
+
+	rc.StopRepublishing()
+	assert.False(t, rc.IsRepublishing())
+}
+
+func Test_RequeueTerminalRecords(t *testing.T) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	subject := nats.NewInbox()
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(s.ClientURL()),
+		requeue.NATSSubject(subject),
+		requeue.RepublisherOptions(
+			republisher.RepublishInterval(100*time.Millisecond),
+		),
+		requeue.RetainTerminalRecords(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	nc, err := nats.Connect(s.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		nc.Close()
+	})
+
+	originalSubject := nats.NewInbox()
+	_, err = nc.Subscribe(originalSubject, func(msg *nats.Msg) {
+		_ = msg.Respond(nil)
+	})
+	assert.NoError(t, err)
+
+	payload := buildPayload(0, originalSubject)
+	_, err = nc.Request(subject, payload.Bytes(), 5*time.Second)
+	assert.NoError(t, err)
+
+	var record protocol.TerminalRecord
+	assert.Eventually(t, func() bool {
+		found := false
+		assert.NoError(t, rc.TerminalRecords(protocol.DefaultQueueName, func(r protocol.TerminalRecord) bool {
+			record, found = r, true
+			return false
+		}))
+		return found
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Equal(t, protocol.TerminalStateDelivered, record.State)
+	assert.Equal(t, originalSubject, record.Subject)
+	assert.Equal(t, uint64(1), record.Attempts)
+
+	got, ok, err := rc.TerminalRecord(record.Queue, record.Key)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, record.Key, got.Key)
+}
+
+func Test_RequeueQueueMinDelay(t *testing.T) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	subject := nats.NewInbox()
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(s.ClientURL()),
+		requeue.NATSSubject(subject),
+		requeue.RepublisherOptions(
+			republisher.RepublishInterval(100*time.Millisecond),
+		),
+		requeue.QueueMinDelay(protocol.DefaultQueueName, 2*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	nc, err := nats.Connect(s.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		nc.Close()
+	})
+
+	originalSubject := nats.NewInbox()
+	republished := make(chan struct{}, 1)
+	_, err = nc.Subscribe(originalSubject, func(msg *nats.Msg) {
+		_ = msg.Respond(nil)
+		select {
+		case republished <- struct{}{}:
+		default:
+		}
+	})
+	assert.NoError(t, err)
+
+	// The producer asks for practically no delay.
+	payload := buildPayload(0, originalSubject)
+	_, err = nc.Request(subject, payload.Bytes(), 5*time.Second)
+	assert.NoError(t, err)
+
+	select {
+	case <-republished:
+		t.Fatal("message was republished before the min delay of its queue")
+	case <-time.After(time.Second):
+	}
+	select {
+	case <-republished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the message to be republished")
+	}
+}
+
+func Test_RequeueBacklogReport(t *testing.T) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	subject := nats.NewInbox()
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(s.ClientURL()),
+		requeue.NATSSubject(subject),
+		requeue.InstanceID("backlog-test"),
+		requeue.DisableRepublish(),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	nc, err := nats.Connect(s.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		nc.Close()
+	})
+
+	for i, originalSubject := range []string{"orders.created", "orders.created", "orders.paid"} {
+		payload := buildPayload(i, originalSubject)
+		_, err := nc.Request(subject, payload.Bytes(), 5*time.Second)
+		assert.NoError(t, err)
+	}
+
+	data, err := protocol.BacklogRequest{Queue: protocol.DefaultQueueName, N: 1}.MarshalBinary()
+	assert.NoError(t, err)
+	msg, err := nc.Request(protocol.BacklogReportSubject("backlog-test"), data, 5*time.Second)
+	assert.NoError(t, err)
+
+	report := protocol.BacklogReport{}
+	assert.NoError(t, report.UnmarshalBinary(msg.Data))
+	assert.Empty(t, report.Error)
+	assert.Equal(t, int64(3), report.Count)
+	if assert.Len(t, report.Subjects, 1) {
+		assert.Equal(t, "orders.created", report.Subjects[0].Subject)
+		assert.Equal(t, int64(2), report.Subjects[0].Count)
+	}
+	assert.Equal(t, requeue.Version, report.Version)
+	assert.True(t, report.Features.Supports(protocol.FeatureBacklogReport))
+
+	msg, err = nc.Request(protocol.InstanceInfoSubject("backlog-test"), nil, 5*time.Second)
+	assert.NoError(t, err)
+	info := protocol.InstanceInfo{}
+	assert.NoError(t, info.UnmarshalBinary(msg.Data))
+	assert.Equal(t, "backlog-test", info.InstanceID)
+	assert.Equal(t, requeue.Version, info.Version)
+	assert.False(t, info.Features.Supports(protocol.FeatureReceipts))
+}
+
+// xorEncrypter is a toy protocol.Encrypter for testing.
+type xorEncrypter byte
+
+func (x xorEncrypter) Encrypt(p []byte) ([]byte, error) {
+	out := make([]byte, len(p))
+	for i := range p {
+		out[i] = p[i] ^ byte(x)
+	}
+	return out, nil
+}
+
+func (x xorEncrypter) Decrypt(p []byte) ([]byte, error) {
+	return x.Encrypt(p)
+}
+
+func Test_RequeuePayloadEncrypter(t *testing.T) {
+	e := xorEncrypter(0x5a)
+	tests := []struct {
+		name      string
+		encrypted bool
+	}{
+		{name: "decrypted on republish"},
+		{name: "republished encrypted", encrypted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := natsserver.RunRandClientPortServer()
+			t.Cleanup(func() {
+				s.Shutdown()
+			})
+
+			subject := nats.NewInbox()
+			opts := []requeue.Option{
+				requeue.DataDir(setup(t)),
+				requeue.NATSServers(s.ClientURL()),
+				requeue.NATSSubject(subject),
+				requeue.RepublisherOptions(
+					republisher.RepublishInterval(100 * time.Millisecond),
+				),
+				requeue.PayloadEncrypter(e),
+			}
+			if tt.encrypted {
+				opts = append(opts, requeue.RepublishEncrypted())
+			}
+			rc, err := requeue.Connect(opts...)
+			if err != nil {
+				t.Fatalf("Error on requeue connect: %v", err)
+			}
+			t.Cleanup(func() {
+				rc.Close()
+			})
+
+			nc, err := nats.Connect(s.ClientURL())
+			assert.NoError(t, err)
+			t.Cleanup(func() {
+				nc.Close()
+			})
+
+			originalSubject := nats.NewInbox()
+			republished := make(chan []byte, 1)
+			_, err = nc.Subscribe(originalSubject, func(msg *nats.Msg) {
+				_ = msg.Respond(nil)
+				select {
+				case republished <- msg.Data:
+				default:
+				}
+			})
+			assert.NoError(t, err)
+
+			payload := buildPayload(0, originalSubject)
+			_, err = nc.Request(subject, payload.Bytes(), 5*time.Second)
+			assert.NoError(t, err)
+
+			select {
+			case data := <-republished:
+				if tt.encrypted {
+					assert.NotEqual(t, payload.OriginalPayload, data)
+					data, err = e.Decrypt(data)
+					assert.NoError(t, err)
+				}
+				assert.Equal(t, payload.OriginalPayload, data)
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for the message to be republished")
+			}
+		})
+	}
+}
+
+// queueKeyring is a toy protocol.Keyring with one key per queue.
+type queueKeyring map[string]xorEncrypter
+
+func (k queueKeyring) KeyID(queue string) (string, error) {
+	return queue + "-1", nil
+}
+
+func (k queueKeyring) Encrypter(keyID string) (protocol.Encrypter, error) {
+	e, ok := k[keyID]
+	if !ok {
+		return nil, protocol.ErrKeyNotFound
+	}
+	return e, nil
+}
+
+func Test_RequeuePayloadKeyring(t *testing.T) {
+	k := queueKeyring{protocol.DefaultQueueName + "-1": xorEncrypter(0x3c)}
+	tests := []struct {
+		name      string
+		encrypted bool
+	}{
+		{name: "decrypted on republish"},
+		{name: "republished encrypted", encrypted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := natsserver.RunRandClientPortServer()
+			t.Cleanup(func() {
+				s.Shutdown()
+			})
+
+			subject := nats.NewInbox()
+			opts := []requeue.Option{
+				requeue.DataDir(setup(t)),
+				requeue.NATSServers(s.ClientURL()),
+				requeue.NATSSubject(subject),
+				requeue.RepublisherOptions(
+					republisher.RepublishInterval(100 * time.Millisecond),
+				),
+				requeue.PayloadKeyring(k),
+			}
+			if tt.encrypted {
+				opts = append(opts, requeue.RepublishEncrypted())
+			}
+			rc, err := requeue.Connect(opts...)
+			if err != nil {
+				t.Fatalf("Error on requeue connect: %v", err)
+			}
+			t.Cleanup(func() {
+				rc.Close()
+			})
+
+			nc, err := nats.Connect(s.ClientURL())
+			assert.NoError(t, err)
+			t.Cleanup(func() {
+				nc.Close()
+			})
+
+			originalSubject := nats.NewInbox()
+			republished := make(chan *nats.Msg, 1)
+			_, err = nc.Subscribe(originalSubject, func(msg *nats.Msg) {
+				_ = msg.Respond(nil)
+				select {
+				case republished <- msg:
+				default:
+				}
+			})
+			assert.NoError(t, err)
+
+			payload := buildPayload(0, originalSubject)
+			_, err = nc.Request(subject, payload.Bytes(), 5*time.Second)
+			assert.NoError(t, err)
+
+			select {
+			case msg := <-republished:
+				data := msg.Data
+				if tt.encrypted {
+					keyID := msg.Header.Get(protocol.KeyIDHeader)
+					assert.Equal(t, protocol.DefaultQueueName+"-1", keyID)
+					assert.NotEqual(t, payload.OriginalPayload, data)
+					data, err = k[keyID].Decrypt(data)
+					assert.NoError(t, err)
+				}
+				assert.Equal(t, payload.OriginalPayload, data)
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for the message to be republished")
+			}
+		})
+	}
+}
+
+func Test_RequeueEgressNATS(t *testing.T) {
+	ingest := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		ingest.Shutdown()
+	})
+	egress := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		egress.Shutdown()
+	})
+
+	subject := nats.NewInbox()
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(ingest.ClientURL()),
+		requeue.NATSSubject(subject),
+		requeue.EgressNATSServers(egress.ClientURL()),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	producer, err := nats.Connect(ingest.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		producer.Close()
+	})
+	consumer, err := nats.Connect(egress.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		consumer.Close()
+	})
+
+	// The message is ingested from one cluster and republished on the other.
+	originalSubject := nats.NewInbox()
+	republished := make(chan *nats.Msg, 1)
+	_, err = consumer.Subscribe(originalSubject, func(msg *nats.Msg) {
+		_ = msg.Respond(nil)
+		select {
+		case republished <- msg:
+		default:
+		}
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, consumer.Flush())
+
+	payload := buildPayload(0, originalSubject)
+	_, err = producer.Request(subject, payload.Bytes(), 5*time.Second)
+	assert.NoError(t, err)
+
+	select {
+	case msg := <-republished:
+		assert.Equal(t, payload.OriginalPayload, msg.Data)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the message to be republished")
+	}
+}
+
+func Test_RequeueBridge(t *testing.T) {
+	west := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		west.Shutdown()
+	})
+	east := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		east.Shutdown()
+	})
+
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(west.ClientURL()),
+		requeue.EgressNATSServers(east.ClientURL()),
+		requeue.Bridge("orders.>", map[string]string{"orders.>": "west.orders.>"}),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	producer, err := nats.Connect(west.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		producer.Close()
+	})
+	consumer, err := nats.Connect(east.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		consumer.Close()
+	})
+
+	forwarded := make(chan *nats.Msg, 1)
+	_, err = consumer.Subscribe("west.orders.>", func(msg *nats.Msg) {
+		_ = msg.Respond(nil)
+		select {
+		case forwarded <- msg:
+		default:
+		}
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, consumer.Flush())
+
+	// A plain message is persisted and forwarded with its subject translated.
+	_, err = producer.Request("orders.created", []byte("order"), 5*time.Second)
+	assert.NoError(t, err)
+
+	select {
+	case msg := <-forwarded:
+		assert.Equal(t, "west.orders.created", msg.Subject)
+		assert.Equal(t, "order", string(msg.Data))
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the message to be forwarded")
+	}
+}
+
+func Test_RequeueDrain(t *testing.T) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(s.ClientURL()),
+		// Long enough that only the drain can commit the messages in time.
+		requeue.BatchMaxWait(time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	producer, err := nats.Connect(s.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		producer.Close()
+	})
+
+	const numMessages = 10
+	acks := make(chan *nats.Msg, numMessages)
+	inbox := nats.NewInbox()
+	_, err = producer.ChanSubscribe(inbox, acks)
+	assert.NoError(t, err)
+	for i := 0; i < numMessages; i++ {
+		msg := buildPayload(i, "foo.bar")
+		assert.NoError(t, producer.PublishRequest("requeue.foo", inbox, msg.Bytes()))
+	}
+	assert.NoError(t, producer.Flush())
+	// Let the messages reach the subscription before it's drained.
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NoError(t, rc.Drain(ctx))
+
+	select {
+	case <-rc.HasBeenClosed():
+	default:
+		t.Fatal("connection is not closed after draining")
+	}
+	// Every message taken before the drain was committed and acked.
+	for i := 0; i < numMessages; i++ {
+		select {
+		case ack := <-acks:
+			assert.Empty(t, ack.Data)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for ack %d", i)
+		}
+	}
+}
+
+func Test_RequeueMembership(t *testing.T) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(s.ClientURL()),
+		requeue.InstanceID("membership"),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	admin, err := nats.Connect(s.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		admin.Close()
+	})
+	request := func(action protocol.MembershipAction) protocol.MembershipReply {
+		data, err := protocol.MembershipRequest{Action: action}.MarshalBinary()
+		assert.NoError(t, err)
+		msg, err := admin.Request(protocol.MembershipSubject("membership"), data, 5*time.Second)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		var reply protocol.MembershipReply
+		assert.NoError(t, reply.UnmarshalBinary(msg.Data))
+		return reply
+	}
+
+	assert.True(t, request(protocol.MembershipStatus).Member)
+
+	reply := request(protocol.MembershipLeave)
+	assert.Empty(t, reply.Error)
+	assert.False(t, reply.Member)
+	assert.False(t, rc.Readiness().Ready)
+
+	// Nothing is taken from the queue group while the instance is out of it.
+	msg := buildPayload(0, "foo.bar")
+	_, err = admin.Request("requeue.foo", msg.Bytes(), 200*time.Millisecond)
+	assert.Equal(t, nats.ErrTimeout, err)
+
+	reply = request(protocol.MembershipJoin)
+	assert.Empty(t, reply.Error)
+	assert.True(t, reply.Member)
+	ack, err := admin.Request("requeue.foo", msg.Bytes(), 5*time.Second)
+	if assert.NoError(t, err) {
+		assert.Empty(t, ack.Data)
+	}
+
+	assert.NotEmpty(t, request("bogus").Error)
+}
+
+func Test_RequeueAckOnDelivery(t *testing.T) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	rc, err := requeue.Connect(
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(s.ClientURL()),
+		requeue.IngestAckMode(requeue.AckOnDelivery),
+	)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	nc, err := nats.Connect(s.ClientURL())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		nc.Close()
+	})
+
+	delivered := make(chan struct{}, 1)
+	_, err = nc.Subscribe("foo.bar", func(msg *nats.Msg) {
+		assert.NoError(t, msg.Respond(nil))
+		delivered <- struct{}{}
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, nc.Flush())
+
+	// The producer is only acked once the message has been delivered.
+	msg := buildPayload(0, "foo.bar")
+	ack, err := nc.Request("requeue.foo", msg.Bytes(), 10*time.Second)
+	if assert.NoError(t, err) {
+		assert.Empty(t, ack.Data)
+	}
+	select {
+	case <-delivered:
+	default:
+		t.Fatal("acked before the message was delivered")
+	}
+}
+
+func Test_RequeueAdaptiveFlowControl(t *testing.T) {
+	_, err := requeue.Connect(requeue.AdaptiveFlowControl(0))
+	assert.Error(t, err)
+
+	_, nc, subject := connectRepublishing(t, requeue.AdaptiveFlowControl(time.Second))
+
+	originalSubject := nats.NewInbox()
+	var republished int32
+	_, err = nc.Subscribe(originalSubject, func(msg *nats.Msg) {
+		atomic.AddInt32(&republished, 1)
+		_ = msg.Respond(nil)
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		payload := buildPayload(i, originalSubject)
+		_, err = nc.Request(subject, payload.Bytes(), 5*time.Second)
+		assert.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&republished) == 3
+	}, 10*time.Second, 50*time.Millisecond)
+}
+
+func Test_RequeueQueueAckTimeout(t *testing.T) {
+	_, err := requeue.Connect(requeue.QueueAckTimeout("", time.Second))
+	assert.Error(t, err)
+
+	rc, nc, subject := connectRepublishing(t,
+		requeue.QueueAckTimeout(protocol.DefaultQueueName, 100*time.Millisecond),
+		requeue.RetainTerminalRecords(time.Hour),
+	)
+
+	// The responder is slower than the queue allows, so the message runs out
+	// of retries long before the default ack timeout.
+	originalSubject := nats.NewInbox()
+	_, err = nc.Subscribe(originalSubject, func(msg *nats.Msg) {
+		time.Sleep(500 * time.Millisecond)
+		_ = msg.Respond(nil)
+	})
+	assert.NoError(t, err)
+
+	payload := buildPayload(0, originalSubject)
+	_, err = nc.Request(subject, payload.Bytes(), 5*time.Second)
+	assert.NoError(t, err)
+
+	var record protocol.TerminalRecord
+	assert.Eventually(t, func() bool {
+		found := false
+		assert.NoError(t, rc.TerminalRecords(protocol.DefaultQueueName, func(r protocol.TerminalRecord) bool {
+			record, found = r, true
+			return false
+		}))
+		return found
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, protocol.TerminalStateDeadLettered, record.State)
+	assert.Equal(t, nats.ErrTimeout.Error(), record.Error)
+}
+
+func Test_RequeueMaxRedeliveries(t *testing.T) {
+	_, err := requeue.Connect(requeue.QueueMaxRedeliveries("", 1))
+	assert.Error(t, err)
+
+	rc, nc, subject := connectRepublishing(t,
+		requeue.MaxRedeliveries(100),
+		requeue.QueueMaxRedeliveries(protocol.DefaultQueueName, 2),
+		requeue.QueueAckTimeout(protocol.DefaultQueueName, 100*time.Millisecond),
+		requeue.RetainTerminalRecords(time.Hour),
+	)
+
+	// Nobody acks the message so it's given up on once it reaches the limit
+	// of its queue, with retries to spare.
+	payload := buildPayload(0, nats.NewInbox())
+	payload.Retries = 10
+	_, err = nc.Request(subject, payload.Bytes(), 5*time.Second)
+	assert.NoError(t, err)
+
+	var record protocol.TerminalRecord
+	assert.Eventually(t, func() bool {
+		found := false
+		assert.NoError(t, rc.TerminalRecords(protocol.DefaultQueueName, func(r protocol.TerminalRecord) bool {
+			record, found = r, true
+			return false
+		}))
+		return found
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Equal(t, protocol.TerminalStateDeadLettered, record.State)
+	assert.Equal(t, protocol.TerminalReasonMaxRedeliveries, record.Reason)
+	assert.Equal(t, uint64(2), record.Attempts)
+}
+
+func Test_RequeueQueueMirrors(t *testing.T) {
+	_, err := requeue.Connect(requeue.QueueMirrors(protocol.DefaultQueueName, "orders.>"))
+	assert.Error(t, err)
+
+	mirror := nats.NewInbox()
+	_, nc, subject := connectRepublishing(t, requeue.QueueMirrors(protocol.DefaultQueueName, mirror))
+
+	originalSubject := nats.NewInbox()
+	republished := make(chan string, 2)
+	for _, subj := range []string{originalSubject, mirror} {
+		_, err = nc.Subscribe(subj, func(msg *nats.Msg) {
+			_ = msg.Respond(nil)
+			republished <- msg.Subject
+		})
+		assert.NoError(t, err)
+	}
+
+	payload := buildPayload(0, originalSubject)
+	_, err = nc.Request(subject, payload.Bytes(), 5*time.Second)
+	assert.NoError(t, err)
+
+	var got []string
+	for len(got) < 2 {
+		select {
+		case subj := <-republished:
+			got = append(got, subj)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for the message to be republished: %v", got)
+		}
+	}
+	assert.ElementsMatch(t, []string{originalSubject, mirror}, got)
+}
+
+func Test_RequeueRescanOnStart(t *testing.T) {
+	rc, nc, subject := connectRepublishing(t, requeue.DisableRepublish(), requeue.RescanOnStart())
+
+	originalSubject := nats.NewInbox()
+	republished := make(chan struct{}, 1)
+	_, err := nc.Subscribe(originalSubject, func(msg *nats.Msg) {
+		_ = msg.Respond(nil)
+		select {
+		case republished <- struct{}{}:
+		default:
+		}
+	})
+	assert.NoError(t, err)
+
+	payload := buildPayload(0, originalSubject)
+	_, err = nc.Request(subject, payload.Bytes(), 5*time.Second)
+	assert.NoError(t, err)
+
+	// Move the checkpoint past the message, as if it had been read already.
+	var q *queue.Queue
+	assert.Eventually(t, func() bool {
+		var ok bool
+		q, ok = rc.Manager().GetQueue(protocol.DefaultQueueName)
+		return ok
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.NoError(t, q.UpdateCheckpoint(queue.LastMessage(protocol.DefaultQueueName).Bytes()))
+
+	assert.NoError(t, rc.StartRepublishing())
+	select {
+	case <-republished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the message to be republished")
+	}
+}
+
 func buildPayload(i int, originalSubject string) protocol.RequeueMessage {
 	msg := protocol.DefaultRequeueMessage()
 	msg.Retries = 1
@@ -212,3 +1304,38 @@ func buildPayload(i int, originalSubject string) protocol.RequeueMessage {
 	msg.OriginalPayload = []byte(fmt.Sprintf("my awesome payload %d", i))
 	return msg
 }
+
+// connectRepublishing connects requeue with the options to a new nats server,
+// republishing every 100ms, along with a client to produce and consume with.
+// The subject requeue ingests messages on is returned.
+func connectRepublishing(t *testing.T, options ...requeue.Option) (*requeue.Conn, *nats.Conn, string) {
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(func() {
+		s.Shutdown()
+	})
+
+	subject := nats.NewInbox()
+	rc, err := requeue.Connect(append([]requeue.Option{
+		requeue.DataDir(setup(t)),
+		requeue.NATSServers(s.ClientURL()),
+		requeue.NATSSubject(subject),
+		requeue.RepublisherOptions(
+			republisher.RepublishInterval(100 * time.Millisecond),
+		),
+	}, options...)...)
+	if err != nil {
+		t.Fatalf("Error on requeue connect: %v", err)
+	}
+	t.Cleanup(func() {
+		rc.Close()
+	})
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error on nats connect: %v", err)
+	}
+	t.Cleanup(func() {
+		nc.Close()
+	})
+	return rc, nc, subject
+}
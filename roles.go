@@ -0,0 +1,56 @@
+package requeue
+
+import "fmt"
+
+// Role is the part an instance plays in a deployment. Separating the roles
+// keeps the write pressure of ingest apart from the read pressure of draining
+// the queues on large deployments.
+type Role int
+
+const (
+	// RoleAll both ingests and republishes messages. This is the default.
+	RoleAll Role = iota
+
+	// RoleIngest persists messages but never republishes them. It needs a
+	// HandoffInterval so the stores it writes are handed off to the
+	// RoleRepublish instances sharing its data dir to be drained.
+	RoleIngest
+
+	// RoleRepublish never subscribes for messages and only drains the queues
+	// in its store. Combined with the reaper, the stores handed off by ingest
+	// instances in the same data dir, and those left behind by ones that
+	// stopped, are merged in and drained too.
+	RoleRepublish
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleAll:
+		return "all"
+	case RoleIngest:
+		return "ingest"
+	case RoleRepublish:
+		return "republish"
+	default:
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+}
+
+// InstanceRole sets the role of the instance. It overrides DisableIngest and
+// DisableRepublish given before it.
+func InstanceRole(r Role) Option {
+	return func(o *Options) error {
+		switch r {
+		case RoleAll:
+			o.ingestDisabled, o.republishDisabled = false, false
+		case RoleIngest:
+			o.ingestDisabled, o.republishDisabled = false, true
+		case RoleRepublish:
+			o.ingestDisabled, o.republishDisabled = true, false
+		default:
+			return fmt.Errorf("unknown instance role: %s", r)
+		}
+		o.role = r
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+package requeue
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultSampleEvery is how many messages there are for every one that is
+// instrumented while sampling by default.
+const DefaultSampleEvery = 100
+
+// samplingWindow is how long the ingest rate is measured over before sampling
+// is switched on or off.
+const samplingWindow = time.Second
+
+// SampleAboveRate switches the per-message instrumentation of ingest, i.e.,
+// the debug logs of every message and the per-message handlers such as
+// BadgerWriteMsgErr, to sampled mode while more than rate messages a second
+// are being ingested, so only one in every n of them is logged or handed to
+// the handlers. This keeps the instrumentation from holding up ingest under
+// load. Sampling is switched off again once the rate drops back. Whether it's
+// active, and how much was skipped, is included in the stats so observability
+// is reduced rather than silently lost.
+func SampleAboveRate(rate float64, n int) Option {
+	return func(o *Options) error {
+		if rate <= 0 {
+			return fmt.Errorf("sample above rate: rate must be positive: %v", rate)
+		}
+		if n < 1 {
+			return fmt.Errorf("sample above rate: must sample at least 1 in every %d messages", n)
+		}
+		o.sampleAboveRate = rate
+		o.sampleEvery = n
+		return nil
+	}
+}
+
+// sampler decides which messages are instrumented from the ingest rate. A nil
+// sampler instruments every message.
+type sampler struct {
+	threshold float64
+	every     uint64
+
+	// The start of the current window in Unix nanoseconds and the number of
+	// messages ingested in it. Accessed atomically.
+	windowStart int64
+	windowCount int64
+
+	// The ingest rate over the last window as float64 bits. Accessed
+	// atomically.
+	rate uint64
+	// Set to 1 while sampling. Accessed atomically.
+	active int32
+
+	// Accessed atomically.
+	calls   uint64
+	skipped int64
+}
+
+func newSampler(threshold float64, every int, now time.Time) *sampler {
+	return &sampler{
+		threshold:   threshold,
+		every:       uint64(every),
+		windowStart: now.UnixNano(),
+	}
+}
+
+// observe counts a message ingested at now. Sampling is switched on or off
+// when a window ends.
+func (s *sampler) observe(now time.Time) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.windowCount, 1)
+
+	start := atomic.LoadInt64(&s.windowStart)
+	elapsed := now.UnixNano() - start
+	if elapsed < int64(samplingWindow) {
+		return
+	}
+	// Only one caller gets to end the window.
+	if !atomic.CompareAndSwapInt64(&s.windowStart, start, now.UnixNano()) {
+		return
+	}
+	rate := float64(atomic.SwapInt64(&s.windowCount, 0)) / time.Duration(elapsed).Seconds()
+	atomic.StoreUint64(&s.rate, math.Float64bits(rate))
+
+	var active int32
+	if rate > s.threshold {
+		active = 1
+	}
+	if old := atomic.SwapInt32(&s.active, active); old != active {
+		log.Info().
+			Float64("ingest_rate", rate).
+			Float64("threshold", s.threshold).
+			Bool("active", active == 1).
+			Msg("switched sampling of the per-message instrumentation")
+	}
+}
+
+// sample returns true if the instrumentation should be done, which is always
+// unless sampling is active.
+func (s *sampler) sample() bool {
+	if s == nil || atomic.LoadInt32(&s.active) == 0 {
+		return true
+	}
+	if atomic.AddUint64(&s.calls, 1)%s.every == 0 {
+		return true
+	}
+	atomic.AddInt64(&s.skipped, 1)
+	return false
+}
+
+// state returns the state of the sampling for the stats.
+func (s *sampler) state() *protocol.Sampling {
+	if s == nil {
+		return nil
+	}
+	return &protocol.Sampling{
+		Active:     atomic.LoadInt32(&s.active) == 1,
+		IngestRate: math.Float64frombits(atomic.LoadUint64(&s.rate)),
+		Threshold:  s.threshold,
+		Every:      int64(s.every),
+		Skipped:    atomic.LoadInt64(&s.skipped),
+	}
+}
+
+// Sampling returns the state of the sampling of the per-message
+// instrumentation, or nil if it isn't configured.
+func (c *Conn) Sampling() *protocol.Sampling {
+	return c.sampler.state()
+}
+
+// debugSampled is log.Debug for the per-message logs. Its logs are sampled
+// along with the rest of the per-message instrumentation.
+func (c *Conn) debugSampled() *zerolog.Event {
+	e := log.Debug()
+	if !e.Enabled() || c.sampler.sample() {
+		return e
+	}
+	return e.Discard()
+}
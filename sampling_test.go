@@ -0,0 +1,46 @@
+package requeue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampler(t *testing.T) {
+	// Without a sampler everything is instrumented.
+	var none *sampler
+	none.observe(time.Now())
+	assert.True(t, none.sample())
+	assert.Nil(t, none.state())
+
+	start := time.Unix(1600000000, 0)
+	s := newSampler(100, 10, start)
+	assert.True(t, s.sample())
+
+	// 200 messages a second switches sampling on once the window ends.
+	for i := 0; i < 200; i++ {
+		s.observe(start.Add(time.Duration(i) * 5 * time.Millisecond))
+	}
+	s.observe(start.Add(time.Second))
+	state := s.state()
+	assert.True(t, state.Active)
+	assert.InDelta(t, 201, state.IngestRate, 1)
+	assert.Equal(t, float64(100), state.Threshold)
+	assert.Equal(t, int64(10), state.Every)
+
+	var sampled int
+	for i := 0; i < 100; i++ {
+		if s.sample() {
+			sampled++
+		}
+	}
+	assert.Equal(t, 10, sampled)
+	assert.Equal(t, int64(90), s.state().Skipped)
+
+	// Sampling is switched off once the rate drops back.
+	s.observe(start.Add(2 * time.Second))
+	assert.False(t, s.state().Active)
+	assert.True(t, s.sample())
+	assert.Equal(t, int64(90), s.state().Skipped)
+}
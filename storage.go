@@ -0,0 +1,39 @@
+package requeue
+
+import (
+	"fmt"
+)
+
+// StorageBackend is where the messages of an instance are stored.
+type StorageBackend int
+
+const (
+	// StorageDisk stores messages in an instance of the store in the
+	// DataDir. It's the default.
+	StorageDisk StorageBackend = iota
+
+	// StorageMemory stores messages in memory, so there's no DataDir to
+	// create or clean up, e.g., for tests or to buffer retries that can be
+	// lost. Every message is lost when the instance stops, and the instances
+	// of other data dirs are never reaped or taken over.
+	StorageMemory
+)
+
+func (b StorageBackend) String() string {
+	switch b {
+	case StorageDisk:
+		return "disk"
+	case StorageMemory:
+		return "memory"
+	default:
+		return fmt.Sprintf("StorageBackend(%d)", int(b))
+	}
+}
+
+// Storage sets where messages are stored. It's StorageDisk by default.
+func Storage(b StorageBackend) Option {
+	return func(o *Options) error {
+		o.storage = b
+		return nil
+	}
+}
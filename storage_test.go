@@ -0,0 +1,29 @@
+package requeue
+
+import (
+	"testing"
+
+	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageMemory(t *testing.T) {
+	o := GetDefaultOptions()
+	assert.NoError(t, Storage(StorageMemory)(&o))
+	assert.NoError(t, o.Validate())
+	c := NewConn(o)
+	defer c.Close()
+
+	// Nothing is created on disk since there is no data dir.
+	assert.NoError(t, c.initBadger())
+	assert.True(t, badgerInternal.InMemory(c.badgerOpenOptions()...))
+	assert.NoError(t, c.preflight())
+	assert.Equal(t, float64(1), c.checkReadiness().FreeDisk)
+
+	// Messages can still be stored.
+	assert.NoError(t, c.initQueueManager())
+	q, err := c.qManager.UpsertQueueState(queue.NewQueueKeyForState("orders", ""))
+	assert.NoError(t, err)
+	assert.NotNil(t, q)
+}
@@ -0,0 +1,77 @@
+package requeue
+
+import (
+	"fmt"
+
+	"github.com/nickpoorman/nats-requeue/internal/statspub"
+	"github.com/nickpoorman/nats-requeue/protocol"
+)
+
+// AllowSubjects only accepts messages whose original subject matches one of
+// the patterns, which may contain wildcards. Other messages are NAKed with
+// protocol.NakReasonSubjectDenied. Every subject is allowed by default.
+func AllowSubjects(patterns ...string) Option {
+	return func(o *Options) error {
+		if err := validateSubjects(patterns); err != nil {
+			return fmt.Errorf("allow subjects: %w", err)
+		}
+		o.allowSubjects = append(o.allowSubjects, patterns...)
+		return nil
+	}
+}
+
+// DenySubjects NAKs messages whose original subject matches one of the
+// patterns, which may contain wildcards, with protocol.NakReasonSubjectDenied,
+// even if they are allowed. It's meant to stop a misrouted producer from
+// filling the queues while its routing is fixed.
+func DenySubjects(patterns ...string) Option {
+	return func(o *Options) error {
+		if err := validateSubjects(patterns); err != nil {
+			return fmt.Errorf("deny subjects: %w", err)
+		}
+		o.denySubjects = append(o.denySubjects, patterns...)
+		return nil
+	}
+}
+
+func validateSubjects(patterns []string) error {
+	for _, p := range patterns {
+		if err := protocol.ValidateSubject(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subjectAllowed returns true if messages with the original subject may be
+// ingested.
+func (c *Conn) subjectAllowed(subject string) bool {
+	for _, p := range c.Opts.denySubjects {
+		if protocol.MatchSubject(p, subject) {
+			return false
+		}
+	}
+	if len(c.Opts.allowSubjects) == 0 {
+		return true
+	}
+	for _, p := range c.Opts.allowSubjects {
+		if protocol.MatchSubject(p, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedSubjects returns the system subjects of the instance, with its stats
+// subjects as they're configured.
+func (o Options) reservedSubjects() protocol.ReservedSubjects {
+	if !o.statsEnabled {
+		return protocol.DefaultReservedSubjects
+	}
+	so, err := statspub.ApplyOptions(o.statsOpts...)
+	if err != nil {
+		// The stats publisher fails to start with the same error.
+		return protocol.DefaultReservedSubjects
+	}
+	return protocol.NewReservedSubjects(so.Subjects())
+}
@@ -0,0 +1,57 @@
+package requeue
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/internal/statspub"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubjectAllowed(t *testing.T) {
+	o := GetDefaultOptions()
+	c := NewConn(o)
+	assert.True(t, c.subjectAllowed("anything"))
+
+	assert.NoError(t, AllowSubjects("orders.>")(&o))
+	assert.NoError(t, DenySubjects("orders.test.*")(&o))
+	c = NewConn(o)
+	assert.True(t, c.subjectAllowed("orders.created"))
+	assert.False(t, c.subjectAllowed("payments.created"))
+	// Denied even though it's allowed.
+	assert.False(t, c.subjectAllowed("orders.test.created"))
+}
+
+func TestReceiveReservedSubject(t *testing.T) {
+	c := NewConn(GetDefaultOptions())
+
+	// Our own events are ignored.
+	c.receiveIngressMessage(&nats.Msg{Subject: protocol.EventsSubjectPrefix + "expired"})
+	assert.Empty(t, c.counters.Rejected())
+
+	// A producer waiting on a reply is told why its message wasn't persisted.
+	c.receiveIngressMessage(&nats.Msg{Subject: protocol.ControlSubjectPrefix + "x", Reply: "inbox.1"})
+	assert.Equal(t, int64(1), c.counters.Rejected()[string(protocol.NakReasonReservedSubject)])
+	assert.Len(t, c.natsMsgCh, 0)
+}
+
+func TestReservedSubjects(t *testing.T) {
+	c := NewConn(GetDefaultOptions())
+	assert.True(t, c.reserved.Contains(protocol.StatsSubjectPrefix+"instance.a"))
+
+	// The stats subjects are reserved as they're configured, and the default
+	// prefix is no longer reserved.
+	o := GetDefaultOptions()
+	assert.NoError(t, EnableStats(
+		statspub.Subject("requeue.metrics"),
+		statspub.SubjectPrefix("requeue.prod.stats."),
+	)(&o))
+	c = NewConn(o)
+	assert.True(t, c.reserved.Contains("requeue.metrics"))
+	assert.True(t, c.reserved.Contains("requeue.metrics.json"))
+	assert.True(t, c.reserved.Contains("requeue.prod.stats.instance.a"))
+	assert.True(t, c.reserved.Contains(protocol.EventsSubjectPrefix+"expired"))
+	assert.False(t, c.reserved.Contains(protocol.StatsSubjectPrefix+"instance.a"))
+	assert.False(t, c.reserved.Contains("requeue.metrics.daily"))
+}
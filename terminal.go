@@ -0,0 +1,116 @@
+package requeue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// RetainTerminalRecords keeps a compact protocol.TerminalRecord of every
+// message once it leaves its queue, i.e., when it's delivered, expires, or is
+// dead lettered, for the retention. The records can be looked up by message
+// key with Conn.TerminalRecord to find out what happened to a message after
+// the fact. Zero, the default, keeps no records.
+func RetainTerminalRecords(retention time.Duration) Option {
+	return func(o *Options) error {
+		if retention < 0 {
+			return fmt.Errorf("terminal record retention cannot be negative: %s", retention)
+		}
+		o.terminalRetention = retention
+		return nil
+	}
+}
+
+// recordTerminal stores the terminal record of a message if they are being
+// retained.
+func (c *Conn) recordTerminal(r protocol.TerminalRecord) {
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	c.recordTerminalIn(db, r)
+}
+
+// recordTerminalIn stores the terminal record of a message in db if they are
+// being retained. It doesn't acquire the lock on the connection.
+func (c *Conn) recordTerminalIn(db *badger.DB, r protocol.TerminalRecord) {
+	if c.Opts.terminalRetention == 0 {
+		return
+	}
+	k, err := key.Parse(r.Key)
+	if err != nil {
+		log.Err(err).Msg("problem parsing the key of a terminal record")
+		return
+	}
+	data, err := r.MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("problem marshaling terminal record")
+		return
+	}
+	if err := queue.PutTerminalRecord(db, r.Queue, k, data, c.Opts.terminalRetention); err != nil {
+		log.Err(err).
+			Str("queue", r.Queue).
+			Str("key", r.Key).
+			Msg("problem storing terminal record")
+	}
+}
+
+// TerminalRecord returns what happened to the message with the key, as given
+// in its protocol.ExpiredMessage or protocol.TerminalRecord, once it left the
+// queue. False is returned if there's no record, e.g., because the message is
+// still in the queue or the record is past its retention.
+func (c *Conn) TerminalRecord(queueName, messageKey string) (protocol.TerminalRecord, bool, error) {
+	var r protocol.TerminalRecord
+
+	k, err := key.Parse(messageKey)
+	if err != nil {
+		return r, false, fmt.Errorf("terminal record: %w", err)
+	}
+
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if db == nil {
+		return r, false, fmt.Errorf("terminal record: store is not open")
+	}
+
+	data, ok, err := queue.GetTerminalRecord(db, queueName, k)
+	if err != nil || !ok {
+		return r, false, err
+	}
+	if err := r.UnmarshalBinary(data); err != nil {
+		return r, false, fmt.Errorf("terminal record: %w", err)
+	}
+	return r, true, nil
+}
+
+// TerminalRecords calls f, in message key order, with the terminal records
+// retained for the queue. If f returns false the iteration stops.
+func (c *Conn) TerminalRecords(queueName string, f func(protocol.TerminalRecord) bool) error {
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if db == nil {
+		return fmt.Errorf("terminal records: store is not open")
+	}
+
+	var decodeErr error
+	err := queue.RangeTerminalRecords(db, queueName, func(qi queue.QueueItem) bool {
+		r := protocol.TerminalRecord{}
+		if decodeErr = r.UnmarshalBinary(qi.V); decodeErr != nil {
+			return false
+		}
+		return f(r)
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return fmt.Errorf("terminal records: %w", err)
+	}
+	return nil
+}
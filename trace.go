@@ -0,0 +1,37 @@
+package requeue
+
+import (
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// TraceHeaders adds the trace and provenance headers in protocol, i.e., the W3C
+// traceparent, message id, first enqueue time, attempt number, and the id of
+// the instance, to every message that is republished. A traceparent the
+// message was ingested with is stored with it so every attempt continues that
+// trace. The NATS servers need to support headers.
+func TraceHeaders() Option {
+	return func(o *Options) error {
+		o.traceHeaders = true
+		return nil
+	}
+}
+
+// stampTraceParent returns the message data with the traceparent it was
+// ingested with when trace headers are enabled. Invalid ones are ignored
+// rather than continued.
+func (c *Conn) stampTraceParent(data []byte, msg *nats.Msg) []byte {
+	if !c.Opts.traceHeaders || msg.Header == nil {
+		return data
+	}
+	tp := msg.Header.Get(protocol.TraceParentHeader)
+	if tp == "" {
+		return data
+	}
+	if _, err := protocol.ParseTraceParent(tp); err != nil {
+		log.Debug().Err(err).Str("subject", msg.Subject).Msg("ignoring the traceparent of message")
+		return data
+	}
+	return protocol.SetTraceParent(data, tp)
+}
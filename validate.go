@@ -0,0 +1,133 @@
+package requeue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/flatbuf"
+	"github.com/nickpoorman/nats-requeue/internal/key"
+	"github.com/nickpoorman/nats-requeue/internal/queue"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// PayloadValidator validates the original payload of a message at ingest
+// against whatever the subject it will be republished to expects, e.g., a
+// JSON Schema or protobuf descriptor looked up by subject in a schema
+// registry.
+type PayloadValidator interface {
+	Validate(subject string, payload []byte) error
+}
+
+// PayloadValidatorFunc is an adapter to allow the use of an ordinary function
+// as a PayloadValidator.
+type PayloadValidatorFunc func(subject string, payload []byte) error
+
+func (f PayloadValidatorFunc) Validate(subject string, payload []byte) error {
+	return f(subject, payload)
+}
+
+// InvalidPayloadAction is what happens to a message that fails validation.
+type InvalidPayloadAction int
+
+const (
+	// InvalidPayloadNak rejects the message with a protocol.Nak.
+	InvalidPayloadNak InvalidPayloadAction = iota
+
+	// InvalidPayloadQuarantine stores the message, along with the validation
+	// error, apart from the queue so it is never republished and replies with
+	// a protocol.Nak. See Conn.QuarantinedMessages.
+	InvalidPayloadQuarantine
+)
+
+// ValidatePayloads runs the validator on the original payload of every message
+// at ingest. Messages that fail validation are handled according to action.
+func ValidatePayloads(v PayloadValidator, action InvalidPayloadAction) Option {
+	return func(o *Options) error {
+		if v == nil {
+			return fmt.Errorf("payload validator cannot be nil")
+		}
+		o.payloadValidator = v
+		o.invalidPayloadAction = action
+		return nil
+	}
+}
+
+// validatePayload returns false if the message failed validation and has been
+// dealt with.
+// data is the message as it will be persisted.
+func (c *Conn) validatePayload(msg *nats.Msg, data []byte, fb *flatbuf.RequeueMessage) bool {
+	v := c.Opts.payloadValidator
+	if v == nil {
+		return true
+	}
+	err := v.Validate(string(fb.OriginalSubject()), fb.OriginalPayloadBytes())
+	if err == nil {
+		return true
+	}
+
+	if c.Opts.invalidPayloadAction == InvalidPayloadQuarantine {
+		if qErr := c.quarantine(msg, data, fb, err); qErr != nil {
+			log.Err(qErr).Msg("problem quarantining message")
+			// Fall back to rejecting it outright.
+			c.nak(msg, protocol.NakReasonInvalidPayload, err.Error())
+			return false
+		}
+		c.nak(msg, protocol.NakReasonQuarantined, err.Error())
+		return false
+	}
+	c.nak(msg, protocol.NakReasonInvalidPayload, err.Error())
+	return false
+}
+
+func (c *Conn) quarantine(msg *nats.Msg, data []byte, fb *flatbuf.RequeueMessage, validationErr error) error {
+	now := time.Now()
+	record, err := protocol.QuarantinedMessage{
+		Subject: msg.Subject,
+		Reason:  protocol.NakReasonInvalidPayload,
+		Error:   validationErr.Error(),
+		Time:    now,
+		Message: data,
+	}.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	return queue.Quarantine(
+		db,
+		protocol.GetQueueName(fb),
+		key.New(now),
+		record,
+		time.Duration(fb.Ttl()),
+	)
+}
+
+// QuarantinedMessages calls f, in the order they were quarantined, with the
+// messages quarantined for the queue. If f returns false the iteration stops.
+func (c *Conn) QuarantinedMessages(queueName string, f func(protocol.QuarantinedMessage) bool) error {
+	c.mu.RLock()
+	db := c.badgerDB
+	c.mu.RUnlock()
+	if db == nil {
+		return fmt.Errorf("quarantined messages: store is not open")
+	}
+
+	var decodeErr error
+	err := queue.RangeQuarantine(db, queueName, func(qi queue.QueueItem) bool {
+		m := protocol.QuarantinedMessage{}
+		if decodeErr = m.UnmarshalBinary(qi.V); decodeErr != nil {
+			return false
+		}
+		return f(m)
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return fmt.Errorf("quarantined messages: %w", err)
+	}
+	return nil
+}
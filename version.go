@@ -0,0 +1,76 @@
+package requeue
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nickpoorman/nats-requeue/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// Version is the version of requeue. It's advertised to clients along with
+// the features of an instance so they can tell what they can rely on while a
+// fleet is being upgraded.
+const Version = "0.1.0"
+
+// Features returns the features this instance supports, which depend on its
+// options.
+func (c *Conn) Features() protocol.Features {
+	fs := protocol.Features{
+		protocol.FeatureAckTimeout,
+		protocol.FeatureDedupeKey,
+		protocol.FeatureBacklogReport,
+		protocol.FeatureErase,
+		protocol.FeatureExport,
+		protocol.FeatureStateReport,
+		protocol.FeatureRejections,
+		protocol.FeatureOperations,
+		protocol.FeatureReplay,
+	}
+	if !c.Opts.ingestDisabled {
+		fs = append(fs, protocol.FeatureMembership)
+	}
+	if c.Opts.receiptsEnabled {
+		fs = append(fs, protocol.FeatureReceipts)
+	}
+	if c.Opts.terminalRetention > 0 {
+		fs = append(fs, protocol.FeatureTerminalRecords)
+	}
+	if c.Opts.payloadKeyring != nil {
+		fs = append(fs, protocol.FeatureKeyRotation)
+	}
+	if c.Opts.deadLetterQueue {
+		fs = append(fs, protocol.FeatureDeadLetterQueue)
+	}
+	if c.ackBatcher != nil {
+		fs = append(fs, protocol.FeatureBatchAcks)
+	}
+	if c.Opts.queueGroupHeaders {
+		fs = append(fs, protocol.FeatureQueueGroupHeaders)
+	}
+	return fs
+}
+
+// Info returns the version and features of this instance.
+func (c *Conn) Info() protocol.InstanceInfo {
+	return protocol.InstanceInfo{
+		InstanceID:      c.instanceId,
+		Version:         Version,
+		Features:        c.Features(),
+		Revision:        c.Revision(),
+		ProtocolVersion: protocol.CurrentProtocolVersion,
+		Labels:          c.Opts.labels,
+		Time:            time.Now(),
+	}
+}
+
+func (c *Conn) handleInfoRequest(msg *nats.Msg) {
+	data, err := c.Info().MarshalBinary()
+	if err != nil {
+		log.Err(err).Msg("unable to marshal instance info")
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Err(err).Msg("unable to respond to info request")
+	}
+}
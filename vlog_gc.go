@@ -0,0 +1,70 @@
+package requeue
+
+import (
+	"fmt"
+	"time"
+
+	badgerInternal "github.com/nickpoorman/nats-requeue/internal/badger"
+	"github.com/nickpoorman/nats-requeue/internal/ticker"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultValueLogGCInterval is how often the value log is garbage
+	// collected by default.
+	DefaultValueLogGCInterval = 5 * time.Minute
+
+	// DefaultValueLogGCDiscardRatio is how much of a value log file must be
+	// discardable for it to be rewritten by default.
+	DefaultValueLogGCDiscardRatio = 0.5
+)
+
+// ValueLogGC sets how often the value log of the store is garbage collected,
+// and how much of a value log file must be discardable, between 0 and 1, for it
+// to be rewritten. The value log only grows otherwise, even as messages are
+// republished and deleted. Lower ratios reclaim more space at the cost of
+// rewriting more. A zero interval disables it.
+func ValueLogGC(interval time.Duration, discardRatio float64) Option {
+	return func(o *Options) error {
+		if interval < 0 {
+			return fmt.Errorf("value log gc interval cannot be negative: %s", interval)
+		}
+		if discardRatio <= 0 || discardRatio >= 1 {
+			return fmt.Errorf("value log gc discard ratio must be between 0 and 1: %v", discardRatio)
+		}
+		o.vlogGCInterval = interval
+		o.vlogGCDiscardRatio = discardRatio
+		return nil
+	}
+}
+
+// initValueLogGC starts garbage collecting the value log, unless the store is
+// in memory where there is none.
+func (c *Conn) initValueLogGC() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Opts.vlogGCInterval == 0 || badgerInternal.InMemory(c.badgerOpenOptions()...) {
+		return nil
+	}
+	gc := badgerInternal.NewValueLogGC(c.badgerDB, c.instanceDir, c.Opts.vlogGCDiscardRatio)
+	c.vlogGC = gc
+
+	c.closers.vlogGC.AddRunning(1)
+	go func() {
+		defer c.closers.vlogGC.Done()
+		t := ticker.New(c.Opts.vlogGCInterval)
+		go func() {
+			<-c.closers.vlogGC.HasBeenClosed()
+			t.Stop()
+		}()
+		t.Loop(func() bool {
+			if n := gc.Run(); n > 0 {
+				log.Debug().Msgf("rewrote %d value log files", n)
+			}
+			return true
+		})
+	}()
+
+	return nil
+}